@@ -0,0 +1,131 @@
+/*
+Copyright 2018 Portworx
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+var (
+	file     = flag.String("file", "", "Metrics log file written by storageops.MetricsLog")
+	since    = flag.Duration("since", 24*time.Hour, "Only show records of this last duration")
+	provider = flag.String("provider", "", "Only show records of this provider")
+	op       = flag.String("op", "", "Only show records of this operation or event type")
+	summary  = flag.Bool("summary", false, "Show the count, errors and latency percentiles "+
+		"per operation instead of the records")
+	export = flag.Bool("json", false, "Export the records as JSON lines")
+)
+
+func main() {
+	flag.Parse()
+
+	if len(*file) == 0 {
+		fmt.Fprintln(os.Stderr, "Must supply a metrics log -file")
+		os.Exit(1)
+	}
+	if _, err := os.Stat(*file); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to open metrics log: %v\n", err)
+		os.Exit(1)
+	}
+	log, err := storageops.OpenMetricsLog(*file, 0, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to open metrics log: %v\n", err)
+		os.Exit(1)
+	}
+	defer log.Close()
+
+	all, err := log.Records(time.Now().Add(-*since))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to read metrics log: %v\n", err)
+		os.Exit(1)
+	}
+	var records []*storageops.MetricsRecord
+	for _, r := range all {
+		if (len(*provider) == 0 || r.Provider == *provider) && (len(*op) == 0 || r.Operation == *op) {
+			records = append(records, r)
+		}
+	}
+
+	switch {
+	case *export:
+		enc := json.NewEncoder(os.Stdout)
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to export record: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	case *summary:
+		printSummary(records)
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tKIND\tPROVIDER\tOPERATION\tVOLUME\tDURATION\tERROR")
+		for _, r := range records {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Time.Format(time.RFC3339),
+				r.Kind, r.Provider, r.Operation, r.VolumeID, r.Duration, r.Err)
+		}
+		w.Flush()
+	}
+}
+
+func printSummary(records []*storageops.MetricsRecord) {
+	type key struct{ provider, op string }
+	durations := make(map[key][]time.Duration)
+	errors := make(map[key]int)
+	var keys []key
+	for _, r := range records {
+		if r.Kind != storageops.MetricsRecordOperation {
+			continue
+		}
+		k := key{r.Provider, r.Operation}
+		if _, ok := durations[k]; !ok {
+			keys = append(keys, k)
+		}
+		durations[k] = append(durations[k], r.Duration)
+		if len(r.Err) > 0 {
+			errors[k]++
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].provider != keys[j].provider {
+			return keys[i].provider < keys[j].provider
+		}
+		return keys[i].op < keys[j].op
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "PROVIDER\tOPERATION\tCOUNT\tERRORS\tP50\tP99\tMAX")
+	for _, k := range keys {
+		d := durations[k]
+		sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\t%s\n", k.provider, k.op, len(d), errors[k],
+			percentile(d, 0.5), percentile(d, 0.99), d[len(d)-1])
+	}
+	w.Flush()
+}
+
+// percentile returns the p-th percentile of the sorted durations
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	return sorted[int(p*float64(len(sorted)-1))]
+}
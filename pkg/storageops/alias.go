@@ -0,0 +1,284 @@
+package storageops
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/portworx/kvdb"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// AliasLabel is the label/tag key under which a volume's alias is stored
+	AliasLabel = "openstorage-alias"
+	// AliasPrefix is the prefix of aliases given in place of volume IDs, e.g.
+	// alias:db-data. Aliases have their own namespace so they can never
+	// shadow the ID of another volume.
+	AliasPrefix = "alias:"
+	// aliasKeyPrefix is the kvdb prefix of the alias to volume ID index
+	aliasKeyPrefix = "storageops/aliases/"
+)
+
+// aliasRegex restricts aliases to names that are valid labels on all providers
+var aliasRegex = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,62}$`)
+
+// AliasOps is a storage operations driver that accepts human-friendly
+// aliases anywhere a volume ID is accepted
+type AliasOps interface {
+	Ops
+	// SetAlias assigns the given unique alias to the given volume, replacing
+	// its previous alias. The alias is given with or without AliasPrefix.
+	SetAlias(volumeID, alias string) error
+	// RemoveAlias removes the given alias
+	RemoveAlias(alias string) error
+	// Resolve returns the volume ID of the given AliasPrefix prefixed alias.
+	// Anything else is a volume ID and returned as is.
+	Resolve(idOrAlias string) (string, error)
+}
+
+type aliasOps struct {
+	Ops
+	kv kvdb.Kvdb
+}
+
+// NewAliasOps returns AliasOps that store aliases as a tag on the volume and
+// in a kvdb index for lookups
+func NewAliasOps(ops Ops, kv kvdb.Kvdb) AliasOps {
+	return &aliasOps{
+		Ops: ops,
+		kv:  kv,
+	}
+}
+
+func (a *aliasOps) aliasKey(alias string) string {
+	return aliasKeyPrefix + a.Ops.Name() + "/" + alias
+}
+
+func (a *aliasOps) SetAlias(volumeID, alias string) error {
+	alias = strings.TrimPrefix(alias, AliasPrefix)
+	if !aliasRegex.MatchString(alias) {
+		return NewStorageError(ErrVolInval,
+			fmt.Sprintf("invalid alias %q: must start with a lowercase letter and "+
+				"contain only lowercase letters, digits, '-' and '_'", alias), "")
+	}
+	volumeID, err := a.Resolve(volumeID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := a.kv.Create(a.aliasKey(alias), volumeID, 0); err == kvdb.ErrExist {
+		existing, rerr := a.lookup(alias)
+		if rerr == nil && existing == volumeID {
+			return nil
+		}
+		return NewStorageError(ErrVolInval,
+			fmt.Sprintf("alias %q is already assigned to volume %s", alias, existing), "")
+	} else if err != nil {
+		return err
+	}
+
+	if err := a.Ops.ApplyTags(volumeID, map[string]string{AliasLabel: alias}); err != nil &&
+		!errors.Is(err, ErrNotSupported) {
+		if _, derr := a.kv.Delete(a.aliasKey(alias)); derr != nil {
+			logrus.Warnf("failed to rollback alias %v for volume %v: %v", alias, volumeID, derr)
+		}
+		return err
+	}
+	// The tag was replaced, drop the index entry of the previous alias
+	a.deleteAliasesOf(volumeID, a.aliasKey(alias))
+	return nil
+}
+
+func (a *aliasOps) RemoveAlias(alias string) error {
+	alias = strings.TrimPrefix(alias, AliasPrefix)
+	volumeID, err := a.lookup(alias)
+	if err != nil {
+		return err
+	}
+
+	if err := a.Ops.RemoveTags(volumeID, map[string]string{AliasLabel: alias}); err != nil &&
+		!errors.Is(err, ErrNotSupported) {
+		return err
+	}
+
+	_, err = a.kv.Delete(a.aliasKey(alias))
+	return err
+}
+
+func (a *aliasOps) Resolve(idOrAlias string) (string, error) {
+	if !strings.HasPrefix(idOrAlias, AliasPrefix) {
+		return idOrAlias, nil
+	}
+	return a.lookup(strings.TrimPrefix(idOrAlias, AliasPrefix))
+}
+
+func (a *aliasOps) lookup(alias string) (string, error) {
+	kvp, err := a.kv.Get(a.aliasKey(alias))
+	if err == kvdb.ErrNotFound {
+		return "", NewStorageError(ErrVolNotFound,
+			fmt.Sprintf("alias %q not found", alias), "")
+	} else if err != nil {
+		return "", err
+	}
+	return string(kvp.Value), nil
+}
+
+func (a *aliasOps) resolveAll(ids []*string) ([]*string, error) {
+	if ids == nil {
+		return nil, nil
+	}
+	resolved := make([]*string, len(ids))
+	for i, id := range ids {
+		volumeID, err := a.Resolve(*id)
+		if err != nil {
+			return nil, err
+		}
+		resolved[i] = &volumeID
+	}
+	return resolved, nil
+}
+
+func (a *aliasOps) Attach(volumeID string, options map[string]string) (string, error) {
+	id, err := a.Resolve(volumeID)
+	if err != nil {
+		return "", err
+	}
+	return a.Ops.Attach(id, options)
+}
+
+func (a *aliasOps) Detach(volumeID string) error {
+	id, err := a.Resolve(volumeID)
+	if err != nil {
+		return err
+	}
+	return a.Ops.Detach(id)
+}
+
+func (a *aliasOps) DetachFrom(volumeID, instanceID string) error {
+	id, err := a.Resolve(volumeID)
+	if err != nil {
+		return err
+	}
+	return a.Ops.DetachFrom(id, instanceID)
+}
+
+func (a *aliasOps) Delete(volumeID string) error {
+	id, err := a.Resolve(volumeID)
+	if err != nil {
+		return err
+	}
+	if err := a.Ops.Delete(id); err != nil {
+		return err
+	}
+	a.deleteAliasesOf(id, "")
+	return nil
+}
+
+func (a *aliasOps) DeleteFrom(volumeID, instanceID string) error {
+	id, err := a.Resolve(volumeID)
+	if err != nil {
+		return err
+	}
+	if err := a.Ops.DeleteFrom(id, instanceID); err != nil {
+		return err
+	}
+	a.deleteAliasesOf(id, "")
+	return nil
+}
+
+// deleteAliasesOf drops the index entries pointing to the given volume,
+// except the given key
+func (a *aliasOps) deleteAliasesOf(volumeID, except string) {
+	kvps, err := a.kv.Enumerate(aliasKeyPrefix + a.Ops.Name() + "/")
+	if err != nil {
+		logrus.Warnf("failed to enumerate aliases of volume %v: %v", volumeID, err)
+		return
+	}
+	for _, kvp := range kvps {
+		if string(kvp.Value) != volumeID || kvp.Key == except {
+			continue
+		}
+		if _, err := a.kv.Delete(kvp.Key); err != nil && err != kvdb.ErrNotFound {
+			logrus.Warnf("failed to delete alias %v of volume %v: %v",
+				kvp.Key, volumeID, err)
+		}
+	}
+}
+
+func (a *aliasOps) DevicePath(volumeID string) (string, error) {
+	id, err := a.Resolve(volumeID)
+	if err != nil {
+		return "", err
+	}
+	return a.Ops.DevicePath(id)
+}
+
+func (a *aliasOps) Snapshot(volumeID string, readonly bool) (*Snapshot, error) {
+	id, err := a.Resolve(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	return a.Ops.Snapshot(id, readonly)
+}
+
+func (a *aliasOps) ApplyTags(volumeID string, labels map[string]string) error {
+	id, err := a.Resolve(volumeID)
+	if err != nil {
+		return err
+	}
+	return a.Ops.ApplyTags(id, labels)
+}
+
+func (a *aliasOps) RemoveTags(volumeID string, labels map[string]string) error {
+	id, err := a.Resolve(volumeID)
+	if err != nil {
+		return err
+	}
+	return a.Ops.RemoveTags(id, labels)
+}
+
+func (a *aliasOps) Expand(volumeID string, newSizeGiB uint64) (uint64, error) {
+	id, err := a.Resolve(volumeID)
+	if err != nil {
+		return 0, err
+	}
+	return a.Ops.Expand(id, newSizeGiB)
+}
+
+func (a *aliasOps) Modify(volumeID string, spec VolumeSpecUpdate) error {
+	id, err := a.Resolve(volumeID)
+	if err != nil {
+		return err
+	}
+	return a.Ops.Modify(id, spec)
+}
+
+func (a *aliasOps) Tags(volumeID string) (map[string]string, error) {
+	id, err := a.Resolve(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	return a.Ops.Tags(id)
+}
+
+func (a *aliasOps) Inspect(volumeIds []*string) ([]*Volume, error) {
+	ids, err := a.resolveAll(volumeIds)
+	if err != nil {
+		return nil, err
+	}
+	return a.Ops.Inspect(ids)
+}
+
+func (a *aliasOps) Enumerate(
+	volumeIds []*string,
+	labels map[string]string,
+	setIdentifier string,
+) (map[string][]*Volume, error) {
+	ids, err := a.resolveAll(volumeIds)
+	if err != nil {
+		return nil, err
+	}
+	return a.Ops.Enumerate(ids, labels, setIdentifier)
+}
@@ -0,0 +1,776 @@
+package alicloud
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/pborman/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// metadataEndpoint is the instance metadata service
+	metadataEndpoint = "http://100.100.100.200/latest/meta-data"
+	// defaultCategory is the disk category of disks created without one
+	defaultCategory = "cloud_essd"
+	// autoCategory is the disk category whose IOPS can be provisioned
+	autoCategory = "cloud_auto"
+	// performanceLevelPrefix starts the performance levels of ESSD disks,
+	// PL0 to PL3, which Modify accepts as the volume type
+	performanceLevelPrefix = "PL"
+)
+
+// diskByIDPrefix is the prefix of the udev symlinks of attached disks,
+// followed by their serial number
+var diskByIDPrefix = "/dev/disk/by-id/virtio-"
+
+// Config is the optional configuration of the Alibaba Cloud storage ops
+// driver
+type Config struct {
+	// RetryPolicy configures the wait loops of the driver. The default
+	// retries every storageops.ProviderOpsRetryInterval.
+	RetryPolicy *storageops.RetryPolicy
+	// HTTP configures the HTTP client of the API calls, e.g. to go through
+	// a proxy
+	HTTP *storageops.HTTPConfig
+	// SecurityToken is the STS token of temporary AccessKeys
+	SecurityToken string
+	// Endpoint replaces the ECS API endpoint of the region
+	Endpoint string
+}
+
+type aliOps struct {
+	client     *client
+	instanceID string
+	region     string
+	zone       string
+	cfg        Config
+	mutex      sync.Mutex
+}
+
+var _ storageops.Ops = &aliOps{}
+
+// NewEnvClient creates a new Alibaba Cloud operations client with the
+// AccessKey of the ALIBABA_CLOUD_ACCESS_KEY_ID and
+// ALIBABA_CLOUD_ACCESS_KEY_SECRET environment vars, and the STS token of
+// ALIBABA_CLOUD_SECURITY_TOKEN if set. The instance, region and zone are read
+// from ALIBABA_CLOUD_INSTANCE_ID, ALIBABA_CLOUD_REGION_ID and
+// ALIBABA_CLOUD_ZONE_ID if set, or else from the instance metadata service.
+func NewEnvClient() (storageops.Ops, error) {
+	keyID, err := storageops.GetEnvValueStrict("ALIBABA_CLOUD_ACCESS_KEY_ID")
+	if err != nil {
+		return nil, err
+	}
+	secret, err := storageops.GetEnvValueStrict("ALIBABA_CLOUD_ACCESS_KEY_SECRET")
+	if err != nil {
+		return nil, err
+	}
+	httpCfg, err := storageops.HTTPConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	cfg := Config{HTTP: httpCfg}
+	cfg.SecurityToken, _ = storageops.GetEnvValueStrict("ALIBABA_CLOUD_SECURITY_TOKEN")
+
+	var values [3]string
+	for i, f := range []struct{ env, key string }{
+		{"ALIBABA_CLOUD_INSTANCE_ID", "instance-id"},
+		{"ALIBABA_CLOUD_REGION_ID", "region-id"},
+		{"ALIBABA_CLOUD_ZONE_ID", "zone-id"},
+	} {
+		if values[i], err = storageops.GetEnvValueStrict(f.env); err == nil {
+			continue
+		}
+		if values[i], err = metadata(f.key); err != nil {
+			return nil, fmt.Errorf("error fetching instance %s. Err: %v", f.key, err)
+		}
+	}
+	return NewClient(keyID, secret, values[0], values[1], values[2], cfg)
+}
+
+// NewClient creates a new Alibaba Cloud operations client for the given
+// instance in the given region and zone, e.g. cn-hangzhou and cn-hangzhou-h,
+// authenticated with the given AccessKey
+func NewClient(
+	accessKeyID, accessKeySecret, instanceID, region, zone string,
+	cfg Config,
+) (storageops.Ops, error) {
+	if err := cfg.RetryPolicy.Validate(); err != nil {
+		return nil, err
+	}
+	httpClient, err := cfg.HTTP.Client()
+	if err != nil {
+		return nil, err
+	}
+	endpoint := cfg.Endpoint
+	if len(endpoint) == 0 {
+		endpoint = fmt.Sprintf("https://ecs.%s.aliyuncs.com", region)
+	}
+	c, err := newClient(accessKeyID, accessKeySecret, cfg.SecurityToken, region, endpoint, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	return &aliOps{
+		client:     c,
+		instanceID: instanceID,
+		region:     region,
+		zone:       zone,
+		cfg:        cfg,
+	}, nil
+}
+
+// metadata returns the given instance metadata, e.g. instance-id or zone-id
+func metadata(key string) (string, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(metadataEndpoint + "/" + key)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata service returned %s for %s", resp.Status, key)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (s *aliOps) Name() string { return "alicloud" }
+
+func (s *aliOps) InstanceID() string { return s.instanceID }
+
+func (s *aliOps) GetZone() (string, error) { return s.zone, nil }
+
+func (s *aliOps) GetRegion() (string, error) { return s.region, nil }
+
+func (s *aliOps) ListZones() ([]string, error) {
+	zones, err := s.client.describeZones()
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	return zones, nil
+}
+
+// validateLabels returns an ErrVolInval error if a label cannot be stored as
+// a tag. Tag keys may not start with the prefixes reserved by Alibaba Cloud.
+func validateLabels(labels map[string]string) error {
+	for k, v := range labels {
+		lower := strings.ToLower(k)
+		if len(k) == 0 || len(k) > 128 || len(v) > 128 ||
+			strings.HasPrefix(lower, "aliyun") || strings.HasPrefix(lower, "acs:") ||
+			strings.Contains(lower, "http://") || strings.Contains(lower, "https://") {
+			return storageops.NewStorageError(storageops.ErrVolInval,
+				fmt.Sprintf("label %s=%s is not a valid tag, keys are 1 to 128 characters "+
+					"not starting with aliyun or acs: and values at most 128", k, v), "")
+		}
+	}
+	return nil
+}
+
+// tagsToLabels returns the labels of the given tags
+func tagsToLabels(tags []ecs.Tag) map[string]string {
+	labels := make(map[string]string)
+	for _, t := range tags {
+		labels[t.TagKey] = t.TagValue
+	}
+	return labels
+}
+
+// hasLabels returns true if the given tags carry all given labels
+func hasLabels(tags []ecs.Tag, labels map[string]string) bool {
+	have := tagsToLabels(tags)
+	for k, v := range labels {
+		if value, ok := have[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *aliOps) Create(
+	template interface{},
+	labels map[string]string,
+) (*storageops.Volume, error) {
+	template, labels, err := storageops.VolumeTemplate(s.Name(), template, labels)
+	if err != nil {
+		return nil, err
+	}
+	v, ok := template.(*ecs.CreateDiskRequest)
+	if !ok {
+		return nil, storageops.NewStorageError(storageops.ErrVolInval,
+			"Invalid volume template given", s.instanceID)
+	}
+	if err := validateLabels(labels); err != nil {
+		return nil, err
+	}
+	req := *v
+	if len(req.ZoneId) == 0 {
+		req.ZoneId = s.zone
+	}
+	if len(req.DiskCategory) == 0 {
+		req.DiskCategory = defaultCategory
+	}
+	if len(req.Description) == 0 {
+		req.Description = "Disk created by openstorage"
+	}
+
+	token := storageops.ClientToken(labels)
+	if len(token) == 0 {
+		token = uuid.New()
+	}
+	id, err := s.client.createDisk(&req, labels, token)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	disk, err := s.waitDisk(id, storageops.RetryOpCreate, func(disk *ecs.Disk) bool {
+		return disk.Status == diskAvailable
+	})
+	if err != nil {
+		return nil, s.rollbackCreate(id, err)
+	}
+	return toVolume(disk), nil
+}
+
+func (s *aliOps) rollbackCreate(id string, createErr error) error {
+	logrus.Warnf("Rollback create volume %v, Error %v", id, createErr)
+	if err := s.Delete(id); err != nil {
+		logrus.Warnf("Rollback failed volume %v, Error %v", id, err)
+	}
+	return createErr
+}
+
+// waitDisk waits for done to return true for the given disk and returns it
+func (s *aliOps) waitDisk(id, op string, done func(disk *ecs.Disk) bool) (*ecs.Disk, error) {
+	disk, err := s.cfg.RetryPolicy.For(op).Retry(
+		fmt.Sprintf("wait for %s of disk %s", op, id),
+		func() (interface{}, bool, error) {
+			disk, err := s.client.getDisk(id)
+			if err != nil {
+				return nil, true, s.storageError(err)
+			}
+			if !done(disk) {
+				return nil, true, fmt.Errorf("%s of disk %s is pending, disk is %s",
+					op, id, disk.Status)
+			}
+			return disk, false, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return disk.(*ecs.Disk), nil
+}
+
+func (s *aliOps) GetDeviceID(template interface{}) (string, error) {
+	if id, ok := storageops.TypedID(template); ok {
+		return id, nil
+	}
+	switch v := template.(type) {
+	case *ecs.Disk:
+		return v.DiskId, nil
+	case *ecs.Snapshot:
+		return v.SnapshotId, nil
+	}
+	return "", fmt.Errorf("invalid type: %v given to GetDeviceID", template)
+}
+
+func (s *aliOps) disk(volumeID string) (*ecs.Disk, error) {
+	disk, err := s.client.getDisk(volumeID)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	return disk, nil
+}
+
+func (s *aliOps) Expand(volumeID string, newSizeGiB uint64) (uint64, error) {
+	disk, err := s.disk(volumeID)
+	if err != nil {
+		return 0, err
+	}
+	currentSize := uint64(disk.Size)
+	if newSizeGiB == currentSize {
+		return currentSize, nil
+	}
+	if newSizeGiB < currentSize {
+		return 0, storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("disk %s of %d GiB cannot be shrunk to %d GiB",
+				volumeID, currentSize, newSizeGiB), s.instanceID)
+	}
+	// Disks in use can only be resized online, which also grows the block
+	// device of the instance
+	if err := s.client.resizeDisk(volumeID, newSizeGiB, disk.Status == diskInUse); err != nil {
+		return 0, s.storageError(err)
+	}
+	if _, err := s.waitDisk(volumeID, storageops.RetryOpExpand, func(disk *ecs.Disk) bool {
+		return uint64(disk.Size) == newSizeGiB
+	}); err != nil {
+		return 0, err
+	}
+	return newSizeGiB, nil
+}
+
+// Modify changes the disk category to spec.Type, e.g. cloud_essd, or the
+// performance level of ESSD disks if spec.Type is PL0 to PL3. IOPS can only be
+// provisioned for cloud_auto disks, and the throughput follows from the
+// category and performance level.
+func (s *aliOps) Modify(volumeID string, spec storageops.VolumeSpecUpdate) error {
+	if spec.ThroughputMiBps > 0 {
+		return storageops.NewStorageError(storageops.ErrModifyUnsupported,
+			fmt.Sprintf("throughput of disk %s cannot be set, it follows from its "+
+				"category and performance level", volumeID), s.instanceID)
+	}
+	disk, err := s.disk(volumeID)
+	if err != nil {
+		return err
+	}
+	category, level := disk.Category, disk.PerformanceLevel
+	var newCategory, newLevel string
+	if strings.HasPrefix(spec.Type, performanceLevelPrefix) {
+		level, newLevel = spec.Type, spec.Type
+	} else if len(spec.Type) > 0 {
+		category, newCategory = spec.Type, spec.Type
+	}
+	if spec.Iops > 0 && category != autoCategory {
+		return storageops.NewStorageError(storageops.ErrModifyUnsupported,
+			fmt.Sprintf("IOPS of disk %s can only be provisioned for %s disks, not %s",
+				volumeID, autoCategory, category), s.instanceID)
+	}
+	if len(newCategory) == 0 && len(newLevel) == 0 && spec.Iops == 0 {
+		return nil
+	}
+	if err := s.client.modifyDiskSpec(volumeID, newCategory, newLevel, spec.Iops); err != nil {
+		return s.storageError(err)
+	}
+	_, err = s.waitDisk(volumeID, storageops.RetryOpModify, func(disk *ecs.Disk) bool {
+		return disk.Category == category && disk.PerformanceLevel == level &&
+			(spec.Iops == 0 || disk.ProvisionedIops == spec.Iops)
+	})
+	return err
+}
+
+func (s *aliOps) Attach(volumeID string, options map[string]string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	disk, err := s.disk(volumeID)
+	if err != nil {
+		return "", err
+	}
+	if disk.InstanceId == s.instanceID {
+		return s.devicePath(disk)
+	}
+	if len(disk.InstanceId) > 0 {
+		return "", storageops.NewStorageError(storageops.ErrVolAlreadyAttached,
+			fmt.Sprintf("disk %s is already attached to instance %s", volumeID, disk.InstanceId),
+			s.instanceID)
+	}
+
+	if err := s.client.attachDisk(volumeID, s.instanceID); err != nil {
+		return "", s.storageError(err)
+	}
+	disk, err = s.waitDisk(volumeID, storageops.RetryOpAttach, func(disk *ecs.Disk) bool {
+		return disk.Status == diskInUse && disk.InstanceId == s.instanceID
+	})
+	if err != nil {
+		return "", err
+	}
+
+	devicePath, err := s.cfg.RetryPolicy.For(storageops.RetryOpAttach).Retry(
+		fmt.Sprintf("wait for device of disk %s", volumeID),
+		func() (interface{}, bool, error) {
+			devicePath, err := s.devicePath(disk)
+			return devicePath, err != nil, err
+		})
+	if err != nil {
+		return "", err
+	}
+	return devicePath.(string), nil
+}
+
+func (s *aliOps) Detach(volumeID string) error {
+	return s.detachInternal(volumeID, s.instanceID)
+}
+
+func (s *aliOps) DetachFrom(volumeID, instanceID string) error {
+	return s.detachInternal(volumeID, instanceID)
+}
+
+func (s *aliOps) detachInternal(volumeID, instanceID string) error {
+	if err := s.client.detachDisk(volumeID, instanceID); err != nil {
+		return s.storageError(err)
+	}
+	_, err := s.waitDisk(volumeID, storageops.RetryOpDetach, func(disk *ecs.Disk) bool {
+		return disk.Status == diskAvailable
+	})
+	return err
+}
+
+func (s *aliOps) Delete(volumeID string) error {
+	return s.storageError(s.client.deleteDisk(volumeID))
+}
+
+func (s *aliOps) DeleteFrom(volumeID, _ string) error {
+	return s.Delete(volumeID)
+}
+
+// Describe returns the *ecs.Instance of this instance
+func (s *aliOps) Describe() (interface{}, error) {
+	inst, err := s.client.describeInstance(s.instanceID)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	return inst, nil
+}
+
+// FreeDevices is not supported, ECS picks the device of attached disks
+func (s *aliOps) FreeDevices(
+	blockDeviceMappings []interface{},
+	rootDeviceName string,
+) ([]string, error) {
+	return nil, storageops.ErrNotSupported
+}
+
+func (s *aliOps) Inspect(volumeIds []*string) ([]*storageops.Volume, error) {
+	var disks []*storageops.Volume
+	for _, id := range volumeIds {
+		disk, err := s.disk(*id)
+		if err != nil {
+			return nil, err
+		}
+		disks = append(disks, toVolume(disk))
+	}
+	return disks, nil
+}
+
+func (s *aliOps) DeviceMappings() (map[string]string, error) {
+	m := make(map[string]string)
+	var pathErr error
+	err := s.client.describeDisks(&diskFilter{instanceID: s.instanceID}, func(disk *ecs.Disk) bool {
+		if disk.Type == "system" || disk.Status != diskInUse {
+			return true
+		}
+		devPath, err := s.devicePath(disk)
+		if err != nil {
+			pathErr = err
+			return false
+		}
+		m[devPath] = disk.DiskId
+		return true
+	})
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	if pathErr != nil {
+		return nil, pathErr
+	}
+	return m, nil
+}
+
+func (s *aliOps) Enumerate(
+	volumeIds []*string,
+	labels map[string]string,
+	setIdentifier string,
+) (map[string][]*storageops.Volume, error) {
+	sets := make(map[string][]*storageops.Volume)
+	err := s.EnumerateEach(volumeIds, labels, setIdentifier,
+		func(set string, vol *storageops.Volume) bool {
+			storageops.AddElementToMap(sets, vol, set)
+			return true
+		})
+	if err != nil {
+		return nil, err
+	}
+	return sets, nil
+}
+
+var _ storageops.StreamingEnumerator = &aliOps{}
+
+// EnumerateEach calls fn with every disk of the region matching the given IDs
+// and labels one page at a time. The set of a disk is the setIdentifier label
+// key if it has it.
+func (s *aliOps) EnumerateEach(
+	volumeIds []*string,
+	labels map[string]string,
+	setIdentifier string,
+	fn func(set string, vol *storageops.Volume) bool,
+) error {
+	ids := make(map[string]bool)
+	for _, id := range volumeIds {
+		ids[*id] = true
+	}
+	// The API filters at most listPageSize disk IDs, more are matched here
+	filter := &diskFilter{tags: labels}
+	if len(ids) > 0 && len(ids) <= listPageSize {
+		for id := range ids {
+			filter.ids = append(filter.ids, id)
+		}
+	}
+	err := s.client.describeDisks(filter, func(disk *ecs.Disk) bool {
+		if len(ids) > 0 && !ids[disk.DiskId] {
+			return true
+		}
+		if !hasLabels(disk.Tags.Tag, labels) {
+			return true
+		}
+		set := storageops.SetIdentifierNone
+		if _, ok := tagsToLabels(disk.Tags.Tag)[setIdentifier]; ok && len(setIdentifier) > 0 {
+			set = setIdentifier
+		}
+		return fn(set, toVolume(disk))
+	})
+	return s.storageError(err)
+}
+
+func (s *aliOps) DevicePath(volumeID string) (string, error) {
+	disk, err := s.disk(volumeID)
+	if err != nil {
+		return "", err
+	}
+	if len(disk.InstanceId) == 0 {
+		return "", storageops.NewStorageError(storageops.ErrVolDetached,
+			fmt.Sprintf("Volume %s is detached", volumeID), s.instanceID)
+	}
+	if disk.InstanceId != s.instanceID {
+		return "", storageops.NewStorageError(storageops.ErrVolAttachedOnRemoteNode,
+			fmt.Sprintf("volume %s is not attached on: %s (Attached on: %s)",
+				volumeID, s.instanceID, disk.InstanceId),
+			s.instanceID)
+	}
+	return s.devicePath(disk)
+}
+
+// deviceName returns the name under which the instance sees the device
+// reported by the API. The API reports Xen style /dev/xvd* names while the
+// virtio disks of current instance families are /dev/vd*.
+func deviceName(device string) string {
+	if strings.HasPrefix(device, "/dev/xvd") {
+		return "/dev/vd" + strings.TrimPrefix(device, "/dev/xvd")
+	}
+	return device
+}
+
+// devicePath resolves the by-id symlink of the given attached disk, named
+// after its serial number, which is the disk ID without its d- prefix. If the
+// symlink is missing, e.g. on images without the udev rules, the /dev/vd*
+// name of the reported device is used.
+func (s *aliOps) devicePath(disk *ecs.Disk) (string, error) {
+	serial := disk.SerialNumber
+	if len(serial) == 0 {
+		serial = strings.TrimPrefix(disk.DiskId, "d-")
+	}
+	link := diskByIDPrefix + serial
+	devPath, err := filepath.EvalSymlinks(link)
+	if err == nil {
+		return devPath, nil
+	}
+	if name := deviceName(disk.Device); len(name) > 0 {
+		if _, statErr := os.Stat(name); statErr == nil {
+			return name, nil
+		}
+	}
+	return "", storageops.NewStorageError(storageops.ErrInvalidDevicePath,
+		fmt.Sprintf("unable to find block dev path for %s. %v", link, err),
+		s.instanceID)
+}
+
+func (s *aliOps) Snapshot(volumeID string, readonly bool) (*storageops.Snapshot, error) {
+	disk, err := s.disk(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("%s-%s", disk.DiskName, time.Now().UTC().Format("20060102150405"))
+	id, err := s.client.createSnapshot(volumeID, name, tagsToLabels(disk.Tags.Tag))
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	snap, err := s.cfg.RetryPolicy.For(storageops.RetryOpSnapshot).Retry(
+		fmt.Sprintf("wait for snapshot %s to be %s", id, snapshotAccomplished),
+		func() (interface{}, bool, error) {
+			snap, err := s.client.getSnapshot(id)
+			if err != nil {
+				return nil, true, s.storageError(err)
+			}
+			if snap.Status == snapshotFailed {
+				return nil, false, fmt.Errorf("snapshot %s is %s", id, snapshotFailed)
+			}
+			if snap.Status != snapshotAccomplished {
+				return nil, true, fmt.Errorf("invalid state: %s for snapshot: %s. expected: %s",
+					snap.Status, id, snapshotAccomplished)
+			}
+			return snap, false, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return toSnapshot(snap.(*ecs.Snapshot))
+}
+
+func (s *aliOps) SnapshotDelete(snapID string) error {
+	return s.storageError(s.client.deleteSnapshot(snapID))
+}
+
+func (s *aliOps) SnapshotEnumerate(
+	filter *storageops.SnapshotFilter,
+) ([]*storageops.Snapshot, error) {
+	diskID := ""
+	if filter != nil {
+		diskID = filter.VolumeID
+	}
+	var snaps []*storageops.Snapshot
+	var parseErr error
+	err := s.client.describeSnapshots(diskID, nil, func(snap *ecs.Snapshot) bool {
+		typed, err := toSnapshot(snap)
+		if err != nil {
+			parseErr = err
+			return false
+		}
+		if filter != nil && !hasLabels(snap.Tags.Tag, filter.Labels) {
+			return true
+		}
+		if filter.Match(typed.VolumeID, typed.Created, typed.State, typed.Labels) {
+			snaps = append(snaps, typed)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	return snaps, nil
+}
+
+// SnapshotRestore creates a disk of the default category from the given
+// snapshot in the given zone
+func (s *aliOps) SnapshotRestore(
+	snapID, zone string,
+	labels map[string]string,
+) (*storageops.Volume, error) {
+	snap, err := s.client.getSnapshot(snapID)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	if len(zone) == 0 {
+		zone = s.zone
+	}
+	size, err := strconv.ParseInt(snap.SourceDiskSize, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source disk size %q of snapshot %s", snap.SourceDiskSize, snapID)
+	}
+	return s.Create(&ecs.CreateDiskRequest{
+		ZoneId:     zone,
+		DiskName:   snap.SnapshotName,
+		Size:       requests.NewInteger64(size),
+		SnapshotId: snapID,
+	}, labels)
+}
+
+func (s *aliOps) SnapshotStatus(snapID string) (*storageops.SnapshotStatus, error) {
+	snap, err := s.client.getSnapshot(snapID)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	progress, _ := strconv.Atoi(strings.TrimSuffix(snap.Progress, "%"))
+	return &storageops.SnapshotStatus{
+		ID:        snap.SnapshotId,
+		State:     snap.Status,
+		Progress:  progress,
+		Completed: snap.Status == snapshotAccomplished,
+		Failed:    snap.Status == snapshotFailed,
+	}, nil
+}
+
+// isSnapshot returns true if the given ID is that of a snapshot
+func isSnapshot(id string) bool {
+	return strings.HasPrefix(id, "s-")
+}
+
+// resourceTags returns the tag resource type and tags of the given disk or
+// snapshot
+func (s *aliOps) resourceTags(id string) (string, []ecs.Tag, error) {
+	if isSnapshot(id) {
+		snap, err := s.client.getSnapshot(id)
+		if err != nil {
+			return "", nil, s.storageError(err)
+		}
+		return resourceTypeSnapshot, snap.Tags.Tag, nil
+	}
+	disk, err := s.disk(id)
+	if err != nil {
+		return "", nil, err
+	}
+	return resourceTypeDisk, disk.Tags.Tag, nil
+}
+
+// ApplyTags tags the given disk or snapshot with the given labels, replacing
+// the values of labels it already has
+func (s *aliOps) ApplyTags(volumeID string, labels map[string]string) error {
+	if err := validateLabels(labels); err != nil {
+		return err
+	}
+	resourceType := resourceTypeDisk
+	if isSnapshot(volumeID) {
+		resourceType = resourceTypeSnapshot
+	}
+	return s.storageError(s.client.tagResource(resourceType, volumeID, labels))
+}
+
+func (s *aliOps) RemoveTags(volumeID string, labels map[string]string) error {
+	resourceType, tags, err := s.resourceTags(volumeID)
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for k := range tagsToLabels(tags) {
+		if _, ok := labels[k]; ok {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return s.storageError(s.client.untagResource(resourceType, volumeID, keys))
+}
+
+func (s *aliOps) Tags(volumeID string) (map[string]string, error) {
+	_, tags, err := s.resourceTags(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	return tagsToLabels(tags), nil
+}
+
+// VolumeFromDevicePath returns the disk attached to this instance at the
+// given device path
+func (s *aliOps) VolumeFromDevicePath(devicePath string) (*storageops.Volume, error) {
+	dev, err := storageops.BaseDevice(devicePath)
+	if err != nil {
+		return nil, storageops.NewStorageError(storageops.ErrInvalidDevicePath,
+			err.Error(), s.instanceID)
+	}
+	mappings, err := s.DeviceMappings()
+	if err != nil {
+		return nil, err
+	}
+	volumeID, err := storageops.VolumeIDFromMappings(mappings, dev)
+	if err != nil {
+		return nil, err
+	}
+	disk, err := s.disk(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	return toVolume(disk), nil
+}
@@ -0,0 +1,302 @@
+package alicloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/libopenstorage/openstorage/pkg/storageops/test"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAll(t *testing.T) {
+	d, err := NewEnvClient()
+	if err != nil {
+		t.Skipf("skipping Alibaba Cloud tests as environment is not set...\n")
+	}
+	name := fmt.Sprintf("openstorage-test-%s", uuid.New()[:8])
+	test.RunTest(map[string]storageops.Ops{d.Name(): d},
+		map[string]map[string]interface{}{d.Name(): {
+			name: &ecs.CreateDiskRequest{DiskName: name, Size: requests.NewInteger(20)},
+		}}, t)
+}
+
+// fakeAPI is an in memory ECS API of disks and snapshots that verifies the
+// requests are signed with the AccessKey
+type fakeAPI struct {
+	t         *testing.T
+	disks     map[string]*ecs.Disk
+	snapshots map[string]*ecs.Snapshot
+	tokens    map[string]string
+}
+
+// tags returns the tags of the Tag.N parameters
+func tags(q url.Values) []ecs.Tag {
+	var tags []ecs.Tag
+	for i := 1; len(q.Get(fmt.Sprintf("Tag.%d.Key", i))) > 0; i++ {
+		tags = append(tags, ecs.Tag{
+			TagKey:   q.Get(fmt.Sprintf("Tag.%d.Key", i)),
+			TagValue: q.Get(fmt.Sprintf("Tag.%d.Value", i)),
+		})
+	}
+	return tags
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	assert.Equal(f.t, "key", q.Get("AccessKeyId"))
+	assert.Equal(f.t, "HMAC-SHA1", q.Get("SignatureMethod"))
+	assert.NotEmpty(f.t, q.Get("Signature"))
+	reply := func(v interface{}) { assert.NoError(f.t, json.NewEncoder(w).Encode(v)) }
+	fail := func(status int, code string) {
+		w.WriteHeader(status)
+		reply(map[string]string{"Code": code, "Message": code, "RequestId": "req-1"})
+	}
+	disk := f.disks[q.Get("DiskId")]
+	switch action := q.Get("Action"); action {
+	case "CreateDisk":
+		if id, ok := f.tokens[q.Get("ClientToken")]; ok {
+			reply(map[string]string{"DiskId": id})
+			return
+		}
+		size, _ := strconv.Atoi(q.Get("Size"))
+		disk := &ecs.Disk{
+			DiskId:           "d-" + uuid.New()[:12],
+			DiskName:         q.Get("DiskName"),
+			Size:             size,
+			Category:         q.Get("DiskCategory"),
+			PerformanceLevel: "PL1",
+			ZoneId:           q.Get("ZoneId"),
+			RegionId:         q.Get("RegionId"),
+			Status:           diskAvailable,
+			Type:             "data",
+			SourceSnapshotId: q.Get("SnapshotId"),
+			CreationTime:     time.Now().UTC().Format("2006-01-02T15:04Z"),
+			Tags:             ecs.TagsInDescribeDisks{Tag: tags(q)},
+		}
+		f.disks[disk.DiskId] = disk
+		f.tokens[q.Get("ClientToken")] = disk.DiskId
+		reply(map[string]string{"DiskId": disk.DiskId})
+	case "DescribeDisks":
+		var ids []string
+		if len(q.Get("DiskIds")) > 0 {
+			assert.NoError(f.t, json.Unmarshal([]byte(q.Get("DiskIds")), &ids))
+		}
+		var disks []*ecs.Disk
+		for _, disk := range f.disks {
+			if len(ids) > 0 && ids[0] != disk.DiskId {
+				continue
+			}
+			if i := q.Get("InstanceId"); len(i) > 0 && i != disk.InstanceId {
+				continue
+			}
+			if !hasLabels(disk.Tags.Tag, tagsToLabels(tags(q))) {
+				continue
+			}
+			disks = append(disks, disk)
+		}
+		resp := map[string]interface{}{"TotalCount": len(disks)}
+		resp["Disks"] = map[string]interface{}{"Disk": disks}
+		reply(resp)
+	case "AttachDisk", "DetachDisk", "DeleteDisk", "ResizeDisk", "ModifyDiskSpec":
+		if disk == nil {
+			fail(http.StatusNotFound, "InvalidDiskId.NotFound")
+			return
+		}
+		switch action {
+		case "AttachDisk":
+			disk.InstanceId, disk.Status, disk.Device = q.Get("InstanceId"), diskInUse, "/dev/xvdb"
+			disk.SerialNumber = disk.DiskId[2:]
+		case "DetachDisk":
+			disk.InstanceId, disk.Status, disk.Device = "", diskAvailable, ""
+		case "DeleteDisk":
+			delete(f.disks, disk.DiskId)
+		case "ResizeDisk":
+			assert.Equal(f.t, "online", q.Get("Type"))
+			disk.Size, _ = strconv.Atoi(q.Get("NewSize"))
+		case "ModifyDiskSpec":
+			if c := q.Get("DiskCategory"); len(c) > 0 {
+				disk.Category = c
+			}
+			if pl := q.Get("PerformanceLevel"); len(pl) > 0 {
+				disk.PerformanceLevel = pl
+			}
+		}
+		reply(map[string]string{"RequestId": "req-1"})
+	case "CreateSnapshot":
+		snap := &ecs.Snapshot{
+			SnapshotId:     "s-" + uuid.New()[:12],
+			SnapshotName:   q.Get("SnapshotName"),
+			SourceDiskId:   disk.DiskId,
+			SourceDiskSize: strconv.Itoa(disk.Size),
+			Status:         snapshotAccomplished,
+			Progress:       "100%",
+			CreationTime:   time.Now().UTC().Format(time.RFC3339),
+			Tags:           ecs.TagsInDescribeSnapshots{Tag: tags(q)},
+		}
+		f.snapshots[snap.SnapshotId] = snap
+		reply(map[string]string{"SnapshotId": snap.SnapshotId})
+	case "DescribeSnapshots":
+		var ids []string
+		if len(q.Get("SnapshotIds")) > 0 {
+			assert.NoError(f.t, json.Unmarshal([]byte(q.Get("SnapshotIds")), &ids))
+		}
+		var snaps []*ecs.Snapshot
+		for _, snap := range f.snapshots {
+			if len(ids) > 0 && ids[0] != snap.SnapshotId {
+				continue
+			}
+			if d := q.Get("DiskId"); len(d) > 0 && d != snap.SourceDiskId {
+				continue
+			}
+			snaps = append(snaps, snap)
+		}
+		resp := map[string]interface{}{"TotalCount": len(snaps)}
+		resp["Snapshots"] = map[string]interface{}{"Snapshot": snaps}
+		reply(resp)
+	case "DeleteSnapshot":
+		delete(f.snapshots, q.Get("SnapshotId"))
+		reply(map[string]string{"RequestId": "req-1"})
+	case "TagResources", "UntagResources":
+		var resourceTags *[]ecs.Tag
+		id := q.Get("ResourceId.1")
+		if q.Get("ResourceType") == resourceTypeSnapshot {
+			resourceTags = &f.snapshots[id].Tags.Tag
+		} else {
+			resourceTags = &f.disks[id].Tags.Tag
+		}
+		labels := tagsToLabels(*resourceTags)
+		for k, v := range tagsToLabels(tags(q)) {
+			labels[k] = v
+		}
+		for i := 1; len(q.Get(fmt.Sprintf("TagKey.%d", i))) > 0; i++ {
+			delete(labels, q.Get(fmt.Sprintf("TagKey.%d", i)))
+		}
+		*resourceTags = nil
+		for k, v := range labels {
+			*resourceTags = append(*resourceTags, ecs.Tag{TagKey: k, TagValue: v})
+		}
+		reply(map[string]string{"RequestId": "req-1"})
+	case "DescribeZones":
+		reply(map[string]interface{}{"Zones": map[string]interface{}{"Zone": []map[string]string{
+			{"ZoneId": "cn-hangzhou-h"}, {"ZoneId": "cn-hangzhou-i"}}}})
+	default:
+		f.t.Errorf("unexpected action %s", action)
+		fail(http.StatusBadRequest, "InvalidAction.NotFound")
+	}
+}
+
+func TestAlicloudDisks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alicloud")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	prefix := diskByIDPrefix
+	diskByIDPrefix = filepath.Join(dir, "virtio-")
+	defer func() { diskByIDPrefix = prefix }()
+
+	api := &fakeAPI{t: t,
+		disks:     make(map[string]*ecs.Disk),
+		snapshots: make(map[string]*ecs.Snapshot),
+		tokens:    make(map[string]string)}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	d, err := NewClient("key", "secret", "i-1", "cn-hangzhou", "cn-hangzhou-h", Config{
+		Endpoint:    server.URL,
+		RetryPolicy: &storageops.RetryPolicy{Interval: time.Millisecond, Timeout: time.Second},
+	})
+	assert.NoError(t, err)
+	zones, err := d.ListZones()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cn-hangzhou-h", "cn-hangzhou-i"}, zones)
+
+	_, err = d.Create(&ecs.CreateDiskRequest{DiskName: "data", Size: requests.NewInteger(20)},
+		map[string]string{"aliyun-x": "1"})
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval))
+	vol, err := d.Create(&ecs.CreateDiskRequest{DiskName: "data", Size: requests.NewInteger(20)},
+		map[string]string{"app": "db", "team": "storage"})
+	assert.NoError(t, err)
+	id, err := d.GetDeviceID(vol)
+	assert.NoError(t, err)
+	created := api.disks[id]
+	assert.Equal(t, "cn-hangzhou-h", created.ZoneId)
+	assert.Equal(t, defaultCategory, created.Category)
+	assert.Equal(t, map[string]string{"app": "db", "team": "storage"}, tagsToLabels(created.Tags.Tag))
+
+	_, err = d.DevicePath(id)
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolDetached))
+	device := filepath.Join(dir, "vdb")
+	assert.NoError(t, ioutil.WriteFile(device, nil, 0600))
+	assert.NoError(t, os.Symlink(device, diskByIDPrefix+id[2:]))
+	path, err := d.Attach(id, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, device, path)
+	assert.Equal(t, "/dev/xvdb", api.disks[id].Device)
+	mappings, err := d.DeviceMappings()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{device: id}, mappings)
+
+	size, err := d.Expand(id, 40)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(40), size)
+	assert.NoError(t, d.Modify(id, storageops.VolumeSpecUpdate{Type: "PL2"}))
+	assert.Equal(t, "PL2", api.disks[id].PerformanceLevel)
+	err = d.Modify(id, storageops.VolumeSpecUpdate{Iops: 5000})
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrModifyUnsupported))
+
+	sets, err := d.Enumerate(nil, map[string]string{"team": "storage"}, "app")
+	assert.NoError(t, err)
+	assert.Len(t, sets["app"], 1)
+	sets, err = d.Enumerate(nil, map[string]string{"team": "other"}, "app")
+	assert.NoError(t, err)
+	assert.Empty(t, sets)
+
+	assert.NoError(t, d.ApplyTags(id, map[string]string{"tier": "gold", "app": "web"}))
+	assert.NoError(t, d.RemoveTags(id, map[string]string{"team": ""}))
+	labels, err := d.Tags(id)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"tier": "gold", "app": "web"}, labels)
+
+	snap, err := d.Snapshot(id, true)
+	assert.NoError(t, err)
+	snapID := snap.ID
+	assert.NoError(t, d.ApplyTags(snapID, map[string]string{"backup": "daily"}))
+	snaps, err := d.SnapshotEnumerate(&storageops.SnapshotFilter{
+		VolumeID: id, Labels: map[string]string{"backup": "daily"}})
+	assert.NoError(t, err)
+	assert.Len(t, snaps, 1)
+	status, err := d.SnapshotStatus(snapID)
+	assert.NoError(t, err)
+	assert.True(t, status.Completed)
+	assert.Equal(t, 100, status.Progress)
+	restored, err := d.SnapshotRestore(snapID, "cn-hangzhou-i", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, snapID, restored.Raw.(*ecs.Disk).SourceSnapshotId)
+	assert.Equal(t, uint64(40), restored.SizeGiB)
+
+	assert.NoError(t, d.Detach(id))
+	_, err = d.DevicePath(id)
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolDetached))
+	assert.NoError(t, d.SnapshotDelete(snapID))
+	assert.NoError(t, d.Delete(id))
+	_, err = d.Inspect([]*string{&id})
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolNotFound))
+}
+
+func TestAlicloudDeviceName(t *testing.T) {
+	assert.Equal(t, "/dev/vdb", deviceName("/dev/xvdb"))
+	assert.Equal(t, "/dev/vdc", deviceName("/dev/vdc"))
+	assert.Equal(t, "", deviceName(""))
+}
@@ -0,0 +1,393 @@
+package alicloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth/credentials"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/errors"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+)
+
+const (
+	// listPageSize is the number of items requested per page of list calls
+	listPageSize = 100
+	// maxListTags is the number of tag filters of list calls
+	maxListTags = 20
+)
+
+// Disk and snapshot states
+const (
+	diskAvailable        = "Available"
+	diskInUse            = "In_use"
+	snapshotAccomplished = "accomplished"
+	snapshotFailed       = "failed"
+)
+
+// Resource types of the tag calls
+const (
+	resourceTypeDisk     = "disk"
+	resourceTypeSnapshot = "snapshot"
+)
+
+// APIError is an error response of the ECS API
+type APIError struct {
+	// StatusCode is the HTTP status of the response
+	StatusCode int
+	// Code is the error code, e.g. InvalidDiskId.NotFound
+	Code string
+	// Message is the error message
+	Message string
+	// RequestID identifies the request for Alibaba Cloud support
+	RequestID string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d %s: %s (request %s)", e.StatusCode, e.Code, e.Message, e.RequestID)
+}
+
+// apiError returns the given server error of an SDK call as an *APIError.
+// Other errors are returned unchanged.
+func apiError(err error) error {
+	serverErr, ok := err.(*errors.ServerError)
+	if !ok {
+		return err
+	}
+	return &APIError{
+		StatusCode: serverErr.HttpStatus(),
+		Code:       serverErr.ErrorCode(),
+		Message:    serverErr.Message(),
+		RequestID:  serverErr.RequestId(),
+	}
+}
+
+// parseTime parses the creation times of the API, which omit the seconds
+// for disks
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04Z"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+// client wraps the ECS SDK calls of the driver, returning their errors as
+// *APIError
+type client struct {
+	ecs *ecs.Client
+}
+
+// newClient returns a client of the given ECS API endpoint authenticated
+// with the given AccessKey, and the STS token if not empty
+func newClient(
+	accessKeyID, accessKeySecret, securityToken, region, endpoint string,
+	httpClient *http.Client,
+) (*client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || len(u.Host) == 0 {
+		return nil, fmt.Errorf("invalid ECS endpoint %q", endpoint)
+	}
+	var credential auth.Credential = credentials.NewAccessKeyCredential(accessKeyID, accessKeySecret)
+	if len(securityToken) > 0 {
+		credential = credentials.NewStsTokenCredential(accessKeyID, accessKeySecret, securityToken)
+	}
+	config := sdk.NewConfig()
+	config.Scheme = strings.ToUpper(u.Scheme)
+	config.Transport = httpClient.Transport
+	config.Timeout = httpClient.Timeout
+	c, err := ecs.NewClientWithOptions(region, config, credential)
+	if err != nil {
+		return nil, err
+	}
+	// The endpoint of the region is known, which spares the SDK looking it
+	// up through the location service
+	c.Domain = u.Host
+	return &client{ecs: c}, nil
+}
+
+// createDisk creates a disk with the given tags from the given request,
+// whose RPC request is replaced by a new one as templates may not have one.
+// The client token makes retries of the same create idempotent.
+func (c *client) createDisk(
+	req *ecs.CreateDiskRequest,
+	tags map[string]string,
+	clientToken string,
+) (string, error) {
+	req.RpcRequest = ecs.CreateCreateDiskRequest().RpcRequest
+	req.ClientToken = clientToken
+	diskTags := make([]ecs.CreateDiskTag, 0, len(tags))
+	for _, k := range sortedKeys(tags) {
+		diskTags = append(diskTags, ecs.CreateDiskTag{Key: k, Value: tags[k]})
+	}
+	req.Tag = &diskTags
+	resp, err := c.ecs.CreateDisk(req)
+	if err != nil {
+		return "", apiError(err)
+	}
+	return resp.DiskId, nil
+}
+
+// diskFilter selects the disks of describeDisks
+type diskFilter struct {
+	ids        []string
+	zone       string
+	instanceID string
+	tags       map[string]string
+}
+
+// describeDisks calls fn with every disk matching the given filter one page
+// at a time. It stops when fn returns false.
+func (c *client) describeDisks(filter *diskFilter, fn func(disk *ecs.Disk) bool) error {
+	req := ecs.CreateDescribeDisksRequest()
+	if len(filter.ids) > 0 {
+		ids, err := json.Marshal(filter.ids)
+		if err != nil {
+			return err
+		}
+		req.DiskIds = string(ids)
+	}
+	req.ZoneId = filter.zone
+	req.InstanceId = filter.instanceID
+	// Callers match the tags again, so more tags than the API filters on
+	// are only matched locally
+	if len(filter.tags) > 0 && len(filter.tags) <= maxListTags {
+		tags := make([]ecs.DescribeDisksTag, 0, len(filter.tags))
+		for _, k := range sortedKeys(filter.tags) {
+			tags = append(tags, ecs.DescribeDisksTag{Key: k, Value: filter.tags[k]})
+		}
+		req.Tag = &tags
+	}
+	req.PageSize = requests.NewInteger(listPageSize)
+	for page := 1; ; page++ {
+		req.PageNumber = requests.NewInteger(page)
+		resp, err := c.ecs.DescribeDisks(req)
+		if err != nil {
+			return apiError(err)
+		}
+		disks := resp.Disks.Disk
+		for i := range disks {
+			if !fn(&disks[i]) {
+				return nil
+			}
+		}
+		if len(disks) == 0 || page*listPageSize >= resp.TotalCount {
+			return nil
+		}
+	}
+}
+
+// getDisk returns the given disk, or an InvalidDiskId.NotFound error
+func (c *client) getDisk(id string) (*ecs.Disk, error) {
+	var found *ecs.Disk
+	err := c.describeDisks(&diskFilter{ids: []string{id}}, func(disk *ecs.Disk) bool {
+		found = disk
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, &APIError{StatusCode: http.StatusNotFound, Code: "InvalidDiskId.NotFound",
+			Message: fmt.Sprintf("disk %s does not exist", id)}
+	}
+	return found, nil
+}
+
+// resizeDisk resizes the given disk, online if it is in use
+func (c *client) resizeDisk(id string, sizeGiB uint64, online bool) error {
+	req := ecs.CreateResizeDiskRequest()
+	req.DiskId = id
+	req.NewSize = requests.NewInteger64(int64(sizeGiB))
+	if online {
+		req.Type = "online"
+	}
+	_, err := c.ecs.ResizeDisk(req)
+	return apiError(err)
+}
+
+// modifyDiskSpec changes the category, performance level or provisioned
+// IOPS of the given disk to those set
+func (c *client) modifyDiskSpec(id, category, level string, iops int64) error {
+	req := ecs.CreateModifyDiskSpecRequest()
+	req.DiskId = id
+	req.DiskCategory = category
+	req.PerformanceLevel = level
+	if iops > 0 {
+		req.ProvisionedIops = requests.NewInteger64(iops)
+	}
+	_, err := c.ecs.ModifyDiskSpec(req)
+	return apiError(err)
+}
+
+func (c *client) attachDisk(id, instanceID string) error {
+	req := ecs.CreateAttachDiskRequest()
+	req.DiskId = id
+	req.InstanceId = instanceID
+	_, err := c.ecs.AttachDisk(req)
+	return apiError(err)
+}
+
+func (c *client) detachDisk(id, instanceID string) error {
+	req := ecs.CreateDetachDiskRequest()
+	req.DiskId = id
+	req.InstanceId = instanceID
+	_, err := c.ecs.DetachDisk(req)
+	return apiError(err)
+}
+
+func (c *client) deleteDisk(id string) error {
+	req := ecs.CreateDeleteDiskRequest()
+	req.DiskId = id
+	_, err := c.ecs.DeleteDisk(req)
+	return apiError(err)
+}
+
+// describeSnapshots calls fn with every snapshot of the given disk, or with
+// the given IDs, one page at a time. It stops when fn returns false.
+func (c *client) describeSnapshots(
+	diskID string,
+	ids []string,
+	fn func(snap *ecs.Snapshot) bool,
+) error {
+	req := ecs.CreateDescribeSnapshotsRequest()
+	req.DiskId = diskID
+	if len(ids) > 0 {
+		data, err := json.Marshal(ids)
+		if err != nil {
+			return err
+		}
+		req.SnapshotIds = string(data)
+	}
+	req.PageSize = requests.NewInteger(listPageSize)
+	for page := 1; ; page++ {
+		req.PageNumber = requests.NewInteger(page)
+		resp, err := c.ecs.DescribeSnapshots(req)
+		if err != nil {
+			return apiError(err)
+		}
+		snaps := resp.Snapshots.Snapshot
+		for i := range snaps {
+			if !fn(&snaps[i]) {
+				return nil
+			}
+		}
+		if len(snaps) == 0 || page*listPageSize >= resp.TotalCount {
+			return nil
+		}
+	}
+}
+
+// getSnapshot returns the given snapshot, or an InvalidSnapshotId.NotFound
+// error
+func (c *client) getSnapshot(id string) (*ecs.Snapshot, error) {
+	var found *ecs.Snapshot
+	err := c.describeSnapshots("", []string{id}, func(snap *ecs.Snapshot) bool {
+		found = snap
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, &APIError{StatusCode: http.StatusNotFound, Code: "InvalidSnapshotId.NotFound",
+			Message: fmt.Sprintf("snapshot %s does not exist", id)}
+	}
+	return found, nil
+}
+
+func (c *client) createSnapshot(diskID, name string, tags map[string]string) (string, error) {
+	req := ecs.CreateCreateSnapshotRequest()
+	req.DiskId = diskID
+	req.SnapshotName = name
+	snapTags := make([]ecs.CreateSnapshotTag, 0, len(tags))
+	for _, k := range sortedKeys(tags) {
+		snapTags = append(snapTags, ecs.CreateSnapshotTag{Key: k, Value: tags[k]})
+	}
+	req.Tag = &snapTags
+	resp, err := c.ecs.CreateSnapshot(req)
+	if err != nil {
+		return "", apiError(err)
+	}
+	return resp.SnapshotId, nil
+}
+
+func (c *client) deleteSnapshot(id string) error {
+	req := ecs.CreateDeleteSnapshotRequest()
+	req.SnapshotId = id
+	_, err := c.ecs.DeleteSnapshot(req)
+	return apiError(err)
+}
+
+func (c *client) tagResource(resourceType, id string, tags map[string]string) error {
+	req := ecs.CreateTagResourcesRequest()
+	req.ResourceType = resourceType
+	req.ResourceId = &[]string{id}
+	resourceTags := make([]ecs.TagResourcesTag, 0, len(tags))
+	for _, k := range sortedKeys(tags) {
+		resourceTags = append(resourceTags, ecs.TagResourcesTag{Key: k, Value: tags[k]})
+	}
+	req.Tag = &resourceTags
+	_, err := c.ecs.TagResources(req)
+	return apiError(err)
+}
+
+func (c *client) untagResource(resourceType, id string, keys []string) error {
+	req := ecs.CreateUntagResourcesRequest()
+	req.ResourceType = resourceType
+	req.ResourceId = &[]string{id}
+	sort.Strings(keys)
+	req.TagKey = &keys
+	_, err := c.ecs.UntagResources(req)
+	return apiError(err)
+}
+
+func (c *client) describeInstance(id string) (*ecs.Instance, error) {
+	ids, err := json.Marshal([]string{id})
+	if err != nil {
+		return nil, err
+	}
+	req := ecs.CreateDescribeInstancesRequest()
+	req.InstanceIds = string(ids)
+	resp, err := c.ecs.DescribeInstances(req)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	if len(resp.Instances.Instance) == 0 {
+		return nil, &APIError{StatusCode: http.StatusNotFound, Code: "InvalidInstanceId.NotFound",
+			Message: fmt.Sprintf("instance %s does not exist", id)}
+	}
+	return &resp.Instances.Instance[0], nil
+}
+
+func (c *client) describeZones() ([]string, error) {
+	req := ecs.CreateDescribeZonesRequest()
+	resp, err := c.ecs.DescribeZones(req)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	var zones []string
+	for _, z := range resp.Zones.Zone {
+		zones = append(zones, z.ZoneId)
+	}
+	return zones, nil
+}
+
+// sortedKeys returns the keys of the given tags in order, so that calls with
+// the same tags send the same parameters
+func sortedKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
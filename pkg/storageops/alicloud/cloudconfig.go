@@ -0,0 +1,55 @@
+package alicloud
+
+import (
+	"fmt"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/libopenstorage/openstorage/pkg/storageops/config"
+)
+
+func init() {
+	config.Register("alicloud", newFromCloudConfig)
+}
+
+// newFromCloudConfig creates the driver of the given cloud config with the
+// AccessKey of its accessKeyID and accessKeySecret credentials, and the STS
+// token of its securityToken credential if set. Credentials the config does
+// not refer to are read from the environment vars of NewEnvClient. The instance, region and
+// zone are read from the instance metadata service unless set, the endpoint
+// param replaces the ECS API endpoint.
+func newFromCloudConfig(c *config.Config) (storageops.Ops, error) {
+	keyID, err := c.RequiredCredential("accessKeyID", "ALIBABA_CLOUD_ACCESS_KEY_ID")
+	if err != nil {
+		return nil, err
+	}
+	secret, err := c.RequiredCredential("accessKeySecret", "ALIBABA_CLOUD_ACCESS_KEY_SECRET")
+	if err != nil {
+		return nil, err
+	}
+	cfg := Config{Endpoint: c.Params["endpoint"]}
+	token, ok, err := c.Credential("securityToken")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		token, _ = storageops.GetEnvValueStrict("ALIBABA_CLOUD_SECURITY_TOKEN")
+	}
+	cfg.SecurityToken = token
+	if cfg.RetryPolicy, err = c.RetryPolicy(); err != nil {
+		return nil, err
+	}
+	if cfg.HTTP, err = c.HTTPConfig(); err != nil {
+		return nil, err
+	}
+
+	values := [3]string{c.Instance, c.Region, c.Zone}
+	for i, key := range []string{"instance-id", "region-id", "zone-id"} {
+		if len(values[i]) > 0 {
+			continue
+		}
+		if values[i], err = metadata(key); err != nil {
+			return nil, fmt.Errorf("error fetching instance %s. Err: %v", key, err)
+		}
+	}
+	return NewClient(keyID, secret, values[0], values[1], values[2], cfg)
+}
@@ -0,0 +1,115 @@
+package alicloud
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+func init() {
+	storageops.RegisterConverter("alicloud", &converter{})
+	storageops.RegisterSizeRule("alicloud", &storageops.SizeRule{
+		Granularity: storageops.GiB,
+		Min:         20 * storageops.GiB,
+		Max:         32 * storageops.TiB,
+	})
+}
+
+type converter struct{}
+
+func (c *converter) ToVolume(raw interface{}) (*storageops.Volume, error) {
+	disk, ok := raw.(*ecs.Disk)
+	if !ok {
+		return nil, fmt.Errorf("invalid alicloud disk %T", raw)
+	}
+	return toVolume(disk), nil
+}
+
+func toVolume(disk *ecs.Disk) *storageops.Volume {
+	v := &storageops.Volume{
+		ID:        disk.DiskId,
+		Name:      disk.DiskName,
+		SizeGiB:   uint64(disk.Size),
+		Type:      disk.Category,
+		Zone:      disk.ZoneId,
+		State:     disk.Status,
+		Encrypted: disk.Encrypted,
+		Labels:    tagsToLabels(disk.Tags.Tag),
+		Raw:       disk,
+	}
+	if len(disk.InstanceId) > 0 {
+		v.AttachedTo = []string{disk.InstanceId}
+		v.Attachments = []storageops.Attachment{{
+			InstanceID: disk.InstanceId,
+			Device:     disk.Device,
+		}}
+	}
+	return v
+}
+
+// FromSpec returns the *ecs.CreateDiskRequest template of the given spec. The
+// type is the disk category.
+func (c *converter) FromSpec(spec *storageops.VolumeSpec) (interface{}, error) {
+	if err := spec.Unsupported("alicloud", storageops.SpecFieldThroughput); err != nil {
+		return nil, err
+	}
+	req := &ecs.CreateDiskRequest{}
+	if spec.Native != nil {
+		native, ok := spec.Native.(*ecs.CreateDiskRequest)
+		if !ok {
+			return nil, storageops.NativeTemplateError("alicloud", spec.Native)
+		}
+		copied := *native
+		req = &copied
+	}
+	if spec.SizeGiB > 0 {
+		req.Size = requests.NewInteger64(int64(spec.SizeGiB))
+	}
+	if len(spec.Type) > 0 {
+		req.DiskCategory = spec.Type
+	}
+	if spec.Iops > 0 {
+		req.ProvisionedIops = requests.NewInteger64(spec.Iops)
+	}
+	if len(spec.Zone) > 0 {
+		req.ZoneId = spec.Zone
+	}
+	if spec.Encrypted || len(spec.KMSKeyID) > 0 {
+		req.Encrypted = requests.NewBoolean(true)
+	}
+	if len(spec.KMSKeyID) > 0 {
+		req.KMSKeyId = spec.KMSKeyID
+	}
+	if len(spec.SnapshotID) > 0 {
+		req.SnapshotId = spec.SnapshotID
+	}
+	return req, nil
+}
+
+func (c *converter) ToSnapshot(raw interface{}) (*storageops.Snapshot, error) {
+	snap, ok := raw.(*ecs.Snapshot)
+	if !ok {
+		return nil, fmt.Errorf("invalid alicloud snapshot %T", raw)
+	}
+	return toSnapshot(snap)
+}
+
+func toSnapshot(snap *ecs.Snapshot) (*storageops.Snapshot, error) {
+	created, err := parseTime(snap.CreationTime)
+	if err != nil {
+		return nil, err
+	}
+	size, _ := strconv.ParseUint(snap.SourceDiskSize, 10, 64)
+	return &storageops.Snapshot{
+		ID:       snap.SnapshotId,
+		VolumeID: snap.SourceDiskId,
+		SizeGiB:  size,
+		State:    snap.Status,
+		Created:  created,
+		Labels:   tagsToLabels(snap.Tags.Tag),
+		Raw:      snap,
+	}, nil
+}
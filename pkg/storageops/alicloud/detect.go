@@ -0,0 +1,14 @@
+package alicloud
+
+import (
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+func init() {
+	storageops.RegisterDetector("alicloud", &storageops.Detector{
+		Probe: func() error {
+			return storageops.ProbeHTTP(metadataEndpoint+"/instance-id", nil)
+		},
+		New: NewEnvClient,
+	})
+}
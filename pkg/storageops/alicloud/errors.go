@@ -0,0 +1,52 @@
+package alicloud
+
+import (
+	"net/http"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+// errorCodes maps the error codes of ECS API errors to storage error codes,
+// they take precedence over the HTTP status
+var errorCodes = map[string]int{
+	"InvalidDiskId.NotFound":           storageops.ErrVolNotFound,
+	"InvalidSnapshotId.NotFound":       storageops.ErrVolNotFound,
+	"InvalidInstanceId.NotFound":       storageops.ErrVolNotFound,
+	"Throttling":                       storageops.ErrThrottled,
+	"Throttling.User":                  storageops.ErrThrottled,
+	"Throttling.Api":                   storageops.ErrThrottled,
+	"QuotaExceed.Snapshot":             storageops.ErrQuotaExceeded,
+	"QuotaExceed.Disk":                 storageops.ErrQuotaExceeded,
+	"InvalidAccessKeyId.NotFound":      storageops.ErrUnauthorized,
+	"SignatureDoesNotMatch":            storageops.ErrUnauthorized,
+	"Forbidden.RAM":                    storageops.ErrUnauthorized,
+	"IncorrectDiskStatus":              storageops.ErrVolInval,
+	"IncorrectDiskStatus.Initializing": storageops.ErrVolInval,
+	"InvalidParameter":                 storageops.ErrVolInval,
+	"DependencyViolation":              storageops.ErrDeviceBusy,
+}
+
+// errorStatuses maps the HTTP status of ECS API errors to storage error codes
+var errorStatuses = map[int]int{
+	http.StatusNotFound:        storageops.ErrVolNotFound,
+	http.StatusTooManyRequests: storageops.ErrThrottled,
+	http.StatusUnauthorized:    storageops.ErrUnauthorized,
+	http.StatusForbidden:       storageops.ErrUnauthorized,
+	http.StatusBadRequest:      storageops.ErrVolInval,
+}
+
+// storageError maps an error returned by the ECS API to a storage error with
+// the matching code. Errors without a matching code are returned unchanged.
+func (s *aliOps) storageError(err error) error {
+	apiErr, ok := err.(*APIError)
+	if !ok {
+		return err
+	}
+	if code, ok := errorCodes[apiErr.Code]; ok {
+		return storageops.WrapError(code, err, s.instanceID)
+	}
+	if code, ok := errorStatuses[apiErr.StatusCode]; ok {
+		return storageops.WrapError(code, err, s.instanceID)
+	}
+	return err
+}
@@ -0,0 +1,274 @@
+package anf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/netapp/mgmt/2021-10-01/netapp"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+const (
+	// protocolNFSv3 is the protocol volumes are exported with
+	protocolNFSv3 = "NFSv3"
+	// defaultServiceLevel is used when the spec does not request a tier
+	defaultServiceLevel = netapp.ServiceLevelStandard
+	// provisioningSucceeded is the provisioning state of ready volumes
+	provisioningSucceeded = "Succeeded"
+	// operationTimeout is how long to wait for NetApp operations, which take
+	// several minutes to provision a volume
+	operationTimeout       = 20 * time.Minute
+	operationRetryInterval = 10 * time.Second
+	gib                    = int64(1) << 30
+)
+
+// Config is the configuration of the Azure NetApp Files driver
+type Config struct {
+	// SubscriptionID of the NetApp account
+	SubscriptionID string
+	// ResourceGroup of the NetApp account
+	ResourceGroup string
+	// Location, i.e. the region, of the NetApp account
+	Location string
+	// Account is the name of the NetApp account
+	Account string
+	// Pool is the name of the capacity pool volumes are provisioned in
+	Pool string
+	// Subnet is the resource ID of the delegated subnet volumes are exported
+	// on unless their spec sets a network
+	Subnet string
+	// Authorizer authorizes the requests to the resource manager
+	Authorizer autorest.Authorizer
+	// Endpoint replaces the resource manager endpoint
+	Endpoint string
+}
+
+type anfOps struct {
+	client        netapp.VolumesClient
+	resourceGroup string
+	location      string
+	account       string
+	pool          string
+	subnet        string
+}
+
+// NewEnvClient creates an Azure NetApp Files file operations client from
+// the AZURE_* environment variables, authenticating with the client secret
+// of a service principal
+func NewEnvClient() (storageops.FileOps, error) {
+	cfg := Config{}
+	for _, env := range []struct {
+		name  string
+		value *string
+	}{
+		{"AZURE_SUBSCRIPTION_ID", &cfg.SubscriptionID},
+		{"AZURE_RESOURCE_GROUP", &cfg.ResourceGroup},
+		{"AZURE_LOCATION", &cfg.Location},
+		{"AZURE_NETAPP_ACCOUNT", &cfg.Account},
+		{"AZURE_NETAPP_POOL", &cfg.Pool},
+	} {
+		if *env.value = os.Getenv(env.name); len(*env.value) == 0 {
+			return nil, fmt.Errorf("env variable %s is not set", env.name)
+		}
+	}
+	cfg.Subnet = os.Getenv("AZURE_NETAPP_SUBNET")
+
+	oauthConfig, err := adal.NewOAuthConfig(
+		azure.PublicCloud.ActiveDirectoryEndpoint, os.Getenv("AZURE_TENANT_ID"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure azure authentication. Err: %v", err)
+	}
+	token, err := adal.NewServicePrincipalToken(*oauthConfig,
+		os.Getenv("AZURE_CLIENT_ID"), os.Getenv("AZURE_CLIENT_SECRET"),
+		azure.PublicCloud.ResourceManagerEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to authenticate with azure api. Err: %v", err)
+	}
+	cfg.Authorizer = autorest.NewBearerAuthorizer(token)
+	return NewClient(cfg)
+}
+
+// NewClient creates an Azure NetApp Files file operations client that
+// provisions volumes in the capacity pool of the given config
+func NewClient(cfg Config) (storageops.FileOps, error) {
+	if len(cfg.SubscriptionID) == 0 || len(cfg.ResourceGroup) == 0 ||
+		len(cfg.Account) == 0 || len(cfg.Pool) == 0 {
+		return nil, fmt.Errorf("subscription, resource group, account and pool " +
+			"of the azure netapp files driver are required")
+	}
+	endpoint := cfg.Endpoint
+	if len(endpoint) == 0 {
+		endpoint = netapp.DefaultBaseURI
+	}
+	client := netapp.NewVolumesClientWithBaseURI(endpoint, cfg.SubscriptionID)
+	if cfg.Authorizer != nil {
+		client.Authorizer = cfg.Authorizer
+	}
+	client.PollingDelay = operationRetryInterval
+	client.PollingDuration = operationTimeout
+
+	return &anfOps{
+		client:        client,
+		resourceGroup: cfg.ResourceGroup,
+		location:      cfg.Location,
+		account:       cfg.Account,
+		pool:          cfg.Pool,
+		subnet:        cfg.Subnet,
+	}, nil
+}
+
+func (s *anfOps) Name() string { return "anf" }
+
+// mapError maps not found responses of the resource manager to storage
+// errors
+func (s *anfOps) mapError(id string, err error) error {
+	var de autorest.DetailedError
+	if errors.As(err, &de) && de.StatusCode == http.StatusNotFound {
+		return storageops.NewStorageError(storageops.ErrVolNotFound,
+			fmt.Sprintf("netapp volume %s not found", id), "")
+	}
+	return err
+}
+
+func tags(labels map[string]string) map[string]*string {
+	t := make(map[string]*string, len(labels))
+	for k, v := range labels {
+		t[k] = to.StringPtr(v)
+	}
+	return t
+}
+
+func exportPolicyRules(rules []storageops.FileExportRule) *[]netapp.ExportPolicyRule {
+	policy := []netapp.ExportPolicyRule{}
+	for i, r := range rules {
+		policy = append(policy, netapp.ExportPolicyRule{
+			RuleIndex:      to.Int32Ptr(int32(i + 1)),
+			UnixReadOnly:   to.BoolPtr(r.ReadOnly),
+			UnixReadWrite:  to.BoolPtr(!r.ReadOnly),
+			Nfsv3:          to.BoolPtr(true),
+			Nfsv41:         to.BoolPtr(false),
+			AllowedClients: to.StringPtr(strings.Join(r.IPRanges, ",")),
+			HasRootAccess:  to.BoolPtr(!r.RootSquash),
+		})
+	}
+	return &policy
+}
+
+func (s *anfOps) fileVolume(id string, vol *netapp.Volume) *storageops.FileVolume {
+	v := &storageops.FileVolume{
+		ID:  id,
+		Raw: vol,
+	}
+	props := vol.VolumeProperties
+	if props == nil {
+		return v
+	}
+	if props.UsageThreshold != nil {
+		v.CapacityGiB = uint64(*props.UsageThreshold / gib)
+	}
+	if to.String(props.ProvisioningState) == provisioningSucceeded &&
+		props.MountTargets != nil && len(*props.MountTargets) > 0 &&
+		(*props.MountTargets)[0].IPAddress != nil {
+		v.Mount = &storageops.FileMountInfo{
+			Protocol: "nfs",
+			Server:   *(*props.MountTargets)[0].IPAddress,
+			Path:     "/" + to.String(props.CreationToken),
+		}
+	}
+	return v
+}
+
+func (s *anfOps) Provision(spec *storageops.FileVolumeSpec) (*storageops.FileVolume, error) {
+	subnet := spec.Network
+	if len(subnet) == 0 {
+		subnet = s.subnet
+	}
+	if len(subnet) == 0 {
+		return nil, storageops.NewStorageError(storageops.ErrVolInval,
+			"a delegated subnet is required to provision a netapp volume", "")
+	}
+	serviceLevel := netapp.ServiceLevel(spec.Tier)
+	if len(serviceLevel) == 0 {
+		serviceLevel = defaultServiceLevel
+	}
+	vol := netapp.Volume{
+		Location: to.StringPtr(s.location),
+		Tags:     tags(spec.Labels),
+		VolumeProperties: &netapp.VolumeProperties{
+			CreationToken:  to.StringPtr(spec.Name),
+			ServiceLevel:   serviceLevel,
+			UsageThreshold: to.Int64Ptr(int64(spec.CapacityGiB) * gib),
+			ProtocolTypes:  &[]string{protocolNFSv3},
+			SubnetID:       to.StringPtr(subnet),
+		},
+	}
+	if len(spec.ExportRules) > 0 {
+		vol.ExportPolicy = &netapp.VolumePropertiesExportPolicy{
+			Rules: exportPolicyRules(spec.ExportRules),
+		}
+	}
+
+	ctx := context.Background()
+	future, err := s.client.CreateOrUpdate(ctx, vol,
+		s.resourceGroup, s.account, s.pool, spec.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := future.WaitForCompletionRef(ctx, s.client.Client); err != nil {
+		return nil, err
+	}
+	return s.Inspect(spec.Name)
+}
+
+func (s *anfOps) Inspect(id string) (*storageops.FileVolume, error) {
+	vol, err := s.client.Get(context.Background(),
+		s.resourceGroup, s.account, s.pool, id)
+	if err != nil {
+		return nil, s.mapError(id, err)
+	}
+	return s.fileVolume(id, &vol), nil
+}
+
+// update applies the given patch to the volume
+func (s *anfOps) update(id string, patch *netapp.VolumePatchProperties) error {
+	ctx := context.Background()
+	future, err := s.client.Update(ctx,
+		netapp.VolumePatch{VolumePatchProperties: patch},
+		s.resourceGroup, s.account, s.pool, id)
+	if err != nil {
+		return s.mapError(id, err)
+	}
+	return s.mapError(id, future.WaitForCompletionRef(ctx, s.client.Client))
+}
+
+func (s *anfOps) Resize(id string, capacityGiB uint64) error {
+	return s.update(id, &netapp.VolumePatchProperties{
+		UsageThreshold: to.Int64Ptr(int64(capacityGiB) * gib),
+	})
+}
+
+func (s *anfOps) SetExportRules(id string, rules []storageops.FileExportRule) error {
+	return s.update(id, &netapp.VolumePatchProperties{
+		ExportPolicy: &netapp.VolumePatchPropertiesExportPolicy{
+			Rules: exportPolicyRules(rules),
+		},
+	})
+}
+
+func (s *anfOps) Delete(id string) error {
+	ctx := context.Background()
+	future, err := s.client.Delete(ctx, s.resourceGroup, s.account, s.pool, id, nil)
+	if err != nil {
+		return s.mapError(id, err)
+	}
+	return s.mapError(id, future.WaitForCompletionRef(ctx, s.client.Client))
+}
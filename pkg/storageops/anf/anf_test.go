@@ -0,0 +1,225 @@
+package anf
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPool = "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.NetApp/" +
+	"netAppAccounts/account/capacityPools/pool/volumes/"
+
+type fakeVolume struct {
+	Location   string                 `json:"location"`
+	Tags       map[string]string      `json:"tags,omitempty"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// fakeAPI is an in memory resource manager API of NetApp volumes. Operations
+// complete right away unless pending is set, in which case they complete on
+// the first poll of their asynchronous operation.
+type fakeAPI struct {
+	t       *testing.T
+	url     string
+	volumes map[string]*fakeVolume
+	pending bool
+	failed  bool
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	assert.Equal(f.t, "Bearer token", r.Header.Get("Authorization"))
+	reply := func(status int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		assert.NoError(f.t, json.NewEncoder(w).Encode(v))
+	}
+
+	if r.URL.Path == "/operations/1" {
+		op := map[string]interface{}{"status": "Succeeded"}
+		if f.failed {
+			op = map[string]interface{}{"status": "Failed",
+				"error": map[string]string{"code": "QuotaExceeded", "message": "quota exceeded"}}
+		}
+		reply(http.StatusOK, op)
+		return
+	}
+	assert.Equal(f.t, "2021-10-01", r.URL.Query().Get("api-version"))
+	if !strings.HasPrefix(r.URL.Path, testPool) {
+		reply(http.StatusBadRequest, map[string]interface{}{})
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, testPool)
+	vol, ok := f.volumes[name]
+	state := http.StatusOK
+	if f.pending {
+		w.Header().Set("Azure-AsyncOperation", f.url+"/operations/1")
+		w.Header().Set("Retry-After", "0")
+		state = http.StatusAccepted
+	}
+
+	switch {
+	case r.Method == http.MethodPut:
+		vol = &fakeVolume{}
+		assert.NoError(f.t, json.NewDecoder(r.Body).Decode(vol))
+		vol.Properties["provisioningState"] = "Succeeded"
+		vol.Properties["mountTargets"] = []map[string]string{{"ipAddress": "10.0.0.4"}}
+		f.volumes[name] = vol
+		if f.pending {
+			state = http.StatusCreated
+		}
+		reply(state, vol)
+	case !ok:
+		reply(http.StatusNotFound, map[string]interface{}{
+			"error": map[string]string{"code": "ResourceNotFound", "message": "not found"}})
+	case r.Method == http.MethodGet:
+		reply(http.StatusOK, vol)
+	case r.Method == http.MethodPatch:
+		patch := &fakeVolume{}
+		assert.NoError(f.t, json.NewDecoder(r.Body).Decode(patch))
+		for k, v := range patch.Properties {
+			vol.Properties[k] = v
+		}
+		reply(state, vol)
+	case r.Method == http.MethodDelete:
+		delete(f.volumes, name)
+		reply(state, map[string]interface{}{})
+	}
+}
+
+func newTestClient(t *testing.T, subnet string) (storageops.FileOps, *fakeAPI, *httptest.Server) {
+	api := &fakeAPI{t: t, volumes: make(map[string]*fakeVolume)}
+	server := httptest.NewServer(api)
+	api.url = server.URL
+	s, err := NewClient(Config{
+		SubscriptionID: "sub",
+		ResourceGroup:  "rg",
+		Location:       "westeurope",
+		Account:        "account",
+		Pool:           "pool",
+		Subnet:         subnet,
+		Authorizer: autorest.NewAPIKeyAuthorizerWithHeaders(
+			map[string]interface{}{"Authorization": "Bearer token"}),
+		Endpoint: server.URL,
+	})
+	require.NoError(t, err)
+	return s, api, server
+}
+
+func TestEnv(t *testing.T) {
+	s, err := NewEnvClient()
+	if err != nil {
+		t.Skipf("skipping Azure NetApp Files tests as environment is not set...\n")
+	}
+	name := fmt.Sprintf("openstorage-test-%s", uuid.New()[:8])
+	vol, err := s.Provision(&storageops.FileVolumeSpec{Name: name, CapacityGiB: 100})
+	require.NoError(t, err)
+	require.NotNil(t, vol.Mount)
+	require.NoError(t, s.Resize(name, 200))
+	vol, err = s.Inspect(name)
+	require.NoError(t, err)
+	require.Equal(t, uint64(200), vol.CapacityGiB)
+	require.NoError(t, s.Delete(name))
+}
+
+func TestNewClient(t *testing.T) {
+	_, err := NewClient(Config{SubscriptionID: "sub", ResourceGroup: "rg", Account: "account"})
+	require.Error(t, err, "the capacity pool is required")
+}
+
+func TestANF(t *testing.T) {
+	s, api, server := newTestClient(t, "subnet-1")
+	defer server.Close()
+
+	vol, err := s.Provision(&storageops.FileVolumeSpec{
+		Name:        "share-1",
+		CapacityGiB: 100,
+		Labels:      map[string]string{"app": "db"},
+		ExportRules: []storageops.FileExportRule{
+			{IPRanges: []string{"10.0.0.0/24", "10.0.1.0/24"}},
+			{IPRanges: []string{"10.1.0.0/24"}, ReadOnly: true, RootSquash: true},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "share-1", vol.ID)
+	require.Equal(t, uint64(100), vol.CapacityGiB)
+	require.Equal(t, &storageops.FileMountInfo{
+		Protocol: "nfs",
+		Server:   "10.0.0.4",
+		Path:     "/share-1",
+	}, vol.Mount)
+
+	created := api.volumes["share-1"]
+	require.Equal(t, "westeurope", created.Location)
+	require.Equal(t, map[string]string{"app": "db"}, created.Tags)
+	require.Equal(t, "subnet-1", created.Properties["subnetId"])
+	require.Equal(t, "share-1", created.Properties["creationToken"])
+	require.Equal(t, []interface{}{"NFSv3"}, created.Properties["protocolTypes"])
+	require.Equal(t, "Standard", created.Properties["serviceLevel"],
+		"the standard service level applies unless the spec sets a tier")
+	rules := created.Properties["exportPolicy"].(map[string]interface{})["rules"].([]interface{})
+	require.Len(t, rules, 2)
+	require.Equal(t, map[string]interface{}{
+		"ruleIndex":      float64(1),
+		"unixReadOnly":   false,
+		"unixReadWrite":  true,
+		"nfsv3":          true,
+		"nfsv41":         false,
+		"allowedClients": "10.0.0.0/24,10.0.1.0/24",
+		"hasRootAccess":  true,
+	}, rules[0])
+	require.Equal(t, true, rules[1].(map[string]interface{})["unixReadOnly"])
+	require.Equal(t, false, rules[1].(map[string]interface{})["hasRootAccess"])
+
+	require.NoError(t, s.Resize("share-1", 200))
+	vol, err = s.Inspect("share-1")
+	require.NoError(t, err)
+	require.Equal(t, uint64(200), vol.CapacityGiB)
+
+	require.NoError(t, s.SetExportRules("share-1", []storageops.FileExportRule{
+		{IPRanges: []string{"0.0.0.0/0"}, ReadOnly: true},
+	}))
+	rules = created.Properties["exportPolicy"].(map[string]interface{})["rules"].([]interface{})
+	require.Len(t, rules, 1)
+	require.Equal(t, "0.0.0.0/0", rules[0].(map[string]interface{})["allowedClients"])
+
+	require.NoError(t, s.Delete("share-1"))
+	_, err = s.Inspect("share-1")
+	require.True(t, storageops.IsErrorCode(err, storageops.ErrVolNotFound))
+	err = s.Resize("share-1", 300)
+	require.True(t, storageops.IsErrorCode(err, storageops.ErrVolNotFound))
+}
+
+func TestANFOperations(t *testing.T) {
+	s, api, server := newTestClient(t, "")
+	defer server.Close()
+
+	_, err := s.Provision(&storageops.FileVolumeSpec{Name: "share-1", CapacityGiB: 100})
+	require.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval),
+		"a subnet is required if the driver has no default")
+
+	api.pending = true
+	vol, err := s.Provision(&storageops.FileVolumeSpec{
+		Name:        "share-1",
+		CapacityGiB: 100,
+		Tier:        "Premium",
+		Network:     "subnet-2",
+	})
+	require.NoError(t, err, "pending operations must be polled until done")
+	require.NotNil(t, vol.Mount)
+	require.Equal(t, "Premium", api.volumes["share-1"].Properties["serviceLevel"])
+	require.Equal(t, "subnet-2", api.volumes["share-1"].Properties["subnetId"])
+
+	api.failed = true
+	err = s.Resize("share-1", 200)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "quota exceeded")
+}
@@ -0,0 +1,232 @@
+package storageops
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/portworx/kvdb"
+	"github.com/sirupsen/logrus"
+)
+
+// driveKeyPrefix is the kvdb prefix of the drive records
+const driveKeyPrefix = "storageops/drives/"
+
+// DriveRecord is the kvdb record of a drive managed by openstorage
+type DriveRecord struct {
+	// VolumeID of the drive
+	VolumeID string `json:"volumeId"`
+	// Set is the drive set the volume belongs to
+	Set string `json:"set,omitempty"`
+}
+
+// Repair is an action taken by anti-entropy to reconcile kvdb with the cloud
+type Repair struct {
+	// VolumeID of the volume the repair is about
+	VolumeID string
+	// Action is EventRecordAdd or EventRecordRemove
+	Action EventType
+	// Err is set if the repair failed
+	Err error
+}
+
+// ReconcileReport is the result of an anti-entropy check
+type ReconcileReport struct {
+	// Time the check started
+	Time time.Time
+	// MissingInCloud are the records of volumes that do not exist anymore
+	MissingInCloud []*DriveRecord
+	// MissingInKvdb are the managed volumes without a record
+	MissingInKvdb []*DriveRecord
+	// Repairs are the repairs made, if repair was requested
+	Repairs []*Repair
+}
+
+// Consistent returns true if kvdb and the cloud agreed
+func (r *ReconcileReport) Consistent() bool {
+	return len(r.MissingInCloud) == 0 && len(r.MissingInKvdb) == 0
+}
+
+func (r *ReconcileReport) String() string {
+	return fmt.Sprintf("%d records missing in cloud, %d volumes missing in kvdb, %d repairs",
+		len(r.MissingInCloud), len(r.MissingInKvdb), len(r.Repairs))
+}
+
+// AntiEntropy compares the drive records stored in kvdb against the volumes
+// the driver enumerates, in both directions. Volumes are never created or
+// deleted by a repair, only the records are changed to match the cloud.
+type AntiEntropy struct {
+	ops           Ops
+	kv            kvdb.Kvdb
+	labels        map[string]string
+	setIdentifier string
+	publisher     EventPublisher
+}
+
+// NewAntiEntropy creates an anti-entropy checker for the volumes of the
+// given driver with the given labels, whose sets are identified by
+// setIdentifier as in Enumerate. Repairs are published as events to
+// publisher, e.g. an AuditLog, which can be nil.
+func NewAntiEntropy(
+	ops Ops,
+	kv kvdb.Kvdb,
+	labels map[string]string,
+	setIdentifier string,
+	publisher EventPublisher,
+) *AntiEntropy {
+	return &AntiEntropy{
+		ops:           ops,
+		kv:            kv,
+		labels:        labels,
+		setIdentifier: setIdentifier,
+		publisher:     publisher,
+	}
+}
+
+func (a *AntiEntropy) prefix() string {
+	return driveKeyPrefix + a.ops.Name() + "/"
+}
+
+// PutRecord stores the record of a drive
+func (a *AntiEntropy) PutRecord(record *DriveRecord) error {
+	_, err := a.kv.Put(a.prefix()+record.VolumeID, record, 0)
+	return err
+}
+
+// DeleteRecord removes the record of the given drive
+func (a *AntiEntropy) DeleteRecord(volumeID string) error {
+	if _, err := a.kv.Delete(a.prefix() + volumeID); err != nil && err != kvdb.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+// Records returns the drive records keyed by volume ID
+func (a *AntiEntropy) Records() (map[string]*DriveRecord, error) {
+	kvps, err := a.kv.Enumerate(a.prefix())
+	if err != nil {
+		return nil, err
+	}
+	records := make(map[string]*DriveRecord, len(kvps))
+	for _, kvp := range kvps {
+		record := &DriveRecord{}
+		if err := json.Unmarshal(kvp.Value, record); err != nil {
+			logrus.Warnf("invalid drive record %v: %v", kvp.Key, err)
+			continue
+		}
+		records[strings.TrimPrefix(kvp.Key, a.prefix())] = record
+	}
+	return records, nil
+}
+
+// Check compares the drive records against the managed volumes and returns
+// the differences. If repair is true records of volumes that no longer exist
+// are removed and records of volumes that have none are added. Volumes that
+// are created or deleted while the check runs may be reported.
+func (a *AntiEntropy) Check(repair bool) (*ReconcileReport, error) {
+	report := &ReconcileReport{Time: time.Now()}
+	records, err := a.Records()
+	if err != nil {
+		return nil, err
+	}
+	sets, err := a.ops.Enumerate(nil, a.labels, a.setIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	cloud := make(map[string]*DriveRecord)
+	for set, vols := range sets {
+		for _, vol := range vols {
+			cloud[vol.ID] = &DriveRecord{VolumeID: vol.ID, Set: set}
+		}
+	}
+
+	for volumeID, record := range records {
+		if _, ok := cloud[volumeID]; !ok {
+			report.MissingInCloud = append(report.MissingInCloud, record)
+		}
+	}
+	for volumeID, record := range cloud {
+		if _, ok := records[volumeID]; !ok {
+			report.MissingInKvdb = append(report.MissingInKvdb, record)
+		}
+	}
+	sortRecords(report.MissingInCloud)
+	sortRecords(report.MissingInKvdb)
+
+	if repair {
+		for _, record := range report.MissingInCloud {
+			a.repair(report, EventRecordRemove, record.VolumeID, a.DeleteRecord(record.VolumeID))
+		}
+		for _, record := range report.MissingInKvdb {
+			a.repair(report, EventRecordAdd, record.VolumeID, a.PutRecord(record))
+		}
+	}
+
+	if report.Consistent() {
+		logrus.Debugf("anti-entropy of %v: %v", a.ops.Name(), report)
+	} else {
+		logrus.Warnf("anti-entropy of %v: %v", a.ops.Name(), report)
+	}
+	return report, nil
+}
+
+func (a *AntiEntropy) repair(report *ReconcileReport, action EventType, volumeID string, err error) {
+	report.Repairs = append(report.Repairs, &Repair{
+		VolumeID: volumeID,
+		Action:   action,
+		Err:      err,
+	})
+	if err != nil {
+		logrus.Warnf("anti-entropy %v of %v failed: %v", action, volumeID, err)
+	}
+	if a.publisher == nil {
+		return
+	}
+	event := &Event{
+		Type:       action,
+		Provider:   a.ops.Name(),
+		InstanceID: a.ops.InstanceID(),
+		VolumeID:   volumeID,
+		Time:       time.Now(),
+	}
+	if err != nil {
+		event.Err = err.Error()
+	}
+	if perr := a.publisher.PublishEvent(event); perr != nil {
+		logrus.Warnf("failed to publish %v event for %v: %v", action, volumeID, perr)
+	}
+}
+
+// Run checks every interval until stop is closed. Reports are passed to
+// onReport, which can be nil.
+func (a *AntiEntropy) Run(
+	interval time.Duration,
+	repair bool,
+	stop <-chan struct{},
+	onReport func(*ReconcileReport),
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			report, err := a.Check(repair)
+			if err != nil {
+				logrus.Warnf("anti-entropy of %v failed: %v", a.ops.Name(), err)
+				continue
+			}
+			if onReport != nil {
+				onReport(report)
+			}
+		}
+	}
+}
+
+func sortRecords(records []*DriveRecord) {
+	sort.Slice(records, func(i, j int) bool { return records[i].VolumeID < records[j].VolumeID })
+}
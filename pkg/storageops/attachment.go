@@ -0,0 +1,77 @@
+package storageops
+
+import (
+	"fmt"
+)
+
+const (
+	// AttachmentStateAttached is the attachment state Attached reports for
+	// drivers that do not report the state of their attachments
+	AttachmentStateAttached = "attached"
+	// attachmentStateDetached is the state of attachments that some
+	// providers still report for a while after a detach
+	attachmentStateDetached = "detached"
+)
+
+// AttachmentOps is implemented by drivers that report where a volume is
+// attached
+type AttachmentOps interface {
+	// Attached returns the instance the given volume is attached to, the
+	// device it is attached at as reported by the provider and the provider
+	// specific state of the attachment, e.g. attaching or attached. The
+	// instance is empty if the volume is not attached.
+	Attached(volumeID string) (instanceID, device, state string, err error)
+}
+
+// Attached returns the instance the given volume is attached to, the device
+// it is attached at and the state of the attachment, see AttachmentOps. The
+// instance is empty if the volume is not attached. For drivers that are not
+// AttachmentOps the Attachments of the inspected volume are used. If they
+// have no device it is only known for the instance of the driver, if they
+// have no state it is AttachmentStateAttached. Wrappers like middlewares
+// hide the AttachmentOps of the driver they wrap, see AttachBatch.
+func Attached(ops Ops, volumeID string) (instanceID, device, state string, err error) {
+	if a, ok := ops.(AttachmentOps); ok {
+		return a.Attached(volumeID)
+	}
+
+	vols, err := ops.Inspect([]*string{&volumeID})
+	if err != nil {
+		return "", "", "", err
+	}
+	if len(vols) != 1 {
+		return "", "", "", NewStorageError(ErrVolNotFound,
+			fmt.Sprintf("volume %s not found", volumeID), ops.InstanceID())
+	}
+	vol := vols[0]
+	attachments := vol.Attachments
+	if len(attachments) == 0 {
+		for _, id := range vol.AttachedTo {
+			attachments = append(attachments, Attachment{InstanceID: id})
+		}
+	}
+
+	// Prefer the instance of the driver if the volume is attached to several
+	var attachment *Attachment
+	for i, a := range attachments {
+		if a.State == attachmentStateDetached {
+			continue
+		}
+		if attachment == nil || a.InstanceID == ops.InstanceID() {
+			attachment = &attachments[i]
+		}
+	}
+	if attachment == nil {
+		return "", "", "", nil
+	}
+	instanceID, device, state = attachment.InstanceID, attachment.Device, attachment.State
+	if len(device) == 0 && instanceID == ops.InstanceID() {
+		if device, err = ops.DevicePath(volumeID); err != nil {
+			return "", "", "", err
+		}
+	}
+	if len(state) == 0 {
+		state = AttachmentStateAttached
+	}
+	return instanceID, device, state, nil
+}
@@ -0,0 +1,134 @@
+package aws
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+// AccountLabel is the volume label that selects the credential set a volume
+// is managed with
+const AccountLabel = "openstorage-account"
+
+// CredentialSet are the credentials and region of one AWS account
+type CredentialSet struct {
+	// Region of the account the volumes are in
+	Region string
+	// Credentials used for all calls made for the account
+	Credentials *credentials.Credentials
+}
+
+// AccountRegistry maps accounts and tenants to credential sets and caches one
+// storage ops client per account, so a single control plane can manage
+// volumes across multiple member accounts.
+type AccountRegistry struct {
+	sync.Mutex
+	instance       string
+	instanceType   string
+	cfg            Config
+	defaultAccount string
+	accounts       map[string]*CredentialSet
+	tenants        map[string]string
+	clients        map[string]storageops.Ops
+}
+
+// NewAccountRegistry creates a registry for the given instance. Operations
+// that do not select an account use defaultAccount.
+func NewAccountRegistry(
+	instance, instanceType string,
+	cfg Config,
+	defaultAccount string,
+) *AccountRegistry {
+	return &AccountRegistry{
+		instance:       instance,
+		instanceType:   instanceType,
+		cfg:            cfg,
+		defaultAccount: defaultAccount,
+		accounts:       make(map[string]*CredentialSet),
+		tenants:        make(map[string]string),
+		clients:        make(map[string]storageops.Ops),
+	}
+}
+
+// Register adds or replaces the credential set of the given account
+func (r *AccountRegistry) Register(account string, set *CredentialSet) error {
+	if set == nil || set.Credentials == nil || len(set.Region) == 0 {
+		return fmt.Errorf("credential set of account %s needs a region and credentials", account)
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.accounts[account] = set
+	delete(r.clients, account)
+	return nil
+}
+
+// Unregister removes the given account and any tenants mapped to it
+func (r *AccountRegistry) Unregister(account string) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.accounts, account)
+	delete(r.clients, account)
+	for tenant, a := range r.tenants {
+		if a == account {
+			delete(r.tenants, tenant)
+		}
+	}
+}
+
+// MapTenant scopes the operations of the given tenant to the given account
+func (r *AccountRegistry) MapTenant(tenant, account string) error {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.accounts[account]; !ok {
+		return fmt.Errorf("account %s is not registered", account)
+	}
+	r.tenants[tenant] = account
+	return nil
+}
+
+// Ops returns the storage ops client of the given account. An empty account
+// selects the default account.
+func (r *AccountRegistry) Ops(account string) (storageops.Ops, error) {
+	if len(account) == 0 {
+		account = r.defaultAccount
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	if ops, ok := r.clients[account]; ok {
+		return ops, nil
+	}
+	set, ok := r.accounts[account]
+	if !ok {
+		return nil, fmt.Errorf("account %s is not registered", account)
+	}
+	sess, err := newSession(r.cfg, &aws.Config{
+		Region:      &set.Region,
+		Credentials: set.Credentials,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ops := NewEc2StorageWithConfig(r.instance, r.instanceType, ec2.New(sess), r.cfg)
+	r.clients[account] = ops
+	return ops, nil
+}
+
+// OpsForTenant returns the storage ops client of the account the given
+// tenant is mapped to, or of the default account.
+func (r *AccountRegistry) OpsForTenant(tenant string) (storageops.Ops, error) {
+	r.Lock()
+	account := r.tenants[tenant]
+	r.Unlock()
+	return r.Ops(account)
+}
+
+// OpsForLabels returns the storage ops client of the account selected by the
+// AccountLabel in the given volume labels, or of the default account.
+func (r *AccountRegistry) OpsForLabels(labels map[string]string) (storageops.Ops, error) {
+	return r.Ops(labels[AccountLabel])
+}
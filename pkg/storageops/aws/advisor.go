@@ -0,0 +1,297 @@
+package aws
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+// InstanceTypeAdvisor is implemented by the AWS storage ops driver
+type InstanceTypeAdvisor interface {
+	// AdviseInstanceType reports whether the EBS limits of the instance type
+	// cap the given observed IO of the volumes attached to this instance,
+	// and the instance types that would lift the cap
+	AdviseInstanceType(observed ObservedIO) (*InstanceTypeAdvice, error)
+}
+
+// InstanceTypeLimits are the EBS limits of an instance type
+type InstanceTypeLimits struct {
+	// BandwidthMiBps is the baseline EBS bandwidth
+	BandwidthMiBps int64
+	// Iops is the baseline EBS IOPS
+	Iops int64
+	// MaxAttachments is the number of EBS volumes that can be attached,
+	// including the root volume
+	MaxAttachments int
+}
+
+// ObservedIO is the total IO of the volumes of an instance, e.g. the peak of
+// the CloudWatch EBS metrics over a day
+type ObservedIO struct {
+	// ThroughputMiBps is the read and write throughput
+	ThroughputMiBps float64
+	// Iops are the read and write operations per second
+	Iops float64
+}
+
+// InstanceTypeAdvice is the result of AdviseInstanceType
+type InstanceTypeAdvice struct {
+	// InstanceType of this instance
+	InstanceType string
+	// Limits of the instance type
+	Limits InstanceTypeLimits
+	// VolumeThroughputMiBps is the total throughput the attached volumes
+	// can deliver
+	VolumeThroughputMiBps int64
+	// VolumeIops is the total IOPS the attached volumes can deliver
+	VolumeIops int64
+	// Attachments is the number of attached volumes
+	Attachments int
+	// Bottleneck is true if the instance type limits the IO of its volumes
+	// or the number of volumes that can be attached
+	Bottleneck bool
+	// Reasons describe each limit that is a bottleneck
+	Reasons []string
+	// Candidates are the instance types whose limits cover the volumes,
+	// those of the same family first and then by bandwidth. The caller
+	// performs the migration, e.g. by stopping the instance to change its
+	// type or by moving the volumes to a new instance.
+	Candidates []string
+}
+
+const (
+	// saturationFraction is the fraction of an instance limit above which
+	// the observed IO is considered capped by it
+	saturationFraction = 0.9
+	// maxInstanceCandidates is the number of candidates AdviseInstanceType
+	// returns
+	maxInstanceCandidates = 3
+	// nitroAttachments are the attachment slots of Nitro instances, shared
+	// by the EBS volumes and the primary network interface
+	nitroAttachments = 27
+)
+
+// mbps returns the limits of an instance type documented in Mbps
+func mbps(bandwidthMbps, iops int64) InstanceTypeLimits {
+	return InstanceTypeLimits{
+		BandwidthMiBps: bandwidthMbps * 1000 * 1000 / 8 / (1024 * 1024),
+		Iops:           iops,
+		MaxAttachments: nitroAttachments,
+	}
+}
+
+// defaultInstanceTypeLimits are the baseline EBS limits of the general
+// purpose, compute and memory optimized instance types most used as storage
+// nodes. Set Config.InstanceTypeLimits for other instance types.
+// https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/ebs-optimized.html
+var defaultInstanceTypeLimits = map[string]InstanceTypeLimits{
+	"m5.large":     mbps(650, 3600),
+	"m5.xlarge":    mbps(1150, 6000),
+	"m5.2xlarge":   mbps(2300, 12000),
+	"m5.4xlarge":   mbps(4750, 18750),
+	"m5.8xlarge":   mbps(6800, 30000),
+	"m5.12xlarge":  mbps(9500, 40000),
+	"m5.16xlarge":  mbps(13600, 60000),
+	"m5.24xlarge":  mbps(19000, 80000),
+	"c5.large":     mbps(650, 4000),
+	"c5.xlarge":    mbps(1150, 6000),
+	"c5.2xlarge":   mbps(2300, 10000),
+	"c5.4xlarge":   mbps(4750, 20000),
+	"c5.9xlarge":   mbps(9500, 40000),
+	"c5.18xlarge":  mbps(19000, 80000),
+	"r5.large":     mbps(650, 3600),
+	"r5.xlarge":    mbps(1150, 6000),
+	"r5.2xlarge":   mbps(2300, 12000),
+	"r5.4xlarge":   mbps(4750, 18750),
+	"r5.8xlarge":   mbps(6800, 30000),
+	"r5.12xlarge":  mbps(9500, 40000),
+	"r5.16xlarge":  mbps(13600, 60000),
+	"r5.24xlarge":  mbps(19000, 80000),
+	"m6i.large":    mbps(650, 3600),
+	"m6i.xlarge":   mbps(1250, 6000),
+	"m6i.2xlarge":  mbps(2500, 12000),
+	"m6i.4xlarge":  mbps(5000, 20000),
+	"m6i.8xlarge":  mbps(10000, 40000),
+	"m6i.12xlarge": mbps(15000, 60000),
+	"m6i.16xlarge": mbps(20000, 80000),
+	"m6i.24xlarge": mbps(30000, 120000),
+	"m6i.32xlarge": mbps(40000, 160000),
+}
+
+// volumePerformance returns the IOPS and throughput in MiB/s the given volume
+// can sustain
+func volumePerformance(vol *ec2.Volume) (int64, int64) {
+	size := aws.Int64Value(vol.Size)
+	iops := aws.Int64Value(vol.Iops)
+	switch aws.StringValue(vol.VolumeType) {
+	case ec2.VolumeTypeGp3:
+		limits := volumeLimits[ec2.VolumeTypeGp3]
+		return maxInt64(iops, limits.baselineIops),
+			maxInt64(aws.Int64Value(vol.Throughput), limits.minThroughput)
+	case ec2.VolumeTypeGp2:
+		// 3 IOPS per GiB, throughput caps at 250 MiB/s above 170 GiB
+		iops = minInt64(maxInt64(3*size, 100), 16000)
+		if size <= 170 {
+			return iops, 128
+		}
+		return iops, 250
+	case ec2.VolumeTypeIo1:
+		return iops, minInt64(iops*io2ThroughputPerIops/1024, 1000)
+	case ec2.VolumeTypeIo2:
+		return iops, minInt64(iops*io2ThroughputPerIops/1024, 4000)
+	case ec2.VolumeTypeSt1:
+		// 40 MiB/s per TiB baseline
+		return 500, minInt64(maxInt64(40*size/1024, 1), 500)
+	case ec2.VolumeTypeSc1:
+		// 12 MiB/s per TiB baseline
+		return 250, minInt64(maxInt64(12*size/1024, 1), 250)
+	}
+	return 200, 90
+}
+
+// adviseInstanceType analyzes the given volumes of an instance of the given
+// type and its observed IO against the given instance type limits
+func adviseInstanceType(
+	instanceType string,
+	vols []*ec2.Volume,
+	observed ObservedIO,
+	limits map[string]InstanceTypeLimits,
+) (*InstanceTypeAdvice, error) {
+	current, ok := limits[instanceType]
+	if !ok {
+		return nil, storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("no EBS limits known for instance type %q, "+
+				"set Config.InstanceTypeLimits", instanceType), "")
+	}
+
+	advice := &InstanceTypeAdvice{
+		InstanceType: instanceType,
+		Limits:       current,
+		Attachments:  len(vols),
+	}
+	for _, vol := range vols {
+		iops, throughput := volumePerformance(vol)
+		advice.VolumeIops += iops
+		advice.VolumeThroughputMiBps += throughput
+	}
+
+	if observed.ThroughputMiBps >= saturationFraction*float64(current.BandwidthMiBps) &&
+		advice.VolumeThroughputMiBps > current.BandwidthMiBps {
+		advice.Reasons = append(advice.Reasons, fmt.Sprintf(
+			"observed %.0f MiB/s is capped by the %d MiB/s EBS bandwidth of %s, "+
+				"the volumes can deliver %d MiB/s", observed.ThroughputMiBps,
+			current.BandwidthMiBps, instanceType, advice.VolumeThroughputMiBps))
+	}
+	if observed.Iops >= saturationFraction*float64(current.Iops) &&
+		advice.VolumeIops > current.Iops {
+		advice.Reasons = append(advice.Reasons, fmt.Sprintf(
+			"observed %.0f IOPS are capped by the %d EBS IOPS of %s, "+
+				"the volumes can deliver %d IOPS", observed.Iops,
+			current.Iops, instanceType, advice.VolumeIops))
+	}
+	needAttachments := advice.Attachments
+	if advice.Attachments >= current.MaxAttachments {
+		needAttachments++
+		advice.Reasons = append(advice.Reasons, fmt.Sprintf(
+			"all %d volume attachments of %s are in use", current.MaxAttachments, instanceType))
+	}
+	advice.Bottleneck = len(advice.Reasons) > 0
+	if !advice.Bottleneck {
+		return advice, nil
+	}
+
+	family := instanceFamily(instanceType)
+	for name, l := range limits {
+		if name == instanceType ||
+			l.BandwidthMiBps < advice.VolumeThroughputMiBps ||
+			l.Iops < advice.VolumeIops ||
+			l.MaxAttachments < needAttachments {
+			continue
+		}
+		advice.Candidates = append(advice.Candidates, name)
+	}
+	sort.Slice(advice.Candidates, func(i, j int) bool {
+		a, b := advice.Candidates[i], advice.Candidates[j]
+		if sameA, sameB := instanceFamily(a) == family, instanceFamily(b) == family; sameA != sameB {
+			return sameA
+		}
+		if limits[a].BandwidthMiBps != limits[b].BandwidthMiBps {
+			return limits[a].BandwidthMiBps < limits[b].BandwidthMiBps
+		}
+		return a < b
+	})
+	if len(advice.Candidates) > maxInstanceCandidates {
+		advice.Candidates = advice.Candidates[:maxInstanceCandidates]
+	}
+	return advice, nil
+}
+
+// instanceFamily returns the family of the given instance type, e.g. m5 of
+// m5.xlarge
+func instanceFamily(instanceType string) string {
+	if i := strings.Index(instanceType, "."); i > 0 {
+		return instanceType[:i]
+	}
+	return instanceType
+}
+
+func (s *ec2Ops) AdviseInstanceType(observed ObservedIO) (*InstanceTypeAdvice, error) {
+	instance, err := s.describe()
+	if err != nil {
+		return nil, err
+	}
+	var ids []*string
+	for _, d := range instance.BlockDeviceMappings {
+		if d.Ebs != nil && d.Ebs.VolumeId != nil {
+			ids = append(ids, d.Ebs.VolumeId)
+		}
+	}
+	var vols []*ec2.Volume
+	if len(ids) > 0 {
+		resp, err := s.ec2.DescribeVolumes(&ec2.DescribeVolumesInput{VolumeIds: ids})
+		if err != nil {
+			return nil, s.storageError(err)
+		}
+		vols = resp.Volumes
+	}
+
+	return adviseInstanceType(aws.StringValue(instance.InstanceType), vols, observed, s.instanceTypeLimits())
+}
+
+// instanceTypeLimits returns the default instance type limits with those of
+// the configuration
+func (s *ec2Ops) instanceTypeLimits() map[string]InstanceTypeLimits {
+	if len(s.cfg.InstanceTypeLimits) == 0 {
+		return defaultInstanceTypeLimits
+	}
+	limits := make(map[string]InstanceTypeLimits)
+	for name, l := range defaultInstanceTypeLimits {
+		limits[name] = l
+	}
+	for name, l := range s.cfg.InstanceTypeLimits {
+		limits[name] = l
+	}
+	return limits
+}
+
+// maxAttachments returns the number of EBS volumes that can be attached to
+// the instance, including the root volume. Instance types without known
+// limits are assumed to have the attachment slots of Nitro instances, which
+// is below the limit of Xen instances.
+func (s *ec2Ops) maxAttachments() int {
+	if l, ok := s.instanceTypeLimits()[s.instanceType]; ok && l.MaxAttachments > 0 {
+		return l.MaxAttachments
+	}
+	return nitroAttachments
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,43 @@
+package aws
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+var _ storageops.AttachmentOps = &ec2Ops{}
+
+// Attached returns the instance the given volume is attached to, the device
+// name of the attachment, e.g. /dev/sdf, and its state. Attachments that are
+// already detached are ignored. A multi-attach volume attached to this
+// instance reports the attachment to this instance.
+func (s *ec2Ops) Attached(volumeID string) (instanceID, device, state string, err error) {
+	resp, err := s.ec2.DescribeVolumes(&ec2.DescribeVolumesInput{
+		VolumeIds: []*string{&volumeID},
+	})
+	if err != nil {
+		return "", "", "", s.storageError(err)
+	}
+	if len(resp.Volumes) != 1 {
+		return "", "", "", storageops.NewStorageError(storageops.ErrVolNotFound,
+			fmt.Sprintf("volume %s not found", volumeID), s.instance)
+	}
+
+	var attachment *ec2.VolumeAttachment
+	for _, a := range resp.Volumes[0].Attachments {
+		if aws.StringValue(a.State) == ec2.VolumeAttachmentStateDetached {
+			continue
+		}
+		if attachment == nil || aws.StringValue(a.InstanceId) == s.instance {
+			attachment = a
+		}
+	}
+	if attachment == nil {
+		return "", "", "", nil
+	}
+	return aws.StringValue(attachment.InstanceId), aws.StringValue(attachment.Device),
+		aws.StringValue(attachment.State), nil
+}
@@ -4,19 +4,17 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/opsworks"
 	sh "github.com/codeskyblue/go-sh"
 	oexec "github.com/libopenstorage/openstorage/pkg/exec"
 	"github.com/libopenstorage/openstorage/pkg/storageops"
-	"github.com/portworx/sched-ops/task"
 	"github.com/sirupsen/logrus"
 )
 
@@ -31,16 +29,136 @@ type ec2Ops struct {
 	instanceType string
 	instance     string
 	ec2          *ec2.EC2
+	cfg          Config
 	mutex        sync.Mutex
+	// selfTest is the capability probe, run on first use and shared with
+	// dry run copies
+	selfTest *lazySelfTest
+	// nvmeLock protects whether the EBS volumes of the instance are exposed
+	// as NVMe devices, nil until detected
+	nvmeLock sync.Mutex
+	nvme     *bool
+	// cacheLock protects the cached instance description
+	cacheLock sync.Mutex
+	cached    *ec2.Instance
+	cachedAt  time.Time
 }
 
+// Config is the optional configuration of the AWS storage ops driver
+type Config struct {
+	// ReservedAttachSlots is the number of device slots on each instance that
+	// FreeDevices never hands out, keeping headroom for system use such as
+	// root and ephemeral devices or an emergency attach.
+	ReservedAttachSlots int
+	// BusyDevicePolicy is what to do when detaching a volume whose device is
+	// still mounted or open on this instance
+	BusyDevicePolicy storageops.BusyDevicePolicy
+	// BusyDeviceTimeout is how long to wait for a busy device to be released.
+	// Defaults to storageops.ProviderOpsTimeout.
+	BusyDeviceTimeout time.Duration
+	// AttachQueueTimeout is how long an attach may wait for earlier attaches
+	// to the same instance, on top of any time those attaches are still
+	// progressing, unless the attach sets storageops.AttachOptionDeadline.
+	// Defaults to storageops.ProviderOpsTimeout.
+	AttachQueueTimeout time.Duration
+	// DescribeCacheTTL is how long the description of this instance is
+	// reused across calls. Defaults to defaultDescribeCacheTTL, a negative
+	// value disables the cache.
+	DescribeCacheTTL time.Duration
+	// SnapshotCopyTimeout is how long SnapshotCopy waits for a copy to
+	// complete, unless set by the RetryOpSnapshot retry policy. Defaults to
+	// defaultSnapshotCopyTimeout.
+	SnapshotCopyTimeout time.Duration
+	// RetryPolicy configures the wait loops of the driver. The default
+	// retries every storageops.ProviderOpsRetryInterval, attaches and
+	// detaches poll adaptively.
+	RetryPolicy *storageops.RetryPolicy
+	// ReconcileDeviceMappings makes DeviceMappings cross check the instance
+	// block device mappings against the volume attachments and the local
+	// block devices, see ReconcileDeviceMappings
+	ReconcileDeviceMappings bool
+	// RateLimit is the number of EC2 API calls per second of the driver,
+	// halved whenever EC2 throttles a call and recovering on success.
+	// Defaults to defaultRateLimit, a negative value disables the limiter.
+	RateLimit float64
+	// RateBurst is the number of calls the driver may make at once, defaults
+	// to defaultRateBurst
+	RateBurst int
+	// OptimizeVolumeType makes Create choose the cheaper of gp3 and io2 for
+	// templates without a volume type that provision IOPS or throughput.
+	// The decision is recorded in the VolumeTypeChoiceLabel and
+	// VolumeTypeRationaleLabel tags.
+	OptimizeVolumeType bool
+	// VolumePrices are the prices the volume type optimizer compares keyed
+	// by region. Defaults to the list prices of defaultVolumePrices.
+	VolumePrices map[string]*VolumePrices
+	// InstanceTypeLimits are the EBS limits of instance types, keyed by
+	// instance type, that AdviseInstanceType uses in addition to
+	// defaultInstanceTypeLimits
+	InstanceTypeLimits map[string]InstanceTypeLimits
+	// DefaultKMSKeyID is the KMS key ID or ARN volumes created without an
+	// explicit encryption setting are encrypted with. Empty leaves them as
+	// the account default.
+	DefaultKMSKeyID string
+	// AlwaysEncrypt encrypts every created volume, with DefaultKMSKeyID if
+	// set, and fails the creation of volumes explicitly requested to be
+	// unencrypted
+	AlwaysEncrypt bool
+	// DeviceQuarantine is how long a device name freed by a detach is not
+	// handed out again, as reusing it right away can race the teardown of
+	// the old device node. Defaults to storageops.DefaultDeviceQuarantine, a
+	// negative value disables the quarantine.
+	DeviceQuarantine time.Duration
+	// DryRun makes every call changing volumes or snapshots, e.g. Create,
+	// Attach, Expand or ApplyTags, only validate its request with the DryRun
+	// flag of EC2, see storageops.DryRun
+	DryRun bool
+	// DeviceReservationDir is where devices picked by attaches are reserved,
+	// so that processes attaching to this instance concurrently never pick
+	// the same device. Defaults to storageops.DefaultDeviceReservationDir.
+	DeviceReservationDir string
+	// HTTP configures the HTTP client of the EC2 API calls, e.g. to go
+	// through a proxy. Only honored by the constructors creating the client.
+	HTTP *storageops.HTTPConfig
+}
+
+// EmbeddedConfig is the config of the embedded profile, for CLI tools and
+// short lived jobs that only make a couple of calls. The instance
+// description is never cached and the calls are not rate limited, so the
+// driver keeps no state between calls beyond the EC2 client. Subsystems
+// such as metrics, tracing or publishing are opt-in middlewares and are not
+// part of either profile.
+func EmbeddedConfig() Config {
+	return Config{
+		DescribeCacheTTL: -1,
+		RateLimit:        -1,
+	}
+}
+
+// NewEmbeddedClient creates an aws storage ops instance with the embedded
+// profile, see EmbeddedConfig. The instance may be empty if no calls are
+// made that act on the local instance.
+func NewEmbeddedClient(instance, instanceType string, ec2 *ec2.EC2) storageops.Ops {
+	return NewEc2StorageWithConfig(instance, instanceType, ec2, EmbeddedConfig())
+}
+
+// defaultDescribeCacheTTL is the default lifetime of the cached instance
+// description. It is invalidated on every attach and detach made through
+// this driver.
+const defaultDescribeCacheTTL = 2 * time.Second
+
 var (
-	// ErrAWSEnvNotAvailable is the error type when aws credentials are not set
-	ErrAWSEnvNotAvailable = fmt.Errorf("AWS credentials are not set in environment")
+	// ErrAWSEnvNotAvailable is the error type when no aws credentials are
+	// found in the credential chain
+	ErrAWSEnvNotAvailable = fmt.Errorf("AWS credentials are not available")
 	nvmeCmd               = oexec.Which("nvme")
 )
 
+var _ storageops.LabeledSnapshotter = &ec2Ops{}
+
 // NewEnvClient creates a new AWS storage ops instance using environment vars
+// for the region and instance. Credentials are resolved with the default
+// chain, see newSession.
 func NewEnvClient() (storageops.Ops, error) {
 	region, err := storageops.GetEnvValueStrict("AWS_REGION")
 	if err != nil {
@@ -57,34 +175,132 @@ func NewEnvClient() (storageops.Ops, error) {
 		return nil, err
 	}
 
-	if _, err := credentials.NewEnvCredentials().Get(); err != nil {
-		return nil, ErrAWSEnvNotAvailable
+	cfg, err := configFromEnv()
+	if err != nil {
+		return nil, err
 	}
 
-	ec2 := ec2.New(
-		session.New(
-			&aws.Config{
-				Region:      &region,
-				Credentials: credentials.NewEnvCredentials(),
-			},
-		),
-	)
+	sess, err := newSession(cfg, &aws.Config{Region: &region})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := sess.Config.Credentials.Get(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAWSEnvNotAvailable, err)
+	}
+
+	return NewEc2StorageWithConfig(instance, instanceType, ec2.New(sess), cfg), nil
+}
 
-	return NewEc2Storage(instance, instanceType, ec2), nil
+// configSettings are the names of the optional settings of the driver
+// config, see parseConfig
+type configSettings struct {
+	profile              string
+	reservedAttachSlots  string
+	busyDevicePolicy     string
+	optimizeVolumeType   string
+	dryRun               string
+	deviceReservationDir string
+	kmsKeyID             string
+	alwaysEncrypt        string
+	describeCacheTTL     string
+}
+
+// envSettings are the environment vars of the optional driver config
+var envSettings = configSettings{
+	profile:              "AWS_STORAGEOPS_PROFILE",
+	reservedAttachSlots:  "AWS_RESERVED_ATTACH_SLOTS",
+	busyDevicePolicy:     "AWS_BUSY_DEVICE_POLICY",
+	optimizeVolumeType:   "AWS_OPTIMIZE_VOLUME_TYPE",
+	dryRun:               "AWS_DRY_RUN",
+	deviceReservationDir: "AWS_DEVICE_RESERVATION_DIR",
+	kmsKeyID:             "AWS_KMS_KEY_ID",
+	alwaysEncrypt:        "AWS_ALWAYS_ENCRYPT",
+	describeCacheTTL:     "AWS_DESCRIBE_CACHE_TTL",
+}
+
+// configFromEnv returns the optional driver config set in environment vars
+func configFromEnv() (Config, error) {
+	cfg, err := parseConfig(envSettings, func(name string) (string, bool) {
+		v, err := storageops.GetEnvValueStrict(name)
+		return v, err == nil
+	})
+	if err != nil {
+		return cfg, err
+	}
+	if cfg.HTTP, err = storageops.HTTPConfigFromEnv(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// parseConfig returns the optional driver config of the settings with the
+// given names that lookup finds
+func parseConfig(names configSettings, lookup func(name string) (string, bool)) (Config, error) {
+	cfg := Config{}
+	if profile, ok := lookup(names.profile); ok {
+		switch profile {
+		case "embedded":
+			cfg = EmbeddedConfig()
+		case "agent":
+		default:
+			return cfg, fmt.Errorf("invalid %s %q", names.profile, profile)
+		}
+	}
+	var err error
+	if slots, ok := lookup(names.reservedAttachSlots); ok {
+		if cfg.ReservedAttachSlots, err = strconv.Atoi(slots); err != nil {
+			return cfg, fmt.Errorf("invalid %s %q: %v", names.reservedAttachSlots, slots, err)
+		}
+	}
+	if policy, ok := lookup(names.busyDevicePolicy); ok {
+		cfg.BusyDevicePolicy = storageops.BusyDevicePolicy(policy)
+	}
+	for name, field := range map[string]*bool{
+		names.optimizeVolumeType: &cfg.OptimizeVolumeType,
+		names.dryRun:             &cfg.DryRun,
+		names.alwaysEncrypt:      &cfg.AlwaysEncrypt,
+	} {
+		if v, ok := lookup(name); ok {
+			if *field, err = strconv.ParseBool(v); err != nil {
+				return cfg, fmt.Errorf("invalid %s %q: %v", name, v, err)
+			}
+		}
+	}
+	if dir, ok := lookup(names.deviceReservationDir); ok {
+		cfg.DeviceReservationDir = dir
+	}
+	if keyID, ok := lookup(names.kmsKeyID); ok {
+		cfg.DefaultKMSKeyID = keyID
+	}
+	if ttl, ok := lookup(names.describeCacheTTL); ok {
+		if cfg.DescribeCacheTTL, err = time.ParseDuration(ttl); err != nil {
+			return cfg, fmt.Errorf("invalid %s %q: %v", names.describeCacheTTL, ttl, err)
+		}
+	}
+	return cfg, nil
 }
 
 // NewEc2Storage creates a new aws storage ops instance
 func NewEc2Storage(instance, instanceType string, ec2 *ec2.EC2) storageops.Ops {
+	return NewEc2StorageWithConfig(instance, instanceType, ec2, Config{})
+}
+
+// NewEc2StorageWithConfig creates a new aws storage ops instance with the given config
+func NewEc2StorageWithConfig(
+	instance, instanceType string,
+	ec2 *ec2.EC2,
+	cfg Config,
+) storageops.Ops {
+	installRateLimiter(ec2, cfg.RateLimit, cfg.RateBurst)
 	return &ec2Ops{
 		instance:     instance,
 		instanceType: instanceType,
 		ec2:          ec2,
+		cfg:          cfg,
+		selfTest:     &lazySelfTest{},
 	}
 }
 
-// nvmeInstanceTypes are list of instance types whose EBS volumes are exposed as NVMe block devices
-var nvmeInstanceTypes = []string{"c5", "c5d", "i3.metal", "m5", "m5d", "r5", "r5d", "z1d"}
-
 func (s *ec2Ops) filters(
 	labels map[string]string,
 	keys []string,
@@ -123,15 +339,29 @@ func (s *ec2Ops) tags(labels map[string]string) []*ec2.Tag {
 	return t
 }
 
+// tagSpecifications returns the tag specifications tagging a resource of
+// the given type with labels when it is created, so it is never left
+// untagged if the driver dies right after creating it
+func (s *ec2Ops) tagSpecifications(resourceType string, labels map[string]string) []*ec2.TagSpecification {
+	if len(labels) == 0 {
+		return nil
+	}
+	return []*ec2.TagSpecification{{
+		ResourceType: aws.String(resourceType),
+		Tags:         s.tags(labels),
+	}}
+}
+
 func (s *ec2Ops) waitStatus(id string, desired string) error {
 	request := &ec2.DescribeVolumesInput{VolumeIds: []*string{&id}}
 	actual := ""
 
-	_, err := task.DoRetryWithTimeout(
+	_, err := s.cfg.RetryPolicy.For(storageops.RetryOpCreate).Retry(
+		fmt.Sprintf("wait for volume %s to be %s", id, desired),
 		func() (interface{}, bool, error) {
 			awsVols, err := s.ec2.DescribeVolumes(request)
 			if err != nil {
-				return nil, true, err
+				return nil, true, s.storageError(err)
 			}
 
 			if len(awsVols.Volumes) != 1 {
@@ -152,9 +382,7 @@ func (s *ec2Ops) waitStatus(id string, desired string) error {
 				"Volume %v did not transition to %v current state %v",
 				id, desired, actual)
 
-		},
-		storageops.ProviderOpsTimeout,
-		storageops.ProviderOpsRetryInterval)
+		})
 
 	return err
 
@@ -162,18 +390,19 @@ func (s *ec2Ops) waitStatus(id string, desired string) error {
 
 func (s *ec2Ops) waitAttachmentStatus(
 	volumeID string,
+	instanceID string,
 	desired string,
 	timeout time.Duration,
+	progress func(),
 ) (*ec2.Volume, error) {
 	id := volumeID
 	request := &ec2.DescribeVolumesInput{VolumeIds: []*string{&id}}
-	interval := 2 * time.Second
-	logrus.Infof("Waiting for state transition to %q", desired)
+	volType := ""
 
 	f := func() (interface{}, bool, error) {
 		awsVols, err := s.ec2.DescribeVolumes(request)
 		if err != nil {
-			return nil, false, err
+			return nil, false, s.storageError(err)
 		}
 		if len(awsVols.Volumes) != 1 {
 			return nil, false, fmt.Errorf("expected one volume %v got %v",
@@ -182,22 +411,38 @@ func (s *ec2Ops) waitAttachmentStatus(
 
 		var actual string
 		vol := awsVols.Volumes[0]
-		awsAttachment := vol.Attachments
-		if awsAttachment == nil || len(awsAttachment) == 0 {
+		volType = aws.StringValue(vol.VolumeType)
+		awsAttachment := attachmentTo(vol, instanceID)
+		if awsAttachment == nil || awsAttachment.State == nil {
 			// We have encountered scenarios where AWS returns a nil attachment state
 			// for a volume transitioning from detaching -> attaching.
 			actual = ec2.VolumeAttachmentStateDetached
 		} else {
-			actual = *awsAttachment[0].State
+			actual = *awsAttachment.State
 		}
 		if actual == desired {
 			return vol, false, nil
 		}
+		if progress != nil {
+			progress()
+		}
 		return nil, true, fmt.Errorf("Volume %v failed to transition to  %v current state %v",
 			volumeID, desired, actual)
 	}
 
-	outVol, err := task.DoRetryWithTimeout(f, timeout, interval)
+	key := func() string { return "aws/" + desired + "/" + volType }
+	op := fmt.Sprintf("wait for volume %s to be %s on %s", volumeID, desired, instanceID)
+	var outVol interface{}
+	var err error
+	if s.cfg.RetryPolicy == nil {
+		outVol, err = storageops.PollWithTimeout(op, key, f, timeout)
+	} else {
+		kind := storageops.RetryOpAttach
+		if desired == ec2.VolumeAttachmentStateDetached {
+			kind = storageops.RetryOpDetach
+		}
+		outVol, err = s.cfg.RetryPolicy.For(kind).Retry(op, f)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -208,26 +453,42 @@ func (s *ec2Ops) waitAttachmentStatus(
 		fmt.Sprintf("Invalid volume object for volume %s", volumeID), "")
 }
 
+// attachmentTo returns the attachment of the given volume to the given
+// instance, a volume with Multi-Attach enabled can have several
+func attachmentTo(vol *ec2.Volume, instanceID string) *ec2.VolumeAttachment {
+	for _, a := range vol.Attachments {
+		if aws.StringValue(a.InstanceId) == instanceID {
+			return a
+		}
+	}
+	return nil
+}
+
 func (s *ec2Ops) Name() string { return "aws" }
 
 func (s *ec2Ops) InstanceID() string { return s.instance }
 
+// ApplyTags tags the given volume or snapshot with the given labels,
+// replacing the values of labels it already has
 func (s *ec2Ops) ApplyTags(volumeID string, labels map[string]string) error {
 	req := &ec2.CreateTagsInput{
+		DryRun:    aws.Bool(s.cfg.DryRun),
 		Resources: []*string{&volumeID},
 		Tags:      s.tags(labels),
 	}
 	_, err := s.ec2.CreateTags(req)
-	return err
+	return s.storageError(err)
 }
 
+// RemoveTags removes the given labels from the given volume or snapshot
 func (s *ec2Ops) RemoveTags(volumeID string, labels map[string]string) error {
 	req := &ec2.DeleteTagsInput{
+		DryRun:    aws.Bool(s.cfg.DryRun),
 		Resources: []*string{&volumeID},
 		Tags:      s.tags(labels),
 	}
 	_, err := s.ec2.DeleteTags(req)
-	return err
+	return s.storageError(err)
 }
 
 func (s *ec2Ops) matchTag(tag *ec2.Tag, match string) bool {
@@ -239,11 +500,18 @@ func (s *ec2Ops) matchTag(tag *ec2.Tag, match string) bool {
 }
 
 func (s *ec2Ops) DeviceMappings() (map[string]string, error) {
+	if s.cfg.ReconcileDeviceMappings {
+		mappings, _, err := s.ReconcileDeviceMappings()
+		return mappings, err
+	}
 	instance, err := s.describe()
 	if err != nil {
 		return nil, err
 	}
+	return s.deviceMappings(instance)
+}
 
+func (s *ec2Ops) deviceMappings(instance *ec2.Instance) (map[string]string, error) {
 	m := make(map[string]string)
 	for _, d := range instance.BlockDeviceMappings {
 		if d.DeviceName != nil && d.Ebs != nil && d.Ebs.VolumeId != nil {
@@ -272,12 +540,45 @@ func (s *ec2Ops) Describe() (interface{}, error) {
 }
 
 func (s *ec2Ops) describe() (*ec2.Instance, error) {
+	ttl := s.cfg.DescribeCacheTTL
+	if ttl == 0 {
+		ttl = defaultDescribeCacheTTL
+	}
+	if ttl < 0 {
+		return s.describeInstance()
+	}
+
+	// Holding the lock during the call coalesces concurrent callers at
+	// startup into a single DescribeInstances
+	s.cacheLock.Lock()
+	defer s.cacheLock.Unlock()
+	if s.cached != nil && time.Since(s.cachedAt) < ttl {
+		return s.cached, nil
+	}
+	instance, err := s.describeInstance()
+	if err != nil {
+		return nil, err
+	}
+	s.cached = instance
+	s.cachedAt = time.Now()
+	return instance, nil
+}
+
+// invalidateDescribe drops the cached instance description after the block
+// device mappings of the instance changed
+func (s *ec2Ops) invalidateDescribe() {
+	s.cacheLock.Lock()
+	s.cached = nil
+	s.cacheLock.Unlock()
+}
+
+func (s *ec2Ops) describeInstance() (*ec2.Instance, error) {
 	request := &ec2.DescribeInstancesInput{
 		InstanceIds: []*string{&s.instance},
 	}
 	out, err := s.ec2.DescribeInstances(request)
 	if err != nil {
-		return nil, err
+		return nil, s.storageError(err)
 	}
 	if len(out.Reservations) != 1 {
 		return nil, fmt.Errorf("DescribeInstances(%v) returned %v reservations, expect 1",
@@ -334,31 +635,23 @@ func (s *ec2Ops) getParentDevice(ipDevPath string) (string, error) {
 // such as /dev/sd and /dev/xvd and return the devicePath which is found
 // or return an error
 func (s *ec2Ops) getActualDevicePath(ipDevicePath, volumeID string) (string, error) {
-	letter := ipDevicePath[len(ipDevicePath)-1:]
-	devicePath := awsDevicePrefix + letter
+	suffix := deviceSuffix(ipDevicePath)
+	devicePath := awsDevicePrefix + suffix
 	if _, err := os.Stat(devicePath); err == nil {
 		return s.getParentDevice(devicePath)
 	}
-	devicePath = awsDevicePrefixWithX + letter
+	devicePath = awsDevicePrefixWithX + suffix
 	if _, err := os.Stat(devicePath); err == nil {
 		return s.getParentDevice(devicePath)
 	}
 
-	devicePath = awsDevicePrefixWithH + letter
+	devicePath = awsDevicePrefixWithH + suffix
 	if _, err := os.Stat(devicePath); err == nil {
 		return s.getParentDevice(devicePath)
 	}
 
 	// Check if the EBS volumes are exposed as NVMe drives
-	found := false
-	for _, instancePrefix := range nvmeInstanceTypes {
-		if strings.HasPrefix(s.instanceType, instancePrefix) {
-			found = true
-			break
-		}
-	}
-
-	if !found {
+	if !s.nvmeExposed() {
 		return "", fmt.Errorf("unable to map volume %v with block device mapping %v to an"+
 			" actual device path on the host", volumeID, ipDevicePath)
 	}
@@ -374,27 +667,62 @@ func (s *ec2Ops) getActualDevicePath(ipDevicePath, volumeID string) (string, err
 
 }
 
+// getNvmeDeviceFromVolumeID maps the given volume to its NVMe device using
+// the strategies of the self test, the serials in sysfs first and nvme-cli
+// or the udev by-id symlinks when they are not readable
 func (s *ec2Ops) getNvmeDeviceFromVolumeID(volumeID string) (string, error) {
-	// We will use nvme list command to find nvme device mappings
-	// A typical output of nvme list looks like this
-	// # nvme list
-	// Node             SN                   Model                                    Namespace Usage                      Format           FW Rev
-	// ---------------- -------------------- ---------------------------------------- --------- -------------------------- ---------------- --------
-	// /dev/nvme0n1     vol00fd6f8c30dc619f4 Amazon Elastic Block Store               1           0.00   B / 137.44  GB    512   B +  0 B   1.0
-	// /dev/nvme1n1     vol044e12c8c0af45b3d Amazon Elastic Block Store               1           0.00   B / 107.37  GB    512   B +  0 B   1.0
-	trimmedVolumeID := strings.Replace(volumeID, "-", "", 1)
-	out, err := sh.Command(nvmeCmd, "list").Command("grep", trimmedVolumeID).Command("awk", "{print $1}").Output()
+	strategies := s.nvmeStrategies()
+	if len(strategies) == 0 {
+		return "", fmt.Errorf("unable to map %v volume to an nvme device: "+
+			"sysfs, nvme-cli and udev by-id symlinks are not available", volumeID)
+	}
+	var err error
+	for _, strategy := range strategies {
+		var devicePath string
+		switch strategy {
+		case nvmeStrategySysfs:
+			devicePath, err = getNvmeDeviceFromSysfs(volumeID)
+		case nvmeStrategyByID:
+			devicePath, err = getNvmeDeviceFromByID(volumeID)
+		case nvmeStrategyCli:
+			devicePath, err = getNvmeDeviceFromCli(volumeID)
+		}
+		if err == nil {
+			return devicePath, nil
+		}
+		logrus.Debugf("Failed to map volume %v with nvme strategy %v: %v", volumeID, strategy, err)
+	}
+	return "", err
+}
+
+// getNvmeDeviceFromCli resolves the NVMe device of the given volume from the
+// output of nvme list, which looks like this
+// # nvme list
+// Node             SN                   Model                                    Namespace Usage                      Format           FW Rev
+// ---------------- -------------------- ---------------------------------------- --------- -------------------------- ---------------- --------
+// /dev/nvme0n1     vol00fd6f8c30dc619f4 Amazon Elastic Block Store               1           0.00   B / 137.44  GB    512   B +  0 B   1.0
+// /dev/nvme1n1     vol044e12c8c0af45b3d Amazon Elastic Block Store               1           0.00   B / 107.37  GB    512   B +  0 B   1.0
+func getNvmeDeviceFromCli(volumeID string) (string, error) {
+	out, err := sh.Command(nvmeCmd, "list").Output()
 	if err != nil {
 		return "", fmt.Errorf("unable to map %v volume to an nvme device: %v", volumeID, err)
 	}
-	return strings.TrimSpace(string(out)), nil
+	trimmedVolumeID := strings.Replace(volumeID, "-", "", 1)
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && fields[1] == trimmedVolumeID {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("unable to map %v volume to an nvme device: "+
+		"not found in nvme list", volumeID)
 }
 
 func (s *ec2Ops) FreeDevices(
 	blockDeviceMappings []interface{},
 	rootDeviceName string,
 ) ([]string, error) {
-	initial := []byte("fghijklmnop")
+	used := make(map[string]bool)
 	devPrefix := awsDevicePrefix
 	for _, d := range blockDeviceMappings {
 		dev := d.(*ec2.InstanceBlockDeviceMapping)
@@ -412,29 +740,25 @@ func (s *ec2Ops) FreeDevices(
 			if !strings.HasPrefix(devName, devPrefix) {
 				devPrefix = awsDevicePrefixWithH
 				if !strings.HasPrefix(devName, devPrefix) {
-					return nil, fmt.Errorf("bad device name %q", devName)
+					return nil, fmt.Errorf("bad device name %q of volume %s: "+
+						"expected a prefix of %s, %s or %s", devName, mappingVolumeID(dev),
+						awsDevicePrefix, awsDevicePrefixWithX, awsDevicePrefixWithH)
 				}
 			}
 		}
 		letter := devName[len(devPrefix):]
 
-		// Reset devPrefix for next devices
-		devPrefix = awsDevicePrefix
-
 		// AWS instances can have the following device names
-		// /dev/xvd[b-c][a-z]
-		if len(letter) == 1 {
-			index := letter[0] - 'f'
-			if index > ('p' - 'f') {
-				continue
-			}
-			initial[index] = '0'
-		} else if len(letter) == 2 {
-			// We do not attach EBS volumes with "/dev/xvdc[a-z]" formats
-			continue
-		} else {
-			return nil, fmt.Errorf("cannot parse device name %q", devName)
+		// /dev/sd[a-z], /dev/xvd[a-z] and /dev/xvd[b-c][a-z]
+		if len(letter) != 1 && len(letter) != 2 {
+			return nil, fmt.Errorf("cannot parse device name %q of volume %s: "+
+				"expected a name in %s[a-z] or %s[b-c][a-z]", devName, mappingVolumeID(dev),
+				devPrefix, awsDevicePrefixWithX)
 		}
+		used[letter] = true
+
+		// Reset devPrefix for next devices
+		devPrefix = awsDevicePrefix
 	}
 
 	// Set the prefix to the same one used as the root drive
@@ -446,18 +770,25 @@ func (s *ec2Ops) FreeDevices(
 		return nil, err
 	}
 
-	free := make([]string, len(initial))
-	count := 0
-	for _, b := range initial {
-		if b != '0' {
-			free[count] = devPrefix + string(b)
-			count++
+	// The root device and every attached volume take an attachment slot
+	slots := s.maxAttachments() - len(blockDeviceMappings)
+	var free []string
+	for _, suffix := range deviceSuffixes(devPrefix) {
+		if len(free) >= slots {
+			break
+		}
+		if !used[suffix] {
+			free = append(free, devPrefix+suffix)
 		}
 	}
-	if count == 0 {
-		return nil, fmt.Errorf("No more free devices")
+	count := len(free)
+	// Keep the last free slots in reserve
+	if count <= s.cfg.ReservedAttachSlots {
+		return nil, fmt.Errorf("No more free devices in %s: %d of %d attachment slots "+
+			"are in use and %d free device(s) are reserved",
+			deviceRange(devPrefix), len(blockDeviceMappings), s.maxAttachments(), count)
 	}
-	return free[:count], nil
+	return free[:count-s.cfg.ReservedAttachSlots], nil
 }
 
 func (s *ec2Ops) rollbackCreate(id string, createErr error) error {
@@ -470,7 +801,7 @@ func (s *ec2Ops) rollbackCreate(id string, createErr error) error {
 }
 
 func (s *ec2Ops) refreshVol(id *string) (*ec2.Volume, error) {
-	vols, err := s.Inspect([]*string{id})
+	vols, err := s.describeVolumes([]*string{id})
 	if err != nil {
 		return nil, err
 	}
@@ -479,15 +810,7 @@ func (s *ec2Ops) refreshVol(id *string) (*ec2.Volume, error) {
 		return nil, fmt.Errorf("failed to get vol: %s."+
 			"Found: %d volumes on inspecting", *id, len(vols))
 	}
-
-	resp, ok := vols[0].(*ec2.Volume)
-	if !ok {
-		return nil, storageops.NewStorageError(storageops.ErrVolInval,
-			fmt.Sprintf("Invalid volume returned by inspect API for vol: %s", *id),
-			"")
-	}
-
-	return resp, nil
+	return vols[0], nil
 }
 
 func (s *ec2Ops) deleted(v *ec2.Volume) bool {
@@ -500,7 +823,9 @@ func (s *ec2Ops) available(v *ec2.Volume) bool {
 }
 
 func (s *ec2Ops) GetDeviceID(vol interface{}) (string, error) {
-	if d, ok := vol.(*ec2.Volume); ok {
+	if id, ok := storageops.TypedID(vol); ok {
+		return id, nil
+	} else if d, ok := vol.(*ec2.Volume); ok {
 		return *d.VolumeId, nil
 	} else if d, ok := vol.(*ec2.Snapshot); ok {
 		return *d.SnapshotId, nil
@@ -509,100 +834,137 @@ func (s *ec2Ops) GetDeviceID(vol interface{}) (string, error) {
 	}
 }
 
-func (s *ec2Ops) Inspect(volumeIds []*string) ([]interface{}, error) {
-	req := &ec2.DescribeVolumesInput{VolumeIds: volumeIds}
-	resp, err := s.ec2.DescribeVolumes(req)
+func (s *ec2Ops) Inspect(volumeIds []*string) ([]*storageops.Volume, error) {
+	vols, err := s.describeVolumes(volumeIds)
 	if err != nil {
 		return nil, err
 	}
+	return toVolumes(vols), nil
+}
 
-	var awsVols = make([]interface{}, len(resp.Volumes))
-	for i, v := range resp.Volumes {
-		awsVols[i] = v
+func (s *ec2Ops) describeVolumes(volumeIds []*string) ([]*ec2.Volume, error) {
+	req := &ec2.DescribeVolumesInput{VolumeIds: volumeIds}
+	resp, err := s.ec2.DescribeVolumes(req)
+	if err != nil {
+		return nil, s.storageError(err)
 	}
-
-	return awsVols, nil
+	return resp.Volumes, nil
 }
 
+// Tags returns the tags of the given volume or snapshot
 func (s *ec2Ops) Tags(volumeID string) (map[string]string, error) {
-	vol, err := s.refreshVol(&volumeID)
-	if err != nil {
-		return nil, err
+	var tags []*ec2.Tag
+	if isSnapshot(volumeID) {
+		resp, err := s.ec2.DescribeSnapshots(&ec2.DescribeSnapshotsInput{
+			SnapshotIds: []*string{&volumeID},
+		})
+		if err != nil {
+			return nil, s.storageError(err)
+		}
+		if len(resp.Snapshots) != 1 {
+			return nil, storageops.NewStorageError(storageops.ErrVolNotFound,
+				fmt.Sprintf("snapshot %s not found", volumeID), s.instance)
+		}
+		tags = resp.Snapshots[0].Tags
+	} else {
+		vol, err := s.refreshVol(&volumeID)
+		if err != nil {
+			return nil, err
+		}
+		tags = vol.Tags
 	}
 
 	labels := make(map[string]string)
-	for _, tag := range vol.Tags {
+	for _, tag := range tags {
 		labels[*tag.Key] = *tag.Value
 	}
 	return labels, nil
 }
 
+// isSnapshot returns true if the given ID is the ID of a snapshot
+func isSnapshot(id string) bool {
+	return strings.HasPrefix(id, "snap-")
+}
+
 func (s *ec2Ops) Enumerate(
 	volumeIds []*string,
 	labels map[string]string,
 	setIdentifier string,
-) (map[string][]interface{}, error) {
-	sets := make(map[string][]interface{})
-
-	// Enumerate all volumes that have same labels.
-	f := s.filters(labels, nil)
-	req := &ec2.DescribeVolumesInput{Filters: f, VolumeIds: volumeIds}
-	awsVols, err := s.ec2.DescribeVolumes(req)
+) (map[string][]*storageops.Volume, error) {
+	sets := make(map[string][]*storageops.Volume)
+	err := s.EnumeratePages(volumeIds, labels, setIdentifier, 0,
+		func(set string, vol *ec2.Volume) bool {
+			sets[set] = append(sets[set], toVolume(vol))
+			return true
+		})
 	if err != nil {
 		return nil, err
 	}
-
-	// Volume sets are identified by volumes with the same setIdentifer.
-	found := false
-	for _, vol := range awsVols.Volumes {
-		if s.deleted(vol) {
-			continue
-		}
-		if len(setIdentifier) == 0 {
-			storageops.AddElementToMap(sets, vol, storageops.SetIdentifierNone)
-		} else {
-			found = false
-			for _, tag := range vol.Tags {
-				if s.matchTag(tag, setIdentifier) {
-					storageops.AddElementToMap(sets, vol, *tag.Value)
-					found = true
-					break
-				}
-			}
-			if !found {
-				storageops.AddElementToMap(sets, vol, storageops.SetIdentifierNone)
-			}
-		}
-	}
-
 	return sets, nil
 }
 
 func (s *ec2Ops) Create(
 	v interface{},
 	labels map[string]string,
-) (interface{}, error) {
+) (*storageops.Volume, error) {
+	v, labels, err := storageops.VolumeTemplate(s.Name(), v, labels)
+	if err != nil {
+		return nil, err
+	}
 	vol, ok := v.(*ec2.Volume)
 	if !ok {
 		return nil, storageops.NewStorageError(storageops.ErrVolInval,
 			"Invalid volume template given", "")
 	}
 
+	if s.cfg.OptimizeVolumeType && vol.VolumeType == nil && (vol.Iops != nil || vol.Throughput != nil) {
+		region, err := s.GetRegion()
+		if err != nil {
+			return nil, err
+		}
+		optimized, decision, err := optimizeVolumeType(vol, region, s.volumePrices(region))
+		if err != nil {
+			return nil, err
+		}
+		logrus.Infof("chose %s volume: %s", decision[VolumeTypeChoiceLabel],
+			decision[VolumeTypeRationaleLabel])
+		for k, v := range labels {
+			decision[k] = v
+		}
+		vol, labels = optimized, decision
+	}
+
+	if err := validateVolume(vol); err != nil {
+		return nil, err
+	}
+
 	req := &ec2.CreateVolumeInput{
-		AvailabilityZone: vol.AvailabilityZone,
-		Encrypted:        vol.Encrypted,
-		KmsKeyId:         vol.KmsKeyId,
-		Size:             vol.Size,
-		VolumeType:       vol.VolumeType,
-		SnapshotId:       vol.SnapshotId,
-	}
-	if *vol.VolumeType == opsworks.VolumeTypeIo1 {
+		DryRun:            aws.Bool(s.cfg.DryRun),
+		AvailabilityZone:  vol.AvailabilityZone,
+		Encrypted:         vol.Encrypted,
+		KmsKeyId:          vol.KmsKeyId,
+		Size:              vol.Size,
+		VolumeType:        vol.VolumeType,
+		SnapshotId:        vol.SnapshotId,
+		TagSpecifications: s.tagSpecifications(ec2.ResourceTypeVolume, labels),
+	}
+	if token := storageops.ClientToken(labels); len(token) > 0 {
+		req.ClientToken = aws.String(token)
+	}
+	switch aws.StringValue(vol.VolumeType) {
+	case opsworks.VolumeTypeIo1, ec2.VolumeTypeIo2:
+		req.Iops = vol.Iops
+	case ec2.VolumeTypeGp3:
 		req.Iops = vol.Iops
+		req.Throughput = vol.Throughput
+	}
+	if err := s.applyEncryptionPolicy(req); err != nil {
+		return nil, err
 	}
 
 	resp, err := s.ec2.CreateVolume(req)
 	if err != nil {
-		return nil, err
+		return nil, s.storageError(err)
 	}
 	if err = s.waitStatus(
 		*resp.VolumeId,
@@ -610,13 +972,32 @@ func (s *ec2Ops) Create(
 	); err != nil {
 		return nil, s.rollbackCreate(*resp.VolumeId, err)
 	}
-	if len(labels) > 0 {
-		if err = s.ApplyTags(*resp.VolumeId, labels); err != nil {
-			return nil, s.rollbackCreate(*resp.VolumeId, err)
-		}
+
+	created, err := s.refreshVol(resp.VolumeId)
+	if err != nil {
+		return nil, err
 	}
+	return toVolume(created), nil
+}
 
-	return s.refreshVol(resp.VolumeId)
+// applyEncryptionPolicy applies the default KMS key and the always encrypt
+// policy of the driver to a request without an explicit encryption setting
+func (s *ec2Ops) applyEncryptionPolicy(req *ec2.CreateVolumeInput) error {
+	if req.Encrypted != nil && !*req.Encrypted {
+		if s.cfg.AlwaysEncrypt {
+			return storageops.NewStorageError(storageops.ErrVolInval,
+				"unencrypted volumes are not allowed by the encryption policy", "")
+		}
+		return nil
+	}
+	if req.Encrypted == nil && !s.cfg.AlwaysEncrypt && len(s.cfg.DefaultKMSKeyID) == 0 {
+		return nil
+	}
+	req.Encrypted = aws.Bool(true)
+	if req.KmsKeyId == nil && len(s.cfg.DefaultKMSKeyID) > 0 {
+		req.KmsKeyId = aws.String(s.cfg.DefaultKMSKeyID)
+	}
+	return nil
 }
 
 func (s *ec2Ops) DeleteFrom(id, _ string) error {
@@ -624,12 +1005,128 @@ func (s *ec2Ops) DeleteFrom(id, _ string) error {
 }
 
 func (s *ec2Ops) Delete(id string) error {
-	req := &ec2.DeleteVolumeInput{VolumeId: &id}
+	req := &ec2.DeleteVolumeInput{VolumeId: &id, DryRun: aws.Bool(s.cfg.DryRun)}
 	_, err := s.ec2.DeleteVolume(req)
+	return s.storageError(err)
+}
+
+func (s *ec2Ops) Expand(volumeID string, newSizeGiB uint64) (uint64, error) {
+	vol, err := s.refreshVol(&volumeID)
+	if err != nil {
+		return 0, err
+	}
+	currentSize := uint64(*vol.Size)
+	if newSizeGiB == currentSize {
+		return currentSize, nil
+	}
+	if newSizeGiB < currentSize {
+		return 0, storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("volume %s of %d GiB cannot be shrunk to %d GiB",
+				volumeID, currentSize, newSizeGiB), "")
+	}
+
+	// Only the size changes, the IOPS and throughput DescribeVolumes
+	// reports, e.g. the baseline IOPS of gp2, are not provisioned ones
+	size := int64(newSizeGiB)
+	volType := aws.StringValue(vol.VolumeType)
+	if len(volType) == 0 {
+		volType = ec2.VolumeTypeGp2
+	}
+	limits, ok := volumeLimits[volType]
+	if !ok {
+		return 0, storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("unsupported volume type %q", volType), "")
+	}
+	if err := validateSize(size, volType, limits); err != nil {
+		return 0, err
+	}
+	request := &ec2.ModifyVolumeInput{
+		DryRun:   aws.Bool(s.cfg.DryRun),
+		VolumeId: &volumeID,
+		Size:     &size,
+	}
+	if _, err := s.ec2.ModifyVolume(request); err != nil {
+		return 0, s.storageError(err)
+	}
+
+	if err := s.waitModification(volumeID, storageops.RetryOpExpand, "expansion"); err != nil {
+		return 0, err
+	}
+	return newSizeGiB, nil
+}
+
+// waitModification waits until the modification of the given volume is
+// optimizing, from when the new size, type or performance is usable
+func (s *ec2Ops) waitModification(volumeID, retryOp, what string) error {
+	_, err := s.cfg.RetryPolicy.For(retryOp).Retry(
+		fmt.Sprintf("wait for %s of volume %s", what, volumeID),
+		func() (interface{}, bool, error) {
+			status, err := s.modificationStatus(volumeID)
+			if err != nil {
+				return nil, true, err
+			}
+			switch status.State {
+			case storageops.OperationSucceeded:
+				return nil, false, nil
+			case storageops.OperationFailed:
+				return nil, false, fmt.Errorf("%s of volume %s failed: %s",
+					what, volumeID, status.Message)
+			}
+			return nil, true, fmt.Errorf("volume %s modification is %s", volumeID, status.Message)
+		})
 	return err
 }
 
-func (s *ec2Ops) Attach(volumeID string) (string, error) {
+// modificationStatus returns the status of the latest modification of the
+// given volume. It succeeded once the modification is optimizing.
+func (s *ec2Ops) modificationStatus(volumeID string) (*storageops.OperationStatus, error) {
+	resp, err := s.ec2.DescribeVolumesModifications(
+		&ec2.DescribeVolumesModificationsInput{
+			VolumeIds: []*string{&volumeID},
+		})
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	if len(resp.VolumesModifications) == 0 {
+		return nil, fmt.Errorf("no modification found for volume %s", volumeID)
+	}
+	mod := resp.VolumesModifications[0]
+	state := aws.StringValue(mod.ModificationState)
+	status := &storageops.OperationStatus{
+		State:    storageops.OperationRunning,
+		Progress: int(aws.Int64Value(mod.Progress)),
+		Message:  state,
+	}
+	switch state {
+	case ec2.VolumeModificationStateOptimizing,
+		ec2.VolumeModificationStateCompleted:
+		status.State = storageops.OperationSucceeded
+		status.Progress = 100
+	case ec2.VolumeModificationStateFailed:
+		status.State = storageops.OperationFailed
+		status.Message = aws.StringValue(mod.StatusMessage)
+	}
+	return status, nil
+}
+
+func (s *ec2Ops) Attach(volumeID string, options map[string]string) (string, error) {
+	// EC2 serializes attaches to an instance, queue them here so that they
+	// are handled in deadline order instead of racing for the mutex
+	queueTimeout := s.cfg.AttachQueueTimeout
+	if queueTimeout == 0 {
+		queueTimeout = storageops.ProviderOpsTimeout
+	}
+	deadline, err := storageops.AttachDeadline(options, queueTimeout)
+	if err != nil {
+		return "", err
+	}
+	queue := storageops.InstanceQueue(s.instance)
+	release, err := queue.Acquire(deadline)
+	if err != nil {
+		return "", fmt.Errorf("failed to attach volume %v: %v", volumeID, err)
+	}
+	defer release()
+
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
@@ -647,23 +1144,135 @@ func (s *ec2Ops) Attach(volumeID string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	req := &ec2.AttachVolumeInput{
-		Device:     &devices[0],
-		InstanceId: &s.instance,
-		VolumeId:   &volumeID,
+	devices, err = s.awaitQuarantine(devices, []string{options[storageops.AttachOptionDevice]})
+	if err != nil {
+		return "", err
 	}
-	if _, err := s.ec2.AttachVolume(req); err != nil {
+	device, err := attachDevice(devices, options[storageops.AttachOptionDevice])
+	if err != nil {
 		return "", err
 	}
+	return s.attachAt(&storageops.AttachRequest{VolumeID: volumeID, Options: options},
+		device, newSpareDevices(devices, device), queue.Progress)
+}
+
+// awaitAttach waits for an attach issued to this instance to complete and
+// returns the device path of the volume
+func (s *ec2Ops) awaitAttach(
+	volumeID string,
+	options map[string]string,
+	progress func(),
+) (string, error) {
 	vol, err := s.waitAttachmentStatus(
 		volumeID,
+		s.instance,
 		ec2.VolumeAttachmentStateAttached,
 		time.Minute,
+		progress,
 	)
 	if err != nil {
 		return "", err
 	}
-	return s.DevicePath(*vol.VolumeId)
+	devicePath, err := s.DevicePath(*vol.VolumeId)
+	if err != nil {
+		return "", err
+	}
+	if options[storageops.AttachOptionReadOnly] == "true" {
+		// EBS has no read-only attachments, protect the device on the host
+		if out, err := sh.Command("blockdev", "--setro", devicePath).CombinedOutput(); err != nil {
+			return devicePath, fmt.Errorf("failed to set %s of volume %s read-only: %v: %s",
+				devicePath, volumeID, err, out)
+		}
+	}
+	return devicePath, nil
+}
+
+// attachDevice returns the device to attach a volume at, the first free
+// device or the requested one, given as a device name or its letter suffix
+func attachDevice(free []string, requested string) (string, error) {
+	if len(requested) == 0 {
+		return free[0], nil
+	}
+	suffix := deviceSuffix(requested)
+	for _, d := range free {
+		if deviceSuffix(d) == suffix {
+			return d, nil
+		}
+	}
+	return "", storageops.NewStorageError(storageops.ErrInvalidDevicePath,
+		fmt.Sprintf("requested device %s is not free, free devices are %v", requested, free), "")
+}
+
+// deviceSuffixes returns the device name suffixes FreeDevices hands out for
+// the given device prefix, in order. The two letter names are only valid
+// with the /dev/xvd prefix.
+// https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/device_naming.html
+func deviceSuffixes(devPrefix string) []string {
+	var suffixes []string
+	for c := 'f'; c <= 'z'; c++ {
+		suffixes = append(suffixes, string(c))
+	}
+	if devPrefix == awsDevicePrefixWithX {
+		for _, first := range "bc" {
+			for c := 'a'; c <= 'z'; c++ {
+				suffixes = append(suffixes, string(first)+string(c))
+			}
+		}
+	}
+	return suffixes
+}
+
+// deviceRange returns the device names of deviceSuffixes, e.g. /dev/sd[f-z]
+func deviceRange(devPrefix string) string {
+	if devPrefix == awsDevicePrefixWithX {
+		return devPrefix + "[f-z] and " + devPrefix + "[b-c][a-z]"
+	}
+	return devPrefix + "[f-z]"
+}
+
+// mappingVolumeID returns the ID of the EBS volume of the given mapping,
+// "<none>" if it has none
+func mappingVolumeID(m *ec2.InstanceBlockDeviceMapping) string {
+	if m.Ebs == nil || m.Ebs.VolumeId == nil {
+		return "<none>"
+	}
+	return *m.Ebs.VolumeId
+}
+
+func deviceSuffix(device string) string {
+	for _, prefix := range []string{awsDevicePrefix, awsDevicePrefixWithX, awsDevicePrefixWithH} {
+		if strings.HasPrefix(device, prefix) {
+			return strings.TrimPrefix(device, prefix)
+		}
+	}
+	return device
+}
+
+// enableMultiAttach enables EBS Multi-Attach on the given volume if it is not
+// enabled yet. Only io1 and io2 volumes support it.
+func (s *ec2Ops) enableMultiAttach(volumeID string) error {
+	vol, err := s.refreshVol(&volumeID)
+	if err != nil {
+		return err
+	}
+	if aws.BoolValue(vol.MultiAttachEnabled) {
+		return nil
+	}
+	volType := aws.StringValue(vol.VolumeType)
+	if volType != ec2.VolumeTypeIo1 && volType != ec2.VolumeTypeIo2 {
+		return storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("volume %s of type %s does not support Multi-Attach", volumeID, volType), "")
+	}
+	_, err = s.ec2.ModifyVolume(&ec2.ModifyVolumeInput{
+		DryRun:             aws.Bool(s.cfg.DryRun),
+		VolumeId:           &volumeID,
+		MultiAttachEnabled: aws.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enable Multi-Attach on volume %s: %w",
+			volumeID, s.storageError(err))
+	}
+	return nil
 }
 
 func (s *ec2Ops) Detach(volumeID string) error {
@@ -676,38 +1285,181 @@ func (s *ec2Ops) DetachFrom(volumeID, instanceName string) error {
 
 func (s *ec2Ops) detachInternal(volumeID, instanceName string) error {
 	force := false
+	if instanceName == s.instance && s.cfg.BusyDevicePolicy != storageops.BusyDeviceIgnore {
+		var err error
+		if force, err = s.checkDeviceBusy(volumeID); err != nil {
+			return err
+		}
+	}
 	req := &ec2.DetachVolumeInput{
+		DryRun:     aws.Bool(s.cfg.DryRun),
 		InstanceId: &instanceName,
 		VolumeId:   &volumeID,
 		Force:      &force,
 	}
-	if _, err := s.ec2.DetachVolume(req); err != nil {
-		return err
+	attachment, err := s.ec2.DetachVolume(req)
+	if instanceName == s.instance {
+		s.invalidateDescribe()
 	}
-	_, err := s.waitAttachmentStatus(volumeID,
+	if err != nil {
+		return s.storageError(err)
+	}
+	_, err = s.waitAttachmentStatus(volumeID,
+		instanceName,
 		ec2.VolumeAttachmentStateDetached,
 		time.Minute,
+		nil,
 	)
+	if err == nil {
+		s.quarantineDevice(instanceName, aws.StringValue(attachment.Device))
+	}
 	return err
 }
 
+// checkDeviceBusy applies the configured busy device policy to the local
+// device of the given volume and returns true if the detach should be forced
+func (s *ec2Ops) checkDeviceBusy(volumeID string) (bool, error) {
+	devicePath, err := s.DevicePath(volumeID)
+	if err != nil {
+		// Nothing to check if the volume is not attached locally
+		logrus.Debugf("Skipping busy check of volume %v: %v", volumeID, err)
+		return false, nil
+	}
+
+	timeout := s.cfg.BusyDeviceTimeout
+	if timeout == 0 {
+		timeout = storageops.ProviderOpsTimeout
+	}
+	force, err := storageops.CheckDeviceBusy(devicePath, s.cfg.BusyDevicePolicy, timeout)
+	if force {
+		logrus.Warnf("Device %v of volume %v is still in use, force detaching",
+			devicePath, volumeID)
+	}
+	return force, err
+}
+
 func (s *ec2Ops) Snapshot(
 	volumeID string,
 	readonly bool,
-) (interface{}, error) {
+) (*storageops.Snapshot, error) {
+	return s.SnapshotWithLabels(volumeID, readonly, nil)
+}
+
+func (s *ec2Ops) SnapshotWithLabels(
+	volumeID string,
+	readonly bool,
+	labels map[string]string,
+) (*storageops.Snapshot, error) {
 	request := &ec2.CreateSnapshotInput{
-		VolumeId: &volumeID,
+		DryRun:            aws.Bool(s.cfg.DryRun),
+		VolumeId:          &volumeID,
+		TagSpecifications: s.tagSpecifications(ec2.ResourceTypeSnapshot, labels),
+	}
+	snap, err := s.ec2.CreateSnapshot(request)
+	if err != nil {
+		return nil, s.storageError(err)
 	}
-	return s.ec2.CreateSnapshot(request)
+	return toSnapshot(snap), nil
 }
 
 func (s *ec2Ops) SnapshotDelete(snapID string) error {
 	request := &ec2.DeleteSnapshotInput{
+		DryRun:     aws.Bool(s.cfg.DryRun),
 		SnapshotId: &snapID,
 	}
 
 	_, err := s.ec2.DeleteSnapshot(request)
-	return err
+	return s.storageError(err)
+}
+
+func (s *ec2Ops) SnapshotEnumerate(
+	filter *storageops.SnapshotFilter,
+) ([]*storageops.Snapshot, error) {
+	request := &ec2.DescribeSnapshotsInput{
+		OwnerIds: []*string{aws.String("self")},
+	}
+	if filter != nil {
+		request.Filters = s.filters(filter.Labels, nil)
+		if len(filter.VolumeID) > 0 {
+			request.Filters = append(request.Filters, &ec2.Filter{
+				Name:   aws.String("volume-id"),
+				Values: []*string{aws.String(filter.VolumeID)},
+			})
+		}
+		if len(filter.State) > 0 {
+			request.Filters = append(request.Filters, &ec2.Filter{
+				Name:   aws.String("status"),
+				Values: []*string{aws.String(filter.State)},
+			})
+		}
+	}
+
+	var snaps []*storageops.Snapshot
+	err := s.ec2.DescribeSnapshotsPages(request,
+		func(page *ec2.DescribeSnapshotsOutput, lastPage bool) bool {
+			for _, snap := range page.Snapshots {
+				// EC2 only filters on exact start times, ranges are
+				// matched here
+				typed := toSnapshot(snap)
+				if filter.Match(
+					aws.StringValue(snap.VolumeId),
+					aws.TimeValue(snap.StartTime),
+					aws.StringValue(snap.State),
+					typed.Labels,
+				) {
+					snaps = append(snaps, typed)
+				}
+			}
+			return true
+		})
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	return snaps, nil
+}
+
+func (s *ec2Ops) SnapshotRestore(
+	snapID, zone string,
+	labels map[string]string,
+) (*storageops.Volume, error) {
+	if len(zone) == 0 {
+		self, err := s.describe()
+		if err != nil {
+			return nil, err
+		}
+		zone = aws.StringValue(self.Placement.AvailabilityZone)
+	}
+	return s.Create(&ec2.Volume{
+		AvailabilityZone: &zone,
+		SnapshotId:       &snapID,
+	}, labels)
+}
+
+func (s *ec2Ops) SnapshotStatus(snapID string) (*storageops.SnapshotStatus, error) {
+	resp, err := s.ec2.DescribeSnapshots(&ec2.DescribeSnapshotsInput{
+		SnapshotIds: []*string{&snapID},
+	})
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	if len(resp.Snapshots) != 1 {
+		return nil, fmt.Errorf("expected one snapshot %v got %v", snapID, len(resp.Snapshots))
+	}
+	return snapshotStatus(resp.Snapshots[0]), nil
+}
+
+func snapshotStatus(snap *ec2.Snapshot) *storageops.SnapshotStatus {
+	state := aws.StringValue(snap.State)
+	status := &storageops.SnapshotStatus{
+		ID:        aws.StringValue(snap.SnapshotId),
+		State:     state,
+		Completed: state == ec2.SnapshotStateCompleted,
+		Failed:    state == ec2.SnapshotStateError,
+		Message:   aws.StringValue(snap.StateMessage),
+	}
+	// Progress is reported as a percentage such as "45%"
+	status.Progress, _ = strconv.Atoi(strings.TrimSuffix(aws.StringValue(snap.Progress), "%"))
+	return status
 }
 
 func (s *ec2Ops) DevicePath(volumeID string) (string, error) {
@@ -720,31 +1472,35 @@ func (s *ec2Ops) DevicePath(volumeID string) (string, error) {
 		return "", storageops.NewStorageError(storageops.ErrVolDetached,
 			"Volume is detached", *vol.VolumeId)
 	}
-	if vol.Attachments[0].InstanceId == nil {
+	attachment := vol.Attachments[0]
+	if a := attachmentTo(vol, s.instance); a != nil {
+		attachment = a
+	}
+	if attachment.InstanceId == nil {
 		return "", storageops.NewStorageError(storageops.ErrVolInval,
 			"Unable to determine volume instance attachment", "")
 	}
-	if s.instance != *vol.Attachments[0].InstanceId {
+	if s.instance != *attachment.InstanceId {
 		return "", storageops.NewStorageError(storageops.ErrVolAttachedOnRemoteNode,
 			fmt.Sprintf("Volume attached on %q current instance %q",
-				*vol.Attachments[0].InstanceId, s.instance),
-			*vol.Attachments[0].InstanceId)
+				*attachment.InstanceId, s.instance),
+			*attachment.InstanceId)
 
 	}
-	if vol.Attachments[0].State == nil {
+	if attachment.State == nil {
 		return "", storageops.NewStorageError(storageops.ErrVolInval,
 			"Unable to determine volume attachment state", "")
 	}
-	if *vol.Attachments[0].State != ec2.VolumeAttachmentStateAttached {
+	if *attachment.State != ec2.VolumeAttachmentStateAttached {
 		return "", storageops.NewStorageError(storageops.ErrVolInval,
 			fmt.Sprintf("Invalid state %q, volume is not attached",
-				*vol.Attachments[0].State), "")
+				*attachment.State), "")
 	}
-	if vol.Attachments[0].Device == nil {
+	if attachment.Device == nil {
 		return "", storageops.NewStorageError(storageops.ErrVolInval,
 			"Unable to determine volume attachment path", "")
 	}
-	devicePath, err := s.getActualDevicePath(*vol.Attachments[0].Device, volumeID)
+	devicePath, err := s.getActualDevicePath(*attachment.Device, volumeID)
 	if err != nil {
 		return "", storageops.NewStorageError(storageops.ErrVolInval,
 			err.Error(), "")
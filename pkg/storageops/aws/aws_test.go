@@ -1,9 +1,23 @@
 package aws
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/opsworks"
 	"github.com/libopenstorage/openstorage/pkg/storageops"
@@ -93,3 +107,1485 @@ func TestAwsGetPrefixFromRootDeviceName(t *testing.T) {
 		assert.Equal(t, test.expectedPrefix, prefix)
 	}
 }
+
+func TestAwsValidateVolume(t *testing.T) {
+	tests := []struct {
+		volType     string
+		size        int64
+		iops        int64
+		throughput  int64
+		expectError bool
+	}{
+		{volType: ec2.VolumeTypeGp2, size: 100},
+		{volType: ec2.VolumeTypeGp2, size: 100, iops: 3000, expectError: true},
+		{volType: ec2.VolumeTypeGp2, size: 20000, expectError: true},
+		{volType: ec2.VolumeTypeIo1, size: 100, iops: 5000},
+		{volType: ec2.VolumeTypeIo1, size: 100, iops: 6000, expectError: true},
+		{volType: ec2.VolumeTypeIo1, size: 100, expectError: true},
+		{volType: ec2.VolumeTypeIo2, size: 65536, iops: 256000},
+		{volType: ec2.VolumeTypeIo2, size: 65537, iops: 256000, expectError: true},
+		{volType: ec2.VolumeTypeSt1, size: 100, expectError: true},
+		{volType: "io9", size: 100, expectError: true},
+		{volType: ec2.VolumeTypeGp3, size: 100, throughput: 750},
+		{volType: ec2.VolumeTypeGp3, size: 100, throughput: 1000, expectError: true},
+		{volType: ec2.VolumeTypeGp3, size: 100, iops: 4000, throughput: 1000},
+		{volType: ec2.VolumeTypeGp3, size: 100, throughput: 100, expectError: true},
+		{volType: ec2.VolumeTypeIo2, size: 100, iops: 1000, throughput: 500, expectError: true},
+	}
+
+	for _, test := range tests {
+		volType := test.volType
+		size := test.size
+		vol := &ec2.Volume{
+			VolumeType: &volType,
+			Size:       &size,
+		}
+		if test.iops != 0 {
+			iops := test.iops
+			vol.Iops = &iops
+		}
+		if test.throughput != 0 {
+			throughput := test.throughput
+			vol.Throughput = &throughput
+		}
+		err := validateVolume(vol)
+		assert.Equal(t, test.expectError, err != nil, "%s %d GiB %d IOPS: %v",
+			test.volType, test.size, test.iops, err)
+	}
+}
+
+func TestAwsFreeDevicesReservedSlots(t *testing.T) {
+	root := "/dev/xvda"
+	used := "/dev/xvdf"
+	mappings := []interface{}{
+		&ec2.InstanceBlockDeviceMapping{DeviceName: &root},
+		&ec2.InstanceBlockDeviceMapping{DeviceName: &used},
+	}
+
+	a := &ec2Ops{}
+	free, err := a.FreeDevices(mappings, root)
+	assert.NoError(t, err)
+	assert.Len(t, free, nitroAttachments-2)
+	assert.Equal(t, "/dev/xvdg", free[0])
+	assert.Equal(t, "/dev/xvdz", free[19])
+	assert.Equal(t, "/dev/xvdba", free[20])
+
+	a = &ec2Ops{cfg: Config{ReservedAttachSlots: 3}}
+	free, err = a.FreeDevices(mappings, root)
+	assert.NoError(t, err)
+	assert.Len(t, free, nitroAttachments-5)
+	assert.Equal(t, "/dev/xvdbb", free[len(free)-1])
+
+	a = &ec2Ops{cfg: Config{ReservedAttachSlots: nitroAttachments - 2}}
+	_, err = a.FreeDevices(mappings, root)
+	assert.EqualError(t, err, "No more free devices in /dev/xvd[f-z] and /dev/xvd[b-c][a-z]: "+
+		"2 of 27 attachment slots are in use and 25 free device(s) are reserved")
+
+	bad, volumeID := "/dev/xvdbca", "vol-1"
+	_, err = a.FreeDevices(append(mappings, &ec2.InstanceBlockDeviceMapping{
+		DeviceName: &bad,
+		Ebs:        &ec2.EbsInstanceBlockDevice{VolumeId: &volumeID},
+	}), root)
+	assert.EqualError(t, err, `cannot parse device name "/dev/xvdbca" of volume vol-1: `+
+		"expected a name in /dev/xvd[a-z] or /dev/xvd[b-c][a-z]")
+}
+
+func TestAwsFreeDevicesInstanceLimits(t *testing.T) {
+	root := "/dev/sda1"
+	mappings := []interface{}{&ec2.InstanceBlockDeviceMapping{DeviceName: &root}}
+	for _, name := range []string{"/dev/sdf", "/dev/xvdg", "/dev/xvdba"} {
+		name := name
+		mappings = append(mappings, &ec2.InstanceBlockDeviceMapping{DeviceName: &name})
+	}
+
+	// Two letter names are not valid with the /dev/sd prefix of the root
+	a := &ec2Ops{
+		instanceType: "x1e.32xlarge",
+		cfg: Config{InstanceTypeLimits: map[string]InstanceTypeLimits{
+			"x1e.32xlarge": {MaxAttachments: 40},
+		}},
+	}
+	free, err := a.FreeDevices(mappings, root)
+	assert.NoError(t, err)
+	assert.Len(t, free, 19)
+	assert.Equal(t, "/dev/sdh", free[0])
+	assert.Equal(t, "/dev/sdz", free[len(free)-1])
+
+	a.cfg.InstanceTypeLimits["x1e.32xlarge"] = InstanceTypeLimits{MaxAttachments: 6}
+	free, err = a.FreeDevices(mappings, root)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/dev/sdh", "/dev/sdi"}, free)
+
+	a.cfg.InstanceTypeLimits["x1e.32xlarge"] = InstanceTypeLimits{MaxAttachments: 4}
+	_, err = a.FreeDevices(mappings, root)
+	assert.Error(t, err)
+}
+
+func TestAwsAccountRegistry(t *testing.T) {
+	r := NewAccountRegistry("i-0", "m5.large", Config{
+		HTTP: &storageops.HTTPConfig{Timeout: 7 * time.Second},
+	}, "main")
+
+	_, err := r.Ops("")
+	assert.Error(t, err, "default account is not registered yet")
+
+	creds := credentials.NewStaticCredentials("id", "secret", "")
+	assert.NoError(t, r.Register("main", &CredentialSet{Region: "us-east-1", Credentials: creds}))
+	assert.NoError(t, r.Register("member", &CredentialSet{Region: "us-west-2", Credentials: creds}))
+	assert.Error(t, r.Register("bad", &CredentialSet{Credentials: creds}))
+
+	main, err := r.Ops("")
+	assert.NoError(t, err)
+	cached, err := r.Ops("main")
+	assert.NoError(t, err)
+	assert.True(t, main == cached, "clients must be cached per account")
+	assert.Equal(t, 7*time.Second, main.(*ec2Ops).ec2.Config.HTTPClient.Timeout,
+		"account clients must use the HTTP config of the driver")
+
+	member, err := r.OpsForLabels(map[string]string{AccountLabel: "member"})
+	assert.NoError(t, err)
+	assert.False(t, main == member)
+
+	assert.Error(t, r.MapTenant("acme", "unknown"))
+	assert.NoError(t, r.MapTenant("acme", "member"))
+	tenant, err := r.OpsForTenant("acme")
+	assert.NoError(t, err)
+	assert.True(t, tenant == member)
+
+	r.Unregister("member")
+	tenant, err = r.OpsForTenant("acme")
+	assert.NoError(t, err)
+	assert.True(t, tenant == main, "unmapped tenants use the default account")
+}
+
+func TestAwsConverter(t *testing.T) {
+	c, err := storageops.GetConverter("aws")
+	assert.NoError(t, err)
+
+	id, size, key, value, instance := "vol-1", int64(10), "Name", "data", "i-1"
+	raw := &ec2.Volume{
+		VolumeId:    &id,
+		Size:        &size,
+		Tags:        []*ec2.Tag{{Key: &key, Value: &value}},
+		Attachments: []*ec2.VolumeAttachment{{InstanceId: &instance}},
+	}
+	vol, err := c.ToVolume(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, "vol-1", vol.ID)
+	assert.Equal(t, "data", vol.Name)
+	assert.Equal(t, uint64(10), vol.SizeGiB)
+	assert.Equal(t, []string{"i-1"}, vol.AttachedTo)
+	assert.True(t, raw == vol.Raw)
+
+	deviceID, err := (&ec2Ops{}).GetDeviceID(vol)
+	assert.NoError(t, err)
+	assert.Equal(t, "vol-1", deviceID, "typed volumes must have a device ID")
+
+	_, err = c.ToVolume(&ec2.Snapshot{})
+	assert.Error(t, err)
+}
+
+func TestAwsDescribeCache(t *testing.T) {
+	id := "i-1"
+	a := &ec2Ops{instance: id}
+	a.cached = &ec2.Instance{InstanceId: &id}
+	a.cachedAt = time.Now()
+
+	instance, err := a.describe()
+	assert.NoError(t, err)
+	assert.True(t, instance == a.cached, "cached description should be reused")
+
+	a.invalidateDescribe()
+	assert.Nil(t, a.cached)
+
+	calls := 0
+	client, done := newFakeEC2(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprint(w, "<DescribeInstancesResponse><reservationSet><item><instancesSet>"+
+			"<item><instanceId>i-1</instanceId></item>"+
+			"</instancesSet></item></reservationSet></DescribeInstancesResponse>")
+	})
+	defer done()
+	a = &ec2Ops{instance: id, ec2: client, cfg: Config{DescribeCacheTTL: 50 * time.Millisecond}}
+	for i := 0; i < 3; i++ {
+		_, err = a.describe()
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, calls)
+	time.Sleep(60 * time.Millisecond)
+	_, err = a.describe()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls, "expired description should be refreshed")
+
+	os.Setenv("AWS_DESCRIBE_CACHE_TTL", "5s")
+	defer os.Unsetenv("AWS_DESCRIBE_CACHE_TTL")
+	cfg, err := configFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, 5*time.Second, cfg.DescribeCacheTTL)
+}
+
+func TestAwsInstanceMetadata(t *testing.T) {
+	const token = "imds-token"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latest/api/token":
+			w.Header().Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "21600")
+			fmt.Fprint(w, token)
+		case "/latest/meta-data/instance-id", "/latest/dynamic/instance-identity/document":
+			if r.Header.Get("X-Aws-Ec2-Metadata-Token") != token {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Path == "/latest/meta-data/instance-id" {
+				fmt.Fprint(w, "i-0123456789")
+				return
+			}
+			fmt.Fprint(w, `{"instanceId":"i-0123456789","instanceType":"m5.large",`+
+				`"region":"us-west-2","availabilityZone":"us-west-2b"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	c := ec2metadata.New(session.New(), &aws.Config{Endpoint: aws.String(server.URL)})
+	md, err := GetInstanceMetadata(c)
+	assert.NoError(t, err)
+	assert.Equal(t, &InstanceMetadata{
+		InstanceID:   "i-0123456789",
+		InstanceType: "m5.large",
+		Region:       "us-west-2",
+		Zone:         "us-west-2b",
+	}, md)
+}
+
+func TestAwsNvmeSerialFromList(t *testing.T) {
+	out := `Node             SN                   Model                                    Namespace Usage                      Format           FW Rev
+---------------- -------------------- ---------------------------------------- --------- -------------------------- ---------------- --------
+/dev/nvme0n1     vol00fd6f8c30dc619f4 Amazon Elastic Block Store               1           0.00   B / 137.44  GB    512   B +  0 B   1.0
+/dev/nvme1n1     vol044e12c8c0af45b3d Amazon Elastic Block Store               1           0.00   B / 107.37  GB    512   B +  0 B   1.0
+`
+	assert.Equal(t, "vol044e12c8c0af45b3d", nvmeSerialFromList(out, "/dev/nvme1n1"))
+	assert.Equal(t, "", nvmeSerialFromList(out, "/dev/nvme2n1"))
+}
+
+func TestAwsNvmeSysfsSerials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sysblock")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for name, attrs := range map[string][2]string{
+		"nvme0n1": {"Amazon Elastic Block Store\n", "vol00fd6f8c30dc619f4\n"},
+		"nvme1n1": {"Amazon Elastic Block Store  \n", "vol044e12c8c0af45b3d  \n"},
+		"nvme2n1": {"Amazon EC2 NVMe Instance Storage\n", "AWS1234\n"},
+	} {
+		devDir := filepath.Join(dir, name, "device")
+		assert.NoError(t, os.MkdirAll(devDir, 0755))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(devDir, "model"), []byte(attrs[0]), 0644))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(devDir, "serial"), []byte(attrs[1]), 0644))
+	}
+	defer func(path string) { sysBlockPath = path }(sysBlockPath)
+	sysBlockPath = dir
+
+	serials, err := nvmeSysfsSerials()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"/dev/nvme0n1": "vol00fd6f8c30dc619f4",
+		"/dev/nvme1n1": "vol044e12c8c0af45b3d",
+	}, serials)
+
+	devicePath, err := getNvmeDeviceFromSysfs("vol-044e12c8c0af45b3d")
+	assert.NoError(t, err)
+	assert.Equal(t, "/dev/nvme1n1", devicePath)
+	_, err = getNvmeDeviceFromSysfs("vol-0123456789abcdef0")
+	assert.Error(t, err)
+
+	s := &ec2Ops{selfTest: &lazySelfTest{report: &SelfTestReport{NvmeStrategy: string(nvmeStrategySysfs)}}}
+	assert.Equal(t, "vol-00fd6f8c30dc619f4", s.volumeIDFromNvmeSerial("/dev/nvme0n1"))
+	assert.Equal(t, "", s.volumeIDFromNvmeSerial("/dev/nvme2n1"))
+}
+
+func TestAwsSelfTest(t *testing.T) {
+	defer func(probe func() *storageops.HostCapabilities) { probeHost = probe }(probeHost)
+	probes := 0
+	host := &storageops.HostCapabilities{KernelVersion: "5.10.0", Udev: true, DiskByID: true}
+	probeHost = func() *storageops.HostCapabilities {
+		probes++
+		return host
+	}
+
+	s := NewEc2Storage("i-1", "m5.large", ec2.New(session.New()))
+	assert.Zero(t, probes, "the self test must not run when the driver is created")
+	report := s.(SelfTester).SelfTestReport()
+	assert.Equal(t, host, report.Host)
+	assert.Equal(t, string(nvmeStrategyByID), report.NvmeStrategy)
+	assert.Len(t, report.Warnings, 2, "%v", report.Warnings)
+	assert.Equal(t, report, s.(storageops.DryRunner).DryRun().(SelfTester).SelfTestReport())
+	assert.Equal(t, 1, probes, "the self test must run once")
+
+	host = &storageops.HostCapabilities{KernelVersion: "5.10.0", NvmeCli: "/usr/sbin/nvme", SysBlock: true}
+	report = SelfTest()
+	assert.Equal(t, string(nvmeStrategySysfs), report.NvmeStrategy)
+	assert.Equal(t, string(nvmeStrategyCli), report.NvmeFallback)
+	assert.Empty(t, report.Warnings)
+
+	host = &storageops.HostCapabilities{KernelVersion: "3.10.0"}
+	report = SelfTest()
+	assert.Equal(t, string(nvmeStrategyNone), report.NvmeStrategy)
+	assert.Len(t, report.Warnings, 3, "%v", report.Warnings)
+
+	assert.Empty(t, (&ec2Ops{}).nvmeStrategies())
+}
+
+func TestAwsNvmeExposed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sysblock")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(path string) { sysBlockPath = path }(sysBlockPath)
+	sysBlockPath = dir
+
+	lookups := 0
+	client, done := newFakeEC2(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "DescribeInstanceTypes", r.Form.Get("Action"))
+		lookups++
+		hypervisor := map[string]string{"m7i.large": "nitro", "m4.large": "xen"}[r.Form.Get("InstanceType.1")]
+		if len(hypervisor) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `<Response><Errors><Error><Code>InvalidInstanceType</Code>`+
+				`<Message>invalid</Message></Error></Errors></Response>`)
+			return
+		}
+		fmt.Fprintf(w, `<DescribeInstanceTypesResponse><instanceTypeSet><item>`+
+			`<instanceType>%s</instanceType><hypervisor>%s</hypervisor>`+
+			`</item></instanceTypeSet></DescribeInstanceTypesResponse>`,
+			r.Form.Get("InstanceType.1"), hypervisor)
+	})
+	defer done()
+
+	nitro := &ec2Ops{instanceType: "m7i.large", ec2: client}
+	assert.True(t, nitro.nvmeExposed())
+	assert.True(t, nitro.nvmeExposed())
+	xen := &ec2Ops{instanceType: "m4.large", ec2: client}
+	assert.False(t, xen.nvmeExposed())
+	assert.Equal(t, 2, lookups)
+	// Failed lookups are retried
+	unknown := &ec2Ops{instanceType: "x9.huge", ec2: client}
+	assert.True(t, unknown.nvmeExposed())
+	assert.True(t, unknown.nvmeExposed())
+	assert.True(t, lookups > 3)
+
+	// NVMe EBS controllers on the host need no lookup
+	devDir := filepath.Join(dir, "nvme0n1", "device")
+	assert.NoError(t, os.MkdirAll(devDir, 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(devDir, "model"), []byte(nvmeEbsModel), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(devDir, "serial"), []byte("vol00fd6f8c30dc619f4"), 0644))
+	lookups = 0
+	assert.True(t, (&ec2Ops{instanceType: "m4.large", ec2: client}).nvmeExposed())
+	assert.Equal(t, 0, lookups)
+}
+
+func TestAwsProviderLimits(t *testing.T) {
+	client, done := newFakeEC2(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "DescribeInstanceTypes", r.Form.Get("Action"))
+		if r.Form.Get("InstanceType.1") != "m6i.large" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `<Response><Errors><Error><Code>InvalidInstanceType</Code>`+
+				`<Message>invalid</Message></Error></Errors></Response>`)
+			return
+		}
+		fmt.Fprint(w, `<DescribeInstanceTypesResponse><instanceTypeSet><item>`+
+			`<instanceType>m6i.large</instanceType><ebsInfo><ebsOptimizedInfo>`+
+			`<maximumIops>20000</maximumIops><maximumThroughputInMBps>1250.0</maximumThroughputInMBps>`+
+			`</ebsOptimizedInfo></ebsInfo></item></instanceTypeSet></DescribeInstanceTypesResponse>`)
+	})
+	defer done()
+
+	a := &ec2Ops{instanceType: "m6i.large", ec2: client}
+	limits, err := storageops.GetProviderLimits(a)
+	assert.NoError(t, err)
+	assert.Equal(t, nitroAttachments, limits.MaxAttachments)
+	assert.Equal(t, uint64(64*1024), limits.MaxVolumeSizeGiB)
+	assert.Equal(t, int64(20000), limits.MaxInstanceIops)
+	assert.Equal(t, int64(1192), limits.MaxInstanceThroughputMiBps)
+	assert.Equal(t, storageops.VolumeTypeLimits{
+		MinSizeGiB: 1, MaxSizeGiB: 16384, MaxIops: 16000, MaxThroughputMiBps: 1000,
+	}, limits.VolumeTypes[ec2.VolumeTypeGp3])
+	assert.Equal(t, int64(250), limits.VolumeTypes[ec2.VolumeTypeGp2].MaxThroughputMiBps)
+
+	// The configured and default limits are used if the type can not be described
+	a = &ec2Ops{instanceType: "m5.large", ec2: client}
+	limits, err = a.ProviderLimits()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3600), limits.MaxInstanceIops)
+	a = &ec2Ops{instanceType: "x9.huge", ec2: client, cfg: Config{
+		InstanceTypeLimits: map[string]InstanceTypeLimits{"x9.huge": {Iops: 1, MaxAttachments: 4}},
+	}}
+	limits, err = a.ProviderLimits()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), limits.MaxInstanceIops)
+	assert.Equal(t, 4, limits.MaxAttachments)
+	_, err = (&ec2Ops{instanceType: "x9.huge", ec2: client}).ProviderLimits()
+	assert.Error(t, err)
+}
+
+func TestAwsDryRun(t *testing.T) {
+	var actions []string
+	client, done := newFakeEC2(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		action := r.Form.Get("Action")
+		if action == "DescribeVolumes" {
+			fmt.Fprint(w, `<DescribeVolumesResponse><volumeSet><item><volumeId>vol-1</volumeId>`+
+				`<status>available</status><size>10</size><volumeType>io2</volumeType>`+
+				`<iops>1000</iops><multiAttachEnabled>false</multiAttachEnabled>`+
+				`</item></volumeSet></DescribeVolumesResponse>`)
+			return
+		}
+		actions = append(actions, action)
+		assert.Equal(t, "true", r.Form.Get("DryRun"), action)
+		w.WriteHeader(http.StatusPreconditionFailed)
+		code := "DryRunOperation"
+		if action == "DeleteVolume" {
+			code = "UnauthorizedOperation"
+		}
+		fmt.Fprintf(w, `<Response><Errors><Error><Code>%s</Code>`+
+			`<Message>dry run</Message></Error></Errors></Response>`, code)
+	})
+	defer done()
+
+	s := &ec2Ops{instance: "i-1", ec2: client, cfg: Config{ReservedAttachSlots: 2}}
+	ops, err := storageops.DryRun(s)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, ops.(*ec2Ops).cfg.ReservedAttachSlots, "the configuration must be kept")
+	assert.False(t, s.cfg.DryRun, "the driver must not be changed")
+
+	_, err = ops.Create(&ec2.Volume{
+		AvailabilityZone: aws.String("us-east-1a"),
+		Size:             aws.Int64(10),
+		VolumeType:       aws.String(ec2.VolumeTypeGp3),
+	}, map[string]string{"app": "db"})
+	assert.True(t, storageops.IsDryRunSuccess(err), "%v", err)
+	_, err = ops.Snapshot("vol-1", false)
+	assert.True(t, storageops.IsDryRunSuccess(err), "%v", err)
+	err = ops.DetachFrom("vol-1", "i-2")
+	assert.True(t, storageops.IsDryRunSuccess(err), "%v", err)
+	err = ops.Delete("vol-1")
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrUnauthorized), "%v", err)
+	_, err = ops.Expand("vol-1", 20)
+	assert.True(t, storageops.IsDryRunSuccess(err), "%v", err)
+	err = ops.Modify("vol-1", storageops.VolumeSpecUpdate{Iops: 2000})
+	assert.True(t, storageops.IsDryRunSuccess(err), "%v", err)
+	err = ops.ApplyTags("vol-1", map[string]string{"app": "db"})
+	assert.True(t, storageops.IsDryRunSuccess(err), "%v", err)
+	err = ops.RemoveTags("vol-1", map[string]string{"app": "db"})
+	assert.True(t, storageops.IsDryRunSuccess(err), "%v", err)
+	err = ops.SnapshotDelete("snap-1")
+	assert.True(t, storageops.IsDryRunSuccess(err), "%v", err)
+	err = ops.(*ec2Ops).enableMultiAttach("vol-1")
+	assert.True(t, storageops.IsDryRunSuccess(err), "%v", err)
+	// Nothing is waited for or tagged after a dry run
+	assert.Equal(t, []string{"CreateVolume", "CreateSnapshot", "DetachVolume", "DeleteVolume",
+		"ModifyVolume", "ModifyVolume", "CreateTags", "DeleteTags", "DeleteSnapshot",
+		"ModifyVolume"}, actions)
+
+	_, err = storageops.DryRun(storageops.NewMetricsOps(ops, storageops.NewMetricsCollector()))
+	assert.Equal(t, storageops.ErrNotSupported, err)
+}
+
+func TestAwsTagOnCreate(t *testing.T) {
+	var actions []string
+	client, done := newFakeEC2(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		action := r.Form.Get("Action")
+		actions = append(actions, action)
+		switch action {
+		case "CreateVolume":
+			assert.Equal(t, "volume", r.Form.Get("TagSpecification.1.ResourceType"))
+			assert.Equal(t, "app", r.Form.Get("TagSpecification.1.Tag.1.Key"))
+			assert.Equal(t, "db", r.Form.Get("TagSpecification.1.Tag.1.Value"))
+			fmt.Fprint(w, "<CreateVolumeResponse><volumeId>vol-1</volumeId></CreateVolumeResponse>")
+		case "DescribeVolumes":
+			fmt.Fprint(w, "<DescribeVolumesResponse><volumeSet><item>"+
+				"<volumeId>vol-1</volumeId><status>available</status>"+
+				"<tagSet><item><key>app</key><value>db</value></item></tagSet>"+
+				"</item></volumeSet></DescribeVolumesResponse>")
+		case "CreateSnapshot":
+			if r.Form.Get("VolumeId") == "vol-2" {
+				assert.Empty(t, r.Form.Get("TagSpecification.1.ResourceType"))
+			} else {
+				assert.Equal(t, "snapshot", r.Form.Get("TagSpecification.1.ResourceType"))
+				assert.Equal(t, "app", r.Form.Get("TagSpecification.1.Tag.1.Key"))
+			}
+			fmt.Fprint(w, "<CreateSnapshotResponse><snapshotId>snap-1</snapshotId></CreateSnapshotResponse>")
+		}
+	})
+	defer done()
+
+	a := &ec2Ops{instance: "i-1", ec2: client}
+	out, err := a.Create(&ec2.Volume{
+		AvailabilityZone: aws.String("us-east-1a"),
+		Size:             aws.Int64(10),
+		VolumeType:       aws.String(ec2.VolumeTypeGp3),
+	}, map[string]string{"app": "db"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"app": "db"}, out.Labels)
+	_, err = storageops.SnapshotWithLabels(a, "vol-1", true, map[string]string{"app": "db"})
+	assert.NoError(t, err)
+	_, err = a.Snapshot("vol-2", true)
+	assert.NoError(t, err)
+	// Nothing is tagged after it is created
+	assert.NotContains(t, actions, "CreateTags")
+}
+
+func TestAwsClientToken(t *testing.T) {
+	var creates int
+	client, done := newFakeEC2(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		switch r.Form.Get("Action") {
+		case "CreateVolume":
+			creates++
+			assert.Equal(t, "token-1", r.Form.Get("ClientToken"))
+			fmt.Fprint(w, "<CreateVolumeResponse><volumeId>vol-1</volumeId></CreateVolumeResponse>")
+		case "DescribeVolumes":
+			if r.Form.Get("Filter.1.Name") == "tag:"+storageops.ClientTokenLabel {
+				assert.Equal(t, "token-1", r.Form.Get("Filter.1.Value.1"))
+				if creates == 0 {
+					fmt.Fprint(w, "<DescribeVolumesResponse><volumeSet/></DescribeVolumesResponse>")
+					return
+				}
+			}
+			fmt.Fprint(w, "<DescribeVolumesResponse><volumeSet><item>"+
+				"<volumeId>vol-1</volumeId><status>available</status>"+
+				"<tagSet><item><key>"+storageops.ClientTokenLabel+"</key><value>token-1</value></item></tagSet>"+
+				"</item></volumeSet></DescribeVolumesResponse>")
+		default:
+			t.Errorf("unexpected %s", r.Form.Get("Action"))
+		}
+	})
+	defer done()
+
+	a := &ec2Ops{instance: "i-1", ec2: client}
+	template := &ec2.Volume{
+		AvailabilityZone: aws.String("us-east-1a"),
+		Size:             aws.Int64(10),
+		VolumeType:       aws.String(ec2.VolumeTypeGp3),
+	}
+	for i := 0; i < 2; i++ {
+		out, err := storageops.CreateIdempotent(a, template, nil, "token-1")
+		assert.NoError(t, err)
+		assert.Equal(t, "vol-1", out.ID)
+	}
+	assert.Equal(t, 1, creates, "retried create should find the volume of its token")
+}
+
+func TestAwsSnapshotTags(t *testing.T) {
+	client, done := newFakeEC2(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		switch r.Form.Get("Action") {
+		case "CreateTags":
+			assert.Equal(t, "snap-1", r.Form.Get("ResourceId.1"))
+			fmt.Fprint(w, "<CreateTagsResponse><return>true</return></CreateTagsResponse>")
+		case "DescribeSnapshots":
+			assert.Equal(t, "snap-1", r.Form.Get("SnapshotId.1"))
+			fmt.Fprint(w, "<DescribeSnapshotsResponse><snapshotSet><item>"+
+				"<snapshotId>snap-1</snapshotId><status>completed</status>"+
+				"<tagSet><item><key>pvc</key><value>data</value></item></tagSet>"+
+				"</item></snapshotSet></DescribeSnapshotsResponse>")
+		default:
+			t.Errorf("unexpected %s", r.Form.Get("Action"))
+		}
+	})
+	defer done()
+
+	a := &ec2Ops{instance: "i-1", ec2: client}
+	assert.NoError(t, a.ApplyTags("snap-1", map[string]string{"pvc": "data"}))
+	tags, err := a.Tags("snap-1")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"pvc": "data"}, tags)
+}
+
+func TestAwsAttached(t *testing.T) {
+	client, done := newFakeEC2(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		var attachments string
+		switch r.Form.Get("VolumeId.1") {
+		case "vol-multi":
+			attachments = "<item><instanceId>i-0</instanceId><device>/dev/sdf</device><status>detached</status></item>" +
+				"<item><instanceId>i-2</instanceId><device>/dev/sdg</device><status>attached</status></item>" +
+				"<item><instanceId>i-1</instanceId><device>/dev/sdh</device><status>attaching</status></item>"
+		case "vol-remote":
+			attachments = "<item><instanceId>i-2</instanceId><device>/dev/sdg</device><status>attached</status></item>"
+		}
+		fmt.Fprintf(w, "<DescribeVolumesResponse><volumeSet><item>"+
+			"<volumeId>%s</volumeId><status>in-use</status><attachmentSet>%s</attachmentSet>"+
+			"</item></volumeSet></DescribeVolumesResponse>", r.Form.Get("VolumeId.1"), attachments)
+	})
+	defer done()
+
+	a := &ec2Ops{instance: "i-1", ec2: client}
+	instanceID, device, state, err := a.Attached("vol-multi")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"i-1", "/dev/sdh", "attaching"}, []string{instanceID, device, state})
+	instanceID, device, state, err = a.Attached("vol-remote")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"i-2", "/dev/sdg", "attached"}, []string{instanceID, device, state})
+	instanceID, _, _, err = a.Attached("vol-free")
+	assert.NoError(t, err)
+	assert.Empty(t, instanceID)
+}
+
+func TestAwsFromSpec(t *testing.T) {
+	out, err := (&converter{}).FromSpec(&storageops.VolumeSpec{
+		SizeGiB:         100,
+		Type:            ec2.VolumeTypeGp3,
+		Iops:            4000,
+		ThroughputMiBps: 250,
+		KMSKeyID:        "alias/ebs",
+		SnapshotID:      "snap-1",
+		Native:          &ec2.Volume{AvailabilityZone: aws.String("us-east-1a"), MultiAttachEnabled: aws.Bool(true)},
+	})
+	assert.NoError(t, err)
+	vol := out.(*ec2.Volume)
+	assert.Equal(t, int64(100), aws.Int64Value(vol.Size))
+	assert.Equal(t, ec2.VolumeTypeGp3, aws.StringValue(vol.VolumeType))
+	assert.Equal(t, int64(4000), aws.Int64Value(vol.Iops))
+	assert.Equal(t, int64(250), aws.Int64Value(vol.Throughput))
+	assert.Equal(t, "us-east-1a", aws.StringValue(vol.AvailabilityZone))
+	assert.True(t, aws.BoolValue(vol.MultiAttachEnabled))
+	// A KMS key implies encryption
+	assert.True(t, aws.BoolValue(vol.Encrypted))
+	assert.Equal(t, "alias/ebs", aws.StringValue(vol.KmsKeyId))
+	assert.Equal(t, "snap-1", aws.StringValue(vol.SnapshotId))
+
+	_, err = (&converter{}).FromSpec(&storageops.VolumeSpec{Native: &ec2.Snapshot{}})
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval), "%v", err)
+}
+
+// newFakeEC2 returns an EC2 client that sends its requests to handler
+func newFakeEC2(t *testing.T, handler http.HandlerFunc) (*ec2.EC2, func()) {
+	server := httptest.NewServer(handler)
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:    aws.String(server.URL),
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	assert.NoError(t, err)
+	return ec2.New(sess), server.Close
+}
+
+func TestAwsEnumeratePages(t *testing.T) {
+	pages := map[string]string{
+		"": `<volumeSet><item><volumeId>vol-1</volumeId><status>available</status>` +
+			`<tagSet><item><key>set</key><value>a</value></item></tagSet></item></volumeSet>` +
+			`<nextToken>page2</nextToken>`,
+		"page2": `<volumeSet><item><volumeId>vol-2</volumeId><status>available</status></item>` +
+			`<item><volumeId>vol-3</volumeId><status>deleting</status></item></volumeSet>`,
+	}
+	calls := 0
+	client, done := newFakeEC2(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "DescribeVolumes", r.Form.Get("Action"))
+		fmt.Fprintf(w, "<DescribeVolumesResponse>%s</DescribeVolumesResponse>",
+			pages[r.Form.Get("NextToken")])
+	})
+	defer done()
+
+	a := &ec2Ops{instance: "i-1", ec2: client}
+	sets, err := a.Enumerate(nil, nil, "set")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+	assert.Len(t, sets["a"], 1)
+	assert.Len(t, sets[storageops.SetIdentifierNone], 1)
+
+	calls = 0
+	var seen []string
+	err = a.EnumeratePages(nil, nil, "", 5, func(set string, vol *ec2.Volume) bool {
+		seen = append(seen, aws.StringValue(vol.VolumeId))
+		return false
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vol-1"}, seen)
+	assert.Equal(t, 1, calls)
+}
+
+func TestAwsAttachDevice(t *testing.T) {
+	free := []string{"/dev/xvdf", "/dev/xvdg", "/dev/xvdh"}
+
+	device, err := attachDevice(free, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "/dev/xvdf", device)
+
+	for _, requested := range []string{"/dev/xvdg", "/dev/sdg", "g"} {
+		device, err = attachDevice(free, requested)
+		assert.NoError(t, err)
+		assert.Equal(t, "/dev/xvdg", device, requested)
+	}
+
+	_, err = attachDevice(free, "/dev/xvdz")
+	assert.Error(t, err)
+}
+
+func TestAwsDeviceQuarantine(t *testing.T) {
+	free := []string{"/dev/xvdf", "/dev/xvdg", "/dev/xvdh"}
+
+	a := &ec2Ops{instance: "i-quarantine", cfg: Config{DeviceQuarantine: 50 * time.Millisecond}}
+	a.quarantineDevice("i-quarantine", "/dev/sdf")
+	devices, err := a.awaitQuarantine(free, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/dev/xvdg", "/dev/xvdh"}, devices)
+
+	start := time.Now()
+	devices, err = a.awaitQuarantine(free, []string{"f"})
+	assert.NoError(t, err)
+	assert.Equal(t, free, devices)
+	assert.True(t, time.Since(start) >= 40*time.Millisecond)
+
+	a = &ec2Ops{instance: "i-quarantine", cfg: Config{DeviceQuarantine: -1}}
+	a.quarantineDevice("i-quarantine", "/dev/xvdf")
+	devices, err = a.awaitQuarantine(free, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, free, devices)
+}
+
+func TestAwsSpareDevices(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reservations")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	a := &ec2Ops{instance: "i-spares", cfg: Config{DeviceReservationDir: dir}}
+	other, err := storageops.ReserveDevice(dir, "i-spares", "g")
+	assert.NoError(t, err)
+	defer other.Release()
+
+	free := []string{"/dev/xvdf", "/dev/xvdg", "/dev/xvdh", "/dev/xvdi"}
+	spares := newSpareDevices(free, "/dev/xvdf")
+	device, r, err := spares.reserve(a)
+	assert.NoError(t, err)
+	assert.Equal(t, "/dev/xvdh", device)
+	defer r.Release()
+	// Reservations are by letters, /dev/sdh is /dev/xvdh
+	_, err = a.reserveDevice("/dev/sdh")
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrDeviceBusy), "%v", err)
+
+	device, r, err = spares.reserve(a)
+	assert.NoError(t, err)
+	assert.Equal(t, "/dev/xvdi", device)
+	r.Release()
+	_, _, err = spares.reserve(a)
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrDeviceBusy), "%v", err)
+
+	assert.True(t, isDeviceInUse(awserr.New("InvalidParameterValue",
+		"Invalid value '/dev/sdf' for unixDevice. Attachment point /dev/sdf is already in use", nil)))
+	assert.True(t, isDeviceInUse(awserr.New("InvalidDevice.InUse", "in use", nil)))
+	assert.False(t, isDeviceInUse(awserr.New("InvalidParameterValue", "invalid volume", nil)))
+	assert.False(t, isDeviceInUse(fmt.Errorf("already in use")))
+}
+
+func TestAwsSnapshotCopy(t *testing.T) {
+	var actions []string
+	client, done := newFakeEC2(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		action := r.Form.Get("Action")
+		actions = append(actions, action)
+		// Requests are signed for the region they are sent to
+		assert.Contains(t, r.Header.Get("Authorization"), "/us-west-2/ec2/")
+		switch action {
+		case "CopySnapshot":
+			assert.Equal(t, "us-east-1", r.Form.Get("SourceRegion"))
+			assert.Equal(t, "snap-1", r.Form.Get("SourceSnapshotId"))
+			// The copy is tagged when it is created
+			assert.Equal(t, "snapshot", r.Form.Get("TagSpecification.1.ResourceType"))
+			assert.Equal(t, "dr", r.Form.Get("TagSpecification.1.Tag.1.Key"))
+			fmt.Fprint(w, "<CopySnapshotResponse><snapshotId>snap-2</snapshotId></CopySnapshotResponse>")
+		case "DescribeSnapshots":
+			fmt.Fprint(w, "<DescribeSnapshotsResponse><snapshotSet><item>"+
+				"<snapshotId>snap-2</snapshotId><status>completed</status><progress>100%</progress>"+
+				"<tagSet><item><key>dr</key><value>true</value></item></tagSet>"+
+				"</item></snapshotSet></DescribeSnapshotsResponse>")
+		}
+	})
+	defer done()
+
+	a := &ec2Ops{instance: "i-1", ec2: client}
+	out, err := a.SnapshotCopy("snap-1", "us-west-2", map[string]string{"dr": "true"})
+	assert.NoError(t, err)
+	snap := out.(*ec2.Snapshot)
+	assert.Equal(t, "snap-2", aws.StringValue(snap.SnapshotId))
+	assert.Len(t, snap.Tags, 1)
+	assert.Equal(t, []string{"CopySnapshot", "DescribeSnapshots"}, actions)
+
+	_, err = a.SnapshotCopy("snap-1", "", nil)
+	assert.Error(t, err)
+}
+
+func TestAwsReconcileMappings(t *testing.T) {
+	instanceView := map[string]string{
+		"vol-ok":      "/dev/sdf",
+		"vol-stale":   "/dev/sdg",
+		"vol-nolocal": "/dev/sdh",
+	}
+	volumeView := map[string]string{
+		"vol-ok":      "/dev/sdf",
+		"vol-lagging": "/dev/sdi",
+		"vol-nolocal": "/dev/sdh",
+	}
+	localDevices := map[string]string{
+		"vol-ok":      "/dev/xvdf",
+		"vol-lagging": "/dev/xvdi",
+	}
+	mappings, discrepancies := reconcileMappings(instanceView, volumeView,
+		func(device, volumeID string) (string, bool) {
+			path, ok := localDevices[volumeID]
+			return path, ok
+		})
+	assert.Equal(t, map[string]string{
+		"/dev/xvdf": "vol-ok",
+		"/dev/xvdi": "vol-lagging",
+		"/dev/sdh":  "vol-nolocal",
+	}, mappings)
+
+	assert.Len(t, discrepancies, 3)
+	assert.Equal(t, &MappingDiscrepancy{
+		VolumeID: "vol-lagging",
+		Device:   "/dev/sdi",
+		Attached: true,
+		Stale:    []MappingSource{MappingSourceInstance},
+	}, discrepancies[0])
+	assert.Equal(t, []MappingSource{MappingSourceLocal}, discrepancies[1].Stale)
+	assert.Equal(t, "vol-stale", discrepancies[2].VolumeID)
+	assert.False(t, discrepancies[2].Attached)
+	assert.Equal(t, []MappingSource{MappingSourceInstance}, discrepancies[2].Stale)
+}
+
+func TestAwsThrottling(t *testing.T) {
+	calls := 0
+	client, done := newFakeEC2(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, "<Response><Errors><Error><Code>RequestLimitExceeded</Code>"+
+				"<Message>Request limit exceeded.</Message></Error></Errors></Response>")
+			return
+		}
+		fmt.Fprint(w, "<DescribeVolumesResponse><volumeSet></volumeSet></DescribeVolumesResponse>")
+	})
+	defer done()
+
+	var throttled []string
+	storageops.AddThrottleObserver(func(provider, api string) {
+		throttled = append(throttled, provider+"/"+api)
+	})
+	bucket := installRateLimiter(client, 10, 5)
+	assert.Nil(t, installRateLimiter(client, -1, 0))
+	bucket = installRateLimiter(client, 10, 5)
+
+	_, err := client.DescribeVolumes(&ec2.DescribeVolumesInput{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, []string{"aws/DescribeVolumes"}, throttled)
+	// halved by the throttle, then raised by the successful retry
+	assert.Equal(t, 5.5, bucket.Rate())
+}
+
+func TestAwsEmbeddedProfile(t *testing.T) {
+	calls := 0
+	client, done := newFakeEC2(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprint(w, "<DescribeInstancesResponse><reservationSet><item><instancesSet>"+
+			"<item><instanceId>i-1</instanceId></item>"+
+			"</instancesSet></item></reservationSet></DescribeInstancesResponse>")
+	})
+	defer done()
+
+	installRateLimiter(client, 10, 5)
+	limited := client.Handlers.Send.Len()
+	a := NewEmbeddedClient("i-1", "m5.large", client).(*ec2Ops)
+	assert.Equal(t, limited-1, client.Handlers.Send.Len(), "embedded profile should not rate limit")
+
+	for i := 0; i < 2; i++ {
+		_, err := a.Describe()
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, calls, "embedded profile should not cache the description")
+	assert.Nil(t, a.cached)
+
+	os.Setenv("AWS_STORAGEOPS_PROFILE", "embedded")
+	defer os.Unsetenv("AWS_STORAGEOPS_PROFILE")
+	cfg, err := configFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, EmbeddedConfig(), cfg)
+
+	os.Setenv("AWS_STORAGEOPS_PROFILE", "bogus")
+	_, err = configFromEnv()
+	assert.Error(t, err)
+}
+
+func TestAwsAssignBatchDevices(t *testing.T) {
+	free := []string{"/dev/xvdf", "/dev/xvdg", "/dev/xvdh"}
+	reqs := []*storageops.AttachRequest{
+		{VolumeID: "vol-1"},
+		{VolumeID: "vol-2", Options: map[string]string{storageops.AttachOptionDevice: "f"}},
+		{VolumeID: "vol-3"},
+		{VolumeID: "vol-4"},
+		{VolumeID: "vol-5", Options: map[string]string{storageops.AttachOptionDevice: "/dev/xvdf"}},
+	}
+
+	devices, errs := assignBatchDevices(free, reqs)
+	assert.Equal(t, []string{"/dev/xvdg", "/dev/xvdf", "/dev/xvdh", "", ""}, devices)
+	assert.NoError(t, errs[0])
+	assert.NoError(t, errs[1])
+	assert.NoError(t, errs[2])
+	assert.Error(t, errs[3], "no device should be left")
+	assert.Error(t, errs[4], "requested device should already be taken")
+}
+
+func TestAwsStorageErrors(t *testing.T) {
+	a := &ec2Ops{instance: "i-1"}
+	for code, expected := range map[string]int{
+		"InvalidVolume.NotFound":  storageops.ErrVolNotFound,
+		"RequestLimitExceeded":    storageops.ErrThrottled,
+		"VolumeLimitExceeded":     storageops.ErrQuotaExceeded,
+		"UnauthorizedOperation":   storageops.ErrUnauthorized,
+		"VolumeInUse":             storageops.ErrVolAlreadyAttached,
+		"InvalidParameterValue":   storageops.ErrVolInval,
+		"ThrottlingException":     storageops.ErrThrottled,
+		"AttachmentLimitExceeded": storageops.ErrQuotaExceeded,
+	} {
+		err := a.storageError(awserr.New(code, "message", nil))
+		assert.True(t, storageops.IsErrorCode(err, expected), "code %s mapped to %v", code, err)
+		var awsErr awserr.Error
+		assert.True(t, errors.As(err, &awsErr), "aws error of %s should be kept", code)
+	}
+
+	unknown := awserr.New("SomethingElse", "message", nil)
+	assert.Equal(t, unknown, a.storageError(unknown))
+	assert.Nil(t, a.storageError(nil))
+}
+
+func TestAwsModifyAsync(t *testing.T) {
+	var polls int
+	client, done := newFakeEC2(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		switch r.Form.Get("Action") {
+		case "DescribeVolumes":
+			fmt.Fprint(w, "<DescribeVolumesResponse><volumeSet><item>"+
+				"<volumeId>vol-1</volumeId><status>available</status><size>100</size>"+
+				"<volumeType>gp3</volumeType><iops>3000</iops><throughput>125</throughput>"+
+				"</item></volumeSet></DescribeVolumesResponse>")
+		case "ModifyVolume":
+			assert.Equal(t, "6000", r.Form.Get("Iops"))
+			fmt.Fprint(w, "<ModifyVolumeResponse><volumeModification>"+
+				"<volumeId>vol-1</volumeId><modificationState>modifying</modificationState>"+
+				"</volumeModification></ModifyVolumeResponse>")
+		case "DescribeVolumesModifications":
+			polls++
+			state, progress := "modifying", "40"
+			if polls > 1 {
+				state, progress = "optimizing", "60"
+			}
+			fmt.Fprintf(w, "<DescribeVolumesModificationsResponse><volumeModificationSet><item>"+
+				"<volumeId>vol-1</volumeId><modificationState>%s</modificationState>"+
+				"<progress>%s</progress></item></volumeModificationSet>"+
+				"</DescribeVolumesModificationsResponse>", state, progress)
+		default:
+			t.Errorf("unexpected %s", r.Form.Get("Action"))
+		}
+	})
+	defer done()
+
+	a := &ec2Ops{instance: "i-1", ec2: client}
+	op, err := storageops.ModifyAsync(a, "vol-1", storageops.VolumeSpecUpdate{Iops: 6000})
+	assert.NoError(t, err)
+	assert.Equal(t, "vol-1", op.ID())
+	status, err := op.Status()
+	assert.NoError(t, err)
+	assert.Equal(t, storageops.OperationRunning, status.State)
+	assert.Equal(t, 40, status.Progress)
+	_, err = op.Wait(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, polls)
+	assert.Equal(t, storageops.ErrNotSupported, op.Cancel())
+}
+
+func TestAwsExpand(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		volume string
+		size   uint64
+		code   int
+	}{
+		{"gp2 with baseline IOPS", "<volumeType>gp2</volumeType><iops>300</iops>", 200, 0},
+		{"standard", "<volumeType>standard</volumeType><iops>100</iops>", 200, 0},
+		{"small gp3 with baseline IOPS",
+			"<volumeType>gp3</volumeType><iops>3000</iops><throughput>125</throughput>", 4, 0},
+		{"too large", "<volumeType>standard</volumeType>", 2048, storageops.ErrVolInval},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var modified string
+			client, done := newFakeEC2(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.NoError(t, r.ParseForm())
+				switch r.Form.Get("Action") {
+				case "DescribeVolumes":
+					fmt.Fprintf(w, "<DescribeVolumesResponse><volumeSet><item>"+
+						"<volumeId>vol-1</volumeId><status>available</status><size>2</size>%s"+
+						"</item></volumeSet></DescribeVolumesResponse>", tc.volume)
+				case "ModifyVolume":
+					assert.Empty(t, r.Form.Get("Iops"))
+					assert.Empty(t, r.Form.Get("VolumeType"))
+					modified = r.Form.Get("Size")
+					fmt.Fprint(w, "<ModifyVolumeResponse><volumeModification>"+
+						"<volumeId>vol-1</volumeId><modificationState>modifying</modificationState>"+
+						"</volumeModification></ModifyVolumeResponse>")
+				case "DescribeVolumesModifications":
+					fmt.Fprint(w, "<DescribeVolumesModificationsResponse><volumeModificationSet><item>"+
+						"<volumeId>vol-1</volumeId><modificationState>optimizing</modificationState>"+
+						"<progress>10</progress></item></volumeModificationSet>"+
+						"</DescribeVolumesModificationsResponse>")
+				default:
+					t.Errorf("unexpected %s", r.Form.Get("Action"))
+				}
+			})
+			defer done()
+
+			a := &ec2Ops{instance: "i-1", ec2: client}
+			size, err := a.Expand("vol-1", tc.size)
+			if tc.code != 0 {
+				assert.True(t, storageops.IsErrorCode(err, tc.code), "%v", err)
+				assert.Empty(t, modified)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.size, size)
+			assert.Equal(t, fmt.Sprint(tc.size), modified)
+		})
+	}
+}
+
+func TestAwsModifyVolumeInput(t *testing.T) {
+	id := "vol-1"
+	gp2 := &ec2.Volume{VolumeId: &id, VolumeType: aws.String(ec2.VolumeTypeGp2),
+		Size: aws.Int64(100), Iops: aws.Int64(300)}
+
+	req, err := modifyVolumeInput(gp2, storageops.VolumeSpecUpdate{Type: ec2.VolumeTypeGp3})
+	assert.NoError(t, err)
+	assert.Equal(t, ec2.VolumeTypeGp3, aws.StringValue(req.VolumeType))
+	assert.Nil(t, req.Iops, "baseline IOPS of gp2 should not be carried over")
+
+	req, err = modifyVolumeInput(gp2, storageops.VolumeSpecUpdate{Type: ec2.VolumeTypeGp2})
+	assert.NoError(t, err)
+	assert.Nil(t, req, "unchanged volume should not be modified")
+
+	_, err = modifyVolumeInput(gp2, storageops.VolumeSpecUpdate{Type: ec2.VolumeTypeIo1})
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval), "io1 requires IOPS")
+
+	io1 := &ec2.Volume{VolumeId: &id, VolumeType: aws.String(ec2.VolumeTypeIo1),
+		Size: aws.Int64(100), Iops: aws.Int64(4000)}
+	req, err = modifyVolumeInput(io1, storageops.VolumeSpecUpdate{Type: ec2.VolumeTypeIo2})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4000), aws.Int64Value(req.Iops))
+
+	gp3 := &ec2.Volume{VolumeId: &id, VolumeType: aws.String(ec2.VolumeTypeGp3),
+		Size: aws.Int64(100), Iops: aws.Int64(3000), Throughput: aws.Int64(125)}
+	req, err = modifyVolumeInput(gp3, storageops.VolumeSpecUpdate{ThroughputMiBps: 500})
+	assert.NoError(t, err)
+	assert.Nil(t, req.VolumeType)
+	assert.Nil(t, req.Iops)
+	assert.Equal(t, int64(500), aws.Int64Value(req.Throughput))
+
+	_, err = modifyVolumeInput(gp3, storageops.VolumeSpecUpdate{ThroughputMiBps: 1000})
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval), "throughput exceeds IOPS ratio")
+
+	standard := &ec2.Volume{VolumeId: &id, VolumeType: aws.String(ec2.VolumeTypeStandard),
+		Size: aws.Int64(100)}
+	_, err = modifyVolumeInput(standard, storageops.VolumeSpecUpdate{Type: ec2.VolumeTypeGp3})
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrModifyUnsupported))
+}
+
+func TestAwsZones(t *testing.T) {
+	client, done := newFakeEC2(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "DescribeAvailabilityZones", r.Form.Get("Action"))
+		assert.Equal(t, "available", r.Form.Get("Filter.1.Value.1"))
+		fmt.Fprint(w, `<DescribeAvailabilityZonesResponse><availabilityZoneInfo>`+
+			`<item><zoneName>us-east-1b</zoneName><regionName>us-east-1</regionName></item>`+
+			`<item><zoneName>us-east-1a</zoneName><regionName>us-east-1</regionName></item>`+
+			`</availabilityZoneInfo></DescribeAvailabilityZonesResponse>`)
+	})
+	defer done()
+
+	a := &ec2Ops{instance: "i-1", ec2: client}
+	region, err := a.GetRegion()
+	assert.NoError(t, err)
+	assert.Equal(t, "us-east-1", region)
+	zones, err := a.ListZones()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"us-east-1a", "us-east-1b"}, zones)
+}
+
+func TestAwsOptimizeVolumeType(t *testing.T) {
+	template := &ec2.Volume{Size: aws.Int64(100), Iops: aws.Int64(5000), Throughput: aws.Int64(200)}
+	vol, labels, err := optimizeVolumeType(template, "us-east-1", usPrices)
+	assert.NoError(t, err)
+	assert.Equal(t, ec2.VolumeTypeGp3, aws.StringValue(vol.VolumeType))
+	assert.Equal(t, int64(200), aws.Int64Value(vol.Throughput))
+	assert.Equal(t, ec2.VolumeTypeGp3, labels[VolumeTypeChoiceLabel])
+	assert.Equal(t, "gp3=21.00 io2=337.50 USD/month in us-east-1 for 5000 IOPS 200 MiB/s",
+		labels[VolumeTypeRationaleLabel])
+	assert.Nil(t, template.VolumeType, "template should not be modified")
+
+	vol, labels, err = optimizeVolumeType(&ec2.Volume{Size: aws.Int64(100), Iops: aws.Int64(20000)},
+		"us-east-1", usPrices)
+	assert.NoError(t, err)
+	assert.Equal(t, ec2.VolumeTypeIo2, aws.StringValue(vol.VolumeType))
+	assert.Nil(t, vol.Throughput)
+	assert.Contains(t, labels[VolumeTypeRationaleLabel], "gp3=unsuitable")
+
+	_, _, err = optimizeVolumeType(&ec2.Volume{Size: aws.Int64(10), Iops: aws.Int64(20000)},
+		"us-east-1", usPrices)
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval))
+
+	a := &ec2Ops{cfg: Config{VolumePrices: map[string]*VolumePrices{"ap-south-1": {Gp3GiB: 1}}}}
+	assert.Equal(t, 1.0, a.volumePrices("ap-south-1").Gp3GiB)
+	assert.Equal(t, usPrices, a.volumePrices("sa-east-1"), "unknown regions fall back to us-east-1")
+}
+
+func TestAwsEncryptionPolicy(t *testing.T) {
+	a := &ec2Ops{}
+	req := &ec2.CreateVolumeInput{}
+	assert.NoError(t, a.applyEncryptionPolicy(req))
+	assert.Nil(t, req.Encrypted, "no policy should leave the account default")
+
+	key := "arn:aws:kms:us-east-1:123456789012:key/cluster"
+	a.cfg = Config{DefaultKMSKeyID: key}
+	req = &ec2.CreateVolumeInput{}
+	assert.NoError(t, a.applyEncryptionPolicy(req))
+	assert.True(t, aws.BoolValue(req.Encrypted))
+	assert.Equal(t, key, aws.StringValue(req.KmsKeyId))
+
+	req = &ec2.CreateVolumeInput{Encrypted: aws.Bool(true), KmsKeyId: aws.String("other")}
+	assert.NoError(t, a.applyEncryptionPolicy(req))
+	assert.Equal(t, "other", aws.StringValue(req.KmsKeyId), "explicit key should be kept")
+
+	req = &ec2.CreateVolumeInput{Encrypted: aws.Bool(false)}
+	assert.NoError(t, a.applyEncryptionPolicy(req))
+	assert.False(t, aws.BoolValue(req.Encrypted))
+	assert.Nil(t, req.KmsKeyId)
+
+	a.cfg.AlwaysEncrypt = true
+	err := a.applyEncryptionPolicy(&ec2.CreateVolumeInput{Encrypted: aws.Bool(false)})
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval))
+}
+
+func TestAwsCredentialChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws-credentials")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	shared := filepath.Join(dir, "credentials")
+	assert.NoError(t, ioutil.WriteFile(shared,
+		[]byte("[default]\naws_access_key_id = id\naws_secret_access_key = secret\n"), 0600))
+
+	env := map[string]string{
+		"AWS_REGION":                  "us-east-1",
+		"AWS_INSTANCE_NAME":           "i-1",
+		"AWS_INSTANCE_TYPE":           "m5.large",
+		"AWS_SHARED_CREDENTIALS_FILE": shared,
+		"AWS_CONFIG_FILE":             filepath.Join(dir, "config"),
+		"AWS_ACCESS_KEY_ID":           "",
+		"AWS_SECRET_ACCESS_KEY":       "",
+	}
+	for k, v := range env {
+		if old, ok := os.LookupEnv(k); ok {
+			defer os.Setenv(k, old)
+		} else {
+			defer os.Unsetenv(k)
+		}
+		os.Setenv(k, v)
+	}
+
+	d, err := NewEnvClient()
+	assert.NoError(t, err, "shared credentials file should be used without env credentials")
+	assert.Equal(t, "i-1", d.InstanceID())
+
+	_, err = NewClientWithCredentials("i-1", "m5.large", "us-east-1",
+		&credentials.StaticProvider{}, Config{})
+	assert.Error(t, err, "empty static credentials should fail")
+	d, err = NewClientWithCredentials("i-1", "m5.large", "us-east-1",
+		&credentials.StaticProvider{Value: credentials.Value{
+			AccessKeyID: "id", SecretAccessKey: "secret"}}, Config{})
+	assert.NoError(t, err)
+	assert.Equal(t, "us-east-1", aws.StringValue(d.(*ec2Ops).ec2.Config.Region))
+}
+
+func TestAwsAssumeRole(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "AssumeRole", r.Form.Get("Action"))
+		assert.Equal(t, "arn:aws:iam::123456789012:role/storage", r.Form.Get("RoleArn"))
+		assert.Equal(t, "customer-1", r.Form.Get("ExternalId"))
+		assert.Equal(t, "control-plane", r.Form.Get("RoleSessionName"))
+		// Expires within the expiry window, so every Get assumes the role again
+		fmt.Fprintf(w, `<AssumeRoleResponse><AssumeRoleResult><Credentials>`+
+			`<AccessKeyId>key-%d</AccessKeyId><SecretAccessKey>secret</SecretAccessKey>`+
+			`<SessionToken>token</SessionToken><Expiration>%s</Expiration>`+
+			`</Credentials></AssumeRoleResult></AssumeRoleResponse>`,
+			calls, time.Now().Add(time.Minute).UTC().Format(time.RFC3339))
+	}))
+	defer server.Close()
+
+	_, err := NewClientWithAssumedRole("i-1", "m5.large", "us-east-1", AssumeRoleConfig{}, Config{})
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval))
+
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:    aws.String(server.URL),
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	assert.NoError(t, err)
+	creds := credentials.NewCredentials(assumeRoleProvider(sess, AssumeRoleConfig{
+		RoleARN:     "arn:aws:iam::123456789012:role/storage",
+		ExternalID:  "customer-1",
+		SessionName: "control-plane",
+	}))
+	v, err := creds.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "key-1", v.AccessKeyID)
+	v, err = creds.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "key-2", v.AccessKeyID, "credentials should be refreshed before expiry")
+}
+
+func TestAwsEnumerateEach(t *testing.T) {
+	calls := 0
+	client, done := newFakeEC2(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "500", r.Form.Get("MaxResults"))
+		fmt.Fprint(w, `<DescribeVolumesResponse><volumeSet>`+
+			`<item><volumeId>vol-1</volumeId><status>available</status></item>`+
+			`<item><volumeId>vol-2</volumeId><status>available</status></item>`+
+			`</volumeSet><nextToken>more</nextToken></DescribeVolumesResponse>`)
+	})
+	defer done()
+
+	var seen []string
+	a := &ec2Ops{instance: "i-1", ec2: client}
+	err := storageops.EnumerateEach(a, nil, nil, "", func(set string, vol *storageops.Volume) bool {
+		seen = append(seen, vol.ID)
+		return len(seen) < 2
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vol-1", "vol-2"}, seen)
+	assert.Equal(t, 1, calls, "no further pages should be fetched after stopping")
+}
+
+func TestAwsAdviseInstanceType(t *testing.T) {
+	gp3 := func(throughput int64) *ec2.Volume {
+		return &ec2.Volume{VolumeType: aws.String(ec2.VolumeTypeGp3), Size: aws.Int64(500),
+			Iops: aws.Int64(3000), Throughput: aws.Int64(throughput)}
+	}
+	vols := []*ec2.Volume{gp3(500), gp3(500)}
+
+	advice, err := adviseInstanceType("m5.xlarge", vols, ObservedIO{ThroughputMiBps: 50},
+		defaultInstanceTypeLimits)
+	assert.NoError(t, err)
+	assert.False(t, advice.Bottleneck, "idle volumes are not capped")
+	assert.Equal(t, int64(1000), advice.VolumeThroughputMiBps)
+	assert.Equal(t, int64(6000), advice.VolumeIops)
+
+	advice, err = adviseInstanceType("m5.xlarge", vols, ObservedIO{ThroughputMiBps: 135},
+		defaultInstanceTypeLimits)
+	assert.NoError(t, err)
+	assert.True(t, advice.Bottleneck)
+	assert.Equal(t, int64(137), advice.Limits.BandwidthMiBps)
+	assert.Len(t, advice.Reasons, 1)
+	assert.Equal(t, []string{"m5.12xlarge", "m5.16xlarge", "m5.24xlarge"}, advice.Candidates,
+		"same family candidates covering 1000 MiB/s should come first")
+
+	full := make([]*ec2.Volume, nitroAttachments)
+	for i := range full {
+		full[i] = &ec2.Volume{VolumeType: aws.String(ec2.VolumeTypeGp2), Size: aws.Int64(10)}
+	}
+	advice, err = adviseInstanceType("c5.large", full, ObservedIO{},
+		map[string]InstanceTypeLimits{
+			"c5.large": mbps(650, 4000),
+			"x1.large": {BandwidthMiBps: 4000, Iops: 10000, MaxAttachments: 40},
+		})
+	assert.NoError(t, err)
+	assert.True(t, advice.Bottleneck)
+	assert.Contains(t, advice.Reasons[0], "attachments")
+	assert.Equal(t, []string{"x1.large"}, advice.Candidates)
+
+	_, err = adviseInstanceType("t2.nano", vols, ObservedIO{}, defaultInstanceTypeLimits)
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval))
+}
+
+func TestAwsVolumeStatus(t *testing.T) {
+	statuses := map[string]string{
+		"vol-ok": `<status>ok</status><details><item><name>io-enabled</name><status>passed</status></item>` +
+			`<item><name>io-performance</name><status>not-applicable</status></item></details>`,
+		"vol-slow": `<status>warning</status><details><item><name>io-enabled</name><status>passed</status></item>` +
+			`<item><name>io-performance</name><status>degraded</status></item></details>`,
+		"vol-broken": `<status>impaired</status><details><item><name>io-enabled</name><status>failed</status></item></details>`,
+		"vol-new":    `<status>insufficient-data</status>`,
+		"vol-event":  `<status>ok</status><details><item><name>io-enabled</name><status>passed</status></item></details>`,
+	}
+	client, done := newFakeEC2(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "DescribeVolumeStatus", r.Form.Get("Action"))
+		id := r.Form.Get("VolumeId.1")
+		if id == "vol-missing" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `<Response><Errors><Error><Code>InvalidVolume.NotFound</Code>`+
+				`<Message>not found</Message></Error></Errors></Response>`)
+			return
+		}
+		events := ""
+		if id == "vol-event" {
+			events = `<eventsSet><item><eventType>potential-data-inconsistency</eventType>` +
+				`<description>check the data</description></item></eventsSet>`
+		}
+		fmt.Fprintf(w, `<DescribeVolumeStatusResponse><volumeStatusSet><item><volumeId>%s</volumeId>`+
+			`<volumeStatus>%s</volumeStatus>%s</item></volumeStatusSet></DescribeVolumeStatusResponse>`,
+			id, statuses[id], events)
+	})
+	defer done()
+
+	a := &ec2Ops{instance: "i-1", ec2: client}
+	for id, health := range map[string]storageops.VolumeHealth{
+		"vol-ok":     storageops.VolumeHealthOK,
+		"vol-event":  storageops.VolumeHealthImpaired,
+		"vol-slow":   storageops.VolumeHealthImpaired,
+		"vol-broken": storageops.VolumeHealthIOError,
+		"vol-new":    storageops.VolumeHealthUnknown,
+	} {
+		status, err := storageops.InspectVolumeStatus(a, id)
+		assert.NoError(t, err, id)
+		assert.Equal(t, health, status.Health, id)
+	}
+
+	status, err := a.VolumeStatus("vol-event")
+	assert.NoError(t, err)
+	assert.Equal(t, "passed", status.Checks["io-enabled"])
+	assert.Len(t, status.Events, 1)
+	assert.Equal(t, "potential-data-inconsistency", status.Events[0].Type)
+
+	_, err = a.VolumeStatus("vol-missing")
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolNotFound), "%v", err)
+}
+
+func TestAwsCloudWatchPublisher(t *testing.T) {
+	var (
+		metrics   []string
+		dims      []string
+		puts      int
+		entries   []map[string]interface{}
+		failEvent bool
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") == "AWSEvents.PutEvents" {
+			body := struct{ Entries []map[string]interface{} }{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			entries = append(entries, body.Entries...)
+			if failEvent {
+				fmt.Fprint(w, `{"FailedEntryCount":1,"Entries":[{"ErrorCode":"Throttled","ErrorMessage":"slow down"}]}`)
+				return
+			}
+			fmt.Fprint(w, `{"FailedEntryCount":0,"Entries":[{"EventId":"event-1"}]}`)
+			return
+		}
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "PutMetricData", r.Form.Get("Action"))
+		assert.Equal(t, "Openstorage", r.Form.Get("Namespace"))
+		puts++
+		for i := 1; len(r.Form.Get(fmt.Sprintf("MetricData.member.%d.MetricName", i))) > 0; i++ {
+			metrics = append(metrics, r.Form.Get(fmt.Sprintf("MetricData.member.%d.MetricName", i)))
+			for j := 1; ; j++ {
+				name := r.Form.Get(fmt.Sprintf("MetricData.member.%d.Dimensions.member.%d.Name", i, j))
+				if len(name) == 0 {
+					break
+				}
+				dims = append(dims, name)
+			}
+		}
+		fmt.Fprint(w, "<PutMetricDataResponse></PutMetricDataResponse>")
+	}))
+	defer server.Close()
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:    aws.String(server.URL),
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	assert.NoError(t, err)
+
+	p := NewCloudWatchPublisher(sess, CloudWatchPublisherConfig{
+		Namespace:    "Openstorage",
+		EventBusName: "default",
+		Dimensions:   map[string]string{"Cluster": "c-1"},
+	})
+	event := &storageops.Event{Type: storageops.EventAttach, Provider: "aws", InstanceID: "i-1",
+		VolumeID: "vol-1", Time: time.Now(), Duration: time.Second, Err: "busy"}
+	assert.NoError(t, p.PublishEvent(event))
+	assert.Equal(t, []string{"LifecycleEvents", "LifecycleLatency", "LifecycleErrors"}, metrics)
+	assert.Contains(t, dims, "Cluster")
+	assert.Contains(t, dims, "Operation")
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "openstorage.storageops", entries[0]["Source"])
+	assert.Equal(t, "Drive attach", entries[0]["DetailType"])
+	assert.Equal(t, []interface{}{"vol-1"}, entries[0]["Resources"])
+	detail := &awsEventDetail{}
+	assert.NoError(t, json.Unmarshal([]byte(entries[0]["Detail"].(string)), detail))
+	assert.Equal(t, "vol-1", detail.VolumeID)
+	assert.Equal(t, int64(1000), detail.DurationMs)
+	assert.Equal(t, "busy", detail.Error)
+
+	failEvent = true
+	assert.Error(t, p.PublishEvent(event), "failed entries must be reported")
+
+	// 7 types in 2 states are 28 datums, more than fit in one put
+	metrics, puts = nil, 0
+	var vols []*storageops.Volume
+	for volType := range volumeLimits {
+		for _, state := range []string{ec2.VolumeStateAvailable, ec2.VolumeStateInUse} {
+			vols = append(vols, &storageops.Volume{Type: volType, State: state, SizeGiB: 10})
+		}
+	}
+	assert.NoError(t, p.PublishInventory(map[string][]*storageops.Volume{"": vols}))
+	assert.Equal(t, 2, puts)
+	assert.Len(t, metrics, 28)
+
+	metrics = nil
+	events := NewCloudWatchPublisher(sess, CloudWatchPublisherConfig{EventBusName: "default"})
+	assert.NoError(t, events.PublishInventory(map[string][]*storageops.Volume{"": vols}))
+	assert.Empty(t, metrics, "metrics must not be published without a namespace")
+}
+
+func TestAwsSpec(t *testing.T) {
+	client, done := newFakeEC2(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		switch r.Form.Get("Action") {
+		case "DescribeVolumes":
+			fmt.Fprint(w, `<DescribeVolumesResponse><volumeSet><item><volumeId>vol-1</volumeId>`+
+				`<status>in-use</status><volumeType>gp3</volumeType><iops>3000</iops>`+
+				`<throughput>125</throughput><encrypted>true</encrypted>`+
+				`<tagSet><item><key>owner</key><value>ops</value></item></tagSet>`+
+				`</item></volumeSet></DescribeVolumesResponse>`)
+		default:
+			t.Errorf("unexpected action %s", r.Form.Get("Action"))
+		}
+	})
+	defer done()
+
+	a := &ec2Ops{instance: "i-1", ec2: client}
+	spec, err := a.Spec("vol-1")
+	assert.NoError(t, err)
+	assert.Equal(t, &storageops.DesiredSpec{
+		Type:            ec2.VolumeTypeGp3,
+		Iops:            3000,
+		ThroughputMiBps: 125,
+		Encrypted:       true,
+		Tags:            map[string]string{"owner": "ops"},
+	}, spec)
+}
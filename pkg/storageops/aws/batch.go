@@ -0,0 +1,184 @@
+package aws
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+var _ storageops.BatchAttacher = &ec2Ops{}
+
+// AttachBatch attaches the requested volumes to this instance. The instance
+// is described and its free devices are computed once for the whole batch,
+// then the attaches are issued and awaited at most parallelism at a time.
+func (s *ec2Ops) AttachBatch(
+	reqs []*storageops.AttachRequest,
+	parallelism int,
+) []*storageops.AttachResult {
+	results := make([]*storageops.AttachResult, len(reqs))
+	for i, r := range reqs {
+		results[i] = &storageops.AttachResult{VolumeID: r.VolumeID}
+	}
+	fail := func(err error) []*storageops.AttachResult {
+		for _, r := range results {
+			r.Err = err
+		}
+		return results
+	}
+
+	queueTimeout := s.cfg.AttachQueueTimeout
+	if queueTimeout == 0 {
+		queueTimeout = storageops.ProviderOpsTimeout
+	}
+	// The batch is queued by the earliest deadline of its attaches
+	var deadline time.Time
+	for _, r := range reqs {
+		d, err := storageops.AttachDeadline(r.Options, queueTimeout)
+		if err != nil {
+			return fail(err)
+		}
+		if deadline.IsZero() || d.Before(deadline) {
+			deadline = d
+		}
+	}
+	if deadline.IsZero() {
+		deadline = time.Now().Add(queueTimeout)
+	}
+	queue := storageops.InstanceQueue(s.instance)
+	release, err := queue.Acquire(deadline)
+	if err != nil {
+		return fail(fmt.Errorf("failed to attach volumes: %v", err))
+	}
+	defer release()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	self, err := s.describe()
+	if err != nil {
+		return fail(err)
+	}
+	var blockDeviceMappings = make([]interface{}, len(self.BlockDeviceMappings))
+	for i, b := range self.BlockDeviceMappings {
+		blockDeviceMappings[i] = b
+	}
+	free, err := s.FreeDevices(blockDeviceMappings, *self.RootDeviceName)
+	if err != nil {
+		return fail(err)
+	}
+	requested := make([]string, len(reqs))
+	for i, r := range reqs {
+		requested[i] = r.Options[storageops.AttachOptionDevice]
+	}
+	if free, err = s.awaitQuarantine(free, requested); err != nil {
+		return fail(err)
+	}
+
+	devices, errs := assignBatchDevices(free, reqs)
+	for i, err := range errs {
+		results[i].Err = err
+	}
+	spares := newSpareDevices(free, devices...)
+
+	storageops.ForEachParallel(len(reqs), parallelism, func(i int) {
+		if results[i].Err != nil {
+			return
+		}
+		results[i].DevicePath, results[i].Err = s.attachAt(reqs[i], devices[i], spares, queue.Progress)
+	})
+	return results
+}
+
+// assignBatchDevices assigns a distinct free device to each request of a
+// batch. Requested devices are assigned first so that requests without one
+// do not take them.
+func assignBatchDevices(
+	free []string,
+	reqs []*storageops.AttachRequest,
+) ([]string, []error) {
+	devices := make([]string, len(reqs))
+	errs := make([]error, len(reqs))
+	taken := make(map[string]bool, len(reqs))
+	available := func() []string {
+		var devs []string
+		for _, d := range free {
+			if !taken[d] {
+				devs = append(devs, d)
+			}
+		}
+		return devs
+	}
+
+	for i, r := range reqs {
+		requested := r.Options[storageops.AttachOptionDevice]
+		if len(requested) == 0 {
+			continue
+		}
+		if devices[i], errs[i] = attachDevice(available(), requested); errs[i] == nil {
+			taken[devices[i]] = true
+		}
+	}
+	for i, r := range reqs {
+		if len(r.Options[storageops.AttachOptionDevice]) > 0 {
+			continue
+		}
+		devs := available()
+		if len(devs) == 0 {
+			errs[i] = storageops.NewStorageError(storageops.ErrInvalidDevicePath,
+				fmt.Sprintf("no free device left to attach volume %s", r.VolumeID), "")
+			continue
+		}
+		devices[i] = devs[0]
+		taken[devices[i]] = true
+	}
+	return devices, errs
+}
+
+// attachAt attaches a volume at the given device, or a spare device if it is
+// taken by another attacher, and waits for it. The caller holds the attach
+// lock of the instance.
+func (s *ec2Ops) attachAt(
+	r *storageops.AttachRequest,
+	device string,
+	spares *spareDevices,
+	progress func(),
+) (string, error) {
+	if r.Options[storageops.AttachOptionMultiAttach] == "true" {
+		// A dry run validates the attach too once Multi-Attach would have
+		// been enabled
+		err := s.enableMultiAttach(r.VolumeID)
+		if err != nil && !(s.cfg.DryRun && storageops.IsDryRunSuccess(err)) {
+			return "", err
+		}
+	}
+	return s.attachReserved(r, device, spares, progress)
+}
+
+// attachVolume issues the attach of a volume to this instance at the given
+// device and returns the EC2 error
+func (s *ec2Ops) attachVolume(volumeID, device string) error {
+	_, err := s.ec2.AttachVolume(&ec2.AttachVolumeInput{
+		DryRun:     aws.Bool(s.cfg.DryRun),
+		Device:     &device,
+		InstanceId: &s.instance,
+		VolumeId:   &volumeID,
+	})
+	s.invalidateDescribe()
+	return err
+}
+
+// DetachBatch detaches the given volumes from this instance, at most
+// parallelism at a time
+func (s *ec2Ops) DetachBatch(volumeIDs []string, parallelism int) []*storageops.DetachResult {
+	results := make([]*storageops.DetachResult, len(volumeIDs))
+	storageops.ForEachParallel(len(volumeIDs), parallelism, func(i int) {
+		results[i] = &storageops.DetachResult{
+			VolumeID: volumeIDs[i],
+			Err:      s.detachInternal(volumeIDs[i], s.instance),
+		}
+	})
+	return results
+}
@@ -0,0 +1,49 @@
+package aws
+
+import (
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sirupsen/logrus"
+)
+
+// BootstrapInfo is the state of this instance needed at node startup
+type BootstrapInfo struct {
+	// Instance is the description of this instance
+	Instance *ec2.Instance
+	// DeviceMappings maps local device paths to the attached volume IDs
+	DeviceMappings map[string]string
+	// FreeDevices are the device names available for new attachments
+	FreeDevices []string
+}
+
+// Bootstrapper is implemented by the AWS storage ops driver
+type Bootstrapper interface {
+	// Bootstrap returns the startup state of this instance from a single
+	// DescribeInstances call
+	Bootstrap() (*BootstrapInfo, error)
+}
+
+func (s *ec2Ops) Bootstrap() (*BootstrapInfo, error) {
+	instance, err := s.describe()
+	if err != nil {
+		return nil, err
+	}
+	mappings, err := s.deviceMappings(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	blockDeviceMappings := make([]interface{}, len(instance.BlockDeviceMappings))
+	for i, b := range instance.BlockDeviceMappings {
+		blockDeviceMappings[i] = b
+	}
+	free, err := s.FreeDevices(blockDeviceMappings, *instance.RootDeviceName)
+	if err != nil {
+		// an instance without free slots can still start
+		logrus.Warnf("No free devices on instance %v: %v", s.instance, err)
+	}
+	return &BootstrapInfo{
+		Instance:       instance,
+		DeviceMappings: mappings,
+		FreeDevices:    free,
+	}, nil
+}
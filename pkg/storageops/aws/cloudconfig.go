@@ -0,0 +1,106 @@
+package aws
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/libopenstorage/openstorage/pkg/storageops/config"
+)
+
+// paramSettings are the cloud config params of the optional driver config
+var paramSettings = configSettings{
+	profile:              "profile",
+	reservedAttachSlots:  "reservedAttachSlots",
+	busyDevicePolicy:     "busyDevicePolicy",
+	optimizeVolumeType:   "optimizeVolumeType",
+	dryRun:               "dryRun",
+	deviceReservationDir: "deviceReservationDir",
+	kmsKeyID:             "kmsKeyID",
+	alwaysEncrypt:        "alwaysEncrypt",
+	describeCacheTTL:     "describeCacheTTL",
+}
+
+func init() {
+	config.Register("aws", newFromCloudConfig)
+}
+
+// newFromCloudConfig creates the driver of the given cloud config. The
+// instance, its type and the region are discovered from the instance
+// metadata service unless all of them are set, the type with the
+// instanceType param. The accessKeyID, secretAccessKey and sessionToken
+// credentials replace the default chain of newSession, the roleARN and
+// externalID params assume a role.
+func newFromCloudConfig(c *config.Config) (storageops.Ops, error) {
+	cfg, err := parseConfig(paramSettings, func(name string) (string, bool) {
+		v, ok := c.Params[name]
+		return v, ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	if cfg.RetryPolicy, err = c.RetryPolicy(); err != nil {
+		return nil, err
+	}
+	if cfg.HTTP, err = c.HTTPConfig(); err != nil {
+		return nil, err
+	}
+
+	instance, instanceType, region := c.Instance, c.Params["instanceType"], c.Region
+	if len(instance) == 0 || len(instanceType) == 0 || len(region) == 0 {
+		sess, err := newSession(cfg, nil)
+		if err != nil {
+			return nil, err
+		}
+		md, err := GetInstanceMetadata(ec2metadata.New(sess))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range []struct {
+			field *string
+			value string
+		}{
+			{&instance, md.InstanceID},
+			{&instanceType, md.InstanceType},
+			{&region, md.Region},
+		} {
+			if len(*f.field) == 0 {
+				*f.field = f.value
+			}
+		}
+	}
+
+	keyID, ok, err := c.Credential("accessKeyID")
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		secret, err := c.RequiredCredential("secretAccessKey", "")
+		if err != nil {
+			return nil, err
+		}
+		token, _, err := c.Credential("sessionToken")
+		if err != nil {
+			return nil, err
+		}
+		return NewClientWithCredentials(instance, instanceType, region,
+			&credentials.StaticProvider{Value: credentials.Value{
+				AccessKeyID:     keyID,
+				SecretAccessKey: secret,
+				SessionToken:    token,
+			}}, cfg)
+	}
+	if role := c.Params["roleARN"]; len(role) > 0 {
+		return NewClientWithAssumedRole(instance, instanceType, region, AssumeRoleConfig{
+			RoleARN:    role,
+			ExternalID: c.Params["externalID"],
+		}, cfg)
+	}
+
+	sess, err := newSession(cfg, &aws.Config{Region: &region})
+	if err != nil {
+		return nil, err
+	}
+	return NewEc2StorageWithConfig(instance, instanceType, ec2.New(sess), cfg), nil
+}
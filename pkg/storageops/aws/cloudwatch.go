@@ -0,0 +1,204 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+const (
+	// eventSource is the EventBridge source for drive lifecycle events
+	eventSource = "openstorage.storageops"
+	// cloudWatchMaxDatums is the max number of metric datums per PutMetricData call
+	cloudWatchMaxDatums = 20
+)
+
+// CloudWatchPublisherConfig configures publishing to CloudWatch and EventBridge
+type CloudWatchPublisherConfig struct {
+	// Namespace is the CloudWatch custom metrics namespace. Metrics are not
+	// published if empty.
+	Namespace string
+	// EventBusName is the EventBridge bus to put events on. Events are not
+	// published if empty. Use "default" for the account's default bus.
+	EventBusName string
+	// Dimensions are extra dimensions, such as a cluster ID, added to every metric
+	Dimensions map[string]string
+}
+
+type cloudWatchPublisher struct {
+	cfg         CloudWatchPublisherConfig
+	cloudwatch  *cloudwatch.CloudWatch
+	eventbridge *eventbridge.EventBridge
+}
+
+// awsEventDetail is the detail payload of an EventBridge drive event
+type awsEventDetail struct {
+	Type       string            `json:"type"`
+	Provider   string            `json:"provider"`
+	InstanceID string            `json:"instanceId,omitempty"`
+	VolumeID   string            `json:"volumeId,omitempty"`
+	DurationMs int64             `json:"durationMs"`
+	Error      string            `json:"error,omitempty"`
+	Dimensions map[string]string `json:"dimensions,omitempty"`
+}
+
+// NewCloudWatchPublisher creates a storageops.EventPublisher that publishes
+// drive lifecycle events to EventBridge and lifecycle and inventory metrics to
+// CloudWatch custom metrics
+func NewCloudWatchPublisher(
+	p client.ConfigProvider,
+	cfg CloudWatchPublisherConfig,
+) storageops.EventPublisher {
+	return &cloudWatchPublisher{
+		cfg:         cfg,
+		cloudwatch:  cloudwatch.New(p),
+		eventbridge: eventbridge.New(p),
+	}
+}
+
+func (c *cloudWatchPublisher) PublishEvent(event *storageops.Event) error {
+	if len(c.cfg.Namespace) > 0 {
+		dims := map[string]string{
+			"Provider":  event.Provider,
+			"Operation": string(event.Type),
+		}
+		datums := []*cloudwatch.MetricDatum{
+			c.datum("LifecycleEvents", dims, 1, cloudwatch.StandardUnitCount, event.Time),
+			c.datum("LifecycleLatency", dims, float64(event.Duration/time.Millisecond),
+				cloudwatch.StandardUnitMilliseconds, event.Time),
+		}
+		if len(event.Err) > 0 {
+			datums = append(datums,
+				c.datum("LifecycleErrors", dims, 1, cloudwatch.StandardUnitCount, event.Time))
+		}
+		if err := c.putMetrics(datums); err != nil {
+			return err
+		}
+	}
+
+	if len(c.cfg.EventBusName) == 0 {
+		return nil
+	}
+
+	detail, err := json.Marshal(&awsEventDetail{
+		Type:       string(event.Type),
+		Provider:   event.Provider,
+		InstanceID: event.InstanceID,
+		VolumeID:   event.VolumeID,
+		DurationMs: int64(event.Duration / time.Millisecond),
+		Error:      event.Err,
+		Dimensions: c.cfg.Dimensions,
+	})
+	if err != nil {
+		return err
+	}
+
+	entry := &eventbridge.PutEventsRequestEntry{
+		EventBusName: aws.String(c.cfg.EventBusName),
+		Source:       aws.String(eventSource),
+		DetailType:   aws.String(fmt.Sprintf("Drive %s", event.Type)),
+		Detail:       aws.String(string(detail)),
+		Time:         aws.Time(event.Time),
+	}
+	if len(event.VolumeID) > 0 {
+		entry.Resources = []*string{aws.String(event.VolumeID)}
+	}
+
+	out, err := c.eventbridge.PutEvents(&eventbridge.PutEventsInput{
+		Entries: []*eventbridge.PutEventsRequestEntry{entry},
+	})
+	if err != nil {
+		return err
+	}
+	if aws.Int64Value(out.FailedEntryCount) > 0 && len(out.Entries) > 0 {
+		return fmt.Errorf("failed to put %v event for %v: %v",
+			event.Type, event.VolumeID, aws.StringValue(out.Entries[0].ErrorMessage))
+	}
+	return nil
+}
+
+func (c *cloudWatchPublisher) PublishInventory(sets map[string][]*storageops.Volume) error {
+	if len(c.cfg.Namespace) == 0 {
+		return nil
+	}
+
+	type key struct {
+		volType string
+		state   string
+	}
+	counts := make(map[key]int64)
+	sizes := make(map[key]int64)
+	for _, vols := range sets {
+		for _, vol := range vols {
+			k := key{
+				volType: vol.Type,
+				state:   vol.State,
+			}
+			counts[k]++
+			sizes[k] += int64(vol.SizeGiB)
+		}
+	}
+
+	now := time.Now()
+	var datums []*cloudwatch.MetricDatum
+	for k, count := range counts {
+		dims := map[string]string{
+			"VolumeType": k.volType,
+			"State":      k.state,
+		}
+		datums = append(datums,
+			c.datum("VolumeCount", dims, float64(count), cloudwatch.StandardUnitCount, now),
+			c.datum("VolumeSizeGiB", dims, float64(sizes[k]), cloudwatch.StandardUnitGigabytes, now))
+	}
+	return c.putMetrics(datums)
+}
+
+func (c *cloudWatchPublisher) datum(
+	name string,
+	dims map[string]string,
+	value float64,
+	unit string,
+	ts time.Time,
+) *cloudwatch.MetricDatum {
+	d := &cloudwatch.MetricDatum{
+		MetricName: aws.String(name),
+		Value:      aws.Float64(value),
+		Unit:       aws.String(unit),
+		Timestamp:  aws.Time(ts),
+	}
+	for _, m := range []map[string]string{c.cfg.Dimensions, dims} {
+		for k, v := range m {
+			if len(v) == 0 {
+				continue
+			}
+			d.Dimensions = append(d.Dimensions, &cloudwatch.Dimension{
+				Name:  aws.String(k),
+				Value: aws.String(v),
+			})
+		}
+	}
+	return d
+}
+
+func (c *cloudWatchPublisher) putMetrics(datums []*cloudwatch.MetricDatum) error {
+	for len(datums) > 0 {
+		n := len(datums)
+		if n > cloudWatchMaxDatums {
+			n = cloudWatchMaxDatums
+		}
+		if _, err := c.cloudwatch.PutMetricData(&cloudwatch.PutMetricDataInput{
+			Namespace:  aws.String(c.cfg.Namespace),
+			MetricData: datums[:n],
+		}); err != nil {
+			return err
+		}
+		datums = datums[n:]
+	}
+	return nil
+}
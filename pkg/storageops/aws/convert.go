@@ -0,0 +1,127 @@
+package aws
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+func init() {
+	storageops.RegisterConverter("aws", &converter{})
+	// EBS volumes are whole GiB, io2 Block Express up to 64 TiB
+	storageops.RegisterSizeRule("aws", &storageops.SizeRule{
+		Granularity: storageops.GiB,
+		Min:         storageops.GiB,
+		Max:         64 * storageops.TiB,
+	})
+}
+
+type converter struct{}
+
+func (c *converter) ToVolume(raw interface{}) (*storageops.Volume, error) {
+	vol, ok := raw.(*ec2.Volume)
+	if !ok {
+		return nil, fmt.Errorf("invalid aws volume %T", raw)
+	}
+	return toVolume(vol), nil
+}
+
+func toVolume(vol *ec2.Volume) *storageops.Volume {
+	v := &storageops.Volume{
+		ID:              aws.StringValue(vol.VolumeId),
+		SizeGiB:         uint64(aws.Int64Value(vol.Size)),
+		Type:            aws.StringValue(vol.VolumeType),
+		Iops:            aws.Int64Value(vol.Iops),
+		ThroughputMiBps: aws.Int64Value(vol.Throughput),
+		Zone:            aws.StringValue(vol.AvailabilityZone),
+		State:           aws.StringValue(vol.State),
+		Encrypted:       aws.BoolValue(vol.Encrypted),
+		Labels:          make(map[string]string),
+		Raw:             vol,
+	}
+	for _, tag := range vol.Tags {
+		v.Labels[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	v.Name = v.Labels["Name"]
+	for _, a := range vol.Attachments {
+		v.AttachedTo = append(v.AttachedTo, aws.StringValue(a.InstanceId))
+		v.Attachments = append(v.Attachments, storageops.Attachment{
+			InstanceID: aws.StringValue(a.InstanceId),
+			Device:     aws.StringValue(a.Device),
+			State:      aws.StringValue(a.State),
+		})
+	}
+	return v
+}
+
+func toVolumes(vols []*ec2.Volume) []*storageops.Volume {
+	typed := make([]*storageops.Volume, len(vols))
+	for i, vol := range vols {
+		typed[i] = toVolume(vol)
+	}
+	return typed
+}
+
+// FromSpec returns the *ec2.Volume template of the given spec
+func (c *converter) FromSpec(spec *storageops.VolumeSpec) (interface{}, error) {
+	vol := &ec2.Volume{}
+	if spec.Native != nil {
+		native, ok := spec.Native.(*ec2.Volume)
+		if !ok {
+			return nil, storageops.NativeTemplateError("aws", spec.Native)
+		}
+		copied := *native
+		vol = &copied
+	}
+	if spec.SizeGiB > 0 {
+		vol.Size = aws.Int64(int64(spec.SizeGiB))
+	}
+	if len(spec.Type) > 0 {
+		vol.VolumeType = aws.String(spec.Type)
+	}
+	if spec.Iops > 0 {
+		vol.Iops = aws.Int64(spec.Iops)
+	}
+	if spec.ThroughputMiBps > 0 {
+		vol.Throughput = aws.Int64(spec.ThroughputMiBps)
+	}
+	if len(spec.Zone) > 0 {
+		vol.AvailabilityZone = aws.String(spec.Zone)
+	}
+	if spec.Encrypted || len(spec.KMSKeyID) > 0 {
+		vol.Encrypted = aws.Bool(true)
+	}
+	if len(spec.KMSKeyID) > 0 {
+		vol.KmsKeyId = aws.String(spec.KMSKeyID)
+	}
+	if len(spec.SnapshotID) > 0 {
+		vol.SnapshotId = aws.String(spec.SnapshotID)
+	}
+	return vol, nil
+}
+
+func (c *converter) ToSnapshot(raw interface{}) (*storageops.Snapshot, error) {
+	snap, ok := raw.(*ec2.Snapshot)
+	if !ok {
+		return nil, fmt.Errorf("invalid aws snapshot %T", raw)
+	}
+	return toSnapshot(snap), nil
+}
+
+func toSnapshot(snap *ec2.Snapshot) *storageops.Snapshot {
+	s := &storageops.Snapshot{
+		ID:       aws.StringValue(snap.SnapshotId),
+		VolumeID: aws.StringValue(snap.VolumeId),
+		SizeGiB:  uint64(aws.Int64Value(snap.VolumeSize)),
+		State:    aws.StringValue(snap.State),
+		Created:  aws.TimeValue(snap.StartTime),
+		Labels:   make(map[string]string),
+		Raw:      snap,
+	}
+	for _, tag := range snap.Tags {
+		s.Labels[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return s
+}
@@ -0,0 +1,122 @@
+package aws
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+// defaultAssumeRoleExpiryWindow is how long before their expiry assumed role
+// credentials are refreshed, so no call is made with credentials expiring
+// in flight
+const defaultAssumeRoleExpiryWindow = 5 * time.Minute
+
+// AssumeRoleConfig is the IAM role of another account the driver manages
+// volumes in
+type AssumeRoleConfig struct {
+	// RoleARN is the ARN of the role to assume
+	RoleARN string
+	// ExternalID is the external ID required by the trust policy of the
+	// role, if any
+	ExternalID string
+	// SessionName identifies the sessions of the driver in the audit logs of
+	// the account. Defaults to a name generated by the SDK.
+	SessionName string
+	// Duration is the lifetime of the assumed role credentials. Defaults to
+	// stscreds.DefaultDuration.
+	Duration time.Duration
+	// ExpiryWindow is how long before their expiry the credentials are
+	// refreshed. Defaults to defaultAssumeRoleExpiryWindow.
+	ExpiryWindow time.Duration
+}
+
+// newSession returns a session resolving credentials with the default
+// chain: environment, shared credentials and config files honoring
+// AWS_PROFILE, web identity tokens as used by IAM roles for service accounts
+// and then the EC2 instance role. The calls use the HTTP client of the
+// given driver config.
+func newSession(cfg Config, config *aws.Config) (*session.Session, error) {
+	opts := session.Options{SharedConfigState: session.SharedConfigEnable}
+	if config != nil {
+		opts.Config = *config
+	}
+	if cfg.HTTP != nil {
+		client, err := cfg.HTTP.Client()
+		if err != nil {
+			return nil, err
+		}
+		opts.Config.HTTPClient = client
+	}
+	return session.NewSessionWithOptions(opts)
+}
+
+// NewClientWithCredentials creates a new AWS storage ops instance in the
+// given region using the given credentials provider instead of the default
+// chain, e.g. for credentials vended by a secrets store
+func NewClientWithCredentials(
+	instance, instanceType, region string,
+	provider credentials.Provider,
+	cfg Config,
+) (storageops.Ops, error) {
+	creds := credentials.NewCredentials(provider)
+	if _, err := creds.Get(); err != nil {
+		return nil, err
+	}
+	sess, err := newSession(cfg, &aws.Config{
+		Region:      &region,
+		Credentials: creds,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewEc2StorageWithConfig(instance, instanceType, ec2.New(sess), cfg), nil
+}
+
+// NewClientWithAssumedRole creates a new AWS storage ops instance managing the
+// volumes of the account of the given role. The role is assumed with the
+// credentials of the default chain, and assumed again before the credentials
+// expire.
+func NewClientWithAssumedRole(
+	instance, instanceType, region string,
+	role AssumeRoleConfig,
+	cfg Config,
+) (storageops.Ops, error) {
+	if len(role.RoleARN) == 0 {
+		return nil, storageops.NewStorageError(storageops.ErrVolInval,
+			"the ARN of the role to assume is required", "")
+	}
+	sess, err := newSession(cfg, &aws.Config{Region: &region})
+	if err != nil {
+		return nil, err
+	}
+	return NewClientWithCredentials(instance, instanceType, region,
+		assumeRoleProvider(sess, role), cfg)
+}
+
+// assumeRoleProvider returns the provider of the credentials of the given
+// role, assumed with the given session
+func assumeRoleProvider(sess *session.Session, role AssumeRoleConfig) *stscreds.AssumeRoleProvider {
+	p := &stscreds.AssumeRoleProvider{
+		Client:          sts.New(sess),
+		RoleARN:         role.RoleARN,
+		RoleSessionName: role.SessionName,
+		Duration:        role.Duration,
+		ExpiryWindow:    role.ExpiryWindow,
+	}
+	if len(role.ExternalID) > 0 {
+		p.ExternalID = aws.String(role.ExternalID)
+	}
+	if p.Duration == 0 {
+		p.Duration = stscreds.DefaultDuration
+	}
+	if p.ExpiryWindow == 0 {
+		p.ExpiryWindow = defaultAssumeRoleExpiryWindow
+	}
+	return p
+}
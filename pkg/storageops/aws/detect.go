@@ -0,0 +1,42 @@
+package aws
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+func init() {
+	storageops.RegisterDetector("aws", &storageops.Detector{
+		Probe: probe,
+		New: func() (storageops.Ops, error) {
+			if _, err := storageops.GetEnvValueStrict("AWS_INSTANCE_NAME"); err == nil {
+				return NewEnvClient()
+			}
+			return NewClientFromMetadata()
+		},
+	})
+}
+
+// probe detects an EC2 instance by its metadata service, or a dev setup by
+// the environment of NewEnvClient
+func probe() error {
+	if _, err := storageops.GetEnvValueStrict("AWS_INSTANCE_NAME"); err == nil {
+		return nil
+	}
+	sess, err := session.NewSession(&aws.Config{
+		HTTPClient: &http.Client{Timeout: storageops.DetectTimeout},
+		MaxRetries: aws.Int(0),
+	})
+	if err != nil {
+		return err
+	}
+	if !ec2metadata.New(sess).Available() {
+		return fmt.Errorf("EC2 instance metadata service not reachable")
+	}
+	return nil
+}
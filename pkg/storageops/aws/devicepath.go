@@ -0,0 +1,97 @@
+package aws
+
+import (
+	"path/filepath"
+	"strings"
+
+	sh "github.com/codeskyblue/go-sh"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/sirupsen/logrus"
+)
+
+// VolumeFromDevicePath returns the volume attached to this instance at the
+// given device path. NVMe devices are mapped using their serial, which is
+// the volume ID without the dash, from sysfs, the udev by-id symlinks or
+// nvme-cli. Other devices and NVMe devices without a serial are mapped
+// using the block device mappings of the instance.
+func (s *ec2Ops) VolumeFromDevicePath(devicePath string) (*storageops.Volume, error) {
+	dev, err := storageops.BaseDevice(devicePath)
+	if err != nil {
+		return nil, storageops.NewStorageError(storageops.ErrInvalidDevicePath,
+			err.Error(), s.instance)
+	}
+
+	volumeID := ""
+	if strings.HasPrefix(dev, awsDevicePrefixNvme) {
+		volumeID = s.volumeIDFromNvmeSerial(dev)
+	}
+	if len(volumeID) == 0 {
+		mappings, err := s.DeviceMappings()
+		if err != nil {
+			return nil, err
+		}
+		if volumeID, err = storageops.VolumeIDFromMappings(mappings, dev); err != nil {
+			return nil, err
+		}
+	}
+
+	vol, err := s.refreshVol(&volumeID)
+	if err != nil {
+		return nil, err
+	}
+	return toVolume(vol), nil
+}
+
+// volumeIDFromNvmeSerial returns the volume ID of the given NVMe device from
+// its serial, or an empty string if it could not be found
+func (s *ec2Ops) volumeIDFromNvmeSerial(dev string) string {
+	serial := ""
+	for _, strategy := range s.nvmeStrategies() {
+		switch strategy {
+		case nvmeStrategySysfs:
+			serials, err := nvmeSysfsSerials()
+			if err != nil {
+				logrus.Warnf("Failed to read nvme serials: %v", err)
+				continue
+			}
+			serial = serials[dev]
+		case nvmeStrategyByID:
+			links, err := filepath.Glob(nvmeByIDPrefix + "vol*")
+			if err != nil {
+				continue
+			}
+			for _, link := range links {
+				if target, err := filepath.EvalSymlinks(link); err == nil && target == dev {
+					serial = strings.TrimPrefix(link, nvmeByIDPrefix)
+					break
+				}
+			}
+		case nvmeStrategyCli:
+			out, err := sh.Command(nvmeCmd, "list").Output()
+			if err != nil {
+				logrus.Warnf("Failed to list nvme devices: %v", err)
+				continue
+			}
+			serial = nvmeSerialFromList(string(out), dev)
+		}
+		if len(serial) > 0 {
+			break
+		}
+	}
+	if !strings.HasPrefix(serial, "vol") || strings.HasPrefix(serial, "vol-") {
+		return ""
+	}
+	return "vol-" + strings.TrimPrefix(serial, "vol")
+}
+
+// nvmeSerialFromList returns the serial of the given device in the output of
+// nvme list
+func nvmeSerialFromList(out, dev string) string {
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && fields[0] == dev {
+			return fields[1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,25 @@
+package aws
+
+import "github.com/libopenstorage/openstorage/pkg/storageops"
+
+var _ storageops.DryRunner = &ec2Ops{}
+
+// DryRun returns a copy of the driver with the DryRun flag set, sharing its
+// EC2 client and configuration
+func (s *ec2Ops) DryRun() storageops.Ops {
+	d := &ec2Ops{
+		instance:     s.instance,
+		instanceType: s.instanceType,
+		ec2:          s.ec2,
+		cfg:          s.cfg,
+		selfTest:     s.selfTest,
+	}
+	d.cfg.DryRun = true
+	s.nvmeLock.Lock()
+	d.nvme = s.nvme
+	s.nvmeLock.Unlock()
+	s.cacheLock.Lock()
+	d.cached, d.cachedAt = s.cached, s.cachedAt
+	s.cacheLock.Unlock()
+	return d
+}
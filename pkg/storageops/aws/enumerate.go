@@ -0,0 +1,86 @@
+package aws
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+// PagedEnumerator is implemented by the AWS storage ops driver
+type PagedEnumerator interface {
+	// EnumeratePages calls fn with every volume matching the filters of
+	// Enumerate, one DescribeVolumes page at a time
+	EnumeratePages(
+		volumeIds []*string,
+		labels map[string]string,
+		setIdentifier string,
+		pageSize int64,
+		fn func(set string, vol *ec2.Volume) bool,
+	) error
+}
+
+var _ storageops.StreamingEnumerator = &ec2Ops{}
+
+// streamPageSize is the number of volumes EnumerateEach requests per
+// DescribeVolumes call, the maximum AWS allows
+const streamPageSize = 500
+
+// EnumerateEach calls fn with every volume matching the given filters one
+// page of streamPageSize volumes at a time
+func (s *ec2Ops) EnumerateEach(
+	volumeIds []*string,
+	labels map[string]string,
+	setIdentifier string,
+	fn func(set string, vol *storageops.Volume) bool,
+) error {
+	return s.EnumeratePages(volumeIds, labels, setIdentifier, streamPageSize,
+		func(set string, vol *ec2.Volume) bool { return fn(set, toVolume(vol)) })
+}
+
+// EnumeratePages calls fn with every volume that matches the given filters,
+// and the set it belongs to, following the NextToken of DescribeVolumes.
+// pageSize is the number of volumes requested per call, between 5 and 500,
+// or 0 for the AWS default. It is ignored if volumeIds are given, as AWS
+// does not page such requests. Enumeration stops when fn returns false.
+func (s *ec2Ops) EnumeratePages(
+	volumeIds []*string,
+	labels map[string]string,
+	setIdentifier string,
+	pageSize int64,
+	fn func(set string, vol *ec2.Volume) bool,
+) error {
+	// Enumerate all volumes that have same labels.
+	f := s.filters(labels, nil)
+	req := &ec2.DescribeVolumesInput{Filters: f, VolumeIds: volumeIds}
+	if pageSize > 0 && len(volumeIds) == 0 {
+		req.MaxResults = aws.Int64(pageSize)
+	}
+
+	err := s.ec2.DescribeVolumesPages(req,
+		func(page *ec2.DescribeVolumesOutput, lastPage bool) bool {
+			for _, vol := range page.Volumes {
+				if s.deleted(vol) {
+					continue
+				}
+				if !fn(s.volumeSet(vol, setIdentifier), vol) {
+					return false
+				}
+			}
+			return true
+		})
+	return s.storageError(err)
+}
+
+// volumeSet returns the set of the given volume. Volume sets are identified
+// by volumes with the same setIdentifer.
+func (s *ec2Ops) volumeSet(vol *ec2.Volume, setIdentifier string) string {
+	if len(setIdentifier) == 0 {
+		return storageops.SetIdentifierNone
+	}
+	for _, tag := range vol.Tags {
+		if s.matchTag(tag, setIdentifier) {
+			return *tag.Value
+		}
+	}
+	return storageops.SetIdentifierNone
+}
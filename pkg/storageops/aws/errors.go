@@ -0,0 +1,54 @@
+package aws
+
+import (
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+// errorCodes maps the EC2 error codes to storage error codes, see
+// https://docs.aws.amazon.com/AWSEC2/latest/APIReference/errors-overview.html
+var errorCodes = map[string]int{
+	"DryRunOperation":                     storageops.ErrDryRun,
+	"InvalidVolume.NotFound":              storageops.ErrVolNotFound,
+	"InvalidSnapshot.NotFound":            storageops.ErrVolNotFound,
+	"InvalidInstanceID.NotFound":          storageops.ErrVolNotFound,
+	"InvalidAttachment.NotFound":          storageops.ErrVolDetached,
+	"IncorrectState":                      storageops.ErrVolInval,
+	"InvalidParameterValue":               storageops.ErrVolInval,
+	"InvalidParameterCombination":         storageops.ErrVolInval,
+	"MissingParameter":                    storageops.ErrVolInval,
+	"InvalidVolume.ZoneMismatch":          storageops.ErrVolInval,
+	"VolumeInUse":                         storageops.ErrVolAlreadyAttached,
+	"InvalidDevice.InUse":                 storageops.ErrVolAlreadyAttached,
+	"AttachmentLimitExceeded":             storageops.ErrQuotaExceeded,
+	"VolumeLimitExceeded":                 storageops.ErrQuotaExceeded,
+	"SnapshotLimitExceeded":               storageops.ErrQuotaExceeded,
+	"MaxIOPSLimitExceeded":                storageops.ErrQuotaExceeded,
+	"ResourceLimitExceeded":               storageops.ErrQuotaExceeded,
+	"UnauthorizedOperation":               storageops.ErrUnauthorized,
+	"AuthFailure":                         storageops.ErrUnauthorized,
+	"Blocked":                             storageops.ErrUnauthorized,
+	"OptInRequired":                       storageops.ErrUnauthorized,
+	"RequestLimitExceeded":                storageops.ErrThrottled,
+	"Throttling":                          storageops.ErrThrottled,
+	"InsufficientVolumeCapacity":          storageops.ErrQuotaExceeded,
+	"VolumeModificationSizeLimitExceeded": storageops.ErrQuotaExceeded,
+}
+
+// storageError maps an error returned by EC2 to a storage error with the
+// matching code. Errors without a matching code are returned unchanged.
+func (s *ec2Ops) storageError(err error) error {
+	awsErr, ok := err.(awserr.Error)
+	if !ok {
+		return err
+	}
+	code, ok := errorCodes[awsErr.Code()]
+	if !ok {
+		if !request.IsErrorThrottle(err) {
+			return err
+		}
+		code = storageops.ErrThrottled
+	}
+	return storageops.WrapError(code, err, s.instance)
+}
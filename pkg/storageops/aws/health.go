@@ -0,0 +1,77 @@
+package aws
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+var _ storageops.VolumeStatusOps = &ec2Ops{}
+
+// VolumeStatus returns the health of the given volume from the EBS status
+// checks and scheduled events of DescribeVolumeStatus
+func (s *ec2Ops) VolumeStatus(volumeID string) (*storageops.VolumeStatus, error) {
+	out, err := s.ec2.DescribeVolumeStatus(&ec2.DescribeVolumeStatusInput{
+		VolumeIds: []*string{&volumeID},
+	})
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	for _, item := range out.VolumeStatuses {
+		if aws.StringValue(item.VolumeId) == volumeID {
+			return volumeStatus(item), nil
+		}
+	}
+	return nil, storageops.NewStorageError(storageops.ErrVolNotFound,
+		fmt.Sprintf("no status of volume %s", volumeID), s.instance)
+}
+
+// volumeStatus normalizes the status of a volume. A failed io-enabled check
+// is an I/O error, degraded performance, an impaired status or any
+// scheduled event impair the volume.
+func volumeStatus(item *ec2.VolumeStatusItem) *storageops.VolumeStatus {
+	status := &storageops.VolumeStatus{
+		VolumeID: aws.StringValue(item.VolumeId),
+		Health:   storageops.VolumeHealthUnknown,
+		Checks:   make(map[string]string),
+	}
+	for _, e := range item.Events {
+		status.Events = append(status.Events, &storageops.VolumeStatusEvent{
+			Type:        aws.StringValue(e.EventType),
+			Description: aws.StringValue(e.Description),
+			NotBefore:   aws.TimeValue(e.NotBefore),
+			NotAfter:    aws.TimeValue(e.NotAfter),
+		})
+	}
+	if item.VolumeStatus == nil {
+		return status
+	}
+	for _, d := range item.VolumeStatus.Details {
+		status.Checks[aws.StringValue(d.Name)] = aws.StringValue(d.Status)
+	}
+
+	switch {
+	case status.Checks[ec2.VolumeStatusNameIoEnabled] == "failed":
+		status.Health = storageops.VolumeHealthIOError
+	case aws.StringValue(item.VolumeStatus.Status) == ec2.VolumeStatusInfoStatusImpaired,
+		aws.StringValue(item.VolumeStatus.Status) == "warning",
+		isDegraded(status.Checks[ec2.VolumeStatusNameIoPerformance]),
+		len(status.Events) > 0:
+		status.Health = storageops.VolumeHealthImpaired
+	case aws.StringValue(item.VolumeStatus.Status) == ec2.VolumeStatusInfoStatusOk:
+		status.Health = storageops.VolumeHealthOK
+	}
+	return status
+}
+
+// isDegraded returns true for the io-performance check results of
+// provisioned IOPS volumes that do not get their provisioned performance
+func isDegraded(performance string) bool {
+	switch performance {
+	case "degraded", "severely-degraded", "stalled":
+		return true
+	}
+	return false
+}
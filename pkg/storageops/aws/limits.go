@@ -0,0 +1,149 @@
+package aws
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+// volumeTypeLimits are the documented EBS size and IOPS limits of a volume type
+type volumeTypeLimits struct {
+	// minSizeGiB is the minimum volume size
+	minSizeGiB int64
+	// maxSizeGiB is the maximum volume size
+	maxSizeGiB int64
+	// minIops is the minimum provisioned IOPS. Zero if IOPS are not configurable
+	minIops int64
+	// maxIops is the maximum provisioned IOPS. Zero if IOPS are not configurable
+	maxIops int64
+	// maxIopsPerGiB is the maximum ratio of provisioned IOPS to volume size
+	maxIopsPerGiB int64
+	// baselineIops are the IOPS of the volume if none are provisioned
+	baselineIops int64
+	// minThroughput is the minimum provisioned throughput in MiB/s. Zero if
+	// throughput is not configurable
+	minThroughput int64
+	// maxThroughput is the maximum provisioned throughput in MiB/s. Zero if
+	// throughput is not configurable
+	maxThroughput int64
+	// maxThroughputPerIops is the maximum ratio of provisioned throughput in
+	// KiB/s to provisioned IOPS
+	maxThroughputPerIops int64
+}
+
+// volumeLimits are the limits for each EBS volume type. io2 limits are those
+// of io2 Block Express, which supports volumes up to 64 TiB.
+var volumeLimits = map[string]volumeTypeLimits{
+	ec2.VolumeTypeStandard: {minSizeGiB: 1, maxSizeGiB: 1024},
+	ec2.VolumeTypeGp2:      {minSizeGiB: 1, maxSizeGiB: 16384},
+	ec2.VolumeTypeGp3: {minSizeGiB: 1, maxSizeGiB: 16384,
+		minIops: 3000, maxIops: 16000, maxIopsPerGiB: 500, baselineIops: 3000,
+		minThroughput: 125, maxThroughput: 1000, maxThroughputPerIops: 256},
+	ec2.VolumeTypeIo1: {minSizeGiB: 4, maxSizeGiB: 16384,
+		minIops: 100, maxIops: 64000, maxIopsPerGiB: 50},
+	ec2.VolumeTypeIo2: {minSizeGiB: 4, maxSizeGiB: 65536,
+		minIops: 100, maxIops: 256000, maxIopsPerGiB: 1000},
+	ec2.VolumeTypeSt1: {minSizeGiB: 125, maxSizeGiB: 16384},
+	ec2.VolumeTypeSc1: {minSizeGiB: 125, maxSizeGiB: 16384},
+}
+
+// validateVolume checks the size and IOPS of the given volume template against
+// the limits of its volume type before it is sent to CreateVolume, so callers get
+// an actionable error instead of an opaque InvalidParameterValue.
+func validateVolume(vol *ec2.Volume) error {
+	volType := aws.StringValue(vol.VolumeType)
+	if len(volType) == 0 {
+		volType = ec2.VolumeTypeGp2
+	}
+
+	limits, ok := volumeLimits[volType]
+	if !ok {
+		return storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("unsupported volume type %q", volType), "")
+	}
+
+	// A volume created from a snapshot may omit the size
+	if vol.Size != nil || len(aws.StringValue(vol.SnapshotId)) == 0 {
+		if err := validateSize(aws.Int64Value(vol.Size), volType, limits); err != nil {
+			return err
+		}
+	}
+
+	if err := validateThroughput(vol, volType, limits); err != nil {
+		return err
+	}
+
+	if vol.Iops == nil {
+		if volType == ec2.VolumeTypeIo1 || volType == ec2.VolumeTypeIo2 {
+			return storageops.NewStorageError(storageops.ErrVolInval,
+				fmt.Sprintf("provisioned IOPS are required for %s volumes", volType), "")
+		}
+		return nil
+	}
+
+	iops := aws.Int64Value(vol.Iops)
+	if limits.maxIops == 0 {
+		return storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("provisioned IOPS are not supported for %s volumes", volType), "")
+	}
+	if iops < limits.minIops || iops > limits.maxIops {
+		return storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("invalid IOPS %d for %s volume: must be between %d and %d",
+				iops, volType, limits.minIops, limits.maxIops), "")
+	}
+	// The baseline IOPS of gp3 are included at any size
+	if vol.Size != nil && iops > limits.baselineIops {
+		size := aws.Int64Value(vol.Size)
+		if iops > size*limits.maxIopsPerGiB {
+			return storageops.NewStorageError(storageops.ErrVolInval,
+				fmt.Sprintf("invalid IOPS %d for %d GiB %s volume: at most %d IOPS per GiB "+
+					"are allowed, increase the size to at least %d GiB",
+					iops, size, volType, limits.maxIopsPerGiB,
+					(iops+limits.maxIopsPerGiB-1)/limits.maxIopsPerGiB), "")
+		}
+	}
+	return nil
+}
+
+// validateSize checks the given size of a volume against the limits of its
+// volume type
+func validateSize(size int64, volType string, limits volumeTypeLimits) error {
+	if size < limits.minSizeGiB || size > limits.maxSizeGiB {
+		return storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("invalid size %d GiB for %s volume: must be between %d and %d GiB",
+				size, volType, limits.minSizeGiB, limits.maxSizeGiB), "")
+	}
+	return nil
+}
+
+// validateThroughput checks the provisioned throughput of the given volume
+// template against the limits of its volume type
+func validateThroughput(vol *ec2.Volume, volType string, limits volumeTypeLimits) error {
+	if vol.Throughput == nil {
+		return nil
+	}
+	throughput := aws.Int64Value(vol.Throughput)
+	if limits.maxThroughput == 0 {
+		return storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("provisioned throughput is not supported for %s volumes", volType), "")
+	}
+	if throughput < limits.minThroughput || throughput > limits.maxThroughput {
+		return storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("invalid throughput %d MiB/s for %s volume: must be between %d and %d MiB/s",
+				throughput, volType, limits.minThroughput, limits.maxThroughput), "")
+	}
+	iops := limits.baselineIops
+	if vol.Iops != nil {
+		iops = aws.Int64Value(vol.Iops)
+	}
+	if throughput*1024 > iops*limits.maxThroughputPerIops {
+		return storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("invalid throughput %d MiB/s for %s volume with %d IOPS: at most "+
+				"%d KiB/s per IOPS are allowed, increase the IOPS to at least %d",
+				throughput, volType, iops, limits.maxThroughputPerIops,
+				(throughput*1024+limits.maxThroughputPerIops-1)/limits.maxThroughputPerIops), "")
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package aws
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+// InstanceMetadata is the identity of the instance as reported by the EC2
+// instance metadata service
+type InstanceMetadata struct {
+	// InstanceID of this instance
+	InstanceID string
+	// InstanceType of this instance, e.g. m5.large
+	InstanceType string
+	// Region this instance runs in
+	Region string
+	// Zone is the availability zone this instance runs in
+	Zone string
+}
+
+// GetInstanceMetadata queries the instance metadata service for the identity
+// of this instance. The metadata client uses an IMDSv2 session token and
+// falls back to IMDSv1 if the token endpoint is not available.
+func GetInstanceMetadata(c *ec2metadata.EC2Metadata) (*InstanceMetadata, error) {
+	if !c.Available() {
+		return nil, fmt.Errorf("EC2 instance metadata service is not available")
+	}
+	doc, err := c.GetInstanceIdentityDocument()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get instance identity document: %v", err)
+	}
+	if len(doc.InstanceID) == 0 || len(doc.InstanceType) == 0 || len(doc.Region) == 0 {
+		return nil, fmt.Errorf("incomplete instance identity document: %+v", doc)
+	}
+	return &InstanceMetadata{
+		InstanceID:   doc.InstanceID,
+		InstanceType: doc.InstanceType,
+		Region:       doc.Region,
+		Zone:         doc.AvailabilityZone,
+	}, nil
+}
+
+// NewClientFromMetadata creates a new AWS storage ops instance for the EC2
+// instance it runs on. The instance ID, type and region are discovered from
+// the instance metadata service and the credentials are resolved using the
+// default chain, see newSession. The optional config of NewEnvClient is
+// honored.
+func NewClientFromMetadata() (storageops.Ops, error) {
+	cfg, err := configFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	sess, err := newSession(cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	md, err := GetInstanceMetadata(ec2metadata.New(sess))
+	if err != nil {
+		return nil, err
+	}
+
+	ec2 := ec2.New(sess, &aws.Config{Region: aws.String(md.Region)})
+	return NewEc2StorageWithConfig(md.InstanceID, md.InstanceType, ec2, cfg), nil
+}
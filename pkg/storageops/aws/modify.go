@@ -0,0 +1,118 @@
+package aws
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+var _ storageops.AsyncModifier = &ec2Ops{}
+
+func (s *ec2Ops) Modify(volumeID string, spec storageops.VolumeSpecUpdate) error {
+	modified, err := s.startModify(volumeID, spec)
+	if err != nil || !modified {
+		return err
+	}
+	return s.waitModification(volumeID, storageops.RetryOpModify, "modification")
+}
+
+// ModifyAsync returns the operation of the modification, which reports the
+// progress EC2 reports for it
+func (s *ec2Ops) ModifyAsync(
+	volumeID string,
+	spec storageops.VolumeSpecUpdate,
+) (storageops.Operation, error) {
+	modified, err := s.startModify(volumeID, spec)
+	if err != nil {
+		return nil, err
+	}
+	return storageops.NewOperation(volumeID, func() (*storageops.OperationStatus, interface{}, error) {
+		if !modified {
+			return &storageops.OperationStatus{State: storageops.OperationSucceeded, Progress: 100}, nil, nil
+		}
+		status, err := s.modificationStatus(volumeID)
+		return status, nil, err
+	}, nil), nil
+}
+
+// startModify starts the modification of the given volume for the given
+// update. It returns false if the volume already matches the update.
+func (s *ec2Ops) startModify(volumeID string, spec storageops.VolumeSpecUpdate) (bool, error) {
+	vol, err := s.refreshVol(&volumeID)
+	if err != nil {
+		return false, err
+	}
+	req, err := modifyVolumeInput(vol, spec)
+	if err != nil || req == nil {
+		return false, err
+	}
+	req.DryRun = aws.Bool(s.cfg.DryRun)
+	if _, err := s.ec2.ModifyVolume(req); err != nil {
+		return false, s.storageError(err)
+	}
+	return true, nil
+}
+
+// modifyVolumeInput returns the modification of the given volume for the
+// given update, nil if the volume already matches it. The IOPS and
+// throughput of the current type are carried over to the new type if it
+// supports them.
+func modifyVolumeInput(
+	vol *ec2.Volume,
+	spec storageops.VolumeSpecUpdate,
+) (*ec2.ModifyVolumeInput, error) {
+	from := aws.StringValue(vol.VolumeType)
+	to := from
+	if len(spec.Type) > 0 {
+		to = spec.Type
+	}
+	if to != from && (from == ec2.VolumeTypeStandard || to == ec2.VolumeTypeStandard) {
+		return nil, storageops.NewStorageError(storageops.ErrModifyUnsupported,
+			fmt.Sprintf("volume %s can not be modified from %s to %s, "+
+				"magnetic volumes do not support modifications",
+				aws.StringValue(vol.VolumeId), from, to), "")
+	}
+	limits, ok := volumeLimits[to]
+	if !ok {
+		return nil, storageops.NewStorageError(storageops.ErrModifyUnsupported,
+			fmt.Sprintf("unsupported volume type %q", to), "")
+	}
+	fromLimits := volumeLimits[from]
+
+	target := &ec2.Volume{VolumeType: &to, Size: vol.Size}
+	if spec.Iops > 0 {
+		target.Iops = aws.Int64(spec.Iops)
+	} else if limits.maxIops > 0 && fromLimits.maxIops > 0 {
+		target.Iops = vol.Iops
+	}
+	if spec.ThroughputMiBps > 0 {
+		target.Throughput = aws.Int64(spec.ThroughputMiBps)
+	} else if limits.maxThroughput > 0 && fromLimits.maxThroughput > 0 {
+		target.Throughput = vol.Throughput
+	}
+	if err := validateVolume(target); err != nil {
+		return nil, err
+	}
+
+	req := &ec2.ModifyVolumeInput{VolumeId: vol.VolumeId}
+	changed := false
+	if to != from {
+		req.VolumeType = &to
+		changed = true
+	}
+	if target.Iops != nil && (to != from || aws.Int64Value(target.Iops) != aws.Int64Value(vol.Iops)) {
+		req.Iops = target.Iops
+		changed = true
+	}
+	if target.Throughput != nil &&
+		(to != from || aws.Int64Value(target.Throughput) != aws.Int64Value(vol.Throughput)) {
+		req.Throughput = target.Throughput
+		changed = true
+	}
+	if !changed {
+		return nil, nil
+	}
+	return req, nil
+}
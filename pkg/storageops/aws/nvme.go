@@ -0,0 +1,95 @@
+package aws
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sirupsen/logrus"
+)
+
+// nvmeEbsModel is the model of the NVMe controllers of EBS volumes
+const nvmeEbsModel = "Amazon Elastic Block Store"
+
+// sysBlockPath is where the kernel exposes the block devices of the host
+var sysBlockPath = "/sys/block"
+
+// nvmeSysfsSerials returns the serials of the NVMe EBS volumes of the host
+// by device path, read from the controller attributes in sysfs, e.g.
+// /sys/block/nvme1n1/device/serial. The serial of an EBS volume is its ID
+// without the dash, e.g. vol0123456789abcdef0.
+func nvmeSysfsSerials() (map[string]string, error) {
+	namespaces, err := filepath.Glob(filepath.Join(sysBlockPath, "nvme*n*"))
+	if err != nil {
+		return nil, err
+	}
+	serials := make(map[string]string, len(namespaces))
+	for _, ns := range namespaces {
+		name := filepath.Base(ns)
+		// Skip partitions, e.g. nvme0n1p1, which are listed by some kernels
+		if strings.Contains(strings.TrimPrefix(name, "nvme"), "p") {
+			continue
+		}
+		model, err := ioutil.ReadFile(filepath.Join(ns, "device", "model"))
+		if err != nil || strings.TrimSpace(string(model)) != nvmeEbsModel {
+			continue
+		}
+		serial, err := ioutil.ReadFile(filepath.Join(ns, "device", "serial"))
+		if err != nil {
+			continue
+		}
+		serials[awsDevicePrefixNvme+strings.TrimPrefix(name, "nvme")] = strings.TrimSpace(string(serial))
+	}
+	return serials, nil
+}
+
+// getNvmeDeviceFromSysfs resolves the NVMe device of the given volume from
+// the serials in sysfs
+func getNvmeDeviceFromSysfs(volumeID string) (string, error) {
+	serials, err := nvmeSysfsSerials()
+	if err != nil {
+		return "", fmt.Errorf("unable to map %v volume to an nvme device: %v", volumeID, err)
+	}
+	trimmedVolumeID := strings.Replace(volumeID, "-", "", 1)
+	for devicePath, serial := range serials {
+		if serial == trimmedVolumeID {
+			return devicePath, nil
+		}
+	}
+	return "", fmt.Errorf("unable to map %v volume to an nvme device: "+
+		"no nvme device with serial %v under %v", volumeID, trimmedVolumeID, sysBlockPath)
+}
+
+// nvmeExposed returns true if the EBS volumes of the instance are exposed as
+// NVMe devices, as on all Nitro instances. The instance is probed for NVMe
+// EBS controllers, e.g. of its root volume, and otherwise its instance type
+// is looked up with DescribeInstanceTypes. If neither is conclusive the
+// volumes are assumed to be NVMe devices and the result is not cached.
+func (s *ec2Ops) nvmeExposed() bool {
+	s.nvmeLock.Lock()
+	defer s.nvmeLock.Unlock()
+	if s.nvme != nil {
+		return *s.nvme
+	}
+
+	if serials, err := nvmeSysfsSerials(); err == nil && len(serials) > 0 {
+		s.nvme = aws.Bool(true)
+		return true
+	}
+	out, err := s.ec2.DescribeInstanceTypes(&ec2.DescribeInstanceTypesInput{
+		InstanceTypes: []*string{aws.String(s.instanceType)},
+	})
+	if err != nil || len(out.InstanceTypes) == 0 {
+		logrus.Warnf("Failed to look up whether %v instances expose EBS volumes as NVMe devices: %v",
+			s.instanceType, err)
+		return true
+	}
+	info := out.InstanceTypes[0]
+	nvme := aws.StringValue(info.Hypervisor) == ec2.InstanceTypeHypervisorNitro ||
+		(info.EbsInfo != nil && aws.StringValue(info.EbsInfo.NvmeSupport) == ec2.EbsNvmeSupportRequired)
+	s.nvme = &nvme
+	return nvme
+}
@@ -0,0 +1,205 @@
+package aws
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+const (
+	// VolumeTypeChoiceLabel is the label carrying the volume type chosen by
+	// the volume type optimizer
+	VolumeTypeChoiceLabel = "openstorage-volume-type-choice"
+	// VolumeTypeRationaleLabel is the label carrying the monthly cost of each
+	// candidate volume type compared by the volume type optimizer
+	VolumeTypeRationaleLabel = "openstorage-volume-type-rationale"
+	// pricingFallbackRegion is the region whose prices are used for regions
+	// without a price list
+	pricingFallbackRegion = "us-east-1"
+)
+
+// IopsPriceTier is the monthly price of provisioned IOPS up to a limit
+type IopsPriceTier struct {
+	// UpTo is the number of IOPS the tier ends at, zero for the last tier
+	UpTo int64
+	// Price per provisioned IOPS-month
+	Price float64
+}
+
+// VolumePrices are the monthly EBS prices of a region in USD used by the
+// volume type optimizer
+type VolumePrices struct {
+	// Gp3GiB is the price per GiB-month of gp3 volumes
+	Gp3GiB float64
+	// Gp3Iops is the price per IOPS-month above the gp3 baseline
+	Gp3Iops float64
+	// Gp3Throughput is the price per MiB/s-month above the gp3 baseline
+	Gp3Throughput float64
+	// Io2GiB is the price per GiB-month of io2 volumes
+	Io2GiB float64
+	// Io2Iops are the tiers of the price of io2 provisioned IOPS
+	Io2Iops []IopsPriceTier
+}
+
+// defaultVolumePrices are the on-demand list prices of the regions with the
+// most EBS usage. Set Config.VolumePrices for accurate prices elsewhere.
+var defaultVolumePrices = map[string]*VolumePrices{
+	"us-east-1": usPrices,
+	"us-east-2": usPrices,
+	"us-west-2": usPrices,
+	"eu-west-1": {
+		Gp3GiB: 0.088, Gp3Iops: 0.0055, Gp3Throughput: 0.044,
+		Io2GiB:  0.138,
+		Io2Iops: []IopsPriceTier{{32000, 0.072}, {64000, 0.0504}, {0, 0.0353}},
+	},
+}
+
+var usPrices = &VolumePrices{
+	Gp3GiB: 0.08, Gp3Iops: 0.005, Gp3Throughput: 0.04,
+	Io2GiB:  0.125,
+	Io2Iops: []IopsPriceTier{{32000, 0.065}, {64000, 0.0455}, {0, 0.032}},
+}
+
+// io2 volumes deliver 256 KiB/s per provisioned IOPS
+const io2ThroughputPerIops = 256
+
+// volumeCandidate is a volume type that meets an IOPS and throughput
+// requirement and its monthly cost
+type volumeCandidate struct {
+	vol  *ec2.Volume
+	cost float64
+}
+
+// gp3Candidate returns the gp3 volume of the given template meeting its IOPS
+// and throughput with the lowest provisioned values
+func gp3Candidate(template *ec2.Volume, prices *VolumePrices) (*volumeCandidate, error) {
+	limits := volumeLimits[ec2.VolumeTypeGp3]
+	iops := maxInt64(aws.Int64Value(template.Iops), limits.baselineIops)
+	throughput := maxInt64(aws.Int64Value(template.Throughput), limits.minThroughput)
+	if minIops := ceilDiv(throughput*1024, limits.maxThroughputPerIops); iops < minIops {
+		iops = minIops
+	}
+
+	vol := *template
+	vol.VolumeType = aws.String(ec2.VolumeTypeGp3)
+	vol.Iops = aws.Int64(iops)
+	vol.Throughput = aws.Int64(throughput)
+	if err := validateVolume(&vol); err != nil {
+		return nil, err
+	}
+	cost := float64(aws.Int64Value(vol.Size))*prices.Gp3GiB +
+		float64(iops-limits.baselineIops)*prices.Gp3Iops +
+		float64(throughput-limits.minThroughput)*prices.Gp3Throughput
+	return &volumeCandidate{vol: &vol, cost: cost}, nil
+}
+
+// io2Candidate returns the io2 volume of the given template meeting its IOPS
+// and throughput. io2 throughput can not be provisioned, it scales with the
+// IOPS.
+func io2Candidate(template *ec2.Volume, prices *VolumePrices) (*volumeCandidate, error) {
+	limits := volumeLimits[ec2.VolumeTypeIo2]
+	iops := maxInt64(aws.Int64Value(template.Iops), limits.minIops)
+	if minIops := ceilDiv(aws.Int64Value(template.Throughput)*1024, io2ThroughputPerIops); iops < minIops {
+		iops = minIops
+	}
+
+	vol := *template
+	vol.VolumeType = aws.String(ec2.VolumeTypeIo2)
+	vol.Iops = aws.Int64(iops)
+	vol.Throughput = nil
+	if err := validateVolume(&vol); err != nil {
+		return nil, err
+	}
+	cost := float64(aws.Int64Value(vol.Size)) * prices.Io2GiB
+	var priced int64
+	for _, tier := range prices.Io2Iops {
+		upTo := tier.UpTo
+		if upTo == 0 || upTo > iops {
+			upTo = iops
+		}
+		if upTo > priced {
+			cost += float64(upTo-priced) * tier.Price
+			priced = upTo
+		}
+	}
+	return &volumeCandidate{vol: &vol, cost: cost}, nil
+}
+
+// optimizeVolumeType returns the cheaper of a gp3 and an io2 volume meeting
+// the IOPS and throughput of the given template, with the labels recording
+// the decision
+func optimizeVolumeType(
+	template *ec2.Volume,
+	region string,
+	prices *VolumePrices,
+) (*ec2.Volume, map[string]string, error) {
+	if template.Size == nil {
+		return nil, nil, storageops.NewStorageError(storageops.ErrVolInval,
+			"the volume size is required to choose the volume type", "")
+	}
+
+	var (
+		best       *volumeCandidate
+		rationale  []string
+		rejections []string
+	)
+	for _, candidate := range []struct {
+		volType string
+		build   func(*ec2.Volume, *VolumePrices) (*volumeCandidate, error)
+	}{
+		{ec2.VolumeTypeGp3, gp3Candidate},
+		{ec2.VolumeTypeIo2, io2Candidate},
+	} {
+		c, err := candidate.build(template, prices)
+		if err != nil {
+			rejections = append(rejections, fmt.Sprintf("%s: %v", candidate.volType, err))
+			rationale = append(rationale, candidate.volType+"=unsuitable")
+			continue
+		}
+		rationale = append(rationale, fmt.Sprintf("%s=%.2f", candidate.volType, c.cost))
+		// gp3 wins ties as it is listed first
+		if best == nil || c.cost < best.cost {
+			best = c
+		}
+	}
+	if best == nil {
+		return nil, nil, storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("no volume type meets %d IOPS and %d MiB/s: %s",
+				aws.Int64Value(template.Iops), aws.Int64Value(template.Throughput),
+				strings.Join(rejections, "; ")), "")
+	}
+
+	labels := map[string]string{
+		VolumeTypeChoiceLabel: aws.StringValue(best.vol.VolumeType),
+		VolumeTypeRationaleLabel: fmt.Sprintf("%s USD/month in %s for %d IOPS %d MiB/s",
+			strings.Join(rationale, " "), region,
+			aws.Int64Value(template.Iops), aws.Int64Value(template.Throughput)),
+	}
+	return best.vol, labels, nil
+}
+
+// volumePrices returns the prices of the given region, those of
+// pricingFallbackRegion if it has no price list
+func (s *ec2Ops) volumePrices(region string) *VolumePrices {
+	if prices, ok := s.cfg.VolumePrices[region]; ok {
+		return prices
+	}
+	if prices, ok := defaultVolumePrices[region]; ok {
+		return prices
+	}
+	return defaultVolumePrices[pricingFallbackRegion]
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func ceilDiv(a, b int64) int64 {
+	return (a + b - 1) / b
+}
@@ -0,0 +1,89 @@
+package aws
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/sirupsen/logrus"
+)
+
+var _ storageops.LimitsOps = &ec2Ops{}
+
+// volumeTypeMaximum are the maximum IOPS and throughput in MiB/s of a volume
+// type that volumeLimits has no provisioning limits for
+type volumeTypeMaximum struct {
+	iops       int64
+	throughput int64
+}
+
+// volumeTypeMaxima are the documented maximum performance of the EBS volume
+// types, see volumePerformance
+var volumeTypeMaxima = map[string]volumeTypeMaximum{
+	ec2.VolumeTypeStandard: {iops: 200, throughput: 90},
+	ec2.VolumeTypeGp2:      {iops: 16000, throughput: 250},
+	ec2.VolumeTypeIo1:      {throughput: 1000},
+	ec2.VolumeTypeIo2:      {throughput: 4000},
+	ec2.VolumeTypeSt1:      {iops: 500, throughput: 500},
+	ec2.VolumeTypeSc1:      {iops: 250, throughput: 250},
+}
+
+// ProviderLimits returns the EBS limits of the volume types and of the
+// instance type of this instance. The instance limits are those of
+// Config.InstanceTypeLimits if set for the instance type, or the maximum EBS
+// performance reported by DescribeInstanceTypes. If the instance type can
+// not be described the baseline of defaultInstanceTypeLimits is used.
+func (s *ec2Ops) ProviderLimits() (*storageops.ProviderLimits, error) {
+	limits := &storageops.ProviderLimits{
+		MaxAttachments:   s.maxAttachments(),
+		MaxVolumeSizeGiB: storageops.GetSizeRule(s.Name()).Max.GiB(),
+		VolumeTypes:      make(map[string]storageops.VolumeTypeLimits, len(volumeLimits)),
+	}
+	for name, l := range volumeLimits {
+		t := storageops.VolumeTypeLimits{
+			MinSizeGiB:         uint64(l.minSizeGiB),
+			MaxSizeGiB:         uint64(l.maxSizeGiB),
+			MaxIops:            l.maxIops,
+			MaxThroughputMiBps: l.maxThroughput,
+		}
+		if m, ok := volumeTypeMaxima[name]; ok {
+			if t.MaxIops == 0 {
+				t.MaxIops = m.iops
+			}
+			if t.MaxThroughputMiBps == 0 {
+				t.MaxThroughputMiBps = m.throughput
+			}
+		}
+		limits.VolumeTypes[name] = t
+	}
+
+	if l, ok := s.cfg.InstanceTypeLimits[s.instanceType]; ok {
+		limits.MaxInstanceIops = l.Iops
+		limits.MaxInstanceThroughputMiBps = l.BandwidthMiBps
+		return limits, nil
+	}
+	out, err := s.ec2.DescribeInstanceTypes(&ec2.DescribeInstanceTypesInput{
+		InstanceTypes: []*string{aws.String(s.instanceType)},
+	})
+	if err == nil && len(out.InstanceTypes) > 0 {
+		if info := out.InstanceTypes[0].EbsInfo; info != nil && info.EbsOptimizedInfo != nil {
+			limits.MaxInstanceIops = aws.Int64Value(info.EbsOptimizedInfo.MaximumIops)
+			// MB/s to MiB/s
+			limits.MaxInstanceThroughputMiBps = int64(
+				aws.Float64Value(info.EbsOptimizedInfo.MaximumThroughputInMBps) * 1000 * 1000 / (1024 * 1024))
+		}
+		return limits, nil
+	}
+	if l, ok := defaultInstanceTypeLimits[s.instanceType]; ok {
+		if err != nil {
+			logrus.Warnf("Failed to describe instance type %v, using its baseline EBS limits: %v",
+				s.instanceType, err)
+		}
+		limits.MaxInstanceIops = l.Iops
+		limits.MaxInstanceThroughputMiBps = l.BandwidthMiBps
+		return limits, nil
+	}
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	return limits, nil
+}
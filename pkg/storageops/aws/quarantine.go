@@ -0,0 +1,66 @@
+package aws
+
+import (
+	"time"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+// deviceQuarantine returns how long device names freed by detaches are held
+// back, zero if the quarantine is disabled
+func (s *ec2Ops) deviceQuarantine() time.Duration {
+	switch {
+	case s.cfg.DeviceQuarantine < 0:
+		return 0
+	case s.cfg.DeviceQuarantine == 0:
+		return storageops.DefaultDeviceQuarantine
+	}
+	return s.cfg.DeviceQuarantine
+}
+
+// quarantineDevice holds back the device a volume was detached from on the
+// given instance. Devices are quarantined by their letters, the same device
+// may be named /dev/sdf or /dev/xvdf.
+func (s *ec2Ops) quarantineDevice(instanceID, device string) {
+	if len(device) == 0 {
+		return
+	}
+	storageops.InstanceDeviceQuarantine(instanceID).Quarantine(
+		deviceSuffix(device), s.deviceQuarantine())
+}
+
+// awaitQuarantine drops the quarantined devices from the free devices of this
+// instance. It waits for a quarantined device to be released if all free
+// devices are quarantined, or one of the requested devices is.
+func (s *ec2Ops) awaitQuarantine(free []string, requested []string) ([]string, error) {
+	period := s.deviceQuarantine()
+	if period == 0 {
+		return free, nil
+	}
+	q := storageops.InstanceDeviceQuarantine(s.instance)
+	deadline := time.Now().Add(period)
+	for _, r := range requested {
+		if len(r) == 0 {
+			continue
+		}
+		if _, err := q.Await(s.Name(), []string{deviceSuffix(r)}, deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	bySuffix := make(map[string]string, len(free))
+	suffixes := make([]string, len(free))
+	for i, d := range free {
+		suffixes[i] = deviceSuffix(d)
+		bySuffix[suffixes[i]] = d
+	}
+	available, err := q.Await(s.Name(), suffixes, deadline)
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]string, len(available))
+	for i, suffix := range available {
+		devices[i] = bySuffix[suffix]
+	}
+	return devices, nil
+}
@@ -0,0 +1,157 @@
+package aws
+
+import (
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+// MappingSource is a view of the volumes attached to an instance
+type MappingSource string
+
+const (
+	// MappingSourceInstance is the BlockDeviceMappings of DescribeInstances,
+	// which can lag attachments by minutes
+	MappingSourceInstance MappingSource = "instance"
+	// MappingSourceVolume is the attachments of DescribeVolumes
+	MappingSourceVolume MappingSource = "volume"
+	// MappingSourceLocal is the block devices present on this instance
+	MappingSourceLocal MappingSource = "local"
+)
+
+// MappingDiscrepancy is a volume whose attachment to this instance the
+// mapping sources disagree on
+type MappingDiscrepancy struct {
+	// VolumeID of the volume
+	VolumeID string
+	// Device is the device name the volume is attached as, e.g. /dev/sdf
+	Device string
+	// Attached is the resolved attachment state, the one of the majority of
+	// the sources
+	Attached bool
+	// Stale are the sources that disagree with the resolved state
+	Stale []MappingSource
+}
+
+// MappingReconciler is implemented by the AWS storage ops driver
+type MappingReconciler interface {
+	// ReconcileDeviceMappings returns the device mappings agreed on by the
+	// majority of the mapping sources, and the volumes they disagree on
+	ReconcileDeviceMappings() (map[string]string, []*MappingDiscrepancy, error)
+}
+
+// ReconcileDeviceMappings cross checks the block device mappings of the
+// instance against the attachments of its volumes and the local block
+// devices. A volume is considered attached if at least two sources say so.
+// Every disagreement is reported with storageops.ReportDiscrepancy.
+func (s *ec2Ops) ReconcileDeviceMappings() (map[string]string, []*MappingDiscrepancy, error) {
+	instance, err := s.describe()
+	if err != nil {
+		return nil, nil, err
+	}
+	instanceView := make(map[string]string)
+	for _, d := range instance.BlockDeviceMappings {
+		if d.DeviceName == nil || d.Ebs == nil || d.Ebs.VolumeId == nil ||
+			aws.StringValue(d.DeviceName) == aws.StringValue(instance.RootDeviceName) {
+			continue
+		}
+		instanceView[*d.Ebs.VolumeId] = *d.DeviceName
+	}
+
+	volumeView := make(map[string]string)
+	err = s.ec2.DescribeVolumesPages(&ec2.DescribeVolumesInput{
+		Filters: []*ec2.Filter{{
+			Name:   aws.String("attachment.instance-id"),
+			Values: []*string{&s.instance},
+		}},
+	}, func(page *ec2.DescribeVolumesOutput, lastPage bool) bool {
+		for _, vol := range page.Volumes {
+			a := attachmentTo(vol, s.instance)
+			if a != nil && aws.StringValue(a.State) == ec2.VolumeAttachmentStateAttached &&
+				aws.StringValue(a.Device) != aws.StringValue(instance.RootDeviceName) {
+				volumeView[aws.StringValue(vol.VolumeId)] = aws.StringValue(a.Device)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, nil, s.storageError(err)
+	}
+
+	mappings, discrepancies := reconcileMappings(instanceView, volumeView,
+		func(device, volumeID string) (string, bool) {
+			devicePath, err := s.getActualDevicePath(device, volumeID)
+			return devicePath, err == nil
+		})
+	for _, d := range discrepancies {
+		for _, source := range d.Stale {
+			storageops.ReportDiscrepancy(s.Name(), "device-mapping/"+string(source))
+		}
+	}
+	return mappings, discrepancies, nil
+}
+
+// reconcileMappings resolves the instance and volume views, both volume ID
+// to device name, and the local device paths returned by local by majority
+func reconcileMappings(
+	instanceView, volumeView map[string]string,
+	local func(device, volumeID string) (string, bool),
+) (map[string]string, []*MappingDiscrepancy) {
+	volumeIDs := make([]string, 0, len(instanceView)+len(volumeView))
+	for id := range instanceView {
+		volumeIDs = append(volumeIDs, id)
+	}
+	for id := range volumeView {
+		if _, ok := instanceView[id]; !ok {
+			volumeIDs = append(volumeIDs, id)
+		}
+	}
+	sort.Strings(volumeIDs)
+
+	mappings := make(map[string]string)
+	var discrepancies []*MappingDiscrepancy
+	for _, id := range volumeIDs {
+		// The volume describe is more recent than the instance describe
+		device, inVolume := volumeView[id]
+		instanceDevice, inInstance := instanceView[id]
+		if !inVolume {
+			device = instanceDevice
+		}
+		devicePath, inLocal := local(device, id)
+
+		views := map[MappingSource]bool{
+			MappingSourceInstance: inInstance,
+			MappingSourceVolume:   inVolume,
+			MappingSourceLocal:    inLocal,
+		}
+		votes := 0
+		for _, attached := range views {
+			if attached {
+				votes++
+			}
+		}
+		attached := votes >= 2
+		if attached {
+			if !inLocal {
+				devicePath = device
+			}
+			mappings[devicePath] = id
+		}
+		if votes == len(views) {
+			continue
+		}
+
+		d := &MappingDiscrepancy{VolumeID: id, Device: device, Attached: attached}
+		for _, source := range []MappingSource{
+			MappingSourceInstance, MappingSourceVolume, MappingSourceLocal,
+		} {
+			if views[source] != attached {
+				d.Stale = append(d.Stale, source)
+			}
+		}
+		discrepancies = append(discrepancies, d)
+	}
+	return mappings, discrepancies
+}
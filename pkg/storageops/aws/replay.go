@@ -0,0 +1,93 @@
+package aws
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/cloudtrail"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+// replayedEvents are the CloudTrail event names included in a replay
+var replayedEvents = map[string]bool{
+	"CreateVolume":   true,
+	"AttachVolume":   true,
+	"DetachVolume":   true,
+	"DeleteVolume":   true,
+	"ModifyVolume":   true,
+	"CreateSnapshot": true,
+	"DeleteSnapshot": true,
+	"CreateTags":     true,
+	"DeleteTags":     true,
+}
+
+// cloudTrailRecord is the part of a CloudTrail event record used in a replay
+type cloudTrailRecord struct {
+	ErrorCode         string `json:"errorCode"`
+	ErrorMessage      string `json:"errorMessage"`
+	RequestParameters struct {
+		VolumeID   string `json:"volumeId"`
+		InstanceID string `json:"instanceId"`
+	} `json:"requestParameters"`
+}
+
+// ReplayCloudTrail pulls the CloudTrail events of the given volumes between
+// start and end and returns them as a timeline, to be merged with the local
+// audit log using storageops.MergeTimelines.
+func ReplayCloudTrail(
+	p client.ConfigProvider,
+	volumeIDs []string,
+	start, end time.Time,
+) ([]*storageops.TimelineEntry, error) {
+	ct := cloudtrail.New(p)
+
+	var entries []*storageops.TimelineEntry
+	for _, volumeID := range volumeIDs {
+		input := &cloudtrail.LookupEventsInput{
+			StartTime: aws.Time(start),
+			EndTime:   aws.Time(end),
+			LookupAttributes: []*cloudtrail.LookupAttribute{{
+				AttributeKey:   aws.String(cloudtrail.LookupAttributeKeyResourceName),
+				AttributeValue: aws.String(volumeID),
+			}},
+		}
+		err := ct.LookupEventsPages(input,
+			func(page *cloudtrail.LookupEventsOutput, lastPage bool) bool {
+				for _, event := range page.Events {
+					if entry := timelineEntry(volumeID, event); entry != nil {
+						entries = append(entries, entry)
+					}
+				}
+				return true
+			})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return storageops.MergeTimelines(entries), nil
+}
+
+func timelineEntry(volumeID string, event *cloudtrail.Event) *storageops.TimelineEntry {
+	name := aws.StringValue(event.EventName)
+	if !replayedEvents[name] {
+		return nil
+	}
+
+	entry := &storageops.TimelineEntry{
+		Time:      aws.TimeValue(event.EventTime),
+		Source:    storageops.TimelineSourceProvider,
+		Operation: name,
+		VolumeID:  volumeID,
+		User:      aws.StringValue(event.Username),
+	}
+	record := &cloudTrailRecord{}
+	if err := json.Unmarshal([]byte(aws.StringValue(event.CloudTrailEvent)), record); err == nil {
+		entry.InstanceID = record.RequestParameters.InstanceID
+		if len(record.ErrorCode) > 0 {
+			entry.Err = record.ErrorCode + ": " + record.ErrorMessage
+		}
+	}
+	return entry
+}
@@ -0,0 +1,123 @@
+package aws
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/sirupsen/logrus"
+)
+
+// maxAttachConflictRetries is how many other devices an attach tries when
+// EC2 reports its device in use, e.g. by an attacher on this instance that
+// does not reserve devices or whose attach this driver has not seen yet
+const maxAttachConflictRetries = 3
+
+// deviceReservationDir returns where devices are reserved on this host
+func (s *ec2Ops) deviceReservationDir() string {
+	if len(s.cfg.DeviceReservationDir) == 0 {
+		return storageops.DefaultDeviceReservationDir
+	}
+	return s.cfg.DeviceReservationDir
+}
+
+// reserveDevice reserves the given device of this instance on the host by
+// its letters, the same device may be named /dev/sdf or /dev/xvdf. If the
+// host does not support reservations, e.g. as /run is not writable, the
+// device is used without one.
+func (s *ec2Ops) reserveDevice(device string) (*storageops.DeviceReservation, error) {
+	r, err := storageops.ReserveDevice(s.deviceReservationDir(), s.instance, deviceSuffix(device))
+	if err != nil && !storageops.IsErrorCode(err, storageops.ErrDeviceBusy) {
+		logrus.Warnf("Failed to reserve device %v of %v, attaching without reservation: %v",
+			device, s.instance, err)
+		return nil, nil
+	}
+	return r, err
+}
+
+// spareDevices are the free devices not picked by any attach of a call, that
+// attaches move to when their device is taken by another attacher
+type spareDevices struct {
+	sync.Mutex
+	free []string
+}
+
+// newSpareDevices returns the free devices that are not picked
+func newSpareDevices(free []string, picked ...string) *spareDevices {
+	spares := &spareDevices{}
+	for _, d := range free {
+		taken := false
+		for _, p := range picked {
+			taken = taken || d == p
+		}
+		if !taken {
+			spares.free = append(spares.free, d)
+		}
+	}
+	return spares
+}
+
+// reserve reserves the first spare device that is not reserved by another
+// attacher
+func (d *spareDevices) reserve(s *ec2Ops) (string, *storageops.DeviceReservation, error) {
+	d.Lock()
+	defer d.Unlock()
+	for len(d.free) > 0 {
+		device := d.free[0]
+		d.free = d.free[1:]
+		if r, err := s.reserveDevice(device); err == nil {
+			return device, r, nil
+		}
+	}
+	return "", nil, storageops.NewStorageError(storageops.ErrDeviceBusy,
+		"no free device left that is not taken by another attacher", s.instance)
+}
+
+// isDeviceInUse returns true if EC2 rejected an attach as its device is in
+// use by another volume
+func isDeviceInUse(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	return awsErr.Code() == "InvalidDevice.InUse" ||
+		(awsErr.Code() == "InvalidParameterValue" && strings.Contains(awsErr.Message(), "already in use"))
+}
+
+// attachReserved attaches a volume at the given device, reserved on the host
+// until the attach completes, and waits for it. Attaches without a requested
+// device move to a spare device if theirs is reserved by another attacher or
+// EC2 reports it in use. The caller holds the attach lock of the instance.
+func (s *ec2Ops) attachReserved(
+	r *storageops.AttachRequest,
+	device string,
+	spares *spareDevices,
+	progress func(),
+) (string, error) {
+	requested := len(r.Options[storageops.AttachOptionDevice]) > 0
+	volumeID := r.VolumeID
+	reservation, err := s.reserveDevice(device)
+	for attempt := 0; ; attempt++ {
+		if err == nil {
+			err = s.attachVolume(volumeID, device)
+			if err == nil {
+				defer reservation.Release()
+				return s.awaitAttach(volumeID, r.Options, progress)
+			}
+			reservation.Release()
+			if !isDeviceInUse(err) {
+				return "", s.storageError(err)
+			}
+		}
+		if requested || attempt >= maxAttachConflictRetries {
+			return "", s.storageError(err)
+		}
+		logrus.Infof("Device %v of %v is taken by another attacher, attaching volume %v at another device: %v",
+			device, s.instance, volumeID, err)
+		if device, reservation, err = spares.reserve(s); err != nil {
+			return "", fmt.Errorf("failed to attach volume %v: %v", volumeID, err)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package aws
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/sirupsen/logrus"
+)
+
+// nvmeStrategy is how EBS volumes exposed as NVMe devices are mapped to their
+// device paths
+type nvmeStrategy string
+
+const (
+	// nvmeStrategySysfs reads the serials of the NVMe controllers in sysfs
+	nvmeStrategySysfs nvmeStrategy = "sysfs"
+	// nvmeStrategyCli parses the output of nvme list
+	nvmeStrategyCli nvmeStrategy = "nvme-cli"
+	// nvmeStrategyByID follows the udev created /dev/disk/by-id symlinks
+	nvmeStrategyByID nvmeStrategy = "udev-by-id"
+	// nvmeStrategyNone means NVMe devices cannot be resolved on this host
+	nvmeStrategyNone nvmeStrategy = "none"
+)
+
+const (
+	// nvmeByIDPrefix is the prefix of the udev by-id symlinks of NVMe EBS volumes
+	nvmeByIDPrefix = "/dev/disk/by-id/nvme-Amazon_Elastic_Block_Store_"
+	// nvmeMinKernelMajor and nvmeMinKernelMinor is the oldest kernel with a
+	// reliable NVMe driver for EBS
+	nvmeMinKernelMajor = 4
+	nvmeMinKernelMinor = 4
+)
+
+// SelfTestReport is the result of the startup capability probe
+type SelfTestReport struct {
+	// Host are the probed host capabilities
+	Host *storageops.HostCapabilities
+	// NvmeStrategy is how NVMe devices will be mapped to EBS volumes
+	NvmeStrategy string
+	// NvmeFallback is how NVMe devices are mapped when the serials of
+	// sysfs are not readable, e.g. for devices hidden by a container
+	NvmeFallback string
+	// Warnings are degraded capabilities that did not prevent startup
+	Warnings []string
+}
+
+// probeHost probes the host capabilities, replaced by tests
+var probeHost = storageops.ProbeHost
+
+// SelfTest probes the host for the tooling needed to map EBS volumes to
+// device paths and picks the best supported strategy, so missing tooling on
+// minimal AMIs is reported before the first attach needs it.
+func SelfTest() *SelfTestReport {
+	r := &SelfTestReport{Host: probeHost()}
+
+	if !r.Host.KernelAtLeast(nvmeMinKernelMajor, nvmeMinKernelMinor) {
+		r.Warnings = append(r.Warnings,
+			fmt.Sprintf("kernel %s is older than %d.%d, NVMe EBS volumes may not work",
+				r.Host.KernelVersion, nvmeMinKernelMajor, nvmeMinKernelMinor))
+	}
+	if !r.Host.SysBlock {
+		r.Warnings = append(r.Warnings, "/sys/block is not available")
+	}
+
+	fallback := nvmeStrategyNone
+	switch {
+	case len(r.Host.NvmeCli) > 0:
+		fallback = nvmeStrategyCli
+	case r.Host.Udev && r.Host.DiskByID:
+		fallback = nvmeStrategyByID
+	}
+	switch {
+	case r.Host.SysBlock:
+		r.NvmeStrategy = string(nvmeStrategySysfs)
+		r.NvmeFallback = string(fallback)
+	case fallback == nvmeStrategyCli:
+		r.NvmeStrategy = string(fallback)
+	case fallback == nvmeStrategyByID:
+		r.NvmeStrategy = string(fallback)
+		r.Warnings = append(r.Warnings,
+			"nvme-cli is not installed, falling back to udev by-id symlinks")
+	default:
+		r.NvmeStrategy = string(nvmeStrategyNone)
+		r.Warnings = append(r.Warnings,
+			"neither sysfs, nvme-cli nor udev by-id symlinks are available, "+
+				"NVMe EBS volumes cannot be mapped to devices")
+	}
+
+	logrus.Infof("AWS storage ops self test: %v nvme strategy: %v fallback: %v",
+		r.Host, r.NvmeStrategy, r.NvmeFallback)
+	for _, w := range r.Warnings {
+		logrus.Warnf("AWS storage ops self test: %v", w)
+	}
+	return r
+}
+
+// lazySelfTest runs the self test on first use, so creating a driver, e.g.
+// for every assumed role, does not probe the host
+type lazySelfTest struct {
+	once   sync.Once
+	report *SelfTestReport
+}
+
+// get returns the report, running the self test unless the report is set
+func (t *lazySelfTest) get() *SelfTestReport {
+	if t == nil {
+		return nil
+	}
+	t.once.Do(func() {
+		if t.report == nil {
+			t.report = SelfTest()
+		}
+	})
+	return t.report
+}
+
+// SelfTester is implemented by the AWS storage ops driver
+type SelfTester interface {
+	// SelfTestReport returns the result of the self test of the driver,
+	// running it on the first call
+	SelfTestReport() *SelfTestReport
+}
+
+var _ SelfTester = &ec2Ops{}
+
+func (s *ec2Ops) SelfTestReport() *SelfTestReport {
+	return s.selfTest.get()
+}
+
+// nvmeStrategies returns the NVMe resolution strategies in the order they
+// are tried
+func (s *ec2Ops) nvmeStrategies() []nvmeStrategy {
+	report := s.SelfTestReport()
+	if report == nil {
+		return nil
+	}
+	var strategies []nvmeStrategy
+	for _, strategy := range []string{report.NvmeStrategy, report.NvmeFallback} {
+		if len(strategy) > 0 && nvmeStrategy(strategy) != nvmeStrategyNone {
+			strategies = append(strategies, nvmeStrategy(strategy))
+		}
+	}
+	return strategies
+}
+
+// getNvmeDeviceFromByID resolves the NVMe device of the given volume through
+// the udev by-id symlink, e.g.
+// /dev/disk/by-id/nvme-Amazon_Elastic_Block_Store_vol0123456789abcdef0
+func getNvmeDeviceFromByID(volumeID string) (string, error) {
+	trimmedVolumeID := strings.Replace(volumeID, "-", "", 1)
+	devicePath, err := filepath.EvalSymlinks(nvmeByIDPrefix + trimmedVolumeID)
+	if err != nil {
+		return "", fmt.Errorf("unable to map %v volume to an nvme device: %v", volumeID, err)
+	}
+	return devicePath, nil
+}
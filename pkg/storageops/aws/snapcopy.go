@@ -0,0 +1,157 @@
+package aws
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+// defaultSnapshotCopyTimeout is the default time to wait for a cross region
+// snapshot copy to complete
+const defaultSnapshotCopyTimeout = 30 * time.Minute
+
+// SnapshotCopier is implemented by the AWS storage ops driver
+type SnapshotCopier interface {
+	// SnapshotCopy copies the given snapshot of this region to destRegion,
+	// waits for the copy to complete and applies the given labels to it.
+	// It returns the *ec2.Snapshot of the copy.
+	SnapshotCopy(snapID, destRegion string, labels map[string]string) (interface{}, error)
+	// SnapshotCopyAsync starts to copy the given snapshot like SnapshotCopy
+	// and returns the operation of the copy without waiting for it to
+	// complete. Wait returns the *ec2.Snapshot of the copy, Cancel deletes
+	// the copy.
+	SnapshotCopyAsync(snapID, destRegion string, labels map[string]string) (storageops.Operation, error)
+}
+
+func (s *ec2Ops) SnapshotCopy(
+	snapID, destRegion string,
+	labels map[string]string,
+) (interface{}, error) {
+	dest, copyID, err := s.startSnapshotCopy(snapID, destRegion, labels)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := storageops.RetryPolicy{}
+	if p := s.cfg.RetryPolicy.For(storageops.RetryOpSnapshot); p != nil {
+		policy = *p
+	}
+	if policy.Timeout == 0 {
+		policy.Timeout = s.cfg.SnapshotCopyTimeout
+	}
+	if policy.Timeout == 0 {
+		policy.Timeout = defaultSnapshotCopyTimeout
+	}
+	out, err := policy.Retry(
+		fmt.Sprintf("wait for snapshot copy %s in %s to be %s",
+			copyID, destRegion, ec2.SnapshotStateCompleted),
+		func() (interface{}, bool, error) {
+			snap, err := s.describeSnapshotCopy(dest, copyID)
+			if err != nil {
+				return nil, true, err
+			}
+			status := snapshotStatus(snap)
+			if status.Failed {
+				return nil, false, fmt.Errorf("%v failed: %s", status, status.Message)
+			}
+			if !status.Completed {
+				return nil, true, fmt.Errorf("%v is not completed", status)
+			}
+			return snap, false, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return out.(*ec2.Snapshot), nil
+}
+
+func (s *ec2Ops) SnapshotCopyAsync(
+	snapID, destRegion string,
+	labels map[string]string,
+) (storageops.Operation, error) {
+	dest, copyID, err := s.startSnapshotCopy(snapID, destRegion, labels)
+	if err != nil {
+		return nil, err
+	}
+	return storageops.NewOperation(copyID, func() (*storageops.OperationStatus, interface{}, error) {
+		snap, err := s.describeSnapshotCopy(dest, copyID)
+		if err != nil {
+			return nil, nil, err
+		}
+		return snapshotStatus(snap).OperationStatus(), snap, nil
+	}, func() error {
+		_, err := dest.DeleteSnapshot(&ec2.DeleteSnapshotInput{
+			DryRun:     aws.Bool(s.cfg.DryRun),
+			SnapshotId: &copyID,
+		})
+		return s.storageError(err)
+	}), nil
+}
+
+// startSnapshotCopy starts to copy the given snapshot to destRegion and
+// returns the client of destRegion and the ID of the copy
+func (s *ec2Ops) startSnapshotCopy(
+	snapID, destRegion string,
+	labels map[string]string,
+) (*ec2.EC2, string, error) {
+	srcRegion := aws.StringValue(s.ec2.Config.Region)
+	if len(destRegion) == 0 {
+		return nil, "", storageops.NewStorageError(storageops.ErrVolInval,
+			"destination region is required", s.instance)
+	}
+
+	dest, err := s.regionClient(destRegion)
+	if err != nil {
+		return nil, "", err
+	}
+	// CopySnapshot is called in the destination region, the source region is
+	// where the snapshot is copied from
+	resp, err := dest.CopySnapshot(&ec2.CopySnapshotInput{
+		DryRun:           aws.Bool(s.cfg.DryRun),
+		SourceRegion:     &srcRegion,
+		SourceSnapshotId: &snapID,
+		Description: aws.String(fmt.Sprintf("Copy of %s from %s",
+			snapID, srcRegion)),
+		TagSpecifications: s.tagSpecifications(ec2.ResourceTypeSnapshot, labels),
+	})
+	if err != nil {
+		return nil, "", s.storageError(err)
+	}
+	return dest, aws.StringValue(resp.SnapshotId), nil
+}
+
+// describeSnapshotCopy returns the given copy with the given client of its
+// region
+func (s *ec2Ops) describeSnapshotCopy(dest *ec2.EC2, copyID string) (*ec2.Snapshot, error) {
+	snaps, err := dest.DescribeSnapshots(&ec2.DescribeSnapshotsInput{
+		SnapshotIds: []*string{&copyID},
+	})
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	if len(snaps.Snapshots) != 1 {
+		return nil, fmt.Errorf("expected one snapshot %v got %v",
+			copyID, len(snaps.Snapshots))
+	}
+	return snaps.Snapshots[0], nil
+}
+
+// regionClient returns an EC2 client for the given region using the
+// configuration and credentials of this driver's client
+func (s *ec2Ops) regionClient(region string) (*ec2.EC2, error) {
+	if region == aws.StringValue(s.ec2.Config.Region) {
+		return s.ec2, nil
+	}
+	sess, err := session.NewSession(s.ec2.Config.Copy(&aws.Config{Region: &region}))
+	if err != nil {
+		return nil, err
+	}
+	client := ec2.New(sess)
+	installRateLimiter(client, s.cfg.RateLimit, s.cfg.RateBurst)
+	return client, nil
+}
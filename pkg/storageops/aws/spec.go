@@ -0,0 +1,32 @@
+package aws
+
+import "github.com/libopenstorage/openstorage/pkg/storageops"
+
+var _ storageops.SpecReporter = &ec2Ops{}
+
+func (s *ec2Ops) Spec(volumeID string) (*storageops.DesiredSpec, error) {
+	vol, err := s.refreshVol(&volumeID)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &storageops.DesiredSpec{
+		Tags: make(map[string]string),
+	}
+	if vol.VolumeType != nil {
+		spec.Type = *vol.VolumeType
+	}
+	if vol.Iops != nil {
+		spec.Iops = *vol.Iops
+	}
+	if vol.Throughput != nil {
+		spec.ThroughputMiBps = *vol.Throughput
+	}
+	if vol.Encrypted != nil {
+		spec.Encrypted = *vol.Encrypted
+	}
+	for _, tag := range vol.Tags {
+		spec.Tags[*tag.Key] = *tag.Value
+	}
+	return spec, nil
+}
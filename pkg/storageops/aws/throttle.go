@@ -0,0 +1,59 @@
+package aws
+
+import (
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+const (
+	// defaultRateLimit is the default rate of EC2 API calls per second of a
+	// driver, well below the EC2 request rate limits of an account
+	defaultRateLimit = 20
+	// defaultRateBurst is the default burst of EC2 API calls of a driver
+	defaultRateBurst = 40
+	// rateLimitHandler is the name of the EC2 client handler waiting for the
+	// rate limiter
+	rateLimitHandler = "openstorage.storageops.RateLimit"
+	// throttleHandler is the name of the EC2 client handler detecting
+	// throttled calls
+	throttleHandler = "openstorage.storageops.Throttle"
+)
+
+// installRateLimiter makes every attempt of the calls of the given client,
+// including the retries of the SDK, wait for a token of a bucket with the
+// given rate, which adapts to throttling errors
+func installRateLimiter(client *ec2.EC2, rate float64, burst int) *storageops.TokenBucket {
+	if rate == 0 {
+		rate = defaultRateLimit
+	}
+	if burst == 0 {
+		burst = defaultRateBurst
+	}
+
+	// Replace the handlers of an earlier driver using the same client
+	client.Handlers.Send.RemoveByName(rateLimitHandler)
+	client.Handlers.CompleteAttempt.RemoveByName(throttleHandler)
+	if rate < 0 {
+		return nil
+	}
+	bucket := storageops.NewTokenBucket(rate, burst)
+	client.Handlers.Send.PushFrontNamed(request.NamedHandler{
+		Name: rateLimitHandler,
+		Fn:   func(r *request.Request) { bucket.Wait() },
+	})
+	client.Handlers.CompleteAttempt.PushBackNamed(request.NamedHandler{
+		Name: throttleHandler,
+		Fn: func(r *request.Request) {
+			if r.Error == nil {
+				bucket.Succeeded()
+				return
+			}
+			if request.IsErrorThrottle(r.Error) {
+				bucket.Throttled()
+				storageops.ReportThrottle("aws", r.Operation.Name)
+			}
+		},
+	})
+	return bucket
+}
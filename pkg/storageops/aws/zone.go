@@ -0,0 +1,56 @@
+package aws
+
+import (
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func (s *ec2Ops) GetZone() (string, error) {
+	self, err := s.describe()
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(self.Placement.AvailabilityZone), nil
+}
+
+func (s *ec2Ops) GetRegion() (string, error) {
+	// The client is bound to the region of the instance, only look it up if
+	// the client was created without one
+	if region := aws.StringValue(s.ec2.Config.Region); len(region) > 0 {
+		return region, nil
+	}
+	zone, err := s.GetZone()
+	if err != nil {
+		return "", err
+	}
+	out, err := s.ec2.DescribeAvailabilityZones(&ec2.DescribeAvailabilityZonesInput{
+		ZoneNames: []*string{&zone},
+	})
+	if err != nil {
+		return "", s.storageError(err)
+	}
+	for _, z := range out.AvailabilityZones {
+		return aws.StringValue(z.RegionName), nil
+	}
+	return "", nil
+}
+
+func (s *ec2Ops) ListZones() ([]string, error) {
+	out, err := s.ec2.DescribeAvailabilityZones(&ec2.DescribeAvailabilityZonesInput{
+		Filters: []*ec2.Filter{{
+			Name:   aws.String("state"),
+			Values: []*string{aws.String(ec2.AvailabilityZoneStateAvailable)},
+		}},
+	})
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	zones := make([]string, 0, len(out.AvailabilityZones))
+	for _, z := range out.AvailabilityZones {
+		zones = append(zones, aws.StringValue(z.ZoneName))
+	}
+	sort.Strings(zones)
+	return zones, nil
+}
@@ -0,0 +1,265 @@
+package storageops
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pborman/uuid"
+)
+
+const (
+	// BackupSetLabel is the label applied to the snapshots of a node backup
+	// with the ID of the backup set
+	BackupSetLabel = "openstorage-backup-set"
+	// defaultBackupConcurrency is the default number of volumes snapshotted
+	// at a time by BackupNode
+	defaultBackupConcurrency = 8
+)
+
+// BackupState is the state of the backup of a single volume
+type BackupState string
+
+const (
+	// BackupPending means the volume waits for a worker
+	BackupPending BackupState = "pending"
+	// BackupRunning means the volume is being snapshotted
+	BackupRunning BackupState = "running"
+	// BackupCompleted means the snapshot was taken and tagged, and completed
+	// if BackupOptions.WaitForCompletion was set
+	BackupCompleted BackupState = "completed"
+	// BackupFailed means the backup of the volume failed, see Err
+	BackupFailed BackupState = "failed"
+)
+
+// BackupOptions are the options of BackupNode
+type BackupOptions struct {
+	// Labels select the managed drives to back up among the volumes attached
+	// to the node. All attached volumes are backed up if empty.
+	Labels map[string]string
+	// Concurrency is the number of volumes snapshotted at a time, defaults
+	// to defaultBackupConcurrency
+	Concurrency int
+	// Budget, if set, is charged one call of its current cycle per operation
+	// of the backup
+	Budget *Budget
+	// WaitForCompletion waits for every snapshot to complete
+	WaitForCompletion bool
+	// Timeout is how long to wait for a snapshot to complete, defaults to
+	// ProviderOpsTimeout
+	Timeout time.Duration
+}
+
+// VolumeBackup is the status of the backup of a single volume
+type VolumeBackup struct {
+	// VolumeID of the volume
+	VolumeID string
+	// SnapshotID of the snapshot, once taken
+	SnapshotID string
+	// State of the backup
+	State BackupState
+	// Err is set if the backup failed
+	Err error
+	// Started is when the volume was picked up by a worker
+	Started time.Time
+	// Finished is when the backup completed or failed
+	Finished time.Time
+}
+
+// BackupJob is the handle of a node backup
+type BackupJob struct {
+	sync.Mutex
+	// ID of the backup set, applied to the snapshots as BackupSetLabel
+	ID      string
+	volumes map[string]*VolumeBackup
+	done    chan struct{}
+}
+
+// Status returns a copy of the per volume status, sorted by volume ID
+func (j *BackupJob) Status() []*VolumeBackup {
+	j.Lock()
+	defer j.Unlock()
+	status := make([]*VolumeBackup, 0, len(j.volumes))
+	for _, v := range j.volumes {
+		copied := *v
+		status = append(status, &copied)
+	}
+	sort.Slice(status, func(i, k int) bool { return status[i].VolumeID < status[k].VolumeID })
+	return status
+}
+
+// Done is closed once every volume completed or failed
+func (j *BackupJob) Done() <-chan struct{} {
+	return j.done
+}
+
+// Wait waits for the job and returns an error listing the failed volumes
+func (j *BackupJob) Wait() error {
+	<-j.done
+	var failed []string
+	for _, v := range j.Status() {
+		if v.State == BackupFailed {
+			failed = append(failed, fmt.Sprintf("%s: %v", v.VolumeID, v.Err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("backup %s failed for %d volumes: %s",
+			j.ID, len(failed), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func (j *BackupJob) update(volumeID string, fn func(v *VolumeBackup)) {
+	j.Lock()
+	defer j.Unlock()
+	fn(j.volumes[volumeID])
+}
+
+// BackupNode snapshots the managed drives attached to the instance of the
+// given driver concurrently and tags the snapshots with a new backup set ID.
+// It returns once the drives are listed, the returned job tracks the
+// snapshots.
+func BackupNode(ops Ops, opts *BackupOptions) (*BackupJob, error) {
+	if opts == nil {
+		opts = &BackupOptions{}
+	}
+	if opts.Budget != nil {
+		ops = NewBudgetedOps(ops, opts.Budget)
+	}
+
+	volumeIDs, err := backupVolumes(ops, opts.Labels)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &BackupJob{
+		ID:      uuid.New(),
+		volumes: make(map[string]*VolumeBackup, len(volumeIDs)),
+		done:    make(chan struct{}),
+	}
+	work := make(chan string, len(volumeIDs))
+	for _, id := range volumeIDs {
+		job.volumes[id] = &VolumeBackup{VolumeID: id, State: BackupPending}
+		work <- id
+	}
+	close(work)
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultBackupConcurrency
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency && i < len(volumeIDs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range work {
+				backupVolume(ops, job, id, opts)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(job.done)
+	}()
+	return job, nil
+}
+
+// backupVolumes returns the IDs of the attached volumes matching labels,
+// without the volume of the root device
+func backupVolumes(ops Ops, labels map[string]string) ([]string, error) {
+	mappings, err := ops.DeviceMappings()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(mappings))
+	var ids []string
+	for devicePath, id := range mappings {
+		if isRootDevice(devicePath) {
+			seen[id] = true
+		}
+	}
+	for _, id := range mappings {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if len(labels) > 0 {
+			tags, err := ops.Tags(id)
+			if err != nil {
+				return nil, err
+			}
+			if !labelsMatch(tags, labels) {
+				continue
+			}
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// isRootDevice returns true if / is mounted from the given device or one of
+// its partitions. Devices that can't be inspected are not the root device.
+func isRootDevice(devicePath string) bool {
+	usage, err := deviceUsage(devicePath)
+	if err != nil {
+		return false
+	}
+	for _, m := range usage.Mounts {
+		if m == "/" {
+			return true
+		}
+	}
+	return false
+}
+
+func labelsMatch(have, want map[string]string) bool {
+	for k, v := range want {
+		if have[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func backupVolume(ops Ops, job *BackupJob, volumeID string, opts *BackupOptions) {
+	job.update(volumeID, func(v *VolumeBackup) {
+		v.State = BackupRunning
+		v.Started = time.Now()
+	})
+
+	snapID, err := func() (string, error) {
+		snap, err := SnapshotWithLabels(ops, volumeID, true,
+			map[string]string{BackupSetLabel: job.ID})
+		if err != nil {
+			return "", err
+		}
+		snapID := snap.ID
+		job.update(volumeID, func(v *VolumeBackup) { v.SnapshotID = snapID })
+
+		if opts.WaitForCompletion {
+			timeout := opts.Timeout
+			if timeout == 0 {
+				timeout = ProviderOpsTimeout
+			}
+			if _, err := WaitForSnapshot(ops, snapID, timeout); err != nil {
+				return snapID, err
+			}
+		}
+		return snapID, nil
+	}()
+
+	job.update(volumeID, func(v *VolumeBackup) {
+		v.SnapshotID = snapID
+		v.Finished = time.Now()
+		if err != nil {
+			v.State = BackupFailed
+			v.Err = err
+		} else {
+			v.State = BackupCompleted
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package storageops
+
+import "sync"
+
+// DefaultBatchParallelism is the default number of volumes of a batch
+// attach or detach that are handled at a time
+const DefaultBatchParallelism = 4
+
+// AttachRequest is a volume to attach in a batch
+type AttachRequest struct {
+	// VolumeID of the volume to attach
+	VolumeID string
+	// Options are the attach options of the volume, see Attach
+	Options map[string]string
+}
+
+// AttachResult is the outcome of attaching a volume of a batch
+type AttachResult struct {
+	// VolumeID of the attached volume
+	VolumeID string
+	// DevicePath the volume is attached at
+	DevicePath string
+	// Err is the error attaching the volume, nil if it was attached
+	Err error
+}
+
+// DetachResult is the outcome of detaching a volume of a batch
+type DetachResult struct {
+	// VolumeID of the detached volume
+	VolumeID string
+	// Err is the error detaching the volume, nil if it was detached
+	Err error
+}
+
+// BatchAttacher is implemented by drivers that attach and detach several
+// volumes more efficiently than one at a time, e.g. by looking up the free
+// devices of the instance once for the whole batch
+type BatchAttacher interface {
+	// AttachBatch attaches the requested volumes to this instance, at most
+	// parallelism at a time, and returns a result for each in request order
+	AttachBatch(reqs []*AttachRequest, parallelism int) []*AttachResult
+	// DetachBatch detaches the given volumes from this instance, at most
+	// parallelism at a time, and returns a result for each in order
+	DetachBatch(volumeIDs []string, parallelism int) []*DetachResult
+}
+
+// AttachBatch attaches the requested volumes using the driver's
+// BatchAttacher if it has one, or else by calling Attach for each volume at
+// most parallelism at a time. A parallelism of zero or less defaults to
+// DefaultBatchParallelism. Wrappers like middlewares hide the BatchAttacher
+// of the driver they wrap, so pass the driver itself to batch the calls.
+func AttachBatch(ops Ops, reqs []*AttachRequest, parallelism int) []*AttachResult {
+	if b, ok := ops.(BatchAttacher); ok {
+		return b.AttachBatch(reqs, parallelism)
+	}
+	results := make([]*AttachResult, len(reqs))
+	ForEachParallel(len(reqs), parallelism, func(i int) {
+		devicePath, err := ops.Attach(reqs[i].VolumeID, reqs[i].Options)
+		results[i] = &AttachResult{
+			VolumeID:   reqs[i].VolumeID,
+			DevicePath: devicePath,
+			Err:        err,
+		}
+	})
+	return results
+}
+
+// DetachBatch detaches the given volumes using the driver's BatchAttacher if
+// it has one, or else by calling Detach for each volume, see AttachBatch
+func DetachBatch(ops Ops, volumeIDs []string, parallelism int) []*DetachResult {
+	if b, ok := ops.(BatchAttacher); ok {
+		return b.DetachBatch(volumeIDs, parallelism)
+	}
+	results := make([]*DetachResult, len(volumeIDs))
+	ForEachParallel(len(volumeIDs), parallelism, func(i int) {
+		results[i] = &DetachResult{
+			VolumeID: volumeIDs[i],
+			Err:      ops.Detach(volumeIDs[i]),
+		}
+	})
+	return results
+}
+
+// ForEachParallel calls f for each index below n, at most parallelism calls
+// at a time, and returns once all calls returned. A parallelism of zero or
+// less defaults to DefaultBatchParallelism.
+func ForEachParallel(n, parallelism int, f func(i int)) {
+	if parallelism <= 0 {
+		parallelism = DefaultBatchParallelism
+	}
+	work := make(chan int, n)
+	for i := 0; i < n; i++ {
+		work <- i
+	}
+	close(work)
+
+	var wg sync.WaitGroup
+	for w := 0; w < parallelism && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				f(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
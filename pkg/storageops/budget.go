@@ -0,0 +1,315 @@
+package storageops
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BudgetStats are the counters of an operation budget
+type BudgetStats struct {
+	// Cycles is the number of started cycles
+	Cycles uint64
+	// Calls is the total number of calls charged to the budget
+	Calls uint64
+	// Rejected is the total number of calls rejected for exceeding the budget
+	Rejected uint64
+	// ExhaustedCycles is the number of cycles that ran out of budget
+	ExhaustedCycles uint64
+	// CarryOver is the number of calls carried into the current cycle. It is
+	// negative if the previous cycle overspent.
+	CarryOver int
+	// Remaining is the number of calls left in the current cycle
+	Remaining int
+}
+
+// Budget bounds the calls and wall time a background subsystem such as a
+// garbage collector or reconciler may use per cycle, see StartCycle. Unused
+// calls carry over to the next cycle up to one cycle's worth, and calls
+// forced past the budget are deducted from the next cycle.
+type Budget struct {
+	sync.Mutex
+	calls     int
+	wallTime  time.Duration
+	start     time.Time
+	remaining int
+	exhausted bool
+	stats     BudgetStats
+}
+
+// NewBudget creates a budget of the given calls and wall time per cycle. A
+// zero value disables the respective limit.
+func NewBudget(calls int, wallTime time.Duration) *Budget {
+	b := &Budget{
+		calls:    calls,
+		wallTime: wallTime,
+	}
+	b.remaining = calls
+	b.start = time.Now()
+	return b
+}
+
+// StartCycle starts a new cycle, carrying over the unused or overspent calls
+// of the previous one
+func (b *Budget) StartCycle() {
+	b.Lock()
+	defer b.Unlock()
+
+	carry := 0
+	if b.stats.Cycles > 0 {
+		carry = b.remaining
+		if carry > b.calls {
+			carry = b.calls
+		}
+	}
+	b.stats.Cycles++
+	b.stats.CarryOver = carry
+	b.remaining = b.calls + carry
+	b.exhausted = false
+	b.start = time.Now()
+}
+
+// Allow charges one call to the budget and returns false if the cycle is out
+// of calls or wall time
+func (b *Budget) Allow() bool {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.calls > 0 && b.remaining <= 0 ||
+		b.wallTime > 0 && time.Since(b.start) > b.wallTime {
+		b.stats.Rejected++
+		if !b.exhausted {
+			b.exhausted = true
+			b.stats.ExhaustedCycles++
+		}
+		return false
+	}
+	b.remaining--
+	b.stats.Calls++
+	return true
+}
+
+// Charge charges calls that were made regardless of the budget, e.g. by a
+// paginated call that cannot be interrupted
+func (b *Budget) Charge(calls int) {
+	b.Lock()
+	defer b.Unlock()
+	b.remaining -= calls
+	b.stats.Calls += uint64(calls)
+}
+
+// Exhausted returns true if the current cycle is out of calls or wall time
+func (b *Budget) Exhausted() bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.calls > 0 && b.remaining <= 0 ||
+		b.wallTime > 0 && time.Since(b.start) > b.wallTime
+}
+
+// Stats returns the counters of the budget
+func (b *Budget) Stats() BudgetStats {
+	b.Lock()
+	defer b.Unlock()
+	stats := b.stats
+	stats.Remaining = b.remaining
+	return stats
+}
+
+type budgetOps struct {
+	Ops
+	budget *Budget
+}
+
+// NewBudgetedOps returns Ops that charge one call of the current cycle of the
+// given budget per operation, however many provider API calls the driver
+// makes for it, and fail with ErrBudgetExhausted once the cycle is out of
+// budget
+func NewBudgetedOps(ops Ops, budget *Budget) Ops {
+	return &budgetOps{
+		Ops:    ops,
+		budget: budget,
+	}
+}
+
+func (o *budgetOps) allow(op string) error {
+	if o.budget.Allow() {
+		return nil
+	}
+	return NewStorageError(ErrBudgetExhausted,
+		fmt.Sprintf("operation budget of this cycle is exhausted, skipped %s", op), "")
+}
+
+func (o *budgetOps) GetZone() (string, error) {
+	if err := o.allow("get zone"); err != nil {
+		return "", err
+	}
+	return o.Ops.GetZone()
+}
+
+func (o *budgetOps) GetRegion() (string, error) {
+	if err := o.allow("get region"); err != nil {
+		return "", err
+	}
+	return o.Ops.GetRegion()
+}
+
+func (o *budgetOps) ListZones() ([]string, error) {
+	if err := o.allow("list zones"); err != nil {
+		return nil, err
+	}
+	return o.Ops.ListZones()
+}
+
+func (o *budgetOps) Create(template interface{}, labels map[string]string) (*Volume, error) {
+	if err := o.allow("create"); err != nil {
+		return nil, err
+	}
+	return o.Ops.Create(template, labels)
+}
+
+func (o *budgetOps) Attach(volumeID string, options map[string]string) (string, error) {
+	if err := o.allow("attach"); err != nil {
+		return "", err
+	}
+	return o.Ops.Attach(volumeID, options)
+}
+
+func (o *budgetOps) Expand(volumeID string, newSizeGiB uint64) (uint64, error) {
+	if err := o.allow("expand"); err != nil {
+		return 0, err
+	}
+	return o.Ops.Expand(volumeID, newSizeGiB)
+}
+
+func (o *budgetOps) Modify(volumeID string, spec VolumeSpecUpdate) error {
+	if err := o.allow("modify"); err != nil {
+		return err
+	}
+	return o.Ops.Modify(volumeID, spec)
+}
+
+func (o *budgetOps) Detach(volumeID string) error {
+	if err := o.allow("detach"); err != nil {
+		return err
+	}
+	return o.Ops.Detach(volumeID)
+}
+
+func (o *budgetOps) DetachFrom(volumeID, instanceID string) error {
+	if err := o.allow("detach"); err != nil {
+		return err
+	}
+	return o.Ops.DetachFrom(volumeID, instanceID)
+}
+
+func (o *budgetOps) Delete(volumeID string) error {
+	if err := o.allow("delete"); err != nil {
+		return err
+	}
+	return o.Ops.Delete(volumeID)
+}
+
+func (o *budgetOps) DeleteFrom(volumeID, instanceID string) error {
+	if err := o.allow("delete"); err != nil {
+		return err
+	}
+	return o.Ops.DeleteFrom(volumeID, instanceID)
+}
+
+func (o *budgetOps) Describe() (interface{}, error) {
+	if err := o.allow("describe"); err != nil {
+		return nil, err
+	}
+	return o.Ops.Describe()
+}
+
+func (o *budgetOps) Inspect(volumeIds []*string) ([]*Volume, error) {
+	if err := o.allow("inspect"); err != nil {
+		return nil, err
+	}
+	return o.Ops.Inspect(volumeIds)
+}
+
+func (o *budgetOps) DeviceMappings() (map[string]string, error) {
+	if err := o.allow("device mappings"); err != nil {
+		return nil, err
+	}
+	return o.Ops.DeviceMappings()
+}
+
+func (o *budgetOps) Enumerate(
+	volumeIds []*string,
+	labels map[string]string,
+	setIdentifier string,
+) (map[string][]*Volume, error) {
+	if err := o.allow("enumerate"); err != nil {
+		return nil, err
+	}
+	return o.Ops.Enumerate(volumeIds, labels, setIdentifier)
+}
+
+func (o *budgetOps) DevicePath(volumeID string) (string, error) {
+	if err := o.allow("device path"); err != nil {
+		return "", err
+	}
+	return o.Ops.DevicePath(volumeID)
+}
+
+func (o *budgetOps) Snapshot(volumeID string, readonly bool) (*Snapshot, error) {
+	if err := o.allow("snapshot"); err != nil {
+		return nil, err
+	}
+	return o.Ops.Snapshot(volumeID, readonly)
+}
+
+func (o *budgetOps) SnapshotDelete(snapID string) error {
+	if err := o.allow("snapshot delete"); err != nil {
+		return err
+	}
+	return o.Ops.SnapshotDelete(snapID)
+}
+
+func (o *budgetOps) SnapshotRestore(
+	snapID, zone string,
+	labels map[string]string,
+) (*Volume, error) {
+	if err := o.allow("snapshot restore"); err != nil {
+		return nil, err
+	}
+	return o.Ops.SnapshotRestore(snapID, zone, labels)
+}
+
+func (o *budgetOps) SnapshotStatus(snapID string) (*SnapshotStatus, error) {
+	if err := o.allow("snapshot status"); err != nil {
+		return nil, err
+	}
+	return o.Ops.SnapshotStatus(snapID)
+}
+
+func (o *budgetOps) ApplyTags(volumeID string, labels map[string]string) error {
+	if err := o.allow("apply tags"); err != nil {
+		return err
+	}
+	return o.Ops.ApplyTags(volumeID, labels)
+}
+
+func (o *budgetOps) RemoveTags(volumeID string, labels map[string]string) error {
+	if err := o.allow("remove tags"); err != nil {
+		return err
+	}
+	return o.Ops.RemoveTags(volumeID, labels)
+}
+
+func (o *budgetOps) Tags(volumeID string) (map[string]string, error) {
+	if err := o.allow("tags"); err != nil {
+		return nil, err
+	}
+	return o.Ops.Tags(volumeID)
+}
+
+func (o *budgetOps) SnapshotEnumerate(filter *SnapshotFilter) ([]*Snapshot, error) {
+	if err := o.allow("snapshot enumerate"); err != nil {
+		return nil, err
+	}
+	return o.Ops.SnapshotEnumerate(filter)
+}
@@ -0,0 +1,279 @@
+package storageops
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/portworx/kvdb"
+)
+
+// bundleVersion is the version of the encoding of configuration bundles
+const bundleVersion = 1
+
+// BundleSection is a part of the persisted configuration exported in
+// configuration bundles, i.e. all kvdb keys under its prefix
+type BundleSection struct {
+	// Name of the section in bundles
+	Name string
+	// Prefix of the kvdb keys of the section
+	Prefix string
+	// RemapVolumes returns the key and value of the given key, relative to
+	// the prefix, and value with the volume IDs replaced by the given ones,
+	// see ImportOptions.VolumeIDs. Nil if the section has no volume IDs.
+	RemapVolumes func(key, value string, volumeIDs map[string]string) (string, string)
+}
+
+var (
+	bundleSectionsLock sync.Mutex
+	// bundleSections are the sections of the managed drive configuration.
+	// Drive records, snapshot catalogs and restore requests are state of the
+	// volumes of a cluster rather than configuration and are never exported.
+	bundleSections = map[string]*BundleSection{
+		"specs": {
+			Name:   "specs",
+			Prefix: driftKeyPrefix,
+			// keys are <provider>/<volume ID>
+			RemapVolumes: func(key, value string, volumeIDs map[string]string) (string, string) {
+				if i := strings.LastIndex(key, "/"); i >= 0 {
+					if id, ok := volumeIDs[key[i+1:]]; ok {
+						key = key[:i+1] + id
+					}
+				}
+				return key, value
+			},
+		},
+		"aliases": {
+			Name:   "aliases",
+			Prefix: aliasKeyPrefix,
+			// values are volume IDs
+			RemapVolumes: func(key, value string, volumeIDs map[string]string) (string, string) {
+				if id, ok := volumeIDs[value]; ok {
+					value = id
+				}
+				return key, value
+			},
+		},
+	}
+)
+
+// RegisterBundleSection adds a section to the configuration bundles, e.g.
+// the placement policies or drive profiles an application keeps in kvdb
+func RegisterBundleSection(section *BundleSection) {
+	bundleSectionsLock.Lock()
+	defer bundleSectionsLock.Unlock()
+	bundleSections[section.Name] = section
+}
+
+func getBundleSection(name string) (*BundleSection, bool) {
+	bundleSectionsLock.Lock()
+	defer bundleSectionsLock.Unlock()
+	section, ok := bundleSections[name]
+	return section, ok
+}
+
+func bundleSectionNames() []string {
+	bundleSectionsLock.Lock()
+	defer bundleSectionsLock.Unlock()
+	names := make([]string, 0, len(bundleSections))
+	for name := range bundleSections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ConfigBundle is an export of the managed drive configuration of a cluster,
+// e.g. the desired volume specs with their labels and the volume aliases,
+// without any data. Bundles are signed so that a rebuilt cluster or a DR
+// environment is only stamped from a known-good configuration.
+type ConfigBundle struct {
+	// Version of the encoding of the bundle
+	Version int `json:"version"`
+	// Created is when the bundle was exported
+	Created time.Time `json:"created"`
+	// Schema is the version of the StateSchema of the exported state
+	Schema int `json:"schema"`
+	// Sections are the values of the exported kvdb keys by section, keyed by
+	// the key relative to the prefix of the section
+	Sections map[string]map[string]string `json:"sections"`
+	// Signature is the hex HMAC-SHA256 of the bundle without signature
+	Signature string `json:"signature"`
+}
+
+// mac returns the HMAC of the bundle without signature with the given key
+func (b *ConfigBundle) mac(key []byte) ([]byte, error) {
+	unsigned := *b
+	unsigned.Signature = ""
+	// Maps are encoded with sorted keys, so the encoding is canonical
+	data, err := json.Marshal(&unsigned)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+// Verify returns an ErrPermissionDenied error if the bundle was not signed
+// with the given key or was modified since
+func (b *ConfigBundle) Verify(key []byte) error {
+	if len(key) == 0 {
+		return NewStorageError(ErrVolInval, "a signing key is required", "")
+	}
+	expected, err := b.mac(key)
+	if err != nil {
+		return err
+	}
+	signature, err := hex.DecodeString(b.Signature)
+	if err != nil || !hmac.Equal(signature, expected) {
+		return NewStorageError(ErrPermissionDenied,
+			"configuration bundle signature does not match, it was modified or signed with another key", "")
+	}
+	return nil
+}
+
+// ExportConfig exports the sections of the managed drive configuration, all
+// registered sections if none are given, as a bundle signed with the given
+// key
+func ExportConfig(kv kvdb.Kvdb, key []byte, sections ...string) (*ConfigBundle, error) {
+	if len(key) == 0 {
+		return nil, NewStorageError(ErrVolInval, "a signing key is required", "")
+	}
+	m, err := NewMigrator(kv, StateSchema, StateMigrations)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := m.Version()
+	if err != nil {
+		return nil, err
+	}
+	if len(sections) == 0 {
+		sections = bundleSectionNames()
+	}
+
+	b := &ConfigBundle{
+		Version:  bundleVersion,
+		Created:  time.Now().UTC(),
+		Schema:   schema,
+		Sections: make(map[string]map[string]string, len(sections)),
+	}
+	for _, name := range sections {
+		section, ok := getBundleSection(name)
+		if !ok {
+			return nil, NewStorageError(ErrVolInval,
+				fmt.Sprintf("unknown configuration section %q", name), "")
+		}
+		kvps, err := kv.Enumerate(section.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		values := make(map[string]string, len(kvps))
+		for _, kvp := range kvps {
+			values[strings.TrimPrefix(kvp.Key, section.Prefix)] = string(kvp.Value)
+		}
+		b.Sections[name] = values
+	}
+	mac, err := b.mac(key)
+	if err != nil {
+		return nil, err
+	}
+	b.Signature = hex.EncodeToString(mac)
+	return b, nil
+}
+
+// ImportOptions configure ImportConfig
+type ImportOptions struct {
+	// Overwrite replaces existing keys, which are skipped otherwise
+	Overwrite bool
+	// VolumeIDs maps the volume IDs of the exported cluster to the ones of
+	// the importing cluster, e.g. the volumes restored from snapshots in
+	// a DR environment. Volumes not in the map keep their IDs.
+	VolumeIDs map[string]string
+}
+
+// ImportReport lists the kvdb keys written and skipped by ImportConfig
+type ImportReport struct {
+	// Imported are the keys that were written
+	Imported []string
+	// Skipped are the keys that already existed and were not overwritten
+	Skipped []string
+}
+
+// ImportConfig verifies the signature of the given bundle with the given
+// key and writes its configuration. The state of the importing cluster must
+// be at the schema version of the bundle, see MigrateState, as bundles of
+// other versions are not migrated.
+func ImportConfig(
+	kv kvdb.Kvdb,
+	b *ConfigBundle,
+	key []byte,
+	opts *ImportOptions,
+) (*ImportReport, error) {
+	if err := b.Verify(key); err != nil {
+		return nil, err
+	}
+	if b.Version != bundleVersion {
+		return nil, NewStorageError(ErrVolInval,
+			fmt.Sprintf("unsupported configuration bundle version %d", b.Version), "")
+	}
+	m, err := NewMigrator(kv, StateSchema, StateMigrations)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := m.Version()
+	if err != nil {
+		return nil, err
+	}
+	if schema != b.Schema {
+		return nil, NewStorageError(ErrVolInval,
+			fmt.Sprintf("configuration bundle is at schema version %d, the state is at %d",
+				b.Schema, schema), "")
+	}
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+
+	report := &ImportReport{}
+	names := make([]string, 0, len(b.Sections))
+	for name := range b.Sections {
+		if _, ok := getBundleSection(name); !ok {
+			return nil, NewStorageError(ErrVolInval,
+				fmt.Sprintf("unknown configuration section %q", name), "")
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		section, _ := getBundleSection(name)
+		keys := make([]string, 0, len(b.Sections[name]))
+		for k := range b.Sections[name] {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			value := b.Sections[name][k]
+			if section.RemapVolumes != nil && len(opts.VolumeIDs) > 0 {
+				k, value = section.RemapVolumes(k, value, opts.VolumeIDs)
+			}
+			fullKey := section.Prefix + k
+			if opts.Overwrite {
+				_, err = kv.Put(fullKey, value, 0)
+			} else if _, err = kv.Create(fullKey, value, 0); err == kvdb.ErrExist {
+				report.Skipped = append(report.Skipped, fullKey)
+				continue
+			}
+			if err != nil {
+				return report, fmt.Errorf("failed to import %s: %v", fullKey, err)
+			}
+			report.Imported = append(report.Imported, fullKey)
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,379 @@
+package storageops
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/portworx/kvdb"
+	"github.com/sirupsen/logrus"
+)
+
+// catalogKeyPrefix is the kvdb prefix of the backup catalogs
+const catalogKeyPrefix = "storageops/catalog/"
+
+// CatalogEntry is the write-once record of a snapshot in the backup catalog.
+// Entries are hash chained in the order they were recorded, so removing or
+// changing one is detected by Verify.
+type CatalogEntry struct {
+	// Seq is the position of the entry in the catalog, starting at 1
+	Seq uint64 `json:"seq"`
+	// SnapshotID of the recorded snapshot
+	SnapshotID string `json:"snapshotId"`
+	// VolumeID of the volume the snapshot was taken from
+	VolumeID string `json:"volumeId"`
+	// Lineage are the volumes VolumeID was restored from, oldest first
+	Lineage []string `json:"lineage,omitempty"`
+	// Labels of the snapshot
+	Labels map[string]string `json:"labels,omitempty"`
+	// Created is when the entry was recorded
+	Created time.Time `json:"created"`
+	// RetainUntil is until when the snapshot may not be deleted
+	RetainUntil time.Time `json:"retainUntil"`
+	// PrevHash is the hash of the previous entry, empty for the first one
+	PrevHash string `json:"prevHash,omitempty"`
+	// Hash of the entry and PrevHash
+	Hash string `json:"hash"`
+}
+
+// catalogHead is the last entry of a catalog
+type catalogHead struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// CatalogQuery selects catalog entries. Empty fields match all entries.
+type CatalogQuery struct {
+	// VolumeID matches the snapshots of the volume and of the volumes it
+	// was restored from
+	VolumeID string
+	// Labels must all be present on the snapshot
+	Labels map[string]string
+	// CreatedAfter matches snapshots recorded at or after the time
+	CreatedAfter time.Time
+	// CreatedBefore matches snapshots recorded before the time
+	CreatedBefore time.Time
+}
+
+// Catalog is the backup catalog of a driver. It records snapshots with a
+// retention and their volume lineage in kvdb, and refuses to delete
+// snapshots within their retention when used through NewCatalogOps.
+type Catalog struct {
+	kv       kvdb.Kvdb
+	provider string
+}
+
+// NewCatalog returns the backup catalog of the driver with the given name
+func NewCatalog(kv kvdb.Kvdb, provider string) *Catalog {
+	return &Catalog{kv: kv, provider: provider}
+}
+
+func (c *Catalog) prefix() string {
+	return catalogKeyPrefix + c.provider + "/"
+}
+
+func (c *Catalog) entryKey(snapID string) string {
+	return c.prefix() + "entries/" + snapID
+}
+
+func (c *Catalog) lineageKey(volumeID string) string {
+	return c.prefix() + "lineage/" + volumeID
+}
+
+// hash returns the hash of the entry, which covers every field but Hash
+func (e *CatalogEntry) hash() (string, error) {
+	copied := *e
+	copied.Hash = ""
+	data, err := json.Marshal(&copied)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Record adds the given snapshot of the given volume to the catalog, to be
+// retained for the given duration. A snapshot can only be recorded once.
+func (c *Catalog) Record(
+	snapID, volumeID string,
+	labels map[string]string,
+	retention time.Duration,
+) (*CatalogEntry, error) {
+	lineage, err := c.Lineage(volumeID)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := c.kv.Lock(c.prefix() + "lock")
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock catalog: %v", err)
+	}
+	defer func() {
+		if err := c.kv.Unlock(lock); err != nil {
+			logrus.Warnf("failed to unlock catalog: %v", err)
+		}
+	}()
+
+	head, err := c.head()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	entry := &CatalogEntry{
+		Seq:         head.Seq + 1,
+		SnapshotID:  snapID,
+		VolumeID:    volumeID,
+		Lineage:     lineage,
+		Labels:      labels,
+		Created:     now,
+		RetainUntil: now.Add(retention),
+		PrevHash:    head.Hash,
+	}
+	if entry.Hash, err = entry.hash(); err != nil {
+		return nil, err
+	}
+
+	if _, err := c.kv.Create(c.entryKey(snapID), entry, 0); err == kvdb.ErrExist {
+		return nil, NewStorageError(ErrVolInval,
+			fmt.Sprintf("snapshot %s is already recorded in the catalog", snapID), "")
+	} else if err != nil {
+		return nil, err
+	}
+	if _, err := c.kv.Put(c.prefix()+"head", &catalogHead{Seq: entry.Seq, Hash: entry.Hash}, 0); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (c *Catalog) head() (*catalogHead, error) {
+	head := &catalogHead{}
+	kvp, err := c.kv.Get(c.prefix() + "head")
+	if err == kvdb.ErrNotFound {
+		return head, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(kvp.Value, head); err != nil {
+		return nil, fmt.Errorf("invalid catalog head: %v", err)
+	}
+	return head, nil
+}
+
+// RecordRestore records that the given volume was restored from the given
+// snapshot, so its snapshots are part of the lineage of the snapshot's volume
+func (c *Catalog) RecordRestore(snapID, volumeID string) error {
+	entry, err := c.Get(snapID)
+	if err != nil {
+		return err
+	}
+	lineage := append(append([]string(nil), entry.Lineage...), entry.VolumeID)
+	if _, err := c.kv.Create(c.lineageKey(volumeID), lineage, 0); err != nil && err != kvdb.ErrExist {
+		return err
+	}
+	return nil
+}
+
+// Lineage returns the volumes the given volume was restored from, oldest
+// first
+func (c *Catalog) Lineage(volumeID string) ([]string, error) {
+	kvp, err := c.kv.Get(c.lineageKey(volumeID))
+	if err == kvdb.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var lineage []string
+	if err := json.Unmarshal(kvp.Value, &lineage); err != nil {
+		return nil, fmt.Errorf("invalid lineage of volume %s: %v", volumeID, err)
+	}
+	return lineage, nil
+}
+
+// Get returns the catalog entry of the given snapshot
+func (c *Catalog) Get(snapID string) (*CatalogEntry, error) {
+	kvp, err := c.kv.Get(c.entryKey(snapID))
+	if err == kvdb.ErrNotFound {
+		return nil, NewStorageError(ErrVolNotFound,
+			fmt.Sprintf("snapshot %s not found in the catalog", snapID), "")
+	} else if err != nil {
+		return nil, err
+	}
+	entry := &CatalogEntry{}
+	if err := json.Unmarshal(kvp.Value, entry); err != nil {
+		return nil, fmt.Errorf("invalid catalog entry of snapshot %s: %v", snapID, err)
+	}
+	return entry, nil
+}
+
+// entries returns all entries of the catalog in the order they were recorded
+func (c *Catalog) entries() ([]*CatalogEntry, error) {
+	kvps, err := c.kv.Enumerate(c.prefix() + "entries/")
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*CatalogEntry, 0, len(kvps))
+	for _, kvp := range kvps {
+		entry := &CatalogEntry{}
+		if err := json.Unmarshal(kvp.Value, entry); err != nil {
+			return nil, fmt.Errorf("invalid catalog entry %s: %v", kvp.Key, err)
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Seq < entries[j].Seq })
+	return entries, nil
+}
+
+// Query returns the entries matching the given query in the order they were
+// recorded
+func (c *Catalog) Query(q *CatalogQuery) ([]*CatalogEntry, error) {
+	entries, err := c.entries()
+	if err != nil {
+		return nil, err
+	}
+	var matched []*CatalogEntry
+	for _, e := range entries {
+		if q.match(e) {
+			matched = append(matched, e)
+		}
+	}
+	return matched, nil
+}
+
+func (q *CatalogQuery) match(e *CatalogEntry) bool {
+	if q == nil {
+		return true
+	}
+	if len(q.VolumeID) > 0 && e.VolumeID != q.VolumeID {
+		found := false
+		for _, id := range e.Lineage {
+			found = found || id == q.VolumeID
+		}
+		if !found {
+			return false
+		}
+	}
+	if !q.CreatedAfter.IsZero() && e.Created.Before(q.CreatedAfter) {
+		return false
+	}
+	if !q.CreatedBefore.IsZero() && !e.Created.Before(q.CreatedBefore) {
+		return false
+	}
+	for k, v := range q.Labels {
+		if e.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Verify checks that no entry of the catalog was removed or changed since it
+// was recorded
+func (c *Catalog) Verify() error {
+	head, err := c.head()
+	if err != nil {
+		return err
+	}
+	entries, err := c.entries()
+	if err != nil {
+		return err
+	}
+
+	prev := ""
+	for i, e := range entries {
+		if e.Seq != uint64(i+1) {
+			return fmt.Errorf("catalog entry %d is missing", i+1)
+		}
+		hash, err := e.hash()
+		if err != nil {
+			return err
+		}
+		if hash != e.Hash || e.PrevHash != prev {
+			return fmt.Errorf("catalog entry %d of snapshot %s was modified", e.Seq, e.SnapshotID)
+		}
+		prev = e.Hash
+	}
+	if head.Seq != uint64(len(entries)) || head.Hash != prev {
+		return fmt.Errorf("catalog has %d entries, expected %d", len(entries), head.Seq)
+	}
+	return nil
+}
+
+// CheckDelete returns an ErrRetentionLocked error if the given snapshot is
+// within its retention
+func (c *Catalog) CheckDelete(snapID string) error {
+	entry, err := c.Get(snapID)
+	if IsErrorCode(err, ErrVolNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if time.Now().Before(entry.RetainUntil) {
+		return NewStorageError(ErrRetentionLocked,
+			fmt.Sprintf("snapshot %s is retained until %s", snapID,
+				entry.RetainUntil.Format(time.RFC3339)), "")
+	}
+	return nil
+}
+
+type catalogOps struct {
+	Ops
+	catalog   *Catalog
+	retention time.Duration
+}
+
+// NewCatalogOps returns Ops that record every snapshot in the given catalog
+// with the given retention, record the lineage of restored volumes and
+// refuse to delete snapshots within their retention
+func NewCatalogOps(ops Ops, catalog *Catalog, retention time.Duration) Ops {
+	return &catalogOps{
+		Ops:       ops,
+		catalog:   catalog,
+		retention: retention,
+	}
+}
+
+// CatalogMiddleware is NewCatalogOps as a middleware
+func CatalogMiddleware(catalog *Catalog, retention time.Duration) Middleware {
+	return func(ops Ops) Ops { return NewCatalogOps(ops, catalog, retention) }
+}
+
+func (o *catalogOps) Snapshot(volumeID string, readonly bool) (*Snapshot, error) {
+	snap, err := o.Ops.Snapshot(volumeID, readonly)
+	if err != nil {
+		return nil, err
+	}
+	snapID := snap.ID
+	labels, err := o.Ops.Tags(snapID)
+	if err != nil && err != ErrNotSupported {
+		logrus.Warnf("failed to get the labels of snapshot %s for the catalog: %v", snapID, err)
+	}
+	if _, err := o.catalog.Record(snapID, volumeID, labels, o.retention); err != nil {
+		return nil, fmt.Errorf("failed to record snapshot %s in the catalog: %v", snapID, err)
+	}
+	return snap, nil
+}
+
+func (o *catalogOps) SnapshotRestore(
+	snapID, zone string,
+	labels map[string]string,
+) (*Volume, error) {
+	vol, err := o.Ops.SnapshotRestore(snapID, zone, labels)
+	if err != nil {
+		return nil, err
+	}
+	volumeID := vol.ID
+	if err := o.catalog.RecordRestore(snapID, volumeID); err != nil && !IsErrorCode(err, ErrVolNotFound) {
+		return nil, fmt.Errorf("failed to record lineage of volume %s: %v", volumeID, err)
+	}
+	return vol, nil
+}
+
+func (o *catalogOps) SnapshotDelete(snapID string) error {
+	if err := o.catalog.CheckDelete(snapID); err != nil {
+		return err
+	}
+	return o.Ops.SnapshotDelete(snapID)
+}
@@ -0,0 +1,243 @@
+package storageops
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// bootIDPath is where the kernel exposes the ID of the current boot
+const bootIDPath = "/proc/sys/kernel/random/boot_id"
+
+// CheckpointVolume is a volume expected to be attached after a reboot
+type CheckpointVolume struct {
+	// VolumeID of the attached volume
+	VolumeID string `json:"volumeId"`
+	// DevicePath the volume was attached at
+	DevicePath string `json:"devicePath"`
+	// Mounts are the mount points of the device and its partitions
+	Mounts []string `json:"mounts,omitempty"`
+}
+
+// AttachCheckpoint is the attach state of the instance before a reboot
+type AttachCheckpoint struct {
+	// Provider is the name of the storage operations driver
+	Provider string `json:"provider"`
+	// BootID is the boot the checkpoint was written in
+	BootID string `json:"bootId"`
+	// Time the checkpoint was written
+	Time time.Time `json:"time"`
+	// Volumes are the attached volumes
+	Volumes []*CheckpointVolume `json:"volumes"`
+}
+
+// VolumeRecoveryStatus is the outcome of recovering a volume after a reboot
+type VolumeRecoveryStatus string
+
+const (
+	// VolumeRecovered is the status of a volume that is attached and mounted
+	// as before the reboot
+	VolumeRecovered VolumeRecoveryStatus = "recovered"
+	// VolumeMoved is the status of a volume attached at another device path
+	// than before the reboot, e.g. after NVMe enumeration changed
+	VolumeMoved VolumeRecoveryStatus = "moved"
+	// VolumeUnmounted is the status of an attached volume missing some of its
+	// mounts
+	VolumeUnmounted VolumeRecoveryStatus = "unmounted"
+	// VolumeMissing is the status of a volume no longer attached
+	VolumeMissing VolumeRecoveryStatus = "missing"
+)
+
+// VolumeRecovery is the recovery report of a single volume
+type VolumeRecovery struct {
+	// VolumeID of the volume
+	VolumeID string `json:"volumeId"`
+	// Status of the volume
+	Status VolumeRecoveryStatus `json:"status"`
+	// ExpectedDevicePath is the device path before the reboot
+	ExpectedDevicePath string `json:"expectedDevicePath"`
+	// DevicePath is the re-resolved device path, empty if missing
+	DevicePath string `json:"devicePath,omitempty"`
+	// MissingMounts are the mount points not restored
+	MissingMounts []string `json:"missingMounts,omitempty"`
+	// Err is why the volume is missing
+	Err string `json:"err,omitempty"`
+}
+
+// RecoveryReport is the result of verifying an attach checkpoint after boot
+type RecoveryReport struct {
+	// Provider is the name of the storage operations driver
+	Provider string `json:"provider"`
+	// Rebooted is false if the checkpoint was verified in the boot it was
+	// written in, i.e. a test run without an actual reboot
+	Rebooted bool `json:"rebooted"`
+	// CheckpointTime is when the checkpoint was written
+	CheckpointTime time.Time `json:"checkpointTime"`
+	// Time of the verification
+	Time time.Time `json:"time"`
+	// Volumes are the reports of the volumes of the checkpoint
+	Volumes []*VolumeRecovery `json:"volumes"`
+}
+
+// Recovered returns true if every volume was recovered. A moved volume counts
+// as recovered as its mounts follow the device.
+func (r *RecoveryReport) Recovered() bool {
+	for _, v := range r.Volumes {
+		if v.Status != VolumeRecovered && v.Status != VolumeMoved {
+			return false
+		}
+	}
+	return true
+}
+
+func bootID() string {
+	id, err := ioutil.ReadFile(bootIDPath)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(id))
+}
+
+// devicePathMounts returns the mount points of the given device and its
+// partitions
+func devicePathMounts(devicePath string) ([]string, error) {
+	devs, err := deviceNumbers(devicePath)
+	if err != nil {
+		return nil, err
+	}
+	mounts, err := deviceMounts(devs)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(mounts)
+	return mounts, nil
+}
+
+// WriteAttachCheckpoint writes the volumes attached to this instance and
+// their mounts to the checkpoint file at path, to be verified with
+// VerifyAttachCheckpoint once the instance is back up. The file is replaced
+// atomically so a crash never leaves a partial checkpoint.
+func WriteAttachCheckpoint(ops Ops, path string) (*AttachCheckpoint, error) {
+	mappings, err := ops.DeviceMappings()
+	if err != nil {
+		return nil, err
+	}
+	cp := &AttachCheckpoint{
+		Provider: ops.Name(),
+		BootID:   bootID(),
+		Time:     time.Now().UTC(),
+	}
+	for devicePath, volumeID := range mappings {
+		mounts, err := devicePathMounts(devicePath)
+		if err != nil {
+			logrus.Warnf("failed to get mounts of %s for the checkpoint: %v", devicePath, err)
+		}
+		cp.Volumes = append(cp.Volumes, &CheckpointVolume{
+			VolumeID:   volumeID,
+			DevicePath: devicePath,
+			Mounts:     mounts,
+		})
+	}
+	sort.Slice(cp.Volumes, func(i, j int) bool { return cp.Volumes[i].VolumeID < cp.Volumes[j].VolumeID })
+
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// ReadAttachCheckpoint reads the checkpoint file at path
+func ReadAttachCheckpoint(path string) (*AttachCheckpoint, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cp := &AttachCheckpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("invalid attach checkpoint %s: %v", path, err)
+	}
+	return cp, nil
+}
+
+// VerifyAttachCheckpoint confirms that every volume of the checkpoint is
+// still attached to this instance, re-resolves its device path and checks
+// that its mounts were restored. It can be run without a reboot to test the
+// recovery, see RecoveryReport.Rebooted.
+func VerifyAttachCheckpoint(ops Ops, cp *AttachCheckpoint) *RecoveryReport {
+	report := &RecoveryReport{
+		Provider:       cp.Provider,
+		CheckpointTime: cp.Time,
+		Time:           time.Now().UTC(),
+	}
+	if id := bootID(); len(id) > 0 {
+		report.Rebooted = id != cp.BootID
+	}
+
+	for _, v := range cp.Volumes {
+		r := &VolumeRecovery{
+			VolumeID:           v.VolumeID,
+			ExpectedDevicePath: v.DevicePath,
+		}
+		report.Volumes = append(report.Volumes, r)
+
+		devicePath, err := ops.DevicePath(v.VolumeID)
+		if err != nil {
+			r.Status = VolumeMissing
+			r.Err = err.Error()
+			continue
+		}
+		r.DevicePath = devicePath
+		r.Status = VolumeRecovered
+		if devicePath != v.DevicePath {
+			r.Status = VolumeMoved
+		}
+		if len(v.Mounts) == 0 {
+			continue
+		}
+
+		mounted := make(map[string]bool)
+		mounts, err := devicePathMounts(devicePath)
+		if err != nil {
+			r.Err = err.Error()
+		}
+		for _, m := range mounts {
+			mounted[m] = true
+		}
+		for _, m := range v.Mounts {
+			if !mounted[m] {
+				r.MissingMounts = append(r.MissingMounts, m)
+			}
+		}
+		if len(r.MissingMounts) > 0 {
+			r.Status = VolumeUnmounted
+		}
+	}
+	return report
+}
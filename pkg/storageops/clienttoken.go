@@ -0,0 +1,71 @@
+package storageops
+
+import (
+	"fmt"
+)
+
+// ClientTokenLabel is the label carrying the client token of a volume, the
+// idempotency key of its Create chosen by the caller. It must be a valid
+// label value of the provider, e.g. a lowercase UUID. Drivers whose provider
+// supports idempotent creates also pass it to the provider, e.g. as the
+// ClientToken of EC2, so a Create retried after a timeout returns the volume
+// of the first attempt instead of creating another.
+const ClientTokenLabel = "openstorage-client-token"
+
+// ClientToken returns the client token of the given Create labels, empty if
+// they have none
+func ClientToken(labels map[string]string) string {
+	return labels[ClientTokenLabel]
+}
+
+// FindByClientToken returns the volume created with the given client token,
+// nil if there is none, e.g. to recover the volume of a creator that crashed
+// before it learned the ID of the volume. It returns an error if several
+// volumes carry the token.
+func FindByClientToken(ops Ops, token string) (*Volume, error) {
+	if len(token) == 0 {
+		return nil, NewStorageError(ErrVolInval, "client token is required", "")
+	}
+	sets, err := ops.Enumerate(nil, map[string]string{ClientTokenLabel: token}, "")
+	if err != nil {
+		return nil, err
+	}
+	var found []*Volume
+	for _, vols := range sets {
+		found = append(found, vols...)
+	}
+	switch len(found) {
+	case 0:
+		return nil, nil
+	case 1:
+		return found[0], nil
+	}
+	ids := make([]string, 0, len(found))
+	for _, vol := range found {
+		ids = append(ids, vol.ID)
+	}
+	return nil, NewStorageError(ErrVolInval,
+		fmt.Sprintf("volumes %v carry the same client token %s", ids, token), "")
+}
+
+// CreateIdempotent creates a volume like Create with the given client token,
+// unless a volume carrying the token exists, which is returned instead. A
+// caller retrying it with the same token after a failure, a timeout or a
+// crash never creates a second volume.
+func CreateIdempotent(
+	ops Ops,
+	template interface{},
+	labels map[string]string,
+	token string,
+) (*Volume, error) {
+	vol, err := FindByClientToken(ops, token)
+	if err != nil || vol != nil {
+		return vol, err
+	}
+	withToken := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		withToken[k] = v
+	}
+	withToken[ClientTokenLabel] = token
+	return ops.Create(template, withToken)
+}
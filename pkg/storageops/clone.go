@@ -0,0 +1,64 @@
+package storageops
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// CloneSourceLabel is the label carrying the ID of the volume a clone
+	// was made from
+	CloneSourceLabel = "openstorage-clone-source"
+	// ClonedAtLabel is the label carrying the RFC 3339 time a clone was made
+	ClonedAtLabel = "openstorage-cloned-at"
+	// DefaultCloneTimeout is how long Clone waits for the snapshot of the
+	// source volume to complete
+	DefaultCloneTimeout = 30 * time.Minute
+)
+
+// Clone copies the given volume to a new volume in the same zone, with the
+// given labels and the provenance labels CloneSourceLabel and ClonedAtLabel,
+// and returns the new volume once it is available. The volume is copied
+// through a temporary snapshot, which is deleted once the clone is created
+// or failed. The clone has the contents of the volume at the time of the
+// call, writes to the source volume afterwards are not copied.
+func Clone(ops Ops, volumeID string, labels map[string]string) (*Volume, error) {
+	vols, err := ops.Inspect([]*string{&volumeID})
+	if err != nil {
+		return nil, err
+	}
+	if len(vols) != 1 {
+		return nil, NewStorageError(ErrVolNotFound,
+			fmt.Sprintf("volume %s not found", volumeID), ops.InstanceID())
+	}
+	source := vols[0]
+
+	snap, err := ops.Snapshot(volumeID, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot volume %s to clone it: %v", volumeID, err)
+	}
+	snapID := snap.ID
+	defer func() {
+		if err := ops.SnapshotDelete(snapID); err != nil {
+			logrus.Warnf("Failed to delete snapshot %s of clone of volume %s: %v",
+				snapID, volumeID, err)
+		}
+	}()
+	if _, err := WaitForSnapshot(ops, snapID, DefaultCloneTimeout); err != nil {
+		return nil, fmt.Errorf("failed to clone volume %s: %v", volumeID, err)
+	}
+
+	cloneLabels := make(map[string]string, len(labels)+2)
+	for k, v := range labels {
+		cloneLabels[k] = v
+	}
+	cloneLabels[CloneSourceLabel] = volumeID
+	cloneLabels[ClonedAtLabel] = time.Now().UTC().Format(time.RFC3339)
+	clone, err := ops.SnapshotRestore(snapID, source.Zone, cloneLabels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to clone volume %s: %v", volumeID, err)
+	}
+	return clone, nil
+}
@@ -0,0 +1,414 @@
+package compat
+
+import (
+	"context"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+// FromV1 presents a storageops.Ops driver as OpsV2. The driver can not be
+// interrupted, so contexts are only checked before a call is started.
+// Drivers presented as storageops.Ops by ToV1 are returned unwrapped.
+func FromV1(ops storageops.Ops) OpsV2 {
+	if a, ok := ops.(*v1Adapter); ok {
+		return a.ops
+	}
+	return &v2Adapter{ops: ops}
+}
+
+// ToV1 presents an OpsV2 driver as storageops.Ops for callers that have not
+// moved to OpsV2 yet. Every call is reported as deprecated and runs with a
+// background context. Drivers presented as OpsV2 by FromV1 are returned
+// unwrapped.
+func ToV1(ops OpsV2) storageops.Ops {
+	if a, ok := ops.(*v2Adapter); ok {
+		return a.ops
+	}
+	return &v1Adapter{ops: ops}
+}
+
+// v2Adapter presents a storageops.Ops driver as OpsV2
+type v2Adapter struct {
+	ops storageops.Ops
+}
+
+func (a *v2Adapter) Name() string {
+	return a.ops.Name()
+}
+
+func (a *v2Adapter) InstanceID() InstanceID {
+	return InstanceID(a.ops.InstanceID())
+}
+
+func (a *v2Adapter) GetZone(ctx context.Context) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return a.ops.GetZone()
+}
+
+func (a *v2Adapter) GetRegion(ctx context.Context) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return a.ops.GetRegion()
+}
+
+func (a *v2Adapter) ListZones(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.ops.ListZones()
+}
+
+func (a *v2Adapter) Create(ctx context.Context, template interface{}, opts *CreateOptions) (*storageops.Volume, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = &CreateOptions{}
+	}
+	return a.ops.Create(template, opts.Labels)
+}
+
+func (a *v2Adapter) GetDeviceID(ctx context.Context, template interface{}) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return a.ops.GetDeviceID(template)
+}
+
+func (a *v2Adapter) Expand(ctx context.Context, id VolumeID, newSizeGiB uint64) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return a.ops.Expand(string(id), newSizeGiB)
+}
+
+func (a *v2Adapter) Modify(ctx context.Context, id VolumeID, spec storageops.VolumeSpecUpdate) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.ops.Modify(string(id), spec)
+}
+
+func (a *v2Adapter) Attach(ctx context.Context, id VolumeID, opts *AttachOptions) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if opts == nil {
+		opts = &AttachOptions{}
+	}
+	return a.ops.Attach(string(id), opts.Options)
+}
+
+func (a *v2Adapter) Detach(ctx context.Context, id VolumeID, opts *DetachOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if opts != nil && len(opts.Instance) > 0 {
+		return a.ops.DetachFrom(string(id), string(opts.Instance))
+	}
+	return a.ops.Detach(string(id))
+}
+
+func (a *v2Adapter) Delete(ctx context.Context, id VolumeID, opts *DeleteOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if opts != nil && len(opts.Instance) > 0 {
+		return a.ops.DeleteFrom(string(id), string(opts.Instance))
+	}
+	return a.ops.Delete(string(id))
+}
+
+func (a *v2Adapter) Describe(ctx context.Context) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.ops.Describe()
+}
+
+func (a *v2Adapter) FreeDevices(
+	ctx context.Context,
+	blockDeviceMappings []interface{},
+	rootDeviceName string,
+) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.ops.FreeDevices(blockDeviceMappings, rootDeviceName)
+}
+
+func (a *v2Adapter) Inspect(ctx context.Context, ids []VolumeID) ([]*storageops.Volume, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.ops.Inspect(volumeIDs(ids))
+}
+
+func (a *v2Adapter) DeviceMappings(ctx context.Context) (map[string]VolumeID, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	mappings, err := a.ops.DeviceMappings()
+	if err != nil {
+		return nil, err
+	}
+	typed := make(map[string]VolumeID, len(mappings))
+	for path, id := range mappings {
+		typed[path] = VolumeID(id)
+	}
+	return typed, nil
+}
+
+func (a *v2Adapter) Enumerate(
+	ctx context.Context,
+	ids []VolumeID,
+	opts *EnumerateOptions,
+) (map[string][]*storageops.Volume, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = &EnumerateOptions{}
+	}
+	return a.ops.Enumerate(volumeIDs(ids), opts.Labels, opts.SetIdentifier)
+}
+
+func (a *v2Adapter) DevicePath(ctx context.Context, id VolumeID) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return a.ops.DevicePath(string(id))
+}
+
+func (a *v2Adapter) Snapshot(ctx context.Context, id VolumeID, opts *SnapshotOptions) (*storageops.Snapshot, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = &SnapshotOptions{}
+	}
+	return a.ops.Snapshot(string(id), opts.Readonly)
+}
+
+func (a *v2Adapter) SnapshotDelete(ctx context.Context, id SnapshotID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.ops.SnapshotDelete(string(id))
+}
+
+func (a *v2Adapter) SnapshotEnumerate(
+	ctx context.Context,
+	filter *storageops.SnapshotFilter,
+) ([]*storageops.Snapshot, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.ops.SnapshotEnumerate(filter)
+}
+
+func (a *v2Adapter) SnapshotRestore(ctx context.Context, id SnapshotID, opts *RestoreOptions) (*storageops.Volume, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = &RestoreOptions{}
+	}
+	return a.ops.SnapshotRestore(string(id), opts.Zone, opts.Labels)
+}
+
+func (a *v2Adapter) SnapshotStatus(ctx context.Context, id SnapshotID) (*storageops.SnapshotStatus, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.ops.SnapshotStatus(string(id))
+}
+
+func (a *v2Adapter) ApplyTags(ctx context.Context, id VolumeID, labels map[string]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.ops.ApplyTags(string(id), labels)
+}
+
+func (a *v2Adapter) RemoveTags(ctx context.Context, id VolumeID, labels map[string]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.ops.RemoveTags(string(id), labels)
+}
+
+func (a *v2Adapter) Tags(ctx context.Context, id VolumeID) (map[string]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.ops.Tags(string(id))
+}
+
+// v1Adapter presents an OpsV2 driver as storageops.Ops
+type v1Adapter struct {
+	ops OpsV2
+}
+
+// deprecated reports the call of the given storageops.Ops method by the
+// caller of the adapter
+func deprecated(method string) {
+	Deprecated("storageops.Ops."+method, "compat.OpsV2."+method)
+}
+
+func (a *v1Adapter) Name() string {
+	return a.ops.Name()
+}
+
+func (a *v1Adapter) InstanceID() string {
+	return string(a.ops.InstanceID())
+}
+
+func (a *v1Adapter) GetZone() (string, error) {
+	deprecated("GetZone")
+	return a.ops.GetZone(context.Background())
+}
+
+func (a *v1Adapter) GetRegion() (string, error) {
+	deprecated("GetRegion")
+	return a.ops.GetRegion(context.Background())
+}
+
+func (a *v1Adapter) ListZones() ([]string, error) {
+	deprecated("ListZones")
+	return a.ops.ListZones(context.Background())
+}
+
+func (a *v1Adapter) Create(template interface{}, labels map[string]string) (*storageops.Volume, error) {
+	deprecated("Create")
+	return a.ops.Create(context.Background(), template, &CreateOptions{Labels: labels})
+}
+
+func (a *v1Adapter) GetDeviceID(template interface{}) (string, error) {
+	deprecated("GetDeviceID")
+	return a.ops.GetDeviceID(context.Background(), template)
+}
+
+func (a *v1Adapter) Expand(volumeID string, newSizeGiB uint64) (uint64, error) {
+	deprecated("Expand")
+	return a.ops.Expand(context.Background(), VolumeID(volumeID), newSizeGiB)
+}
+
+func (a *v1Adapter) Modify(volumeID string, spec storageops.VolumeSpecUpdate) error {
+	deprecated("Modify")
+	return a.ops.Modify(context.Background(), VolumeID(volumeID), spec)
+}
+
+func (a *v1Adapter) Attach(volumeID string, options map[string]string) (string, error) {
+	deprecated("Attach")
+	return a.ops.Attach(context.Background(), VolumeID(volumeID), &AttachOptions{Options: options})
+}
+
+func (a *v1Adapter) Detach(volumeID string) error {
+	deprecated("Detach")
+	return a.ops.Detach(context.Background(), VolumeID(volumeID), nil)
+}
+
+func (a *v1Adapter) DetachFrom(volumeID, instanceID string) error {
+	deprecated("DetachFrom")
+	return a.ops.Detach(context.Background(), VolumeID(volumeID),
+		&DetachOptions{Instance: InstanceID(instanceID)})
+}
+
+func (a *v1Adapter) Delete(volumeID string) error {
+	deprecated("Delete")
+	return a.ops.Delete(context.Background(), VolumeID(volumeID), nil)
+}
+
+func (a *v1Adapter) DeleteFrom(volumeID, instanceID string) error {
+	deprecated("DeleteFrom")
+	return a.ops.Delete(context.Background(), VolumeID(volumeID),
+		&DeleteOptions{Instance: InstanceID(instanceID)})
+}
+
+func (a *v1Adapter) Describe() (interface{}, error) {
+	deprecated("Describe")
+	return a.ops.Describe(context.Background())
+}
+
+func (a *v1Adapter) FreeDevices(blockDeviceMappings []interface{}, rootDeviceName string) ([]string, error) {
+	deprecated("FreeDevices")
+	return a.ops.FreeDevices(context.Background(), blockDeviceMappings, rootDeviceName)
+}
+
+func (a *v1Adapter) Inspect(volumeIds []*string) ([]*storageops.Volume, error) {
+	deprecated("Inspect")
+	return a.ops.Inspect(context.Background(), typedVolumeIDs(volumeIds))
+}
+
+func (a *v1Adapter) DeviceMappings() (map[string]string, error) {
+	deprecated("DeviceMappings")
+	typed, err := a.ops.DeviceMappings(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	mappings := make(map[string]string, len(typed))
+	for path, id := range typed {
+		mappings[path] = string(id)
+	}
+	return mappings, nil
+}
+
+func (a *v1Adapter) Enumerate(
+	volumeIds []*string,
+	labels map[string]string,
+	setIdentifier string,
+) (map[string][]*storageops.Volume, error) {
+	deprecated("Enumerate")
+	return a.ops.Enumerate(context.Background(), typedVolumeIDs(volumeIds),
+		&EnumerateOptions{Labels: labels, SetIdentifier: setIdentifier})
+}
+
+func (a *v1Adapter) DevicePath(volumeID string) (string, error) {
+	deprecated("DevicePath")
+	return a.ops.DevicePath(context.Background(), VolumeID(volumeID))
+}
+
+func (a *v1Adapter) Snapshot(volumeID string, readonly bool) (*storageops.Snapshot, error) {
+	deprecated("Snapshot")
+	return a.ops.Snapshot(context.Background(), VolumeID(volumeID), &SnapshotOptions{Readonly: readonly})
+}
+
+func (a *v1Adapter) SnapshotDelete(snapID string) error {
+	deprecated("SnapshotDelete")
+	return a.ops.SnapshotDelete(context.Background(), SnapshotID(snapID))
+}
+
+func (a *v1Adapter) SnapshotEnumerate(filter *storageops.SnapshotFilter) ([]*storageops.Snapshot, error) {
+	deprecated("SnapshotEnumerate")
+	return a.ops.SnapshotEnumerate(context.Background(), filter)
+}
+
+func (a *v1Adapter) SnapshotRestore(snapID, zone string, labels map[string]string) (*storageops.Volume, error) {
+	deprecated("SnapshotRestore")
+	return a.ops.SnapshotRestore(context.Background(), SnapshotID(snapID),
+		&RestoreOptions{Zone: zone, Labels: labels})
+}
+
+func (a *v1Adapter) SnapshotStatus(snapID string) (*storageops.SnapshotStatus, error) {
+	deprecated("SnapshotStatus")
+	return a.ops.SnapshotStatus(context.Background(), SnapshotID(snapID))
+}
+
+func (a *v1Adapter) ApplyTags(volumeID string, labels map[string]string) error {
+	deprecated("ApplyTags")
+	return a.ops.ApplyTags(context.Background(), VolumeID(volumeID), labels)
+}
+
+func (a *v1Adapter) RemoveTags(volumeID string, labels map[string]string) error {
+	deprecated("RemoveTags")
+	return a.ops.RemoveTags(context.Background(), VolumeID(volumeID), labels)
+}
+
+func (a *v1Adapter) Tags(volumeID string) (map[string]string, error) {
+	deprecated("Tags")
+	return a.ops.Tags(context.Background(), VolumeID(volumeID))
+}
@@ -0,0 +1,151 @@
+// Package compat adapts storage operations drivers between revisions of the
+// Ops interface, so drivers and their callers can each move to a new
+// revision on their own schedule. FromV1 presents a storageops.Ops driver as
+// OpsV2 and ToV1 presents an OpsV2 driver as storageops.Ops. Calls of
+// deprecated APIs are reported with their call site, see Deprecated.
+package compat
+
+import (
+	"context"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+// VolumeID is the provider ID of a volume/disk
+type VolumeID string
+
+// SnapshotID is the provider ID of a snapshot
+type SnapshotID string
+
+// InstanceID is the provider ID of an instance
+type InstanceID string
+
+// CreateOptions configure OpsV2.Create
+type CreateOptions struct {
+	// Labels applied to the volume
+	Labels map[string]string
+}
+
+// AttachOptions configure OpsV2.Attach
+type AttachOptions struct {
+	// Options are the storageops.AttachOption* options
+	Options map[string]string
+}
+
+// DetachOptions configure OpsV2.Detach
+type DetachOptions struct {
+	// Instance to detach the volume from, the instance of the driver if empty
+	Instance InstanceID
+}
+
+// DeleteOptions configure OpsV2.Delete
+type DeleteOptions struct {
+	// Instance to delete the volume from, the instance of the driver if empty
+	Instance InstanceID
+}
+
+// EnumerateOptions configure OpsV2.Enumerate
+type EnumerateOptions struct {
+	// Labels the volumes must have
+	Labels map[string]string
+	// SetIdentifier is the label the volumes are organized into sets by
+	SetIdentifier string
+}
+
+// SnapshotOptions configure OpsV2.Snapshot
+type SnapshotOptions struct {
+	// Readonly snapshots can not be modified
+	Readonly bool
+}
+
+// RestoreOptions configure OpsV2.SnapshotRestore
+type RestoreOptions struct {
+	// Zone to restore into, the zone of the instance if empty
+	Zone string
+	// Labels applied to the restored volume
+	Labels map[string]string
+}
+
+// OpsV2 is the revision of storageops.Ops taking a context and typed IDs in
+// every call that reaches the provider, and options structs in place of
+// positional optional arguments, so options can be added without breaking
+// drivers. A nil options struct means the defaults.
+type OpsV2 interface {
+	// Name returns name of the storage operations driver
+	Name() string
+	// InstanceID returns the ID of the instance the operations are performed on
+	InstanceID() InstanceID
+	// GetZone returns the availability zone of the instance
+	GetZone(ctx context.Context) (string, error)
+	// GetRegion returns the region of the instance
+	GetRegion(ctx context.Context) (string, error)
+	// ListZones returns the available zones of the region of the instance
+	ListZones(ctx context.Context) ([]string, error)
+	// Create volume based on input template volume
+	Create(ctx context.Context, template interface{}, opts *CreateOptions) (*storageops.Volume, error)
+	// GetDeviceID returns ID/Name of the given device/disk or snapshot
+	GetDeviceID(ctx context.Context, template interface{}) (string, error)
+	// Expand grows the given volume and returns its new size in GiB
+	Expand(ctx context.Context, id VolumeID, newSizeGiB uint64) (uint64, error)
+	// Modify changes the type, provisioned IOPS or throughput of the volume
+	Modify(ctx context.Context, id VolumeID, spec storageops.VolumeSpecUpdate) error
+	// Attach the volume and return its attach path
+	Attach(ctx context.Context, id VolumeID, opts *AttachOptions) (string, error)
+	// Detach the volume
+	Detach(ctx context.Context, id VolumeID, opts *DetachOptions) error
+	// Delete the volume
+	Delete(ctx context.Context, id VolumeID, opts *DeleteOptions) error
+	// Describe the instance
+	Describe(ctx context.Context) (interface{}, error)
+	// FreeDevices returns free block devices on the instance
+	FreeDevices(ctx context.Context, blockDeviceMappings []interface{}, rootDeviceName string) ([]string, error)
+	// Inspect the given volumes
+	Inspect(ctx context.Context, ids []VolumeID) ([]*storageops.Volume, error)
+	// DeviceMappings returns the volumes attached to the instance by path
+	DeviceMappings(ctx context.Context) (map[string]VolumeID, error)
+	// Enumerate volumes, organized into sets
+	Enumerate(ctx context.Context, ids []VolumeID, opts *EnumerateOptions) (map[string][]*storageops.Volume, error)
+	// DevicePath returns the path the volume is attached at
+	DevicePath(ctx context.Context, id VolumeID) (string, error)
+	// Snapshot the volume
+	Snapshot(ctx context.Context, id VolumeID, opts *SnapshotOptions) (*storageops.Snapshot, error)
+	// SnapshotDelete deletes the snapshot
+	SnapshotDelete(ctx context.Context, id SnapshotID) error
+	// SnapshotEnumerate returns all snapshots matching the filter
+	SnapshotEnumerate(ctx context.Context, filter *storageops.SnapshotFilter) ([]*storageops.Snapshot, error)
+	// SnapshotRestore creates a volume from the snapshot
+	SnapshotRestore(ctx context.Context, id SnapshotID, opts *RestoreOptions) (*storageops.Volume, error)
+	// SnapshotStatus returns the status of the snapshot
+	SnapshotStatus(ctx context.Context, id SnapshotID) (*storageops.SnapshotStatus, error)
+	// ApplyTags applies the labels to the volume
+	ApplyTags(ctx context.Context, id VolumeID, labels map[string]string) error
+	// RemoveTags removes the labels from the volume
+	RemoveTags(ctx context.Context, id VolumeID, labels map[string]string) error
+	// Tags returns the labels of the volume
+	Tags(ctx context.Context, id VolumeID) (map[string]string, error)
+}
+
+func volumeIDs(ids []VolumeID) []*string {
+	if ids == nil {
+		return nil
+	}
+	out := make([]*string, len(ids))
+	for i := range ids {
+		id := string(ids[i])
+		out[i] = &id
+	}
+	return out
+}
+
+func typedVolumeIDs(ids []*string) []VolumeID {
+	if ids == nil {
+		return nil
+	}
+	out := make([]VolumeID, 0, len(ids))
+	for _, id := range ids {
+		if id != nil {
+			out = append(out, VolumeID(*id))
+		}
+	}
+	return out
+}
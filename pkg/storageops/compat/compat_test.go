@@ -0,0 +1,60 @@
+package compat
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompatAdapters(t *testing.T) {
+	var notices []*DeprecationNotice
+	AddDeprecationObserver(func(n *DeprecationNotice) { notices = append(notices, n) })
+
+	d := mock.New("instance-1", "zone-a")
+	v2 := FromV1(d)
+	require.Equal(t, InstanceID("instance-1"), v2.InstanceID())
+
+	ctx := context.Background()
+	vol, err := v2.Create(ctx, &mock.Volume{SizeGiB: 1}, &CreateOptions{Labels: map[string]string{"app": "db"}})
+	require.NoError(t, err)
+	id, err := v2.GetDeviceID(ctx, vol)
+	require.NoError(t, err)
+	_, err = v2.Attach(ctx, VolumeID(id), nil)
+	require.NoError(t, err)
+	mappings, err := v2.DeviceMappings(ctx)
+	require.NoError(t, err)
+	require.Len(t, mappings, 1)
+	for _, mapped := range mappings {
+		require.Equal(t, VolumeID(id), mapped)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	require.Equal(t, context.Canceled, v2.Detach(canceled, VolumeID(id), nil))
+	require.Equal(t, 0, d.Calls("Detach"))
+	require.Empty(t, notices)
+
+	// Calls through the v1 view are reported at the call site
+	v1 := ToV1(&struct{ OpsV2 }{v2})
+	tags, err := v1.Tags(id)
+	require.NoError(t, err)
+	require.Equal(t, "db", tags["app"])
+	require.NoError(t, v1.Detach(id))
+	require.Equal(t, 1, d.Calls("Detach"))
+	require.Len(t, notices, 2)
+	require.Equal(t, "storageops.Ops.Tags", notices[0].API)
+	require.Equal(t, "compat.OpsV2.Tags", notices[0].Replacement)
+	require.True(t, strings.HasSuffix(notices[0].Caller, "TestCompatAdapters"), notices[0].Caller)
+	require.Contains(t, notices[0].Site, "compat_test.go:")
+
+	// Round trips return the wrapped driver
+	require.True(t, ToV1(v2) == d)
+	require.True(t, FromV1(v1).(*struct{ OpsV2 }).OpsV2 == v2)
+
+	SetDeprecationPolicy(DeprecationPanic)
+	defer SetDeprecationPolicy(DeprecationWarn)
+	require.Panics(t, func() { v1.GetZone() })
+}
@@ -0,0 +1,125 @@
+package compat
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DeprecationPolicy is what happens when a deprecated API is called
+type DeprecationPolicy int
+
+const (
+	// DeprecationWarn logs a warning the first time each call site calls a
+	// deprecated API
+	DeprecationWarn DeprecationPolicy = iota
+	// DeprecationSilent only reports the calls to the observers
+	DeprecationSilent
+	// DeprecationPanic panics on calls of deprecated APIs, e.g. in the tests
+	// of downstream drivers to find the remaining callers
+	DeprecationPanic
+)
+
+// DeprecationNotice is a call of a deprecated API
+type DeprecationNotice struct {
+	// API that was called, e.g. storageops.Ops.Attach
+	API string
+	// Replacement of the API, e.g. compat.OpsV2.Attach
+	Replacement string
+	// Caller is the function calling the API
+	Caller string
+	// Site is the file:line of the call
+	Site string
+}
+
+func (n *DeprecationNotice) String() string {
+	return fmt.Sprintf("%s is deprecated, use %s instead (called by %s at %s)",
+		n.API, n.Replacement, n.Caller, n.Site)
+}
+
+// DeprecationObserver is notified of every call of a deprecated API
+type DeprecationObserver func(notice *DeprecationNotice)
+
+var (
+	deprecationLock      sync.Mutex
+	deprecationPolicy    = DeprecationWarn
+	deprecationObservers []DeprecationObserver
+	// deprecationWarned are the API and call sites already warned about
+	deprecationWarned = make(map[string]bool)
+)
+
+// SetDeprecationPolicy sets what happens when a deprecated API is called
+func SetDeprecationPolicy(policy DeprecationPolicy) {
+	deprecationLock.Lock()
+	defer deprecationLock.Unlock()
+	deprecationPolicy = policy
+}
+
+// AddDeprecationObserver adds an observer of the calls of deprecated APIs,
+// e.g. to count them in metrics
+func AddDeprecationObserver(o DeprecationObserver) {
+	deprecationLock.Lock()
+	defer deprecationLock.Unlock()
+	deprecationObservers = append(deprecationObservers, o)
+}
+
+// Deprecated reports a call of the given deprecated API. It must be called
+// by the deprecated function itself, so that its caller is the reported call
+// site. Frames of this package are skipped, so calls through the adapters
+// are reported at the code calling the adapter.
+func Deprecated(api, replacement string) {
+	notice := &DeprecationNotice{
+		API:         api,
+		Replacement: replacement,
+		Caller:      "unknown",
+		Site:        "unknown",
+	}
+	pcs := make([]uintptr, 16)
+	// Skip runtime.Callers, Deprecated and the deprecated function
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !isCompatFrame(frame.Function) {
+			notice.Caller = frame.Function
+			notice.Site = fmt.Sprintf("%s:%d", frame.File, frame.Line)
+			break
+		}
+		if !more {
+			break
+		}
+	}
+
+	deprecationLock.Lock()
+	policy := deprecationPolicy
+	observers := deprecationObservers
+	key := notice.API + "@" + notice.Site
+	warn := policy == DeprecationWarn && !deprecationWarned[key]
+	if warn {
+		deprecationWarned[key] = true
+	}
+	deprecationLock.Unlock()
+
+	for _, o := range observers {
+		o(notice)
+	}
+	switch {
+	case policy == DeprecationPanic:
+		panic(notice.String())
+	case warn:
+		logrus.Warnf("%v", notice)
+	}
+}
+
+// isCompatFrame returns true for functions of this package, but not of its
+// tests
+func isCompatFrame(function string) bool {
+	const pkg = "github.com/libopenstorage/openstorage/pkg/storageops/compat."
+	i := strings.Index(function, pkg)
+	if i < 0 {
+		return false
+	}
+	return !strings.HasPrefix(function[i+len(pkg):], "Test")
+}
@@ -0,0 +1,351 @@
+// Package config loads cloud configs, YAML or JSON files naming the provider
+// of a storage ops driver and how to configure it, and creates their driver.
+// Providers register a Factory for their name in their init, so the provider
+// packages of the configs to load must be imported, e.g.
+//
+//	import _ "github.com/libopenstorage/openstorage/pkg/storageops/aws"
+package config
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/libopenstorage/openstorage/pkg/auth"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+// Config is the cloud config of a storage ops driver
+type Config struct {
+	// Provider is the name of the driver, e.g. aws or gce
+	Provider string `json:"provider"`
+	// Region of the instance, discovered by the driver if empty and
+	// supported by the provider
+	Region string `json:"region,omitempty"`
+	// Zone of the instance, discovered by the driver if empty and supported
+	// by the provider
+	Zone string `json:"zone,omitempty"`
+	// Instance is the ID of the instance, discovered by the driver if empty
+	// and supported by the provider
+	Instance string `json:"instance,omitempty"`
+	// Credentials refer to where the credentials of the provider are stored,
+	// keyed by the credential names of the provider, e.g. accessKeyID. The
+	// default credentials of the provider are used if empty.
+	Credentials map[string]*SecretRef `json:"credentials,omitempty"`
+	// Retry is the retry policy of the wait loops of the driver
+	Retry *Retry `json:"retry,omitempty"`
+	// HTTP configures the HTTP client of the API calls, e.g. to go through a
+	// proxy
+	HTTP *HTTP `json:"http,omitempty"`
+	// Tags are applied to every volume and snapshot created through the
+	// driver. The labels given to a call take precedence.
+	Tags map[string]string `json:"tags,omitempty"`
+	// Params are the provider specific settings, e.g. endpoint
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// SecretRef refers to where a credential is stored, so cloud configs never
+// hold secrets themselves. Exactly one of the fields must be set.
+type SecretRef struct {
+	// Env is the environment var holding the credential
+	Env string `json:"env,omitempty"`
+	// File is the path of the file holding the credential, e.g. a mounted
+	// secret. Surrounding whitespace is trimmed.
+	File string `json:"file,omitempty"`
+}
+
+// Retry is a storageops.RetryPolicy with durations such as "5s"
+type Retry struct {
+	Interval    string            `json:"interval,omitempty"`
+	Multiplier  float64           `json:"multiplier,omitempty"`
+	MaxInterval string            `json:"maxInterval,omitempty"`
+	Jitter      float64           `json:"jitter,omitempty"`
+	MaxAttempts int               `json:"maxAttempts,omitempty"`
+	Timeout     string            `json:"timeout,omitempty"`
+	Overrides   map[string]*Retry `json:"overrides,omitempty"`
+}
+
+// Policy returns the retry policy, nil if r is nil
+func (r *Retry) Policy() (*storageops.RetryPolicy, error) {
+	if r == nil {
+		return nil, nil
+	}
+	p := &storageops.RetryPolicy{
+		Multiplier:  r.Multiplier,
+		Jitter:      r.Jitter,
+		MaxAttempts: r.MaxAttempts,
+	}
+	for _, d := range []struct {
+		name  string
+		value string
+		field *time.Duration
+	}{
+		{"interval", r.Interval, &p.Interval},
+		{"maxInterval", r.MaxInterval, &p.MaxInterval},
+		{"timeout", r.Timeout, &p.Timeout},
+	} {
+		if len(d.value) == 0 {
+			continue
+		}
+		var err error
+		if *d.field, err = time.ParseDuration(d.value); err != nil {
+			return nil, fmt.Errorf("invalid retry %s: %v", d.name, err)
+		}
+	}
+	for op, o := range r.Overrides {
+		override, err := o.Policy()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", op, err)
+		}
+		if p.Overrides == nil {
+			p.Overrides = make(map[string]*storageops.RetryPolicy)
+		}
+		p.Overrides[op] = override
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// HTTP is a storageops.HTTPConfig with durations such as "30s"
+type HTTP struct {
+	ProxyURL  string `json:"proxyURL,omitempty"`
+	CABundle  string `json:"caBundle,omitempty"`
+	DNSServer string `json:"dnsServer,omitempty"`
+	Timeout   string `json:"timeout,omitempty"`
+}
+
+// HTTPConfig returns the HTTP config of the config, nil if it has none
+func (c *Config) HTTPConfig() (*storageops.HTTPConfig, error) {
+	if c.HTTP == nil {
+		return nil, nil
+	}
+	cfg := &storageops.HTTPConfig{
+		ProxyURL:  c.HTTP.ProxyURL,
+		CABundle:  c.HTTP.CABundle,
+		DNSServer: c.HTTP.DNSServer,
+	}
+	if len(c.HTTP.Timeout) > 0 {
+		var err error
+		if cfg.Timeout, err = time.ParseDuration(c.HTTP.Timeout); err != nil {
+			return nil, fmt.Errorf("invalid http timeout: %v", err)
+		}
+	}
+	return cfg, nil
+}
+
+// RetryPolicy returns the retry policy of the config, nil if it has none
+func (c *Config) RetryPolicy() (*storageops.RetryPolicy, error) {
+	return c.Retry.Policy()
+}
+
+// Credential returns the credential with the given name. ok is false if the
+// config does not refer to the credential, it is an error if the credential
+// is referred to but not found.
+func (c *Config) Credential(name string) (value string, ok bool, err error) {
+	ref := c.Credentials[name]
+	if ref == nil {
+		return "", false, nil
+	}
+	switch {
+	case len(ref.Env) > 0 && len(ref.File) > 0:
+		return "", false, fmt.Errorf("credential %s refers to both env %s and file %s",
+			name, ref.Env, ref.File)
+	case len(ref.Env) > 0:
+		value, ok = os.LookupEnv(ref.Env)
+		if !ok || len(value) == 0 {
+			return "", false, fmt.Errorf("env %s of credential %s is not set", ref.Env, name)
+		}
+		return value, true, nil
+	case len(ref.File) > 0:
+		data, err := ioutil.ReadFile(ref.File)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to read credential %s: %v", name, err)
+		}
+		return strings.TrimSpace(string(data)), true, nil
+	}
+	return "", false, fmt.Errorf("credential %s refers to neither an env nor a file", name)
+}
+
+// RequiredCredential returns the credential with the given name or, if the
+// config does not refer to it, the value of the given environment var the
+// provider reads it from by default. env may be empty if there is none.
+func (c *Config) RequiredCredential(name, env string) (string, error) {
+	value, ok, err := c.Credential(name)
+	if err != nil || ok {
+		return value, err
+	}
+	if len(env) > 0 {
+		if value, err = storageops.GetEnvValueStrict(env); err == nil {
+			return value, nil
+		}
+		return "", fmt.Errorf("%s cloud config requires credential %s or env %s",
+			c.Provider, name, env)
+	}
+	return "", fmt.Errorf("%s cloud config requires credential %s", c.Provider, name)
+}
+
+// Parse parses the given cloud config, YAML or JSON
+func Parse(data []byte) (*Config, error) {
+	c := &Config{}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("invalid cloud config: %v", err)
+	}
+	if len(c.Provider) == 0 {
+		return nil, fmt.Errorf("invalid cloud config: provider is required")
+	}
+	return c, nil
+}
+
+// Load reads the cloud config file at the given path, YAML or JSON
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+// Factory creates the driver of the given cloud config
+type Factory func(c *Config) (storageops.Ops, error)
+
+var (
+	factoriesLock sync.Mutex
+	factories     = make(map[string]Factory)
+)
+
+// Register registers the factory of the given provider, replacing a factory
+// registered before. The factory is also registered as the storageops init
+// function of the provider, creating the driver of the config of FromParams.
+func Register(provider string, f Factory) {
+	factoriesLock.Lock()
+	factories[provider] = f
+	factoriesLock.Unlock()
+	storageops.Register(provider, func(params map[string]string) (storageops.Ops, error) {
+		return f(FromParams(provider, params))
+	})
+}
+
+// Providers returns the sorted names of the providers with a factory
+func Providers() []string {
+	factoriesLock.Lock()
+	defer factoriesLock.Unlock()
+	providers := make([]string, 0, len(factories))
+	for p := range factories {
+		providers = append(providers, p)
+	}
+	sort.Strings(providers)
+	return providers
+}
+
+// Params that set the fields of the config of FromParams
+const (
+	ParamInstance = "instance"
+	ParamRegion   = "region"
+	ParamZone     = "zone"
+)
+
+// FromParams returns the config of the given storageops init function params,
+// see storageops.InitFunc. ParamInstance, ParamRegion and ParamZone set the
+// fields of the config, the others are its params. The config refers to no
+// credentials, so the provider uses its default credentials.
+func FromParams(provider string, params map[string]string) *Config {
+	c := &Config{Provider: provider}
+	for k, v := range params {
+		switch k {
+		case ParamInstance:
+			c.Instance = v
+		case ParamRegion:
+			c.Region = v
+		case ParamZone:
+			c.Zone = v
+		default:
+			if c.Params == nil {
+				c.Params = make(map[string]string)
+			}
+			c.Params[k] = v
+		}
+	}
+	return c
+}
+
+// New creates the driver of the given cloud config with the factory of its
+// provider. Providers without a factory, e.g. out-of-tree drivers, are
+// created with their storageops init function, passed the params of the
+// config and its instance, region and zone, see FromParams. It returns an
+// ErrNotSupported error if neither is registered. If the config has tags the
+// driver is wrapped to apply them.
+func New(c *Config) (storageops.Ops, error) {
+	factoriesLock.Lock()
+	f, ok := factories[c.Provider]
+	factoriesLock.Unlock()
+	if !ok {
+		initFn, err := storageops.Get(c.Provider)
+		if err != nil {
+			return nil, err
+		}
+		f = func(c *Config) (storageops.Ops, error) { return initFn(c.initParams()) }
+	}
+	ops, err := f(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s driver: %v", c.Provider, err)
+	}
+	if len(c.Tags) > 0 {
+		ops = NewTaggingOps(ops, c.Tags)
+	}
+	return ops, nil
+}
+
+// NewWithContext is New for the caller of an API request. If the given
+// context has the user of the request, see auth.ContextSaveUserInfo, the
+// driver authorizes every operation against the cloud drive role of the
+// user, see storageops.AuthorizationMiddleware.
+func NewWithContext(ctx context.Context, c *Config) (storageops.Ops, error) {
+	ops, err := New(c)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := auth.NewUserInfoFromContext(ctx); ok {
+		ops = storageops.NewChainedOps(ops, storageops.AuthorizationMiddleware(ctx))
+	}
+	return ops, nil
+}
+
+// initParams returns the params of the config with its instance, region and
+// zone, the inverse of FromParams
+func (c *Config) initParams() map[string]string {
+	params := make(map[string]string, len(c.Params)+3)
+	for k, v := range c.Params {
+		params[k] = v
+	}
+	for k, v := range map[string]string{
+		ParamInstance: c.Instance,
+		ParamRegion:   c.Region,
+		ParamZone:     c.Zone,
+	} {
+		if len(v) > 0 {
+			params[k] = v
+		}
+	}
+	return params
+}
+
+// NewFromFile creates the driver of the cloud config file at the given path
+func NewFromFile(path string) (storageops.Ops, error) {
+	c, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return New(c)
+}
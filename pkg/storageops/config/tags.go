@@ -0,0 +1,56 @@
+package config
+
+import (
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+type taggingOps struct {
+	storageops.Ops
+	tags map[string]string
+}
+
+var _ storageops.LabeledSnapshotter = &taggingOps{}
+
+// NewTaggingOps returns Ops applying the given tags to every volume and
+// snapshot they create. The labels given to a call take precedence.
+func NewTaggingOps(ops storageops.Ops, tags map[string]string) storageops.Ops {
+	return &taggingOps{
+		Ops:  ops,
+		tags: tags,
+	}
+}
+
+// withTags returns the given labels merged with the tags
+func (o *taggingOps) withTags(labels map[string]string) map[string]string {
+	merged := make(map[string]string, len(o.tags)+len(labels))
+	for k, v := range o.tags {
+		merged[k] = v
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	return merged
+}
+
+func (o *taggingOps) Create(template interface{}, labels map[string]string) (*storageops.Volume, error) {
+	return o.Ops.Create(template, o.withTags(labels))
+}
+
+func (o *taggingOps) SnapshotRestore(
+	snapID, zone string,
+	labels map[string]string,
+) (*storageops.Volume, error) {
+	return o.Ops.SnapshotRestore(snapID, zone, o.withTags(labels))
+}
+
+func (o *taggingOps) Snapshot(volumeID string, readonly bool) (*storageops.Snapshot, error) {
+	return o.SnapshotWithLabels(volumeID, readonly, nil)
+}
+
+func (o *taggingOps) SnapshotWithLabels(
+	volumeID string,
+	readonly bool,
+	labels map[string]string,
+) (*storageops.Snapshot, error) {
+	return storageops.SnapshotWithLabels(o.Ops, volumeID, readonly, o.withTags(labels))
+}
@@ -0,0 +1,72 @@
+package csi
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/libopenstorage/openstorage/pkg/storageops/config"
+)
+
+const (
+	// parameterPrefix and snapshotParameterPrefix prefix the cloud config
+	// params passed as CreateVolume and CreateSnapshot parameters
+	parameterPrefix         = "parameters."
+	snapshotParameterPrefix = "snapshotParameters."
+)
+
+func init() {
+	config.Register("csi", newFromCloudConfig)
+}
+
+// newFromCloudConfig creates the driver of the CSI driver at the endpoint
+// param of the given cloud config, or of CSI_ENDPOINT if not set. The
+// instance is the node ID, the publishDir and timeout params configure the
+// driver. Params prefixed with parameters. and snapshotParameters. are
+// passed to CreateVolume and CreateSnapshot, all credentials are passed as
+// secrets.
+func newFromCloudConfig(c *config.Config) (storageops.Ops, error) {
+	cfg := Config{
+		Endpoint:   c.Params["endpoint"],
+		NodeID:     c.Instance,
+		PublishDir: c.Params["publishDir"],
+	}
+	if len(cfg.Endpoint) == 0 {
+		var err error
+		if cfg.Endpoint, err = storageops.GetEnvValueStrict("CSI_ENDPOINT"); err != nil {
+			return nil, fmt.Errorf("csi cloud config requires param endpoint or env CSI_ENDPOINT")
+		}
+	}
+	if timeout, ok := c.Params["timeout"]; ok {
+		var err error
+		if cfg.Timeout, err = time.ParseDuration(timeout); err != nil {
+			return nil, fmt.Errorf("invalid timeout %q: %v", timeout, err)
+		}
+	}
+	for k, v := range c.Params {
+		switch {
+		case strings.HasPrefix(k, parameterPrefix):
+			if cfg.Parameters == nil {
+				cfg.Parameters = make(map[string]string)
+			}
+			cfg.Parameters[strings.TrimPrefix(k, parameterPrefix)] = v
+		case strings.HasPrefix(k, snapshotParameterPrefix):
+			if cfg.SnapshotParameters == nil {
+				cfg.SnapshotParameters = make(map[string]string)
+			}
+			cfg.SnapshotParameters[strings.TrimPrefix(k, snapshotParameterPrefix)] = v
+		}
+	}
+	for name := range c.Credentials {
+		secret, err := c.RequiredCredential(name, "")
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Secrets == nil {
+			cfg.Secrets = make(map[string]string)
+		}
+		cfg.Secrets[name] = secret
+	}
+	return NewClient(cfg)
+}
@@ -0,0 +1,104 @@
+package csi
+
+import (
+	"fmt"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/pborman/uuid"
+)
+
+func init() {
+	storageops.RegisterConverter("csi", &converter{})
+}
+
+type converter struct{}
+
+// ToVolume converts a *csi.Volume. CSI reports neither the state nor the
+// attachments of volumes.
+func (c *converter) ToVolume(raw interface{}) (*storageops.Volume, error) {
+	vol, ok := raw.(*csi.Volume)
+	if !ok {
+		return nil, fmt.Errorf("invalid csi volume %T", raw)
+	}
+	return toVolume(vol), nil
+}
+
+func toVolume(vol *csi.Volume) *storageops.Volume {
+	v := &storageops.Volume{
+		ID:      vol.VolumeId,
+		SizeGiB: uint64(vol.CapacityBytes) / uint64(storageops.GiB),
+		Labels:  map[string]string{},
+		Raw:     vol,
+	}
+	for _, t := range vol.AccessibleTopology {
+		if zone, ok := t.Segments[ZoneTopologyKey]; ok {
+			v.Zone = zone
+			break
+		}
+	}
+	return v
+}
+
+// FromSpec returns the *csi.CreateVolumeRequest template of the given spec.
+// Types, performance and encryption are CreateVolume parameters specific to
+// the CSI driver, they are set in the native template or the Parameters of
+// the Config. The zone is the ZoneTopologyKey segment of the volume topology.
+func (c *converter) FromSpec(spec *storageops.VolumeSpec) (interface{}, error) {
+	if err := spec.Unsupported("csi", storageops.SpecFieldType, storageops.SpecFieldIops,
+		storageops.SpecFieldThroughput, storageops.SpecFieldEncrypted,
+		storageops.SpecFieldKMSKey); err != nil {
+		return nil, err
+	}
+	req := &csi.CreateVolumeRequest{}
+	if spec.Native != nil {
+		native, ok := spec.Native.(*csi.CreateVolumeRequest)
+		if !ok {
+			return nil, storageops.NativeTemplateError("csi", spec.Native)
+		}
+		copied := *native
+		req = &copied
+	}
+	if len(req.Name) == 0 && len(spec.ClientToken) == 0 {
+		req.Name = "openstorage-" + uuid.New()
+	}
+	if spec.SizeGiB > 0 {
+		req.CapacityRange = &csi.CapacityRange{RequiredBytes: int64(spec.SizeGiB * uint64(storageops.GiB))}
+	}
+	if len(spec.Zone) > 0 {
+		req.AccessibilityRequirements = topologyRequirement(
+			map[string]string{ZoneTopologyKey: spec.Zone})
+	}
+	if len(spec.SnapshotID) > 0 {
+		req.VolumeContentSource = &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Snapshot{
+				Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: spec.SnapshotID},
+			},
+		}
+	}
+	return req, nil
+}
+
+func (c *converter) ToSnapshot(raw interface{}) (*storageops.Snapshot, error) {
+	snap, ok := raw.(*csi.Snapshot)
+	if !ok {
+		return nil, fmt.Errorf("invalid csi snapshot %T", raw)
+	}
+	return toSnapshot(snap)
+}
+
+func toSnapshot(snap *csi.Snapshot) (*storageops.Snapshot, error) {
+	created, err := snapshotCreated(snap)
+	if err != nil {
+		return nil, err
+	}
+	return &storageops.Snapshot{
+		ID:       snap.SnapshotId,
+		VolumeID: snap.SourceVolumeId,
+		SizeGiB:  uint64(snap.SizeBytes) / uint64(storageops.GiB),
+		State:    snapshotState(snap),
+		Created:  created,
+		Labels:   map[string]string{},
+		Raw:      snap,
+	}, nil
+}
@@ -0,0 +1,821 @@
+// Package csi implements the storage ops driver on top of an arbitrary CSI
+// driver, so platforms without a native driver in this repo can be managed
+// through the same abstraction. Volumes are created with CreateVolume and
+// attached with ControllerPublishVolume, NodeStageVolume and
+// NodePublishVolume as a raw block device on the node of the driver.
+package csi
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/pborman/uuid"
+	"google.golang.org/grpc"
+)
+
+const (
+	// DefaultPublishDir is the directory volumes are staged and published in
+	// unless configured otherwise
+	DefaultPublishDir = "/var/lib/openstorage/csi"
+	// ZoneTopologyKey is the topology segment of the zone of nodes and
+	// volumes, the well-known key most CSI drivers report
+	ZoneTopologyKey = "topology.kubernetes.io/zone"
+	// RegionTopologyKey is the topology segment of the region of nodes and
+	// volumes
+	RegionTopologyKey = "topology.kubernetes.io/region"
+	// defaultTimeout is the timeout of a single CSI call
+	defaultTimeout = 5 * time.Minute
+	// stagingDir and deviceFile are the staging target path and the block
+	// target path of a volume in its publish dir
+	stagingDir = "staging"
+	deviceFile = "device"
+	// snapshotReady and snapshotPending are the states of snapshots, CSI only
+	// reports whether they are ready to use
+	snapshotReady   = "ready"
+	snapshotPending = "pending"
+)
+
+// Config is the configuration of the CSI storage ops driver
+type Config struct {
+	// Endpoint of the CSI driver, a unix socket path or unix:// URL, or a
+	// host:port or tcp:// URL
+	Endpoint string
+	// NodeID of the node volumes are attached to, defaults to the node ID
+	// reported by NodeGetInfo
+	NodeID string
+	// PublishDir is the directory volumes are staged and published in,
+	// defaults to DefaultPublishDir
+	PublishDir string
+	// Parameters are the CreateVolume parameters of all volumes, e.g. those
+	// of a Kubernetes storage class. The parameters of a template take
+	// precedence.
+	Parameters map[string]string
+	// SnapshotParameters are the CreateSnapshot parameters of all snapshots
+	SnapshotParameters map[string]string
+	// Secrets are passed to every call that takes secrets
+	Secrets map[string]string
+	// AccessMode of the volume capability, defaults to SINGLE_NODE_WRITER
+	AccessMode csi.VolumeCapability_AccessMode_Mode
+	// Timeout of a single CSI call, defaults to 5 minutes
+	Timeout time.Duration
+}
+
+type csiOps struct {
+	controller csi.ControllerClient
+	node       csi.NodeClient
+	nodeID     string
+	topology   map[string]string
+	cfg        Config
+	// publish, stage, listVolumes and listSnapshots are the optional
+	// capabilities of the CSI driver
+	publish       bool
+	stage         bool
+	listVolumes   bool
+	listSnapshots bool
+	mutex         sync.Mutex
+}
+
+var _ storageops.Ops = &csiOps{}
+
+// NewEnvClient creates a new CSI operations client for the CSI driver at the
+// endpoint of the CSI_ENDPOINT environment var. The node is read from
+// CSI_NODE_ID if set, or else from the CSI driver.
+func NewEnvClient() (storageops.Ops, error) {
+	endpoint, err := storageops.GetEnvValueStrict("CSI_ENDPOINT")
+	if err != nil {
+		return nil, err
+	}
+	nodeID, _ := storageops.GetEnvValueStrict("CSI_NODE_ID")
+	return NewClient(Config{Endpoint: endpoint, NodeID: nodeID})
+}
+
+// NewClient creates a new CSI operations client for the CSI driver at the
+// endpoint of the given config
+func NewClient(cfg Config) (storageops.Ops, error) {
+	network, addr, err := parseEndpoint(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := grpc.Dial(addr, grpc.WithInsecure(),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout(network, addr, timeout)
+		}))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to CSI driver at %s: %v", cfg.Endpoint, err)
+	}
+	ops, err := NewClientWithConn(conn, cfg)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ops, nil
+}
+
+// NewClientWithConn creates a new CSI operations client for the CSI driver
+// of the given connection, which the caller keeps owning. The endpoint of the
+// config is ignored.
+func NewClientWithConn(conn *grpc.ClientConn, cfg Config) (storageops.Ops, error) {
+	if len(cfg.PublishDir) == 0 {
+		cfg.PublishDir = DefaultPublishDir
+	}
+	if cfg.AccessMode == csi.VolumeCapability_AccessMode_UNKNOWN {
+		cfg.AccessMode = csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	s := &csiOps{
+		controller: csi.NewControllerClient(conn),
+		node:       csi.NewNodeClient(conn),
+		cfg:        cfg,
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+	info, err := s.node.NodeGetInfo(ctx, &csi.NodeGetInfoRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting node info of CSI driver: %v", err)
+	}
+	s.nodeID = cfg.NodeID
+	if len(s.nodeID) == 0 {
+		s.nodeID = info.NodeId
+	}
+	if info.AccessibleTopology != nil {
+		s.topology = info.AccessibleTopology.Segments
+	}
+
+	controllerCaps, err := s.controller.ControllerGetCapabilities(ctx,
+		&csi.ControllerGetCapabilitiesRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting controller capabilities of CSI driver: %v", err)
+	}
+	var createDelete bool
+	for _, c := range controllerCaps.Capabilities {
+		switch c.GetRpc().GetType() {
+		case csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME:
+			createDelete = true
+		case csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME:
+			s.publish = true
+		case csi.ControllerServiceCapability_RPC_LIST_VOLUMES:
+			s.listVolumes = true
+		case csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS:
+			s.listSnapshots = true
+		}
+	}
+	if !createDelete {
+		return nil, fmt.Errorf("CSI driver can not create and delete volumes")
+	}
+	nodeCaps, err := s.node.NodeGetCapabilities(ctx, &csi.NodeGetCapabilitiesRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting node capabilities of CSI driver: %v", err)
+	}
+	for _, c := range nodeCaps.Capabilities {
+		if c.GetRpc().GetType() == csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME {
+			s.stage = true
+		}
+	}
+	return s, nil
+}
+
+// parseEndpoint returns the network and address of the given CSI endpoint
+func parseEndpoint(endpoint string) (string, string, error) {
+	switch {
+	case len(endpoint) == 0:
+		return "", "", fmt.Errorf("CSI endpoint is required")
+	case strings.HasPrefix(endpoint, "unix://"):
+		return "unix", strings.TrimPrefix(endpoint, "unix://"), nil
+	case strings.HasPrefix(endpoint, "tcp://"):
+		return "tcp", strings.TrimPrefix(endpoint, "tcp://"), nil
+	case strings.HasPrefix(endpoint, "/"):
+		return "unix", endpoint, nil
+	}
+	return "tcp", endpoint, nil
+}
+
+// context returns the context of a single CSI call
+func (s *csiOps) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), s.cfg.Timeout)
+}
+
+func (s *csiOps) Name() string { return "csi" }
+
+func (s *csiOps) InstanceID() string { return s.nodeID }
+
+// GetZone returns the ZoneTopologyKey segment of the topology of the node,
+// empty if the CSI driver reports none
+func (s *csiOps) GetZone() (string, error) { return s.topology[ZoneTopologyKey], nil }
+
+// GetRegion returns the RegionTopologyKey segment of the topology of the node,
+// empty if the CSI driver reports none
+func (s *csiOps) GetRegion() (string, error) { return s.topology[RegionTopologyKey], nil }
+
+// ListZones is not supported, CSI has no discovery of topologies
+func (s *csiOps) ListZones() ([]string, error) {
+	return nil, storageops.ErrNotSupported
+}
+
+// capability returns the block volume capability volumes are created and
+// attached with
+func (s *csiOps) capability() *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: s.cfg.AccessMode},
+	}
+}
+
+// accessibility returns the topology requirement of volumes in the given
+// zone, or of volumes accessible from the node if zone is empty
+func (s *csiOps) accessibility(zone string) *csi.TopologyRequirement {
+	segments := s.topology
+	if len(zone) > 0 {
+		segments = map[string]string{ZoneTopologyKey: zone}
+	}
+	return topologyRequirement(segments)
+}
+
+// topologyRequirement returns the requirement of volumes accessible from the
+// given topology segments, nil if there are none
+func topologyRequirement(segments map[string]string) *csi.TopologyRequirement {
+	if len(segments) == 0 {
+		return nil
+	}
+	topology := &csi.Topology{Segments: segments}
+	return &csi.TopologyRequirement{
+		Requisite: []*csi.Topology{topology},
+		Preferred: []*csi.Topology{topology},
+	}
+}
+
+// Create creates a volume from a *csi.CreateVolumeRequest template. CSI
+// volumes have no labels, the labels other than the client token are
+// ignored. CreateVolume is idempotent by name, volumes of a client token are
+// named after it so retried creates return the same volume.
+func (s *csiOps) Create(
+	template interface{},
+	labels map[string]string,
+) (*storageops.Volume, error) {
+	template, labels, err := storageops.VolumeTemplate(s.Name(), template, labels)
+	if err != nil {
+		return nil, err
+	}
+	v, ok := template.(*csi.CreateVolumeRequest)
+	if !ok {
+		return nil, storageops.NewStorageError(storageops.ErrVolInval,
+			"Invalid volume template given", s.InstanceID())
+	}
+	req := *v
+	if len(req.Name) == 0 {
+		if token := storageops.ClientToken(labels); len(token) > 0 {
+			req.Name = "openstorage-" + token
+		} else {
+			req.Name = "openstorage-" + uuid.New()
+		}
+	}
+	params := make(map[string]string, len(s.cfg.Parameters)+len(v.Parameters))
+	for key, value := range s.cfg.Parameters {
+		params[key] = value
+	}
+	for key, value := range v.Parameters {
+		params[key] = value
+	}
+	req.Parameters = params
+	if req.Secrets == nil {
+		req.Secrets = s.cfg.Secrets
+	}
+	if len(req.VolumeCapabilities) == 0 {
+		req.VolumeCapabilities = []*csi.VolumeCapability{s.capability()}
+	}
+	if req.AccessibilityRequirements == nil {
+		req.AccessibilityRequirements = s.accessibility("")
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+	resp, err := s.controller.CreateVolume(ctx, &req)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	return toVolume(resp.Volume), nil
+}
+
+func (s *csiOps) GetDeviceID(template interface{}) (string, error) {
+	if id, ok := storageops.TypedID(template); ok {
+		return id, nil
+	}
+	switch v := template.(type) {
+	case *csi.Volume:
+		return v.VolumeId, nil
+	case *csi.Snapshot:
+		return v.SnapshotId, nil
+	}
+	return "", fmt.Errorf("invalid type: %v given to GetDeviceID", template)
+}
+
+// Expand is not supported, the CSI spec of the driver has no volume
+// expansion
+func (s *csiOps) Expand(volumeID string, newSizeGiB uint64) (uint64, error) {
+	return 0, storageops.ErrNotSupported
+}
+
+// Modify is not supported, CSI volumes can not be changed once created
+func (s *csiOps) Modify(volumeID string, spec storageops.VolumeSpecUpdate) error {
+	return storageops.ErrNotSupported
+}
+
+// publishDir returns the directory of the given volume in the publish dir
+func (s *csiOps) publishDir(volumeID string) string {
+	return filepath.Join(s.cfg.PublishDir, url.PathEscape(volumeID))
+}
+
+// devicePath returns the block target path of the given volume
+func (s *csiOps) devicePath(volumeID string) string {
+	return filepath.Join(s.publishDir(volumeID), deviceFile)
+}
+
+// volumeContext returns the volume context of the given volume, nil if the
+// CSI driver can not list volumes
+func (s *csiOps) volumeContext(volumeID string) (map[string]string, error) {
+	if !s.listVolumes {
+		return nil, nil
+	}
+	vol, err := s.volume(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	return vol.VolumeContext, nil
+}
+
+// Attach publishes the given volume to the node, stages it if the CSI driver
+// stages volumes and publishes it on the node as a raw block device. It
+// returns the path of the device in the publish dir. A volume that fails to
+// attach is detached again.
+func (s *csiOps) Attach(volumeID string, options map[string]string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	devicePath := s.devicePath(volumeID)
+	if _, err := os.Stat(devicePath); err == nil {
+		return devicePath, nil
+	}
+	volumeContext, err := s.volumeContext(volumeID)
+	if err != nil {
+		return "", err
+	}
+	readonly := options[storageops.AttachOptionReadOnly] == "true"
+	if err := os.MkdirAll(s.publishDir(volumeID), 0750); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+	var publishContext map[string]string
+	if s.publish {
+		resp, err := s.controller.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+			VolumeId:         volumeID,
+			NodeId:           s.nodeID,
+			VolumeCapability: s.capability(),
+			Readonly:         readonly,
+			Secrets:          s.cfg.Secrets,
+			VolumeContext:    volumeContext,
+		})
+		if err != nil {
+			s.detach(volumeID, s.nodeID)
+			return "", s.storageError(err)
+		}
+		publishContext = resp.PublishContext
+	}
+	var stagingPath string
+	if s.stage {
+		stagingPath = filepath.Join(s.publishDir(volumeID), stagingDir)
+		if err := os.MkdirAll(stagingPath, 0750); err != nil {
+			s.detach(volumeID, s.nodeID)
+			return "", err
+		}
+		if _, err := s.node.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+			VolumeId:          volumeID,
+			PublishContext:    publishContext,
+			StagingTargetPath: stagingPath,
+			VolumeCapability:  s.capability(),
+			Secrets:           s.cfg.Secrets,
+			VolumeContext:     volumeContext,
+		}); err != nil {
+			s.detach(volumeID, s.nodeID)
+			return "", s.storageError(err)
+		}
+	}
+	if _, err := s.node.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+		VolumeId:          volumeID,
+		PublishContext:    publishContext,
+		StagingTargetPath: stagingPath,
+		TargetPath:        devicePath,
+		VolumeCapability:  s.capability(),
+		Readonly:          readonly,
+		Secrets:           s.cfg.Secrets,
+		VolumeContext:     volumeContext,
+	}); err != nil {
+		s.detach(volumeID, s.nodeID)
+		return "", s.storageError(err)
+	}
+	return devicePath, nil
+}
+
+func (s *csiOps) Detach(volumeID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.detach(volumeID, s.nodeID)
+}
+
+// DetachFrom detaches the given volume from the given node. Volumes of other
+// nodes can only be detached if the CSI driver publishes volumes to nodes.
+func (s *csiOps) DetachFrom(volumeID, instanceID string) error {
+	if instanceID == s.nodeID {
+		return s.Detach(volumeID)
+	}
+	if !s.publish {
+		return storageops.ErrNotSupported
+	}
+	return s.detach(volumeID, instanceID)
+}
+
+// detach unpublishes and unstages the given volume on this node and
+// unpublishes it from the given node. CSI unpublish and unstage calls succeed
+// for volumes that are not published or staged.
+func (s *csiOps) detach(volumeID, nodeID string) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	if nodeID == s.nodeID {
+		dir := s.publishDir(volumeID)
+		if _, err := s.node.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{
+			VolumeId:   volumeID,
+			TargetPath: s.devicePath(volumeID),
+		}); err != nil {
+			return s.storageError(err)
+		}
+		if s.stage {
+			if _, err := s.node.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{
+				VolumeId:          volumeID,
+				StagingTargetPath: filepath.Join(dir, stagingDir),
+			}); err != nil {
+				return s.storageError(err)
+			}
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	if s.publish {
+		if _, err := s.controller.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{
+			VolumeId: volumeID,
+			NodeId:   nodeID,
+			Secrets:  s.cfg.Secrets,
+		}); err != nil {
+			return s.storageError(err)
+		}
+	}
+	return nil
+}
+
+func (s *csiOps) Delete(volumeID string) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	_, err := s.controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{
+		VolumeId: volumeID,
+		Secrets:  s.cfg.Secrets,
+	})
+	return s.storageError(err)
+}
+
+func (s *csiOps) DeleteFrom(volumeID, _ string) error {
+	return s.Delete(volumeID)
+}
+
+// Describe returns the *csi.NodeGetInfoResponse of the node
+func (s *csiOps) Describe() (interface{}, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	info, err := s.node.NodeGetInfo(ctx, &csi.NodeGetInfoRequest{})
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	return info, nil
+}
+
+// FreeDevices is not supported, the CSI driver chooses the devices of
+// volumes
+func (s *csiOps) FreeDevices(
+	blockDeviceMappings []interface{},
+	rootDeviceName string,
+) ([]string, error) {
+	return nil, storageops.ErrNotSupported
+}
+
+// eachVolume calls fn with every volume of the CSI driver until it returns
+// false, following the pagination of ListVolumes
+func (s *csiOps) eachVolume(fn func(vol *csi.Volume) bool) error {
+	if !s.listVolumes {
+		return fmt.Errorf("%w: CSI driver can not list volumes", storageops.ErrNotSupported)
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	req := &csi.ListVolumesRequest{}
+	for {
+		resp, err := s.controller.ListVolumes(ctx, req)
+		if err != nil {
+			return s.storageError(err)
+		}
+		for _, e := range resp.Entries {
+			if !fn(e.Volume) {
+				return nil
+			}
+		}
+		if len(resp.NextToken) == 0 {
+			return nil
+		}
+		req.StartingToken = resp.NextToken
+	}
+}
+
+// volume returns the given volume, CSI has no call to get a single volume
+func (s *csiOps) volume(volumeID string) (*csi.Volume, error) {
+	var found *csi.Volume
+	err := s.eachVolume(func(vol *csi.Volume) bool {
+		if vol.VolumeId == volumeID {
+			found = vol
+		}
+		return found == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, storageops.NewStorageError(storageops.ErrVolNotFound,
+			fmt.Sprintf("volume %s not found", volumeID), s.InstanceID())
+	}
+	return found, nil
+}
+
+func (s *csiOps) Inspect(volumeIds []*string) ([]*storageops.Volume, error) {
+	vols := make(map[string]*csi.Volume)
+	err := s.eachVolume(func(vol *csi.Volume) bool {
+		vols[vol.VolumeId] = vol
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	var found []*storageops.Volume
+	for _, id := range volumeIds {
+		vol, ok := vols[*id]
+		if !ok {
+			return nil, storageops.NewStorageError(storageops.ErrVolNotFound,
+				fmt.Sprintf("volume %s not found", *id), s.InstanceID())
+		}
+		found = append(found, toVolume(vol))
+	}
+	return found, nil
+}
+
+// DeviceMappings returns the devices of the volumes published on the node in
+// the publish dir
+func (s *csiOps) DeviceMappings() (map[string]string, error) {
+	dirs, err := ioutil.ReadDir(s.cfg.PublishDir)
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string)
+	for _, dir := range dirs {
+		volumeID, err := url.PathUnescape(dir.Name())
+		if err != nil || !dir.IsDir() {
+			continue
+		}
+		devicePath := s.devicePath(volumeID)
+		if _, err := os.Stat(devicePath); err == nil {
+			m[devicePath] = volumeID
+		}
+	}
+	return m, nil
+}
+
+// Enumerate returns the volumes of the CSI driver with the given IDs, all in
+// the storageops.SetIdentifierNone set. CSI volumes have no labels, so
+// enumerating by labels is not supported.
+func (s *csiOps) Enumerate(
+	volumeIds []*string,
+	labels map[string]string,
+	setIdentifier string,
+) (map[string][]*storageops.Volume, error) {
+	if len(labels) > 0 {
+		return nil, fmt.Errorf("%w: CSI volumes have no labels", storageops.ErrNotSupported)
+	}
+	ids := make(map[string]bool)
+	for _, id := range volumeIds {
+		ids[*id] = true
+	}
+	sets := make(map[string][]*storageops.Volume)
+	err := s.eachVolume(func(vol *csi.Volume) bool {
+		if len(ids) == 0 || ids[vol.VolumeId] {
+			storageops.AddElementToMap(sets, toVolume(vol), storageops.SetIdentifierNone)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sets, nil
+}
+
+func (s *csiOps) DevicePath(volumeID string) (string, error) {
+	devicePath := s.devicePath(volumeID)
+	if _, err := os.Stat(devicePath); err != nil {
+		return "", storageops.NewStorageError(storageops.ErrVolDetached,
+			fmt.Sprintf("Volume %s is detached", volumeID), s.InstanceID())
+	}
+	return devicePath, nil
+}
+
+func (s *csiOps) Snapshot(volumeID string, readonly bool) (*storageops.Snapshot, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	resp, err := s.controller.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{
+		SourceVolumeId: volumeID,
+		Name:           "openstorage-snap-" + uuid.New(),
+		Parameters:     s.cfg.SnapshotParameters,
+		Secrets:        s.cfg.Secrets,
+	})
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	return toSnapshot(resp.Snapshot)
+}
+
+func (s *csiOps) SnapshotDelete(snapID string) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	_, err := s.controller.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{
+		SnapshotId: snapID,
+		Secrets:    s.cfg.Secrets,
+	})
+	return s.storageError(err)
+}
+
+// eachSnapshot calls fn with every snapshot matching the given request,
+// following the pagination of ListSnapshots
+func (s *csiOps) eachSnapshot(req *csi.ListSnapshotsRequest, fn func(snap *csi.Snapshot) error) error {
+	if !s.listSnapshots {
+		return fmt.Errorf("%w: CSI driver can not list snapshots", storageops.ErrNotSupported)
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	for {
+		resp, err := s.controller.ListSnapshots(ctx, req)
+		if err != nil {
+			return s.storageError(err)
+		}
+		for _, e := range resp.Entries {
+			if err := fn(e.Snapshot); err != nil {
+				return err
+			}
+		}
+		if len(resp.NextToken) == 0 {
+			return nil
+		}
+		req.StartingToken = resp.NextToken
+	}
+}
+
+// snapshot returns the given snapshot
+func (s *csiOps) snapshot(snapID string) (*csi.Snapshot, error) {
+	var found *csi.Snapshot
+	err := s.eachSnapshot(&csi.ListSnapshotsRequest{SnapshotId: snapID},
+		func(snap *csi.Snapshot) error {
+			if snap.SnapshotId == snapID {
+				found = snap
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, storageops.NewStorageError(storageops.ErrVolNotFound,
+			fmt.Sprintf("snapshot %s not found", snapID), s.InstanceID())
+	}
+	return found, nil
+}
+
+// snapshotState returns the state of the given snapshot
+func snapshotState(snap *csi.Snapshot) string {
+	if snap.ReadyToUse {
+		return snapshotReady
+	}
+	return snapshotPending
+}
+
+// snapshotCreated returns the creation time of the given snapshot, zero if
+// the CSI driver did not report it
+func snapshotCreated(snap *csi.Snapshot) (time.Time, error) {
+	if snap.CreationTime == nil {
+		return time.Time{}, nil
+	}
+	return ptypes.Timestamp(snap.CreationTime)
+}
+
+// SnapshotEnumerate returns the snapshots matching the given filter. CSI
+// snapshots have no labels, so filtering by labels is not supported.
+func (s *csiOps) SnapshotEnumerate(
+	filter *storageops.SnapshotFilter,
+) ([]*storageops.Snapshot, error) {
+	req := &csi.ListSnapshotsRequest{}
+	if filter != nil {
+		if len(filter.Labels) > 0 {
+			return nil, fmt.Errorf("%w: CSI snapshots have no labels", storageops.ErrNotSupported)
+		}
+		req.SourceVolumeId = filter.VolumeID
+	}
+	var snaps []*storageops.Snapshot
+	err := s.eachSnapshot(req, func(snap *csi.Snapshot) error {
+		typed, err := toSnapshot(snap)
+		if err != nil {
+			return err
+		}
+		if filter.Match(typed.VolumeID, typed.Created, typed.State, typed.Labels) {
+			snaps = append(snaps, typed)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snaps, nil
+}
+
+// SnapshotRestore creates a volume of the size of the given snapshot from
+// it. The zone is the ZoneTopologyKey segment of the volume topology,
+// accessible from the node if empty.
+func (s *csiOps) SnapshotRestore(
+	snapID, zone string,
+	labels map[string]string,
+) (*storageops.Volume, error) {
+	req := &csi.CreateVolumeRequest{
+		VolumeContentSource: &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Snapshot{
+				Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: snapID},
+			},
+		},
+		AccessibilityRequirements: s.accessibility(zone),
+	}
+	if s.listSnapshots {
+		snap, err := s.snapshot(snapID)
+		if err != nil {
+			return nil, err
+		}
+		if snap.SizeBytes > 0 {
+			req.CapacityRange = &csi.CapacityRange{RequiredBytes: snap.SizeBytes}
+		}
+	}
+	return s.Create(req, labels)
+}
+
+func (s *csiOps) SnapshotStatus(snapID string) (*storageops.SnapshotStatus, error) {
+	snap, err := s.snapshot(snapID)
+	if err != nil {
+		return nil, err
+	}
+	status := &storageops.SnapshotStatus{
+		ID:        snap.SnapshotId,
+		State:     snapshotState(snap),
+		Completed: snap.ReadyToUse,
+	}
+	if snap.ReadyToUse {
+		status.Progress = 100
+	}
+	return status, nil
+}
+
+// ApplyTags is not supported, CSI volumes and snapshots have no labels
+func (s *csiOps) ApplyTags(volumeID string, labels map[string]string) error {
+	return storageops.ErrNotSupported
+}
+
+// RemoveTags is not supported, CSI volumes and snapshots have no labels
+func (s *csiOps) RemoveTags(volumeID string, labels map[string]string) error {
+	return storageops.ErrNotSupported
+}
+
+// Tags is not supported, CSI volumes and snapshots have no labels
+func (s *csiOps) Tags(volumeID string) (map[string]string, error) {
+	return nil, storageops.ErrNotSupported
+}
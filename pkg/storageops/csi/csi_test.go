@@ -0,0 +1,447 @@
+package csi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/libopenstorage/openstorage/pkg/storageops/test"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAll(t *testing.T) {
+	d, err := NewEnvClient()
+	if err != nil {
+		t.Skipf("skipping CSI tests as environment is not set...\n")
+	}
+	name := fmt.Sprintf("openstorage-test-%s", uuid.New()[:8])
+	test.RunTest(map[string]storageops.Ops{d.Name(): d},
+		map[string]map[string]interface{}{d.Name(): {
+			name: &csi.CreateVolumeRequest{
+				Name:          name,
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 10 << 30},
+			},
+		}}, t)
+}
+
+// fakeCSI is an in memory CSI driver of volumes and snapshots that publishes
+// volumes as regular files
+type fakeCSI struct {
+	sync.Mutex
+	t         *testing.T
+	volumes   map[string]*csi.Volume
+	snapshots map[string]*csi.Snapshot
+	// published are the nodes of the controller published volumes
+	published map[string]string
+	staged    map[string]string
+}
+
+func newFakeCSI(t *testing.T) *fakeCSI {
+	return &fakeCSI{
+		t:         t,
+		volumes:   make(map[string]*csi.Volume),
+		snapshots: make(map[string]*csi.Snapshot),
+		published: make(map[string]string),
+		staged:    make(map[string]string),
+	}
+}
+
+func (f *fakeCSI) CreateVolume(
+	ctx context.Context,
+	req *csi.CreateVolumeRequest,
+) (*csi.CreateVolumeResponse, error) {
+	f.Lock()
+	defer f.Unlock()
+	assert.Len(f.t, req.VolumeCapabilities, 1)
+	assert.NotNil(f.t, req.VolumeCapabilities[0].GetBlock())
+	for _, vol := range f.volumes {
+		if vol.VolumeContext["name"] == req.Name {
+			return &csi.CreateVolumeResponse{Volume: vol}, nil
+		}
+	}
+	vol := &csi.Volume{
+		VolumeId:      "vol-" + uuid.New()[:8],
+		CapacityBytes: req.GetCapacityRange().GetRequiredBytes(),
+		VolumeContext: map[string]string{"name": req.Name, "type": req.Parameters["type"]},
+		ContentSource: req.VolumeContentSource,
+	}
+	if snap := req.GetVolumeContentSource().GetSnapshot(); snap != nil {
+		if _, ok := f.snapshots[snap.SnapshotId]; !ok {
+			return nil, status.Errorf(codes.NotFound, "snapshot %s not found", snap.SnapshotId)
+		}
+	}
+	if r := req.AccessibilityRequirements; r != nil {
+		vol.AccessibleTopology = r.Requisite
+	}
+	f.volumes[vol.VolumeId] = vol
+	return &csi.CreateVolumeResponse{Volume: vol}, nil
+}
+
+func (f *fakeCSI) DeleteVolume(
+	ctx context.Context,
+	req *csi.DeleteVolumeRequest,
+) (*csi.DeleteVolumeResponse, error) {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.published[req.VolumeId]; ok {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume %s is published", req.VolumeId)
+	}
+	delete(f.volumes, req.VolumeId)
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+func (f *fakeCSI) ControllerPublishVolume(
+	ctx context.Context,
+	req *csi.ControllerPublishVolumeRequest,
+) (*csi.ControllerPublishVolumeResponse, error) {
+	f.Lock()
+	defer f.Unlock()
+	vol, ok := f.volumes[req.VolumeId]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "volume %s not found", req.VolumeId)
+	}
+	assert.Equal(f.t, vol.VolumeContext, req.VolumeContext)
+	if node, ok := f.published[req.VolumeId]; ok && node != req.NodeId {
+		return nil, status.Errorf(codes.FailedPrecondition,
+			"volume %s is published to %s", req.VolumeId, node)
+	}
+	f.published[req.VolumeId] = req.NodeId
+	return &csi.ControllerPublishVolumeResponse{
+		PublishContext: map[string]string{"device": "/dev/fake-" + req.VolumeId},
+	}, nil
+}
+
+func (f *fakeCSI) ControllerUnpublishVolume(
+	ctx context.Context,
+	req *csi.ControllerUnpublishVolumeRequest,
+) (*csi.ControllerUnpublishVolumeResponse, error) {
+	f.Lock()
+	defer f.Unlock()
+	if f.published[req.VolumeId] == req.NodeId {
+		delete(f.published, req.VolumeId)
+	}
+	return &csi.ControllerUnpublishVolumeResponse{}, nil
+}
+
+func (f *fakeCSI) ValidateVolumeCapabilities(
+	ctx context.Context,
+	req *csi.ValidateVolumeCapabilitiesRequest,
+) (*csi.ValidateVolumeCapabilitiesResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "not implemented")
+}
+
+// ListVolumes returns one volume per page
+func (f *fakeCSI) ListVolumes(
+	ctx context.Context,
+	req *csi.ListVolumesRequest,
+) (*csi.ListVolumesResponse, error) {
+	f.Lock()
+	defer f.Unlock()
+	var ids []string
+	for id := range f.volumes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	i, next := page(req.StartingToken, len(ids))
+	resp := &csi.ListVolumesResponse{NextToken: next}
+	if i < len(ids) {
+		resp.Entries = []*csi.ListVolumesResponse_Entry{{Volume: f.volumes[ids[i]]}}
+	}
+	return resp, nil
+}
+
+// page returns the index of the single entry of the page of the given
+// starting token of n sorted entries, and the token of the next page
+func page(token string, n int) (int, string) {
+	var i int
+	if len(token) > 0 {
+		fmt.Sscanf(token, "%d", &i)
+	}
+	if i+1 < n {
+		return i, fmt.Sprintf("%d", i+1)
+	}
+	return i, ""
+}
+
+func (f *fakeCSI) GetCapacity(
+	ctx context.Context,
+	req *csi.GetCapacityRequest,
+) (*csi.GetCapacityResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "not implemented")
+}
+
+func (f *fakeCSI) ControllerGetCapabilities(
+	ctx context.Context,
+	req *csi.ControllerGetCapabilitiesRequest,
+) (*csi.ControllerGetCapabilitiesResponse, error) {
+	var caps []*csi.ControllerServiceCapability
+	for _, c := range []csi.ControllerServiceCapability_RPC_Type{
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+		csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+		csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+	} {
+		caps = append(caps, &csi.ControllerServiceCapability{
+			Type: &csi.ControllerServiceCapability_Rpc{
+				Rpc: &csi.ControllerServiceCapability_RPC{Type: c},
+			},
+		})
+	}
+	return &csi.ControllerGetCapabilitiesResponse{Capabilities: caps}, nil
+}
+
+func (f *fakeCSI) CreateSnapshot(
+	ctx context.Context,
+	req *csi.CreateSnapshotRequest,
+) (*csi.CreateSnapshotResponse, error) {
+	f.Lock()
+	defer f.Unlock()
+	vol, ok := f.volumes[req.SourceVolumeId]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "volume %s not found", req.SourceVolumeId)
+	}
+	snap := &csi.Snapshot{
+		SnapshotId:     "snap-" + uuid.New()[:8],
+		SourceVolumeId: vol.VolumeId,
+		SizeBytes:      vol.CapacityBytes,
+		CreationTime:   ptypes.TimestampNow(),
+		ReadyToUse:     true,
+	}
+	f.snapshots[snap.SnapshotId] = snap
+	return &csi.CreateSnapshotResponse{Snapshot: snap}, nil
+}
+
+func (f *fakeCSI) DeleteSnapshot(
+	ctx context.Context,
+	req *csi.DeleteSnapshotRequest,
+) (*csi.DeleteSnapshotResponse, error) {
+	f.Lock()
+	defer f.Unlock()
+	delete(f.snapshots, req.SnapshotId)
+	return &csi.DeleteSnapshotResponse{}, nil
+}
+
+// ListSnapshots returns one snapshot per page
+func (f *fakeCSI) ListSnapshots(
+	ctx context.Context,
+	req *csi.ListSnapshotsRequest,
+) (*csi.ListSnapshotsResponse, error) {
+	f.Lock()
+	defer f.Unlock()
+	var ids []string
+	for id, snap := range f.snapshots {
+		if len(req.SnapshotId) > 0 && id != req.SnapshotId {
+			continue
+		}
+		if len(req.SourceVolumeId) > 0 && snap.SourceVolumeId != req.SourceVolumeId {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	i, next := page(req.StartingToken, len(ids))
+	resp := &csi.ListSnapshotsResponse{NextToken: next}
+	if i < len(ids) {
+		resp.Entries = []*csi.ListSnapshotsResponse_Entry{{Snapshot: f.snapshots[ids[i]]}}
+	}
+	return resp, nil
+}
+
+func (f *fakeCSI) NodeStageVolume(
+	ctx context.Context,
+	req *csi.NodeStageVolumeRequest,
+) (*csi.NodeStageVolumeResponse, error) {
+	f.Lock()
+	defer f.Unlock()
+	if f.published[req.VolumeId] != "node-1" {
+		return nil, status.Errorf(codes.FailedPrecondition,
+			"volume %s is not published to the node", req.VolumeId)
+	}
+	assert.Equal(f.t, "/dev/fake-"+req.VolumeId, req.PublishContext["device"])
+	_, err := os.Stat(req.StagingTargetPath)
+	assert.NoError(f.t, err, "staging target path must exist")
+	f.staged[req.VolumeId] = req.StagingTargetPath
+	return &csi.NodeStageVolumeResponse{}, nil
+}
+
+func (f *fakeCSI) NodeUnstageVolume(
+	ctx context.Context,
+	req *csi.NodeUnstageVolumeRequest,
+) (*csi.NodeUnstageVolumeResponse, error) {
+	f.Lock()
+	defer f.Unlock()
+	delete(f.staged, req.VolumeId)
+	return &csi.NodeUnstageVolumeResponse{}, nil
+}
+
+func (f *fakeCSI) NodePublishVolume(
+	ctx context.Context,
+	req *csi.NodePublishVolumeRequest,
+) (*csi.NodePublishVolumeResponse, error) {
+	f.Lock()
+	defer f.Unlock()
+	if f.staged[req.VolumeId] != req.StagingTargetPath {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume %s is not staged", req.VolumeId)
+	}
+	if err := ioutil.WriteFile(req.TargetPath, nil, 0600); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+func (f *fakeCSI) NodeUnpublishVolume(
+	ctx context.Context,
+	req *csi.NodeUnpublishVolumeRequest,
+) (*csi.NodeUnpublishVolumeResponse, error) {
+	if err := os.Remove(req.TargetPath); err != nil && !os.IsNotExist(err) {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
+func (f *fakeCSI) NodeGetVolumeStats(
+	ctx context.Context,
+	req *csi.NodeGetVolumeStatsRequest,
+) (*csi.NodeGetVolumeStatsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "not implemented")
+}
+
+func (f *fakeCSI) NodeGetCapabilities(
+	ctx context.Context,
+	req *csi.NodeGetCapabilitiesRequest,
+) (*csi.NodeGetCapabilitiesResponse, error) {
+	return &csi.NodeGetCapabilitiesResponse{
+		Capabilities: []*csi.NodeServiceCapability{{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{
+					Type: csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
+				},
+			},
+		}},
+	}, nil
+}
+
+func (f *fakeCSI) NodeGetInfo(
+	ctx context.Context,
+	req *csi.NodeGetInfoRequest,
+) (*csi.NodeGetInfoResponse, error) {
+	return &csi.NodeGetInfoResponse{
+		NodeId: "node-1",
+		AccessibleTopology: &csi.Topology{Segments: map[string]string{
+			ZoneTopologyKey:   "zone-a",
+			RegionTopologyKey: "region-1",
+		}},
+	}, nil
+}
+
+func TestCSIVolumes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fake := newFakeCSI(t)
+	server := grpc.NewServer()
+	csi.RegisterControllerServer(server, fake)
+	csi.RegisterNodeServer(server, fake)
+	endpoint := filepath.Join(dir, "csi.sock")
+	l, err := net.Listen("unix", endpoint)
+	assert.NoError(t, err)
+	go server.Serve(l)
+	defer server.Stop()
+
+	d, err := NewClient(Config{
+		Endpoint:   "unix://" + endpoint,
+		PublishDir: filepath.Join(dir, "publish"),
+		Parameters: map[string]string{"type": "ssd"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "node-1", d.InstanceID())
+	zone, err := d.GetZone()
+	assert.NoError(t, err)
+	assert.Equal(t, "zone-a", zone)
+
+	vol, err := d.Create(&storageops.VolumeSpec{SizeGiB: 2, ClientToken: "token-1"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), vol.SizeGiB)
+	assert.Equal(t, "zone-a", vol.Zone)
+	raw := vol.Raw.(*csi.Volume)
+	assert.Equal(t, "openstorage-token-1", raw.VolumeContext["name"])
+	assert.Equal(t, "ssd", raw.VolumeContext["type"])
+	again, err := d.Create(&storageops.VolumeSpec{SizeGiB: 2, ClientToken: "token-1"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, vol.ID, again.ID, "creates with the same client token must be idempotent")
+
+	_, err = d.Create(&storageops.VolumeSpec{SizeGiB: 2, Iops: 100}, nil)
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval))
+	_, err = d.Enumerate(nil, map[string]string{"foo": "bar"}, "")
+	assert.True(t, errors.Is(err, storageops.ErrNotSupported))
+
+	devicePath, err := d.Attach(vol.ID, nil)
+	assert.NoError(t, err)
+	_, err = os.Stat(devicePath)
+	assert.NoError(t, err)
+	assert.Equal(t, "node-1", fake.published[vol.ID])
+	path, err := d.DevicePath(vol.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, devicePath, path)
+	mappings, err := d.DeviceMappings()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{devicePath: vol.ID}, mappings)
+	path, err = d.Attach(vol.ID, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, devicePath, path, "attaching an attached volume returns its device")
+
+	err = d.Delete(vol.ID)
+	assert.Error(t, err, "published volumes can not be deleted")
+	assert.NoError(t, d.Detach(vol.ID))
+	_, err = d.DevicePath(vol.ID)
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolDetached))
+	assert.Empty(t, fake.published)
+	assert.Empty(t, fake.staged)
+
+	snap, err := d.Snapshot(vol.ID, true)
+	assert.NoError(t, err)
+	assert.Equal(t, vol.ID, snap.VolumeID)
+	assert.Equal(t, snapshotReady, snap.State)
+	snapStatus, err := d.SnapshotStatus(snap.ID)
+	assert.NoError(t, err)
+	assert.True(t, snapStatus.Completed)
+	snaps, err := d.SnapshotEnumerate(&storageops.SnapshotFilter{VolumeID: vol.ID})
+	assert.NoError(t, err)
+	assert.Len(t, snaps, 1)
+
+	restored, err := d.SnapshotRestore(snap.ID, "zone-b", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), restored.SizeGiB)
+	assert.Equal(t, "zone-b", restored.Zone)
+	sets, err := d.Enumerate(nil, nil, "")
+	assert.NoError(t, err)
+	assert.Len(t, sets[storageops.SetIdentifierNone], 2)
+	vols, err := d.Inspect([]*string{&restored.ID})
+	assert.NoError(t, err)
+	assert.Len(t, vols, 1)
+
+	assert.NoError(t, d.SnapshotDelete(snap.ID))
+	_, err = d.SnapshotStatus(snap.ID)
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolNotFound))
+	for _, id := range []string{vol.ID, restored.ID} {
+		assert.NoError(t, d.Delete(id))
+	}
+	_, err = d.Inspect([]*string{&vol.ID})
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolNotFound))
+}
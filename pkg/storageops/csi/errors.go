@@ -0,0 +1,35 @@
+package csi
+
+import (
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errorCodes maps the gRPC codes of CSI errors to storage error codes
+var errorCodes = map[codes.Code]int{
+	codes.NotFound:          storageops.ErrVolNotFound,
+	codes.InvalidArgument:   storageops.ErrVolInval,
+	codes.OutOfRange:        storageops.ErrVolInval,
+	codes.AlreadyExists:     storageops.ErrVolInval,
+	codes.ResourceExhausted: storageops.ErrQuotaExceeded,
+	codes.PermissionDenied:  storageops.ErrUnauthorized,
+	codes.Unauthenticated:   storageops.ErrUnauthorized,
+}
+
+// storageError maps an error returned by the CSI driver to a storage error
+// with the matching code. Errors without a matching code are returned
+// unchanged.
+func (s *csiOps) storageError(err error) error {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	if code, ok := errorCodes[st.Code()]; ok {
+		return storageops.WrapError(code, err, s.InstanceID())
+	}
+	return err
+}
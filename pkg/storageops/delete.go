@@ -0,0 +1,105 @@
+package storageops
+
+import (
+	"sort"
+)
+
+// DeleteMatchingOptions configure DeleteMatching
+type DeleteMatchingOptions struct {
+	// Detach detaches attached volumes from all their instances before
+	// deleting them. By default attached volumes are skipped.
+	Detach bool
+	// Parallelism is the number of volumes deleted at once, defaults to
+	// DefaultBatchParallelism
+	Parallelism int
+}
+
+// VolumeDeleteResult is the result of deleting a single volume of
+// DeleteMatching
+type VolumeDeleteResult struct {
+	// VolumeID of the volume
+	VolumeID string
+	// AttachedTo are the instances the volume was attached to when it was
+	// enumerated
+	AttachedTo []string
+	// Skipped is true if the volume was not deleted because it is attached
+	Skipped bool
+	// Err is the error detaching or deleting the volume, nil if it was
+	// deleted, skipped or it is a dry run
+	Err error
+}
+
+// DeleteMatching deletes every volume carrying all the given labels, e.g. the
+// volumes of a torn down cluster or volumes leaked by failed creates.
+// Attached volumes are skipped unless opts detach them first. If dryRun is
+// true nothing is detached or deleted, the results report what would be. The
+// labels must not be empty so a missing label never deletes all volumes, and
+// enumerated volumes without all the labels are never deleted, whatever the
+// filtering of the driver. opts may be nil. It returns the result of each volume ordered by volume
+// ID.
+func DeleteMatching(
+	ops Ops,
+	labels map[string]string,
+	dryRun bool,
+	opts *DeleteMatchingOptions,
+) ([]*VolumeDeleteResult, error) {
+	if len(labels) == 0 {
+		return nil, NewStorageError(ErrVolInval,
+			"refusing to delete volumes without labels to match", "")
+	}
+	if opts == nil {
+		opts = &DeleteMatchingOptions{}
+	}
+	sets, err := ops.Enumerate(nil, labels, "")
+	if err != nil {
+		return nil, err
+	}
+	var results []*VolumeDeleteResult
+	for _, vols := range sets {
+		for _, vol := range vols {
+			if !HasLabels(vol.Labels, labels) {
+				continue
+			}
+			results = append(results, &VolumeDeleteResult{
+				VolumeID:   vol.ID,
+				AttachedTo: attachedInstances(vol),
+			})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].VolumeID < results[j].VolumeID })
+	for _, r := range results {
+		r.Skipped = len(r.AttachedTo) > 0 && !opts.Detach
+	}
+	if dryRun {
+		return results, nil
+	}
+
+	ForEachParallel(len(results), opts.Parallelism, func(i int) {
+		r := results[i]
+		if r.Skipped {
+			return
+		}
+		for _, instanceID := range r.AttachedTo {
+			if r.Err = ops.DetachFrom(r.VolumeID, instanceID); r.Err != nil {
+				return
+			}
+		}
+		r.Err = ops.Delete(r.VolumeID)
+	})
+	return results, nil
+}
+
+// attachedInstances returns the instances the given volume is attached to,
+// without those of attachments that are detached already
+func attachedInstances(vol *Volume) []string {
+	if len(vol.Attachments) == 0 {
+		return vol.AttachedTo
+	}
+	var instances []string
+	for _, a := range vol.Attachments {
+		if a.State != attachmentStateDetached {
+			instances = append(instances, a.InstanceID)
+		}
+	}
+	return instances
+}
@@ -0,0 +1,139 @@
+package storageops
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DetectTimeout is how long a provider probe may take, e.g. waiting for a
+// metadata endpoint that does not exist outside of its cloud
+var DetectTimeout = 2 * time.Second
+
+// Detector detects whether this instance runs in a provider's environment
+// and creates its driver
+type Detector struct {
+	// Probe returns nil if the instance runs in the provider's environment
+	// or an error describing why it does not
+	Probe func() error
+	// New creates the driver of the provider for this instance
+	New func() (Ops, error)
+}
+
+var (
+	detectorsLock sync.Mutex
+	detectors     = make(map[string]*Detector)
+)
+
+// RegisterDetector registers the detector of the driver with the given
+// name. Drivers register theirs on init, so AutoDetect only considers the
+// drivers imported by the binary.
+func RegisterDetector(name string, d *Detector) {
+	detectorsLock.Lock()
+	defer detectorsLock.Unlock()
+	detectors[name] = d
+}
+
+// ErrNoProviderDetected is returned by AutoDetect if none of the probed
+// providers matched the environment
+type ErrNoProviderDetected struct {
+	// Probes are the reasons each provider did not match keyed by its name
+	Probes map[string]error
+}
+
+func (e *ErrNoProviderDetected) Error() string {
+	if len(e.Probes) == 0 {
+		return "no storage provider detected: no providers registered"
+	}
+	names := make([]string, 0, len(e.Probes))
+	for name := range e.Probes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	probes := make([]string, len(names))
+	for i, name := range names {
+		probes[i] = fmt.Sprintf("%s: %v", name, e.Probes[name])
+	}
+	return "no storage provider detected, probed " + strings.Join(probes, "; ")
+}
+
+// AutoDetect probes the environment for all registered providers at once and
+// creates the driver of the one that matches. If several match, the first by
+// name is used.
+func AutoDetect() (Ops, error) {
+	detectorsLock.Lock()
+	names := make([]string, 0, len(detectors))
+	probed := make([]*Detector, 0, len(detectors))
+	for name := range detectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		probed = append(probed, detectors[name])
+	}
+	detectorsLock.Unlock()
+
+	results := make([]error, len(probed))
+	var wg sync.WaitGroup
+	for i, d := range probed {
+		wg.Add(1)
+		go func(i int, d *Detector) {
+			defer wg.Done()
+			results[i] = d.Probe()
+		}(i, d)
+	}
+	wg.Wait()
+
+	notDetected := &ErrNoProviderDetected{Probes: make(map[string]error, len(names))}
+	for i, name := range names {
+		if results[i] != nil {
+			notDetected.Probes[name] = results[i]
+			continue
+		}
+		ops, err := probed[i].New()
+		if err != nil {
+			return nil, fmt.Errorf("detected %s but failed to create its driver: %v", name, err)
+		}
+		return ops, nil
+	}
+	return nil, notDetected
+}
+
+// ProbeHTTP returns nil if a GET of the given URL with the given headers
+// succeeds within DetectTimeout
+func ProbeHTTP(url string, headers map[string]string) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	client := &http.Client{Timeout: DetectTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("metadata service not reachable: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("metadata service returned %s", resp.Status)
+	}
+	return nil
+}
+
+func init() {
+	// There is no azure driver, detect it anyway so the caller learns why
+	// nothing could be created instead of a generic failure
+	RegisterDetector("azure", &Detector{
+		Probe: func() error {
+			return ProbeHTTP("http://169.254.169.254/metadata/instance?api-version=2017-08-01",
+				map[string]string{"Metadata": "true"})
+		},
+		New: func() (Ops, error) {
+			return nil, ErrNotSupported
+		},
+	})
+}
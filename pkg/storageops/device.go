@@ -0,0 +1,288 @@
+package storageops
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// BusyDevicePolicy is what to do when a device that is being detached is
+// still in use on this host
+type BusyDevicePolicy string
+
+const (
+	// BusyDeviceIgnore detaches without checking if the device is in use
+	BusyDeviceIgnore BusyDevicePolicy = ""
+	// BusyDeviceWait waits for the device to be released before detaching and
+	// fails if it is still in use after the timeout
+	BusyDeviceWait BusyDevicePolicy = "wait"
+	// BusyDeviceFail fails the detach right away with the details of the users
+	BusyDeviceFail BusyDevicePolicy = "fail"
+	// BusyDeviceForce waits for the device to be released and force detaches
+	// it if it is still in use after the timeout
+	BusyDeviceForce BusyDevicePolicy = "force"
+)
+
+// DeviceUsage describes what is using a block device on this host
+type DeviceUsage struct {
+	// Mounts are the mount points of the device or its partitions
+	Mounts []string
+	// Holders are the devices stacked on top of it, e.g. device mapper or md
+	Holders []string
+	// Pids are the processes with the device or its partitions open
+	Pids []int
+}
+
+// Busy returns true if the device is in use
+func (u *DeviceUsage) Busy() bool {
+	return len(u.Mounts) > 0 || len(u.Holders) > 0 || len(u.Pids) > 0
+}
+
+func (u *DeviceUsage) String() string {
+	return fmt.Sprintf("mounts: %v holders: %v pids: %v", u.Mounts, u.Holders, u.Pids)
+}
+
+// GetDeviceUsage inspects /proc and /sys for mounts, holders and open file
+// handles on the given device and its partitions. Mounts are read from the
+// mount namespaces of this process and of PID 1, so that a containerized
+// caller sees the mounts of the host if it shares its PID namespace. Mounts
+// that are only in the namespaces of other processes are not seen, their
+// open file handles are.
+func GetDeviceUsage(devicePath string) (*DeviceUsage, error) {
+	devicePath, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return nil, err
+	}
+	devs, err := deviceNumbers(devicePath)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &DeviceUsage{}
+	if usage.Mounts, err = deviceMounts(devs); err != nil {
+		return nil, err
+	}
+
+	name := filepath.Base(devicePath)
+	if holders, err := ioutil.ReadDir(filepath.Join("/sys/class/block", name, "holders")); err == nil {
+		for _, h := range holders {
+			usage.Holders = append(usage.Holders, h.Name())
+		}
+	}
+
+	procs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range procs {
+		pid, err := strconv.Atoi(p.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join("/proc", p.Name(), "fd")
+		fds, err := ioutil.ReadDir(fdDir)
+		if err != nil {
+			// process exited or is not accessible
+			continue
+		}
+		for _, fd := range fds {
+			var st syscall.Stat_t
+			if err := syscall.Stat(filepath.Join(fdDir, fd.Name()), &st); err != nil {
+				continue
+			}
+			if st.Mode&syscall.S_IFMT == syscall.S_IFBLK && devs[uint64(st.Rdev)] != "" {
+				usage.Pids = append(usage.Pids, pid)
+				break
+			}
+		}
+	}
+	return usage, nil
+}
+
+// deviceNumbers returns the device numbers of the given device and its
+// partitions mapped to "major:minor"
+func deviceNumbers(devicePath string) (map[uint64]string, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(devicePath, &st); err != nil {
+		return nil, err
+	}
+	devs := map[uint64]string{uint64(st.Rdev): majorMinor(uint64(st.Rdev))}
+
+	name := filepath.Base(devicePath)
+	entries, err := ioutil.ReadDir(filepath.Join("/sys/class/block", name))
+	if err != nil {
+		return devs, nil
+	}
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), name) {
+			continue
+		}
+		var st syscall.Stat_t
+		if err := syscall.Stat(filepath.Join("/dev", e.Name()), &st); err == nil {
+			devs[uint64(st.Rdev)] = majorMinor(uint64(st.Rdev))
+		}
+	}
+	return devs, nil
+}
+
+func majorMinor(dev uint64) string {
+	major := ((dev >> 8) & 0xfff) | ((dev >> 32) & 0xfffff000)
+	minor := (dev & 0xff) | ((dev >> 12) & 0xffffff00)
+	return fmt.Sprintf("%d:%d", major, minor)
+}
+
+// mountInfoPaths are the mountinfo files of the mount namespaces searched by
+// deviceMounts. All but the first may be unreadable, e.g. without
+// CAP_SYS_PTRACE.
+var mountInfoPaths = []string{"/proc/self/mountinfo", "/proc/1/mountinfo"}
+
+// deviceMounts returns the mount points of the given devices from the
+// mountinfo of mountInfoPaths
+func deviceMounts(devs map[uint64]string) ([]string, error) {
+	numbers := make(map[string]bool)
+	for _, mm := range devs {
+		numbers[mm] = true
+	}
+
+	var mounts []string
+	seen := make(map[string]bool)
+	for i, path := range mountInfoPaths {
+		found, err := readMountInfo(path, numbers)
+		if err != nil {
+			if i == 0 {
+				return nil, err
+			}
+			continue
+		}
+		for _, m := range found {
+			if !seen[m] {
+				seen[m] = true
+				mounts = append(mounts, m)
+			}
+		}
+	}
+	return mounts, nil
+}
+
+// readMountInfo returns the mount points of the given mountinfo file whose
+// "major:minor" device number is one of the given numbers
+func readMountInfo(path string, numbers map[string]bool) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mounts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 4 && numbers[fields[2]] {
+			mounts = append(mounts, fields[4])
+		}
+	}
+	return mounts, scanner.Err()
+}
+
+// deviceUsage and deviceReleaseInterval, how often CheckDeviceBusy checks
+// if a busy device was released, are replaced by tests
+var (
+	deviceUsage           = GetDeviceUsage
+	deviceReleaseInterval = ProviderOpsRetryInterval
+)
+
+// CheckDeviceBusy applies the given policy to a device that is about to be
+// detached. It returns true if the detach should be forced.
+func CheckDeviceBusy(
+	devicePath string,
+	policy BusyDevicePolicy,
+	timeout time.Duration,
+) (bool, error) {
+	if policy == BusyDeviceIgnore {
+		return false, nil
+	}
+
+	usage, err := deviceUsage(devicePath)
+	if err != nil {
+		return false, err
+	}
+	if !usage.Busy() {
+		return false, nil
+	}
+	if policy == BusyDeviceFail {
+		return false, NewStorageError(ErrDeviceBusy,
+			fmt.Sprintf("device %s is in use: %v", devicePath, usage), "")
+	}
+
+	_, err = RetryWithTimeout(
+		fmt.Sprintf("wait for device %s to be released", devicePath),
+		func() (interface{}, bool, error) {
+			usage, err := deviceUsage(devicePath)
+			if err != nil {
+				return nil, false, err
+			}
+			if usage.Busy() {
+				return nil, true, fmt.Errorf("device %s is in use: %v", devicePath, usage)
+			}
+			return nil, false, nil
+		},
+		timeout,
+		deviceReleaseInterval)
+	if err == nil {
+		return false, nil
+	}
+	if policy == BusyDeviceForce {
+		return true, nil
+	}
+	return false, NewStorageError(ErrDeviceBusy, err.Error(), "")
+}
+
+// DeviceResolver is implemented by storage operations drivers that can map a
+// local block device back to the volume attached at it
+type DeviceResolver interface {
+	// VolumeFromDevicePath returns the volume attached to this instance at
+	// the given device path, which may be a symlink or a partition
+	VolumeFromDevicePath(devicePath string) (*Volume, error)
+}
+
+// partitionRegex matches the partitions of SCSI, Xen, virtio and NVMe disks,
+// e.g. sda1, xvdf1, vdb2 and nvme1n1p1. Devices whose names end in a number
+// without being partitions, e.g. loop0, md0 or nbd1, do not match.
+var partitionRegex = regexp.MustCompile(`^(nvme\d+n\d+)p\d+$|^((?:s|xv|v)d[a-z]+)\d+$`)
+
+// BaseDevice resolves the symlinks of the given device path and strips the
+// partition, e.g. /dev/disk/by-id/<id>-part1 becomes /dev/nvme1n1
+func BaseDevice(devicePath string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return "", err
+	}
+	dir, name := filepath.Split(resolved)
+	if m := partitionRegex.FindStringSubmatch(name); m != nil {
+		name = m[1] + m[2]
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// VolumeIDFromMappings returns the volume of the given device in the device
+// mappings returned by Ops.DeviceMappings
+func VolumeIDFromMappings(mappings map[string]string, devicePath string) (string, error) {
+	for path, volumeID := range mappings {
+		if path == devicePath {
+			return volumeID, nil
+		}
+		if base, err := BaseDevice(path); err == nil && base == devicePath {
+			return volumeID, nil
+		}
+	}
+	return "", NewStorageError(ErrInvalidDevicePath,
+		fmt.Sprintf("no volume is attached at %s", devicePath), "")
+}
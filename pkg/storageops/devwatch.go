@@ -0,0 +1,173 @@
+package storageops
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultDeviceWatchInterval is how often WatchDeviceMappings polls the
+	// device mappings when no device changes are seen
+	DefaultDeviceWatchInterval = 30 * time.Second
+	// DefaultDeviceWatchSettle is how long WatchDeviceMappings waits after a
+	// device change under /dev before polling, so that the changes of an
+	// attach, i.e. the device node and its udev symlinks, are seen at once
+	DefaultDeviceWatchSettle = time.Second
+)
+
+// DeviceMappingEventType is a change of the device mappings of an instance
+type DeviceMappingEventType string
+
+const (
+	// DeviceAdded is sent when a volume shows up at a device
+	DeviceAdded DeviceMappingEventType = "added"
+	// DeviceRemoved is sent when the device of a volume disappears
+	DeviceRemoved DeviceMappingEventType = "removed"
+	// DeviceMoved is sent when a volume shows up at another device, e.g.
+	// when NVMe devices are renumbered after a reboot
+	DeviceMoved DeviceMappingEventType = "moved"
+)
+
+// DeviceMappingEvent is a change of the device of a volume
+type DeviceMappingEvent struct {
+	// Type of the change
+	Type DeviceMappingEventType
+	// VolumeID of the volume
+	VolumeID string
+	// DevicePath of the volume, the removed device if it was removed
+	DevicePath string
+	// OldDevicePath is the previous device of moved volumes
+	OldDevicePath string
+	// Time the change was seen
+	Time time.Time
+}
+
+// DeviceWatchOptions configure WatchDeviceMappings
+type DeviceWatchOptions struct {
+	// Interval between polls, defaults to DefaultDeviceWatchInterval
+	Interval time.Duration
+	// Settle is the delay between a device change and the poll it
+	// triggers, defaults to DefaultDeviceWatchSettle
+	Settle time.Duration
+	// DeviceDirs are watched with inotify for added and removed devices,
+	// defaults to /dev and /dev/disk/by-id
+	DeviceDirs []string
+}
+
+// WatchDeviceMappings sends the changes of the device mappings of the
+// instance of the driver on the returned channel until the context is done,
+// when the channel is closed. The current mappings are sent first as added
+// devices. Mappings are polled at the interval of the options and whenever
+// a device is added or removed under the device directories. Failed polls
+// are logged and retried at the next trigger. Watching devices is only
+// supported on linux, elsewhere ErrNotSupported is returned.
+//
+// The device mappings of drivers caching the instance description, such as
+// aws, may lag behind attaches by other processes by the cache lifetime.
+func WatchDeviceMappings(ctx context.Context, ops Ops, opts *DeviceWatchOptions) (<-chan *DeviceMappingEvent, error) {
+	if opts == nil {
+		opts = &DeviceWatchOptions{}
+	}
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = DefaultDeviceWatchInterval
+	}
+	settle := opts.Settle
+	if settle <= 0 {
+		settle = DefaultDeviceWatchSettle
+	}
+	dirs := opts.DeviceDirs
+	if len(dirs) == 0 {
+		dirs = []string{"/dev", "/dev/disk/by-id"}
+	}
+
+	changed := make(chan struct{}, 1)
+	if err := watchDeviceDirs(ctx, dirs, func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	events := make(chan *DeviceMappingEvent, 16)
+	go func() {
+		defer close(events)
+		var current map[string]string
+		poll := func() bool {
+			mappings, err := ops.DeviceMappings()
+			if err != nil {
+				logrus.Warnf("failed to get the device mappings of %s: %v", ops.InstanceID(), err)
+				return true
+			}
+			for _, e := range diffDeviceMappings(current, mappings) {
+				select {
+				case events <- e:
+				case <-ctx.Done():
+					return false
+				}
+			}
+			current = mappings
+			return true
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for poll() {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-changed:
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(settle):
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// diffDeviceMappings returns the changes from the old to the current device
+// mappings in the order of the volume IDs
+func diffDeviceMappings(old, current map[string]string) []*DeviceMappingEvent {
+	oldPaths := make(map[string]string, len(old))
+	for path, volumeID := range old {
+		oldPaths[volumeID] = path
+	}
+	newPaths := make(map[string]string, len(current))
+	for path, volumeID := range current {
+		newPaths[volumeID] = path
+	}
+
+	now := time.Now()
+	var events []*DeviceMappingEvent
+	for volumeID, path := range newPaths {
+		oldPath, ok := oldPaths[volumeID]
+		switch {
+		case !ok:
+			events = append(events, &DeviceMappingEvent{
+				Type: DeviceAdded, VolumeID: volumeID, DevicePath: path, Time: now,
+			})
+		case oldPath != path:
+			events = append(events, &DeviceMappingEvent{
+				Type: DeviceMoved, VolumeID: volumeID, DevicePath: path, OldDevicePath: oldPath, Time: now,
+			})
+		}
+	}
+	for volumeID, path := range oldPaths {
+		if _, ok := newPaths[volumeID]; !ok {
+			events = append(events, &DeviceMappingEvent{
+				Type: DeviceRemoved, VolumeID: volumeID, DevicePath: path, Time: now,
+			})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].VolumeID < events[j].VolumeID })
+	return events
+}
@@ -0,0 +1,65 @@
+//go:build linux
+// +build linux
+
+package storageops
+
+import (
+	"context"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// devWatchPollTimeout is how often the inotify loop checks if its context is
+// done, in milliseconds
+const devWatchPollTimeout = 500
+
+// watchDeviceDirs calls changed whenever an entry is added to or removed
+// from one of the given directories, until the context is done. Directories
+// that do not exist are skipped.
+func watchDeviceDirs(ctx context.Context, dirs []string, changed func()) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+	watched := 0
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			continue
+		}
+		mask := uint32(unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO)
+		if _, err := unix.InotifyAddWatch(fd, dir, mask); err != nil {
+			unix.Close(fd)
+			return err
+		}
+		watched++
+	}
+	if watched == 0 {
+		unix.Close(fd)
+		return nil
+	}
+
+	go func() {
+		defer unix.Close(fd)
+		buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		for ctx.Err() == nil {
+			n, err := unix.Poll(fds, devWatchPollTimeout)
+			if err == unix.EINTR || n == 0 {
+				continue
+			}
+			if err != nil {
+				logrus.Warnf("failed to watch devices: %v", err)
+				return
+			}
+			// Events are only a trigger to poll, their details do not matter
+			if _, err := unix.Read(fd, buf); err != nil && err != unix.EAGAIN {
+				logrus.Warnf("failed to watch devices: %v", err)
+				return
+			}
+			changed()
+		}
+	}()
+	return nil
+}
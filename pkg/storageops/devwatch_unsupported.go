@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package storageops
+
+import "context"
+
+// watchDeviceDirs is not supported without inotify
+func watchDeviceDirs(ctx context.Context, dirs []string, changed func()) error {
+	return ErrNotSupported
+}
@@ -0,0 +1,112 @@
+package digitalocean
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/digitalocean/godo"
+	"golang.org/x/oauth2"
+)
+
+// listPageSize is the number of items requested per page of list calls
+const listPageSize = 200
+
+// Action statuses
+const (
+	actionInProgress = "in-progress"
+	actionCompleted  = "completed"
+	actionErrored    = "errored"
+)
+
+// newGodoClient returns a godo client of the given endpoint, or of the
+// DigitalOcean API if empty, that authenticates the requests of the given
+// HTTP client with the given API token
+func newGodoClient(token, endpoint string, httpClient *http.Client) (*godo.Client, error) {
+	authenticated := *httpClient
+	authenticated.Transport = &oauth2.Transport{
+		Source: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}),
+		Base:   httpClient.Transport,
+	}
+	var opts []godo.ClientOpt
+	if len(endpoint) > 0 {
+		opts = append(opts, godo.SetBaseURL(strings.TrimSuffix(endpoint, "/")+"/"))
+	}
+	return godo.New(&authenticated, opts...)
+}
+
+// listVolumes calls fn with every volume of the given region one page at a
+// time. It stops when fn returns false.
+func (s *doOps) listVolumes(region string, fn func(vol *godo.Volume) bool) error {
+	opt := &godo.ListOptions{Page: 1, PerPage: listPageSize}
+	for {
+		vols, resp, err := s.client.Storage.ListVolumes(context.Background(),
+			&godo.ListVolumeParams{Region: region, ListOptions: opt})
+		if err != nil {
+			return err
+		}
+		for i := range vols {
+			if !fn(&vols[i]) {
+				return nil
+			}
+		}
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			return nil
+		}
+		opt.Page++
+	}
+}
+
+// listSnapshots calls fn with every volume snapshot one page at a time. It
+// stops when fn returns false.
+func (s *doOps) listSnapshots(fn func(snap *godo.Snapshot) bool) error {
+	opt := &godo.ListOptions{Page: 1, PerPage: listPageSize}
+	for {
+		snaps, resp, err := s.client.Snapshots.ListVolume(context.Background(), opt)
+		if err != nil {
+			return err
+		}
+		for i := range snaps {
+			if !fn(&snaps[i]) {
+				return nil
+			}
+		}
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			return nil
+		}
+		opt.Page++
+	}
+}
+
+// tagResource applies the given tag to the given volume or snapshot,
+// creating the tag if it does not exist yet
+func (s *doOps) tagResource(tag, resourceID string, resourceType godo.ResourceType) error {
+	_, _, err := s.client.Tags.Create(context.Background(), &godo.TagCreateRequest{Name: tag})
+	if statusCode(err) == http.StatusUnprocessableEntity {
+		// The tag already exists
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Tags.TagResources(context.Background(), tag, &godo.TagResourcesRequest{
+		Resources: []godo.Resource{{ID: resourceID, Type: resourceType}},
+	})
+	return err
+}
+
+func (s *doOps) untagResource(tag, resourceID string, resourceType godo.ResourceType) error {
+	_, err := s.client.Tags.UntagResources(context.Background(), tag, &godo.UntagResourcesRequest{
+		Resources: []godo.Resource{{ID: resourceID, Type: resourceType}},
+	})
+	return err
+}
+
+// statusCode returns the HTTP status of the given godo API error, or 0 if it
+// is not one
+func statusCode(err error) int {
+	if errResp, ok := err.(*godo.ErrorResponse); ok && errResp.Response != nil {
+		return errResp.Response.StatusCode
+	}
+	return 0
+}
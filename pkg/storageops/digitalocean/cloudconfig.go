@@ -0,0 +1,44 @@
+package digitalocean
+
+import (
+	"fmt"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/libopenstorage/openstorage/pkg/storageops/config"
+)
+
+func init() {
+	config.Register("digitalocean", newFromCloudConfig)
+}
+
+// newFromCloudConfig creates the driver of the given cloud config with the
+// API token of its token credential, or of DIGITALOCEAN_TOKEN if not set. The droplet and region are read from
+// the droplet metadata service unless set, the endpoint param replaces the
+// API endpoint.
+func newFromCloudConfig(c *config.Config) (storageops.Ops, error) {
+	token, err := c.RequiredCredential("token", "DIGITALOCEAN_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	cfg := Config{Endpoint: c.Params["endpoint"]}
+	if cfg.RetryPolicy, err = c.RetryPolicy(); err != nil {
+		return nil, err
+	}
+	if cfg.HTTP, err = c.HTTPConfig(); err != nil {
+		return nil, err
+	}
+
+	dropletID := c.Instance
+	if len(dropletID) == 0 {
+		if dropletID, err = metadata("id"); err != nil {
+			return nil, fmt.Errorf("error fetching droplet ID. Err: %v", err)
+		}
+	}
+	region := c.Region
+	if len(region) == 0 {
+		if region, err = metadata("region"); err != nil {
+			return nil, fmt.Errorf("error fetching droplet region. Err: %v", err)
+		}
+	}
+	return NewClient(token, dropletID, region, cfg)
+}
@@ -0,0 +1,111 @@
+package digitalocean
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/pborman/uuid"
+)
+
+func init() {
+	storageops.RegisterConverter("digitalocean", &converter{})
+	storageops.RegisterSizeRule("digitalocean", &storageops.SizeRule{
+		Granularity: storageops.GiB,
+		Min:         storageops.GiB,
+		Max:         16 * storageops.TiB,
+	})
+}
+
+type converter struct{}
+
+func (c *converter) ToVolume(raw interface{}) (*storageops.Volume, error) {
+	vol, ok := raw.(*godo.Volume)
+	if !ok {
+		return nil, fmt.Errorf("invalid digitalocean volume %T", raw)
+	}
+	return toVolume(vol), nil
+}
+
+func toVolume(vol *godo.Volume) *storageops.Volume {
+	v := &storageops.Volume{
+		ID:      vol.ID,
+		Name:    vol.Name,
+		SizeGiB: uint64(vol.SizeGigaBytes),
+		// All volumes are encrypted at rest
+		Encrypted: true,
+		Labels:    tagsToLabels(vol.Tags),
+		Raw:       vol,
+	}
+	if vol.Region != nil {
+		v.Zone = vol.Region.Slug
+	}
+	if len(vol.DropletIDs) > 0 {
+		v.State = "attached"
+	} else {
+		v.State = "available"
+	}
+	for _, id := range vol.DropletIDs {
+		v.AttachedTo = append(v.AttachedTo, strconv.Itoa(id))
+		v.Attachments = append(v.Attachments, storageops.Attachment{InstanceID: strconv.Itoa(id)})
+	}
+	return v
+}
+
+// FromSpec returns the *godo.VolumeCreateRequest template of the given spec. The
+// zone is the region, volumes are always encrypted at rest and volumes
+// without a name in the native template are named after a random UUID.
+func (c *converter) FromSpec(spec *storageops.VolumeSpec) (interface{}, error) {
+	if err := spec.Unsupported("digitalocean", storageops.SpecFieldType, storageops.SpecFieldIops,
+		storageops.SpecFieldThroughput, storageops.SpecFieldKMSKey); err != nil {
+		return nil, err
+	}
+	req := &godo.VolumeCreateRequest{}
+	if spec.Native != nil {
+		native, ok := spec.Native.(*godo.VolumeCreateRequest)
+		if !ok {
+			return nil, storageops.NativeTemplateError("digitalocean", spec.Native)
+		}
+		copied := *native
+		req = &copied
+	}
+	if len(req.Name) == 0 {
+		req.Name = "volume-" + uuid.New()
+	}
+	if spec.SizeGiB > 0 {
+		req.SizeGigaBytes = int64(spec.SizeGiB)
+	}
+	if len(spec.Zone) > 0 {
+		req.Region = spec.Zone
+	}
+	if len(spec.SnapshotID) > 0 {
+		req.SnapshotID = spec.SnapshotID
+	}
+	return req, nil
+}
+
+func (c *converter) ToSnapshot(raw interface{}) (*storageops.Snapshot, error) {
+	snap, ok := raw.(*godo.Snapshot)
+	if !ok {
+		return nil, fmt.Errorf("invalid digitalocean snapshot %T", raw)
+	}
+	return toSnapshot(snap)
+}
+
+func toSnapshot(snap *godo.Snapshot) (*storageops.Snapshot, error) {
+	created, err := time.Parse(time.RFC3339, snap.Created)
+	if err != nil {
+		return nil, err
+	}
+	return &storageops.Snapshot{
+		ID:       snap.ID,
+		VolumeID: snap.ResourceID,
+		SizeGiB:  uint64(snap.MinDiskSize),
+		State:    actionCompleted,
+		Created:  created,
+		Labels:   tagsToLabels(snap.Tags),
+		Raw:      snap,
+	}, nil
+}
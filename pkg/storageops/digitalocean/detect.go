@@ -0,0 +1,14 @@
+package digitalocean
+
+import (
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+func init() {
+	storageops.RegisterDetector("digitalocean", &storageops.Detector{
+		Probe: func() error {
+			return storageops.ProbeHTTP(metadataEndpoint+"/id", nil)
+		},
+		New: NewEnvClient,
+	})
+}
@@ -0,0 +1,680 @@
+package digitalocean
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/pborman/uuid"
+)
+
+const (
+	// metadataEndpoint is the droplet metadata service
+	metadataEndpoint = "http://169.254.169.254/metadata/v1"
+)
+
+// diskByIDPrefix is the prefix of the udev symlinks of attached volumes,
+// followed by the volume name
+var diskByIDPrefix = "/dev/disk/by-id/scsi-0DO_Volume_"
+
+// tagRegex matches valid DigitalOcean tags
+var tagRegex = regexp.MustCompile(`^[a-zA-Z0-9_\-:]+$`)
+
+// Config is the optional configuration of the DigitalOcean storage ops driver
+type Config struct {
+	// RetryPolicy configures the wait loops of the driver. The default
+	// retries every storageops.ProviderOpsRetryInterval.
+	RetryPolicy *storageops.RetryPolicy
+	// HTTP configures the HTTP client of the API calls, e.g. to go through
+	// a proxy
+	HTTP *storageops.HTTPConfig
+	// Endpoint replaces the DigitalOcean API endpoint
+	Endpoint string
+}
+
+type doOps struct {
+	client    *godo.Client
+	dropletID int
+	region    string
+	cfg       Config
+	mutex     sync.Mutex
+}
+
+var _ storageops.Ops = &doOps{}
+
+// NewEnvClient creates a new DigitalOcean operations client with the API
+// token of the DIGITALOCEAN_TOKEN environment var. The droplet and region are
+// read from DO_DROPLET_ID and DO_REGION if set, or else from the droplet
+// metadata service.
+func NewEnvClient() (storageops.Ops, error) {
+	token, err := storageops.GetEnvValueStrict("DIGITALOCEAN_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	httpCfg, err := storageops.HTTPConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	dropletID, err := storageops.GetEnvValueStrict("DO_DROPLET_ID")
+	if err != nil {
+		if dropletID, err = metadata("id"); err != nil {
+			return nil, fmt.Errorf("error fetching droplet ID. Err: %v", err)
+		}
+	}
+	region, err := storageops.GetEnvValueStrict("DO_REGION")
+	if err != nil {
+		if region, err = metadata("region"); err != nil {
+			return nil, fmt.Errorf("error fetching droplet region. Err: %v", err)
+		}
+	}
+	return NewClient(token, dropletID, region, Config{HTTP: httpCfg})
+}
+
+// NewClient creates a new DigitalOcean operations client for the given
+// droplet in the given region, authenticated with the given API token
+func NewClient(token, dropletID, region string, cfg Config) (storageops.Ops, error) {
+	if err := cfg.RetryPolicy.Validate(); err != nil {
+		return nil, err
+	}
+	id, err := strconv.Atoi(dropletID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid droplet ID %q: %v", dropletID, err)
+	}
+	httpClient, err := cfg.HTTP.Client()
+	if err != nil {
+		return nil, err
+	}
+	client, err := newGodoClient(token, cfg.Endpoint, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	return &doOps{
+		client:    client,
+		dropletID: id,
+		region:    region,
+		cfg:       cfg,
+	}, nil
+}
+
+// metadata returns the given droplet metadata, e.g. id or region
+func metadata(key string) (string, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(metadataEndpoint + "/" + key)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata service returned %s for %s", resp.Status, key)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (s *doOps) Name() string { return "digitalocean" }
+
+func (s *doOps) InstanceID() string { return strconv.Itoa(s.dropletID) }
+
+// GetZone returns the region of the droplet, DigitalOcean regions have no
+// zones
+func (s *doOps) GetZone() (string, error) { return s.region, nil }
+
+func (s *doOps) GetRegion() (string, error) { return s.region, nil }
+
+// ListZones returns the region of the droplet, as volumes can only be
+// attached to droplets of their region
+func (s *doOps) ListZones() ([]string, error) { return []string{s.region}, nil }
+
+// labelsToTags returns the tags of the given labels, key:value or key for
+// empty values
+func labelsToTags(labels map[string]string) ([]string, error) {
+	tags := make([]string, 0, len(labels))
+	for k, v := range labels {
+		tag := k
+		if len(v) > 0 {
+			tag = k + ":" + v
+		}
+		if !tagRegex.MatchString(tag) {
+			return nil, storageops.NewStorageError(storageops.ErrVolInval,
+				fmt.Sprintf("label %s=%s is not a valid tag, tags may only contain "+
+					"letters, numbers, colons, dashes and underscores", k, v), "")
+		}
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// tagsToLabels returns the labels of the given tags, see labelsToTags
+func tagsToLabels(tags []string) map[string]string {
+	labels := make(map[string]string)
+	for _, tag := range tags {
+		if i := strings.Index(tag, ":"); i >= 0 {
+			labels[tag[:i]] = tag[i+1:]
+		} else {
+			labels[tag] = ""
+		}
+	}
+	return labels
+}
+
+// hasLabels returns true if the given tags carry all given labels
+func hasLabels(tags []string, labels map[string]string) bool {
+	have := tagsToLabels(tags)
+	for k, v := range labels {
+		if value, ok := have[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *doOps) Create(
+	template interface{},
+	labels map[string]string,
+) (*storageops.Volume, error) {
+	template, labels, err := storageops.VolumeTemplate(s.Name(), template, labels)
+	if err != nil {
+		return nil, err
+	}
+	v, ok := template.(*godo.VolumeCreateRequest)
+	if !ok {
+		return nil, storageops.NewStorageError(storageops.ErrVolInval,
+			"Invalid volume template given", s.InstanceID())
+	}
+	tags, err := labelsToTags(labels)
+	if err != nil {
+		return nil, err
+	}
+	req := *v
+	if len(req.Region) == 0 {
+		req.Region = s.region
+	}
+	if len(req.Description) == 0 {
+		req.Description = "Volume created by openstorage"
+	}
+	req.Tags = append(append([]string{}, v.Tags...), tags...)
+
+	vol, _, err := s.client.Storage.CreateVolume(context.Background(), &req)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	return toVolume(vol), nil
+}
+
+func (s *doOps) GetDeviceID(template interface{}) (string, error) {
+	if id, ok := storageops.TypedID(template); ok {
+		return id, nil
+	}
+	switch v := template.(type) {
+	case *godo.Volume:
+		return v.ID, nil
+	case *godo.Snapshot:
+		return v.ID, nil
+	}
+	return "", fmt.Errorf("invalid type: %v given to GetDeviceID", template)
+}
+
+func (s *doOps) volume(volumeID string) (*godo.Volume, error) {
+	vol, _, err := s.client.Storage.GetVolume(context.Background(), volumeID)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	return vol, nil
+}
+
+// waitForAction waits for the given volume action to complete
+func (s *doOps) waitForAction(volumeID string, action *godo.Action, op string) error {
+	_, err := s.cfg.RetryPolicy.For(op).Retry(
+		fmt.Sprintf("wait for %s of volume %s", action.Type, volumeID),
+		func() (interface{}, bool, error) {
+			a, _, err := s.client.StorageActions.Get(context.Background(), volumeID, action.ID)
+			if err != nil {
+				return nil, true, s.storageError(err)
+			}
+			switch a.Status {
+			case actionCompleted:
+				return nil, false, nil
+			case actionErrored:
+				return nil, false, fmt.Errorf("%s of volume %s failed", a.Type, volumeID)
+			}
+			return nil, true, fmt.Errorf("%s of volume %s is %s", a.Type, volumeID, a.Status)
+		})
+	return err
+}
+
+func (s *doOps) Expand(volumeID string, newSizeGiB uint64) (uint64, error) {
+	vol, err := s.volume(volumeID)
+	if err != nil {
+		return 0, err
+	}
+	currentSize := uint64(vol.SizeGigaBytes)
+	if newSizeGiB == currentSize {
+		return currentSize, nil
+	}
+	if newSizeGiB < currentSize {
+		return 0, storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("volume %s of %d GiB cannot be shrunk to %d GiB",
+				volumeID, currentSize, newSizeGiB), s.InstanceID())
+	}
+	action, _, err := s.client.StorageActions.Resize(context.Background(), volumeID,
+		int(newSizeGiB), vol.Region.Slug)
+	if err != nil {
+		return 0, s.storageError(err)
+	}
+	if err := s.waitForAction(volumeID, action, storageops.RetryOpExpand); err != nil {
+		return 0, err
+	}
+	return newSizeGiB, nil
+}
+
+// Modify is not supported, DigitalOcean volumes have a single type with
+// fixed performance
+func (s *doOps) Modify(volumeID string, spec storageops.VolumeSpecUpdate) error {
+	return storageops.ErrNotSupported
+}
+
+func (s *doOps) Attach(volumeID string, options map[string]string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	vol, err := s.volume(volumeID)
+	if err != nil {
+		return "", err
+	}
+	for _, id := range vol.DropletIDs {
+		if id == s.dropletID {
+			return s.devicePath(vol)
+		}
+	}
+	if len(vol.DropletIDs) > 0 {
+		return "", storageops.NewStorageError(storageops.ErrVolAlreadyAttached,
+			fmt.Sprintf("volume %s is already attached to droplet %v", volumeID, vol.DropletIDs),
+			s.InstanceID())
+	}
+
+	action, _, err := s.client.StorageActions.Attach(context.Background(), volumeID, s.dropletID)
+	if err != nil {
+		return "", s.storageError(err)
+	}
+	if err := s.waitForAction(volumeID, action, storageops.RetryOpAttach); err != nil {
+		return "", err
+	}
+
+	devicePath, err := s.cfg.RetryPolicy.For(storageops.RetryOpAttach).Retry(
+		fmt.Sprintf("wait for device of volume %s", volumeID),
+		func() (interface{}, bool, error) {
+			devicePath, err := s.devicePath(vol)
+			return devicePath, err != nil, err
+		})
+	if err != nil {
+		return "", err
+	}
+	return devicePath.(string), nil
+}
+
+func (s *doOps) Detach(volumeID string) error {
+	return s.detachInternal(volumeID, s.dropletID)
+}
+
+func (s *doOps) DetachFrom(volumeID, instanceID string) error {
+	id, err := strconv.Atoi(instanceID)
+	if err != nil {
+		return storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("invalid droplet ID %q", instanceID), s.InstanceID())
+	}
+	return s.detachInternal(volumeID, id)
+}
+
+func (s *doOps) detachInternal(volumeID string, dropletID int) error {
+	action, _, err := s.client.StorageActions.DetachByDropletID(context.Background(),
+		volumeID, dropletID)
+	if err != nil {
+		return s.storageError(err)
+	}
+	return s.waitForAction(volumeID, action, storageops.RetryOpDetach)
+}
+
+func (s *doOps) Delete(volumeID string) error {
+	_, err := s.client.Storage.DeleteVolume(context.Background(), volumeID)
+	return s.storageError(err)
+}
+
+func (s *doOps) DeleteFrom(volumeID, _ string) error {
+	return s.Delete(volumeID)
+}
+
+// Describe returns the *godo.Droplet of the instance
+func (s *doOps) Describe() (interface{}, error) {
+	droplet, _, err := s.client.Droplets.Get(context.Background(), s.dropletID)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	return droplet, nil
+}
+
+// FreeDevices is not supported, volumes are exposed by name and not at a
+// device chosen by the caller
+func (s *doOps) FreeDevices(
+	blockDeviceMappings []interface{},
+	rootDeviceName string,
+) ([]string, error) {
+	return nil, storageops.ErrNotSupported
+}
+
+func (s *doOps) Inspect(volumeIds []*string) ([]*storageops.Volume, error) {
+	var vols []*storageops.Volume
+	for _, id := range volumeIds {
+		vol, err := s.volume(*id)
+		if err != nil {
+			return nil, err
+		}
+		vols = append(vols, toVolume(vol))
+	}
+	return vols, nil
+}
+
+func (s *doOps) DeviceMappings() (map[string]string, error) {
+	droplet, _, err := s.client.Droplets.Get(context.Background(), s.dropletID)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	m := make(map[string]string)
+	for _, id := range droplet.VolumeIDs {
+		vol, err := s.volume(id)
+		if err != nil {
+			return nil, err
+		}
+		devPath, err := s.devicePath(vol)
+		if err != nil {
+			return nil, err
+		}
+		m[devPath] = vol.ID
+	}
+	return m, nil
+}
+
+func (s *doOps) Enumerate(
+	volumeIds []*string,
+	labels map[string]string,
+	setIdentifier string,
+) (map[string][]*storageops.Volume, error) {
+	sets := make(map[string][]*storageops.Volume)
+	err := s.EnumerateEach(volumeIds, labels, setIdentifier,
+		func(set string, vol *storageops.Volume) bool {
+			storageops.AddElementToMap(sets, vol, set)
+			return true
+		})
+	if err != nil {
+		return nil, err
+	}
+	return sets, nil
+}
+
+var _ storageops.StreamingEnumerator = &doOps{}
+
+// EnumerateEach calls fn with every volume of the region matching the given
+// IDs and labels one page at a time. The set of a volume is the
+// setIdentifier label key if it has it.
+func (s *doOps) EnumerateEach(
+	volumeIds []*string,
+	labels map[string]string,
+	setIdentifier string,
+	fn func(set string, vol *storageops.Volume) bool,
+) error {
+	ids := make(map[string]bool)
+	for _, id := range volumeIds {
+		ids[*id] = true
+	}
+	err := s.listVolumes(s.region, func(vol *godo.Volume) bool {
+		if len(ids) > 0 && !ids[vol.ID] {
+			return true
+		}
+		if !hasLabels(vol.Tags, labels) {
+			return true
+		}
+		set := storageops.SetIdentifierNone
+		if _, ok := tagsToLabels(vol.Tags)[setIdentifier]; ok && len(setIdentifier) > 0 {
+			set = setIdentifier
+		}
+		return fn(set, toVolume(vol))
+	})
+	return s.storageError(err)
+}
+
+func (s *doOps) DevicePath(volumeID string) (string, error) {
+	vol, err := s.volume(volumeID)
+	if err != nil {
+		return "", err
+	}
+	if len(vol.DropletIDs) == 0 {
+		return "", storageops.NewStorageError(storageops.ErrVolDetached,
+			fmt.Sprintf("Volume %s is detached", volumeID), s.InstanceID())
+	}
+	for _, id := range vol.DropletIDs {
+		if id == s.dropletID {
+			return s.devicePath(vol)
+		}
+	}
+	return "", storageops.NewStorageError(storageops.ErrVolAttachedOnRemoteNode,
+		fmt.Sprintf("volume %s is not attached on: %d (Attached on: %v)",
+			volumeID, s.dropletID, vol.DropletIDs),
+		s.InstanceID())
+}
+
+// devicePath resolves the by-id symlink of the given attached volume
+func (s *doOps) devicePath(vol *godo.Volume) (string, error) {
+	link := diskByIDPrefix + vol.Name
+	devPath, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		return "", storageops.NewStorageError(storageops.ErrInvalidDevicePath,
+			fmt.Sprintf("unable to find block dev path for %s. %v", link, err),
+			s.InstanceID())
+	}
+	return devPath, nil
+}
+
+func (s *doOps) Snapshot(volumeID string, readonly bool) (*storageops.Snapshot, error) {
+	vol, err := s.volume(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	// Snapshot names are unique per volume
+	name := fmt.Sprintf("%s-%d", vol.Name, time.Now().Unix())
+	snap, _, err := s.client.Storage.CreateSnapshot(context.Background(),
+		&godo.SnapshotCreateRequest{VolumeID: volumeID, Name: name, Tags: vol.Tags})
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	return toSnapshot(snap)
+}
+
+func (s *doOps) SnapshotDelete(snapID string) error {
+	_, err := s.client.Snapshots.Delete(context.Background(), snapID)
+	return s.storageError(err)
+}
+
+func (s *doOps) SnapshotEnumerate(
+	filter *storageops.SnapshotFilter,
+) ([]*storageops.Snapshot, error) {
+	var snaps []*storageops.Snapshot
+	var parseErr error
+	err := s.listSnapshots(func(snap *godo.Snapshot) bool {
+		typed, err := toSnapshot(snap)
+		if err != nil {
+			parseErr = err
+			return false
+		}
+		if filter != nil && !hasLabels(snap.Tags, filter.Labels) {
+			return true
+		}
+		// Snapshots are created before they are returned, so they are
+		// always completed
+		if filter.Match(typed.VolumeID, typed.Created, typed.State, typed.Labels) {
+			snaps = append(snaps, typed)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	return snaps, nil
+}
+
+// SnapshotRestore creates a volume from the given snapshot. The zone is the
+// region of the volume, since DigitalOcean regions have no zones.
+func (s *doOps) SnapshotRestore(
+	snapID, zone string,
+	labels map[string]string,
+) (*storageops.Volume, error) {
+	snap, _, err := s.client.Snapshots.Get(context.Background(), snapID)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	if len(zone) == 0 {
+		zone = s.region
+	}
+	// Volume names are at most 64 characters
+	name := snap.Name
+	if len(name) > 55 {
+		name = name[:55]
+	}
+	return s.Create(&godo.VolumeCreateRequest{
+		Name:          fmt.Sprintf("%s-%s", name, uuid.New()[:8]),
+		Region:        zone,
+		SizeGigaBytes: int64(snap.MinDiskSize),
+		SnapshotID:    snapID,
+	}, labels)
+}
+
+// SnapshotStatus returns the status of the given snapshot. DigitalOcean
+// volume snapshots are usable as soon as they exist.
+func (s *doOps) SnapshotStatus(snapID string) (*storageops.SnapshotStatus, error) {
+	snap, _, err := s.client.Snapshots.Get(context.Background(), snapID)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	return &storageops.SnapshotStatus{
+		ID:        snap.ID,
+		State:     actionCompleted,
+		Progress:  100,
+		Completed: true,
+	}, nil
+}
+
+// resource returns the tags API resource type and tags of the given
+// volume or snapshot ID
+func (s *doOps) resource(id string) (godo.ResourceType, []string, error) {
+	vol, _, err := s.client.Storage.GetVolume(context.Background(), id)
+	if err == nil {
+		return godo.VolumeResourceType, vol.Tags, nil
+	}
+	if statusCode(err) != http.StatusNotFound {
+		return "", nil, s.storageError(err)
+	}
+	snap, _, err := s.client.Snapshots.Get(context.Background(), id)
+	if err != nil {
+		return "", nil, s.storageError(err)
+	}
+	return godo.VolumeSnapshotResourceType, snap.Tags, nil
+}
+
+// ApplyTags tags the given volume or snapshot with the given labels,
+// replacing the values of labels it already has
+func (s *doOps) ApplyTags(volumeID string, labels map[string]string) error {
+	tags, err := labelsToTags(labels)
+	if err != nil {
+		return err
+	}
+	resourceType, current, err := s.resource(volumeID)
+	if err != nil {
+		return err
+	}
+	if err := s.untag(volumeID, resourceType, current, labels); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if err := s.tagResource(tag, volumeID, resourceType); err != nil {
+			return s.storageError(err)
+		}
+	}
+	return nil
+}
+
+func (s *doOps) RemoveTags(volumeID string, labels map[string]string) error {
+	resourceType, current, err := s.resource(volumeID)
+	if err != nil {
+		return err
+	}
+	return s.untag(volumeID, resourceType, current, labels)
+}
+
+// untag removes the tags of the given label keys from the given resource
+func (s *doOps) untag(
+	id string,
+	resourceType godo.ResourceType,
+	tags []string,
+	labels map[string]string,
+) error {
+	for _, tag := range tags {
+		key := tag
+		if i := strings.Index(tag, ":"); i >= 0 {
+			key = tag[:i]
+		}
+		if _, ok := labels[key]; !ok {
+			continue
+		}
+		if err := s.untagResource(tag, id, resourceType); err != nil {
+			return s.storageError(err)
+		}
+	}
+	return nil
+}
+
+func (s *doOps) Tags(volumeID string) (map[string]string, error) {
+	_, tags, err := s.resource(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	return tagsToLabels(tags), nil
+}
+
+// VolumeFromDevicePath returns the volume attached to this droplet at the
+// given device path, using the udev by-id symlinks of the attached volumes
+func (s *doOps) VolumeFromDevicePath(devicePath string) (*storageops.Volume, error) {
+	dev, err := storageops.BaseDevice(devicePath)
+	if err != nil {
+		return nil, storageops.NewStorageError(storageops.ErrInvalidDevicePath,
+			err.Error(), s.InstanceID())
+	}
+	mappings, err := s.DeviceMappings()
+	if err != nil {
+		return nil, err
+	}
+	volumeID, err := storageops.VolumeIDFromMappings(mappings, dev)
+	if err != nil {
+		return nil, err
+	}
+	vol, err := s.volume(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	return toVolume(vol), nil
+}
@@ -0,0 +1,226 @@
+package digitalocean
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/libopenstorage/openstorage/pkg/storageops/test"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAll(t *testing.T) {
+	d, err := NewEnvClient()
+	if err != nil {
+		t.Skipf("skipping DigitalOcean tests as environment is not set...\n")
+	}
+	name := fmt.Sprintf("openstorage-test-%s", uuid.New()[:8])
+	test.RunTest(map[string]storageops.Ops{d.Name(): d},
+		map[string]map[string]interface{}{d.Name(): {
+			name: &godo.VolumeCreateRequest{Name: name, SizeGigaBytes: 10},
+		}}, t)
+}
+
+// fakeAPI is an in memory DigitalOcean API of volumes, volume actions and
+// tags
+type fakeAPI struct {
+	t       *testing.T
+	volumes map[string]*godo.Volume
+	tags    []string
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	assert.Equal(f.t, "Bearer token", r.Header.Get("Authorization"))
+	var body map[string]interface{}
+	if r.Body != nil {
+		data, _ := ioutil.ReadAll(r.Body)
+		if len(data) > 0 {
+			assert.NoError(f.t, json.Unmarshal(data, &body))
+		}
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v2/"), "/")
+	reply := func(v interface{}) { assert.NoError(f.t, json.NewEncoder(w).Encode(v)) }
+
+	switch {
+	case parts[0] == "volumes" && len(parts) == 1 && r.Method == http.MethodPost:
+		vol := &godo.Volume{
+			ID:            uuid.New(),
+			Name:          body["name"].(string),
+			Region:        &godo.Region{Slug: body["region"].(string)},
+			SizeGigaBytes: int64(body["size_gigabytes"].(float64)),
+			CreatedAt:     time.Now(),
+		}
+		if tags, ok := body["tags"].([]interface{}); ok {
+			for _, tag := range tags {
+				vol.Tags = append(vol.Tags, tag.(string))
+			}
+		}
+		f.volumes[vol.ID] = vol
+		w.WriteHeader(http.StatusCreated)
+		reply(map[string]interface{}{"volume": vol})
+	case parts[0] == "volumes" && len(parts) == 1:
+		// One volume per page to exercise the pagination
+		page := 0
+		fmt.Sscan(r.URL.Query().Get("page"), &page)
+		ids := sortedKeys(f.volumes)
+		resp := map[string]interface{}{"volumes": []*godo.Volume{}}
+		if page <= len(ids) {
+			resp["volumes"] = []*godo.Volume{f.volumes[ids[page-1]]}
+		}
+		if page < len(ids) {
+			resp["links"] = map[string]interface{}{"pages": map[string]string{"next": "more"}}
+		}
+		reply(resp)
+	case parts[0] == "volumes" && len(parts) == 2:
+		vol, ok := f.volumes[parts[1]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			reply(map[string]string{"id": "not_found", "message": "volume not found"})
+			return
+		}
+		if r.Method == http.MethodDelete {
+			delete(f.volumes, parts[1])
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		reply(map[string]interface{}{"volume": vol})
+	case parts[0] == "volumes" && len(parts) == 3 && r.Method == http.MethodPost:
+		vol := f.volumes[parts[1]]
+		switch body["type"] {
+		case "attach":
+			vol.DropletIDs = []int{int(body["droplet_id"].(float64))}
+		case "detach":
+			vol.DropletIDs = nil
+		case "resize":
+			vol.SizeGigaBytes = int64(body["size_gigabytes"].(float64))
+		}
+		reply(map[string]interface{}{"action": &godo.Action{ID: 1, Status: actionInProgress,
+			Type: body["type"].(string)}})
+	case parts[0] == "volumes" && len(parts) == 4:
+		reply(map[string]interface{}{"action": &godo.Action{ID: 1, Status: actionCompleted}})
+	case parts[0] == "droplets":
+		droplet := &godo.Droplet{ID: 42}
+		for _, id := range sortedKeys(f.volumes) {
+			for _, d := range f.volumes[id].DropletIDs {
+				if d == 42 {
+					droplet.VolumeIDs = append(droplet.VolumeIDs, id)
+				}
+			}
+		}
+		reply(map[string]interface{}{"droplet": droplet})
+	case parts[0] == "tags" && len(parts) == 1:
+		f.tags = append(f.tags, body["name"].(string))
+		w.WriteHeader(http.StatusCreated)
+		reply(map[string]interface{}{"tag": &godo.Tag{Name: body["name"].(string)}})
+	case parts[0] == "tags" && len(parts) == 3:
+		res := body["resources"].([]interface{})[0].(map[string]interface{})
+		vol := f.volumes[res["resource_id"].(string)]
+		if r.Method == http.MethodPost {
+			vol.Tags = append(vol.Tags, parts[1])
+		} else {
+			for i, tag := range vol.Tags {
+				if tag == parts[1] {
+					vol.Tags = append(vol.Tags[:i], vol.Tags[i+1:]...)
+					break
+				}
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func sortedKeys(volumes map[string]*godo.Volume) []string {
+	var ids []string
+	for id := range volumes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func newFakeDO(t *testing.T) (storageops.Ops, *fakeAPI, func()) {
+	api := &fakeAPI{t: t, volumes: make(map[string]*godo.Volume)}
+	server := httptest.NewServer(api)
+	d, err := NewClient("token", "42", "nyc1", Config{
+		Endpoint:    server.URL,
+		RetryPolicy: &storageops.RetryPolicy{Interval: time.Millisecond, Timeout: time.Second},
+	})
+	assert.NoError(t, err)
+	return d, api, server.Close
+}
+
+func TestDigitalOceanVolumes(t *testing.T) {
+	d, _, done := newFakeDO(t)
+	defer done()
+
+	dir, err := ioutil.TempDir("", "digitalocean")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	prefix := diskByIDPrefix
+	diskByIDPrefix = filepath.Join(dir, "scsi-0DO_Volume_")
+	defer func() { diskByIDPrefix = prefix }()
+	device := filepath.Join(dir, "sda")
+	assert.NoError(t, ioutil.WriteFile(device, nil, 0600))
+	assert.NoError(t, os.Symlink(device, diskByIDPrefix+"data"))
+
+	_, err = d.Create(&godo.VolumeCreateRequest{Name: "data", SizeGigaBytes: 10},
+		map[string]string{"app": "db", "bad label": "x"})
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval))
+
+	vol, err := d.Create(&godo.VolumeCreateRequest{Name: "data", SizeGigaBytes: 10},
+		map[string]string{"app": "db"})
+	assert.NoError(t, err)
+	_, err = d.Create(&godo.VolumeCreateRequest{Name: "other", SizeGigaBytes: 10}, nil)
+	assert.NoError(t, err)
+	id, err := d.GetDeviceID(vol)
+	assert.NoError(t, err)
+	assert.Equal(t, "nyc1", vol.Zone)
+
+	_, err = d.DevicePath(id)
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolDetached))
+	path, err := d.Attach(id, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, device, path)
+	mappings, err := d.DeviceMappings()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{device: id}, mappings)
+
+	size, err := d.Expand(id, 20)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(20), size)
+
+	sets, err := d.Enumerate(nil, map[string]string{"app": "db"}, "app")
+	assert.NoError(t, err)
+	assert.Len(t, sets["app"], 1)
+	sets, err = d.Enumerate(nil, nil, "")
+	assert.NoError(t, err)
+	assert.Len(t, sets[storageops.SetIdentifierNone], 2)
+
+	assert.NoError(t, d.ApplyTags(id, map[string]string{"app": "web", "tier": ""}))
+	tags, err := d.Tags(id)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"app": "web", "tier": ""}, tags)
+	assert.NoError(t, d.RemoveTags(id, map[string]string{"tier": ""}))
+	tags, err = d.Tags(id)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"app": "web"}, tags)
+
+	assert.NoError(t, d.Detach(id))
+	assert.NoError(t, d.Delete(id))
+	_, err = d.Inspect([]*string{&id})
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolNotFound))
+}
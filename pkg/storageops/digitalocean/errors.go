@@ -0,0 +1,28 @@
+package digitalocean
+
+import (
+	"net/http"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+// errorStatuses maps the HTTP status of DigitalOcean API errors to storage
+// error codes
+var errorStatuses = map[int]int{
+	http.StatusNotFound:            storageops.ErrVolNotFound,
+	http.StatusTooManyRequests:     storageops.ErrThrottled,
+	http.StatusUnauthorized:        storageops.ErrUnauthorized,
+	http.StatusForbidden:           storageops.ErrUnauthorized,
+	http.StatusBadRequest:          storageops.ErrVolInval,
+	http.StatusUnprocessableEntity: storageops.ErrVolInval,
+}
+
+// storageError maps an error returned by the DigitalOcean API to a storage
+// error with the matching code. Errors without a matching code are returned
+// unchanged.
+func (s *doOps) storageError(err error) error {
+	if code, ok := errorStatuses[statusCode(err)]; ok {
+		return storageops.WrapError(code, err, s.InstanceID())
+	}
+	return err
+}
@@ -0,0 +1,218 @@
+package storageops
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/portworx/kvdb"
+	"github.com/sirupsen/logrus"
+)
+
+// driftKeyPrefix is the kvdb prefix of the desired volume specs
+const driftKeyPrefix = "storageops/specs/"
+
+// DesiredSpec is the part of a volume's cloud spec that is checked for drift.
+// Empty fields are not checked.
+type DesiredSpec struct {
+	// Type is the provider volume type, e.g. gp3 or pd-ssd
+	Type string `json:"type,omitempty"`
+	// Iops is the provisioned IOPS
+	Iops int64 `json:"iops,omitempty"`
+	// ThroughputMiBps is the provisioned throughput, e.g. of gp3 volumes
+	ThroughputMiBps int64 `json:"throughputMiBps,omitempty"`
+	// Encrypted is true if the volume must be encrypted
+	Encrypted bool `json:"encrypted,omitempty"`
+	// Tags must be present on the volume with the given values. Other tags
+	// on the volume are ignored.
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// SpecReporter is implemented by storage operations drivers that can report
+// the spec of a volume. Drifts are remediated through Ops.Modify and
+// Ops.ApplyTags.
+type SpecReporter interface {
+	// Spec returns the current spec of the given volume
+	Spec(volumeID string) (*DesiredSpec, error)
+}
+
+// Drift is a difference between the desired and actual spec of a volume
+type Drift struct {
+	// VolumeID of the drifted volume
+	VolumeID string
+	// Field is the drifted field, e.g. "type" or "tag:owner"
+	Field string
+	// Desired value of the field
+	Desired string
+	// Actual value of the field
+	Actual string
+	// Remediated is true if the field was changed back to the desired value
+	Remediated bool
+	// Err is set if remediation failed
+	Err error
+}
+
+func (d *Drift) String() string {
+	return fmt.Sprintf("volume %s %s: desired %q actual %q",
+		d.VolumeID, d.Field, d.Desired, d.Actual)
+}
+
+// DriftDetector compares the actual spec of managed volumes against their
+// desired spec stored in kvdb, catching changes made out-of-band such as in
+// the cloud console.
+type DriftDetector struct {
+	ops  Ops
+	spec SpecReporter
+	kv   kvdb.Kvdb
+}
+
+// NewDriftDetector creates a drift detector for volumes of the given driver.
+// spec is usually the driver itself.
+func NewDriftDetector(ops Ops, spec SpecReporter, kv kvdb.Kvdb) *DriftDetector {
+	return &DriftDetector{
+		ops:  ops,
+		spec: spec,
+		kv:   kv,
+	}
+}
+
+func (d *DriftDetector) prefix() string {
+	return driftKeyPrefix + d.ops.Name() + "/"
+}
+
+// SetDesired stores the desired spec of the given volume
+func (d *DriftDetector) SetDesired(volumeID string, spec *DesiredSpec) error {
+	_, err := d.kv.Put(d.prefix()+volumeID, spec, 0)
+	return err
+}
+
+// RemoveDesired stops checking the given volume for drift
+func (d *DriftDetector) RemoveDesired(volumeID string) error {
+	if _, err := d.kv.Delete(d.prefix() + volumeID); err != nil && err != kvdb.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+// Check compares all volumes with a desired spec against their actual spec
+// and returns the differences. If remediate is true type, IOPS, throughput
+// and tag differences are changed back to the desired values. Encryption cannot be
+// changed in place and is only reported.
+func (d *DriftDetector) Check(remediate bool) ([]*Drift, error) {
+	kvps, err := d.kv.Enumerate(d.prefix())
+	if err != nil {
+		return nil, err
+	}
+
+	var drifts []*Drift
+	for _, kvp := range kvps {
+		volumeID := strings.TrimPrefix(kvp.Key, d.prefix())
+
+		desired := &DesiredSpec{}
+		if err := json.Unmarshal(kvp.Value, desired); err != nil {
+			logrus.Warnf("invalid desired spec of volume %v: %v", volumeID, err)
+			continue
+		}
+		volDrifts, err := d.check(volumeID, desired, remediate)
+		if err != nil {
+			return drifts, err
+		}
+		drifts = append(drifts, volDrifts...)
+	}
+	return drifts, nil
+}
+
+func (d *DriftDetector) check(
+	volumeID string,
+	desired *DesiredSpec,
+	remediate bool,
+) ([]*Drift, error) {
+	actual, err := d.spec.Spec(volumeID)
+	if err != nil {
+		if IsErrorCode(err, ErrVolNotFound) {
+			logrus.Warnf("volume %v with a desired spec no longer exists", volumeID)
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var drifts, specDrifts []*Drift
+	if len(desired.Type) > 0 && desired.Type != actual.Type {
+		specDrifts = append(specDrifts, &Drift{
+			VolumeID: volumeID,
+			Field:    "type",
+			Desired:  desired.Type,
+			Actual:   actual.Type,
+		})
+	}
+	if desired.Iops > 0 && desired.Iops != actual.Iops {
+		specDrifts = append(specDrifts, &Drift{
+			VolumeID: volumeID,
+			Field:    "iops",
+			Desired:  fmt.Sprint(desired.Iops),
+			Actual:   fmt.Sprint(actual.Iops),
+		})
+	}
+	if desired.ThroughputMiBps > 0 && desired.ThroughputMiBps != actual.ThroughputMiBps {
+		specDrifts = append(specDrifts, &Drift{
+			VolumeID: volumeID,
+			Field:    "throughput",
+			Desired:  fmt.Sprint(desired.ThroughputMiBps),
+			Actual:   fmt.Sprint(actual.ThroughputMiBps),
+		})
+	}
+	if len(specDrifts) > 0 && remediate {
+		err := d.ops.Modify(volumeID, VolumeSpecUpdate{
+			Type:            desired.Type,
+			Iops:            desired.Iops,
+			ThroughputMiBps: desired.ThroughputMiBps,
+		})
+		for _, drift := range specDrifts {
+			drift.Remediated = err == nil
+			drift.Err = err
+		}
+	}
+	drifts = append(drifts, specDrifts...)
+
+	if desired.Encrypted && !actual.Encrypted {
+		drifts = append(drifts, &Drift{
+			VolumeID: volumeID,
+			Field:    "encrypted",
+			Desired:  "true",
+			Actual:   "false",
+		})
+	}
+
+	missing := make(map[string]string)
+	var tagDrifts []*Drift
+	for k, v := range desired.Tags {
+		if actual.Tags[k] != v {
+			missing[k] = v
+			tagDrifts = append(tagDrifts, &Drift{
+				VolumeID: volumeID,
+				Field:    "tag:" + k,
+				Desired:  v,
+				Actual:   actual.Tags[k],
+			})
+		}
+	}
+	if len(missing) > 0 && remediate {
+		err := d.ops.ApplyTags(volumeID, missing)
+		for _, drift := range tagDrifts {
+			drift.Remediated = err == nil
+			drift.Err = err
+		}
+	}
+	drifts = append(drifts, tagDrifts...)
+
+	for _, drift := range drifts {
+		if drift.Err != nil {
+			logrus.Warnf("failed to remediate drift of %v: %v", drift, drift.Err)
+		} else if drift.Remediated {
+			logrus.Infof("remediated drift of %v", drift)
+		} else {
+			logrus.Warnf("detected drift of %v", drift)
+		}
+	}
+	return drifts, nil
+}
@@ -0,0 +1,30 @@
+package storageops
+
+// DryRunner is implemented by drivers that can validate mutating requests,
+// e.g. their parameters and the permissions of the caller, without making
+// any change
+type DryRunner interface {
+	// DryRun returns a copy of the driver whose calls changing volumes or
+	// snapshots, e.g. Create, Attach, Expand, ApplyTags or SnapshotDelete,
+	// validate their request and return an ErrDryRun error if it would have
+	// succeeded, or the error it would have failed with. Read-only calls
+	// are passed through unchanged.
+	DryRun() Ops
+}
+
+// DryRun returns the dry run copy of the given driver using its DryRunner, to
+// preflight a cluster expansion or verify the permissions of a driver. It
+// returns ErrNotSupported if the driver has none. Wrappers like middlewares
+// hide the DryRunner of the driver they wrap, see AttachBatch.
+func DryRun(ops Ops) (Ops, error) {
+	if d, ok := ops.(DryRunner); ok {
+		return d.DryRun(), nil
+	}
+	return nil, ErrNotSupported
+}
+
+// IsDryRunSuccess returns true if err is the error of a dry run request that
+// would have succeeded
+func IsDryRunSuccess(err error) bool {
+	return IsErrorCode(err, ErrDryRun)
+}
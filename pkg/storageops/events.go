@@ -0,0 +1,293 @@
+package storageops
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EventType identifies a drive lifecycle event
+type EventType string
+
+const (
+	// EventCreate is emitted when a volume is created
+	EventCreate EventType = "create"
+	// EventDelete is emitted when a volume is deleted
+	EventDelete EventType = "delete"
+	// EventAttach is emitted when a volume is attached to an instance
+	EventAttach EventType = "attach"
+	// EventDetach is emitted when a volume is detached from an instance
+	EventDetach EventType = "detach"
+	// EventExpand is emitted when a volume is expanded
+	EventExpand EventType = "expand"
+	// EventModify is emitted when the type, IOPS or throughput of a volume
+	// is changed
+	EventModify EventType = "modify"
+	// EventSnapshot is emitted when a snapshot of a volume is taken
+	EventSnapshot EventType = "snapshot"
+	// EventSnapshotDelete is emitted when a snapshot is deleted
+	EventSnapshotDelete EventType = "snapshot-delete"
+	// EventSnapshotRestore is emitted when a volume is created from a snapshot
+	EventSnapshotRestore EventType = "snapshot-restore"
+	// EventRecordAdd is emitted when anti-entropy adds a missing drive record
+	EventRecordAdd EventType = "record-add"
+	// EventRecordRemove is emitted when anti-entropy removes the drive record
+	// of a volume that no longer exists
+	EventRecordRemove EventType = "record-remove"
+)
+
+// Event describes a single drive lifecycle event
+type Event struct {
+	// Type is the lifecycle event type
+	Type EventType
+	// Provider is the name of the storage operations driver
+	Provider string
+	// InstanceID is the instance on which the operation was performed
+	InstanceID string
+	// VolumeID is the ID of the volume or snapshot the event is about
+	VolumeID string
+	// Time is when the operation completed
+	Time time.Time
+	// Duration is how long the operation took
+	Duration time.Duration
+	// Err is the error message if the operation failed
+	Err string
+}
+
+// EventPublisher publishes drive lifecycle events and inventory to an
+// external system
+type EventPublisher interface {
+	// PublishEvent publishes a single lifecycle event
+	PublishEvent(event *Event) error
+	// PublishInventory publishes the given volume sets as returned by Enumerate
+	PublishInventory(sets map[string][]*Volume) error
+}
+
+// DefaultEventBufferSize is the number of events NewPublishingOps buffers
+// for a publisher that is not an AsyncPublisher
+const DefaultEventBufferSize = 256
+
+// AsyncPublisher is an EventPublisher that publishes events to another
+// publisher in the background, so that a slow publisher does not add latency
+// to the operations emitting the events. Events are dropped while its buffer
+// is full.
+type AsyncPublisher struct {
+	publisher EventPublisher
+	events    chan *Event
+	done      chan struct{}
+	// lock guards closed against PublishEvent sending on a closed channel
+	lock    sync.RWMutex
+	closed  bool
+	dropped uint64
+}
+
+// NewAsyncPublisher returns an AsyncPublisher buffering size events for the
+// given publisher
+func NewAsyncPublisher(publisher EventPublisher, size int) *AsyncPublisher {
+	a := &AsyncPublisher{
+		publisher: publisher,
+		events:    make(chan *Event, size),
+		done:      make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+func (a *AsyncPublisher) run() {
+	defer close(a.done)
+	for event := range a.events {
+		if err := a.publisher.PublishEvent(event); err != nil {
+			logrus.Warnf("failed to publish %v event for %v: %v", event.Type, event.VolumeID, err)
+		}
+	}
+}
+
+// PublishEvent queues the given event, it returns an error if the buffer is
+// full or the publisher is closed
+func (a *AsyncPublisher) PublishEvent(event *Event) error {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	if a.closed {
+		return fmt.Errorf("publisher is closed")
+	}
+	select {
+	case a.events <- event:
+		return nil
+	default:
+		atomic.AddUint64(&a.dropped, 1)
+		return fmt.Errorf("event buffer of %d events is full", cap(a.events))
+	}
+}
+
+// PublishInventory publishes the given inventory synchronously
+func (a *AsyncPublisher) PublishInventory(sets map[string][]*Volume) error {
+	return a.publisher.PublishInventory(sets)
+}
+
+// Dropped returns the number of events dropped because the buffer was full
+func (a *AsyncPublisher) Dropped() uint64 {
+	return atomic.LoadUint64(&a.dropped)
+}
+
+// Close publishes the buffered events and stops publishing
+func (a *AsyncPublisher) Close() {
+	a.lock.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.events)
+	}
+	a.lock.Unlock()
+	<-a.done
+}
+
+type publishingOps struct {
+	Ops
+	publisher EventPublisher
+}
+
+// NewPublishingOps returns Ops that publish a lifecycle event to the given
+// publisher for every mutating operation. Events are published in the
+// background by an AsyncPublisher, the given one or one buffering
+// DefaultEventBufferSize events. Publish errors are logged and never fail
+// the operation itself.
+func NewPublishingOps(ops Ops, publisher EventPublisher) Ops {
+	if _, ok := publisher.(*AsyncPublisher); !ok {
+		publisher = NewAsyncPublisher(publisher, DefaultEventBufferSize)
+	}
+	return &publishingOps{
+		Ops:       ops,
+		publisher: publisher,
+	}
+}
+
+// PublishInventory enumerates volumes matching the given labels and publishes
+// them as inventory
+func PublishInventory(
+	ops Ops,
+	publisher EventPublisher,
+	labels map[string]string,
+	setIdentifier string,
+) error {
+	sets, err := ops.Enumerate(nil, labels, setIdentifier)
+	if err != nil {
+		return err
+	}
+	return publisher.PublishInventory(sets)
+}
+
+func (p *publishingOps) publish(
+	t EventType,
+	instanceID string,
+	volumeID string,
+	start time.Time,
+	err error,
+) {
+	event := &Event{
+		Type:       t,
+		Provider:   p.Ops.Name(),
+		InstanceID: instanceID,
+		VolumeID:   volumeID,
+		Time:       time.Now(),
+		Duration:   time.Since(start),
+	}
+	if err != nil {
+		event.Err = err.Error()
+	}
+	if perr := p.publisher.PublishEvent(event); perr != nil {
+		logrus.Warnf("failed to publish %v event for %v: %v", t, volumeID, perr)
+	}
+}
+
+func (p *publishingOps) Create(
+	template interface{},
+	labels map[string]string,
+) (*Volume, error) {
+	start := time.Now()
+	vol, err := p.Ops.Create(template, labels)
+	id := ""
+	if err == nil {
+		id = vol.ID
+	}
+	p.publish(EventCreate, p.Ops.InstanceID(), id, start, err)
+	return vol, err
+}
+
+func (p *publishingOps) Attach(volumeID string, options map[string]string) (string, error) {
+	start := time.Now()
+	devicePath, err := p.Ops.Attach(volumeID, options)
+	p.publish(EventAttach, p.Ops.InstanceID(), volumeID, start, err)
+	return devicePath, err
+}
+
+func (p *publishingOps) Expand(volumeID string, newSizeGiB uint64) (uint64, error) {
+	start := time.Now()
+	size, err := p.Ops.Expand(volumeID, newSizeGiB)
+	p.publish(EventExpand, p.Ops.InstanceID(), volumeID, start, err)
+	return size, err
+}
+
+func (p *publishingOps) Modify(volumeID string, spec VolumeSpecUpdate) error {
+	start := time.Now()
+	err := p.Ops.Modify(volumeID, spec)
+	p.publish(EventModify, p.Ops.InstanceID(), volumeID, start, err)
+	return err
+}
+
+func (p *publishingOps) Detach(volumeID string) error {
+	start := time.Now()
+	err := p.Ops.Detach(volumeID)
+	p.publish(EventDetach, p.Ops.InstanceID(), volumeID, start, err)
+	return err
+}
+
+func (p *publishingOps) DetachFrom(volumeID, instanceID string) error {
+	start := time.Now()
+	err := p.Ops.DetachFrom(volumeID, instanceID)
+	p.publish(EventDetach, instanceID, volumeID, start, err)
+	return err
+}
+
+func (p *publishingOps) Delete(volumeID string) error {
+	start := time.Now()
+	err := p.Ops.Delete(volumeID)
+	p.publish(EventDelete, p.Ops.InstanceID(), volumeID, start, err)
+	return err
+}
+
+func (p *publishingOps) DeleteFrom(volumeID, instanceID string) error {
+	start := time.Now()
+	err := p.Ops.DeleteFrom(volumeID, instanceID)
+	p.publish(EventDelete, instanceID, volumeID, start, err)
+	return err
+}
+
+func (p *publishingOps) Snapshot(volumeID string, readonly bool) (*Snapshot, error) {
+	start := time.Now()
+	snap, err := p.Ops.Snapshot(volumeID, readonly)
+	p.publish(EventSnapshot, p.Ops.InstanceID(), volumeID, start, err)
+	return snap, err
+}
+
+func (p *publishingOps) SnapshotDelete(snapID string) error {
+	start := time.Now()
+	err := p.Ops.SnapshotDelete(snapID)
+	p.publish(EventSnapshotDelete, p.Ops.InstanceID(), snapID, start, err)
+	return err
+}
+
+func (p *publishingOps) SnapshotRestore(
+	snapID, zone string,
+	labels map[string]string,
+) (*Volume, error) {
+	start := time.Now()
+	vol, err := p.Ops.SnapshotRestore(snapID, zone, labels)
+	id := ""
+	if err == nil {
+		id = vol.ID
+	}
+	p.publish(EventSnapshotRestore, p.Ops.InstanceID(), id, start, err)
+	return vol, err
+}
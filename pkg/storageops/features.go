@@ -0,0 +1,108 @@
+package storageops
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// Feature is a capability of a storage operations driver that is verified by
+// the conformance suite
+type Feature string
+
+const (
+	// FeatureCreate is creating volumes from a template
+	FeatureCreate Feature = "create"
+	// FeatureSnapshot is creating and deleting snapshots
+	FeatureSnapshot Feature = "snapshot"
+	// FeatureTags is applying, listing and removing volume tags
+	FeatureTags Feature = "tags"
+	// FeatureEnumerate is enumerating volumes by labels
+	FeatureEnumerate Feature = "enumerate"
+	// FeatureInspect is inspecting volumes by ID
+	FeatureInspect Feature = "inspect"
+	// FeatureExpand is growing volumes
+	FeatureExpand Feature = "expand"
+	// FeatureAttach is attaching and detaching volumes and listing device mappings
+	FeatureAttach Feature = "attach"
+	// FeatureDevicePath is resolving the local device path of an attached volume
+	FeatureDevicePath Feature = "device-path"
+	// FeatureDelete is detaching and deleting volumes
+	FeatureDelete Feature = "delete"
+)
+
+// FeatureStatus is the conformance status of a feature
+type FeatureStatus string
+
+const (
+	// FeatureSupported means the feature passed the conformance suite
+	FeatureSupported FeatureStatus = "supported"
+	// FeatureNotSupported means the driver returned ErrNotSupported
+	FeatureNotSupported FeatureStatus = "not-supported"
+	// FeatureFailed means the feature failed the conformance suite
+	FeatureFailed FeatureStatus = "failed"
+)
+
+// FeatureMatrix is the conformance status of each feature of each driver,
+// keyed by driver name
+type FeatureMatrix map[string]map[Feature]FeatureStatus
+
+// Set records the status of a feature for the given driver. A failure is
+// never overwritten by a later success of the same feature.
+func (m FeatureMatrix) Set(driver string, feature Feature, status FeatureStatus) {
+	features, ok := m[driver]
+	if !ok {
+		features = make(map[Feature]FeatureStatus)
+		m[driver] = features
+	}
+	if features[feature] == FeatureFailed {
+		return
+	}
+	features[feature] = status
+}
+
+// Supports returns true if the given driver passed the given feature
+func (m FeatureMatrix) Supports(driver string, feature Feature) bool {
+	return m[driver][feature] == FeatureSupported
+}
+
+// Markdown renders the matrix as a markdown table for the docs
+func (m FeatureMatrix) Markdown() string {
+	var drivers []string
+	featureSet := make(map[Feature]bool)
+	for d, features := range m {
+		drivers = append(drivers, d)
+		for f := range features {
+			featureSet[f] = true
+		}
+	}
+	sort.Strings(drivers)
+	var features []string
+	for f := range featureSet {
+		features = append(features, string(f))
+	}
+	sort.Strings(features)
+
+	var b bytes.Buffer
+	b.WriteString("| Feature |")
+	for _, d := range drivers {
+		fmt.Fprintf(&b, " %s |", d)
+	}
+	b.WriteString("\n|---|")
+	for range drivers {
+		b.WriteString("---|")
+	}
+	b.WriteString("\n")
+	for _, f := range features {
+		fmt.Fprintf(&b, "| %s |", f)
+		for _, d := range drivers {
+			status := m[d][Feature(f)]
+			if len(status) == 0 {
+				status = "-"
+			}
+			fmt.Fprintf(&b, " %s |", status)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
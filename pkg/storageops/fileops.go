@@ -0,0 +1,68 @@
+package storageops
+
+// FileExportRule is an NFS export rule of a file volume
+type FileExportRule struct {
+	// IPRanges are the client addresses or CIDR ranges the rule applies to
+	IPRanges []string
+	// ReadOnly is true if the clients may only read
+	ReadOnly bool
+	// RootSquash is true if root on the clients is mapped to an anonymous user
+	RootSquash bool
+}
+
+// FileVolumeSpec is the spec of a file volume to provision
+type FileVolumeSpec struct {
+	// Name of the file volume
+	Name string
+	// CapacityGiB is the size of the file volume
+	CapacityGiB uint64
+	// Tier is the provider specific service tier, e.g. BASIC_HDD
+	Tier string
+	// Network is the provider network the volume is exported on
+	Network string
+	// ExportRules restrict access to the volume. If empty the provider
+	// default applies. Drivers that cannot restrict access, e.g. filestore,
+	// return ErrNotSupported if rules are given.
+	ExportRules []FileExportRule
+	// Labels to apply on the volume
+	Labels map[string]string
+}
+
+// FileMountInfo describes how to mount a file volume
+type FileMountInfo struct {
+	// Protocol is the protocol of the export, e.g. nfs
+	Protocol string
+	// Server is the address of the file server
+	Server string
+	// Path is the exported path on the server
+	Path string
+}
+
+// FileVolume is a provisioned file volume
+type FileVolume struct {
+	// ID of the file volume
+	ID string
+	// CapacityGiB is the size of the file volume
+	CapacityGiB uint64
+	// Mount is how to mount the volume. It is nil until the volume is ready.
+	Mount *FileMountInfo
+	// Raw is the provider object of the volume
+	Raw interface{}
+}
+
+// FileOps interface to perform operations on shared file volumes
+type FileOps interface {
+	// Name returns name of the file operations driver
+	Name() string
+	// Provision creates a file volume with the given spec and waits for it
+	// to be ready
+	Provision(spec *FileVolumeSpec) (*FileVolume, error)
+	// Inspect returns the file volume with the given ID
+	Inspect(id string) (*FileVolume, error)
+	// Resize changes the capacity of the given file volume
+	Resize(id string, capacityGiB uint64) error
+	// SetExportRules replaces the export rules of the given file volume
+	SetExportRules(id string, rules []FileExportRule) error
+	// Delete deletes the given file volume
+	Delete(id string) error
+}
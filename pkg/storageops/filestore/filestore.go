@@ -0,0 +1,205 @@
+package filestore
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"golang.org/x/oauth2/google"
+	file "google.golang.org/api/file/v1"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// shareName is the name of the single file share of each instance
+	shareName = "share"
+	// defaultTier is used when the spec does not request a tier
+	defaultTier = "BASIC_HDD"
+	// defaultNetwork is used when the spec does not request a network
+	defaultNetwork = "default"
+	// operationTimeout is how long to wait for Filestore operations, which
+	// take several minutes to provision an instance
+	operationTimeout       = 20 * time.Minute
+	operationRetryInterval = 10 * time.Second
+)
+
+type filestoreOps struct {
+	service  *file.Service
+	project  string
+	location string
+}
+
+// NewClient creates a Google Filestore file operations client for the given
+// project and location (zone or region) using the default credentials
+func NewClient(project, location string) (storageops.FileOps, error) {
+	c, err := google.DefaultClient(context.Background(), file.CloudPlatformScope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to authenticate with google api. Err: %v", err)
+	}
+	service, err := file.New(c)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create Filestore service: %v", err)
+	}
+	return &filestoreOps{
+		service:  service,
+		project:  project,
+		location: location,
+	}, nil
+}
+
+func (s *filestoreOps) Name() string { return "filestore" }
+
+func (s *filestoreOps) parent() string {
+	return fmt.Sprintf("projects/%s/locations/%s", s.project, s.location)
+}
+
+func (s *filestoreOps) instancePath(id string) string {
+	return s.parent() + "/instances/" + id
+}
+
+// storageError maps a not found error of the given instance to
+// ErrVolNotFound
+func storageError(err error, id string) error {
+	if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == http.StatusNotFound {
+		return storageops.NewStorageError(storageops.ErrVolNotFound,
+			fmt.Sprintf("filestore instance %s not found", id), "")
+	}
+	return err
+}
+
+// wait waits for the given long running operation to complete
+func (s *filestoreOps) wait(op *file.Operation) error {
+	_, err := storageops.RetryWithTimeout(
+		fmt.Sprintf("wait for operation %s", op.Name),
+		func() (interface{}, bool, error) {
+			if !op.Done {
+				current, err := s.service.Projects.Locations.Operations.Get(op.Name).Do()
+				if err != nil {
+					return nil, true, err
+				}
+				op = current
+			}
+			if !op.Done {
+				return nil, true, fmt.Errorf("operation %s is still running", op.Name)
+			}
+			if op.Error != nil {
+				return nil, false, fmt.Errorf("operation %s failed: %s",
+					op.Name, op.Error.Message)
+			}
+			return nil, false, nil
+		},
+		operationTimeout,
+		operationRetryInterval)
+	return err
+}
+
+// checkExportRules rejects export rules, as the Filestore v1 API of the
+// vendored client has no NFS export options. Exports are restricted to the
+// network of the instance only.
+func checkExportRules(rules []storageops.FileExportRule) error {
+	if len(rules) > 0 {
+		return fmt.Errorf("%w: filestore instances are exported to their network, "+
+			"export rules cannot be set", storageops.ErrNotSupported)
+	}
+	return nil
+}
+
+func (s *filestoreOps) fileVolume(id string, inst *file.Instance) *storageops.FileVolume {
+	v := &storageops.FileVolume{
+		ID:  id,
+		Raw: inst,
+	}
+	if len(inst.FileShares) > 0 {
+		v.CapacityGiB = uint64(inst.FileShares[0].CapacityGb)
+	}
+	if inst.State == "READY" && len(inst.Networks) > 0 &&
+		len(inst.Networks[0].IpAddresses) > 0 && len(inst.FileShares) > 0 {
+		v.Mount = &storageops.FileMountInfo{
+			Protocol: "nfs",
+			Server:   inst.Networks[0].IpAddresses[0],
+			Path:     "/" + inst.FileShares[0].Name,
+		}
+	}
+	return v
+}
+
+func (s *filestoreOps) Provision(spec *storageops.FileVolumeSpec) (*storageops.FileVolume, error) {
+	if err := checkExportRules(spec.ExportRules); err != nil {
+		return nil, err
+	}
+	inst := &file.Instance{
+		Tier:   spec.Tier,
+		Labels: spec.Labels,
+		FileShares: []*file.FileShareConfig{{
+			Name:       shareName,
+			CapacityGb: int64(spec.CapacityGiB),
+		}},
+		Networks: []*file.NetworkConfig{{
+			Network: spec.Network,
+			Modes:   []string{"MODE_IPV4"},
+		}},
+	}
+	if len(inst.Tier) == 0 {
+		inst.Tier = defaultTier
+	}
+	if len(inst.Networks[0].Network) == 0 {
+		inst.Networks[0].Network = defaultNetwork
+	}
+
+	op, err := s.service.Projects.Locations.Instances.Create(s.parent(), inst).
+		InstanceId(spec.Name).Do()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.wait(op); err != nil {
+		return nil, err
+	}
+	return s.Inspect(spec.Name)
+}
+
+func (s *filestoreOps) Inspect(id string) (*storageops.FileVolume, error) {
+	inst, err := s.service.Projects.Locations.Instances.Get(s.instancePath(id)).Do()
+	if err != nil {
+		return nil, storageError(err, id)
+	}
+	return s.fileVolume(id, inst), nil
+}
+
+func (s *filestoreOps) Resize(id string, capacityGiB uint64) error {
+	inst, err := s.service.Projects.Locations.Instances.Get(s.instancePath(id)).Do()
+	if err != nil {
+		return storageError(err, id)
+	}
+	if len(inst.FileShares) == 0 {
+		return storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("filestore instance %s has no file share", id), "")
+	}
+	inst.FileShares[0].CapacityGb = int64(capacityGiB)
+
+	op, err := s.service.Projects.Locations.Instances.Patch(s.instancePath(id),
+		&file.Instance{FileShares: inst.FileShares}).UpdateMask("fileShares").Do()
+	if err != nil {
+		return storageError(err, id)
+	}
+	return s.wait(op)
+}
+
+// SetExportRules only accepts clearing the export rules, see
+// checkExportRules
+func (s *filestoreOps) SetExportRules(id string, rules []storageops.FileExportRule) error {
+	if err := checkExportRules(rules); err != nil {
+		return err
+	}
+	_, err := s.Inspect(id)
+	return err
+}
+
+func (s *filestoreOps) Delete(id string) error {
+	op, err := s.service.Projects.Locations.Instances.Delete(s.instancePath(id)).Do()
+	if err != nil {
+		return storageError(err, id)
+	}
+	return s.wait(op)
+}
@@ -0,0 +1,158 @@
+package filestore
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	file "google.golang.org/api/file/v1"
+)
+
+const testParent = "projects/project/locations/us-central1-a"
+
+// fakeAPI is an in memory Filestore API of instances. Operations complete
+// right away unless pending is set, in which case they complete on the first
+// poll.
+type fakeAPI struct {
+	t         *testing.T
+	instances map[string]*file.Instance
+	pending   bool
+	failed    bool
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	reply := func(v interface{}) { assert.NoError(f.t, json.NewEncoder(w).Encode(v)) }
+	done := func() {
+		op := &file.Operation{Name: "operations/1", Done: !f.pending}
+		if f.failed {
+			op.Done = true
+			op.Error = &file.Status{Code: 8, Message: "quota exceeded"}
+		}
+		reply(op)
+	}
+
+	if path == "operations/1" {
+		reply(&file.Operation{Name: "operations/1", Done: true})
+		return
+	}
+	if path == testParent+"/instances" && r.Method == http.MethodPost {
+		inst := &file.Instance{}
+		assert.NoError(f.t, json.NewDecoder(r.Body).Decode(inst))
+		inst.Name = path + "/" + r.URL.Query().Get("instanceId")
+		inst.State = "READY"
+		inst.Networks[0].IpAddresses = []string{"10.0.0.2"}
+		f.instances[inst.Name] = inst
+		done()
+		return
+	}
+	inst, ok := f.instances[path]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		reply(map[string]interface{}{"error": map[string]interface{}{"code": 404, "status": "NOT_FOUND"}})
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		reply(inst)
+	case http.MethodPatch:
+		assert.Equal(f.t, "fileShares", r.URL.Query().Get("updateMask"))
+		update := &file.Instance{}
+		assert.NoError(f.t, json.NewDecoder(r.Body).Decode(update))
+		inst.FileShares = update.FileShares
+		done()
+	case http.MethodDelete:
+		delete(f.instances, path)
+		done()
+	}
+}
+
+func newTestClient(t *testing.T) (*filestoreOps, *fakeAPI, *httptest.Server) {
+	api := &fakeAPI{t: t, instances: make(map[string]*file.Instance)}
+	server := httptest.NewServer(api)
+	service, err := file.New(server.Client())
+	require.NoError(t, err)
+	service.BasePath = server.URL + "/"
+	return &filestoreOps{
+		service:  service,
+		project:  "project",
+		location: "us-central1-a",
+	}, api, server
+}
+
+func TestFilestore(t *testing.T) {
+	s, api, server := newTestClient(t)
+	defer server.Close()
+
+	vol, err := s.Provision(&storageops.FileVolumeSpec{
+		Name:        "share-1",
+		CapacityGiB: 1024,
+		Labels:      map[string]string{"app": "db"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "share-1", vol.ID)
+	require.Equal(t, uint64(1024), vol.CapacityGiB)
+	require.Equal(t, &storageops.FileMountInfo{
+		Protocol: "nfs",
+		Server:   "10.0.0.2",
+		Path:     "/" + shareName,
+	}, vol.Mount)
+
+	inst := api.instances[testParent+"/instances/share-1"]
+	require.Equal(t, defaultTier, inst.Tier)
+	require.Equal(t, defaultNetwork, inst.Networks[0].Network)
+	require.Equal(t, map[string]string{"app": "db"}, inst.Labels)
+
+	require.NoError(t, s.Resize("share-1", 2048))
+	vol, err = s.Inspect("share-1")
+	require.NoError(t, err)
+	require.Equal(t, uint64(2048), vol.CapacityGiB)
+
+	require.NoError(t, s.SetExportRules("share-1", nil))
+	err = s.SetExportRules("share-1", []storageops.FileExportRule{{IPRanges: []string{"10.0.0.0/24"}}})
+	require.True(t, errors.Is(err, storageops.ErrNotSupported), "%v", err)
+	_, err = s.Provision(&storageops.FileVolumeSpec{
+		Name:        "share-2",
+		CapacityGiB: 1024,
+		ExportRules: []storageops.FileExportRule{{IPRanges: []string{"10.0.0.0/24"}, ReadOnly: true}},
+	})
+	require.True(t, errors.Is(err, storageops.ErrNotSupported), "%v", err)
+	require.NotContains(t, api.instances, testParent+"/instances/share-2",
+		"instances must not be exported wider than requested")
+
+	require.NoError(t, s.Delete("share-1"))
+	_, err = s.Inspect("share-1")
+	require.True(t, storageops.IsErrorCode(err, storageops.ErrVolNotFound))
+	err = s.Resize("share-1", 4096)
+	require.True(t, storageops.IsErrorCode(err, storageops.ErrVolNotFound))
+	err = s.Delete("share-1")
+	require.True(t, storageops.IsErrorCode(err, storageops.ErrVolNotFound))
+}
+
+func TestFilestoreOperations(t *testing.T) {
+	s, api, server := newTestClient(t)
+	defer server.Close()
+
+	api.pending = true
+	_, err := s.Provision(&storageops.FileVolumeSpec{
+		Name:        "share-1",
+		CapacityGiB: 1024,
+		Tier:        "PREMIUM",
+		Network:     "shared",
+	})
+	require.NoError(t, err, "pending operations must be polled until done")
+	inst := api.instances[testParent+"/instances/share-1"]
+	require.Equal(t, "PREMIUM", inst.Tier)
+	require.Equal(t, "shared", inst.Networks[0].Network)
+
+	api.pending, api.failed = false, true
+	err = s.Resize("share-1", 2048)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "quota exceeded")
+}
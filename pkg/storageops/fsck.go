@@ -0,0 +1,184 @@
+package storageops
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FsckPolicy is what to do with a filesystem on a device that is attached
+type FsckPolicy string
+
+const (
+	// FsckSkip does not check the filesystem
+	FsckSkip FsckPolicy = ""
+	// FsckFail checks the filesystem read-only and fails the attach if it
+	// has errors
+	FsckFail FsckPolicy = "fail"
+	// FsckRepair checks the filesystem, repairs the errors that can be
+	// repaired safely and fails the attach if any are left
+	FsckRepair FsckPolicy = "repair"
+	// FsckWarn checks the filesystem read-only and logs a warning if it has
+	// errors, or the check could not be run
+	FsckWarn FsckPolicy = "warn"
+)
+
+// FsckResult is the result of a filesystem check
+type FsckResult struct {
+	// FsType is the type of the checked filesystem, empty if the device has
+	// no filesystem
+	FsType string
+	// Skipped is true if the filesystem could not be checked, e.g. because
+	// it is mounted or its type is not supported
+	Skipped bool
+	// Clean is true if no errors were found or all were repaired
+	Clean bool
+	// Repaired is true if errors were found and repaired
+	Repaired bool
+	// Output is the output of the check
+	Output string
+}
+
+// fsckCommand returns the command checking or repairing the filesystem of
+// the given type, or nil if the type is not supported
+func fsckCommand(fsType string, repair bool) []string {
+	switch fsType {
+	case "ext2", "ext3", "ext4":
+		if repair {
+			return []string{"e2fsck", "-f", "-p"}
+		}
+		return []string{"e2fsck", "-f", "-n"}
+	case "xfs":
+		if repair {
+			return []string{"xfs_repair"}
+		}
+		return []string{"xfs_repair", "-n"}
+	}
+	return nil
+}
+
+// fsckExitStatus interprets the exit code of the command returned by
+// fsckCommand and returns whether the filesystem is clean and if it was
+// repaired
+func fsckExitStatus(fsType string, repair bool, code int) (bool, bool, error) {
+	if fsType == "xfs" {
+		switch {
+		case code == 0:
+			// xfs_repair does not report if it repaired anything
+			return true, false, nil
+		case code == 1 && !repair:
+			return false, false, nil
+		}
+		return false, false, fmt.Errorf("xfs_repair exited with %d", code)
+	}
+
+	// e2fsck exit codes are a bit mask: 1 errors corrected, 2 corrected and
+	// a reboot is needed, 4 errors left uncorrected, 8 operational error
+	switch {
+	case code&^7 != 0:
+		return false, false, fmt.Errorf("e2fsck exited with %d", code)
+	case code&4 != 0:
+		return false, false, nil
+	case code&3 != 0:
+		return true, true, nil
+	}
+	return true, false, nil
+}
+
+// CheckFilesystem checks, and with repair also repairs, the filesystem on the
+// given device, and kills the check if it takes longer than timeout. Devices
+// without a filesystem, with a mounted one or one that is not ext or xfs are
+// skipped.
+func CheckFilesystem(devicePath string, repair bool, timeout time.Duration) (*FsckResult, error) {
+	sig, err := GetFsSignature(devicePath)
+	if err != nil {
+		return nil, err
+	}
+	result := &FsckResult{FsType: sig.Type, Clean: true}
+	cmd := fsckCommand(sig.Type, repair)
+	if cmd == nil {
+		result.Skipped = true
+		return result, nil
+	}
+	usage, err := GetDeviceUsage(devicePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(usage.Mounts) > 0 {
+		logrus.Infof("Skipping filesystem check of %s mounted at %v", devicePath, usage.Mounts)
+		result.Skipped = true
+		return result, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, cmd[0], append(cmd[1:], devicePath)...).CombinedOutput()
+	result.Output = strings.TrimSpace(string(out))
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%s on %s did not complete within %v", cmd[0], devicePath, timeout)
+	}
+	code := 0
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		code = exitErr.ExitCode()
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to run %s on %s: %v", cmd[0], devicePath, err)
+	}
+	if result.Clean, result.Repaired, err = fsckExitStatus(sig.Type, repair, code); err != nil {
+		return nil, fmt.Errorf("%s on %s: %v: %s", cmd[0], devicePath, err, result.Output)
+	}
+	return result, nil
+}
+
+type fsckOps struct {
+	Ops
+	policy  FsckPolicy
+	timeout time.Duration
+}
+
+// NewFsckOps returns Ops that check the filesystem of a volume after
+// attaching it as per the given policy, bounded by timeout. If the check
+// fails the volume is left attached for inspection and Attach returns the
+// device path along with the error.
+func NewFsckOps(ops Ops, policy FsckPolicy, timeout time.Duration) Ops {
+	return &fsckOps{
+		Ops:     ops,
+		policy:  policy,
+		timeout: timeout,
+	}
+}
+
+func (o *fsckOps) Attach(volumeID string, options map[string]string) (string, error) {
+	devicePath, err := o.Ops.Attach(volumeID, options)
+	if err != nil || o.policy == FsckSkip {
+		return devicePath, err
+	}
+
+	result, err := CheckFilesystem(devicePath, o.policy == FsckRepair, o.timeout)
+	if err != nil {
+		if o.policy == FsckWarn {
+			logrus.Warnf("Failed to check filesystem of volume %s on %s: %v",
+				volumeID, devicePath, err)
+			return devicePath, nil
+		}
+		return devicePath, err
+	}
+	if result.Repaired {
+		logrus.Warnf("Repaired %s filesystem of volume %s on %s: %s",
+			result.FsType, volumeID, devicePath, result.Output)
+	}
+	if result.Clean {
+		return devicePath, nil
+	}
+
+	msg := fmt.Sprintf("%s filesystem of volume %s on %s has errors: %s",
+		result.FsType, volumeID, devicePath, result.Output)
+	if o.policy == FsckWarn {
+		logrus.Warn(msg)
+		return devicePath, nil
+	}
+	return devicePath, NewStorageError(ErrFsckFailed, msg, "")
+}
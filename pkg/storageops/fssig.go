@@ -0,0 +1,131 @@
+package storageops
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const (
+	// FsUUIDLabel is the label/tag key under which a volume's filesystem UUID
+	// is recorded
+	FsUUIDLabel = "openstorage-fs-uuid"
+	// FsLabelLabel is the label/tag key under which a volume's filesystem
+	// label is recorded
+	FsLabelLabel = "openstorage-fs-label"
+)
+
+// FsSignature identifies the filesystem on a device
+type FsSignature struct {
+	// UUID of the filesystem
+	UUID string
+	// Label of the filesystem
+	Label string
+	// Type of the filesystem, e.g. ext4
+	Type string
+}
+
+// blkidCommand creates the blkid command, replaced by tests
+var blkidCommand = exec.Command
+
+// GetFsSignature returns the filesystem signature on the given device using
+// blkid. It returns an empty signature if the device has no filesystem.
+func GetFsSignature(devicePath string) (*FsSignature, error) {
+	out, err := blkidCommand("blkid", "-p", "-o", "export", devicePath).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 2 {
+			// blkid exits with 2 if no signature was found
+			return &FsSignature{}, nil
+		}
+		return nil, fmt.Errorf("blkid failed on %s: %v", devicePath, err)
+	}
+
+	sig := &FsSignature{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "UUID":
+			sig.UUID = kv[1]
+		case "LABEL":
+			sig.Label = kv[1]
+		case "TYPE":
+			sig.Type = kv[1]
+		}
+	}
+	return sig, scanner.Err()
+}
+
+// RecordFsSignature records the filesystem signature on the device of the
+// given volume as tags on the volume, to be verified on a later reattach
+func RecordFsSignature(ops Ops, volumeID, devicePath string) error {
+	sig, err := GetFsSignature(devicePath)
+	if err != nil {
+		return err
+	}
+	if len(sig.UUID) == 0 {
+		return NewStorageError(ErrVolInval,
+			fmt.Sprintf("no filesystem found on %s of volume %s", devicePath, volumeID), "")
+	}
+	labels := map[string]string{FsUUIDLabel: sig.UUID}
+	if len(sig.Label) > 0 {
+		labels[FsLabelLabel] = sig.Label
+	}
+	return ops.ApplyTags(volumeID, labels)
+}
+
+// VerifyFsSignature checks that the filesystem on the device of the given
+// volume matches the signature recorded by RecordFsSignature, ignoring case.
+// Volumes without a recorded signature always pass.
+func VerifyFsSignature(ops Ops, volumeID, devicePath string) error {
+	tags, err := ops.Tags(volumeID)
+	if err != nil {
+		return err
+	}
+	expectedUUID := tags[FsUUIDLabel]
+	if len(expectedUUID) == 0 {
+		return nil
+	}
+
+	sig, err := GetFsSignature(devicePath)
+	if err != nil {
+		return err
+	}
+	// Providers may not keep the case of tag values, e.g. GCE lowercases
+	// labels, so the signature is compared case-insensitively
+	expectedLabel := tags[FsLabelLabel]
+	if !strings.EqualFold(sig.UUID, expectedUUID) ||
+		(len(expectedLabel) > 0 && !strings.EqualFold(sig.Label, expectedLabel)) {
+		return NewStorageError(ErrFsSignatureMismatch,
+			fmt.Sprintf("filesystem on %s of volume %s does not match the recorded "+
+				"signature: expected uuid %q label %q found uuid %q label %q",
+				devicePath, volumeID, expectedUUID, expectedLabel, sig.UUID, sig.Label),
+			"")
+	}
+	return nil
+}
+
+type verifyingOps struct {
+	Ops
+}
+
+// NewVerifyingOps returns Ops that verify the recorded filesystem signature
+// of a volume after attaching it. On a mismatch the volume is left attached
+// for inspection and Attach returns the device path along with an
+// ErrFsSignatureMismatch error.
+func NewVerifyingOps(ops Ops) Ops {
+	return &verifyingOps{Ops: ops}
+}
+
+func (o *verifyingOps) Attach(volumeID string, options map[string]string) (string, error) {
+	devicePath, err := o.Ops.Attach(volumeID, options)
+	if err != nil {
+		return devicePath, err
+	}
+	return devicePath, VerifyFsSignature(o.Ops, volumeID, devicePath)
+}
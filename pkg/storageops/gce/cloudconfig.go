@@ -0,0 +1,62 @@
+package gce
+
+import (
+	"fmt"
+
+	"cloud.google.com/go/compute/metadata"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/libopenstorage/openstorage/pkg/storageops/config"
+)
+
+func init() {
+	config.Register("gce", newFromCloudConfig)
+}
+
+// newFromCloudConfig creates the driver of the given cloud config with the
+// JSON service account key of its serviceAccountKey credential, or the
+// default credentials if not set. The instance, its zone and the project of
+// the project param are read from the instance metadata service unless all
+// of them are set.
+func newFromCloudConfig(c *config.Config) (storageops.Ops, error) {
+	cfg := Config{}
+	var err error
+	if cfg.RetryPolicy, err = c.RetryPolicy(); err != nil {
+		return nil, err
+	}
+	if cfg.HTTP, err = c.HTTPConfig(); err != nil {
+		return nil, err
+	}
+	var key []byte
+	if v, ok, err := c.Credential("serviceAccountKey"); err != nil {
+		return nil, err
+	} else if ok {
+		key = []byte(v)
+	}
+
+	i := &instance{
+		name:     c.Instance,
+		hostname: c.Instance,
+		zone:     c.Zone,
+		project:  c.Params["project"],
+	}
+	if len(i.name) == 0 || len(i.zone) == 0 || len(i.project) == 0 {
+		if !metadata.OnGCE() {
+			return nil, fmt.Errorf("instance is not running on GCE, " +
+				"the instance, zone and project must be set")
+		}
+		md := &instance{}
+		if err := gceInfo(md); err != nil {
+			return nil, fmt.Errorf("error fetching instance info. Err: %v", err)
+		}
+		if len(i.name) == 0 {
+			i.name, i.hostname = md.name, md.hostname
+		}
+		if len(i.zone) == 0 {
+			i.zone = md.zone
+		}
+		if len(i.project) == 0 {
+			i.project = md.project
+		}
+	}
+	return newClient(i, cfg, key)
+}
@@ -0,0 +1,108 @@
+package gce
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/pborman/uuid"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func init() {
+	storageops.RegisterConverter("gce", &converter{})
+	storageops.RegisterSizeRule("gce", &storageops.SizeRule{
+		Granularity: storageops.GiB,
+		Min:         storageops.GiB,
+		Max:         64 * storageops.TiB,
+	})
+}
+
+type converter struct{}
+
+func (c *converter) ToVolume(raw interface{}) (*storageops.Volume, error) {
+	disk, ok := raw.(*compute.Disk)
+	if !ok {
+		return nil, fmt.Errorf("invalid gce disk %T", raw)
+	}
+	return toVolume(disk), nil
+}
+
+func toVolume(disk *compute.Disk) *storageops.Volume {
+	v := &storageops.Volume{
+		ID:        disk.Name,
+		Name:      disk.Name,
+		SizeGiB:   uint64(disk.SizeGb),
+		Type:      path.Base(disk.Type),
+		Zone:      path.Base(disk.Zone),
+		State:     disk.Status,
+		Encrypted: disk.DiskEncryptionKey != nil,
+		Labels:    disk.Labels,
+		Raw:       disk,
+	}
+	for _, user := range disk.Users {
+		v.AttachedTo = append(v.AttachedTo, path.Base(user))
+		v.Attachments = append(v.Attachments, storageops.Attachment{InstanceID: path.Base(user)})
+	}
+	return v
+}
+
+// FromSpec returns the *compute.Disk template of the given spec. Disks are
+// always encrypted at rest, disks without a name in the native template are
+// named after a random UUID.
+func (c *converter) FromSpec(spec *storageops.VolumeSpec) (interface{}, error) {
+	if err := spec.Unsupported("gce", storageops.SpecFieldIops,
+		storageops.SpecFieldThroughput, storageops.SpecFieldKMSKey); err != nil {
+		return nil, err
+	}
+	disk := &compute.Disk{}
+	if spec.Native != nil {
+		native, ok := spec.Native.(*compute.Disk)
+		if !ok {
+			return nil, storageops.NativeTemplateError("gce", spec.Native)
+		}
+		copied := *native
+		disk = &copied
+	}
+	if len(disk.Name) == 0 {
+		disk.Name = "disk-" + uuid.New()
+	}
+	if spec.SizeGiB > 0 {
+		disk.SizeGb = int64(spec.SizeGiB)
+	}
+	if len(spec.Type) > 0 {
+		disk.Type = spec.Type
+	}
+	if len(spec.Zone) > 0 {
+		disk.Zone = spec.Zone
+	}
+	if len(spec.SnapshotID) > 0 {
+		disk.SourceSnapshot = "global/snapshots/" + spec.SnapshotID
+	}
+	return disk, nil
+}
+
+func (c *converter) ToSnapshot(raw interface{}) (*storageops.Snapshot, error) {
+	snap, ok := raw.(*compute.Snapshot)
+	if !ok {
+		return nil, fmt.Errorf("invalid gce snapshot %T", raw)
+	}
+	return toSnapshot(snap)
+}
+
+func toSnapshot(snap *compute.Snapshot) (*storageops.Snapshot, error) {
+	created, err := time.Parse(time.RFC3339, snap.CreationTimestamp)
+	if err != nil {
+		return nil, err
+	}
+	return &storageops.Snapshot{
+		ID:       snap.Name,
+		VolumeID: path.Base(snap.SourceDisk),
+		SizeGiB:  uint64(snap.DiskSizeGb),
+		State:    snap.Status,
+		Created:  created,
+		Labels:   snap.Labels,
+		Raw:      snap,
+	}, nil
+}
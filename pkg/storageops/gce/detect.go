@@ -0,0 +1,20 @@
+package gce
+
+import (
+	"fmt"
+
+	"cloud.google.com/go/compute/metadata"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+func init() {
+	storageops.RegisterDetector("gce", &storageops.Detector{
+		Probe: func() error {
+			if metadata.OnGCE() || IsDevMode() {
+				return nil
+			}
+			return fmt.Errorf("GCE metadata server not reachable")
+		},
+		New: NewClient,
+	})
+}
@@ -0,0 +1,49 @@
+package gce
+
+import (
+	"net/http"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"google.golang.org/api/googleapi"
+)
+
+// errorReasons maps the reasons of GCE API errors to storage error codes,
+// they take precedence over the HTTP status
+var errorReasons = map[string]int{
+	"notFound":                       storageops.ErrVolNotFound,
+	"quotaExceeded":                  storageops.ErrQuotaExceeded,
+	"rateLimitExceeded":              storageops.ErrThrottled,
+	"userRateLimitExceeded":          storageops.ErrThrottled,
+	"resourceInUseByAnotherResource": storageops.ErrVolAlreadyAttached,
+	"invalid":                        storageops.ErrVolInval,
+	"badRequest":                     storageops.ErrVolInval,
+	"forbidden":                      storageops.ErrUnauthorized,
+	"insufficientPermissions":        storageops.ErrUnauthorized,
+}
+
+// errorStatuses maps the HTTP status of GCE API errors to storage error codes
+var errorStatuses = map[int]int{
+	http.StatusNotFound:        storageops.ErrVolNotFound,
+	http.StatusTooManyRequests: storageops.ErrThrottled,
+	http.StatusUnauthorized:    storageops.ErrUnauthorized,
+	http.StatusForbidden:       storageops.ErrUnauthorized,
+	http.StatusBadRequest:      storageops.ErrVolInval,
+}
+
+// storageError maps an error returned by the GCE API to a storage error with
+// the matching code. Errors without a matching code are returned unchanged.
+func (s *gceOps) storageError(err error) error {
+	gerr, ok := err.(*googleapi.Error)
+	if !ok {
+		return err
+	}
+	for _, e := range gerr.Errors {
+		if code, ok := errorReasons[e.Reason]; ok {
+			return storageops.WrapError(code, err, s.inst.name)
+		}
+	}
+	if code, ok := errorStatuses[gerr.Code]; ok {
+		return storageops.WrapError(code, err, s.inst.name)
+	}
+	return err
+}
@@ -2,20 +2,23 @@ package gce
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"cloud.google.com/go/compute/metadata"
 	"github.com/libopenstorage/openstorage/pkg/storageops"
-	"github.com/portworx/sched-ops/task"
+	"github.com/pborman/uuid"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	compute "google.golang.org/api/compute/v1"
 	"google.golang.org/api/googleapi"
@@ -34,9 +37,21 @@ const (
 type gceOps struct {
 	inst    *instance
 	service *compute.Service
+	cfg     Config
 	mutex   sync.Mutex
 }
 
+// Config is the optional configuration of the GCE storage ops driver
+type Config struct {
+	// RetryPolicy configures the wait loops of the driver. The default
+	// retries every storageops.ProviderOpsRetryInterval, attaches poll
+	// adaptively.
+	RetryPolicy *storageops.RetryPolicy
+	// HTTP configures the HTTP client of the compute API and token calls,
+	// e.g. to go through a proxy
+	HTTP *storageops.HTTPConfig
+}
+
 // instance stores the metadata of the running GCE instance
 type instance struct {
 	name     string
@@ -53,8 +68,23 @@ func IsDevMode() bool {
 	return err == nil
 }
 
-// NewClient creates a new GCE operations client
+// NewClient creates a new GCE operations client with the HTTP config of the
+// environment, see storageops.HTTPConfigFromEnv
 func NewClient() (storageops.Ops, error) {
+	httpCfg, err := storageops.HTTPConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return NewClientWithConfig(Config{HTTP: httpCfg})
+}
+
+// NewClientWithConfig creates a new GCE storage ops instance with the given
+// configuration
+func NewClientWithConfig(cfg Config) (storageops.Ops, error) {
+	if err := cfg.RetryPolicy.Validate(); err != nil {
+		return nil, err
+	}
+
 	var i = new(instance)
 	var err error
 	if metadata.OnGCE() {
@@ -68,10 +98,34 @@ func NewClient() (storageops.Ops, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error fetching instance info. Err: %v", err)
 	}
+	return newClient(i, cfg, nil)
+}
 
-	c, err := google.DefaultClient(context.Background(), compute.ComputeScope)
-	if err != nil {
-		return nil, fmt.Errorf("failed to authenticate with google api. Err: %v", err)
+// newClient creates a new GCE storage ops instance for the given instance,
+// authenticated with the given service account key or, if nil, the default
+// credentials
+func newClient(i *instance, cfg Config, serviceAccountKey []byte) (storageops.Ops, error) {
+	ctx := context.Background()
+	if cfg.HTTP != nil {
+		client, err := cfg.HTTP.Client()
+		if err != nil {
+			return nil, err
+		}
+		// The oauth2 transport wraps the client of the context
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, client)
+	}
+	var c *http.Client
+	if serviceAccountKey != nil {
+		jwt, err := google.JWTConfigFromJSON(serviceAccountKey, compute.ComputeScope)
+		if err != nil {
+			return nil, fmt.Errorf("invalid service account key: %v", err)
+		}
+		c = jwt.Client(ctx)
+	} else {
+		var err error
+		if c, err = google.DefaultClient(ctx, compute.ComputeScope); err != nil {
+			return nil, fmt.Errorf("failed to authenticate with google api. Err: %v", err)
+		}
 	}
 
 	service, err := compute.New(c)
@@ -82,6 +136,7 @@ func NewClient() (storageops.Ops, error) {
 	return &gceOps{
 		inst:    i,
 		service: service,
+		cfg:     cfg,
 	}, nil
 }
 
@@ -89,35 +144,128 @@ func (s *gceOps) Name() string { return "gce" }
 
 func (s *gceOps) InstanceID() string { return s.inst.name }
 
+func (s *gceOps) GetZone() (string, error) { return s.inst.zone, nil }
+
+func (s *gceOps) GetRegion() (string, error) {
+	return zoneRegion(s.inst.zone), nil
+}
+
+func (s *gceOps) ListZones() ([]string, error) {
+	region := zoneRegion(s.inst.zone)
+	var zones []string
+	err := s.service.Zones.List(s.inst.project).Pages(context.Background(),
+		func(page *compute.ZoneList) error {
+			for _, z := range page.Items {
+				// Region is the URL of the region resource
+				if z.Status == "UP" && path.Base(z.Region) == region {
+					zones = append(zones, z.Name)
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	sort.Strings(zones)
+	return zones, nil
+}
+
+// zoneRegion returns the region of the given zone, e.g. us-central1 of
+// us-central1-a
+func zoneRegion(zone string) string {
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		return zone[:i]
+	}
+	return zone
+}
+
+// ApplyTags labels the given disk or, if there is no such disk, the snapshot
+// of that name with the given labels, replacing the values of labels it
+// already has
 func (s *gceOps) ApplyTags(
 	diskName string,
 	labels map[string]string) error {
-	d, err := s.service.Disks.Get(s.inst.project, s.inst.zone, diskName).Do()
+	currentLabels, fingerprint, snapshot, err := s.resourceLabels(diskName)
 	if err != nil {
 		return err
 	}
-
-	var currentLabels map[string]string
-	if len(d.Labels) == 0 {
+	if currentLabels == nil {
 		currentLabels = make(map[string]string)
-	} else {
-		currentLabels = d.Labels
 	}
 
 	for k, v := range formatLabels(labels) {
 		currentLabels[k] = v
 	}
+	return s.setLabels(diskName, snapshot, fingerprint, currentLabels)
+}
+
+// resourceLabels returns the labels and label fingerprint of the given disk
+// or, if there is no such disk, of the snapshot of that name. snapshot is
+// true for a snapshot.
+func (s *gceOps) resourceLabels(name string) (labels map[string]string, fingerprint string, snapshot bool, err error) {
+	d, err := s.service.Disks.Get(s.inst.project, s.inst.zone, name).Do()
+	if err == nil {
+		return d.Labels, d.LabelFingerprint, false, nil
+	}
+	if gerr, ok := err.(*googleapi.Error); !ok || gerr.Code != http.StatusNotFound {
+		return nil, "", false, s.storageError(err)
+	}
+	snap, err := s.service.Snapshots.Get(s.inst.project, name).Do()
+	if err != nil {
+		return nil, "", false, s.storageError(err)
+	}
+	return snap.Labels, snap.LabelFingerprint, true, nil
+}
 
-	rb := &compute.ZoneSetLabelsRequest{
-		LabelFingerprint: d.LabelFingerprint,
-		Labels:           currentLabels,
+// setLabels replaces the labels of the given disk or snapshot
+func (s *gceOps) setLabels(name string, snapshot bool, fingerprint string, labels map[string]string) error {
+	var err error
+	if snapshot {
+		_, err = s.service.Snapshots.SetLabels(s.inst.project, name, &compute.GlobalSetLabelsRequest{
+			LabelFingerprint: fingerprint,
+			Labels:           labels,
+		}).Do()
+	} else {
+		_, err = s.service.Disks.SetLabels(s.inst.project, s.inst.zone, name, &compute.ZoneSetLabelsRequest{
+			LabelFingerprint: fingerprint,
+			Labels:           labels,
+		}).Do()
 	}
+	return s.storageError(err)
+}
 
-	_, err = s.service.Disks.SetLabels(s.inst.project, s.inst.zone, d.Name, rb).Do()
-	return err
+// Modify is not supported, the type of a persistent disk can only be changed
+// by recreating it from a snapshot
+func (s *gceOps) Modify(diskName string, spec storageops.VolumeSpecUpdate) error {
+	return storageops.ErrNotSupported
+}
+
+func (s *gceOps) Expand(diskName string, newSizeGiB uint64) (uint64, error) {
+	d, err := s.service.Disks.Get(s.inst.project, s.inst.zone, diskName).Do()
+	if err != nil {
+		return 0, err
+	}
+	currentSize := uint64(d.SizeGb)
+	if newSizeGiB == currentSize {
+		return currentSize, nil
+	}
+	if newSizeGiB < currentSize {
+		return 0, storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("disk %s of %d GiB cannot be shrunk to %d GiB",
+				diskName, currentSize, newSizeGiB), "")
+	}
+
+	rb := &compute.DisksResizeRequest{SizeGb: int64(newSizeGiB)}
+	if _, err := s.service.Disks.Resize(s.inst.project, s.inst.zone, diskName, rb).Do(); err != nil {
+		return 0, s.storageError(err)
+	}
+	if err := s.checkDiskStatus(diskName, s.inst.zone, STATUS_READY); err != nil {
+		return 0, err
+	}
+	return newSizeGiB, nil
 }
 
-func (s *gceOps) Attach(diskName string) (string, error) {
+func (s *gceOps) Attach(diskName string, options map[string]string) (string, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
@@ -136,6 +284,9 @@ func (s *gceOps) Attach(diskName string) (string, error) {
 		DeviceName: d.Name,
 		Source:     diskURL,
 	}
+	if options[storageops.AttachOptionReadOnly] == "true" {
+		rb.Mode = "READ_ONLY"
+	}
 
 	_, err = s.service.Instances.AttachDisk(
 		s.inst.project,
@@ -143,7 +294,7 @@ func (s *gceOps) Attach(diskName string) (string, error) {
 		s.inst.name,
 		rb).Do()
 	if err != nil {
-		return "", err
+		return "", s.storageError(err)
 	}
 
 	devicePath, err := s.waitForAttach(d, time.Minute)
@@ -157,7 +308,11 @@ func (s *gceOps) Attach(diskName string) (string, error) {
 func (s *gceOps) Create(
 	template interface{},
 	labels map[string]string,
-) (interface{}, error) {
+) (*storageops.Volume, error) {
+	template, labels, err := storageops.VolumeTemplate(s.Name(), template, labels)
+	if err != nil {
+		return nil, err
+	}
 	v, ok := template.(*compute.Disk)
 	if !ok {
 		return nil, storageops.NewStorageError(storageops.ErrVolInval,
@@ -174,10 +329,18 @@ func (s *gceOps) Create(
 		Type:           v.Type,
 		Zone:           path.Base(v.Zone),
 	}
+	if len(v.Zone) == 0 {
+		newDisk.Zone = s.inst.zone
+	}
+	// Disk types can be given by name, e.g. pd-ssd
+	if len(newDisk.Type) > 0 && !strings.Contains(newDisk.Type, "/") {
+		newDisk.Type = fmt.Sprintf("projects/%s/zones/%s/diskTypes/%s",
+			s.inst.project, newDisk.Zone, newDisk.Type)
+	}
 
 	resp, err := s.service.Disks.Insert(s.inst.project, newDisk.Zone, newDisk).Do()
 	if err != nil {
-		return nil, err
+		return nil, s.storageError(err)
 	}
 
 	if err = s.checkDiskStatus(newDisk.Name, newDisk.Zone, STATUS_READY); err != nil {
@@ -189,7 +352,7 @@ func (s *gceOps) Create(
 		return nil, err
 	}
 
-	return d, err
+	return toVolume(d), err
 }
 
 func (s *gceOps) DeleteFrom(id, _ string) error {
@@ -206,7 +369,7 @@ func (s *gceOps) Delete(id string) error {
 				if disk.Name == id {
 					found = true
 					_, err := s.service.Disks.Delete(s.inst.project, path.Base(disk.Zone), id).Do()
-					return err
+					return s.storageError(err)
 				}
 			}
 		}
@@ -238,7 +401,7 @@ func (s *gceOps) detachInternal(devicePath, instanceName string) error {
 		instanceName,
 		devicePath).Do()
 	if err != nil {
-		return err
+		return s.storageError(err)
 	}
 
 	var d *compute.Disk
@@ -329,8 +492,8 @@ func (s *gceOps) Enumerate(
 	volumeIds []*string,
 	labels map[string]string,
 	setIdentifier string,
-) (map[string][]interface{}, error) {
-	sets := make(map[string][]interface{})
+) (map[string][]*storageops.Volume, error) {
+	sets := make(map[string][]*storageops.Volume)
 	found := false
 
 	allDisks, err := s.getDisksFromAllZones(formatLabels(labels))
@@ -340,19 +503,19 @@ func (s *gceOps) Enumerate(
 
 	for _, disk := range allDisks {
 		if len(setIdentifier) == 0 {
-			storageops.AddElementToMap(sets, disk, storageops.SetIdentifierNone)
+			storageops.AddElementToMap(sets, toVolume(disk), storageops.SetIdentifierNone)
 		} else {
 			found = false
 			for key := range disk.Labels {
 				if key == setIdentifier {
-					storageops.AddElementToMap(sets, disk, key)
+					storageops.AddElementToMap(sets, toVolume(disk), key)
 					found = true
 					break
 				}
 			}
 
 			if !found {
-				storageops.AddElementToMap(sets, disk, storageops.SetIdentifierNone)
+				storageops.AddElementToMap(sets, toVolume(disk), storageops.SetIdentifierNone)
 			}
 		}
 	}
@@ -360,15 +523,100 @@ func (s *gceOps) Enumerate(
 	return sets, nil
 }
 
+var _ storageops.StreamingEnumerator = &gceOps{}
+
+// streamPageSize is the number of disks EnumerateEach requests per list call
+const streamPageSize = 500
+
+// errStopEnumerate stops the pages of EnumerateEach
+var errStopEnumerate = errors.New("enumeration stopped")
+
+// EnumerateEach calls fn with every disk matching the given labels one page
+// of streamPageSize disks at a time. Like Enumerate, volumeIds are ignored
+// and the set of a disk is the setIdentifier label key if it has it.
+func (s *gceOps) EnumerateEach(
+	volumeIds []*string,
+	labels map[string]string,
+	setIdentifier string,
+	fn func(set string, vol *storageops.Volume) bool,
+) error {
+	req := s.service.Disks.AggregatedList(s.inst.project).MaxResults(streamPageSize)
+	if len(labels) > 0 {
+		req = req.Filter(generateListFilterFromLabels(formatLabels(labels)))
+	}
+	err := req.Pages(context.Background(), func(page *compute.DiskAggregatedList) error {
+		for _, scoped := range page.Items {
+			for _, disk := range scoped.Disks {
+				set := storageops.SetIdentifierNone
+				if _, ok := disk.Labels[setIdentifier]; ok && len(setIdentifier) > 0 {
+					set = setIdentifier
+				}
+				if !fn(set, toVolume(disk)) {
+					return errStopEnumerate
+				}
+			}
+		}
+		return nil
+	})
+	if err == errStopEnumerate {
+		return nil
+	}
+	return s.storageError(err)
+}
+
+// FreeDevices returns the device names of the free disk slots on the
+// instance. blockDeviceMappings are the *compute.AttachedDisk of the instance
+// as returned by Describe. GCE does not assign devices by name, so the
+// returned names follow the default GCE device naming scheme.
 func (s *gceOps) FreeDevices(
 	blockDeviceMappings []interface{},
 	rootDeviceName string,
 ) ([]string, error) {
-	return nil, fmt.Errorf("function not implemented")
+	instance, err := s.describeinstance()
+	if err != nil {
+		return nil, err
+	}
+
+	used := make(map[string]bool)
+	for _, b := range blockDeviceMappings {
+		disk, ok := b.(*compute.AttachedDisk)
+		if !ok {
+			return nil, storageops.NewStorageError(storageops.ErrVolInval,
+				fmt.Sprintf("invalid block device mapping %T", b), s.inst.name)
+		}
+		used[disk.DeviceName] = true
+	}
+
+	max := maxDisksPerInstance(path.Base(instance.MachineType))
+	var free []string
+	for i := 0; len(used)+len(free) < max; i++ {
+		name := fmt.Sprintf("persistent-disk-%d", i)
+		if !used[name] {
+			free = append(free, googleDiskPrefix+name)
+		}
+	}
+	if len(free) == 0 {
+		return nil, fmt.Errorf("No more free devices: %d disks attached to %s",
+			len(used), s.inst.name)
+	}
+	return free, nil
+}
+
+// maxDisksPerInstance returns the maximum number of persistent disks that
+// can be attached to an instance of the given machine type
+// https://cloud.google.com/compute/docs/disks#pdnumberlimits
+func maxDisksPerInstance(machineType string) int {
+	switch machineType {
+	case "f1-micro", "g1-small", "e2-micro", "e2-small", "e2-medium":
+		return 16
+	}
+	return 128
 }
 
 func (s *gceOps) GetDeviceID(disk interface{}) (string, error) {
-	if d, ok := disk.(*compute.Disk); ok {
+	if id, ok := storageops.TypedID(disk); ok {
+		return id, nil
+	} else if d, ok := disk.(*compute.Disk); ok {
 		return d.Name, nil
 	} else if d, ok := disk.(*compute.Snapshot); ok {
 		return d.Name, nil
@@ -377,16 +625,16 @@ func (s *gceOps) GetDeviceID(disk interface{}) (string, error) {
 	}
 }
 
-func (s *gceOps) Inspect(diskNames []*string) ([]interface{}, error) {
+func (s *gceOps) Inspect(diskNames []*string) ([]*storageops.Volume, error) {
 	allDisks, err := s.getDisksFromAllZones(nil)
 	if err != nil {
 		return nil, err
 	}
 
-	var disks []interface{}
+	var disks []*storageops.Volume
 	for _, id := range diskNames {
 		if d, ok := allDisks[*id]; ok {
-			disks = append(disks, d)
+			disks = append(disks, toVolume(d))
 		} else {
 			return nil, fmt.Errorf("disk %s not found", *id)
 		}
@@ -399,39 +647,31 @@ func (s *gceOps) RemoveTags(
 	diskName string,
 	labels map[string]string,
 ) error {
-	d, err := s.service.Disks.Get(s.inst.project, s.inst.zone, diskName).Do()
+	currentLabels, fingerprint, snapshot, err := s.resourceLabels(diskName)
 	if err != nil {
 		return err
 	}
-
-	if len(d.Labels) != 0 {
-		currentLabels := d.Labels
-		for k := range formatLabels(labels) {
-			delete(currentLabels, k)
-		}
-
-		rb := &compute.ZoneSetLabelsRequest{
-			LabelFingerprint: d.LabelFingerprint,
-			Labels:           currentLabels,
-		}
-
-		_, err = s.service.Disks.SetLabels(s.inst.project, s.inst.zone, d.Name, rb).Do()
+	if len(currentLabels) == 0 {
+		return nil
 	}
 
-	return err
+	for k := range formatLabels(labels) {
+		delete(currentLabels, k)
+	}
+	return s.setLabels(diskName, snapshot, fingerprint, currentLabels)
 }
 
 func (s *gceOps) Snapshot(
 	disk string,
 	readonly bool,
-) (interface{}, error) {
+) (*storageops.Snapshot, error) {
 	rb := &compute.Snapshot{
 		Name: fmt.Sprintf("snap-%d%02d%02d", time.Now().Year(), time.Now().Month(), time.Now().Day()),
 	}
 
 	_, err := s.service.Disks.CreateSnapshot(s.inst.project, s.inst.zone, disk, rb).Do()
 	if err != nil {
-		return nil, err
+		return nil, s.storageError(err)
 	}
 
 	if err = s.checkSnapStatus(rb.Name, STATUS_READY); err != nil {
@@ -443,21 +683,103 @@ func (s *gceOps) Snapshot(
 		return nil, err
 	}
 
-	return snap, err
+	return toSnapshot(snap)
 }
 
 func (s *gceOps) SnapshotDelete(snapID string) error {
 	_, err := s.service.Snapshots.Delete(s.inst.project, snapID).Do()
-	return err
+	return s.storageError(err)
+}
+
+func (s *gceOps) SnapshotEnumerate(
+	filter *storageops.SnapshotFilter,
+) ([]*storageops.Snapshot, error) {
+	call := s.service.Snapshots.List(s.inst.project)
+	if filter != nil {
+		// GCE labels are lower case, see formatLabels
+		formatted := *filter
+		formatted.Labels = formatLabels(filter.Labels)
+		filter = &formatted
+
+		var exprs []string
+		for k, v := range filter.Labels {
+			exprs = append(exprs, fmt.Sprintf("(labels.%s = %q)", k, v))
+		}
+		if len(filter.State) > 0 {
+			exprs = append(exprs, fmt.Sprintf("(status = %q)", filter.State))
+		}
+		if len(exprs) > 0 {
+			call = call.Filter(strings.Join(exprs, " "))
+		}
+	}
+
+	var snaps []*storageops.Snapshot
+	err := call.Pages(context.Background(), func(page *compute.SnapshotList) error {
+		for _, snap := range page.Items {
+			typed, err := toSnapshot(snap)
+			if err != nil {
+				return err
+			}
+			if filter.Match(typed.VolumeID, typed.Created, typed.State, typed.Labels) {
+				snaps = append(snaps, typed)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	return snaps, nil
+}
+
+func (s *gceOps) SnapshotRestore(
+	snapID, zone string,
+	labels map[string]string,
+) (*storageops.Volume, error) {
+	if len(zone) == 0 {
+		zone = s.inst.zone
+	}
+	// Disk names are at most 63 characters
+	name := snapID
+	if len(name) > 54 {
+		name = name[:54]
+	}
+	return s.Create(&compute.Disk{
+		Name:           fmt.Sprintf("%s-%s", name, uuid.New()[:8]),
+		SourceSnapshot: fmt.Sprintf("projects/%s/global/snapshots/%s", s.inst.project, snapID),
+		Zone:           zone,
+	}, labels)
 }
 
+func (s *gceOps) SnapshotStatus(snapID string) (*storageops.SnapshotStatus, error) {
+	snap, err := s.service.Snapshots.Get(s.inst.project, snapID).Do()
+	if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == http.StatusNotFound {
+		return nil, storageops.NewStorageError(storageops.ErrVolNotFound,
+			fmt.Sprintf("snapshot %s not found", snapID), s.inst.name)
+	} else if err != nil {
+		return nil, s.storageError(err)
+	}
+	status := &storageops.SnapshotStatus{
+		ID:        snap.Name,
+		State:     snap.Status,
+		Completed: snap.Status == STATUS_READY,
+		Failed:    snap.Status == "FAILED",
+	}
+	if status.Completed {
+		status.Progress = 100
+	}
+	return status, nil
+}
+
+// Tags returns the labels of the given disk or, if there is no such disk, of
+// the snapshot of that name
 func (s *gceOps) Tags(diskName string) (map[string]string, error) {
-	d, err := s.service.Disks.Get(s.inst.project, s.inst.zone, diskName).Do()
+	labels, _, _, err := s.resourceLabels(diskName)
 	if err != nil {
 		return nil, err
 	}
 
-	return d.Labels, nil
+	return labels, nil
 }
 
 func (s *gceOps) available(v *compute.Disk) bool {
@@ -465,7 +787,8 @@ func (s *gceOps) available(v *compute.Disk) bool {
 }
 
 func (s *gceOps) checkDiskStatus(id string, zone string, desired string) error {
-	_, err := task.DoRetryWithTimeout(
+	_, err := s.cfg.RetryPolicy.For(storageops.RetryOpCreate).Retry(
+		fmt.Sprintf("wait for disk %s to be %s", id, desired),
 		func() (interface{}, bool, error) {
 			d, err := s.service.Disks.Get(s.inst.project, zone, id).Do()
 			if err != nil {
@@ -484,15 +807,14 @@ func (s *gceOps) checkDiskStatus(id string, zone string, desired string) error {
 			}
 
 			return nil, false, nil
-		},
-		storageops.ProviderOpsTimeout,
-		storageops.ProviderOpsRetryInterval)
+		})
 
 	return err
 }
 
 func (s *gceOps) checkSnapStatus(id string, desired string) error {
-	_, err := task.DoRetryWithTimeout(
+	_, err := s.cfg.RetryPolicy.For(storageops.RetryOpSnapshot).Retry(
+		fmt.Sprintf("wait for snapshot %s to be %s", id, desired),
 		func() (interface{}, bool, error) {
 			snap, err := s.service.Snapshots.Get(s.inst.project, id).Do()
 			if err != nil {
@@ -511,9 +833,7 @@ func (s *gceOps) checkSnapStatus(id string, desired string) error {
 			}
 
 			return nil, false, nil
-		},
-		storageops.ProviderOpsTimeout,
-		storageops.ProviderOpsRetryInterval)
+		})
 
 	return err
 }
@@ -588,7 +908,8 @@ func (s *gceOps) waitForDetach(
 	timeout time.Duration,
 ) error {
 
-	_, err := task.DoRetryWithTimeout(
+	_, err := s.cfg.RetryPolicy.For(storageops.RetryOpDetach).Retry(
+		fmt.Sprintf("wait for disk %s to detach", path.Base(diskURL)),
 		func() (interface{}, bool, error) {
 			inst, err := s.describeinstance()
 			if err != nil {
@@ -605,9 +926,7 @@ func (s *gceOps) waitForDetach(
 
 			return nil, false, nil
 
-		},
-		storageops.ProviderOpsTimeout,
-		storageops.ProviderOpsRetryInterval)
+		})
 
 	return err
 }
@@ -617,20 +936,26 @@ func (s *gceOps) waitForAttach(
 	disk *compute.Disk,
 	timeout time.Duration,
 ) (string, error) {
-	devicePath, err := task.DoRetryWithTimeout(
-		func() (interface{}, bool, error) {
-			devicePath, err := s.DevicePath(disk.Name)
-			if se, ok := err.(*storageops.StorageError); ok &&
-				se.Code == storageops.ErrVolAttachedOnRemoteNode {
-				return "", false, err
-			} else if err != nil {
-				return "", true, err
-			}
+	key := func() string { return "gce/attached/" + path.Base(disk.Type) }
+	op := fmt.Sprintf("wait for disk %s to attach", disk.Name)
+	f := func() (interface{}, bool, error) {
+		devicePath, err := s.DevicePath(disk.Name)
+		if storageops.IsErrorCode(err, storageops.ErrVolAttachedOnRemoteNode) {
+			return "", false, err
+		} else if err != nil {
+			return "", true, err
+		}
 
-			return devicePath, false, nil
-		},
-		storageops.ProviderOpsTimeout,
-		storageops.ProviderOpsRetryInterval)
+		return devicePath, false, nil
+	}
+
+	var devicePath interface{}
+	var err error
+	if s.cfg.RetryPolicy == nil {
+		devicePath, err = storageops.PollWithTimeout(op, key, f, storageops.ProviderOpsTimeout)
+	} else {
+		devicePath, err = s.cfg.RetryPolicy.For(storageops.RetryOpAttach).Retry(op, f)
+	}
 	if err != nil {
 		return "", err
 	}
@@ -688,7 +1013,7 @@ func (s *gceOps) diskIDToBlockDevPathWithRetry(devPath string) (string, error) {
 		if path, err = s.diskIDToBlockDevPath(devPath); err == nil {
 			return path, nil
 		}
-		logrus.Warnf(err.Error())
+		logrus.Warnf("%v", err)
 		retryCount++
 		if retryCount >= devicePathMaxRetryCount {
 			break
@@ -727,3 +1052,26 @@ func formatLabels(labels map[string]string) map[string]string {
 	}
 	return newLabels
 }
+
+// VolumeFromDevicePath returns the disk attached to this instance at the given
+// device path, using the udev by-id symlinks of the attached disks
+func (s *gceOps) VolumeFromDevicePath(devicePath string) (*storageops.Volume, error) {
+	dev, err := storageops.BaseDevice(devicePath)
+	if err != nil {
+		return nil, storageops.NewStorageError(storageops.ErrInvalidDevicePath,
+			err.Error(), s.inst.name)
+	}
+	mappings, err := s.DeviceMappings()
+	if err != nil {
+		return nil, err
+	}
+	diskName, err := storageops.VolumeIDFromMappings(mappings, dev)
+	if err != nil {
+		return nil, err
+	}
+	d, err := s.service.Disks.Get(s.inst.project, s.inst.zone, diskName).Do()
+	if err != nil {
+		return nil, err
+	}
+	return toVolume(d), nil
+}
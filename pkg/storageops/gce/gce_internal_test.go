@@ -0,0 +1,163 @@
+package gce
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// fakeCompute serves the instance and the snapshots of the compute API
+type fakeCompute struct {
+	instance  *compute.Instance
+	snapshots []*compute.Snapshot
+	filter    string
+	status    int
+}
+
+func (f *fakeCompute) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.status != 0 {
+		http.Error(w, http.StatusText(f.status), f.status)
+		return
+	}
+	if r.Method == http.MethodGet && r.URL.Path == "/project-1/global/snapshots" {
+		f.filter = r.URL.Query().Get("filter")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&compute.SnapshotList{Items: f.snapshots})
+		return
+	}
+	if r.Method != http.MethodGet ||
+		r.URL.Path != "/project-1/zones/zone-a/instances/"+f.instance.Name {
+		http.Error(w, fmt.Sprintf("unexpected %s %s", r.Method, r.URL.Path), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(f.instance)
+}
+
+// newFakeOps returns a gceOps of an instance of the given machine type with
+// the given disks, served by a fake compute API
+func newFakeOps(t *testing.T, machineType string, disks []*compute.AttachedDisk) (*gceOps, func()) {
+	s, _, done := newFakeCompute(t, machineType, disks)
+	return s, done
+}
+
+// newFakeCompute is newFakeOps also returning the fake compute API
+func newFakeCompute(
+	t *testing.T,
+	machineType string,
+	disks []*compute.AttachedDisk,
+) (*gceOps, *fakeCompute, func()) {
+	api := &fakeCompute{instance: &compute.Instance{
+		Name:        "instance-1",
+		MachineType: "zones/zone-a/machineTypes/" + machineType,
+		Disks:       disks,
+	}}
+	server := httptest.NewServer(api)
+	service, err := compute.New(server.Client())
+	require.NoError(t, err)
+	service.BasePath = server.URL + "/"
+	return &gceOps{
+		inst:    &instance{name: "instance-1", zone: "zone-a", project: "project-1"},
+		service: service,
+	}, api, server.Close
+}
+
+// attachedDisks returns n attached disks with the default device names, the
+// first of which is the boot disk
+func attachedDisks(n int) []*compute.AttachedDisk {
+	disks := make([]*compute.AttachedDisk, n)
+	for i := range disks {
+		disks[i] = &compute.AttachedDisk{
+			Boot:       i == 0,
+			DeviceName: fmt.Sprintf("persistent-disk-%d", i),
+		}
+	}
+	return disks
+}
+
+func mappings(disks []*compute.AttachedDisk) []interface{} {
+	m := make([]interface{}, len(disks))
+	for i, d := range disks {
+		m[i] = d
+	}
+	return m
+}
+
+func TestFreeDevices(t *testing.T) {
+	tests := []struct {
+		name        string
+		machineType string
+		disks       []*compute.AttachedDisk
+		free        int
+		first       string
+	}{
+		{"shared core", "e2-small", attachedDisks(2), 14, "persistent-disk-2"},
+		{"standard", "n1-standard-4", attachedDisks(3), 125, "persistent-disk-3"},
+		{"gap", "f1-micro", []*compute.AttachedDisk{
+			{Boot: true, DeviceName: "persistent-disk-0"},
+			{DeviceName: "persistent-disk-2"},
+		}, 14, "persistent-disk-1"},
+		{"custom names", "g1-small", []*compute.AttachedDisk{
+			{Boot: true, DeviceName: "persistent-disk-0"},
+			{DeviceName: "data"},
+		}, 14, "persistent-disk-1"},
+	}
+	for _, tt := range tests {
+		s, done := newFakeOps(t, tt.machineType, tt.disks)
+		free, err := s.FreeDevices(mappings(tt.disks), "")
+		done()
+		require.NoError(t, err, tt.name)
+		assert.Len(t, free, tt.free, tt.name)
+		assert.Equal(t, googleDiskPrefix+tt.first, free[0], tt.name)
+		for _, d := range tt.disks {
+			assert.NotContains(t, free, googleDiskPrefix+d.DeviceName, tt.name)
+		}
+	}
+}
+
+func TestFreeDevicesErrors(t *testing.T) {
+	disks := attachedDisks(16)
+	s, done := newFakeOps(t, "e2-medium", disks)
+	defer done()
+
+	_, err := s.FreeDevices(mappings(disks), "")
+	assert.Error(t, err, "an instance with all disk slots in use has no free devices")
+
+	_, err = s.FreeDevices([]interface{}{"persistent-disk-1"}, "")
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval), "%v", err)
+
+	s.inst.name = "instance-2"
+	_, err = s.FreeDevices(nil, "")
+	assert.Error(t, err, "instance lookup errors must be returned")
+}
+
+func TestSnapshotEnumerate(t *testing.T) {
+	s, api, done := newFakeCompute(t, "e2-small", nil)
+	defer done()
+	created := "2020-01-02T03:04:05Z"
+	api.snapshots = []*compute.Snapshot{
+		{Name: "snap-1", SourceDisk: "zones/zone-a/disks/disk-1", Status: "READY",
+			CreationTimestamp: created, Labels: map[string]string{"app": "db"}},
+		{Name: "snap-2", SourceDisk: "zones/zone-a/disks/disk-2", Status: "READY",
+			CreationTimestamp: created, Labels: map[string]string{"app": "web"}},
+	}
+
+	filter := &storageops.SnapshotFilter{Labels: map[string]string{"App": "DB"}}
+	snaps, err := s.SnapshotEnumerate(filter)
+	require.NoError(t, err)
+	require.Len(t, snaps, 1, "labels must match in the lower case GCE stores them in")
+	assert.Equal(t, "snap-1", snaps[0].ID)
+	assert.Equal(t, `(labels.app = "db")`, api.filter)
+	assert.Equal(t, "DB", filter.Labels["App"], "the filter of the caller must not change")
+
+	api.status = http.StatusForbidden
+	_, err = s.SnapshotEnumerate(nil)
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrUnauthorized), "%v", err)
+}
@@ -0,0 +1,71 @@
+package storageops
+
+import (
+	"fmt"
+	"time"
+)
+
+// VolumeHealth is the provider independent health of a volume
+type VolumeHealth string
+
+const (
+	// VolumeHealthOK volumes pass all provider checks
+	VolumeHealthOK VolumeHealth = "ok"
+	// VolumeHealthImpaired volumes serve I/O with degraded performance or
+	// have events scheduled that impact them
+	VolumeHealthImpaired VolumeHealth = "impaired"
+	// VolumeHealthIOError volumes have I/O disabled by the provider, e.g.
+	// after a potential data inconsistency
+	VolumeHealthIOError VolumeHealth = "io-error"
+	// VolumeHealthUnknown volumes have no health checks yet, e.g. right
+	// after they were created
+	VolumeHealthUnknown VolumeHealth = "unknown"
+)
+
+// VolumeStatusEvent is a provider event affecting a volume
+type VolumeStatusEvent struct {
+	// Type of the event, e.g. potential-data-inconsistency
+	Type string
+	// Description of the event
+	Description string
+	// NotBefore is when the event starts, zero if unknown
+	NotBefore time.Time
+	// NotAfter is when the event ends, zero if unknown
+	NotAfter time.Time
+}
+
+// VolumeStatus is the health of a volume
+type VolumeStatus struct {
+	// VolumeID of the volume
+	VolumeID string
+	// Health is the normalized health of the volume
+	Health VolumeHealth
+	// Checks are the results of the provider checks, e.g. io-enabled:
+	// passed and io-performance: degraded
+	Checks map[string]string
+	// Events affecting the volume
+	Events []*VolumeStatusEvent
+}
+
+func (s *VolumeStatus) String() string {
+	return fmt.Sprintf("volume %s is %s, checks: %v events: %d",
+		s.VolumeID, s.Health, s.Checks, len(s.Events))
+}
+
+// VolumeStatusOps is implemented by drivers that can report the health of
+// volumes
+type VolumeStatusOps interface {
+	// VolumeStatus returns the health of the given volume
+	VolumeStatus(volumeID string) (*VolumeStatus, error)
+}
+
+// InspectVolumeStatus returns the health of the given volume using the
+// driver's VolumeStatusOps. It returns ErrNotSupported if the driver has
+// none. Wrappers like middlewares hide the VolumeStatusOps of the driver
+// they wrap, see AttachBatch.
+func InspectVolumeStatus(ops Ops, volumeID string) (*VolumeStatus, error) {
+	if s, ok := ops.(VolumeStatusOps); ok {
+		return s.VolumeStatus(volumeID)
+	}
+	return nil, ErrNotSupported
+}
@@ -0,0 +1,86 @@
+package storageops
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// The probes of ProbeHost, replaced by tests
+var (
+	kernelRelease = unameRelease
+	lookPath      = exec.LookPath
+	hostCommand   = exec.Command
+	statPath      = os.Stat
+)
+
+// HostCapabilities describes the block device tooling available on this host
+type HostCapabilities struct {
+	// KernelVersion is the running kernel release
+	KernelVersion string
+	// NvmeCli is the path of the nvme binary. Empty if not installed.
+	NvmeCli string
+	// NvmeCliVersion is the version reported by nvme-cli
+	NvmeCliVersion string
+	// Udev is true if udev is running and managing /dev
+	Udev bool
+	// DiskByID is true if /dev/disk/by-id symlinks are available
+	DiskByID bool
+	// SysBlock is true if block devices can be inspected under /sys/block
+	SysBlock bool
+}
+
+// String returns a single line summary of the host capabilities
+func (h *HostCapabilities) String() string {
+	return fmt.Sprintf("kernel: %s nvme-cli: %q (%s) udev: %v by-id: %v sysfs: %v",
+		h.KernelVersion, h.NvmeCli, h.NvmeCliVersion, h.Udev, h.DiskByID, h.SysBlock)
+}
+
+// KernelAtLeast returns true if the running kernel is at least major.minor
+func (h *HostCapabilities) KernelAtLeast(major, minor int) bool {
+	parts := strings.SplitN(h.KernelVersion, ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	kmajor, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false
+	}
+	kminor, err := strconv.Atoi(strings.TrimRightFunc(parts[1], func(r rune) bool {
+		return r < '0' || r > '9'
+	}))
+	if err != nil {
+		return false
+	}
+	return kmajor > major || (kmajor == major && kminor >= minor)
+}
+
+// ProbeHost probes the kernel version, nvme-cli, udev and sysfs. The kernel
+// version is only probed on linux.
+func ProbeHost() *HostCapabilities {
+	h := &HostCapabilities{KernelVersion: kernelRelease()}
+
+	if path, err := lookPath("nvme"); err == nil {
+		h.NvmeCli = path
+		if out, err := hostCommand(path, "version").Output(); err == nil {
+			// nvme version 1.9
+			fields := strings.Fields(string(out))
+			if len(fields) > 0 {
+				h.NvmeCliVersion = fields[len(fields)-1]
+			}
+		}
+	}
+
+	if _, err := statPath("/run/udev/control"); err == nil {
+		h.Udev = true
+	}
+	if _, err := statPath("/dev/disk/by-id"); err == nil {
+		h.DiskByID = true
+	}
+	if _, err := statPath("/sys/block"); err == nil {
+		h.SysBlock = true
+	}
+	return h
+}
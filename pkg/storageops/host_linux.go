@@ -0,0 +1,26 @@
+//go:build linux
+// +build linux
+
+package storageops
+
+import (
+	"strings"
+	"syscall"
+)
+
+// unameRelease returns the release of the running kernel, empty if uname
+// fails
+func unameRelease() string {
+	var uts syscall.Utsname
+	if err := syscall.Uname(&uts); err != nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, c := range uts.Release {
+		if c == 0 {
+			break
+		}
+		b.WriteByte(byte(c))
+	}
+	return b.String()
+}
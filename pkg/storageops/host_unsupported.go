@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package storageops
+
+// unameRelease returns an empty release, as only linux kernels are probed
+func unameRelease() string {
+	return ""
+}
@@ -0,0 +1,276 @@
+package ibm
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/IBM/go-sdk-core/v5/core"
+	"github.com/IBM/vpc-go-sdk/vpcv1"
+)
+
+const (
+	// apiVersion is the date version of the VPC API the driver is written
+	// against
+	apiVersion = "2024-04-30"
+	// defaultIAMEndpoint is the endpoint of the IAM token service
+	defaultIAMEndpoint = "https://iam.cloud.ibm.com"
+	// listPageSize is the number of items requested per page of list calls
+	listPageSize = 100
+)
+
+// Volume, attachment and snapshot states
+const (
+	statusAvailable    = "available"
+	statusFailed       = "failed"
+	statusAttached     = "attached"
+	snapshotStable     = "stable"
+	snapshotFailed     = "failed"
+	attachmentTypeData = vpcv1.VolumeAttachmentTypeDataConst
+)
+
+// APIError is an error response of the VPC or IAM API
+type APIError struct {
+	// StatusCode is the HTTP status of the response
+	StatusCode int
+	// Code is the first error code, e.g. volume_not_found
+	Code string
+	// Message is the first error message
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// apiError returns the given error of a VPC or IAM API call as an *APIError
+// with the HTTP status and first error code of the given response
+func apiError(resp *core.DetailedResponse, err error) error {
+	var authErr *core.AuthenticationError
+	if errors.As(err, &authErr) {
+		resp = authErr.Response
+	}
+	if err == nil || resp == nil {
+		return err
+	}
+	apiErr := &APIError{StatusCode: resp.StatusCode, Message: err.Error()}
+	if body, ok := resp.Result.(map[string]interface{}); ok {
+		if errs, ok := body["errors"].([]interface{}); ok && len(errs) > 0 {
+			if first, ok := errs[0].(map[string]interface{}); ok {
+				apiErr.Code, _ = first["code"].(string)
+			}
+		}
+	}
+	return apiErr
+}
+
+// client wraps the VPC SDK calls of the driver, returning their errors as
+// *APIError
+type client struct {
+	vpc *vpcv1.VpcV1
+}
+
+// newClient returns a client of the given VPC API endpoint authenticated
+// with IAM tokens of the given API key
+func newClient(apiKey, endpoint, iamEndpoint string, httpClient *http.Client) (*client, error) {
+	vpc, err := vpcv1.NewVpcV1(&vpcv1.VpcV1Options{
+		URL:     strings.TrimSuffix(endpoint, "/") + "/v1",
+		Version: core.StringPtr(apiVersion),
+		Authenticator: &core.IamAuthenticator{
+			ApiKey: apiKey,
+			URL:    strings.TrimSuffix(iamEndpoint, "/"),
+			Client: httpClient,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	vpc.Service.SetHTTPClient(httpClient)
+	return &client{vpc: vpc}, nil
+}
+
+func (c *client) getVolume(id string) (*vpcv1.Volume, error) {
+	vol, resp, err := c.vpc.GetVolume(c.vpc.NewGetVolumeOptions(id))
+	if err != nil {
+		return nil, apiError(resp, err)
+	}
+	return vol, nil
+}
+
+func (c *client) createVolume(prototype *vpcv1.VolumePrototype) (*vpcv1.Volume, error) {
+	vol, resp, err := c.vpc.CreateVolume(c.vpc.NewCreateVolumeOptions(prototype))
+	if err != nil {
+		return nil, apiError(resp, err)
+	}
+	return vol, nil
+}
+
+// updateVolume patches the given fields, e.g. capacity or user_tags, of the
+// given volume
+func (c *client) updateVolume(id string, patch map[string]interface{}) (*vpcv1.Volume, error) {
+	vol, resp, err := c.vpc.UpdateVolume(c.vpc.NewUpdateVolumeOptions(id, patch))
+	if err != nil {
+		return nil, apiError(resp, err)
+	}
+	return vol, nil
+}
+
+func (c *client) deleteVolume(id string) error {
+	resp, err := c.vpc.DeleteVolume(c.vpc.NewDeleteVolumeOptions(id))
+	return apiError(resp, err)
+}
+
+// listVolumes calls fn with every volume of the given zone, or of the region
+// if empty, one page at a time. It stops when fn returns false.
+func (c *client) listVolumes(zone string, fn func(vol *vpcv1.Volume) bool) error {
+	opts := c.vpc.NewListVolumesOptions().SetLimit(listPageSize)
+	if len(zone) > 0 {
+		opts.SetZoneName(zone)
+	}
+	for {
+		page, resp, err := c.vpc.ListVolumes(opts)
+		if err != nil {
+			return apiError(resp, err)
+		}
+		for i := range page.Volumes {
+			if !fn(&page.Volumes[i]) {
+				return nil
+			}
+		}
+		start, err := page.GetNextStart()
+		if err != nil || start == nil {
+			return err
+		}
+		opts.SetStart(*start)
+	}
+}
+
+func (c *client) attachVolume(instanceID, volumeID string) (*vpcv1.VolumeAttachment, error) {
+	opts := c.vpc.NewCreateInstanceVolumeAttachmentOptions(instanceID,
+		&vpcv1.VolumeAttachmentPrototypeVolumeVolumeIdentityVolumeIdentityByID{
+			ID: core.StringPtr(volumeID),
+		}).SetDeleteVolumeOnInstanceDelete(false)
+	att, resp, err := c.vpc.CreateInstanceVolumeAttachment(opts)
+	if err != nil {
+		return nil, apiError(resp, err)
+	}
+	return att, nil
+}
+
+func (c *client) getVolumeAttachment(instanceID, id string) (*vpcv1.VolumeAttachment, error) {
+	att, resp, err := c.vpc.GetInstanceVolumeAttachment(
+		c.vpc.NewGetInstanceVolumeAttachmentOptions(instanceID, id))
+	if err != nil {
+		return nil, apiError(resp, err)
+	}
+	return att, nil
+}
+
+func (c *client) deleteVolumeAttachment(instanceID, id string) error {
+	resp, err := c.vpc.DeleteInstanceVolumeAttachment(
+		c.vpc.NewDeleteInstanceVolumeAttachmentOptions(instanceID, id))
+	return apiError(resp, err)
+}
+
+func (c *client) listVolumeAttachments(instanceID string) ([]vpcv1.VolumeAttachment, error) {
+	atts, resp, err := c.vpc.ListInstanceVolumeAttachments(
+		c.vpc.NewListInstanceVolumeAttachmentsOptions(instanceID))
+	if err != nil {
+		return nil, apiError(resp, err)
+	}
+	return atts.VolumeAttachments, nil
+}
+
+func (c *client) getInstance(id string) (*vpcv1.Instance, error) {
+	inst, resp, err := c.vpc.GetInstance(c.vpc.NewGetInstanceOptions(id))
+	if err != nil {
+		return nil, apiError(resp, err)
+	}
+	return inst, nil
+}
+
+func (c *client) createSnapshot(volumeID, name string, tags []string) (*vpcv1.Snapshot, error) {
+	snap, resp, err := c.vpc.CreateSnapshot(c.vpc.NewCreateSnapshotOptions(
+		&vpcv1.SnapshotPrototypeSnapshotBySourceVolume{
+			Name:         core.StringPtr(name),
+			UserTags:     tags,
+			SourceVolume: &vpcv1.VolumeIdentityByID{ID: core.StringPtr(volumeID)},
+		}))
+	if err != nil {
+		return nil, apiError(resp, err)
+	}
+	return snap, nil
+}
+
+func (c *client) getSnapshot(id string) (*vpcv1.Snapshot, error) {
+	snap, resp, err := c.vpc.GetSnapshot(c.vpc.NewGetSnapshotOptions(id))
+	if err != nil {
+		return nil, apiError(resp, err)
+	}
+	return snap, nil
+}
+
+func (c *client) updateSnapshot(id string, patch map[string]interface{}) error {
+	_, resp, err := c.vpc.UpdateSnapshot(c.vpc.NewUpdateSnapshotOptions(id, patch))
+	return apiError(resp, err)
+}
+
+func (c *client) deleteSnapshot(id string) error {
+	resp, err := c.vpc.DeleteSnapshot(c.vpc.NewDeleteSnapshotOptions(id))
+	return apiError(resp, err)
+}
+
+// listSnapshots calls fn with every snapshot of the given volume, or of the
+// region if empty, one page at a time. It stops when fn returns false.
+func (c *client) listSnapshots(volumeID string, fn func(snap *vpcv1.Snapshot) bool) error {
+	opts := c.vpc.NewListSnapshotsOptions().SetLimit(listPageSize)
+	if len(volumeID) > 0 {
+		opts.SetSourceVolumeID(volumeID)
+	}
+	for {
+		page, resp, err := c.vpc.ListSnapshots(opts)
+		if err != nil {
+			return apiError(resp, err)
+		}
+		for i := range page.Snapshots {
+			if !fn(&page.Snapshots[i]) {
+				return nil
+			}
+		}
+		start, err := page.GetNextStart()
+		if err != nil || start == nil {
+			return err
+		}
+		opts.SetStart(*start)
+	}
+}
+
+// listZones returns the available zones of the given region
+func (c *client) listZones(region string) ([]string, error) {
+	page, resp, err := c.vpc.ListRegionZones(c.vpc.NewListRegionZonesOptions(region))
+	if err != nil {
+		return nil, apiError(resp, err)
+	}
+	var zones []string
+	for _, z := range page.Zones {
+		if z.Status != nil && *z.Status == statusAvailable {
+			zones = append(zones, *z.Name)
+		}
+	}
+	return zones, nil
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func int64Value(i *int64) int64 {
+	if i == nil {
+		return 0
+	}
+	return *i
+}
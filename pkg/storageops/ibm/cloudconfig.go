@@ -0,0 +1,47 @@
+package ibm
+
+import (
+	"fmt"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/libopenstorage/openstorage/pkg/storageops/config"
+)
+
+func init() {
+	config.Register("ibm", newFromCloudConfig)
+}
+
+// newFromCloudConfig creates the driver of the given cloud config with the
+// API key of its apiKey credential, or of IBMCLOUD_API_KEY if not set. The instance and zone are read from the
+// instance metadata service unless set, the endpoint and iamEndpoint params
+// replace the API endpoints.
+func newFromCloudConfig(c *config.Config) (storageops.Ops, error) {
+	apiKey, err := c.RequiredCredential("apiKey", "IBMCLOUD_API_KEY")
+	if err != nil {
+		return nil, err
+	}
+	cfg := Config{
+		Endpoint:    c.Params["endpoint"],
+		IAMEndpoint: c.Params["iamEndpoint"],
+	}
+	if cfg.RetryPolicy, err = c.RetryPolicy(); err != nil {
+		return nil, err
+	}
+	if cfg.HTTP, err = c.HTTPConfig(); err != nil {
+		return nil, err
+	}
+
+	instanceID := c.Instance
+	if len(instanceID) == 0 {
+		if instanceID, err = metadata("id"); err != nil {
+			return nil, fmt.Errorf("error fetching instance ID. Err: %v", err)
+		}
+	}
+	zone := c.Zone
+	if len(zone) == 0 {
+		if zone, err = metadata("zone"); err != nil {
+			return nil, fmt.Errorf("error fetching instance zone. Err: %v", err)
+		}
+	}
+	return NewClient(apiKey, instanceID, zone, cfg)
+}
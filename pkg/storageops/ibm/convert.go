@@ -0,0 +1,119 @@
+package ibm
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/IBM/go-sdk-core/v5/core"
+	"github.com/IBM/vpc-go-sdk/vpcv1"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+func init() {
+	storageops.RegisterConverter("ibm", &converter{})
+	storageops.RegisterSizeRule("ibm", &storageops.SizeRule{
+		Granularity: storageops.GiB,
+		Min:         10 * storageops.GiB,
+		Max:         16000 * storageops.GiB,
+	})
+}
+
+type converter struct{}
+
+func (c *converter) ToVolume(raw interface{}) (*storageops.Volume, error) {
+	vol, ok := raw.(*vpcv1.Volume)
+	if !ok {
+		return nil, fmt.Errorf("invalid ibm volume %T", raw)
+	}
+	return toVolume(vol), nil
+}
+
+func toVolume(vol *vpcv1.Volume) *storageops.Volume {
+	v := &storageops.Volume{
+		ID:      stringValue(vol.ID),
+		Name:    stringValue(vol.Name),
+		SizeGiB: uint64(int64Value(vol.Capacity)),
+		State:   stringValue(vol.Status),
+		// Volumes are encrypted at rest with provider or user managed keys
+		Encrypted:  len(stringValue(vol.Encryption)) > 0,
+		Labels:     tagsToLabels(vol.UserTags),
+		AttachedTo: attachedTo(vol),
+		Raw:        vol,
+	}
+	for _, att := range vol.VolumeAttachments {
+		if att.Instance == nil {
+			continue
+		}
+		a := storageops.Attachment{InstanceID: stringValue(att.Instance.ID)}
+		if att.Device != nil {
+			a.Device = stringValue(att.Device.ID)
+		}
+		v.Attachments = append(v.Attachments, a)
+	}
+	if vol.Profile != nil {
+		v.Type = stringValue(vol.Profile.Name)
+	}
+	if vol.Zone != nil {
+		v.Zone = stringValue(vol.Zone.Name)
+	}
+	return v
+}
+
+// FromSpec returns the *vpcv1.VolumePrototype template of the given spec. The type
+// is the volume profile, volumes are always encrypted at rest.
+func (c *converter) FromSpec(spec *storageops.VolumeSpec) (interface{}, error) {
+	if err := spec.Unsupported("ibm", storageops.SpecFieldThroughput,
+		storageops.SpecFieldKMSKey); err != nil {
+		return nil, err
+	}
+	req := &vpcv1.VolumePrototype{}
+	if spec.Native != nil {
+		native, ok := spec.Native.(*vpcv1.VolumePrototype)
+		if !ok {
+			return nil, storageops.NativeTemplateError("ibm", spec.Native)
+		}
+		copied := *native
+		req = &copied
+	}
+	if spec.SizeGiB > 0 {
+		req.Capacity = core.Int64Ptr(int64(spec.SizeGiB))
+	}
+	if len(spec.Type) > 0 {
+		req.Profile = &vpcv1.VolumeProfileIdentityByName{Name: core.StringPtr(spec.Type)}
+	}
+	if spec.Iops > 0 {
+		req.Iops = core.Int64Ptr(spec.Iops)
+	}
+	if len(spec.Zone) > 0 {
+		req.Zone = &vpcv1.ZoneIdentityByName{Name: core.StringPtr(spec.Zone)}
+	}
+	if len(spec.SnapshotID) > 0 {
+		req.SourceSnapshot = &vpcv1.SnapshotIdentityByID{ID: core.StringPtr(spec.SnapshotID)}
+	}
+	return req, nil
+}
+
+func (c *converter) ToSnapshot(raw interface{}) (*storageops.Snapshot, error) {
+	snap, ok := raw.(*vpcv1.Snapshot)
+	if !ok {
+		return nil, fmt.Errorf("invalid ibm snapshot %T", raw)
+	}
+	return toSnapshot(snap), nil
+}
+
+func toSnapshot(snap *vpcv1.Snapshot) *storageops.Snapshot {
+	s := &storageops.Snapshot{
+		ID:      stringValue(snap.ID),
+		SizeGiB: uint64(int64Value(snap.MinimumCapacity)),
+		State:   stringValue(snap.LifecycleState),
+		Labels:  tagsToLabels(snap.UserTags),
+		Raw:     snap,
+	}
+	if snap.CreatedAt != nil {
+		s.Created = time.Time(*snap.CreatedAt)
+	}
+	if snap.SourceVolume != nil {
+		s.VolumeID = stringValue(snap.SourceVolume.ID)
+	}
+	return s
+}
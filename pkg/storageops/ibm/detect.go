@@ -0,0 +1,19 @@
+package ibm
+
+import (
+	"net/http"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+func init() {
+	storageops.RegisterDetector("ibm", &storageops.Detector{
+		// The metadata service only answers requests with a token, which
+		// is created with a PUT rather than the GET of ProbeHTTP
+		Probe: func() error {
+			_, err := metadataToken(&http.Client{Timeout: storageops.DetectTimeout})
+			return err
+		},
+		New: NewEnvClient,
+	})
+}
@@ -0,0 +1,49 @@
+package ibm
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+// errorCodes maps the error codes of VPC API errors to storage error codes,
+// they take precedence over the HTTP status
+var errorCodes = map[string]int{
+	"not_found":                storageops.ErrVolNotFound,
+	"volume_not_found":         storageops.ErrVolNotFound,
+	"snapshot_not_found":       storageops.ErrVolNotFound,
+	"rate_limit_exceeded":      storageops.ErrThrottled,
+	"quota_exceeded":           storageops.ErrQuotaExceeded,
+	"over_quota":               storageops.ErrQuotaExceeded,
+	"not_authorized":           storageops.ErrUnauthorized,
+	"validation_invalid_value": storageops.ErrVolInval,
+	"volume_in_use":            storageops.ErrDeviceBusy,
+}
+
+// errorStatuses maps the HTTP status of VPC and IAM API errors to storage
+// error codes
+var errorStatuses = map[int]int{
+	http.StatusNotFound:        storageops.ErrVolNotFound,
+	http.StatusTooManyRequests: storageops.ErrThrottled,
+	http.StatusUnauthorized:    storageops.ErrUnauthorized,
+	http.StatusForbidden:       storageops.ErrUnauthorized,
+	http.StatusBadRequest:      storageops.ErrVolInval,
+	http.StatusConflict:        storageops.ErrVolInval,
+}
+
+// storageError maps an error returned by the VPC API to a storage error with
+// the matching code. Errors without a matching code are returned unchanged.
+func (s *ibmOps) storageError(err error) error {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	if code, ok := errorCodes[apiErr.Code]; ok {
+		return storageops.WrapError(code, err, s.instanceID)
+	}
+	if code, ok := errorStatuses[apiErr.StatusCode]; ok {
+		return storageops.WrapError(code, err, s.instanceID)
+	}
+	return err
+}
@@ -0,0 +1,885 @@
+package ibm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IBM/go-sdk-core/v5/core"
+	"github.com/IBM/vpc-go-sdk/vpcv1"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/pborman/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// metadataEndpoint is the instance metadata service
+	metadataEndpoint = "http://169.254.169.254"
+	// metadataVersion is the date version of the metadata service API
+	metadataVersion = "2022-03-01"
+	// defaultProfile is the volume profile of volumes created without one
+	defaultProfile = "general-purpose"
+	// customProfile is the volume profile whose IOPS can be set
+	customProfile = "custom"
+	// attachmentIDLength is the number of characters of the attachment ID
+	// used as the virtio serial of the attached volume
+	attachmentIDLength = 20
+)
+
+// diskByIDPrefix is the prefix of the udev symlinks of attached volumes,
+// followed by the start of the volume attachment ID
+var diskByIDPrefix = "/dev/disk/by-id/virtio-"
+
+// tagRegex matches valid IBM Cloud user tags
+var tagRegex = regexp.MustCompile(`^[a-zA-Z0-9 _\-.:]{1,128}$`)
+
+// Config is the optional configuration of the IBM Cloud VPC storage ops driver
+type Config struct {
+	// RetryPolicy configures the wait loops of the driver. The default
+	// retries every storageops.ProviderOpsRetryInterval.
+	RetryPolicy *storageops.RetryPolicy
+	// HTTP configures the HTTP client of the API calls, e.g. to go through
+	// a proxy
+	HTTP *storageops.HTTPConfig
+	// Endpoint replaces the VPC API endpoint of the region
+	Endpoint string
+	// IAMEndpoint replaces the IAM token service endpoint
+	IAMEndpoint string
+}
+
+type ibmOps struct {
+	client     *client
+	instanceID string
+	zone       string
+	region     string
+	cfg        Config
+	mutex      sync.Mutex
+}
+
+var _ storageops.Ops = &ibmOps{}
+
+// NewEnvClient creates a new IBM Cloud VPC operations client with the API key
+// of the IBMCLOUD_API_KEY environment var. The instance and zone are read
+// from IBM_INSTANCE_ID and IBM_ZONE if set, or else from the instance
+// metadata service.
+func NewEnvClient() (storageops.Ops, error) {
+	apiKey, err := storageops.GetEnvValueStrict("IBMCLOUD_API_KEY")
+	if err != nil {
+		return nil, err
+	}
+	httpCfg, err := storageops.HTTPConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	instanceID, err := storageops.GetEnvValueStrict("IBM_INSTANCE_ID")
+	if err != nil {
+		if instanceID, err = metadata("id"); err != nil {
+			return nil, fmt.Errorf("error fetching instance ID. Err: %v", err)
+		}
+	}
+	zone, err := storageops.GetEnvValueStrict("IBM_ZONE")
+	if err != nil {
+		if zone, err = metadata("zone"); err != nil {
+			return nil, fmt.Errorf("error fetching instance zone. Err: %v", err)
+		}
+	}
+	return NewClient(apiKey, instanceID, zone, Config{HTTP: httpCfg})
+}
+
+// NewClient creates a new IBM Cloud VPC operations client for the given
+// instance in the given zone, e.g. us-south-1, authenticated with the given
+// API key
+func NewClient(apiKey, instanceID, zone string, cfg Config) (storageops.Ops, error) {
+	if err := cfg.RetryPolicy.Validate(); err != nil {
+		return nil, err
+	}
+	region, err := zoneRegion(zone)
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := cfg.HTTP.Client()
+	if err != nil {
+		return nil, err
+	}
+	endpoint := cfg.Endpoint
+	if len(endpoint) == 0 {
+		endpoint = fmt.Sprintf("https://%s.iaas.cloud.ibm.com", region)
+	}
+	iamEndpoint := cfg.IAMEndpoint
+	if len(iamEndpoint) == 0 {
+		iamEndpoint = defaultIAMEndpoint
+	}
+	client, err := newClient(apiKey, endpoint, iamEndpoint, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	return &ibmOps{
+		client:     client,
+		instanceID: instanceID,
+		zone:       zone,
+		region:     region,
+		cfg:        cfg,
+	}, nil
+}
+
+// zoneRegion returns the region of the given zone, e.g. us-south of
+// us-south-1
+func zoneRegion(zone string) (string, error) {
+	i := strings.LastIndex(zone, "-")
+	if i <= 0 {
+		return "", fmt.Errorf("invalid zone %q", zone)
+	}
+	return zone[:i], nil
+}
+
+// metadataToken returns an access token of the instance metadata service
+func metadataToken(client *http.Client) (string, error) {
+	req, err := http.NewRequest(http.MethodPut,
+		metadataEndpoint+"/instance_identity/v1/token?version="+metadataVersion,
+		bytes.NewReader([]byte(`{"expires_in":300}`)))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "ibm")
+	req.Header.Set("Content-Type", "application/json")
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := metadataCall(client, req, &token); err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+// metadata returns the given field, id or zone, of the instance from the
+// instance metadata service
+func metadata(field string) (string, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	token, err := metadataToken(client)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodGet,
+		metadataEndpoint+"/metadata/v1/instance?version="+metadataVersion, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	var inst struct {
+		ID   string `json:"id"`
+		Zone struct {
+			Name string `json:"name"`
+		} `json:"zone"`
+	}
+	if err := metadataCall(client, req, &inst); err != nil {
+		return "", err
+	}
+	switch field {
+	case "id":
+		if len(inst.ID) > 0 {
+			return inst.ID, nil
+		}
+	case "zone":
+		if len(inst.Zone.Name) > 0 {
+			return inst.Zone.Name, nil
+		}
+	}
+	return "", fmt.Errorf("metadata service returned no %s", field)
+}
+
+func metadataCall(client *http.Client, req *http.Request, out interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("metadata service returned %s for %s", resp.Status, req.URL.Path)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func (s *ibmOps) Name() string { return "ibm" }
+
+func (s *ibmOps) InstanceID() string { return s.instanceID }
+
+func (s *ibmOps) GetZone() (string, error) { return s.zone, nil }
+
+func (s *ibmOps) GetRegion() (string, error) { return s.region, nil }
+
+func (s *ibmOps) ListZones() ([]string, error) {
+	zones, err := s.client.listZones(s.region)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	return zones, nil
+}
+
+// labelsToTags returns the user tags of the given labels, key:value or key
+// for empty values
+func labelsToTags(labels map[string]string) ([]string, error) {
+	tags := make([]string, 0, len(labels))
+	for k, v := range labels {
+		tag := k
+		if len(v) > 0 {
+			tag = k + ":" + v
+		}
+		if strings.Contains(k, ":") || !tagRegex.MatchString(tag) {
+			return nil, storageops.NewStorageError(storageops.ErrVolInval,
+				fmt.Sprintf("label %s=%s is not a valid tag, tags are at most 128 "+
+					"letters, numbers, spaces, colons, dashes, periods and underscores, "+
+					"and keys may not contain colons", k, v), "")
+		}
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// tagsToLabels returns the labels of the given tags, see labelsToTags
+func tagsToLabels(tags []string) map[string]string {
+	labels := make(map[string]string)
+	for _, tag := range tags {
+		if i := strings.Index(tag, ":"); i >= 0 {
+			labels[tag[:i]] = tag[i+1:]
+		} else {
+			labels[tag] = ""
+		}
+	}
+	return labels
+}
+
+// hasLabels returns true if the given tags carry all given labels
+func hasLabels(tags []string, labels map[string]string) bool {
+	have := tagsToLabels(tags)
+	for k, v := range labels {
+		if value, ok := have[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *ibmOps) Create(
+	template interface{},
+	labels map[string]string,
+) (*storageops.Volume, error) {
+	template, labels, err := storageops.VolumeTemplate(s.Name(), template, labels)
+	if err != nil {
+		return nil, err
+	}
+	v, ok := template.(*vpcv1.VolumePrototype)
+	if !ok {
+		return nil, storageops.NewStorageError(storageops.ErrVolInval,
+			"Invalid volume template given", s.instanceID)
+	}
+	tags, err := labelsToTags(labels)
+	if err != nil {
+		return nil, err
+	}
+	req := *v
+	if req.Zone == nil {
+		req.Zone = &vpcv1.ZoneIdentityByName{Name: core.StringPtr(s.zone)}
+	}
+	if req.Profile == nil {
+		req.Profile = &vpcv1.VolumeProfileIdentityByName{Name: core.StringPtr(defaultProfile)}
+	}
+	req.UserTags = append(append([]string{}, v.UserTags...), tags...)
+
+	vol, err := s.client.createVolume(&req)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	id := stringValue(vol.ID)
+	available, err := s.waitVolumeStatus(id, storageops.RetryOpCreate, nil)
+	if err != nil {
+		return nil, s.rollbackCreate(id, err)
+	}
+	return toVolume(available), nil
+}
+
+func (s *ibmOps) rollbackCreate(id string, createErr error) error {
+	logrus.Warnf("Rollback create volume %v, Error %v", id, createErr)
+	if err := s.Delete(id); err != nil {
+		logrus.Warnf("Rollback failed volume %v, Error %v", id, err)
+	}
+	return createErr
+}
+
+// waitVolumeStatus waits for the given volume to be available and, if done
+// is set, for done to return true and returns it
+func (s *ibmOps) waitVolumeStatus(
+	id, op string,
+	done func(vol *vpcv1.Volume) bool,
+) (*vpcv1.Volume, error) {
+	vol, err := s.cfg.RetryPolicy.For(op).Retry(
+		fmt.Sprintf("wait for volume %s to be %s", id, statusAvailable),
+		func() (interface{}, bool, error) {
+			vol, err := s.client.getVolume(id)
+			if err != nil {
+				return nil, true, s.storageError(err)
+			}
+			status := stringValue(vol.Status)
+			if status == statusFailed {
+				return nil, false, fmt.Errorf("volume %s is %s", id, status)
+			}
+			if status != statusAvailable {
+				return nil, true, fmt.Errorf("invalid status: %s for volume: %s. expected: %s",
+					status, id, statusAvailable)
+			}
+			if done != nil && !done(vol) {
+				return nil, true, fmt.Errorf("update of volume %s is pending", id)
+			}
+			return vol, false, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return vol.(*vpcv1.Volume), nil
+}
+
+func (s *ibmOps) GetDeviceID(template interface{}) (string, error) {
+	if id, ok := storageops.TypedID(template); ok {
+		return id, nil
+	}
+	switch v := template.(type) {
+	case *vpcv1.Volume:
+		return stringValue(v.ID), nil
+	case *vpcv1.Snapshot:
+		return stringValue(v.ID), nil
+	}
+	return "", fmt.Errorf("invalid type: %v given to GetDeviceID", template)
+}
+
+func (s *ibmOps) volume(volumeID string) (*vpcv1.Volume, error) {
+	vol, err := s.client.getVolume(volumeID)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	return vol, nil
+}
+
+func (s *ibmOps) Expand(volumeID string, newSizeGiB uint64) (uint64, error) {
+	vol, err := s.volume(volumeID)
+	if err != nil {
+		return 0, err
+	}
+	currentSize := uint64(int64Value(vol.Capacity))
+	if newSizeGiB == currentSize {
+		return currentSize, nil
+	}
+	if newSizeGiB < currentSize {
+		return 0, storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("volume %s of %d GiB cannot be shrunk to %d GiB",
+				volumeID, currentSize, newSizeGiB), s.instanceID)
+	}
+	if _, err := s.client.updateVolume(volumeID, map[string]interface{}{
+		"capacity": newSizeGiB,
+	}); err != nil {
+		return 0, s.storageError(err)
+	}
+	if _, err := s.waitVolumeStatus(volumeID, storageops.RetryOpExpand, func(vol *vpcv1.Volume) bool {
+		return uint64(int64Value(vol.Capacity)) == newSizeGiB
+	}); err != nil {
+		return 0, err
+	}
+	return newSizeGiB, nil
+}
+
+// Modify changes the profile of the given volume to spec.Type, e.g.
+// 10iops-tier, and the IOPS of custom profile volumes. The throughput of
+// volumes follows from their profile and IOPS and cannot be set.
+func (s *ibmOps) Modify(volumeID string, spec storageops.VolumeSpecUpdate) error {
+	if spec.ThroughputMiBps > 0 {
+		return storageops.NewStorageError(storageops.ErrModifyUnsupported,
+			fmt.Sprintf("throughput of volume %s cannot be set, it follows from "+
+				"its profile and IOPS", volumeID), s.instanceID)
+	}
+	vol, err := s.volume(volumeID)
+	if err != nil {
+		return err
+	}
+	profile := spec.Type
+	if len(profile) == 0 && vol.Profile != nil {
+		profile = stringValue(vol.Profile.Name)
+	}
+	if spec.Iops > 0 && profile != customProfile {
+		return storageops.NewStorageError(storageops.ErrModifyUnsupported,
+			fmt.Sprintf("IOPS of volume %s can only be set with the %s profile, not %s",
+				volumeID, customProfile, profile), s.instanceID)
+	}
+	patch := make(map[string]interface{})
+	if len(spec.Type) > 0 {
+		patch["profile"] = &vpcv1.VolumeProfileIdentityByName{Name: core.StringPtr(spec.Type)}
+	}
+	if spec.Iops > 0 {
+		patch["iops"] = spec.Iops
+	}
+	if len(patch) == 0 {
+		return nil
+	}
+	if _, err := s.client.updateVolume(volumeID, patch); err != nil {
+		return s.storageError(err)
+	}
+	_, err = s.waitVolumeStatus(volumeID, storageops.RetryOpModify, func(vol *vpcv1.Volume) bool {
+		return (len(spec.Type) == 0 ||
+			(vol.Profile != nil && stringValue(vol.Profile.Name) == spec.Type)) &&
+			(spec.Iops == 0 || int64Value(vol.Iops) == spec.Iops)
+	})
+	return err
+}
+
+// attachment returns the data attachment of the given volume to the given
+// instance, or nil if it is not attached to it
+func attachment(
+	vol *vpcv1.Volume,
+	instanceID string,
+) *vpcv1.VolumeAttachmentReferenceVolumeContext {
+	for i, att := range vol.VolumeAttachments {
+		if stringValue(att.Type) != attachmentTypeData {
+			continue
+		}
+		if att.Instance != nil && stringValue(att.Instance.ID) == instanceID {
+			return &vol.VolumeAttachments[i]
+		}
+	}
+	return nil
+}
+
+// attachedTo returns the instances the given volume is attached to
+func attachedTo(vol *vpcv1.Volume) []string {
+	var ids []string
+	for _, att := range vol.VolumeAttachments {
+		if att.Instance != nil {
+			ids = append(ids, stringValue(att.Instance.ID))
+		}
+	}
+	return ids
+}
+
+func (s *ibmOps) Attach(volumeID string, options map[string]string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	vol, err := s.volume(volumeID)
+	if err != nil {
+		return "", err
+	}
+	if att := attachment(vol, s.instanceID); att != nil {
+		return s.devicePath(stringValue(att.ID))
+	}
+	if instances := attachedTo(vol); len(instances) > 0 {
+		return "", storageops.NewStorageError(storageops.ErrVolAlreadyAttached,
+			fmt.Sprintf("volume %s is already attached to instance %v", volumeID, instances),
+			s.instanceID)
+	}
+
+	att, err := s.client.attachVolume(s.instanceID, volumeID)
+	if err != nil {
+		return "", s.storageError(err)
+	}
+	attID := stringValue(att.ID)
+	devicePath, err := s.cfg.RetryPolicy.For(storageops.RetryOpAttach).Retry(
+		fmt.Sprintf("wait for attachment of volume %s", volumeID),
+		func() (interface{}, bool, error) {
+			att, err := s.client.getVolumeAttachment(s.instanceID, attID)
+			if err != nil {
+				return nil, true, s.storageError(err)
+			}
+			if status := stringValue(att.Status); status != statusAttached {
+				return nil, true, fmt.Errorf("invalid status: %s for attachment of volume: %s. "+
+					"expected: %s", status, volumeID, statusAttached)
+			}
+			devicePath, err := s.devicePath(attID)
+			return devicePath, err != nil, err
+		})
+	if err != nil {
+		return "", err
+	}
+	return devicePath.(string), nil
+}
+
+func (s *ibmOps) Detach(volumeID string) error {
+	return s.detachInternal(volumeID, s.instanceID)
+}
+
+func (s *ibmOps) DetachFrom(volumeID, instanceID string) error {
+	return s.detachInternal(volumeID, instanceID)
+}
+
+func (s *ibmOps) detachInternal(volumeID, instanceID string) error {
+	vol, err := s.volume(volumeID)
+	if err != nil {
+		return err
+	}
+	att := attachment(vol, instanceID)
+	if att == nil {
+		return nil
+	}
+	if err := s.client.deleteVolumeAttachment(instanceID, stringValue(att.ID)); err != nil {
+		return s.storageError(err)
+	}
+	_, err = s.cfg.RetryPolicy.For(storageops.RetryOpDetach).Retry(
+		fmt.Sprintf("wait for detach of volume %s", volumeID),
+		func() (interface{}, bool, error) {
+			vol, err := s.volume(volumeID)
+			if err != nil {
+				return nil, true, err
+			}
+			if attachment(vol, instanceID) != nil {
+				return nil, true, fmt.Errorf("volume %s is still attached to %s",
+					volumeID, instanceID)
+			}
+			return nil, false, nil
+		})
+	return err
+}
+
+func (s *ibmOps) Delete(volumeID string) error {
+	return s.storageError(s.client.deleteVolume(volumeID))
+}
+
+func (s *ibmOps) DeleteFrom(volumeID, _ string) error {
+	return s.Delete(volumeID)
+}
+
+// Describe returns the *vpcv1.Instance of this instance
+func (s *ibmOps) Describe() (interface{}, error) {
+	inst, err := s.client.getInstance(s.instanceID)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	return inst, nil
+}
+
+// FreeDevices is not supported, volumes are exposed by attachment ID and not
+// at a device chosen by the caller
+func (s *ibmOps) FreeDevices(
+	blockDeviceMappings []interface{},
+	rootDeviceName string,
+) ([]string, error) {
+	return nil, storageops.ErrNotSupported
+}
+
+func (s *ibmOps) Inspect(volumeIds []*string) ([]*storageops.Volume, error) {
+	var vols []*storageops.Volume
+	for _, id := range volumeIds {
+		vol, err := s.volume(*id)
+		if err != nil {
+			return nil, err
+		}
+		vols = append(vols, toVolume(vol))
+	}
+	return vols, nil
+}
+
+func (s *ibmOps) DeviceMappings() (map[string]string, error) {
+	atts, err := s.client.listVolumeAttachments(s.instanceID)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	m := make(map[string]string)
+	for _, att := range atts {
+		if stringValue(att.Type) != attachmentTypeData || att.Volume == nil ||
+			stringValue(att.Status) != statusAttached {
+			continue
+		}
+		devPath, err := s.devicePath(stringValue(att.ID))
+		if err != nil {
+			return nil, err
+		}
+		m[devPath] = stringValue(att.Volume.ID)
+	}
+	return m, nil
+}
+
+func (s *ibmOps) Enumerate(
+	volumeIds []*string,
+	labels map[string]string,
+	setIdentifier string,
+) (map[string][]*storageops.Volume, error) {
+	sets := make(map[string][]*storageops.Volume)
+	err := s.EnumerateEach(volumeIds, labels, setIdentifier,
+		func(set string, vol *storageops.Volume) bool {
+			storageops.AddElementToMap(sets, vol, set)
+			return true
+		})
+	if err != nil {
+		return nil, err
+	}
+	return sets, nil
+}
+
+var _ storageops.StreamingEnumerator = &ibmOps{}
+
+// EnumerateEach calls fn with every volume of the zone matching the given IDs
+// and labels one page at a time. The set of a volume is the setIdentifier
+// label key if it has it.
+func (s *ibmOps) EnumerateEach(
+	volumeIds []*string,
+	labels map[string]string,
+	setIdentifier string,
+	fn func(set string, vol *storageops.Volume) bool,
+) error {
+	ids := make(map[string]bool)
+	for _, id := range volumeIds {
+		ids[*id] = true
+	}
+	err := s.client.listVolumes(s.zone, func(vol *vpcv1.Volume) bool {
+		if len(ids) > 0 && !ids[stringValue(vol.ID)] {
+			return true
+		}
+		if !hasLabels(vol.UserTags, labels) {
+			return true
+		}
+		set := storageops.SetIdentifierNone
+		if _, ok := tagsToLabels(vol.UserTags)[setIdentifier]; ok && len(setIdentifier) > 0 {
+			set = setIdentifier
+		}
+		return fn(set, toVolume(vol))
+	})
+	return s.storageError(err)
+}
+
+func (s *ibmOps) DevicePath(volumeID string) (string, error) {
+	vol, err := s.volume(volumeID)
+	if err != nil {
+		return "", err
+	}
+	instances := attachedTo(vol)
+	if len(instances) == 0 {
+		return "", storageops.NewStorageError(storageops.ErrVolDetached,
+			fmt.Sprintf("Volume %s is detached", volumeID), s.instanceID)
+	}
+	if att := attachment(vol, s.instanceID); att != nil {
+		return s.devicePath(stringValue(att.ID))
+	}
+	return "", storageops.NewStorageError(storageops.ErrVolAttachedOnRemoteNode,
+		fmt.Sprintf("volume %s is not attached on: %s (Attached on: %v)",
+			volumeID, s.instanceID, instances),
+		s.instanceID)
+}
+
+// devicePath resolves the by-id symlink of the volume of the given
+// attachment, whose virtio serial is the start of the attachment ID
+func (s *ibmOps) devicePath(attachmentID string) (string, error) {
+	serial := attachmentID
+	if len(serial) > attachmentIDLength {
+		serial = serial[:attachmentIDLength]
+	}
+	link := diskByIDPrefix + serial
+	devPath, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		return "", storageops.NewStorageError(storageops.ErrInvalidDevicePath,
+			fmt.Sprintf("unable to find block dev path for %s. %v", link, err),
+			s.instanceID)
+	}
+	return devPath, nil
+}
+
+func (s *ibmOps) Snapshot(volumeID string, readonly bool) (*storageops.Snapshot, error) {
+	vol, err := s.volume(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("%s-%s", stringValue(vol.Name), time.Now().UTC().Format("20060102150405"))
+	snap, err := s.client.createSnapshot(volumeID, name, vol.UserTags)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	snapID := stringValue(snap.ID)
+	stable, err := s.cfg.RetryPolicy.For(storageops.RetryOpSnapshot).Retry(
+		fmt.Sprintf("wait for snapshot %s to be %s", snapID, snapshotStable),
+		func() (interface{}, bool, error) {
+			snap, err := s.client.getSnapshot(snapID)
+			if err != nil {
+				return nil, true, s.storageError(err)
+			}
+			state := stringValue(snap.LifecycleState)
+			if state == snapshotFailed {
+				return nil, false, fmt.Errorf("snapshot %s is %s", snapID, snapshotFailed)
+			}
+			if state != snapshotStable {
+				return nil, true, fmt.Errorf("invalid state: %s for snapshot: %s. expected: %s",
+					state, snapID, snapshotStable)
+			}
+			return snap, false, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return toSnapshot(stable.(*vpcv1.Snapshot)), nil
+}
+
+func (s *ibmOps) SnapshotDelete(snapID string) error {
+	return s.storageError(s.client.deleteSnapshot(snapID))
+}
+
+func (s *ibmOps) SnapshotEnumerate(
+	filter *storageops.SnapshotFilter,
+) ([]*storageops.Snapshot, error) {
+	volumeID := ""
+	if filter != nil {
+		volumeID = filter.VolumeID
+	}
+	var snaps []*storageops.Snapshot
+	err := s.client.listSnapshots(volumeID, func(snap *vpcv1.Snapshot) bool {
+		if filter != nil && !hasLabels(snap.UserTags, filter.Labels) {
+			return true
+		}
+		typed := toSnapshot(snap)
+		if filter.Match(typed.VolumeID, typed.Created, typed.State, typed.Labels) {
+			snaps = append(snaps, typed)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	return snaps, nil
+}
+
+// SnapshotRestore creates a volume of the general purpose profile from the
+// given snapshot in the given zone
+func (s *ibmOps) SnapshotRestore(
+	snapID, zone string,
+	labels map[string]string,
+) (*storageops.Volume, error) {
+	snap, err := s.client.getSnapshot(snapID)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	if len(zone) == 0 {
+		zone = s.zone
+	}
+	// Volume names are at most 63 characters
+	name := stringValue(snap.Name)
+	if len(name) > 54 {
+		name = name[:54]
+	}
+	return s.Create(&vpcv1.VolumePrototype{
+		Name:           core.StringPtr(fmt.Sprintf("%s-%s", name, uuid.New()[:8])),
+		Capacity:       snap.MinimumCapacity,
+		Zone:           &vpcv1.ZoneIdentityByName{Name: core.StringPtr(zone)},
+		SourceSnapshot: &vpcv1.SnapshotIdentityByID{ID: core.StringPtr(snapID)},
+	}, labels)
+}
+
+func (s *ibmOps) SnapshotStatus(snapID string) (*storageops.SnapshotStatus, error) {
+	snap, err := s.client.getSnapshot(snapID)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	state := stringValue(snap.LifecycleState)
+	status := &storageops.SnapshotStatus{
+		ID:        stringValue(snap.ID),
+		State:     state,
+		Completed: state == snapshotStable,
+		Failed:    state == snapshotFailed,
+	}
+	if status.Completed {
+		status.Progress = 100
+	}
+	return status, nil
+}
+
+// resourceTags returns the user tags of the given volume or snapshot, and
+// whether it is a snapshot
+func (s *ibmOps) resourceTags(id string) ([]string, bool, error) {
+	vol, err := s.client.getVolume(id)
+	if err == nil {
+		return vol.UserTags, false, nil
+	}
+	if apiErr, ok := err.(*APIError); !ok || apiErr.StatusCode != http.StatusNotFound {
+		return nil, false, s.storageError(err)
+	}
+	snap, err := s.client.getSnapshot(id)
+	if err != nil {
+		return nil, false, s.storageError(err)
+	}
+	return snap.UserTags, true, nil
+}
+
+// updateTags replaces the user tags of the given volume or snapshot with its
+// current tags without the keys of remove and with the labels of apply
+func (s *ibmOps) updateTags(id string, apply, remove map[string]string) error {
+	tags, isSnapshot, err := s.resourceTags(id)
+	if err != nil {
+		return err
+	}
+	labels := tagsToLabels(tags)
+	for k := range remove {
+		delete(labels, k)
+	}
+	for k, v := range apply {
+		labels[k] = v
+	}
+	updated, err := labelsToTags(labels)
+	if err != nil {
+		return err
+	}
+	patch := map[string]interface{}{"user_tags": updated}
+	if isSnapshot {
+		err = s.client.updateSnapshot(id, patch)
+	} else {
+		_, err = s.client.updateVolume(id, patch)
+	}
+	return s.storageError(err)
+}
+
+// ApplyTags tags the given volume or snapshot with the given labels,
+// replacing the values of labels it already has
+func (s *ibmOps) ApplyTags(volumeID string, labels map[string]string) error {
+	if _, err := labelsToTags(labels); err != nil {
+		return err
+	}
+	return s.updateTags(volumeID, labels, nil)
+}
+
+func (s *ibmOps) RemoveTags(volumeID string, labels map[string]string) error {
+	return s.updateTags(volumeID, nil, labels)
+}
+
+func (s *ibmOps) Tags(volumeID string) (map[string]string, error) {
+	tags, _, err := s.resourceTags(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	return tagsToLabels(tags), nil
+}
+
+// VolumeFromDevicePath returns the volume attached to this instance at the
+// given device path, using the udev by-id symlinks of the attached volumes
+func (s *ibmOps) VolumeFromDevicePath(devicePath string) (*storageops.Volume, error) {
+	dev, err := storageops.BaseDevice(devicePath)
+	if err != nil {
+		return nil, storageops.NewStorageError(storageops.ErrInvalidDevicePath,
+			err.Error(), s.instanceID)
+	}
+	mappings, err := s.DeviceMappings()
+	if err != nil {
+		return nil, err
+	}
+	volumeID, err := storageops.VolumeIDFromMappings(mappings, dev)
+	if err != nil {
+		return nil, err
+	}
+	vol, err := s.volume(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	return toVolume(vol), nil
+}
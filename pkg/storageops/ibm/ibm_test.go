@@ -0,0 +1,330 @@
+package ibm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IBM/go-sdk-core/v5/core"
+	"github.com/IBM/vpc-go-sdk/vpcv1"
+	"github.com/go-openapi/strfmt"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/libopenstorage/openstorage/pkg/storageops/test"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAll(t *testing.T) {
+	d, err := NewEnvClient()
+	if err != nil {
+		t.Skipf("skipping IBM tests as environment is not set...\n")
+	}
+	name := fmt.Sprintf("openstorage-test-%s", uuid.New()[:8])
+	test.RunTest(map[string]storageops.Ops{d.Name(): d},
+		map[string]map[string]interface{}{d.Name(): {
+			name: &vpcv1.VolumePrototype{
+				Name:     core.StringPtr(name),
+				Capacity: core.Int64Ptr(20),
+			},
+		}}, t)
+}
+
+// fakeAPI is an in memory IAM and VPC API of volumes, volume attachments and
+// snapshots of a single instance
+type fakeAPI struct {
+	t           *testing.T
+	instanceID  string
+	tokens      int
+	volumes     map[string]*vpcv1.Volume
+	attachments map[string]*vpcv1.VolumeAttachment
+	snapshots   map[string]*vpcv1.Snapshot
+}
+
+func newFakeAPI(t *testing.T, instanceID string) *fakeAPI {
+	return &fakeAPI{t: t, instanceID: instanceID,
+		volumes:     make(map[string]*vpcv1.Volume),
+		attachments: make(map[string]*vpcv1.VolumeAttachment),
+		snapshots:   make(map[string]*vpcv1.Snapshot)}
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The SDK only decodes JSON responses
+	w.Header().Set("Content-Type", "application/json")
+	reply := func(v interface{}) { assert.NoError(f.t, json.NewEncoder(w).Encode(v)) }
+	body, _ := ioutil.ReadAll(r.Body)
+	if r.URL.Path == "/identity/token" {
+		form, err := url.ParseQuery(string(body))
+		assert.NoError(f.t, err)
+		assert.Equal(f.t, "key", form.Get("apikey"))
+		f.tokens++
+		reply(map[string]interface{}{
+			"access_token": "token",
+			"expires_in":   3600,
+			"expiration":   time.Now().Add(time.Hour).Unix(),
+		})
+		return
+	}
+	assert.Equal(f.t, "Bearer token", r.Header.Get("Authorization"))
+	assert.Equal(f.t, apiVersion, r.URL.Query().Get("version"))
+	var in map[string]interface{}
+	if len(body) > 0 {
+		assert.NoError(f.t, json.Unmarshal(body, &in))
+	}
+	notFound := func() {
+		w.WriteHeader(http.StatusNotFound)
+		reply(map[string]interface{}{"errors": []map[string]string{
+			{"code": "not_found", "message": "not found"}}})
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/"), "/")
+
+	switch {
+	case parts[0] == "regions":
+		reply(map[string]interface{}{"zones": []map[string]string{
+			{"name": "us-south-1", "status": "available"},
+			{"name": "us-south-2", "status": "available"},
+			{"name": "us-south-3", "status": "unavailable"},
+		}})
+	case parts[0] == "volumes" && len(parts) == 1 && r.Method == http.MethodPost:
+		vol := &vpcv1.Volume{}
+		assert.NoError(f.t, json.Unmarshal(body, vol))
+		vol.ID = core.StringPtr("r006-" + uuid.New())
+		vol.Status = core.StringPtr(statusAvailable)
+		vol.Encryption = core.StringPtr("provider_managed")
+		vol.CreatedAt = dateTime(time.Now())
+		f.volumes[*vol.ID] = vol
+		w.WriteHeader(http.StatusCreated)
+		reply(vol)
+	case parts[0] == "volumes" && len(parts) == 1:
+		// One volume per page to exercise paging
+		var ids []string
+		for id, vol := range f.volumes {
+			if *vol.Zone.Name == r.URL.Query().Get("zone.name") {
+				ids = append(ids, id)
+			}
+		}
+		sort.Strings(ids)
+		page := map[string]interface{}{"volumes": []*vpcv1.Volume{}}
+		for i, id := range ids {
+			if start := r.URL.Query().Get("start"); len(start) > 0 && id < start {
+				continue
+			}
+			page["volumes"] = []*vpcv1.Volume{f.volumes[id]}
+			if i+1 < len(ids) {
+				page["next"] = map[string]string{"href": "https://fake/v1/volumes?start=" + ids[i+1]}
+			}
+			break
+		}
+		reply(page)
+	case parts[0] == "volumes":
+		vol, ok := f.volumes[parts[1]]
+		if !ok {
+			notFound()
+			return
+		}
+		switch r.Method {
+		case http.MethodPatch:
+			assert.Equal(f.t, "application/merge-patch+json", r.Header.Get("Content-Type"))
+			assert.NoError(f.t, json.Unmarshal(body, vol))
+		case http.MethodDelete:
+			delete(f.volumes, *vol.ID)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		reply(vol)
+	case parts[0] == "instances" && len(parts) == 2:
+		reply(&vpcv1.Instance{ID: core.StringPtr(f.instanceID),
+			Zone: &vpcv1.ZoneReference{Name: core.StringPtr("us-south-1")}})
+	case parts[0] == "instances" && len(parts) == 3 && r.Method == http.MethodPost:
+		volumeID := in["volume"].(map[string]interface{})["id"].(string)
+		att := &vpcv1.VolumeAttachment{
+			ID:     core.StringPtr(fmt.Sprintf("0717-%08d-attachment", len(f.attachments)+1)),
+			Status: core.StringPtr(statusAttached),
+			Type:   core.StringPtr(attachmentTypeData),
+			Volume: &vpcv1.VolumeReference{ID: core.StringPtr(volumeID)},
+		}
+		f.attachments[*att.ID] = att
+		vol := f.volumes[volumeID]
+		vol.VolumeAttachments = append(vol.VolumeAttachments,
+			vpcv1.VolumeAttachmentReferenceVolumeContext{ID: att.ID, Type: att.Type,
+				Instance: &vpcv1.InstanceReference{ID: core.StringPtr(parts[1])}})
+		w.WriteHeader(http.StatusCreated)
+		reply(att)
+	case parts[0] == "instances" && len(parts) == 3:
+		atts := []*vpcv1.VolumeAttachment{}
+		for _, att := range f.attachments {
+			atts = append(atts, att)
+		}
+		reply(map[string]interface{}{"volume_attachments": atts})
+	case parts[0] == "instances":
+		att, ok := f.attachments[parts[3]]
+		if !ok {
+			notFound()
+			return
+		}
+		if r.Method == http.MethodDelete {
+			delete(f.attachments, *att.ID)
+			vol := f.volumes[*att.Volume.ID]
+			vol.VolumeAttachments = nil
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		reply(att)
+	case parts[0] == "snapshots" && len(parts) == 1 && r.Method == http.MethodPost:
+		snap := &vpcv1.Snapshot{}
+		assert.NoError(f.t, json.Unmarshal(body, snap))
+		vol := f.volumes[*snap.SourceVolume.ID]
+		snap.ID = core.StringPtr("r006-" + uuid.New())
+		snap.LifecycleState = core.StringPtr(snapshotStable)
+		snap.MinimumCapacity = vol.Capacity
+		snap.CreatedAt = dateTime(time.Now())
+		f.snapshots[*snap.ID] = snap
+		w.WriteHeader(http.StatusCreated)
+		reply(snap)
+	case parts[0] == "snapshots" && len(parts) == 1:
+		snaps := []*vpcv1.Snapshot{}
+		for _, snap := range f.snapshots {
+			if v := r.URL.Query().Get("source_volume.id"); len(v) > 0 && v != *snap.SourceVolume.ID {
+				continue
+			}
+			snaps = append(snaps, snap)
+		}
+		reply(map[string]interface{}{"snapshots": snaps})
+	case parts[0] == "snapshots":
+		snap, ok := f.snapshots[parts[1]]
+		if !ok {
+			notFound()
+			return
+		}
+		switch r.Method {
+		case http.MethodPatch:
+			assert.NoError(f.t, json.Unmarshal(body, snap))
+		case http.MethodDelete:
+			delete(f.snapshots, *snap.ID)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		reply(snap)
+	default:
+		f.t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestIBMVolumes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ibm")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	prefix := diskByIDPrefix
+	diskByIDPrefix = filepath.Join(dir, "virtio-")
+	defer func() { diskByIDPrefix = prefix }()
+
+	api := newFakeAPI(t, "0717_instance")
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	d, err := NewClient("key", api.instanceID, "us-south-1", Config{
+		Endpoint:    server.URL,
+		IAMEndpoint: server.URL,
+		RetryPolicy: &storageops.RetryPolicy{Interval: time.Millisecond, Timeout: time.Second},
+	})
+	assert.NoError(t, err)
+	region, err := d.GetRegion()
+	assert.NoError(t, err)
+	assert.Equal(t, "us-south", region)
+	zones, err := d.ListZones()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"us-south-1", "us-south-2"}, zones)
+
+	prototype := func(name string, capacity int64) *vpcv1.VolumePrototype {
+		return &vpcv1.VolumePrototype{Name: core.StringPtr(name), Capacity: core.Int64Ptr(capacity)}
+	}
+	_, err = d.Create(prototype("data", 20), map[string]string{"app:x": "db"})
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval))
+	vol, err := d.Create(prototype("data", 20), map[string]string{"app": "db", "team": ""})
+	assert.NoError(t, err)
+	id, err := d.GetDeviceID(vol)
+	assert.NoError(t, err)
+	created := api.volumes[id]
+	assert.Equal(t, "us-south-1", *created.Zone.Name)
+	assert.Equal(t, defaultProfile, *created.Profile.Name)
+	assert.Equal(t, []string{"app:db", "team"}, created.UserTags)
+	_, err = d.Create(prototype("other", 10), nil)
+	assert.NoError(t, err)
+
+	_, err = d.DevicePath(id)
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolDetached))
+	device := filepath.Join(dir, "vdb")
+	assert.NoError(t, ioutil.WriteFile(device, nil, 0600))
+	assert.NoError(t, os.Symlink(device, diskByIDPrefix+"0717-00000001-attach"))
+	path, err := d.Attach(id, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, device, path)
+	path, err = d.DevicePath(id)
+	assert.NoError(t, err)
+	assert.Equal(t, device, path)
+	mappings, err := d.DeviceMappings()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{device: id}, mappings)
+
+	size, err := d.Expand(id, 40)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(40), size)
+	assert.Equal(t, int64(40), *api.volumes[id].Capacity)
+	assert.NoError(t, d.Modify(id, storageops.VolumeSpecUpdate{Type: customProfile, Iops: 1000}))
+	assert.Equal(t, customProfile, *api.volumes[id].Profile.Name)
+	assert.Equal(t, int64(1000), *api.volumes[id].Iops)
+	err = d.Modify(id, storageops.VolumeSpecUpdate{ThroughputMiBps: 100})
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrModifyUnsupported))
+	err = d.Modify(id, storageops.VolumeSpecUpdate{Type: "10iops-tier", Iops: 1000})
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrModifyUnsupported))
+
+	sets, err := d.Enumerate(nil, nil, "app")
+	assert.NoError(t, err)
+	assert.Len(t, sets["app"], 1)
+	assert.Len(t, sets[storageops.SetIdentifierNone], 1)
+
+	assert.NoError(t, d.ApplyTags(id, map[string]string{"tier": "gold", "app": "web"}))
+	assert.NoError(t, d.RemoveTags(id, map[string]string{"team": ""}))
+	tags, err := d.Tags(id)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"tier": "gold", "app": "web"}, tags)
+
+	snap, err := d.Snapshot(id, true)
+	assert.NoError(t, err)
+	snapID := snap.ID
+	assert.NoError(t, d.ApplyTags(snapID, map[string]string{"backup": "daily"}))
+	snaps, err := d.SnapshotEnumerate(&storageops.SnapshotFilter{
+		VolumeID: id, Labels: map[string]string{"backup": "daily"}})
+	assert.NoError(t, err)
+	assert.Len(t, snaps, 1)
+	status, err := d.SnapshotStatus(snapID)
+	assert.NoError(t, err)
+	assert.True(t, status.Completed)
+	restored, err := d.SnapshotRestore(snapID, "us-south-2", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, snapID, *api.volumes[restored.ID].SourceSnapshot.ID)
+	assert.Equal(t, int64(40), *api.volumes[restored.ID].Capacity)
+
+	assert.NoError(t, d.Detach(id))
+	_, err = d.DevicePath(id)
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolDetached))
+	assert.NoError(t, d.SnapshotDelete(snapID))
+	assert.NoError(t, d.Delete(id))
+	_, err = d.Inspect([]*string{&id})
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolNotFound))
+	assert.Equal(t, 1, api.tokens)
+}
+
+func dateTime(t time.Time) *strfmt.DateTime {
+	dt := strfmt.DateTime(t)
+	return &dt
+}
@@ -0,0 +1,500 @@
+package storageops
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// IDMapper maps provider volume and snapshot IDs to opaque per-tenant handles
+// and back, so tenants can neither correlate nor directly manipulate cloud
+// resources out-of-band
+type IDMapper interface {
+	// Encode returns the opaque handle of the given ID for the given tenant
+	Encode(tenant, id string) (string, error)
+	// Decode returns the ID for the given handle of the given tenant
+	Decode(tenant, handle string) (string, error)
+}
+
+type aesIDMapper struct {
+	secret []byte
+}
+
+// NewAESIDMapper returns an IDMapper that encrypts IDs with AES-GCM using a
+// per-tenant key derived from secret. Handles are stable so they can be stored
+// by tenants, and handles of one tenant fail to decode for any other tenant.
+func NewAESIDMapper(secret []byte) (IDMapper, error) {
+	if len(secret) < 16 {
+		return nil, fmt.Errorf("ID mapper secret must be at least 16 bytes")
+	}
+	return &aesIDMapper{secret: secret}, nil
+}
+
+func (m *aesIDMapper) tenantKeys(tenant string) (cipher.AEAD, []byte, error) {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte("encrypt:" + tenant))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mac = hmac.New(sha256.New, m.secret)
+	mac.Write([]byte("nonce:" + tenant))
+	return aead, mac.Sum(nil), nil
+}
+
+func (m *aesIDMapper) Encode(tenant, id string) (string, error) {
+	aead, nonceKey, err := m.tenantKeys(tenant)
+	if err != nil {
+		return "", err
+	}
+	// The nonce is derived from the ID so the same ID always maps to the
+	// same handle
+	mac := hmac.New(sha256.New, nonceKey)
+	mac.Write([]byte(id))
+	nonce := mac.Sum(nil)[:aead.NonceSize()]
+
+	sealed := aead.Seal(nonce, nonce, []byte(id), nil)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (m *aesIDMapper) Decode(tenant, handle string) (string, error) {
+	notFound := NewStorageError(ErrVolNotFound,
+		fmt.Sprintf("volume %s not found", handle), "")
+
+	sealed, err := base64.RawURLEncoding.DecodeString(handle)
+	if err != nil {
+		return "", notFound
+	}
+	aead, _, err := m.tenantKeys(tenant)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", notFound
+	}
+	id, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", notFound
+	}
+	return string(id), nil
+}
+
+// TenantLabel is the label of the tenant that created a volume or snapshot
+// through NewTenantOps
+const TenantLabel = "openstorage-tenant"
+
+type tenantOps struct {
+	Ops
+	mapper IDMapper
+	tenant string
+}
+
+// NewTenantOps returns Ops scoped to the given tenant that accept and return
+// opaque handles instead of provider IDs. Volumes and snapshots created
+// through it are labeled with TenantLabel, and only those are enumerated.
+// Provider IDs in returned errors are replaced by their handles. Handles
+// passed where IDs are returned, e.g. to GetDeviceID, are returned as is.
+// The IDs of the returned volumes and snapshots are handles, but their
+// provider objects in Raw and the instance returned by Describe still carry
+// the raw IDs, so API surfaces exposing volumes to tenants must not expose
+// those.
+func NewTenantOps(ops Ops, mapper IDMapper, tenant string) Ops {
+	return &tenantOps{
+		Ops:    ops,
+		mapper: mapper,
+		tenant: tenant,
+	}
+}
+
+// encode returns the handle of the given ID. IDs that already are handles of
+// the tenant are returned as is.
+func (o *tenantOps) encode(id string) (string, error) {
+	if _, err := o.mapper.Decode(o.tenant, id); err == nil {
+		return id, nil
+	}
+	return o.mapper.Encode(o.tenant, id)
+}
+
+// hide returns err with the given IDs replaced by their handles in its
+// message. The storage error code of err is kept, its provider cause is
+// dropped as it carries the raw IDs.
+func (o *tenantOps) hide(err error, handles map[string]string) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	for id, handle := range handles {
+		msg = strings.Replace(msg, id, handle, -1)
+	}
+	var se *StorageError
+	if !errors.As(err, &se) {
+		return errors.New(msg)
+	}
+	instance := se.Instance
+	for id, handle := range handles {
+		instance = strings.Replace(instance, id, handle, -1)
+	}
+	return NewStorageError(se.Code, msg, instance)
+}
+
+// withTenant returns a copy of the given labels with TenantLabel set. A
+// TenantLabel of another tenant is an error.
+func (o *tenantOps) withTenant(labels map[string]string) (map[string]string, error) {
+	copied := map[string]string{TenantLabel: o.tenant}
+	for k, v := range labels {
+		if k == TenantLabel && v != o.tenant {
+			return nil, NewStorageError(ErrVolInval,
+				fmt.Sprintf("label %s is reserved", TenantLabel), "")
+		}
+		copied[k] = v
+	}
+	return copied, nil
+}
+
+func (o *tenantOps) decodeAll(handles []*string) ([]*string, map[string]string, error) {
+	if handles == nil {
+		return nil, nil, nil
+	}
+	ids := make([]*string, len(handles))
+	byID := make(map[string]string, len(handles))
+	for i, h := range handles {
+		id, err := o.mapper.Decode(o.tenant, *h)
+		if err != nil {
+			return nil, nil, err
+		}
+		ids[i] = &id
+		byID[id] = *h
+	}
+	return ids, byID, nil
+}
+
+// encodeVolume returns a copy of the given volume with its ID replaced by
+// its handle
+func (o *tenantOps) encodeVolume(vol *Volume) (*Volume, error) {
+	if vol == nil {
+		return nil, nil
+	}
+	handle, err := o.encode(vol.ID)
+	if err != nil {
+		return nil, err
+	}
+	copied := *vol
+	copied.ID = handle
+	return &copied, nil
+}
+
+// encodeVolumes returns copies of the volumes of the tenant with their IDs
+// replaced by their handles
+func (o *tenantOps) encodeVolumes(vols []*Volume) ([]*Volume, error) {
+	if vols == nil {
+		return nil, nil
+	}
+	encoded := make([]*Volume, 0, len(vols))
+	for _, vol := range vols {
+		if vol.Labels[TenantLabel] != o.tenant {
+			continue
+		}
+		copied, err := o.encodeVolume(vol)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, copied)
+	}
+	return encoded, nil
+}
+
+// encodeSnapshot returns a copy of the given snapshot with its ID and volume
+// ID replaced by their handles
+func (o *tenantOps) encodeSnapshot(snap *Snapshot) (*Snapshot, error) {
+	if snap == nil {
+		return nil, nil
+	}
+	copied := *snap
+	var err error
+	if copied.ID, err = o.encode(snap.ID); err != nil {
+		return nil, err
+	}
+	if len(snap.VolumeID) > 0 {
+		if copied.VolumeID, err = o.encode(snap.VolumeID); err != nil {
+			return nil, err
+		}
+	}
+	return &copied, nil
+}
+
+func (o *tenantOps) Create(template interface{}, labels map[string]string) (*Volume, error) {
+	labels, err := o.withTenant(labels)
+	if err != nil {
+		return nil, err
+	}
+	vol, err := o.Ops.Create(template, labels)
+	if err != nil {
+		return nil, err
+	}
+	return o.encodeVolume(vol)
+}
+
+func (o *tenantOps) GetDeviceID(template interface{}) (string, error) {
+	id, err := o.Ops.GetDeviceID(template)
+	if err != nil {
+		return "", err
+	}
+	return o.encode(id)
+}
+
+func (o *tenantOps) Attach(handle string, options map[string]string) (string, error) {
+	id, err := o.mapper.Decode(o.tenant, handle)
+	if err != nil {
+		return "", err
+	}
+	path, err := o.Ops.Attach(id, options)
+	return path, o.hide(err, map[string]string{id: handle})
+}
+
+func (o *tenantOps) Expand(handle string, newSizeGiB uint64) (uint64, error) {
+	id, err := o.mapper.Decode(o.tenant, handle)
+	if err != nil {
+		return 0, err
+	}
+	size, err := o.Ops.Expand(id, newSizeGiB)
+	return size, o.hide(err, map[string]string{id: handle})
+}
+
+func (o *tenantOps) Modify(handle string, spec VolumeSpecUpdate) error {
+	id, err := o.mapper.Decode(o.tenant, handle)
+	if err != nil {
+		return err
+	}
+	return o.hide(o.Ops.Modify(id, spec), map[string]string{id: handle})
+}
+
+func (o *tenantOps) Detach(handle string) error {
+	id, err := o.mapper.Decode(o.tenant, handle)
+	if err != nil {
+		return err
+	}
+	return o.hide(o.Ops.Detach(id), map[string]string{id: handle})
+}
+
+func (o *tenantOps) DetachFrom(handle, instanceID string) error {
+	id, err := o.mapper.Decode(o.tenant, handle)
+	if err != nil {
+		return err
+	}
+	return o.hide(o.Ops.DetachFrom(id, instanceID), map[string]string{id: handle})
+}
+
+func (o *tenantOps) Delete(handle string) error {
+	id, err := o.mapper.Decode(o.tenant, handle)
+	if err != nil {
+		return err
+	}
+	return o.hide(o.Ops.Delete(id), map[string]string{id: handle})
+}
+
+func (o *tenantOps) DeleteFrom(handle, instanceID string) error {
+	id, err := o.mapper.Decode(o.tenant, handle)
+	if err != nil {
+		return err
+	}
+	return o.hide(o.Ops.DeleteFrom(id, instanceID), map[string]string{id: handle})
+}
+
+func (o *tenantOps) Inspect(handles []*string) ([]*Volume, error) {
+	ids, byID, err := o.decodeAll(handles)
+	if err != nil {
+		return nil, err
+	}
+	vols, err := o.Ops.Inspect(ids)
+	if err != nil {
+		return nil, o.hide(err, byID)
+	}
+	encoded := make([]*Volume, len(vols))
+	for i, vol := range vols {
+		if encoded[i], err = o.encodeVolume(vol); err != nil {
+			return nil, err
+		}
+	}
+	return encoded, nil
+}
+
+func (o *tenantOps) DeviceMappings() (map[string]string, error) {
+	m, err := o.Ops.DeviceMappings()
+	if err != nil {
+		return nil, err
+	}
+	for path, id := range m {
+		if m[path], err = o.encode(id); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (o *tenantOps) Enumerate(
+	handles []*string,
+	labels map[string]string,
+	setIdentifier string,
+) (map[string][]*Volume, error) {
+	ids, byID, err := o.decodeAll(handles)
+	if err != nil {
+		return nil, err
+	}
+	if labels, err = o.withTenant(labels); err != nil {
+		return nil, err
+	}
+	sets, err := o.Ops.Enumerate(ids, labels, setIdentifier)
+	if err != nil {
+		return nil, o.hide(err, byID)
+	}
+	encoded := make(map[string][]*Volume, len(sets))
+	for set, vols := range sets {
+		if encoded[set], err = o.encodeVolumes(vols); err != nil {
+			return nil, err
+		}
+		if len(encoded[set]) == 0 {
+			delete(encoded, set)
+		}
+	}
+	return encoded, nil
+}
+
+func (o *tenantOps) DevicePath(handle string) (string, error) {
+	id, err := o.mapper.Decode(o.tenant, handle)
+	if err != nil {
+		return "", err
+	}
+	path, err := o.Ops.DevicePath(id)
+	return path, o.hide(err, map[string]string{id: handle})
+}
+
+func (o *tenantOps) Snapshot(handle string, readonly bool) (*Snapshot, error) {
+	id, err := o.mapper.Decode(o.tenant, handle)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := SnapshotWithLabels(o.Ops, id, readonly, map[string]string{TenantLabel: o.tenant})
+	if err != nil {
+		return nil, o.hide(err, map[string]string{id: handle})
+	}
+	return o.encodeSnapshot(snap)
+}
+
+func (o *tenantOps) SnapshotDelete(handle string) error {
+	id, err := o.mapper.Decode(o.tenant, handle)
+	if err != nil {
+		return err
+	}
+	return o.hide(o.Ops.SnapshotDelete(id), map[string]string{id: handle})
+}
+
+func (o *tenantOps) SnapshotRestore(
+	handle, zone string,
+	labels map[string]string,
+) (*Volume, error) {
+	id, err := o.mapper.Decode(o.tenant, handle)
+	if err != nil {
+		return nil, err
+	}
+	if labels, err = o.withTenant(labels); err != nil {
+		return nil, err
+	}
+	vol, err := o.Ops.SnapshotRestore(id, zone, labels)
+	if err != nil {
+		return nil, o.hide(err, map[string]string{id: handle})
+	}
+	return o.encodeVolume(vol)
+}
+
+func (o *tenantOps) SnapshotEnumerate(filter *SnapshotFilter) ([]*Snapshot, error) {
+	copied := SnapshotFilter{}
+	if filter != nil {
+		copied = *filter
+	}
+	handles := make(map[string]string)
+	if len(copied.VolumeID) > 0 {
+		id, err := o.mapper.Decode(o.tenant, copied.VolumeID)
+		if err != nil {
+			return nil, err
+		}
+		handles[id] = copied.VolumeID
+		copied.VolumeID = id
+	}
+	var err error
+	if copied.Labels, err = o.withTenant(copied.Labels); err != nil {
+		return nil, err
+	}
+	snaps, err := o.Ops.SnapshotEnumerate(&copied)
+	if err != nil {
+		return nil, o.hide(err, handles)
+	}
+	encoded := make([]*Snapshot, 0, len(snaps))
+	for _, snap := range snaps {
+		if snap.Labels[TenantLabel] != o.tenant {
+			continue
+		}
+		e, err := o.encodeSnapshot(snap)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, e)
+	}
+	return encoded, nil
+}
+
+func (o *tenantOps) SnapshotStatus(handle string) (*SnapshotStatus, error) {
+	id, err := o.mapper.Decode(o.tenant, handle)
+	if err != nil {
+		return nil, err
+	}
+	status, err := o.Ops.SnapshotStatus(id)
+	if err != nil {
+		return nil, o.hide(err, map[string]string{id: handle})
+	}
+	copied := *status
+	copied.ID = handle
+	return &copied, nil
+}
+
+func (o *tenantOps) ApplyTags(handle string, labels map[string]string) error {
+	id, err := o.mapper.Decode(o.tenant, handle)
+	if err != nil {
+		return err
+	}
+	if _, ok := labels[TenantLabel]; ok {
+		return NewStorageError(ErrVolInval,
+			fmt.Sprintf("label %s is reserved", TenantLabel), "")
+	}
+	return o.hide(o.Ops.ApplyTags(id, labels), map[string]string{id: handle})
+}
+
+func (o *tenantOps) RemoveTags(handle string, labels map[string]string) error {
+	id, err := o.mapper.Decode(o.tenant, handle)
+	if err != nil {
+		return err
+	}
+	if _, ok := labels[TenantLabel]; ok {
+		return NewStorageError(ErrVolInval,
+			fmt.Sprintf("label %s is reserved", TenantLabel), "")
+	}
+	return o.hide(o.Ops.RemoveTags(id, labels), map[string]string{id: handle})
+}
+
+func (o *tenantOps) Tags(handle string) (map[string]string, error) {
+	id, err := o.mapper.Decode(o.tenant, handle)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := o.Ops.Tags(id)
+	return tags, o.hide(err, map[string]string{id: handle})
+}
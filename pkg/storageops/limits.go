@@ -0,0 +1,139 @@
+package storageops
+
+import (
+	"fmt"
+	"sort"
+)
+
+// VolumeTypeLimits are the limits of a volume type of a provider. Zero
+// values are not limited or not known.
+type VolumeTypeLimits struct {
+	// MinSizeGiB is the minimum volume size
+	MinSizeGiB uint64
+	// MaxSizeGiB is the maximum volume size
+	MaxSizeGiB uint64
+	// MaxIops are the maximum IOPS of a volume
+	MaxIops int64
+	// MaxThroughputMiBps is the maximum throughput of a volume
+	MaxThroughputMiBps int64
+}
+
+// ProviderLimits are the limits of a provider and of the instance of a
+// driver that a drive set spec must fit in. Zero values are not limited or
+// not known.
+type ProviderLimits struct {
+	// MaxAttachments is the number of volumes that can be attached to the
+	// instance, including the root volume
+	MaxAttachments int
+	// MaxVolumeSizeGiB is the maximum size of a volume of any type
+	MaxVolumeSizeGiB uint64
+	// MaxInstanceIops are the maximum total IOPS of the volumes of the
+	// instance
+	MaxInstanceIops int64
+	// MaxInstanceThroughputMiBps is the maximum total throughput of the
+	// volumes of the instance
+	MaxInstanceThroughputMiBps int64
+	// VolumeTypes are the limits of each volume type, keyed by the provider
+	// specific type
+	VolumeTypes map[string]VolumeTypeLimits
+}
+
+// LimitsOps is implemented by drivers that report their ProviderLimits
+type LimitsOps interface {
+	// ProviderLimits returns the limits of the provider and the instance
+	ProviderLimits() (*ProviderLimits, error)
+}
+
+// GetProviderLimits returns the limits of the driver using its LimitsOps. It
+// returns ErrNotSupported if the driver has none. Wrappers like middlewares
+// hide the LimitsOps of the driver they wrap, see AttachBatch.
+func GetProviderLimits(ops Ops) (*ProviderLimits, error) {
+	if l, ok := ops.(LimitsOps); ok {
+		return l.ProviderLimits()
+	}
+	return nil, ErrNotSupported
+}
+
+// DriveSetSpec is a set of identical volumes to be created and attached to
+// an instance
+type DriveSetSpec struct {
+	// Count of volumes
+	Count int
+	// Type of the volumes, provider specific
+	Type string
+	// SizeGiB of each volume
+	SizeGiB uint64
+	// Iops of each volume, zero if not provisioned
+	Iops int64
+	// ThroughputMiBps of each volume, zero if not provisioned
+	ThroughputMiBps int64
+}
+
+// Validate returns an ErrVolInval error listing every limit the given drive
+// sets exceed if they were all attached to an instance that already has
+// attached volumes, including the root volume, so a scheduler can reject
+// a spec before creating any volume. The IOPS and throughput of the drive
+// sets are checked against the instance limits only if provisioned.
+func (l *ProviderLimits) Validate(attached int, sets ...*DriveSetSpec) error {
+	var reasons []string
+	count := attached
+	var iops, throughput int64
+	for _, set := range sets {
+		count += set.Count
+		iops += int64(set.Count) * set.Iops
+		throughput += int64(set.Count) * set.ThroughputMiBps
+
+		if l.MaxVolumeSizeGiB > 0 && set.SizeGiB > l.MaxVolumeSizeGiB {
+			reasons = append(reasons, fmt.Sprintf("%d GiB volumes exceed the maximum size of %d GiB",
+				set.SizeGiB, l.MaxVolumeSizeGiB))
+		}
+		if len(l.VolumeTypes) == 0 {
+			continue
+		}
+		t, ok := l.VolumeTypes[set.Type]
+		if !ok {
+			types := make([]string, 0, len(l.VolumeTypes))
+			for name := range l.VolumeTypes {
+				types = append(types, name)
+			}
+			sort.Strings(types)
+			reasons = append(reasons, fmt.Sprintf("unknown volume type %q, known types are %v",
+				set.Type, types))
+			continue
+		}
+		switch {
+		case t.MinSizeGiB > 0 && set.SizeGiB < t.MinSizeGiB:
+			reasons = append(reasons, fmt.Sprintf("%d GiB %s volumes are below the minimum size of %d GiB",
+				set.SizeGiB, set.Type, t.MinSizeGiB))
+		case t.MaxSizeGiB > 0 && set.SizeGiB > t.MaxSizeGiB:
+			reasons = append(reasons, fmt.Sprintf("%d GiB %s volumes exceed the maximum size of %d GiB",
+				set.SizeGiB, set.Type, t.MaxSizeGiB))
+		}
+		if t.MaxIops > 0 && set.Iops > t.MaxIops {
+			reasons = append(reasons, fmt.Sprintf("%d IOPS exceed the maximum of %d IOPS of %s volumes",
+				set.Iops, t.MaxIops, set.Type))
+		}
+		if t.MaxThroughputMiBps > 0 && set.ThroughputMiBps > t.MaxThroughputMiBps {
+			reasons = append(reasons, fmt.Sprintf("%d MiB/s exceed the maximum of %d MiB/s of %s volumes",
+				set.ThroughputMiBps, t.MaxThroughputMiBps, set.Type))
+		}
+	}
+
+	if l.MaxAttachments > 0 && count > l.MaxAttachments {
+		reasons = append(reasons, fmt.Sprintf("%d volumes exceed the %d attachments of the instance",
+			count, l.MaxAttachments))
+	}
+	if l.MaxInstanceIops > 0 && iops > l.MaxInstanceIops {
+		reasons = append(reasons, fmt.Sprintf("%d provisioned IOPS exceed the %d IOPS of the instance",
+			iops, l.MaxInstanceIops))
+	}
+	if l.MaxInstanceThroughputMiBps > 0 && throughput > l.MaxInstanceThroughputMiBps {
+		reasons = append(reasons, fmt.Sprintf("%d MiB/s provisioned throughput exceeds the %d MiB/s of the instance",
+			throughput, l.MaxInstanceThroughputMiBps))
+	}
+	if len(reasons) > 0 {
+		return NewStorageError(ErrVolInval, fmt.Sprintf("drive sets exceed the provider limits: %v",
+			reasons), "")
+	}
+	return nil
+}
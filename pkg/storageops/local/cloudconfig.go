@@ -0,0 +1,40 @@
+package local
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/libopenstorage/openstorage/pkg/storageops/config"
+)
+
+func init() {
+	config.Register("local", newFromCloudConfig)
+}
+
+// newFromCloudConfig creates the driver of the volume group of the
+// volumeGroup param of the given cloud config, or of LVM_VOLUME_GROUP if not
+// set. The thinPool and snapshotSizePercent params configure the driver, the
+// instance is the host of the server.
+func newFromCloudConfig(c *config.Config) (storageops.Ops, error) {
+	cfg := Config{
+		VolumeGroup: c.Params["volumeGroup"],
+		ThinPool:    c.Params["thinPool"],
+		Host:        c.Instance,
+		Zone:        c.Zone,
+		Region:      c.Region,
+	}
+	if len(cfg.VolumeGroup) == 0 {
+		var err error
+		if cfg.VolumeGroup, err = storageops.GetEnvValueStrict("LVM_VOLUME_GROUP"); err != nil {
+			return nil, fmt.Errorf("local cloud config requires param volumeGroup or env LVM_VOLUME_GROUP")
+		}
+	}
+	if percent, ok := c.Params["snapshotSizePercent"]; ok {
+		var err error
+		if cfg.SnapshotSizePercent, err = strconv.Atoi(percent); err != nil {
+			return nil, fmt.Errorf("invalid snapshotSizePercent %q: %v", percent, err)
+		}
+	}
+	return NewClient(cfg)
+}
@@ -0,0 +1,92 @@
+package local
+
+import (
+	"fmt"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+func init() {
+	storageops.RegisterConverter("local", &converter{})
+}
+
+type converter struct{}
+
+// ToVolume converts a *LogicalVolume. Active volumes are attached to the
+// host of their volume group.
+func (c *converter) ToVolume(raw interface{}) (*storageops.Volume, error) {
+	lv, ok := raw.(*LogicalVolume)
+	if !ok {
+		return nil, fmt.Errorf("invalid local volume %T", raw)
+	}
+	return toVolume(lv), nil
+}
+
+func toVolume(lv *LogicalVolume) *storageops.Volume {
+	v := &storageops.Volume{
+		ID:      lv.ID(),
+		Name:    lv.Name,
+		SizeGiB: lv.SizeBytes / uint64(storageops.GiB),
+		Zone:    lv.Zone,
+		State:   volumeInactive,
+		Labels:  tagsToLabels(lv.Tags),
+		Raw:     lv,
+	}
+	if lv.Active() {
+		v.State = volumeActive
+		v.AttachedTo = []string{lv.Host}
+		v.Attachments = []storageops.Attachment{{
+			InstanceID: lv.Host,
+			Device:     lv.DevicePath(),
+		}}
+	}
+	return v
+}
+
+// FromSpec returns the *CreateRequest template of the given spec. Logical
+// volumes have the performance of the disks of their volume group and are
+// encrypted by an encrypted volume group only, the zone must be empty or the
+// zone of the server.
+func (c *converter) FromSpec(spec *storageops.VolumeSpec) (interface{}, error) {
+	if err := spec.Unsupported("local", storageops.SpecFieldType, storageops.SpecFieldIops,
+		storageops.SpecFieldThroughput, storageops.SpecFieldEncrypted,
+		storageops.SpecFieldKMSKey); err != nil {
+		return nil, err
+	}
+	req := &CreateRequest{}
+	if spec.Native != nil {
+		native, ok := spec.Native.(*CreateRequest)
+		if !ok {
+			return nil, storageops.NativeTemplateError("local", spec.Native)
+		}
+		copied := *native
+		req = &copied
+	}
+	if spec.SizeGiB > 0 {
+		req.SizeBytes = spec.SizeGiB * uint64(storageops.GiB)
+	}
+	if len(spec.SnapshotID) > 0 {
+		req.SnapshotID = spec.SnapshotID
+	}
+	return req, nil
+}
+
+func (c *converter) ToSnapshot(raw interface{}) (*storageops.Snapshot, error) {
+	lv, ok := raw.(*LogicalVolume)
+	if !ok || !lv.IsSnapshot() {
+		return nil, fmt.Errorf("invalid local snapshot %T", raw)
+	}
+	return toSnapshot(lv), nil
+}
+
+func toSnapshot(lv *LogicalVolume) *storageops.Snapshot {
+	return &storageops.Snapshot{
+		ID:       lv.ID(),
+		VolumeID: lv.VolumeGroup + "/" + lv.Origin,
+		SizeGiB:  lv.SizeBytes / uint64(storageops.GiB),
+		State:    snapshotState(lv),
+		Created:  lv.Created,
+		Labels:   tagsToLabels(lv.Tags),
+		Raw:      lv,
+	}
+}
@@ -0,0 +1,33 @@
+package local
+
+import (
+	"strings"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+// errorOutputs maps the messages of failed lvm commands to storage error
+// codes
+var errorOutputs = map[string]int{
+	"Failed to find logical volume": storageops.ErrVolNotFound,
+	"not found":                     storageops.ErrVolNotFound,
+	"Insufficient free space":       storageops.ErrQuotaExceeded,
+	"already exists":                storageops.ErrVolInval,
+	"is used by another device":     storageops.ErrDeviceBusy,
+	"in use":                        storageops.ErrDeviceBusy,
+}
+
+// storageError maps an error of an lvm command to a storage error with the
+// matching code. Errors without a matching code are returned unchanged.
+func (s *localOps) storageError(err error) error {
+	cmdErr, ok := err.(*CommandError)
+	if !ok {
+		return err
+	}
+	for msg, code := range errorOutputs {
+		if strings.Contains(cmdErr.Output, msg) {
+			return storageops.WrapError(code, err, s.InstanceID())
+		}
+	}
+	return err
+}
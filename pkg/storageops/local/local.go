@@ -0,0 +1,655 @@
+// Package local implements the storage ops driver of local disks of on-prem
+// and bare metal servers, e.g. JBOD or SSD pools, with LVM logical volumes of
+// a volume group. Volumes are attached by activating them, snapshots are LVM
+// snapshots.
+package local
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/pborman/uuid"
+)
+
+const (
+	// DefaultSnapshotSizePercent is the default size of the copy-on-write
+	// space of snapshots of thick volumes, in percent of the volume size
+	DefaultSnapshotSizePercent = 20
+	// snapshotValid and snapshotInvalid are the states of snapshots, invalid
+	// snapshots overflowed their copy-on-write space
+	snapshotValid   = "valid"
+	snapshotInvalid = "invalid"
+	// volumeActive and volumeInactive are the states of volumes
+	volumeActive   = "active"
+	volumeInactive = "inactive"
+)
+
+// tagRegex matches valid LVM tags
+var tagRegex = regexp.MustCompile(`^[a-zA-Z0-9_+.\-/=!:&#]+$`)
+
+// Config is the configuration of the local storage ops driver
+type Config struct {
+	// VolumeGroup the logical volumes are created in
+	VolumeGroup string
+	// ThinPool creates thin volumes in the given thin pool of the volume
+	// group if set, or else thick volumes. Snapshots of thin volumes need no
+	// copy-on-write space and can be restored.
+	ThinPool string
+	// SnapshotSizePercent is the size of the copy-on-write space of
+	// snapshots of thick volumes, in percent of the volume size. Defaults to
+	// DefaultSnapshotSizePercent.
+	SnapshotSizePercent int
+	// Host is the instance ID of the server, defaults to its hostname
+	Host string
+	// Zone of the server, e.g. its rack, empty if not set
+	Zone string
+	// Region of the server, e.g. its data center, empty if not set
+	Region string
+}
+
+// CreateRequest is the template of a logical volume to create
+type CreateRequest struct {
+	// Name of the logical volume, defaults to a random name
+	Name string
+	// SizeBytes is the size of the volume, rounded up to the extent size by
+	// LVM
+	SizeBytes uint64
+	// SnapshotID is the thin snapshot to create the volume from, if any
+	SnapshotID string
+}
+
+type localOps struct {
+	cfg Config
+}
+
+var _ storageops.Ops = &localOps{}
+
+// NewEnvClient creates a new local operations client for the volume group
+// of the LVM_VOLUME_GROUP environment var. Volumes are created in the thin
+// pool of LVM_THIN_POOL if set.
+func NewEnvClient() (storageops.Ops, error) {
+	vg, err := storageops.GetEnvValueStrict("LVM_VOLUME_GROUP")
+	if err != nil {
+		return nil, err
+	}
+	pool, _ := storageops.GetEnvValueStrict("LVM_THIN_POOL")
+	return NewClient(Config{VolumeGroup: vg, ThinPool: pool})
+}
+
+// NewClient creates a new local operations client for the volume group of
+// the given config, which must exist
+func NewClient(cfg Config) (storageops.Ops, error) {
+	if len(cfg.VolumeGroup) == 0 {
+		return nil, fmt.Errorf("volume group is required")
+	}
+	if cfg.SnapshotSizePercent == 0 {
+		cfg.SnapshotSizePercent = DefaultSnapshotSizePercent
+	}
+	if cfg.SnapshotSizePercent < 0 || cfg.SnapshotSizePercent > 100 {
+		return nil, fmt.Errorf("invalid snapshot size percent %d", cfg.SnapshotSizePercent)
+	}
+	if len(cfg.Host) == 0 {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("error fetching hostname. Err: %v", err)
+		}
+		cfg.Host = host
+	}
+	if _, err := getVolumeGroup(cfg.VolumeGroup); err != nil {
+		return nil, err
+	}
+	return &localOps{cfg: cfg}, nil
+}
+
+func (s *localOps) Name() string { return "local" }
+
+func (s *localOps) InstanceID() string { return s.cfg.Host }
+
+func (s *localOps) GetZone() (string, error) { return s.cfg.Zone, nil }
+
+func (s *localOps) GetRegion() (string, error) { return s.cfg.Region, nil }
+
+// ListZones returns the zone of the server, volumes are local to it
+func (s *localOps) ListZones() ([]string, error) { return []string{s.cfg.Zone}, nil }
+
+// labelsToTags returns the key=value tags of the given labels
+func labelsToTags(labels map[string]string) ([]string, error) {
+	tags := make([]string, 0, len(labels))
+	for k, v := range labels {
+		tag := k + "=" + v
+		if !tagRegex.MatchString(tag) || strings.Contains(k, "=") {
+			return nil, storageops.NewStorageError(storageops.ErrVolInval,
+				fmt.Sprintf("label %s=%s is not a valid tag, tags may only contain "+
+					"letters, numbers and _+.-/!:&# and keys may not contain =", k, v), "")
+		}
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// tagsToLabels returns the labels of the given tags, see labelsToTags. Tags
+// without a value are labels with an empty value.
+func tagsToLabels(tags []string) map[string]string {
+	labels := make(map[string]string)
+	for _, tag := range tags {
+		if i := strings.Index(tag, "="); i >= 0 {
+			labels[tag[:i]] = tag[i+1:]
+		} else {
+			labels[tag] = ""
+		}
+	}
+	return labels
+}
+
+// hasLabels returns true if the given tags carry all given labels
+func hasLabels(tags []string, labels map[string]string) bool {
+	have := tagsToLabels(tags)
+	for k, v := range labels {
+		if value, ok := have[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// parseID returns the logical volume name of the given volume or snapshot
+// ID, which must be in the volume group of the driver
+func (s *localOps) parseID(id string) (string, error) {
+	i := strings.Index(id, "/")
+	if i < 0 || id[:i] != s.cfg.VolumeGroup || len(id[i+1:]) == 0 {
+		return "", storageops.NewStorageError(storageops.ErrVolNotFound,
+			fmt.Sprintf("volume %s is not in volume group %s", id, s.cfg.VolumeGroup),
+			s.InstanceID())
+	}
+	return id[i+1:], nil
+}
+
+// list returns the logical volumes of the volume group
+func (s *localOps) list() ([]*LogicalVolume, error) {
+	lvs, err := listLogicalVolumes(s.cfg.VolumeGroup)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	for _, lv := range lvs {
+		lv.Host, lv.Zone = s.cfg.Host, s.cfg.Zone
+	}
+	return lvs, nil
+}
+
+// volume returns the logical volume of the given volume or snapshot ID
+func (s *localOps) volume(id string) (*LogicalVolume, error) {
+	name, err := s.parseID(id)
+	if err != nil {
+		return nil, err
+	}
+	lvs, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	for _, lv := range lvs {
+		if lv.Name == name {
+			return lv, nil
+		}
+	}
+	return nil, storageops.NewStorageError(storageops.ErrVolNotFound,
+		fmt.Sprintf("logical volume %s not found", id), s.InstanceID())
+}
+
+// Create creates a logical volume from a *CreateRequest template, thin if
+// the driver has a thin pool. Volumes activated by LVM are deactivated, so
+// new volumes are detached. The labels are stored as key=value tags. LVM
+// has no idempotent creates, volumes of a client token are named after it
+// and a Create retried with the token returns the volume of the first
+// attempt.
+func (s *localOps) Create(
+	template interface{},
+	labels map[string]string,
+) (*storageops.Volume, error) {
+	lv, err := s.create(template, labels)
+	if err != nil {
+		return nil, err
+	}
+	return toVolume(lv), nil
+}
+
+func (s *localOps) create(
+	template interface{},
+	labels map[string]string,
+) (*LogicalVolume, error) {
+	template, labels, err := storageops.VolumeTemplate(s.Name(), template, labels)
+	if err != nil {
+		return nil, err
+	}
+	req, ok := template.(*CreateRequest)
+	if !ok {
+		return nil, storageops.NewStorageError(storageops.ErrVolInval,
+			"Invalid volume template given", s.InstanceID())
+	}
+	tags, err := labelsToTags(labels)
+	if err != nil {
+		return nil, err
+	}
+	name := req.Name
+	if token := storageops.ClientToken(labels); len(token) > 0 {
+		if len(name) == 0 {
+			name = "openstorage-" + token
+		}
+		if lv, err := s.volume(s.cfg.VolumeGroup + "/" + name); err == nil &&
+			storageops.ClientToken(tagsToLabels(lv.Tags)) == token {
+			return lv, nil
+		}
+	}
+	if len(name) == 0 {
+		name = "openstorage-" + uuid.New()
+	}
+
+	args := []string{"lvcreate", "-y", "-n", name}
+	switch {
+	case len(req.SnapshotID) > 0:
+		snap, err := s.parseID(req.SnapshotID)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-s", s.cfg.VolumeGroup+"/"+snap)
+	case req.SizeBytes == 0:
+		return nil, storageops.NewStorageError(storageops.ErrVolInval,
+			"volume size is required", s.InstanceID())
+	case len(s.cfg.ThinPool) > 0:
+		args = append(args, "-V", sizeArg(req.SizeBytes),
+			"-T", s.cfg.VolumeGroup+"/"+s.cfg.ThinPool)
+	default:
+		args = append(args, "-L", sizeArg(req.SizeBytes), s.cfg.VolumeGroup)
+	}
+	if _, err := lvm(append(args, tagArgs("--addtag", tags)...)...); err != nil {
+		return nil, s.storageError(err)
+	}
+	lv, err := s.volume(s.cfg.VolumeGroup + "/" + name)
+	if err != nil || !lv.Active() {
+		return lv, err
+	}
+	if err := s.Detach(lv.ID()); err != nil {
+		return nil, err
+	}
+	return s.volume(lv.ID())
+}
+
+func (s *localOps) GetDeviceID(template interface{}) (string, error) {
+	if id, ok := storageops.TypedID(template); ok {
+		return id, nil
+	}
+	if lv, ok := template.(*LogicalVolume); ok {
+		return lv.ID(), nil
+	}
+	return "", fmt.Errorf("invalid type: %v given to GetDeviceID", template)
+}
+
+func (s *localOps) Expand(volumeID string, newSizeGiB uint64) (uint64, error) {
+	lv, err := s.volume(volumeID)
+	if err != nil {
+		return 0, err
+	}
+	gib := uint64(storageops.GiB)
+	currentSize := (lv.SizeBytes + gib - 1) / gib
+	if newSizeGiB == currentSize {
+		return currentSize, nil
+	}
+	if newSizeGiB < currentSize {
+		return 0, storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("volume %s of %d GiB cannot be shrunk to %d GiB",
+				volumeID, currentSize, newSizeGiB), s.InstanceID())
+	}
+	if _, err := lvm("lvextend", "-L", sizeArg(newSizeGiB*gib), lv.ID()); err != nil {
+		return 0, s.storageError(err)
+	}
+	return newSizeGiB, nil
+}
+
+// Modify is not supported, logical volumes have the performance of the
+// disks of their volume group
+func (s *localOps) Modify(volumeID string, spec storageops.VolumeSpecUpdate) error {
+	return storageops.ErrNotSupported
+}
+
+// Attach activates the given logical volume and returns its device mapper
+// path. Activating an active volume returns its path.
+func (s *localOps) Attach(volumeID string, options map[string]string) (string, error) {
+	lv, err := s.volume(volumeID)
+	if err != nil {
+		return "", err
+	}
+	if !lv.Active() {
+		// Thin snapshots skip activation unless -K is given
+		if _, err := lvm("lvchange", "-ay", "-K", lv.ID()); err != nil {
+			return "", s.storageError(err)
+		}
+	}
+	return lv.DevicePath(), nil
+}
+
+// Detach deactivates the given logical volume
+func (s *localOps) Detach(volumeID string) error {
+	lv, err := s.volume(volumeID)
+	if err != nil {
+		return err
+	}
+	if !lv.Active() {
+		return nil
+	}
+	if _, err := lvm("lvchange", "-an", lv.ID()); err != nil {
+		return s.storageError(err)
+	}
+	return nil
+}
+
+// DetachFrom detaches the given volume from this server, logical volumes are
+// local to the server of their volume group
+func (s *localOps) DetachFrom(volumeID, instanceID string) error {
+	if instanceID != s.cfg.Host {
+		return s.remoteError(instanceID)
+	}
+	return s.Detach(volumeID)
+}
+
+// remoteError returns the error of operations on volumes of other servers
+func (s *localOps) remoteError(instanceID string) error {
+	return fmt.Errorf("%w: volumes of %s are local to it", storageops.ErrNotSupported, instanceID)
+}
+
+// Delete removes the given logical volume. Active volumes are deactivated
+// first, volumes in use fail with ErrDeviceBusy.
+func (s *localOps) Delete(volumeID string) error {
+	lv, err := s.volume(volumeID)
+	if err != nil {
+		return err
+	}
+	if _, err := lvm("lvremove", "-y", lv.ID()); err != nil {
+		return s.storageError(err)
+	}
+	return nil
+}
+
+func (s *localOps) DeleteFrom(volumeID, instanceID string) error {
+	if instanceID != s.cfg.Host {
+		return s.remoteError(instanceID)
+	}
+	return s.Delete(volumeID)
+}
+
+// Describe returns the *VolumeGroup of the driver
+func (s *localOps) Describe() (interface{}, error) {
+	vg, err := getVolumeGroup(s.cfg.VolumeGroup)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	return vg, nil
+}
+
+// FreeDevices is not supported, the devices of logical volumes are named
+// after them
+func (s *localOps) FreeDevices(
+	blockDeviceMappings []interface{},
+	rootDeviceName string,
+) ([]string, error) {
+	return nil, storageops.ErrNotSupported
+}
+
+func (s *localOps) Inspect(volumeIds []*string) ([]*storageops.Volume, error) {
+	var vols []*storageops.Volume
+	for _, id := range volumeIds {
+		lv, err := s.volume(*id)
+		if err != nil {
+			return nil, err
+		}
+		vols = append(vols, toVolume(lv))
+	}
+	return vols, nil
+}
+
+// DeviceMappings returns the device mapper paths of the active volumes
+func (s *localOps) DeviceMappings() (map[string]string, error) {
+	lvs, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string)
+	for _, lv := range lvs {
+		if lv.Active() && (lv.IsVolume() || lv.IsSnapshot()) {
+			m[lv.DevicePath()] = lv.ID()
+		}
+	}
+	return m, nil
+}
+
+// Enumerate returns the volumes of the volume group matching the given IDs
+// and labels, without snapshots and thin pools. The set of a volume is the
+// setIdentifier label key if it has it.
+func (s *localOps) Enumerate(
+	volumeIds []*string,
+	labels map[string]string,
+	setIdentifier string,
+) (map[string][]*storageops.Volume, error) {
+	ids := make(map[string]bool)
+	for _, id := range volumeIds {
+		ids[*id] = true
+	}
+	lvs, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	sets := make(map[string][]*storageops.Volume)
+	for _, lv := range lvs {
+		if !lv.IsVolume() || (len(ids) > 0 && !ids[lv.ID()]) || !hasLabels(lv.Tags, labels) {
+			continue
+		}
+		set := storageops.SetIdentifierNone
+		if _, ok := tagsToLabels(lv.Tags)[setIdentifier]; ok && len(setIdentifier) > 0 {
+			set = setIdentifier
+		}
+		storageops.AddElementToMap(sets, toVolume(lv), set)
+	}
+	return sets, nil
+}
+
+func (s *localOps) DevicePath(volumeID string) (string, error) {
+	lv, err := s.volume(volumeID)
+	if err != nil {
+		return "", err
+	}
+	if !lv.Active() {
+		return "", storageops.NewStorageError(storageops.ErrVolDetached,
+			fmt.Sprintf("Volume %s is detached", volumeID), s.InstanceID())
+	}
+	return lv.DevicePath(), nil
+}
+
+// Snapshot takes an LVM snapshot of the given volume carrying its tags. Thin
+// volumes get thin snapshots, thick volumes snapshots with copy-on-write
+// space of SnapshotSizePercent of their size.
+func (s *localOps) Snapshot(volumeID string, readonly bool) (*storageops.Snapshot, error) {
+	lv, err := s.volume(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("%s-snap-%s", lv.Name, uuid.New()[:8])
+	args := []string{"lvcreate", "-y", "-s", "-n", name}
+	if len(lv.Pool) == 0 {
+		cow := lv.SizeBytes * uint64(s.cfg.SnapshotSizePercent) / 100
+		args = append(args, "-L", sizeArg(cow))
+	}
+	if readonly {
+		args = append(args, "-p", "r")
+	}
+	args = append(append(args, tagArgs("--addtag", lv.Tags)...), lv.ID())
+	if _, err := lvm(args...); err != nil {
+		return nil, s.storageError(err)
+	}
+	snap, err := s.volume(s.cfg.VolumeGroup + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	return toSnapshot(snap), nil
+}
+
+func (s *localOps) SnapshotDelete(snapID string) error {
+	lv, err := s.volume(snapID)
+	if err != nil {
+		return err
+	}
+	if !lv.IsSnapshot() {
+		return storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("%s is not a snapshot", snapID), s.InstanceID())
+	}
+	if _, err := lvm("lvremove", "-y", lv.ID()); err != nil {
+		return s.storageError(err)
+	}
+	return nil
+}
+
+// snapshotState returns the state of the given snapshot
+func snapshotState(lv *LogicalVolume) string {
+	if lv.Invalid() {
+		return snapshotInvalid
+	}
+	return snapshotValid
+}
+
+func (s *localOps) SnapshotEnumerate(
+	filter *storageops.SnapshotFilter,
+) ([]*storageops.Snapshot, error) {
+	lvs, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	var snaps []*storageops.Snapshot
+	for _, lv := range lvs {
+		if !lv.IsSnapshot() {
+			continue
+		}
+		if filter != nil && !hasLabels(lv.Tags, filter.Labels) {
+			continue
+		}
+		snap := toSnapshot(lv)
+		origin := s.cfg.VolumeGroup + "/" + lv.Origin
+		if filter.Match(origin, lv.Created, snapshotState(lv), snap.Labels) {
+			snaps = append(snaps, snap)
+		}
+	}
+	return snaps, nil
+}
+
+// SnapshotRestore creates a volume from the given thin snapshot, as a thin
+// snapshot of it carrying its labels and the given labels. Snapshots of thick volumes can only be merged back into
+// their origin, which is not supported. Volumes are local to the server, the
+// zone must be empty or the zone of the server.
+func (s *localOps) SnapshotRestore(
+	snapID, zone string,
+	labels map[string]string,
+) (*storageops.Volume, error) {
+	if len(zone) > 0 && zone != s.cfg.Zone {
+		return nil, storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("volumes can not be restored to zone %s of other servers", zone),
+			s.InstanceID())
+	}
+	snap, err := s.volume(snapID)
+	if err != nil {
+		return nil, err
+	}
+	if !snap.IsSnapshot() {
+		return nil, storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("%s is not a snapshot", snapID), s.InstanceID())
+	}
+	if len(snap.Pool) == 0 {
+		return nil, fmt.Errorf("%w: snapshot %s of a thick volume can not be restored",
+			storageops.ErrNotSupported, snapID)
+	}
+	// The client token of the snapshot is the one of its origin
+	merged := tagsToLabels(snap.Tags)
+	delete(merged, storageops.ClientTokenLabel)
+	for k, v := range labels {
+		merged[k] = v
+	}
+	return s.Create(&CreateRequest{SnapshotID: snapID}, merged)
+}
+
+// SnapshotStatus returns the status of the given snapshot. LVM snapshots are
+// usable as soon as they exist, snapshots that overflowed their
+// copy-on-write space failed.
+func (s *localOps) SnapshotStatus(snapID string) (*storageops.SnapshotStatus, error) {
+	lv, err := s.volume(snapID)
+	if err != nil {
+		return nil, err
+	}
+	status := &storageops.SnapshotStatus{
+		ID:        lv.ID(),
+		State:     snapshotState(lv),
+		Progress:  100,
+		Completed: true,
+	}
+	if lv.Invalid() {
+		status.Completed, status.Failed = false, true
+		status.Message = "snapshot overflowed its copy-on-write space"
+	}
+	return status, nil
+}
+
+func (s *localOps) ApplyTags(volumeID string, labels map[string]string) error {
+	lv, err := s.volume(volumeID)
+	if err != nil {
+		return err
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	tags, err := labelsToTags(labels)
+	if err != nil {
+		return err
+	}
+	// Tags of the keys with other values are replaced
+	var stale []string
+	for _, tag := range lv.Tags {
+		k := strings.SplitN(tag, "=", 2)[0]
+		if v, ok := labels[k]; ok && tag != k+"="+v {
+			stale = append(stale, tag)
+		}
+	}
+	args := append(tagArgs("--deltag", stale), tagArgs("--addtag", tags)...)
+	if _, err := lvm(append(append([]string{"lvchange"}, args...), lv.ID())...); err != nil {
+		return s.storageError(err)
+	}
+	return nil
+}
+
+// RemoveTags removes the tags of the given label keys, whatever their value
+func (s *localOps) RemoveTags(volumeID string, labels map[string]string) error {
+	lv, err := s.volume(volumeID)
+	if err != nil {
+		return err
+	}
+	var remove []string
+	for _, tag := range lv.Tags {
+		if _, ok := labels[strings.SplitN(tag, "=", 2)[0]]; ok {
+			remove = append(remove, tag)
+		}
+	}
+	if len(remove) == 0 {
+		return nil
+	}
+	args := append([]string{"lvchange"}, tagArgs("--deltag", remove)...)
+	if _, err := lvm(append(args, lv.ID())...); err != nil {
+		return s.storageError(err)
+	}
+	return nil
+}
+
+func (s *localOps) Tags(volumeID string) (map[string]string, error) {
+	lv, err := s.volume(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	return tagsToLabels(lv.Tags), nil
+}
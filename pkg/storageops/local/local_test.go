@@ -0,0 +1,273 @@
+package local
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/libopenstorage/openstorage/pkg/storageops/test"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAll(t *testing.T) {
+	d, err := NewEnvClient()
+	if err != nil {
+		t.Skipf("skipping local tests as environment is not set...\n")
+	}
+	name := fmt.Sprintf("openstorage-test-%s", uuid.New()[:8])
+	test.RunTest(map[string]storageops.Ops{d.Name(): d},
+		map[string]map[string]interface{}{d.Name(): {
+			name: &CreateRequest{Name: name, SizeBytes: 1 << 30},
+		}}, t)
+}
+
+// fakeLVM is an in memory volume group with a thin pool, run instead of the
+// lvm commands
+type fakeLVM struct {
+	t   *testing.T
+	vg  string
+	lvs map[string]map[string]string
+}
+
+func newFakeLVM(t *testing.T, vg string) *fakeLVM {
+	f := &fakeLVM{t: t, vg: vg, lvs: make(map[string]map[string]string)}
+	f.lvs["pool"] = f.lv("pool", "twi-a-tz--", 100<<30, "", "")
+	return f
+}
+
+func (f *fakeLVM) lv(name, attr string, size uint64, origin, pool string) map[string]string {
+	return map[string]string{
+		"lv_name": name,
+		"vg_name": f.vg,
+		"lv_uuid": uuid.New(),
+		"lv_size": strconv.FormatUint(size, 10),
+		"lv_attr": attr,
+		"origin":  origin,
+		"pool_lv": pool,
+		"lv_time": time.Now().Format(lvTimeFormat),
+	}
+}
+
+// run parses the args of the lvm command the driver runs
+func (f *fakeLVM) run(args ...string) ([]byte, error) {
+	flags := make(map[string][]string)
+	var positional []string
+	for i := 1; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "-n", "-L", "-V", "-T", "-p", "-o", "--addtag", "--deltag",
+			"--reportformat", "--units":
+			flags[arg] = append(flags[arg], args[i+1])
+			i++
+		case "-y", "-s", "-ay", "-an", "-K", "--nosuffix":
+			flags[arg] = nil
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	fail := func(msg string) ([]byte, error) {
+		return nil, &CommandError{Args: args, Output: msg, Err: errors.New("exit status 5")}
+	}
+	lvName := func(id string) string { return strings.TrimPrefix(id, f.vg+"/") }
+	size := func(flag string) uint64 {
+		v, err := strconv.ParseUint(strings.TrimSuffix(flags[flag][0], "b"), 10, 64)
+		assert.NoError(f.t, err)
+		return v
+	}
+	setAttr := func(lv map[string]string, i int, c byte) {
+		attr := []byte(lv["lv_attr"])
+		attr[i] = c
+		lv["lv_attr"] = string(attr)
+	}
+
+	switch args[0] {
+	case "vgs":
+		assert.Equal(f.t, []string{f.vg}, positional)
+		return json.Marshal(map[string]interface{}{"report": []interface{}{
+			map[string]interface{}{"vg": []map[string]string{{
+				"vg_name": f.vg, "vg_uuid": "vg-uuid", "vg_size": "214748364800",
+				"vg_free": "107374182400",
+			}}},
+		}})
+	case "lvs":
+		assert.Equal(f.t, []string{f.vg}, positional)
+		assert.Equal(f.t, []string{"json"}, flags["--reportformat"])
+		var rows []map[string]string
+		for _, lv := range f.lvs {
+			rows = append(rows, lv)
+		}
+		return json.Marshal(map[string]interface{}{"report": []interface{}{
+			map[string]interface{}{"lv": rows},
+		}})
+	case "lvcreate":
+		name := flags["-n"][0]
+		if _, ok := f.lvs[name]; ok {
+			return fail(fmt.Sprintf("Logical Volume \"%s\" already exists in volume group \"%s\"", name, f.vg))
+		}
+		var lv map[string]string
+		switch _, snapshot := flags["-s"]; {
+		case snapshot:
+			origin, ok := f.lvs[lvName(positional[0])]
+			if !ok {
+				return fail("Failed to find logical volume " + positional[0])
+			}
+			originSize, _ := strconv.ParseUint(origin["lv_size"], 10, 64)
+			if len(origin["pool_lv"]) > 0 {
+				lv = f.lv(name, "Vwi---tz-k", originSize, origin["lv_name"], origin["pool_lv"])
+			} else {
+				assert.Equal(f.t, originSize/5, size("-L"), "COW space must be 20% of the origin")
+				lv = f.lv(name, "swi-a-s---", originSize, origin["lv_name"], "")
+			}
+		case len(flags["-T"]) > 0:
+			assert.Equal(f.t, []string{f.vg + "/pool"}, flags["-T"])
+			lv = f.lv(name, "Vwi-a-tz--", size("-V"), "", "pool")
+		default:
+			assert.Equal(f.t, []string{f.vg}, positional)
+			lv = f.lv(name, "-wi-a-----", size("-L"), "", "")
+		}
+		lv["lv_tags"] = strings.Join(flags["--addtag"], ",")
+		f.lvs[name] = lv
+	case "lvchange":
+		lv, ok := f.lvs[lvName(positional[0])]
+		if !ok {
+			return fail("Failed to find logical volume " + positional[0])
+		}
+		if _, ok := flags["-ay"]; ok {
+			if lv["lv_attr"][9] == 'k' {
+				_, ok := flags["-K"]
+				assert.True(f.t, ok, "activating thin snapshots requires -K")
+			}
+			setAttr(lv, 4, 'a')
+		}
+		if _, ok := flags["-an"]; ok {
+			setAttr(lv, 4, '-')
+		}
+		var tags []string
+		for _, tag := range strings.Split(lv["lv_tags"], ",") {
+			deleted := len(tag) == 0
+			for _, del := range flags["--deltag"] {
+				deleted = deleted || tag == del
+			}
+			if !deleted {
+				tags = append(tags, tag)
+			}
+		}
+		lv["lv_tags"] = strings.Join(append(tags, flags["--addtag"]...), ",")
+	case "lvremove":
+		name := lvName(positional[0])
+		if _, ok := f.lvs[name]; !ok {
+			return fail("Failed to find logical volume " + positional[0])
+		}
+		delete(f.lvs, name)
+	case "lvextend":
+		lv, ok := f.lvs[lvName(positional[0])]
+		if !ok {
+			return fail("Failed to find logical volume " + positional[0])
+		}
+		lv["lv_size"] = strconv.FormatUint(size("-L"), 10)
+	default:
+		f.t.Errorf("unexpected lvm command %v", args)
+	}
+	return nil, nil
+}
+
+func TestLocalVolumes(t *testing.T) {
+	for _, thin := range []bool{false, true} {
+		t.Run(fmt.Sprintf("thin=%v", thin), func(t *testing.T) {
+			fake := newFakeLVM(t, "data-vg")
+			run := lvm
+			lvm = fake.run
+			defer func() { lvm = run }()
+
+			cfg := Config{VolumeGroup: "data-vg", Host: "server-1", Zone: "rack-1"}
+			if thin {
+				cfg.ThinPool = "pool"
+			}
+			d, err := NewClient(cfg)
+			assert.NoError(t, err)
+
+			vol, err := d.Create(&storageops.VolumeSpec{SizeGiB: 10, ClientToken: "token-1"},
+				map[string]string{"app": "db"})
+			assert.NoError(t, err)
+			assert.Equal(t, "data-vg/openstorage-token-1", vol.ID)
+			assert.Equal(t, uint64(10), vol.SizeGiB)
+			assert.Equal(t, "rack-1", vol.Zone)
+			assert.Equal(t, "db", vol.Labels["app"])
+			assert.Empty(t, vol.AttachedTo, "new volumes must be detached")
+			again, err := d.Create(&storageops.VolumeSpec{SizeGiB: 10, ClientToken: "token-1"}, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, vol.ID, again.ID, "creates with the same client token must be idempotent")
+			_, err = d.Create(&CreateRequest{SizeBytes: 1 << 30}, map[string]string{"bad": "white space"})
+			assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval))
+
+			_, err = d.Attach(vol.ID, nil)
+			assert.NoError(t, err)
+			devicePath, err := d.DevicePath(vol.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, "/dev/mapper/data--vg-openstorage--token--1", devicePath)
+			mappings, err := d.DeviceMappings()
+			assert.NoError(t, err)
+			assert.Equal(t, vol.ID, mappings[devicePath])
+			assert.NoError(t, d.Detach(vol.ID))
+			_, err = d.DevicePath(vol.ID)
+			assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolDetached))
+			err = d.DetachFrom(vol.ID, "server-2")
+			assert.True(t, errors.Is(err, storageops.ErrNotSupported))
+
+			size, err := d.Expand(vol.ID, 20)
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(20), size)
+			_, err = d.Expand(vol.ID, 5)
+			assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval))
+
+			assert.NoError(t, d.ApplyTags(vol.ID, map[string]string{"app": "web", "tier": "1"}))
+			tags, err := d.Tags(vol.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, "web", tags["app"])
+			assert.Equal(t, "1", tags["tier"])
+			assert.NoError(t, d.RemoveTags(vol.ID, map[string]string{"tier": ""}))
+			sets, err := d.Enumerate(nil, map[string]string{"app": "web"}, "")
+			assert.NoError(t, err)
+			assert.Len(t, sets[storageops.SetIdentifierNone], 1)
+
+			snap, err := d.Snapshot(vol.ID, false)
+			assert.NoError(t, err)
+			assert.Equal(t, vol.ID, snap.VolumeID)
+			assert.Equal(t, "web", snap.Labels["app"])
+			status, err := d.SnapshotStatus(snap.ID)
+			assert.NoError(t, err)
+			assert.True(t, status.Completed)
+			snaps, err := d.SnapshotEnumerate(&storageops.SnapshotFilter{VolumeID: vol.ID})
+			assert.NoError(t, err)
+			assert.Len(t, snaps, 1)
+			sets, err = d.Enumerate(nil, nil, "")
+			assert.NoError(t, err)
+			assert.Len(t, sets[storageops.SetIdentifierNone], 1, "snapshots and pools are not volumes")
+
+			restored, err := d.SnapshotRestore(snap.ID, "", map[string]string{"restored": "true"})
+			if thin {
+				assert.NoError(t, err)
+				assert.Equal(t, uint64(20), restored.SizeGiB)
+				assert.Equal(t, "web", restored.Labels["app"])
+				assert.Equal(t, "true", restored.Labels["restored"])
+				_, err = d.Attach(restored.ID, nil)
+				assert.NoError(t, err)
+				assert.NoError(t, d.Detach(restored.ID))
+				assert.NoError(t, d.Delete(restored.ID))
+			} else {
+				assert.True(t, errors.Is(err, storageops.ErrNotSupported))
+			}
+
+			assert.NoError(t, d.SnapshotDelete(snap.ID))
+			assert.NoError(t, d.Delete(vol.ID))
+			_, err = d.Inspect([]*string{&vol.ID})
+			assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolNotFound))
+			assert.Len(t, fake.lvs, 1)
+		})
+	}
+}
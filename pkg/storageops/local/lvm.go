@@ -0,0 +1,224 @@
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lvFields are the lvs report fields of logical volumes
+const lvFields = "lv_name,vg_name,lv_uuid,lv_size,lv_tags,lv_attr,origin,pool_lv,lv_time"
+
+// lvTimeFormat is the format of the lv_time report field
+const lvTimeFormat = "2006-01-02 15:04:05 -0700"
+
+// lvm runs the given lvm command, e.g. lvcreate, and returns its output
+var lvm = func(args ...string) ([]byte, error) {
+	out, err := exec.Command("lvm", args...).CombinedOutput()
+	if err != nil {
+		return nil, &CommandError{
+			Args:   args,
+			Output: strings.TrimSpace(string(out)),
+			Err:    err,
+		}
+	}
+	return out, nil
+}
+
+// CommandError is the error of a failed lvm command
+type CommandError struct {
+	// Args of the lvm command, starting with the command
+	Args []string
+	// Output of the command
+	Output string
+	// Err is the exit error of the command
+	Err error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("lvm %s failed: %v: %s", strings.Join(e.Args, " "), e.Err, e.Output)
+}
+
+// LogicalVolume is an LVM logical volume, the raw volume and snapshot of the
+// driver
+type LogicalVolume struct {
+	// Name of the logical volume
+	Name string
+	// VolumeGroup of the logical volume
+	VolumeGroup string
+	// UUID of the logical volume
+	UUID string
+	// SizeBytes is the size of the logical volume
+	SizeBytes uint64
+	// Tags of the logical volume, key=value for labels
+	Tags []string
+	// Attr are the lv_attr bits of the logical volume, e.g. -wi-a-----
+	Attr string
+	// Origin is the logical volume this snapshot was taken of, empty if it
+	// is not a snapshot
+	Origin string
+	// Pool is the thin pool of a thin volume, empty if it is not thin
+	Pool string
+	// Created is when the logical volume was created
+	Created time.Time
+	// Host the volume group is on, set by the driver
+	Host string
+	// Zone of the host, set by the driver
+	Zone string
+}
+
+// ID returns the ID of the logical volume, its volume group and name
+func (lv *LogicalVolume) ID() string {
+	return lv.VolumeGroup + "/" + lv.Name
+}
+
+// Active returns true if the logical volume is activated on the host
+func (lv *LogicalVolume) Active() bool {
+	return len(lv.Attr) > 4 && lv.Attr[4] == 'a'
+}
+
+// IsSnapshot returns true if the logical volume is a snapshot of another
+func (lv *LogicalVolume) IsSnapshot() bool {
+	return len(lv.Origin) > 0
+}
+
+// Invalid returns true if the logical volume is a snapshot that overflowed
+// its copy-on-write space
+func (lv *LogicalVolume) Invalid() bool {
+	return len(lv.Attr) > 4 && lv.Attr[4] == 'I'
+}
+
+// IsVolume returns true if the logical volume is a volume of the driver, not
+// a snapshot, thin pool or internal volume of LVM
+func (lv *LogicalVolume) IsVolume() bool {
+	if lv.IsSnapshot() || len(lv.Attr) == 0 {
+		return false
+	}
+	switch lv.Attr[0] {
+	case '-', 'V':
+		return true
+	}
+	return false
+}
+
+// DevicePath returns the device mapper path of the logical volume, whose
+// dashes are doubled in the name of the device
+func (lv *LogicalVolume) DevicePath() string {
+	return "/dev/mapper/" + strings.Replace(lv.VolumeGroup, "-", "--", -1) + "-" +
+		strings.Replace(lv.Name, "-", "--", -1)
+}
+
+// VolumeGroup is the LVM volume group of the driver, returned by Describe
+type VolumeGroup struct {
+	// Name of the volume group
+	Name string
+	// UUID of the volume group
+	UUID string
+	// SizeBytes is the size of the volume group
+	SizeBytes uint64
+	// FreeBytes is the size of the extents not allocated to logical volumes
+	FreeBytes uint64
+}
+
+// report is the JSON report of lvs and vgs
+type report struct {
+	Report []struct {
+		LV []map[string]string `json:"lv"`
+		VG []map[string]string `json:"vg"`
+	} `json:"report"`
+}
+
+// reportRows runs the given lvs or vgs command with a JSON report of sizes in
+// bytes and returns its rows
+func reportRows(command string, args ...string) ([]map[string]string, error) {
+	out, err := lvm(append([]string{command,
+		"--reportformat", "json", "--units", "b", "--nosuffix"}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	var r report
+	if err := json.Unmarshal(out, &r); err != nil {
+		return nil, fmt.Errorf("invalid %s report: %v", command, err)
+	}
+	var rows []map[string]string
+	for _, rep := range r.Report {
+		rows = append(rows, rep.LV...)
+		rows = append(rows, rep.VG...)
+	}
+	return rows, nil
+}
+
+// listLogicalVolumes returns the logical volumes of the given volume group
+func listLogicalVolumes(vg string) ([]*LogicalVolume, error) {
+	rows, err := reportRows("lvs", "-o", lvFields, vg)
+	if err != nil {
+		return nil, err
+	}
+	lvs := make([]*LogicalVolume, 0, len(rows))
+	for _, row := range rows {
+		size, err := strconv.ParseUint(row["lv_size"], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size of logical volume %s: %v", row["lv_name"], err)
+		}
+		lv := &LogicalVolume{
+			Name:        row["lv_name"],
+			VolumeGroup: row["vg_name"],
+			UUID:        row["lv_uuid"],
+			SizeBytes:   size,
+			Attr:        row["lv_attr"],
+			Origin:      row["origin"],
+			Pool:        row["pool_lv"],
+		}
+		if tags := row["lv_tags"]; len(tags) > 0 {
+			lv.Tags = strings.Split(tags, ",")
+		}
+		if created := row["lv_time"]; len(created) > 0 {
+			if lv.Created, err = time.Parse(lvTimeFormat, created); err != nil {
+				return nil, fmt.Errorf("invalid time of logical volume %s: %v", lv.Name, err)
+			}
+		}
+		lvs = append(lvs, lv)
+	}
+	return lvs, nil
+}
+
+// getVolumeGroup returns the given volume group
+func getVolumeGroup(name string) (*VolumeGroup, error) {
+	rows, err := reportRows("vgs", "-o", "vg_name,vg_uuid,vg_size,vg_free", name)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) != 1 {
+		return nil, fmt.Errorf("vgs returned %d volume groups for %s", len(rows), name)
+	}
+	vg := &VolumeGroup{Name: rows[0]["vg_name"], UUID: rows[0]["vg_uuid"]}
+	for _, f := range []struct {
+		field string
+		value *uint64
+	}{
+		{"vg_size", &vg.SizeBytes},
+		{"vg_free", &vg.FreeBytes},
+	} {
+		if *f.value, err = strconv.ParseUint(rows[0][f.field], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid %s of volume group %s: %v", f.field, name, err)
+		}
+	}
+	return vg, nil
+}
+
+// tagArgs returns the --addtag or --deltag args of the given tags
+func tagArgs(flag string, tags []string) []string {
+	args := make([]string, 0, 2*len(tags))
+	for _, tag := range tags {
+		args = append(args, flag, tag)
+	}
+	return args
+}
+
+// sizeArg returns the lvcreate and lvextend size arg of the given size
+func sizeArg(sizeBytes uint64) string {
+	return strconv.FormatUint(sizeBytes, 10) + "b"
+}
@@ -0,0 +1,139 @@
+package storageops
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// MaintenanceSnapshotLabel is the label carrying the operation class a
+	// maintenance snapshot was taken before
+	MaintenanceSnapshotLabel = "openstorage-maintenance-snapshot"
+	// MaintenanceExpiresLabel is the label carrying the RFC 3339 time after
+	// which PruneMaintenanceSnapshots deletes a maintenance snapshot
+	MaintenanceExpiresLabel = "openstorage-maintenance-expires"
+)
+
+// MaintenanceOp is a class of risky operations a maintenance snapshot can be
+// taken before
+type MaintenanceOp string
+
+const (
+	// MaintenanceExpand is the class of Expand
+	MaintenanceExpand MaintenanceOp = "expand"
+	// MaintenanceModify is the class of Modify
+	MaintenanceModify MaintenanceOp = "modify"
+	// MaintenanceForceDetach is the class of DetachFrom, which detaches a
+	// volume from another instance that may still be writing to it
+	MaintenanceForceDetach MaintenanceOp = "force-detach"
+	// MaintenanceDelete is the class of Delete and DeleteFrom
+	MaintenanceDelete MaintenanceOp = "delete"
+)
+
+type maintenanceOps struct {
+	Ops
+	retention map[MaintenanceOp]time.Duration
+}
+
+// NewMaintenanceSnapshotOps returns Ops that snapshot a volume before each of
+// the risky operations of the given classes, keeping the snapshot for the
+// retention of the class as an undo point. Operations of other classes are
+// not snapshotted. If the snapshot fails the operation is not performed.
+// The operation does not wait for the snapshot to complete, as snapshots
+// capture the volume at the time they are taken.
+func NewMaintenanceSnapshotOps(ops Ops, retention map[MaintenanceOp]time.Duration) Ops {
+	return &maintenanceOps{
+		Ops:       ops,
+		retention: retention,
+	}
+}
+
+// snapshot takes the maintenance snapshot of the given volume before an
+// operation of the given class
+func (o *maintenanceOps) snapshot(volumeID string, op MaintenanceOp) error {
+	retention, ok := o.retention[op]
+	if !ok {
+		return nil
+	}
+	snap, err := SnapshotWithLabels(o.Ops, volumeID, true, map[string]string{
+		MaintenanceSnapshotLabel: string(op),
+		MaintenanceExpiresLabel:  time.Now().Add(retention).UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to take maintenance snapshot of volume %s before %s: %w",
+			volumeID, op, err)
+	}
+	logrus.Infof("Took maintenance snapshot %s of volume %s before %s", snap.ID, volumeID, op)
+	return nil
+}
+
+func (o *maintenanceOps) Expand(volumeID string, newSizeGiB uint64) (uint64, error) {
+	if err := o.snapshot(volumeID, MaintenanceExpand); err != nil {
+		return 0, err
+	}
+	return o.Ops.Expand(volumeID, newSizeGiB)
+}
+
+func (o *maintenanceOps) Modify(volumeID string, spec VolumeSpecUpdate) error {
+	if err := o.snapshot(volumeID, MaintenanceModify); err != nil {
+		return err
+	}
+	return o.Ops.Modify(volumeID, spec)
+}
+
+func (o *maintenanceOps) DetachFrom(volumeID, instanceID string) error {
+	if err := o.snapshot(volumeID, MaintenanceForceDetach); err != nil {
+		return err
+	}
+	return o.Ops.DetachFrom(volumeID, instanceID)
+}
+
+func (o *maintenanceOps) Delete(volumeID string) error {
+	if err := o.snapshot(volumeID, MaintenanceDelete); err != nil {
+		return err
+	}
+	return o.Ops.Delete(volumeID)
+}
+
+func (o *maintenanceOps) DeleteFrom(volumeID, instanceID string) error {
+	if err := o.snapshot(volumeID, MaintenanceDelete); err != nil {
+		return err
+	}
+	return o.Ops.DeleteFrom(volumeID, instanceID)
+}
+
+// PruneMaintenanceSnapshots deletes the maintenance snapshots whose retention
+// expired and returns their IDs. Snapshots that fail to be deleted are
+// retried by the next prune.
+func PruneMaintenanceSnapshots(ops Ops) ([]string, error) {
+	var pruned []string
+	now := time.Now()
+	for _, op := range []MaintenanceOp{
+		MaintenanceExpand, MaintenanceModify, MaintenanceForceDetach, MaintenanceDelete,
+	} {
+		snaps, err := ops.SnapshotEnumerate(&SnapshotFilter{
+			Labels: map[string]string{MaintenanceSnapshotLabel: string(op)},
+		})
+		if err != nil {
+			return pruned, err
+		}
+		for _, snap := range snaps {
+			expires, err := time.Parse(time.RFC3339, snap.Labels[MaintenanceExpiresLabel])
+			if err != nil {
+				logrus.Warnf("Keeping maintenance snapshot %s with invalid expiry: %v", snap.ID, err)
+				continue
+			}
+			if now.Before(expires) {
+				continue
+			}
+			if err := ops.SnapshotDelete(snap.ID); err != nil {
+				logrus.Warnf("Failed to prune maintenance snapshot %s: %v", snap.ID, err)
+				continue
+			}
+			pruned = append(pruned, snap.ID)
+		}
+	}
+	return pruned, nil
+}
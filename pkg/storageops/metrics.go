@@ -0,0 +1,156 @@
+package storageops
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricsCollector is a Prometheus collector of the latency and errors of
+// storage operations and the retries of their wait loops. Register it with
+// the registry of the serving binary and wrap drivers with Middleware.
+type MetricsCollector struct {
+	latency       *prometheus.HistogramVec
+	errors        *prometheus.CounterVec
+	retries       *prometheus.CounterVec
+	discrepancies *prometheus.CounterVec
+	throttles     *prometheus.CounterVec
+	quarantine    *prometheus.HistogramVec
+}
+
+// NewMetricsCollector creates a collector observing all wait loops of the
+// process
+func NewMetricsCollector() *MetricsCollector {
+	c := &MetricsCollector{
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "storageops",
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of storage operations.",
+			Buckets:   prometheus.ExponentialBuckets(0.05, 2, 14),
+		}, []string{"provider", "operation"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "storageops",
+			Name:      "operation_errors_total",
+			Help:      "Number of failed storage operations.",
+		}, []string{"provider", "operation"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "storageops",
+			Name:      "wait_retries_total",
+			Help:      "Number of retries of wait loops, e.g. waiting for a volume to attach.",
+		}, []string{"wait"}),
+		discrepancies: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "storageops",
+			Name:      "discrepancies_total",
+			Help:      "Number of disagreements between views of the cloud state, by stale view.",
+		}, []string{"provider", "kind"}),
+		throttles: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "storageops",
+			Name:      "throttled_calls_total",
+			Help:      "Number of provider API calls rejected by provider rate limits.",
+		}, []string{"provider", "api"}),
+		quarantine: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "storageops",
+			Name:      "device_quarantine_wait_seconds",
+			Help:      "Time attaches waited for device names freed by detaches to leave quarantine.",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+		}, []string{"provider"}),
+	}
+	AddRetryObserver(func(op string, retries int, err error) {
+		c.retries.WithLabelValues(retryKind(op)).Add(float64(retries))
+	})
+	AddDiscrepancyObserver(func(provider, kind string) {
+		c.discrepancies.WithLabelValues(provider, kind).Inc()
+	})
+	AddThrottleObserver(func(provider, api string) {
+		c.throttles.WithLabelValues(provider, api).Inc()
+	})
+	AddQuarantineObserver(func(provider string, wait time.Duration) {
+		c.quarantine.WithLabelValues(provider).Observe(wait.Seconds())
+	})
+	return c
+}
+
+// Describe implements prometheus.Collector
+func (c *MetricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.latency.Describe(ch)
+	c.errors.Describe(ch)
+	c.retries.Describe(ch)
+	c.discrepancies.Describe(ch)
+	c.throttles.Describe(ch)
+	c.quarantine.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (c *MetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.latency.Collect(ch)
+	c.errors.Collect(ch)
+	c.retries.Collect(ch)
+	c.discrepancies.Collect(ch)
+	c.throttles.Collect(ch)
+	c.quarantine.Collect(ch)
+}
+
+// Middleware returns a middleware recording every operation of the wrapped
+// driver, labeled by the driver name and operation
+func (c *MetricsCollector) Middleware() Middleware {
+	return func(ops Ops) Ops {
+		provider := ops.Name()
+		return InterceptorMiddleware(func(op string, args []interface{}, invoke Invoker) error {
+			start := time.Now()
+			err := invoke()
+			c.latency.WithLabelValues(provider, op).Observe(time.Since(start).Seconds())
+			if err != nil {
+				c.errors.WithLabelValues(provider, op).Inc()
+			}
+			return err
+		})(ops)
+	}
+}
+
+// NewMetricsOps returns Ops recording their metrics in the given collector
+func NewMetricsOps(ops Ops, c *MetricsCollector) Ops {
+	return c.Middleware()(ops)
+}
+
+var (
+	discrepancyObserversLock sync.Mutex
+	discrepancyObservers     []DiscrepancyObserver
+)
+
+// DiscrepancyObserver is notified when a provider finds that views of the
+// cloud state disagree, e.g. kind "device-mapping/instance" when the instance
+// block device mappings are stale
+type DiscrepancyObserver func(provider, kind string)
+
+// AddDiscrepancyObserver registers an observer of all discrepancies
+func AddDiscrepancyObserver(o DiscrepancyObserver) {
+	discrepancyObserversLock.Lock()
+	defer discrepancyObserversLock.Unlock()
+	discrepancyObservers = append(discrepancyObservers, o)
+}
+
+// ReportDiscrepancy notifies the observers of a discrepancy of the given
+// kind found by the given provider
+func ReportDiscrepancy(provider, kind string) {
+	discrepancyObserversLock.Lock()
+	observers := discrepancyObservers
+	discrepancyObserversLock.Unlock()
+	for _, o := range observers {
+		o(provider, kind)
+	}
+}
+
+// retryKind returns the name of a wait loop without the IDs it contains, to
+// bound the cardinality of the retry metric, e.g. "wait for volume" for
+// "wait for volume vol-1 to be available"
+func retryKind(op string) string {
+	words := strings.Fields(op)
+	for i, w := range words {
+		if strings.ContainsAny(w, "0123456789-_/:.") {
+			return strings.Join(words[:i], " ")
+		}
+	}
+	return op
+}
@@ -0,0 +1,256 @@
+package storageops
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// metricsLogSlotSize is the size of a record of the metrics log file,
+	// longer records have their error message truncated
+	metricsLogSlotSize = 512
+	// DefaultMetricsLogSlots is the default number of records kept in a
+	// metrics log file, about 10MiB
+	DefaultMetricsLogSlots = 20000
+)
+
+// MetricsRecordKind is the kind of a record of the metrics log
+type MetricsRecordKind string
+
+const (
+	// MetricsRecordOperation is the record of a storage operation
+	MetricsRecordOperation MetricsRecordKind = "operation"
+	// MetricsRecordEvent is the record of a drive lifecycle event
+	MetricsRecordEvent MetricsRecordKind = "event"
+)
+
+// MetricsRecord is a single operation or event in the metrics log
+type MetricsRecord struct {
+	// Time is when the operation completed
+	Time time.Time `json:"time"`
+	// Kind of the record
+	Kind MetricsRecordKind `json:"kind"`
+	// Provider is the name of the storage operations driver
+	Provider string `json:"provider"`
+	// Operation is the name of the operation or the type of the event
+	Operation string `json:"op"`
+	// VolumeID is the volume or snapshot of an event
+	VolumeID string `json:"volume,omitempty"`
+	// Duration is how long the operation took
+	Duration time.Duration `json:"duration"`
+	// Err is the error message if the operation failed
+	Err string `json:"err,omitempty"`
+}
+
+// metricsLogHeader is the first slot of the metrics log file
+type metricsLogHeader struct {
+	Slots int `json:"slots"`
+	Next  int `json:"next"`
+}
+
+// MetricsLog persists operation metrics and lifecycle events to a bounded
+// local file, for environments without a metrics server. The file is a ring
+// of fixed size records, once full the oldest records are overwritten.
+type MetricsLog struct {
+	sync.Mutex
+	file      *os.File
+	header    metricsLogHeader
+	retention time.Duration
+}
+
+// OpenMetricsLog opens or creates the metrics log file at path with room for
+// the given number of records. An existing file keeps its size. Records
+// older than retention are dropped when read, zero keeps all records. Use
+// Middleware to record operations and pass the log to NewPublishingOps to
+// record events.
+func OpenMetricsLog(path string, slots int, retention time.Duration) (*MetricsLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l := &MetricsLog{file: file, retention: retention}
+	if err := l.readHeader(); err == nil {
+		return l, nil
+	} else if !os.IsNotExist(err) {
+		file.Close()
+		return nil, fmt.Errorf("invalid metrics log %s: %v", path, err)
+	}
+
+	if slots <= 0 {
+		slots = DefaultMetricsLogSlots
+	}
+	l.header = metricsLogHeader{Slots: slots}
+	if err := file.Truncate(int64(slots+1) * metricsLogSlotSize); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := l.writeSlot(0, l.header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// readHeader reads the header of the file, it returns an os.IsNotExist
+// error if the file is empty
+func (l *MetricsLog) readHeader() error {
+	slot, err := l.readSlot(0)
+	if err != nil {
+		return err
+	}
+	if len(slot) == 0 {
+		return os.ErrNotExist
+	}
+	if err := json.Unmarshal(slot, &l.header); err != nil {
+		return err
+	}
+	if l.header.Slots <= 0 || l.header.Next < 0 || l.header.Next >= l.header.Slots {
+		return fmt.Errorf("invalid header %s", slot)
+	}
+	return nil
+}
+
+func (l *MetricsLog) readSlot(i int) ([]byte, error) {
+	buf := make([]byte, metricsLogSlotSize)
+	n, err := l.file.ReadAt(buf, int64(i)*metricsLogSlotSize)
+	if err == io.EOF && n == 0 {
+		return nil, nil
+	} else if err != nil && err != io.EOF {
+		return nil, err
+	} else if n < len(buf) {
+		return nil, fmt.Errorf("short slot %d", i)
+	}
+	return bytes.TrimRight(buf, " \n\x00"), nil
+}
+
+func (l *MetricsLog) writeSlot(i int, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(data) >= metricsLogSlotSize {
+		return fmt.Errorf("record of %d bytes does not fit in a slot", len(data))
+	}
+	buf := bytes.Repeat([]byte{' '}, metricsLogSlotSize)
+	copy(buf, data)
+	buf[len(buf)-1] = '\n'
+	_, err = l.file.WriteAt(buf, int64(i)*metricsLogSlotSize)
+	return err
+}
+
+// Record appends a record to the log, overwriting the oldest record if the
+// log is full
+func (l *MetricsLog) Record(r *MetricsRecord) error {
+	l.Lock()
+	defer l.Unlock()
+
+	if err := l.writeSlot(l.header.Next+1, r); err != nil {
+		// Only free text can be long, make it fit
+		truncated := *r
+		truncated.Err = ""
+		data, _ := json.Marshal(&truncated)
+		if room := metricsLogSlotSize - len(data) - 32; room > 0 && room < len(r.Err) {
+			truncated.Err = r.Err[:room] + "..."
+		}
+		if err := l.writeSlot(l.header.Next+1, &truncated); err != nil {
+			return err
+		}
+	}
+	l.header.Next = (l.header.Next + 1) % l.header.Slots
+	return l.writeSlot(0, l.header)
+}
+
+// Records returns the records at or after since within the retention, oldest
+// first
+func (l *MetricsLog) Records(since time.Time) ([]*MetricsRecord, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.retention > 0 {
+		if oldest := time.Now().Add(-l.retention); since.Before(oldest) {
+			since = oldest
+		}
+	}
+	var records []*MetricsRecord
+	for i := 1; i <= l.header.Slots; i++ {
+		slot, err := l.readSlot(i)
+		if err != nil {
+			return nil, err
+		}
+		if len(slot) == 0 {
+			continue
+		}
+		r := &MetricsRecord{}
+		if err := json.Unmarshal(slot, r); err != nil {
+			return nil, fmt.Errorf("invalid record in slot %d: %v", i, err)
+		}
+		if !r.Time.Before(since) {
+			records = append(records, r)
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+	return records, nil
+}
+
+// Close syncs and closes the log file
+func (l *MetricsLog) Close() error {
+	l.Lock()
+	defer l.Unlock()
+	if err := l.file.Sync(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
+
+// Middleware returns a middleware recording every operation of the wrapped
+// driver. Failures to write the log never fail the operation.
+func (l *MetricsLog) Middleware() Middleware {
+	return func(ops Ops) Ops {
+		provider := ops.Name()
+		return InterceptorMiddleware(func(op string, args []interface{}, invoke Invoker) error {
+			start := time.Now()
+			err := invoke()
+			r := &MetricsRecord{
+				Time:      time.Now(),
+				Kind:      MetricsRecordOperation,
+				Provider:  provider,
+				Operation: op,
+				Duration:  time.Since(start),
+			}
+			if err != nil {
+				r.Err = err.Error()
+			}
+			if logErr := l.Record(r); logErr != nil {
+				logrus.Warnf("failed to record %s in metrics log: %v", op, logErr)
+			}
+			return err
+		})(ops)
+	}
+}
+
+// PublishEvent implements EventPublisher by recording the event
+func (l *MetricsLog) PublishEvent(event *Event) error {
+	return l.Record(&MetricsRecord{
+		Time:      event.Time,
+		Kind:      MetricsRecordEvent,
+		Provider:  event.Provider,
+		Operation: string(event.Type),
+		VolumeID:  event.VolumeID,
+		Duration:  event.Duration,
+		Err:       event.Err,
+	})
+}
+
+// PublishInventory implements EventPublisher, inventories are not recorded
+func (l *MetricsLog) PublishInventory(sets map[string][]*Volume) error {
+	return nil
+}
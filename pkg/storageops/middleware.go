@@ -0,0 +1,287 @@
+package storageops
+
+import "time"
+
+// Middleware wraps storage operations to add a cross-cutting concern, e.g.
+// metrics, tracing, auditing, rate limiting or auth
+type Middleware func(Ops) Ops
+
+// NewChainedOps wraps base in the given middlewares. The first middleware is
+// the outermost, i.e. it sees a call first and its result last.
+func NewChainedOps(base Ops, middlewares ...Middleware) Ops {
+	ops := base
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		ops = middlewares[i](ops)
+	}
+	return ops
+}
+
+// Invoker performs the intercepted call
+type Invoker func() error
+
+// Interceptor is called for every operation with its name, e.g. "Attach", and
+// arguments. It must call invoke exactly once to perform the operation, unless
+// it rejects the call by returning an error, and returns the resulting error.
+type Interceptor func(op string, args []interface{}, invoke Invoker) error
+
+// InterceptorMiddleware returns a middleware running the given interceptors
+// around every operation except Name and InstanceID. Interceptors are run in
+// order, the first being the outermost.
+func InterceptorMiddleware(interceptors ...Interceptor) Middleware {
+	return func(ops Ops) Ops {
+		return &interceptingOps{
+			Ops:          ops,
+			interceptors: interceptors,
+		}
+	}
+}
+
+// PublishingMiddleware is NewPublishingOps as a middleware
+func PublishingMiddleware(publisher EventPublisher) Middleware {
+	return func(ops Ops) Ops { return NewPublishingOps(ops, publisher) }
+}
+
+// BudgetMiddleware is NewBudgetedOps as a middleware
+func BudgetMiddleware(budget *Budget) Middleware {
+	return func(ops Ops) Ops { return NewBudgetedOps(ops, budget) }
+}
+
+// TenantMiddleware is NewTenantOps as a middleware
+func TenantMiddleware(mapper IDMapper, tenant string) Middleware {
+	return func(ops Ops) Ops { return NewTenantOps(ops, mapper, tenant) }
+}
+
+// FsckMiddleware is NewFsckOps as a middleware
+func FsckMiddleware(policy FsckPolicy, timeout time.Duration) Middleware {
+	return func(ops Ops) Ops { return NewFsckOps(ops, policy, timeout) }
+}
+
+// SmokeTestMiddleware is NewSmokeTestOps as a middleware
+func SmokeTestMiddleware(mode SmokeTestMode, timeout time.Duration) Middleware {
+	return func(ops Ops) Ops { return NewSmokeTestOps(ops, mode, timeout) }
+}
+
+// MaintenanceSnapshotMiddleware is NewMaintenanceSnapshotOps as a middleware
+func MaintenanceSnapshotMiddleware(retention map[MaintenanceOp]time.Duration) Middleware {
+	return func(ops Ops) Ops { return NewMaintenanceSnapshotOps(ops, retention) }
+}
+
+// VerifyingMiddleware is NewVerifyingOps as a middleware
+func VerifyingMiddleware() Middleware {
+	return NewVerifyingOps
+}
+
+type interceptingOps struct {
+	Ops
+	interceptors []Interceptor
+}
+
+func (o *interceptingOps) intercept(op string, args []interface{}, invoke Invoker) error {
+	for i := len(o.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := o.interceptors[i], invoke
+		invoke = func() error { return interceptor(op, args, next) }
+	}
+	return invoke()
+}
+
+func (o *interceptingOps) GetZone() (zone string, err error) {
+	err = o.intercept("GetZone", nil, func() error {
+		zone, err = o.Ops.GetZone()
+		return err
+	})
+	return zone, err
+}
+
+func (o *interceptingOps) GetRegion() (region string, err error) {
+	err = o.intercept("GetRegion", nil, func() error {
+		region, err = o.Ops.GetRegion()
+		return err
+	})
+	return region, err
+}
+
+func (o *interceptingOps) ListZones() (zones []string, err error) {
+	err = o.intercept("ListZones", nil, func() error {
+		zones, err = o.Ops.ListZones()
+		return err
+	})
+	return zones, err
+}
+
+func (o *interceptingOps) Create(template interface{}, labels map[string]string) (out *Volume, err error) {
+	err = o.intercept("Create", []interface{}{template, labels}, func() error {
+		out, err = o.Ops.Create(template, labels)
+		return err
+	})
+	return out, err
+}
+
+func (o *interceptingOps) GetDeviceID(template interface{}) (id string, err error) {
+	err = o.intercept("GetDeviceID", []interface{}{template}, func() error {
+		id, err = o.Ops.GetDeviceID(template)
+		return err
+	})
+	return id, err
+}
+
+func (o *interceptingOps) Expand(volumeID string, newSizeGiB uint64) (size uint64, err error) {
+	err = o.intercept("Expand", []interface{}{volumeID, newSizeGiB}, func() error {
+		size, err = o.Ops.Expand(volumeID, newSizeGiB)
+		return err
+	})
+	return size, err
+}
+
+func (o *interceptingOps) Modify(volumeID string, spec VolumeSpecUpdate) error {
+	return o.intercept("Modify", []interface{}{volumeID, spec}, func() error {
+		return o.Ops.Modify(volumeID, spec)
+	})
+}
+
+func (o *interceptingOps) Attach(volumeID string, options map[string]string) (path string, err error) {
+	err = o.intercept("Attach", []interface{}{volumeID, options}, func() error {
+		path, err = o.Ops.Attach(volumeID, options)
+		return err
+	})
+	return path, err
+}
+
+func (o *interceptingOps) Detach(volumeID string) error {
+	return o.intercept("Detach", []interface{}{volumeID}, func() error {
+		return o.Ops.Detach(volumeID)
+	})
+}
+
+func (o *interceptingOps) DetachFrom(volumeID, instanceID string) error {
+	return o.intercept("DetachFrom", []interface{}{volumeID, instanceID}, func() error {
+		return o.Ops.DetachFrom(volumeID, instanceID)
+	})
+}
+
+func (o *interceptingOps) Delete(volumeID string) error {
+	return o.intercept("Delete", []interface{}{volumeID}, func() error {
+		return o.Ops.Delete(volumeID)
+	})
+}
+
+func (o *interceptingOps) DeleteFrom(volumeID, instanceID string) error {
+	return o.intercept("DeleteFrom", []interface{}{volumeID, instanceID}, func() error {
+		return o.Ops.DeleteFrom(volumeID, instanceID)
+	})
+}
+
+func (o *interceptingOps) Describe() (out interface{}, err error) {
+	err = o.intercept("Describe", nil, func() error {
+		out, err = o.Ops.Describe()
+		return err
+	})
+	return out, err
+}
+
+func (o *interceptingOps) FreeDevices(
+	blockDeviceMappings []interface{},
+	rootDeviceName string,
+) (devices []string, err error) {
+	err = o.intercept("FreeDevices", []interface{}{blockDeviceMappings, rootDeviceName}, func() error {
+		devices, err = o.Ops.FreeDevices(blockDeviceMappings, rootDeviceName)
+		return err
+	})
+	return devices, err
+}
+
+func (o *interceptingOps) Inspect(volumeIds []*string) (out []*Volume, err error) {
+	err = o.intercept("Inspect", []interface{}{volumeIds}, func() error {
+		out, err = o.Ops.Inspect(volumeIds)
+		return err
+	})
+	return out, err
+}
+
+func (o *interceptingOps) DeviceMappings() (mappings map[string]string, err error) {
+	err = o.intercept("DeviceMappings", nil, func() error {
+		mappings, err = o.Ops.DeviceMappings()
+		return err
+	})
+	return mappings, err
+}
+
+func (o *interceptingOps) Enumerate(
+	volumeIds []*string,
+	labels map[string]string,
+	setIdentifier string,
+) (sets map[string][]*Volume, err error) {
+	err = o.intercept("Enumerate", []interface{}{volumeIds, labels, setIdentifier}, func() error {
+		sets, err = o.Ops.Enumerate(volumeIds, labels, setIdentifier)
+		return err
+	})
+	return sets, err
+}
+
+func (o *interceptingOps) DevicePath(volumeID string) (path string, err error) {
+	err = o.intercept("DevicePath", []interface{}{volumeID}, func() error {
+		path, err = o.Ops.DevicePath(volumeID)
+		return err
+	})
+	return path, err
+}
+
+func (o *interceptingOps) Snapshot(volumeID string, readonly bool) (out *Snapshot, err error) {
+	err = o.intercept("Snapshot", []interface{}{volumeID, readonly}, func() error {
+		out, err = o.Ops.Snapshot(volumeID, readonly)
+		return err
+	})
+	return out, err
+}
+
+func (o *interceptingOps) SnapshotDelete(snapID string) error {
+	return o.intercept("SnapshotDelete", []interface{}{snapID}, func() error {
+		return o.Ops.SnapshotDelete(snapID)
+	})
+}
+
+func (o *interceptingOps) SnapshotEnumerate(filter *SnapshotFilter) (out []*Snapshot, err error) {
+	err = o.intercept("SnapshotEnumerate", []interface{}{filter}, func() error {
+		out, err = o.Ops.SnapshotEnumerate(filter)
+		return err
+	})
+	return out, err
+}
+
+func (o *interceptingOps) SnapshotRestore(
+	snapID, zone string,
+	labels map[string]string,
+) (out *Volume, err error) {
+	err = o.intercept("SnapshotRestore", []interface{}{snapID, zone, labels}, func() error {
+		out, err = o.Ops.SnapshotRestore(snapID, zone, labels)
+		return err
+	})
+	return out, err
+}
+
+func (o *interceptingOps) SnapshotStatus(snapID string) (status *SnapshotStatus, err error) {
+	err = o.intercept("SnapshotStatus", []interface{}{snapID}, func() error {
+		status, err = o.Ops.SnapshotStatus(snapID)
+		return err
+	})
+	return status, err
+}
+
+func (o *interceptingOps) ApplyTags(volumeID string, labels map[string]string) error {
+	return o.intercept("ApplyTags", []interface{}{volumeID, labels}, func() error {
+		return o.Ops.ApplyTags(volumeID, labels)
+	})
+}
+
+func (o *interceptingOps) RemoveTags(volumeID string, labels map[string]string) error {
+	return o.intercept("RemoveTags", []interface{}{volumeID, labels}, func() error {
+		return o.Ops.RemoveTags(volumeID, labels)
+	})
+}
+
+func (o *interceptingOps) Tags(volumeID string) (labels map[string]string, err error) {
+	err = o.intercept("Tags", []interface{}{volumeID}, func() error {
+		labels, err = o.Ops.Tags(volumeID)
+		return err
+	})
+	return labels, err
+}
@@ -0,0 +1,138 @@
+package storageops
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/portworx/kvdb"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// schemaKeyPrefix is the kvdb prefix of the schema versions of the
+	// persisted state
+	schemaKeyPrefix = "storageops/schema/"
+	// StateSchema is the name of the schema of the state persisted by this
+	// package, e.g. aliases and desired specs
+	StateSchema = "storageops"
+)
+
+// Migration is a single step that upgrades persisted state from Version-1
+// to Version
+type Migration struct {
+	// Version the state is at after the migration
+	Version int
+	// Description of the change
+	Description string
+	// Migrate upgrades the state. It must be safe to rerun if it fails
+	// midway, as the version is only updated once it succeeds.
+	Migrate func(kv kvdb.Kvdb) error
+}
+
+// StateMigrations are the migrations of the state persisted by this package
+var StateMigrations = []Migration{
+	{
+		Version:     1,
+		Description: "initial schema with aliases and desired volume specs",
+		Migrate:     func(kv kvdb.Kvdb) error { return nil },
+	},
+}
+
+// Migrator runs the migrations of a schema on startup
+type Migrator struct {
+	kv         kvdb.Kvdb
+	schema     string
+	migrations []Migration
+}
+
+// NewMigrator creates a migrator for the named schema. The migrations must
+// have consecutive versions starting at 1.
+func NewMigrator(kv kvdb.Kvdb, schema string, migrations []Migration) (*Migrator, error) {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i, m := range sorted {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("schema %s: expected migration version %d got %d",
+				schema, i+1, m.Version)
+		}
+	}
+	return &Migrator{
+		kv:         kv,
+		schema:     schema,
+		migrations: sorted,
+	}, nil
+}
+
+func (m *Migrator) versionKey() string {
+	return schemaKeyPrefix + m.schema + "/version"
+}
+
+// Version returns the current version of the persisted state. State that was
+// never migrated is at version 0.
+func (m *Migrator) Version() (int, error) {
+	kvp, err := m.kv.Get(m.versionKey())
+	if err == kvdb.ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	version, err := strconv.Atoi(string(kvp.Value))
+	if err != nil {
+		return 0, fmt.Errorf("schema %s: invalid version %q: %v", m.schema, kvp.Value, err)
+	}
+	return version, nil
+}
+
+// Latest returns the version the state is at after all migrations
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// Migrate runs all pending migrations in order while holding a kvdb lock, so
+// only one node migrates at a time. It fails if the state was written by a
+// newer version than this binary knows about.
+func (m *Migrator) Migrate() error {
+	lock, err := m.kv.Lock(schemaKeyPrefix + m.schema + "/lock")
+	if err != nil {
+		return fmt.Errorf("schema %s: failed to lock: %v", m.schema, err)
+	}
+	defer func() {
+		if err := m.kv.Unlock(lock); err != nil {
+			logrus.Warnf("schema %s: failed to unlock: %v", m.schema, err)
+		}
+	}()
+
+	version, err := m.Version()
+	if err != nil {
+		return err
+	}
+	if version > m.Latest() {
+		return fmt.Errorf("schema %s: state is at version %d, newer than the supported %d",
+			m.schema, version, m.Latest())
+	}
+
+	for _, migration := range m.migrations[version:] {
+		logrus.Infof("schema %s: migrating to version %d: %s",
+			m.schema, migration.Version, migration.Description)
+		if err := migration.Migrate(m.kv); err != nil {
+			return fmt.Errorf("schema %s: migration to version %d failed: %v",
+				m.schema, migration.Version, err)
+		}
+		if _, err := m.kv.Put(m.versionKey(), strconv.Itoa(migration.Version), 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateState runs the pending migrations of the state persisted by this
+// package
+func MigrateState(kv kvdb.Kvdb) error {
+	m, err := NewMigrator(kv, StateSchema, StateMigrations)
+	if err != nil {
+		return err
+	}
+	return m.Migrate()
+}
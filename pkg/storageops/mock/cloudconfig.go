@@ -0,0 +1,35 @@
+package mock
+
+import (
+	"fmt"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/libopenstorage/openstorage/pkg/storageops/config"
+)
+
+func init() {
+	config.Register(Name, newFromCloudConfig)
+}
+
+// newFromCloudConfig creates a mock driver with an empty store for the
+// instance and zone of the given cloud config, or for the nodes of the
+// topology spec file of its topology param, see LoadTopology
+func newFromCloudConfig(c *config.Config) (storageops.Ops, error) {
+	path, ok := c.Params["topology"]
+	if !ok {
+		return New(c.Instance, c.Zone), nil
+	}
+	t, err := LoadTopology(path)
+	if err != nil {
+		return nil, err
+	}
+	cluster, err := NewCluster(t)
+	if err != nil {
+		return nil, err
+	}
+	node := cluster.Node(c.Instance)
+	if node == nil {
+		return nil, fmt.Errorf("instance %q is not a node of topology %s", c.Instance, path)
+	}
+	return node, nil
+}
@@ -0,0 +1,100 @@
+package mock
+
+import (
+	"fmt"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+func init() {
+	storageops.RegisterConverter(Name, &converter{})
+	storageops.RegisterSizeRule(Name, &storageops.SizeRule{Granularity: storageops.GiB})
+}
+
+type converter struct{}
+
+func (c *converter) ToVolume(raw interface{}) (*storageops.Volume, error) {
+	v, ok := raw.(*Volume)
+	if !ok {
+		return nil, fmt.Errorf("invalid mock volume %T", raw)
+	}
+	return toVolume(v), nil
+}
+
+func toVolume(v *Volume) *storageops.Volume {
+	vol := &storageops.Volume{
+		ID:              v.ID,
+		SizeGiB:         v.SizeGiB,
+		Type:            v.Type,
+		Iops:            v.Iops,
+		ThroughputMiBps: v.ThroughputMiBps,
+		Zone:            v.Zone,
+		State:           v.State,
+		Labels:          v.Labels,
+		Raw:             v,
+	}
+	if len(v.AttachedTo) > 0 {
+		vol.AttachedTo = []string{v.AttachedTo}
+		vol.Attachments = []storageops.Attachment{{
+			InstanceID: v.AttachedTo,
+			Device:     v.DevicePath,
+			State:      storageops.AttachmentStateAttached,
+		}}
+	}
+	return vol
+}
+
+// FromSpec returns the *Volume template of the given spec
+func (c *converter) FromSpec(spec *storageops.VolumeSpec) (interface{}, error) {
+	if err := spec.Unsupported(Name, storageops.SpecFieldEncrypted,
+		storageops.SpecFieldKMSKey); err != nil {
+		return nil, err
+	}
+	v := &Volume{}
+	if spec.Native != nil {
+		native, ok := spec.Native.(*Volume)
+		if !ok {
+			return nil, storageops.NativeTemplateError(Name, spec.Native)
+		}
+		v = native.copy()
+	}
+	if spec.SizeGiB > 0 {
+		v.SizeGiB = spec.SizeGiB
+	}
+	if len(spec.Type) > 0 {
+		v.Type = spec.Type
+	}
+	if spec.Iops > 0 {
+		v.Iops = spec.Iops
+	}
+	if spec.ThroughputMiBps > 0 {
+		v.ThroughputMiBps = spec.ThroughputMiBps
+	}
+	if len(spec.Zone) > 0 {
+		v.Zone = spec.Zone
+	}
+	if len(spec.SnapshotID) > 0 {
+		v.SnapshotID = spec.SnapshotID
+	}
+	return v, nil
+}
+
+func (c *converter) ToSnapshot(raw interface{}) (*storageops.Snapshot, error) {
+	s, ok := raw.(*Snapshot)
+	if !ok {
+		return nil, fmt.Errorf("invalid mock snapshot %T", raw)
+	}
+	return toSnapshot(s), nil
+}
+
+func toSnapshot(s *Snapshot) *storageops.Snapshot {
+	return &storageops.Snapshot{
+		ID:       s.ID,
+		VolumeID: s.VolumeID,
+		SizeGiB:  s.SizeGiB,
+		State:    s.State,
+		Created:  s.Created,
+		Labels:   s.Labels,
+		Raw:      s,
+	}
+}
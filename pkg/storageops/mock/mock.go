@@ -0,0 +1,1025 @@
+// Package mock is an in-memory storage operations driver, so consumers of
+// storageops can be tested without cloud credentials. Latencies and errors
+// can be injected per operation, and NewCluster simulates clusters of
+// thousands of nodes from a topology spec file for scale tests.
+package mock
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+const (
+	// Name is the name of the mock driver
+	Name = "mock"
+	// devicePrefix is the prefix of the device paths of attached volumes
+	devicePrefix = "/dev/mock"
+	// maxDevices is the number of volumes that can be attached to an instance
+	maxDevices = 26
+)
+
+const (
+	// VolumeStateAvailable is the state of a volume that is not attached
+	VolumeStateAvailable = "available"
+	// VolumeStateInUse is the state of an attached volume
+	VolumeStateInUse = "in-use"
+	// SnapshotStateCompleted is the state of all mock snapshots
+	SnapshotStateCompleted = "completed"
+)
+
+// Volume is a mock volume. It is both the template passed to Create and the
+// volume returned by the driver; returned volumes are copies.
+type Volume struct {
+	// ID of the volume, assigned by Create
+	ID string
+	// SizeGiB is the size of the volume
+	SizeGiB uint64
+	// Type is the volume type, free form
+	Type string
+	// Iops are the provisioned IOPS, zero if not provisioned
+	Iops int64
+	// ThroughputMiBps is the provisioned throughput, zero if not provisioned
+	ThroughputMiBps int64
+	// Zone the volume is in, defaults to the zone of the driver
+	Zone string
+	// State is VolumeStateAvailable or VolumeStateInUse
+	State string
+	// SnapshotID is the snapshot the volume was created from, if any
+	SnapshotID string
+	// AttachedTo is the instance the volume is attached to
+	AttachedTo string
+	// DevicePath is where the volume is attached on AttachedTo
+	DevicePath string
+	// Labels on the volume
+	Labels map[string]string
+	// Health of the volume, see VolumeStatus. Empty is healthy.
+	Health storageops.VolumeHealth
+	// Created is when the volume was created
+	Created time.Time
+}
+
+func (v *Volume) copy() *Volume {
+	c := *v
+	c.Labels = make(map[string]string, len(v.Labels))
+	for k, val := range v.Labels {
+		c.Labels[k] = val
+	}
+	return &c
+}
+
+// Snapshot is a mock snapshot
+type Snapshot struct {
+	// ID of the snapshot
+	ID string
+	// VolumeID of the volume the snapshot was taken from
+	VolumeID string
+	// SizeGiB is the size of the volume when the snapshot was taken
+	SizeGiB uint64
+	// State is always SnapshotStateCompleted
+	State string
+	// Readonly is as passed to Snapshot
+	Readonly bool
+	// Labels are the labels of the volume when the snapshot was taken
+	Labels map[string]string
+	// Created is when the snapshot was taken
+	Created time.Time
+}
+
+// Instance is the description of a mock instance returned by Describe
+type Instance struct {
+	// ID of the instance
+	ID string
+	// Zone of the instance
+	Zone string
+	// DeviceMappings maps device paths to the attached volumes
+	DeviceMappings map[string]string
+}
+
+// store holds the state shared by the drivers of all mock instances
+type store struct {
+	sync.Mutex
+	nextID    int
+	volumes   map[string]*Volume
+	snapshots map[string]*Snapshot
+	latencies map[string]time.Duration
+	errors    map[string][]error
+	calls     map[string]int
+	// attached indexes the device mappings of every instance, so clusters
+	// of thousands of nodes do not scan all volumes per call
+	attached map[string]map[string]string
+	// sorted are the sorted volume IDs, nil once volumes changed
+	sorted []string
+	// zones of the cluster, see ListZones
+	zones    []string
+	jitter   time.Duration
+	rand     *rand.Rand
+	pageSize int
+}
+
+func newStore() *store {
+	return &store{
+		volumes:   make(map[string]*Volume),
+		snapshots: make(map[string]*Snapshot),
+		latencies: make(map[string]time.Duration),
+		errors:    make(map[string][]error),
+		calls:     make(map[string]int),
+		attached:  make(map[string]map[string]string),
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Ops is the mock storage operations driver of a single instance
+type Ops struct {
+	instance string
+	zone     string
+	store    *store
+}
+
+// New returns a mock driver for the given instance and zone with an empty
+// store
+func New(instance, zone string) *Ops {
+	return &Ops{
+		instance: instance,
+		zone:     zone,
+		store:    newStore(),
+	}
+}
+
+// ForInstance returns a driver for another instance sharing the same store,
+// latencies and injected errors, to test multi-node behaviour
+func (m *Ops) ForInstance(instance, zone string) *Ops {
+	return &Ops{
+		instance: instance,
+		zone:     zone,
+		store:    m.store,
+	}
+}
+
+// SetLatency makes every call of the named operation, i.e. the name of the
+// Ops method such as "Attach", take at least d. An empty op sets the latency
+// of all operations that do not have their own.
+func (m *Ops) SetLatency(op string, d time.Duration) {
+	m.store.Lock()
+	defer m.store.Unlock()
+	m.store.latencies[op] = d
+}
+
+// SetJitter adds a random delay of up to d to the latency of every call, so
+// concurrent callers do not all complete in lock step
+func (m *Ops) SetJitter(d time.Duration) {
+	m.store.Lock()
+	defer m.store.Unlock()
+	m.store.jitter = d
+}
+
+// SetPageSize makes list calls page like cloud APIs do: Enumerate and
+// SnapshotEnumerate take their latency once per page of n items, and
+// EnumerateEach makes one Enumerate call per page. Zero disables paging.
+func (m *Ops) SetPageSize(n int) {
+	m.store.Lock()
+	defer m.store.Unlock()
+	m.store.pageSize = n
+}
+
+// InjectError makes the next calls of the named operation return the given
+// errors, one per call, before the operation is performed
+func (m *Ops) InjectError(op string, errs ...error) {
+	m.store.Lock()
+	defer m.store.Unlock()
+	m.store.errors[op] = append(m.store.errors[op], errs...)
+}
+
+// ClearErrors drops all injected errors that were not returned yet
+func (m *Ops) ClearErrors() {
+	m.store.Lock()
+	defer m.store.Unlock()
+	m.store.errors = make(map[string][]error)
+}
+
+// Calls returns the number of calls of the named operation across all
+// instances
+func (m *Ops) Calls(op string) int {
+	m.store.Lock()
+	defer m.store.Unlock()
+	return m.store.calls[op]
+}
+
+// call records a call of the operation, applies its latency and returns the
+// next injected error. On success the store is returned locked.
+func (m *Ops) call(op string) error {
+	m.store.Lock()
+	m.store.calls[op]++
+	latency := m.latency(op)
+	var err error
+	if errs := m.store.errors[op]; len(errs) > 0 {
+		err = errs[0]
+		m.store.errors[op] = errs[1:]
+	}
+	m.store.Unlock()
+
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	if err != nil {
+		return err
+	}
+	m.store.Lock()
+	return nil
+}
+
+// latency returns the latency of a call of the operation. The store must be
+// locked.
+func (m *Ops) latency(op string) time.Duration {
+	latency, ok := m.store.latencies[op]
+	if !ok {
+		latency = m.store.latencies[""]
+	}
+	if m.store.jitter > 0 {
+		latency += time.Duration(m.store.rand.Int63n(int64(m.store.jitter)))
+	}
+	return latency
+}
+
+// pageLatency applies the latency of the operation for every page but the
+// first, which call applied, of a list of n items. The store must not be
+// locked.
+func (m *Ops) pageLatency(op string, n int) {
+	m.store.Lock()
+	pageSize := m.store.pageSize
+	var latency time.Duration
+	if pageSize > 0 && n > pageSize {
+		latency = m.latency(op) * time.Duration((n-1)/pageSize)
+	}
+	m.store.Unlock()
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+}
+
+// sortedIDs returns the sorted IDs of all volumes. The store must be locked.
+func (m *Ops) sortedIDs() []string {
+	if m.store.sorted == nil {
+		m.store.sorted = make([]string, 0, len(m.store.volumes))
+		for id := range m.store.volumes {
+			m.store.sorted = append(m.store.sorted, id)
+		}
+		sort.Strings(m.store.sorted)
+	}
+	return m.store.sorted
+}
+
+// attach records the given volume as attached at the given device of the
+// given instance. The store must be locked.
+func (m *Ops) attach(v *Volume, instance, devicePath string) {
+	mappings, ok := m.store.attached[instance]
+	if !ok {
+		mappings = make(map[string]string)
+		m.store.attached[instance] = mappings
+	}
+	mappings[devicePath] = v.ID
+	v.AttachedTo = instance
+	v.DevicePath = devicePath
+	v.State = VolumeStateInUse
+}
+
+func (m *Ops) newID(prefix string) string {
+	m.store.nextID++
+	return fmt.Sprintf("%s-%08d", prefix, m.store.nextID)
+}
+
+func (m *Ops) volume(volumeID string) (*Volume, error) {
+	v, ok := m.store.volumes[volumeID]
+	if !ok {
+		return nil, storageops.NewStorageError(storageops.ErrVolNotFound,
+			fmt.Sprintf("volume %s not found", volumeID), m.instance)
+	}
+	return v, nil
+}
+
+// Name returns the name of the mock driver
+func (m *Ops) Name() string { return Name }
+
+// InstanceID returns the instance of the driver
+func (m *Ops) InstanceID() string { return m.instance }
+
+// GetZone returns the zone of the instance of the driver
+func (m *Ops) GetZone() (string, error) {
+	if err := m.call("GetZone"); err != nil {
+		return "", err
+	}
+	defer m.store.Unlock()
+	return m.zone, nil
+}
+
+// GetRegion returns the region of the zone of the instance, the zone up to
+// its last dash, e.g. us-east of us-east-a
+func (m *Ops) GetRegion() (string, error) {
+	if err := m.call("GetRegion"); err != nil {
+		return "", err
+	}
+	defer m.store.Unlock()
+	return zoneRegion(m.zone), nil
+}
+
+func zoneRegion(zone string) string {
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		return zone[:i]
+	}
+	return zone
+}
+
+// ListZones returns the zones of the cluster in the region of the instance,
+// see NewCluster, or else the zone of the instance
+func (m *Ops) ListZones() ([]string, error) {
+	if err := m.call("ListZones"); err != nil {
+		return nil, err
+	}
+	defer m.store.Unlock()
+	region := zoneRegion(m.zone)
+	var zones []string
+	for _, zone := range m.store.zones {
+		if zoneRegion(zone) == region {
+			zones = append(zones, zone)
+		}
+	}
+	if len(zones) == 0 {
+		return []string{m.zone}, nil
+	}
+	return zones, nil
+}
+
+// Create creates a volume from the given *Volume template or VolumeSpec
+func (m *Ops) Create(template interface{}, labels map[string]string) (*storageops.Volume, error) {
+	template, labels, err := storageops.VolumeTemplate(Name, template, labels)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := template.(*Volume)
+	if !ok {
+		return nil, storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("invalid volume template %T", template), m.instance)
+	}
+	if t.SizeGiB == 0 {
+		return nil, storageops.NewStorageError(storageops.ErrVolInval,
+			"volume size must be specified", m.instance)
+	}
+	if err := m.call("Create"); err != nil {
+		return nil, err
+	}
+	defer m.store.Unlock()
+
+	v := t.copy()
+	v.ID = m.newID("vol")
+	v.State = VolumeStateAvailable
+	v.AttachedTo = ""
+	v.DevicePath = ""
+	v.Created = time.Now()
+	if len(v.Zone) == 0 {
+		v.Zone = m.zone
+	}
+	for k, val := range labels {
+		v.Labels[k] = val
+	}
+	m.store.volumes[v.ID] = v
+	m.store.sorted = nil
+	return toVolume(v.copy()), nil
+}
+
+// GetDeviceID returns the ID of the given *Volume or *Snapshot, of the
+// driver or of storageops
+func (m *Ops) GetDeviceID(template interface{}) (string, error) {
+	if id, ok := storageops.TypedID(template); ok {
+		return id, nil
+	}
+	switch t := template.(type) {
+	case *Volume:
+		return t.ID, nil
+	case *Snapshot:
+		return t.ID, nil
+	}
+	return "", storageops.NewStorageError(storageops.ErrVolInval,
+		fmt.Sprintf("invalid volume or snapshot %T", template), m.instance)
+}
+
+// Expand grows the given volume
+func (m *Ops) Expand(volumeID string, newSizeGiB uint64) (uint64, error) {
+	if err := m.call("Expand"); err != nil {
+		return 0, err
+	}
+	defer m.store.Unlock()
+
+	v, err := m.volume(volumeID)
+	if err != nil {
+		return 0, err
+	}
+	if newSizeGiB < v.SizeGiB {
+		return 0, storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("cannot shrink volume %s from %d to %d GiB",
+				volumeID, v.SizeGiB, newSizeGiB), m.instance)
+	}
+	v.SizeGiB = newSizeGiB
+	return newSizeGiB, nil
+}
+
+// Modify changes the type, IOPS or throughput of the given volume
+func (m *Ops) Modify(volumeID string, spec storageops.VolumeSpecUpdate) error {
+	if err := m.call("Modify"); err != nil {
+		return err
+	}
+	defer m.store.Unlock()
+
+	v, err := m.volume(volumeID)
+	if err != nil {
+		return err
+	}
+	if len(spec.Type) > 0 {
+		v.Type = spec.Type
+	}
+	if spec.Iops > 0 {
+		v.Iops = spec.Iops
+	}
+	if spec.ThroughputMiBps > 0 {
+		v.ThroughputMiBps = spec.ThroughputMiBps
+	}
+	return nil
+}
+
+// Attach attaches the given volume to the instance of the driver at the first
+// free device, or the one requested with AttachOptionDevice
+func (m *Ops) Attach(volumeID string, options map[string]string) (string, error) {
+	if err := m.call("Attach"); err != nil {
+		return "", err
+	}
+	defer m.store.Unlock()
+
+	v, err := m.volume(volumeID)
+	if err != nil {
+		return "", err
+	}
+	if v.AttachedTo == m.instance {
+		return v.DevicePath, nil
+	}
+	if len(v.AttachedTo) > 0 {
+		return "", storageops.NewStorageError(storageops.ErrVolAttachedOnRemoteNode,
+			fmt.Sprintf("volume %s is attached on %s", volumeID, v.AttachedTo),
+			v.AttachedTo)
+	}
+	if v.Zone != m.zone {
+		return "", storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("volume %s is in zone %s, instance is in %s",
+				volumeID, v.Zone, m.zone), m.instance)
+	}
+
+	free, err := m.freeDevices()
+	if err != nil {
+		return "", err
+	}
+	devicePath := free[0]
+	if requested := options[storageops.AttachOptionDevice]; len(requested) > 0 {
+		devicePath = ""
+		for _, d := range free {
+			if d == requested {
+				devicePath = d
+			}
+		}
+		if len(devicePath) == 0 {
+			return "", storageops.NewStorageError(storageops.ErrInvalidDevicePath,
+				fmt.Sprintf("requested device %s is not free", requested), m.instance)
+		}
+	}
+	m.attach(v, m.instance, devicePath)
+	return v.DevicePath, nil
+}
+
+// Detach detaches the given volume from the instance of the driver
+func (m *Ops) Detach(volumeID string) error {
+	return m.detach("Detach", volumeID, m.instance)
+}
+
+// DetachFrom detaches the given volume from the given instance
+func (m *Ops) DetachFrom(volumeID, instanceID string) error {
+	return m.detach("DetachFrom", volumeID, instanceID)
+}
+
+func (m *Ops) detach(op, volumeID, instanceID string) error {
+	if err := m.call(op); err != nil {
+		return err
+	}
+	defer m.store.Unlock()
+
+	v, err := m.volume(volumeID)
+	if err != nil {
+		return err
+	}
+	if v.AttachedTo != instanceID {
+		return storageops.NewStorageError(storageops.ErrVolDetached,
+			fmt.Sprintf("volume %s is not attached on %s", volumeID, instanceID),
+			instanceID)
+	}
+	delete(m.store.attached[instanceID], v.DevicePath)
+	v.AttachedTo = ""
+	v.DevicePath = ""
+	v.State = VolumeStateAvailable
+	return nil
+}
+
+// Delete deletes the given volume
+func (m *Ops) Delete(volumeID string) error {
+	return m.delete("Delete", volumeID)
+}
+
+// DeleteFrom deletes the given volume, which must not be attached
+func (m *Ops) DeleteFrom(volumeID, _ string) error {
+	return m.delete("DeleteFrom", volumeID)
+}
+
+func (m *Ops) delete(op, volumeID string) error {
+	if err := m.call(op); err != nil {
+		return err
+	}
+	defer m.store.Unlock()
+
+	v, err := m.volume(volumeID)
+	if err != nil {
+		return err
+	}
+	if len(v.AttachedTo) > 0 {
+		return storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("volume %s is attached on %s", volumeID, v.AttachedTo),
+			v.AttachedTo)
+	}
+	delete(m.store.volumes, volumeID)
+	m.store.sorted = nil
+	return nil
+}
+
+// Describe returns the *Instance of the driver
+func (m *Ops) Describe() (interface{}, error) {
+	if err := m.call("Describe"); err != nil {
+		return nil, err
+	}
+	defer m.store.Unlock()
+	return &Instance{
+		ID:             m.instance,
+		Zone:           m.zone,
+		DeviceMappings: m.deviceMappings(),
+	}, nil
+}
+
+// FreeDevices returns the device paths not in the given device paths
+func (m *Ops) FreeDevices(blockDeviceMappings []interface{}, rootDeviceName string) ([]string, error) {
+	used := make(map[string]bool)
+	for _, d := range blockDeviceMappings {
+		devicePath, ok := d.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid device mapping %T", d)
+		}
+		used[devicePath] = true
+	}
+	return freeDevices(used)
+}
+
+func (m *Ops) freeDevices() ([]string, error) {
+	used := make(map[string]bool)
+	for devicePath := range m.deviceMappings() {
+		used[devicePath] = true
+	}
+	return freeDevices(used)
+}
+
+func freeDevices(used map[string]bool) ([]string, error) {
+	var free []string
+	for i := 0; i < maxDevices; i++ {
+		devicePath := devicePrefix + string(rune('a'+i))
+		if !used[devicePath] {
+			free = append(free, devicePath)
+		}
+	}
+	if len(free) == 0 {
+		return nil, fmt.Errorf("no free device paths")
+	}
+	return free, nil
+}
+
+// Inspect returns the given volumes
+func (m *Ops) Inspect(volumeIds []*string) ([]*storageops.Volume, error) {
+	if err := m.call("Inspect"); err != nil {
+		return nil, err
+	}
+	defer m.store.Unlock()
+
+	vols := make([]*storageops.Volume, 0, len(volumeIds))
+	for _, id := range volumeIds {
+		v, err := m.volume(*id)
+		if err != nil {
+			return nil, err
+		}
+		vols = append(vols, toVolume(v.copy()))
+	}
+	return vols, nil
+}
+
+// DeviceMappings returns the volumes attached to the instance of the driver
+func (m *Ops) DeviceMappings() (map[string]string, error) {
+	if err := m.call("DeviceMappings"); err != nil {
+		return nil, err
+	}
+	defer m.store.Unlock()
+	return m.deviceMappings(), nil
+}
+
+func (m *Ops) deviceMappings() map[string]string {
+	mappings := make(map[string]string, len(m.store.attached[m.instance]))
+	for devicePath, volumeID := range m.store.attached[m.instance] {
+		mappings[devicePath] = volumeID
+	}
+	return mappings
+}
+
+// Enumerate returns the volumes with the given IDs and labels grouped by the
+// value of the setIdentifier label
+func (m *Ops) Enumerate(
+	volumeIds []*string,
+	labels map[string]string,
+	setIdentifier string,
+) (map[string][]*storageops.Volume, error) {
+	if err := m.call("Enumerate"); err != nil {
+		return nil, err
+	}
+	sets, n, err := m.enumerate(volumeIds, labels, setIdentifier)
+	m.store.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	m.pageLatency("Enumerate", n)
+	return sets, nil
+}
+
+// enumerate returns the sets of Enumerate and the number of volumes in them.
+// The store must be locked.
+func (m *Ops) enumerate(
+	volumeIds []*string,
+	labels map[string]string,
+	setIdentifier string,
+) (map[string][]*storageops.Volume, int, error) {
+	var vols []*Volume
+	if volumeIds != nil {
+		for _, id := range volumeIds {
+			v, err := m.volume(*id)
+			if err != nil {
+				return nil, 0, err
+			}
+			vols = append(vols, v)
+		}
+	} else {
+		for _, id := range m.sortedIDs() {
+			vols = append(vols, m.store.volumes[id])
+		}
+	}
+
+	sets := make(map[string][]*storageops.Volume)
+	n := 0
+	for _, v := range vols {
+		if !matchLabels(v.Labels, labels) {
+			continue
+		}
+		storageops.AddElementToMap(sets, toVolume(v.copy()), volumeSet(v, setIdentifier))
+		n++
+	}
+	return sets, n, nil
+}
+
+func volumeSet(v *Volume, setIdentifier string) string {
+	if value, ok := v.Labels[setIdentifier]; ok && len(setIdentifier) > 0 {
+		return value
+	}
+	return storageops.SetIdentifierNone
+}
+
+var _ storageops.StreamingEnumerator = &Ops{}
+
+// EnumerateEach calls fn with the volumes of Enumerate in the order of their
+// IDs, fetching them with one Enumerate call per page, see SetPageSize, like
+// the drivers of cloud APIs do
+func (m *Ops) EnumerateEach(
+	volumeIds []*string,
+	labels map[string]string,
+	setIdentifier string,
+	fn func(set string, vol *storageops.Volume) bool,
+) error {
+	var wanted map[string]bool
+	if volumeIds != nil {
+		wanted = make(map[string]bool, len(volumeIds))
+		for _, id := range volumeIds {
+			wanted[*id] = true
+		}
+	}
+
+	after := ""
+	for {
+		if err := m.call("Enumerate"); err != nil {
+			return err
+		}
+		limit := m.store.pageSize
+		if limit <= 0 {
+			limit = storageops.DefaultPageLimit
+		}
+		ids := m.sortedIDs()
+		var page []*Volume
+		for i := sort.SearchStrings(ids, after); i < len(ids) && len(page) < limit; i++ {
+			v := m.store.volumes[ids[i]]
+			if v.ID == after {
+				continue
+			}
+			if wanted != nil && !wanted[v.ID] || !matchLabels(v.Labels, labels) {
+				continue
+			}
+			page = append(page, v.copy())
+		}
+		m.store.Unlock()
+
+		for _, v := range page {
+			if !fn(volumeSet(v, setIdentifier), toVolume(v)) {
+				return nil
+			}
+		}
+		if len(page) < limit {
+			return nil
+		}
+		after = page[len(page)-1].ID
+	}
+}
+
+func matchLabels(have, want map[string]string) bool {
+	for k, v := range want {
+		if have[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// DevicePath returns where the given volume is attached on the instance of
+// the driver
+func (m *Ops) DevicePath(volumeID string) (string, error) {
+	if err := m.call("DevicePath"); err != nil {
+		return "", err
+	}
+	defer m.store.Unlock()
+
+	v, err := m.volume(volumeID)
+	if err != nil {
+		return "", err
+	}
+	if len(v.AttachedTo) == 0 {
+		return "", storageops.NewStorageError(storageops.ErrVolDetached,
+			"Volume is detached", volumeID)
+	}
+	if v.AttachedTo != m.instance {
+		return "", storageops.NewStorageError(storageops.ErrVolAttachedOnRemoteNode,
+			fmt.Sprintf("Volume attached on %q current instance %q", v.AttachedTo, m.instance),
+			v.AttachedTo)
+	}
+	return v.DevicePath, nil
+}
+
+var _ storageops.AttachmentOps = &Ops{}
+
+// Attached returns the instance and device path the given volume is
+// attached at, of any instance
+func (m *Ops) Attached(volumeID string) (instanceID, device, state string, err error) {
+	if err := m.call("Attached"); err != nil {
+		return "", "", "", err
+	}
+	defer m.store.Unlock()
+
+	v, err := m.volume(volumeID)
+	if err != nil {
+		return "", "", "", err
+	}
+	if len(v.AttachedTo) == 0 {
+		return "", "", "", nil
+	}
+	return v.AttachedTo, v.DevicePath, storageops.AttachmentStateAttached, nil
+}
+
+// Snapshot takes a snapshot of the given volume
+func (m *Ops) Snapshot(volumeID string, readonly bool) (*storageops.Snapshot, error) {
+	if err := m.call("Snapshot"); err != nil {
+		return nil, err
+	}
+	defer m.store.Unlock()
+
+	v, err := m.volume(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	snap := &Snapshot{
+		ID:       m.newID("snap"),
+		VolumeID: volumeID,
+		SizeGiB:  v.SizeGiB,
+		State:    SnapshotStateCompleted,
+		Readonly: readonly,
+		Labels:   v.copy().Labels,
+		Created:  time.Now(),
+	}
+	m.store.snapshots[snap.ID] = snap
+	c := *snap
+	return toSnapshot(&c), nil
+}
+
+// SnapshotDelete deletes the given snapshot
+func (m *Ops) SnapshotDelete(snapID string) error {
+	if err := m.call("SnapshotDelete"); err != nil {
+		return err
+	}
+	defer m.store.Unlock()
+
+	if _, ok := m.store.snapshots[snapID]; !ok {
+		return storageops.NewStorageError(storageops.ErrVolNotFound,
+			fmt.Sprintf("snapshot %s not found", snapID), m.instance)
+	}
+	delete(m.store.snapshots, snapID)
+	return nil
+}
+
+// SnapshotEnumerate returns the snapshots matching the given filter
+func (m *Ops) SnapshotEnumerate(filter *storageops.SnapshotFilter) ([]*storageops.Snapshot, error) {
+	if err := m.call("SnapshotEnumerate"); err != nil {
+		return nil, err
+	}
+	out := m.snapshotEnumerate(filter)
+	m.store.Unlock()
+	m.pageLatency("SnapshotEnumerate", len(out))
+	return out, nil
+}
+
+// snapshotEnumerate returns the snapshots of SnapshotEnumerate. The store
+// must be locked.
+func (m *Ops) snapshotEnumerate(filter *storageops.SnapshotFilter) []*storageops.Snapshot {
+	var snaps []*Snapshot
+	for _, snap := range m.store.snapshots {
+		if !filter.Match(snap.VolumeID, snap.Created, snap.State, snap.Labels) {
+			continue
+		}
+		snaps = append(snaps, snap)
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].ID < snaps[j].ID })
+
+	out := make([]*storageops.Snapshot, len(snaps))
+	for i, snap := range snaps {
+		c := *snap
+		out[i] = toSnapshot(&c)
+	}
+	return out
+}
+
+// SnapshotRestore creates a volume from the given snapshot
+func (m *Ops) SnapshotRestore(
+	snapID, zone string,
+	labels map[string]string,
+) (*storageops.Volume, error) {
+	m.store.Lock()
+	snap, ok := m.store.snapshots[snapID]
+	m.store.Unlock()
+	if !ok {
+		return nil, storageops.NewStorageError(storageops.ErrVolNotFound,
+			fmt.Sprintf("snapshot %s not found", snapID), m.instance)
+	}
+	return m.Create(&Volume{
+		SizeGiB:    snap.SizeGiB,
+		Zone:       zone,
+		SnapshotID: snapID,
+	}, labels)
+}
+
+// SnapshotStatus returns the status of the given snapshot, mock snapshots
+// complete right away
+func (m *Ops) SnapshotStatus(snapID string) (*storageops.SnapshotStatus, error) {
+	if err := m.call("SnapshotStatus"); err != nil {
+		return nil, err
+	}
+	defer m.store.Unlock()
+
+	snap, ok := m.store.snapshots[snapID]
+	if !ok {
+		return nil, storageops.NewStorageError(storageops.ErrVolNotFound,
+			fmt.Sprintf("snapshot %s not found", snapID), m.instance)
+	}
+	return &storageops.SnapshotStatus{
+		ID:        snap.ID,
+		State:     snap.State,
+		Progress:  100,
+		Completed: true,
+	}, nil
+}
+
+// ApplyTags applies the given labels on the given volume
+func (m *Ops) ApplyTags(volumeID string, labels map[string]string) error {
+	if err := m.call("ApplyTags"); err != nil {
+		return err
+	}
+	defer m.store.Unlock()
+
+	target, err := m.labels(volumeID)
+	if err != nil {
+		return err
+	}
+	for k, val := range labels {
+		target[k] = val
+	}
+	return nil
+}
+
+// labels returns the labels of the given volume or, like EC2 tags, snapshot
+func (m *Ops) labels(id string) (map[string]string, error) {
+	if snap, ok := m.store.snapshots[id]; ok {
+		if snap.Labels == nil {
+			snap.Labels = make(map[string]string)
+		}
+		return snap.Labels, nil
+	}
+	v, err := m.volume(id)
+	if err != nil {
+		return nil, err
+	}
+	return v.Labels, nil
+}
+
+// RemoveTags removes the given labels from the given volume
+func (m *Ops) RemoveTags(volumeID string, labels map[string]string) error {
+	if err := m.call("RemoveTags"); err != nil {
+		return err
+	}
+	defer m.store.Unlock()
+
+	target, err := m.labels(volumeID)
+	if err != nil {
+		return err
+	}
+	for k := range labels {
+		delete(target, k)
+	}
+	return nil
+}
+
+// Tags returns the labels on the given volume
+func (m *Ops) Tags(volumeID string) (map[string]string, error) {
+	if err := m.call("Tags"); err != nil {
+		return nil, err
+	}
+	defer m.store.Unlock()
+
+	labels, err := m.labels(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	copied := make(map[string]string, len(labels))
+	for k, val := range labels {
+		copied[k] = val
+	}
+	return copied, nil
+}
+
+var _ storageops.VolumeStatusOps = &Ops{}
+
+// SetHealth sets the health the given volume reports, to test monitoring of
+// degraded volumes
+func (m *Ops) SetHealth(volumeID string, health storageops.VolumeHealth) error {
+	m.store.Lock()
+	defer m.store.Unlock()
+	v, err := m.volume(volumeID)
+	if err != nil {
+		return err
+	}
+	v.Health = health
+	return nil
+}
+
+// VolumeStatus returns the health of the given volume, see SetHealth
+func (m *Ops) VolumeStatus(volumeID string) (*storageops.VolumeStatus, error) {
+	if err := m.call("VolumeStatus"); err != nil {
+		return nil, err
+	}
+	defer m.store.Unlock()
+
+	v, err := m.volume(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	health := v.Health
+	if len(health) == 0 {
+		health = storageops.VolumeHealthOK
+	}
+	return &storageops.VolumeStatus{
+		VolumeID: v.ID,
+		Health:   health,
+		Checks:   map[string]string{},
+	}, nil
+}
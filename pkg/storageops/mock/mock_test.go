@@ -0,0 +1,799 @@
+package mock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/libopenstorage/openstorage/pkg/auth"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/libopenstorage/openstorage/pkg/storageops/config"
+	"github.com/libopenstorage/openstorage/pkg/storageops/test"
+	"github.com/portworx/kvdb"
+	"github.com/portworx/kvdb/mem"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAll(t *testing.T) {
+	d := New("instance-1", "zone-a")
+	drivers := map[string]storageops.Ops{d.Name(): d}
+	diskTemplates := map[string]map[string]interface{}{
+		d.Name(): {"mock-disk": &Volume{SizeGiB: 10, Type: "ssd"}},
+	}
+	test.RunTest(drivers, diskTemplates, t)
+}
+
+func TestMockInjection(t *testing.T) {
+	d := New("instance-1", "zone-a")
+	other := d.ForInstance("instance-2", "zone-a")
+
+	vol, err := d.Create(&Volume{SizeGiB: 1}, map[string]string{"app": "db"})
+	require.NoError(t, err)
+	volumeID, err := d.GetDeviceID(vol)
+	require.NoError(t, err)
+
+	injected := fmt.Errorf("attach failed")
+	d.InjectError("Attach", injected)
+	_, err = d.Attach(volumeID, nil)
+	require.Equal(t, injected, err)
+
+	devicePath, err := d.Attach(volumeID, nil)
+	require.NoError(t, err)
+	require.NotEmpty(t, devicePath)
+	require.Equal(t, 2, d.Calls("Attach"))
+
+	_, err = other.Attach(volumeID, nil)
+	se, ok := err.(*storageops.StorageError)
+	require.True(t, ok, "expected a storage error, got %v", err)
+	require.Equal(t, storageops.ErrVolAttachedOnRemoteNode, se.Code)
+
+	other.SetLatency("DetachFrom", 50*time.Millisecond)
+	start := time.Now()
+	require.NoError(t, other.DetachFrom(volumeID, "instance-1"))
+	require.True(t, time.Since(start) >= 50*time.Millisecond)
+
+	mappings, err := d.DeviceMappings()
+	require.NoError(t, err)
+	require.Empty(t, mappings)
+}
+
+func TestMockSnapshotRestore(t *testing.T) {
+	d := New("instance-1", "zone-a")
+
+	vol, err := d.Create(&Volume{SizeGiB: 5}, nil)
+	require.NoError(t, err)
+	snap, err := d.Snapshot(vol.ID, true)
+	require.NoError(t, err)
+
+	restored, err := d.SnapshotRestore(snap.ID, "zone-b", map[string]string{"restored": "true"})
+	require.NoError(t, err)
+	require.NotEqual(t, vol.ID, restored.ID)
+	require.Equal(t, uint64(5), restored.SizeGiB)
+	require.Equal(t, "zone-b", restored.Zone)
+	require.Equal(t, "true", restored.Labels["restored"])
+
+	_, err = d.SnapshotRestore("snap-missing", "", nil)
+	require.Error(t, err)
+}
+
+func TestMockSnapshotWait(t *testing.T) {
+	d := New("instance-1", "zone-a")
+
+	vol, err := d.Create(&Volume{SizeGiB: 1}, nil)
+	require.NoError(t, err)
+	volID, err := d.GetDeviceID(vol)
+	require.NoError(t, err)
+
+	snap, err := storageops.SnapshotAndWait(d, volID, true, true, time.Second)
+	require.NoError(t, err)
+	snapID, err := d.GetDeviceID(snap)
+	require.NoError(t, err)
+
+	status, err := d.SnapshotStatus(snapID)
+	require.NoError(t, err)
+	require.True(t, status.Completed)
+	require.Equal(t, 100, status.Progress)
+
+	d.InjectError("SnapshotStatus", fmt.Errorf("throttled"))
+	status, err = storageops.WaitForSnapshot(d, snapID, time.Minute)
+	require.NoError(t, err)
+	require.True(t, status.Completed)
+
+	_, err = d.SnapshotStatus("snap-missing")
+	require.Error(t, err)
+}
+
+func TestMockBackupNode(t *testing.T) {
+	d := New("instance-1", "zone-a")
+	managed := map[string]string{"managed": "true"}
+
+	var volumeIDs []string
+	for i := 0; i < 4; i++ {
+		labels := managed
+		if i == 3 {
+			labels = nil
+		}
+		vol, err := d.Create(&Volume{SizeGiB: 1}, labels)
+		require.NoError(t, err)
+		id, err := d.GetDeviceID(vol)
+		require.NoError(t, err)
+		_, err = d.Attach(id, nil)
+		require.NoError(t, err)
+		volumeIDs = append(volumeIDs, id)
+	}
+	d.InjectError("Snapshot", fmt.Errorf("throttled"))
+
+	job, err := storageops.BackupNode(d, &storageops.BackupOptions{
+		Labels:            managed,
+		Concurrency:       2,
+		WaitForCompletion: true,
+	})
+	require.NoError(t, err)
+	require.Error(t, job.Wait())
+
+	status := job.Status()
+	require.Len(t, status, 3)
+	failed := 0
+	for _, v := range status {
+		require.NotEqual(t, volumeIDs[3], v.VolumeID)
+		if v.State == storageops.BackupFailed {
+			failed++
+			continue
+		}
+		require.Equal(t, storageops.BackupCompleted, v.State)
+		tags, err := d.Tags(v.SnapshotID)
+		require.NoError(t, err)
+		require.Equal(t, job.ID, tags[storageops.BackupSetLabel])
+	}
+	require.Equal(t, 1, failed)
+}
+
+func TestMockListVolumes(t *testing.T) {
+	d := New("instance-1", "zone-a")
+	for i := 0; i < 5; i++ {
+		_, err := d.Create(&Volume{SizeGiB: 1}, map[string]string{"app": "db"})
+		require.NoError(t, err)
+	}
+
+	var ids []string
+	req := &storageops.PageRequest{Limit: 2}
+	for pages := 1; ; pages++ {
+		vols, next, err := storageops.ListVolumes(d, map[string]string{"app": "db"}, req)
+		require.NoError(t, err)
+		for _, v := range vols {
+			ids = append(ids, v.ID)
+		}
+		if len(next) == 0 {
+			require.Equal(t, 3, pages)
+			break
+		}
+		req.Cursor = next
+	}
+	require.Len(t, ids, 5)
+	require.True(t, sort.StringsAreSorted(ids))
+}
+
+func TestMockVolumeGroup(t *testing.T) {
+	kv, err := kvdb.New(mem.Name, "volumegroup_test", []string{}, nil, logrus.Panicf)
+	require.NoError(t, err)
+	d := New("instance-1", "zone-a")
+	spec := &storageops.VolumeGroupSpec{
+		Name:      "db",
+		Templates: []interface{}{&Volume{SizeGiB: 1}, &Volume{SizeGiB: 1}, &Volume{SizeGiB: 1}},
+	}
+
+	d.InjectError("Create", nil, fmt.Errorf("quota exceeded"))
+	_, err = storageops.CreateVolumeGroup(kv, d, spec)
+	require.Error(t, err)
+	vols, err := d.Enumerate(nil, nil, "")
+	require.NoError(t, err)
+	require.Empty(t, vols, "created volumes should be rolled back")
+
+	g, err := storageops.CreateVolumeGroup(kv, d, spec)
+	require.NoError(t, err)
+	require.Len(t, g.VolumeIDs, 3)
+	_, err = storageops.CreateVolumeGroup(kv, d, spec)
+	require.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval), "%v", err)
+	opened, err := storageops.OpenVolumeGroup(kv, d, "db")
+	require.NoError(t, err)
+	created := append([]string(nil), g.VolumeIDs...)
+	sort.Strings(created)
+	require.Equal(t, created, opened.VolumeIDs)
+
+	d.InjectError("Attach", fmt.Errorf("attach failed"))
+	_, err = g.Attach(nil)
+	require.Error(t, err)
+	mappings, err := d.DeviceMappings()
+	require.NoError(t, err)
+	require.Empty(t, mappings, "attached volumes should be rolled back")
+
+	devicePaths, err := g.Attach(nil)
+	require.NoError(t, err)
+	require.Len(t, devicePaths, 3)
+	require.Error(t, g.Delete(), "attached group should not be deleted")
+
+	d.InjectError("Snapshot", nil, fmt.Errorf("throttled"))
+	_, err = g.Snapshot(true)
+	require.Error(t, err)
+	snaps, err := d.SnapshotEnumerate(nil)
+	require.NoError(t, err)
+	require.Empty(t, snaps, "snapshots should be rolled back")
+
+	gs, err := g.Snapshot(true)
+	require.NoError(t, err)
+	require.Len(t, gs.SnapshotIDs, 3)
+	for _, snapID := range gs.SnapshotIDs {
+		tags, err := d.Tags(snapID)
+		require.NoError(t, err)
+		require.Equal(t, gs.ID, tags[storageops.VolumeGroupSnapshotLabel])
+	}
+
+	require.NoError(t, g.Detach())
+	require.NoError(t, g.Delete())
+	_, err = storageops.OpenVolumeGroup(kv, d, "db")
+	require.Error(t, err)
+	_, err = storageops.CreateVolumeGroup(kv, d, spec)
+	require.NoError(t, err, "the name of a deleted group can be reused")
+}
+
+func TestMockSnapshotDeleteMatching(t *testing.T) {
+	d := New("instance-1", "zone-a")
+	for _, cluster := range []string{"c1", "c1", "c2"} {
+		vol, err := d.Create(&Volume{SizeGiB: 1}, map[string]string{"cluster": cluster})
+		require.NoError(t, err)
+		_, err = d.Snapshot(vol.ID, true)
+		require.NoError(t, err)
+	}
+	plain, err := d.Create(&Volume{SizeGiB: 1}, nil)
+	require.NoError(t, err)
+	_, err = d.Snapshot(plain.ID, true)
+	require.NoError(t, err)
+
+	sets, err := storageops.SnapshotEnumerateSets(d, nil, "cluster")
+	require.NoError(t, err)
+	require.Len(t, sets["c1"], 2)
+	require.Len(t, sets["c2"], 1)
+	require.Len(t, sets[storageops.SetIdentifierNone], 1)
+
+	_, err = storageops.SnapshotDeleteMatching(d, nil, 0)
+	require.Error(t, err, "empty labels must not delete every snapshot")
+
+	d.InjectError("SnapshotDelete", fmt.Errorf("throttled"))
+	results, err := storageops.SnapshotDeleteMatching(d, map[string]string{"cluster": "c1"}, 0)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+		}
+	}
+	require.Equal(t, 1, failed)
+
+	snaps, err := d.SnapshotEnumerate(nil)
+	require.NoError(t, err)
+	require.Len(t, snaps, 3)
+}
+
+func TestMockAttached(t *testing.T) {
+	d := New("instance-1", "zone-a")
+	other := d.ForInstance("instance-2", "zone-a")
+	// Middlewares hide the AttachmentOps of the driver, volumes are inspected
+	wrapped := storageops.NewMetricsOps(d, storageops.NewMetricsCollector())
+	wrappedOther := storageops.NewMetricsOps(other, storageops.NewMetricsCollector())
+
+	vol, err := d.Create(&Volume{SizeGiB: 1}, nil)
+	require.NoError(t, err)
+	volumeID := vol.ID
+	for _, ops := range []storageops.Ops{d, wrapped} {
+		instanceID, device, state, err := storageops.Attached(ops, volumeID)
+		require.NoError(t, err)
+		require.Empty(t, instanceID)
+		require.Empty(t, device)
+		require.Empty(t, state)
+	}
+
+	devicePath, err := d.Attach(volumeID, nil)
+	require.NoError(t, err)
+	for _, ops := range []storageops.Ops{d, other, wrapped, wrappedOther} {
+		instanceID, device, state, err := storageops.Attached(ops, volumeID)
+		require.NoError(t, err)
+		require.Equal(t, "instance-1", instanceID)
+		require.Equal(t, devicePath, device)
+		require.Equal(t, storageops.AttachmentStateAttached, state)
+	}
+	vols, err := d.Inspect([]*string{&volumeID})
+	require.NoError(t, err)
+	require.Equal(t, []storageops.Attachment{{
+		InstanceID: "instance-1",
+		Device:     devicePath,
+		State:      storageops.AttachmentStateAttached,
+	}}, vols[0].Attachments)
+
+	_, _, _, err = storageops.Attached(d, "vol-missing")
+	require.Error(t, err)
+}
+
+func TestMockVolumeSpec(t *testing.T) {
+	d := New("instance-1", "zone-a")
+	out, err := d.Create(&storageops.VolumeSpec{
+		SizeGiB: 8,
+		Type:    "ssd",
+		Iops:    3000,
+		Labels:  map[string]string{"app": "db", "tier": "spec"},
+		// The native template sets what the spec has no field for
+		Native: &Volume{Zone: "zone-b", SizeGiB: 1},
+	}, map[string]string{"tier": "create"})
+	require.NoError(t, err)
+	vol := out
+	require.Equal(t, uint64(8), vol.SizeGiB)
+	require.Equal(t, "ssd", vol.Type)
+	require.Equal(t, int64(3000), vol.Iops)
+	require.Equal(t, "zone-b", vol.Zone)
+	require.Equal(t, map[string]string{"app": "db", "tier": "create"}, vol.Labels)
+
+	_, err = d.Create(&storageops.VolumeSpec{SizeGiB: 1, Encrypted: true}, nil)
+	require.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval), "%v", err)
+	_, err = d.Create(&storageops.VolumeSpec{SizeGiB: 1, Native: &Snapshot{}}, nil)
+	require.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval), "%v", err)
+}
+
+func TestMockCreateIdempotent(t *testing.T) {
+	d := New("instance-1", "zone-a")
+	found, err := storageops.FindByClientToken(d, "token-1")
+	require.NoError(t, err)
+	require.Nil(t, found)
+
+	first, err := storageops.CreateIdempotent(d, &Volume{SizeGiB: 1}, map[string]string{"app": "db"}, "token-1")
+	require.NoError(t, err)
+	require.Equal(t, "token-1", first.Labels[storageops.ClientTokenLabel])
+	retried, err := storageops.CreateIdempotent(d, &Volume{SizeGiB: 1}, map[string]string{"app": "db"}, "token-1")
+	require.NoError(t, err)
+	require.Equal(t, first.ID, retried.ID)
+
+	// A crashed creator recovers the volume of its token
+	found, err = storageops.FindByClientToken(d, "token-1")
+	require.NoError(t, err)
+	require.Equal(t, first.ID, found.ID)
+
+	// The spec carries the token as well
+	_, err = d.Create(&storageops.VolumeSpec{SizeGiB: 1, ClientToken: "token-1"}, nil)
+	require.NoError(t, err)
+	_, err = storageops.FindByClientToken(d, "token-1")
+	require.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval), "%v", err)
+	_, err = storageops.FindByClientToken(d, "")
+	require.Error(t, err)
+}
+
+func TestMockDeleteMatching(t *testing.T) {
+	d := New("instance-1", "zone-a")
+	other := d.ForInstance("instance-2", "zone-a")
+	var ids []string
+	for i := 0; i < 3; i++ {
+		vol, err := d.Create(&Volume{SizeGiB: 1}, map[string]string{"cluster": "c1"})
+		require.NoError(t, err)
+		ids = append(ids, vol.ID)
+	}
+	_, err := d.Create(&Volume{SizeGiB: 1}, map[string]string{"cluster": "c2"})
+	require.NoError(t, err)
+	_, err = other.Attach(ids[1], nil)
+	require.NoError(t, err)
+	count := func() int {
+		sets, err := d.Enumerate(nil, nil, "")
+		require.NoError(t, err)
+		return len(sets[storageops.SetIdentifierNone])
+	}
+
+	_, err = storageops.DeleteMatching(d, nil, true, nil)
+	require.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval), "%v", err)
+
+	results, err := storageops.DeleteMatching(d, map[string]string{"cluster": "c1"}, true, nil)
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	require.Equal(t, ids[1], results[1].VolumeID)
+	require.Equal(t, []string{"instance-2"}, results[1].AttachedTo)
+	require.True(t, results[1].Skipped)
+	require.False(t, results[0].Skipped)
+	require.Equal(t, 4, count(), "dry run should not delete")
+
+	results, err = storageops.DeleteMatching(d, map[string]string{"cluster": "c1"}, false, nil)
+	require.NoError(t, err)
+	for _, r := range results {
+		require.NoError(t, r.Err)
+	}
+	require.Equal(t, 2, count(), "attached volume should be skipped")
+
+	results, err = storageops.DeleteMatching(d, map[string]string{"cluster": "c1"}, false,
+		&storageops.DeleteMatchingOptions{Detach: true, Parallelism: 2})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.False(t, results[0].Skipped)
+	require.NoError(t, results[0].Err)
+	require.Equal(t, 1, count())
+}
+
+func TestMockOperations(t *testing.T) {
+	d := New("instance-1", "zone-a")
+	vol, err := d.Create(&Volume{SizeGiB: 1, Type: "hdd"}, nil)
+	require.NoError(t, err)
+	volumeID := vol.ID
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	op, err := storageops.SnapshotAsync(d, volumeID, true)
+	require.NoError(t, err)
+	status, err := op.Status()
+	require.NoError(t, err)
+	require.Equal(t, storageops.OperationSucceeded, status.State)
+	require.Equal(t, 100, status.Progress)
+	result, err := op.Wait(ctx)
+	require.NoError(t, err)
+	require.Equal(t, op.ID(), result.(*storageops.SnapshotStatus).ID)
+
+	// Canceling a snapshot deletes it
+	require.NoError(t, op.Cancel())
+	_, err = op.Wait(ctx)
+	require.Equal(t, storageops.ErrOperationCanceled, err)
+	_, err = d.SnapshotStatus(op.ID())
+	require.True(t, storageops.IsErrorCode(err, storageops.ErrVolNotFound), "%v", err)
+
+	// Drivers without progress reporting modify in the background
+	d.SetLatency("Modify", 50*time.Millisecond)
+	op, err = storageops.ModifyAsync(d, volumeID, storageops.VolumeSpecUpdate{Type: "ssd"})
+	require.NoError(t, err)
+	status, err = op.Status()
+	require.NoError(t, err)
+	require.Equal(t, storageops.OperationRunning, status.State)
+	require.Equal(t, storageops.ErrNotSupported, op.Cancel())
+	_, err = op.Wait(ctx)
+	require.NoError(t, err)
+	modified, err := d.Inspect([]*string{&volumeID})
+	require.NoError(t, err)
+	require.Equal(t, "ssd", modified[0].Type)
+
+	d.InjectError("Modify", fmt.Errorf("modification failed"))
+	op, err = storageops.ModifyAsync(d, volumeID, storageops.VolumeSpecUpdate{Type: "hdd"})
+	require.NoError(t, err)
+	_, err = op.Wait(ctx)
+	require.Error(t, err)
+	status, err = op.Status()
+	require.NoError(t, err)
+	require.Equal(t, storageops.OperationFailed, status.State)
+	require.Contains(t, status.Message, "modification failed")
+}
+
+func TestMockSnapshotWithLabels(t *testing.T) {
+	d := New("instance-1", "zone-a")
+	vol, err := d.Create(&Volume{SizeGiB: 1}, nil)
+	require.NoError(t, err)
+	volumeID := vol.ID
+
+	snap, err := storageops.SnapshotWithLabels(d, volumeID, true, map[string]string{"pvc": "data"})
+	require.NoError(t, err)
+	snapID, err := d.GetDeviceID(snap)
+	require.NoError(t, err)
+	tags, err := d.Tags(snapID)
+	require.NoError(t, err)
+	require.Equal(t, "data", tags["pvc"])
+
+	// A snapshot that can not be labeled is deleted again
+	d.InjectError("ApplyTags", fmt.Errorf("throttled"))
+	_, err = storageops.SnapshotWithLabels(d, volumeID, true, map[string]string{"pvc": "data"})
+	require.Error(t, err)
+	snaps, err := d.SnapshotEnumerate(nil)
+	require.NoError(t, err)
+	require.Len(t, snaps, 1)
+}
+
+func TestMockMaintenanceSnapshots(t *testing.T) {
+	d := New("instance-1", "zone-a")
+	ops := storageops.NewMaintenanceSnapshotOps(d, map[storageops.MaintenanceOp]time.Duration{
+		storageops.MaintenanceExpand: time.Hour,
+		storageops.MaintenanceDelete: 0,
+	})
+	vol, err := d.Create(&Volume{SizeGiB: 1}, nil)
+	require.NoError(t, err)
+	volumeID := vol.ID
+
+	_, err = ops.Expand(volumeID, 2)
+	require.NoError(t, err)
+	require.NoError(t, ops.Modify(volumeID, storageops.VolumeSpecUpdate{Iops: 3000}))
+	snaps, err := d.SnapshotEnumerate(nil)
+	require.NoError(t, err)
+	require.Len(t, snaps, 1, "only configured operation classes should be snapshotted")
+	require.Equal(t, string(storageops.MaintenanceExpand),
+		snaps[0].Labels[storageops.MaintenanceSnapshotLabel])
+
+	d.InjectError("Snapshot", fmt.Errorf("snapshot limit exceeded"))
+	require.Error(t, ops.Delete(volumeID))
+	_, err = d.Inspect([]*string{&volumeID})
+	require.NoError(t, err, "volume must not be deleted without its snapshot")
+
+	require.NoError(t, ops.Delete(volumeID))
+	pruned, err := storageops.PruneMaintenanceSnapshots(d)
+	require.NoError(t, err)
+	require.Len(t, pruned, 1, "only the expired delete snapshot should be pruned")
+	snaps, err = d.SnapshotEnumerate(nil)
+	require.NoError(t, err)
+	require.Len(t, snaps, 1)
+}
+
+func TestMockEnumerateEach(t *testing.T) {
+	d := New("instance-1", "zone-a")
+	for _, set := range []string{"a", "b", "b"} {
+		_, err := d.Create(&Volume{SizeGiB: 1}, map[string]string{"set": set})
+		require.NoError(t, err)
+	}
+
+	var sets []string
+	err := storageops.EnumerateEach(d, nil, nil, "set", func(set string, vol *storageops.Volume) bool {
+		sets = append(sets, set)
+		return true
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b", "b"}, sets)
+
+	count := 0
+	err = storageops.EnumerateEach(d, nil, nil, "", func(set string, vol *storageops.Volume) bool {
+		count++
+		return false
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
+
+func TestMockTopology(t *testing.T) {
+	topology, err := LoadTopology("testdata/topology.yaml")
+	require.NoError(t, err)
+	c, err := NewCluster(topology)
+	require.NoError(t, err)
+
+	nodes := c.Nodes()
+	require.Len(t, nodes, 45)
+	require.Equal(t, "eu-west-a-node-00001", nodes[0])
+	require.Nil(t, c.Node("missing"))
+	node := c.Node("us-east-a-node-00003")
+	require.NotNil(t, node)
+
+	zones, err := node.ListZones()
+	require.NoError(t, err)
+	require.Equal(t, []string{"us-east-a", "us-east-b"}, zones)
+	zones, err = c.Node("eu-west-a-node-00001").ListZones()
+	require.NoError(t, err)
+	require.Equal(t, []string{"eu-west-a"}, zones)
+
+	mappings, err := node.DeviceMappings()
+	require.NoError(t, err)
+	require.Len(t, mappings, 3)
+	sets, err := node.Enumerate(nil, map[string]string{"pxnode": node.InstanceID()}, "")
+	require.NoError(t, err)
+	require.Len(t, sets[storageops.SetIdentifierNone], 2)
+
+	orphans, err := node.Enumerate(nil, map[string]string{"orphaned": "true"}, "")
+	require.NoError(t, err)
+	require.Len(t, orphans[storageops.SetIdentifierNone], 10)
+	orphan := orphans[storageops.SetIdentifierNone][0]
+	require.Equal(t, VolumeStateAvailable, orphan.State)
+	_, err = node.Attach(orphan.ID, nil)
+	require.NoError(t, err)
+	mappings, err = node.DeviceMappings()
+	require.NoError(t, err)
+	require.Len(t, mappings, 4)
+
+	snaps, err := node.SnapshotEnumerate(nil)
+	require.NoError(t, err)
+	require.Len(t, snaps, 40)
+
+	// 20*3 + 10 + 20*3 + 5 drives, listed 50 at a time
+	calls := node.Calls("Enumerate")
+	var ids []string
+	err = storageops.EnumerateEach(node, nil, nil, "pxnode", func(set string, vol *storageops.Volume) bool {
+		ids = append(ids, vol.ID)
+		return true
+	})
+	require.NoError(t, err)
+	require.Len(t, ids, 135)
+	require.True(t, sort.StringsAreSorted(ids))
+	require.Equal(t, 3, node.Calls("Enumerate")-calls)
+
+	_, err = NewCluster(&Topology{Zones: []*ZoneTopology{
+		{Name: "zone-a", Nodes: 1, Drives: []*DriveTopology{{Count: maxDevices + 1, SizeGiB: 1}}},
+	}})
+	require.Error(t, err)
+	_, err = NewCluster(&Topology{Latencies: map[string]string{"Attach": "soon"}})
+	require.Error(t, err)
+}
+
+func TestMockTopologyScale(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping scale test in short mode")
+	}
+	topology := &Topology{Seed: 1, PageSize: 500}
+	for _, zone := range []string{"us-east-a", "us-east-b", "us-east-c"} {
+		topology.Zones = append(topology.Zones, &ZoneTopology{
+			Name:   zone,
+			Nodes:  1000,
+			Drives: []*DriveTopology{{Count: 8, SizeGiB: 512, NodeLabel: "node"}},
+		})
+	}
+	c, err := NewCluster(topology)
+	require.NoError(t, err)
+	node := c.Node(c.Nodes()[0])
+
+	count := 0
+	err = storageops.EnumerateEach(node, nil, nil, "node", func(set string, vol *storageops.Volume) bool {
+		count++
+		return true
+	})
+	require.NoError(t, err)
+	require.Equal(t, 24000, count)
+	require.Equal(t, 49, node.Calls("Enumerate"))
+}
+
+func TestMockVolumeStatus(t *testing.T) {
+	d := New("instance-1", "zone-a")
+	vol, err := d.Create(&Volume{SizeGiB: 1}, nil)
+	require.NoError(t, err)
+	volumeID := vol.ID
+
+	status, err := storageops.InspectVolumeStatus(d, volumeID)
+	require.NoError(t, err)
+	require.Equal(t, storageops.VolumeHealthOK, status.Health)
+
+	require.NoError(t, d.SetHealth(volumeID, storageops.VolumeHealthIOError))
+	status, err = d.VolumeStatus(volumeID)
+	require.NoError(t, err)
+	require.Equal(t, storageops.VolumeHealthIOError, status.Health)
+
+	_, err = d.VolumeStatus("vol-missing")
+	require.True(t, storageops.IsErrorCode(err, storageops.ErrVolNotFound), "%v", err)
+	_, err = storageops.InspectVolumeStatus(storageops.NewMetricsOps(d, storageops.NewMetricsCollector()), volumeID)
+	require.Equal(t, storageops.ErrNotSupported, err)
+}
+
+func TestMockClone(t *testing.T) {
+	d := New("instance-1", "zone-a")
+	vol, err := d.Create(&Volume{SizeGiB: 8, Zone: "zone-b"}, map[string]string{"app": "db"})
+	require.NoError(t, err)
+	volumeID := vol.ID
+
+	out, err := storageops.Clone(d, volumeID, map[string]string{"env": "test"})
+	require.NoError(t, err)
+	clone := out
+	require.NotEqual(t, volumeID, clone.ID)
+	require.Equal(t, uint64(8), clone.SizeGiB)
+	require.Equal(t, "zone-b", clone.Zone)
+	require.Equal(t, "test", clone.Labels["env"])
+	require.Equal(t, volumeID, clone.Labels[storageops.CloneSourceLabel])
+	require.NotEmpty(t, clone.Labels[storageops.ClonedAtLabel])
+
+	// The temporary snapshot is deleted
+	snaps, err := d.SnapshotEnumerate(nil)
+	require.NoError(t, err)
+	require.Empty(t, snaps)
+
+	d.InjectError("Create", fmt.Errorf("out of capacity"))
+	_, err = storageops.Clone(d, volumeID, nil)
+	require.Error(t, err)
+	snaps, err = d.SnapshotEnumerate(nil)
+	require.NoError(t, err)
+	require.Empty(t, snaps)
+
+	_, err = storageops.Clone(d, "vol-missing", nil)
+	require.Error(t, err)
+}
+
+func TestMockCloudConfig(t *testing.T) {
+	c, err := config.Load("testdata/cloudconfig.yaml")
+	require.NoError(t, err)
+	require.Equal(t, Name, c.Provider)
+
+	policy, err := c.RetryPolicy()
+	require.NoError(t, err)
+	require.Equal(t, 10*time.Millisecond, policy.Interval)
+	require.Equal(t, 3, policy.MaxAttempts)
+	require.Equal(t, time.Minute, policy.Overrides["attach"].Timeout)
+
+	_, err = c.RequiredCredential("token", "")
+	require.Error(t, err, "unset env of a credential should fail")
+	os.Setenv("MOCK_CLOUD_TOKEN", "secret")
+	defer os.Unsetenv("MOCK_CLOUD_TOKEN")
+	token, err := c.RequiredCredential("token", "")
+	require.NoError(t, err)
+	require.Equal(t, "secret", token)
+	_, ok, err := c.Credential("missing")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	d, err := config.New(c)
+	require.NoError(t, err)
+	require.Equal(t, Name, d.Name())
+	require.Equal(t, "us-east-a-node-00001", d.InstanceID())
+
+	vol, err := d.Create(&Volume{SizeGiB: 1}, map[string]string{"owner": "app"})
+	require.NoError(t, err)
+	volumeID, err := d.GetDeviceID(vol)
+	require.NoError(t, err)
+	tags, err := d.Tags(volumeID)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"cluster": "test", "owner": "app"}, tags)
+
+	snap, err := d.Snapshot(volumeID, true)
+	require.NoError(t, err)
+	snapID, err := d.GetDeviceID(snap)
+	require.NoError(t, err)
+	tags, err = d.Tags(snapID)
+	require.NoError(t, err)
+	require.Equal(t, "test", tags["cluster"])
+
+	c.Instance = "missing"
+	_, err = config.New(c)
+	require.Error(t, err)
+	c.Provider = "bogus"
+	_, err = config.New(c)
+	require.True(t, errors.Is(err, storageops.ErrNotSupported))
+	_, err = config.Parse([]byte(`{"region": "us-east"}`))
+	require.Error(t, err, "config without provider should fail")
+}
+
+func TestMockRegister(t *testing.T) {
+	initFn, err := storageops.Get(Name)
+	require.NoError(t, err)
+	d, err := initFn(map[string]string{
+		config.ParamInstance: "instance-1",
+		config.ParamZone:     "zone-a",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "instance-1", d.InstanceID())
+	zone, err := d.GetZone()
+	require.NoError(t, err)
+	require.Equal(t, "zone-a", zone)
+
+	// Drivers without a cloud config factory are created by their init function
+	storageops.Register("mock-plugin", func(params map[string]string) (storageops.Ops, error) {
+		return New(params[config.ParamInstance], params["pool"]), nil
+	})
+	d, err = config.New(&config.Config{
+		Provider: "mock-plugin",
+		Instance: "instance-2",
+		Params:   map[string]string{"pool": "zone-b"},
+		Tags:     map[string]string{"cluster": "test"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "instance-2", d.InstanceID())
+	zone, err = d.GetZone()
+	require.NoError(t, err)
+	require.Equal(t, "zone-b", zone)
+}
+
+func TestMockAuthorization(t *testing.T) {
+	c := &config.Config{Provider: Name, Instance: "instance-1", Zone: "zone-a"}
+	user := &auth.UserInfo{Username: "jdoe", Claims: auth.Claims{Roles: []string{"system.user"}}}
+	d, err := config.NewWithContext(auth.ContextSaveUserInfo(context.Background(), user), c)
+	require.NoError(t, err)
+
+	vol, err := d.Create(&Volume{SizeGiB: 1}, nil)
+	require.NoError(t, err)
+	volumeID, err := d.GetDeviceID(vol)
+	require.NoError(t, err)
+
+	err = d.Delete(volumeID)
+	require.True(t, storageops.IsErrorCode(err, storageops.ErrPermissionDenied), "%v", err)
+	vols, err := d.Inspect([]*string{&volumeID})
+	require.NoError(t, err)
+	require.Len(t, vols, 1, "a denied delete must not reach the driver")
+
+	// Without a user in the context the system runs without auth
+	d, err = config.NewWithContext(context.Background(), c)
+	require.NoError(t, err)
+	vol, err = d.Create(&Volume{SizeGiB: 1}, nil)
+	require.NoError(t, err)
+	volumeID, err = d.GetDeviceID(vol)
+	require.NoError(t, err)
+	require.NoError(t, d.Delete(volumeID))
+}
@@ -0,0 +1,202 @@
+package mock
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+// Topology is the spec of a simulated cluster, to load test controllers,
+// garbage collection and enumeration against thousands of nodes and tens of
+// thousands of drives. See LoadTopology and NewCluster.
+type Topology struct {
+	// Seed of the random jitter, zero for a random seed
+	Seed int64 `json:"seed,omitempty"`
+	// Zones of the cluster
+	Zones []*ZoneTopology `json:"zones"`
+	// Latencies are the latencies of the operations, e.g. "Attach": "2s".
+	// The empty operation is the latency of all others, see SetLatency.
+	Latencies map[string]string `json:"latencies,omitempty"`
+	// Jitter is the maximum random delay added to every latency
+	Jitter string `json:"jitter,omitempty"`
+	// PageSize is the page size of list calls, see SetPageSize
+	PageSize int `json:"pageSize,omitempty"`
+}
+
+// ZoneTopology is the spec of the nodes and drives of a zone
+type ZoneTopology struct {
+	// Name of the zone, e.g. us-east-a in region us-east
+	Name string `json:"name"`
+	// Nodes is the number of nodes in the zone
+	Nodes int `json:"nodes"`
+	// Drives are attached to every node of the zone
+	Drives []*DriveTopology `json:"drives,omitempty"`
+	// Detached drives are in the zone but not attached to any node, e.g.
+	// drives of deleted nodes waiting for garbage collection
+	Detached []*DriveTopology `json:"detached,omitempty"`
+}
+
+// DriveTopology is the spec of a group of identical drives
+type DriveTopology struct {
+	// Count is the number of drives, per node for attached drives
+	Count int `json:"count"`
+	// SizeGiB is the size of the drives
+	SizeGiB uint64 `json:"sizeGiB"`
+	// Type of the drives
+	Type string `json:"type,omitempty"`
+	// Iops are the provisioned IOPS of the drives
+	Iops int64 `json:"iops,omitempty"`
+	// Labels of the drives
+	Labels map[string]string `json:"labels,omitempty"`
+	// NodeLabel, if set, is a label holding the node of attached drives,
+	// e.g. a set identifier
+	NodeLabel string `json:"nodeLabel,omitempty"`
+	// Snapshots is the number of snapshots of every drive
+	Snapshots int `json:"snapshots,omitempty"`
+}
+
+// LoadTopology reads the topology spec file at the given path, YAML or JSON
+func LoadTopology(path string) (*Topology, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t := &Topology{}
+	if err := yaml.Unmarshal(data, t); err != nil {
+		return nil, fmt.Errorf("invalid topology %s: %v", path, err)
+	}
+	return t, nil
+}
+
+// Cluster is a simulated cluster. Its nodes are drivers sharing one store,
+// so latencies, paging and injected errors set on any node apply to all.
+type Cluster struct {
+	nodes map[string]*Ops
+	ids   []string
+}
+
+// NewCluster creates the nodes and drives of the given topology. Node IDs
+// are the zone followed by the node number, e.g. us-east-a-node-00001, and
+// attached drives use the first device paths of every node.
+func NewCluster(t *Topology) (*Cluster, error) {
+	s := newStore()
+	seed := t.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	s.rand = rand.New(rand.NewSource(seed))
+	for op, latency := range t.Latencies {
+		d, err := time.ParseDuration(latency)
+		if err != nil {
+			return nil, fmt.Errorf("invalid latency of %q: %v", op, err)
+		}
+		s.latencies[op] = d
+	}
+	if len(t.Jitter) > 0 {
+		d, err := time.ParseDuration(t.Jitter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jitter: %v", err)
+		}
+		s.jitter = d
+	}
+	s.pageSize = t.PageSize
+
+	c := &Cluster{nodes: make(map[string]*Ops)}
+	zones := make(map[string]bool)
+	for _, zone := range t.Zones {
+		if len(zone.Name) == 0 {
+			return nil, fmt.Errorf("zone without name")
+		}
+		if zones[zone.Name] {
+			return nil, fmt.Errorf("duplicate zone %s", zone.Name)
+		}
+		zones[zone.Name] = true
+		s.zones = append(s.zones, zone.Name)
+
+		perNode := 0
+		for _, d := range zone.Drives {
+			perNode += d.Count
+		}
+		if perNode > maxDevices {
+			return nil, fmt.Errorf("zone %s has %d drives per node, at most %d can be attached",
+				zone.Name, perNode, maxDevices)
+		}
+
+		for i := 1; i <= zone.Nodes; i++ {
+			node := &Ops{
+				instance: fmt.Sprintf("%s-node-%05d", zone.Name, i),
+				zone:     zone.Name,
+				store:    s,
+			}
+			c.nodes[node.instance] = node
+			c.ids = append(c.ids, node.instance)
+
+			device := 0
+			for _, d := range zone.Drives {
+				for j := 0; j < d.Count; j++ {
+					v := node.addDrive(d, zone.Name, node.instance)
+					node.attach(v, node.instance, devicePrefix+string(rune('a'+device)))
+					device++
+				}
+			}
+		}
+		detacher := &Ops{zone: zone.Name, store: s}
+		for _, d := range zone.Detached {
+			for j := 0; j < d.Count; j++ {
+				detacher.addDrive(d, zone.Name, "")
+			}
+		}
+	}
+	sort.Strings(s.zones)
+	sort.Strings(c.ids)
+	return c, nil
+}
+
+// addDrive creates a drive of the given spec for the given node, if any, and
+// its snapshots without any latency
+func (m *Ops) addDrive(d *DriveTopology, zone, node string) *Volume {
+	v := &Volume{
+		ID:      m.newID("vol"),
+		SizeGiB: d.SizeGiB,
+		Type:    d.Type,
+		Iops:    d.Iops,
+		Zone:    zone,
+		State:   VolumeStateAvailable,
+		Labels:  make(map[string]string, len(d.Labels)+1),
+		Created: time.Now(),
+	}
+	for k, val := range d.Labels {
+		v.Labels[k] = val
+	}
+	if len(d.NodeLabel) > 0 && len(node) > 0 {
+		v.Labels[d.NodeLabel] = node
+	}
+	m.store.volumes[v.ID] = v
+	m.store.sorted = nil
+	for i := 0; i < d.Snapshots; i++ {
+		snap := &Snapshot{
+			ID:       m.newID("snap"),
+			VolumeID: v.ID,
+			SizeGiB:  v.SizeGiB,
+			State:    SnapshotStateCompleted,
+			Labels:   v.copy().Labels,
+			Created:  v.Created,
+		}
+		m.store.snapshots[snap.ID] = snap
+	}
+	return v
+}
+
+// Nodes returns the IDs of the nodes of the cluster, sorted
+func (c *Cluster) Nodes() []string {
+	return append([]string(nil), c.ids...)
+}
+
+// Node returns the driver of the given node, nil if it is not in the cluster
+func (c *Cluster) Node(instance string) *Ops {
+	return c.nodes[instance]
+}
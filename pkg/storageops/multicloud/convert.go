@@ -0,0 +1,69 @@
+package multicloud
+
+import (
+	"fmt"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+func init() {
+	storageops.RegisterConverter(Name, &converter{})
+}
+
+// converter converts *Objects, the volumes and snapshots of the members,
+// qualifying their IDs and adding their MemberLabel
+type converter struct{}
+
+// withMember returns a copy of the given labels with the MemberLabel of the
+// given member
+func withMember(labels map[string]string, member string) map[string]string {
+	copied := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		copied[k] = v
+	}
+	copied[MemberLabel] = member
+	return copied
+}
+
+func (c *converter) ToVolume(raw interface{}) (*storageops.Volume, error) {
+	obj, ok := raw.(*Object)
+	if !ok {
+		return nil, fmt.Errorf("invalid multicloud object %T", raw)
+	}
+	vol, ok := obj.Raw.(*storageops.Volume)
+	if !ok {
+		return nil, fmt.Errorf("invalid volume %T of member %s", obj.Raw, obj.Member)
+	}
+	return toVolume(obj, vol), nil
+}
+
+func toVolume(obj *Object, vol *storageops.Volume) *storageops.Volume {
+	copied := *vol
+	copied.ID = VolumeID(obj.Member, vol.ID)
+	copied.Labels = withMember(vol.Labels, obj.Member)
+	copied.Raw = obj
+	return &copied
+}
+
+func (c *converter) ToSnapshot(raw interface{}) (*storageops.Snapshot, error) {
+	obj, ok := raw.(*Object)
+	if !ok {
+		return nil, fmt.Errorf("invalid multicloud object %T", raw)
+	}
+	snap, ok := obj.Raw.(*storageops.Snapshot)
+	if !ok {
+		return nil, fmt.Errorf("invalid snapshot %T of member %s", obj.Raw, obj.Member)
+	}
+	return toSnapshot(obj, snap), nil
+}
+
+func toSnapshot(obj *Object, snap *storageops.Snapshot) *storageops.Snapshot {
+	copied := *snap
+	copied.ID = VolumeID(obj.Member, snap.ID)
+	if len(snap.VolumeID) > 0 {
+		copied.VolumeID = VolumeID(obj.Member, snap.VolumeID)
+	}
+	copied.Labels = withMember(snap.Labels, obj.Member)
+	copied.Raw = obj
+	return &copied
+}
@@ -0,0 +1,756 @@
+// Package multicloud implements a composite storage ops driver that wraps the
+// drivers of several clouds or regions, e.g. AWS in two regions or AWS and
+// GCE, so a single control plane manages the volumes of all of them. Creates
+// are routed by labels and zone, calls on volumes and snapshots by their ID,
+// which is qualified with the member that owns it. Enumerate, SnapshotEnumerate
+// and DeviceMappings fan out to all members.
+package multicloud
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+const (
+	// Name of the driver
+	Name = "multicloud"
+	// MemberLabel is the label carrying the member of a volume or snapshot.
+	// It is added to the labels of the returned volumes and snapshots, routes
+	// Create and filters Enumerate and SnapshotEnumerate, but it is never
+	// stored on the volumes and so not returned by Tags.
+	MemberLabel = "openstorage-cloud"
+	// idSeparator separates the member from the ID of the member driver in
+	// qualified IDs
+	idSeparator = ":"
+)
+
+// Member is a driver of the composite driver
+type Member struct {
+	// Name of the member, unique in the composite driver, e.g.
+	// aws-us-east-1. It must not contain colons.
+	Name string
+	// Ops is the driver of the member
+	Ops storageops.Ops
+	// Zones the member creates volumes in, defaults to the ListZones of its
+	// driver
+	Zones []string
+	// Labels route creates with all these labels to the member, e.g. a
+	// tier=archive label to the cheapest cloud. Nil matches no creates.
+	Labels map[string]string
+}
+
+// Template routes a native provider template to the given member, e.g. an
+// *ec2.Volume to an AWS member
+type Template struct {
+	// Member the volume is created in
+	Member string
+	// Template of the driver of the member
+	Template interface{}
+}
+
+// Object is the Raw of the volumes and snapshots returned by the composite
+// driver
+type Object struct {
+	// Member the object belongs to
+	Member string
+	// Driver is the name of the driver of the member
+	Driver string
+	// Raw is the *storageops.Volume or *storageops.Snapshot returned by the
+	// driver of the member
+	Raw interface{}
+}
+
+type multiOps struct {
+	members []*Member
+	byName  map[string]*Member
+	// zones are the zones of the members not configured with zones, listed
+	// once
+	zonesLock sync.Mutex
+	zones     map[string][]string
+}
+
+var _ storageops.Ops = &multiOps{}
+
+// NewClient creates a composite driver of the given members. The first
+// member is the default one: it creates volumes no label or zone routes
+// elsewhere, and its instance, zone and region are those of the composite
+// driver.
+func NewClient(members ...*Member) (storageops.Ops, error) {
+	if len(members) == 0 {
+		return nil, fmt.Errorf("at least one member is required")
+	}
+	m := &multiOps{
+		members: members,
+		byName:  make(map[string]*Member, len(members)),
+		zones:   make(map[string][]string),
+	}
+	for _, member := range members {
+		if len(member.Name) == 0 || strings.Contains(member.Name, idSeparator) {
+			return nil, fmt.Errorf("invalid member name %q", member.Name)
+		}
+		if member.Ops == nil {
+			return nil, fmt.Errorf("member %s has no driver", member.Name)
+		}
+		if _, ok := m.byName[member.Name]; ok {
+			return nil, fmt.Errorf("duplicate member %s", member.Name)
+		}
+		m.byName[member.Name] = member
+	}
+	return m, nil
+}
+
+// VolumeID returns the qualified ID of the given ID of a volume or snapshot
+// of the given member
+func VolumeID(member, id string) string {
+	return member + idSeparator + id
+}
+
+// ParseID returns the member and the ID of the member driver of the given
+// qualified volume or snapshot ID
+func ParseID(id string) (string, string, error) {
+	i := strings.Index(id, idSeparator)
+	if i <= 0 {
+		return "", "", storageops.NewStorageError(storageops.ErrVolNotFound,
+			fmt.Sprintf("%s is not the ID of a volume of a member", id), "")
+	}
+	return id[:i], id[i+len(idSeparator):], nil
+}
+
+// member returns the member and the member driver ID of the given qualified
+// ID
+func (m *multiOps) member(id string) (*Member, string, error) {
+	name, memberID, err := ParseID(id)
+	if err != nil {
+		return nil, "", err
+	}
+	member, ok := m.byName[name]
+	if !ok {
+		return nil, "", storageops.NewStorageError(storageops.ErrVolNotFound,
+			fmt.Sprintf("volume %s is of unknown member %s", id, name), "")
+	}
+	return member, memberID, nil
+}
+
+// memberError returns the given error of a member with its name, keeping
+// the error in the chain so storage error codes remain
+func memberError(member *Member, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s: %w", member.Name, err)
+}
+
+// wrap returns the object of the given member of the given volume or
+// snapshot of the member
+func wrap(member *Member, raw interface{}) *Object {
+	return &Object{Member: member.Name, Driver: member.Ops.Name(), Raw: raw}
+}
+
+// wrapVolumes returns the qualified volumes of the given volumes of the
+// given member
+func wrapVolumes(member *Member, vols []*storageops.Volume) []*storageops.Volume {
+	wrapped := make([]*storageops.Volume, 0, len(vols))
+	for _, vol := range vols {
+		wrapped = append(wrapped, toVolume(wrap(member, vol), vol))
+	}
+	return wrapped
+}
+
+// wrapSnapshots returns the qualified snapshots of the given snapshots of
+// the given member
+func wrapSnapshots(member *Member, snaps []*storageops.Snapshot) []*storageops.Snapshot {
+	wrapped := make([]*storageops.Snapshot, 0, len(snaps))
+	for _, snap := range snaps {
+		wrapped = append(wrapped, toSnapshot(wrap(member, snap), snap))
+	}
+	return wrapped
+}
+
+// fanOut calls f for every member in parallel, or only for the member of the
+// MemberLabel of the given labels if they have one, with the labels without
+// the MemberLabel. A MemberLabel without other labels is refused, as the
+// member would be called without labels and match all of its volumes, e.g.
+// deleting all of them in storageops.DeleteMatching. It returns the first
+// error of a member.
+func (m *multiOps) fanOut(
+	labels map[string]string,
+	f func(member *Member, labels map[string]string) error,
+) error {
+	members := m.members
+	if name, ok := labels[MemberLabel]; ok {
+		if len(labels) == 1 {
+			return storageops.NewStorageError(storageops.ErrVolInval,
+				fmt.Sprintf("member label %s=%s must be given with other labels", MemberLabel, name), "")
+		}
+		member, ok := m.byName[name]
+		if !ok {
+			return nil
+		}
+		members = []*Member{member}
+		labels = withoutMember(labels)
+	}
+	errs := make([]error, len(members))
+	storageops.ForEachParallel(len(members), 0, func(i int) {
+		errs[i] = memberError(members[i], f(members[i], labels))
+	})
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withoutMember returns a copy of the given labels without the MemberLabel
+func withoutMember(labels map[string]string) map[string]string {
+	if _, ok := labels[MemberLabel]; !ok {
+		return labels
+	}
+	copied := make(map[string]string, len(labels))
+	for k, v := range labels {
+		if k != MemberLabel {
+			copied[k] = v
+		}
+	}
+	return copied
+}
+
+// memberZones returns the zones of the given member
+func (m *multiOps) memberZones(member *Member) ([]string, error) {
+	if len(member.Zones) > 0 {
+		return member.Zones, nil
+	}
+	m.zonesLock.Lock()
+	defer m.zonesLock.Unlock()
+	if zones, ok := m.zones[member.Name]; ok {
+		return zones, nil
+	}
+	zones, err := member.Ops.ListZones()
+	if err != nil {
+		return nil, memberError(member, err)
+	}
+	m.zones[member.Name] = zones
+	return zones, nil
+}
+
+// zoneMember returns the member creating volumes in the given zone
+func (m *multiOps) zoneMember(zone string) (*Member, error) {
+	for _, member := range m.members {
+		// Members that can not list their zones only create volumes in
+		// the zones they are configured with
+		zones, err := m.memberZones(member)
+		if err != nil && !errors.Is(err, storageops.ErrNotSupported) {
+			return nil, err
+		}
+		for _, z := range zones {
+			if z == zone {
+				return member, nil
+			}
+		}
+	}
+	return nil, storageops.NewStorageError(storageops.ErrVolInval,
+		fmt.Sprintf("no member creates volumes in zone %s", zone), "")
+}
+
+// route returns the member the given Create goes to and its template and
+// labels for the driver of the member. The MemberLabel of the labels or of
+// the labels of a *storageops.VolumeSpec routes first, then a *Template,
+// then the zone of a *storageops.VolumeSpec and then the Labels of the
+// members. Creates nothing routes go to the default member.
+func (m *multiOps) route(
+	template interface{},
+	labels map[string]string,
+) (*Member, interface{}, map[string]string, error) {
+	name, routed := labels[MemberLabel]
+	labels = withoutMember(labels)
+	if t, ok := template.(*Template); ok {
+		if routed && name != t.Member {
+			return nil, nil, nil, storageops.NewStorageError(storageops.ErrVolInval,
+				fmt.Sprintf("template of member %s created with member label %s", t.Member, name), "")
+		}
+		name, routed, template = t.Member, true, t.Template
+	}
+	spec, isSpec := template.(*storageops.VolumeSpec)
+	if isSpec {
+		if n, ok := spec.Labels[MemberLabel]; ok && !routed {
+			name, routed = n, true
+		}
+		copied := *spec
+		copied.Labels = withoutMember(spec.Labels)
+		template = &copied
+	}
+
+	if routed {
+		member, ok := m.byName[name]
+		if !ok {
+			return nil, nil, nil, storageops.NewStorageError(storageops.ErrVolInval,
+				fmt.Sprintf("unknown member %s", name), "")
+		}
+		return member, template, labels, nil
+	}
+	if isSpec && len(spec.Zone) > 0 {
+		member, err := m.zoneMember(spec.Zone)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return member, template, labels, nil
+	}
+	all := make(map[string]string)
+	if isSpec {
+		for k, v := range spec.Labels {
+			all[k] = v
+		}
+	}
+	for k, v := range labels {
+		all[k] = v
+	}
+	for _, member := range m.members {
+		if member.Labels != nil && matches(all, member.Labels) {
+			return member, template, labels, nil
+		}
+	}
+	return m.members[0], template, labels, nil
+}
+
+// matches returns true if the given labels carry all the given selector
+// labels
+func matches(labels, selector map[string]string) bool {
+	for k, v := range selector {
+		if value, ok := labels[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *multiOps) Name() string { return Name }
+
+// InstanceID returns the instance of the default member. Volumes of other
+// members are attached to the instances of their drivers.
+func (m *multiOps) InstanceID() string { return m.members[0].Ops.InstanceID() }
+
+// GetZone returns the zone of the instance of the default member
+func (m *multiOps) GetZone() (string, error) {
+	zone, err := m.members[0].Ops.GetZone()
+	return zone, memberError(m.members[0], err)
+}
+
+// GetRegion returns the region of the instance of the default member
+func (m *multiOps) GetRegion() (string, error) {
+	region, err := m.members[0].Ops.GetRegion()
+	return region, memberError(m.members[0], err)
+}
+
+// ListZones returns the sorted zones of all members. Members that can not list
+// their zones contribute the zones they are configured with.
+func (m *multiOps) ListZones() ([]string, error) {
+	seen := make(map[string]bool)
+	for _, member := range m.members {
+		zones, err := m.memberZones(member)
+		if err != nil && !errors.Is(err, storageops.ErrNotSupported) {
+			return nil, err
+		}
+		for _, zone := range zones {
+			seen[zone] = true
+		}
+	}
+	zones := make([]string, 0, len(seen))
+	for zone := range seen {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	return zones, nil
+}
+
+// Create creates a volume in the member the template and labels are routed
+// to, see Template and MemberLabel. It returns the volume with a qualified
+// ID.
+func (m *multiOps) Create(
+	template interface{},
+	labels map[string]string,
+) (*storageops.Volume, error) {
+	member, template, labels, err := m.route(template, labels)
+	if err != nil {
+		return nil, err
+	}
+	vol, err := member.Ops.Create(template, labels)
+	if err != nil {
+		return nil, memberError(member, err)
+	}
+	return toVolume(wrap(member, vol), vol), nil
+}
+
+// GetDeviceID returns the qualified ID of the given volume, snapshot or
+// *Object
+func (m *multiOps) GetDeviceID(template interface{}) (string, error) {
+	if id, ok := storageops.TypedID(template); ok {
+		return id, nil
+	}
+	obj, ok := template.(*Object)
+	if !ok {
+		return "", fmt.Errorf("invalid type: %v given to GetDeviceID", template)
+	}
+	member, ok := m.byName[obj.Member]
+	if !ok {
+		return "", fmt.Errorf("object of unknown member %s given to GetDeviceID", obj.Member)
+	}
+	id, err := member.Ops.GetDeviceID(obj.Raw)
+	if err != nil {
+		return "", memberError(member, err)
+	}
+	return VolumeID(member.Name, id), nil
+}
+
+func (m *multiOps) Expand(volumeID string, newSizeGiB uint64) (uint64, error) {
+	member, id, err := m.member(volumeID)
+	if err != nil {
+		return 0, err
+	}
+	size, err := member.Ops.Expand(id, newSizeGiB)
+	return size, memberError(member, err)
+}
+
+func (m *multiOps) Modify(volumeID string, spec storageops.VolumeSpecUpdate) error {
+	member, id, err := m.member(volumeID)
+	if err != nil {
+		return err
+	}
+	return memberError(member, member.Ops.Modify(id, spec))
+}
+
+// Attach attaches the given volume to the instance of the driver of its
+// member
+func (m *multiOps) Attach(volumeID string, options map[string]string) (string, error) {
+	member, id, err := m.member(volumeID)
+	if err != nil {
+		return "", err
+	}
+	path, err := member.Ops.Attach(id, options)
+	return path, memberError(member, err)
+}
+
+func (m *multiOps) Detach(volumeID string) error {
+	member, id, err := m.member(volumeID)
+	if err != nil {
+		return err
+	}
+	return memberError(member, member.Ops.Detach(id))
+}
+
+// DetachFrom detaches the given volume from the given instance of the cloud
+// of its member
+func (m *multiOps) DetachFrom(volumeID, instanceID string) error {
+	member, id, err := m.member(volumeID)
+	if err != nil {
+		return err
+	}
+	return memberError(member, member.Ops.DetachFrom(id, instanceID))
+}
+
+func (m *multiOps) Delete(volumeID string) error {
+	member, id, err := m.member(volumeID)
+	if err != nil {
+		return err
+	}
+	return memberError(member, member.Ops.Delete(id))
+}
+
+func (m *multiOps) DeleteFrom(volumeID, instanceID string) error {
+	member, id, err := m.member(volumeID)
+	if err != nil {
+		return err
+	}
+	return memberError(member, member.Ops.DeleteFrom(id, instanceID))
+}
+
+// Describe returns the Describe of the instances of all members, by member
+// name
+func (m *multiOps) Describe() (interface{}, error) {
+	var lock sync.Mutex
+	described := make(map[string]interface{}, len(m.members))
+	err := m.fanOut(nil, func(member *Member, _ map[string]string) error {
+		out, err := member.Ops.Describe()
+		if err != nil {
+			return err
+		}
+		lock.Lock()
+		described[member.Name] = out
+		lock.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return described, nil
+}
+
+// FreeDevices returns the free devices of the instance of the default member
+func (m *multiOps) FreeDevices(
+	blockDeviceMappings []interface{},
+	rootDeviceName string,
+) ([]string, error) {
+	devices, err := m.members[0].Ops.FreeDevices(blockDeviceMappings, rootDeviceName)
+	return devices, memberError(m.members[0], err)
+}
+
+// Inspect returns the given volumes in the given order,
+// inspecting the volumes of each member with one call
+func (m *multiOps) Inspect(volumeIds []*string) ([]*storageops.Volume, error) {
+	byMember := make(map[*Member][]*string)
+	var order []*Member
+	for _, volumeID := range volumeIds {
+		member, id, err := m.member(*volumeID)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := byMember[member]; !ok {
+			order = append(order, member)
+		}
+		memberID := id
+		byMember[member] = append(byMember[member], &memberID)
+	}
+	found := make(map[string]*storageops.Volume, len(volumeIds))
+	for _, member := range order {
+		vols, err := member.Ops.Inspect(byMember[member])
+		if err != nil {
+			return nil, memberError(member, err)
+		}
+		for _, vol := range wrapVolumes(member, vols) {
+			found[vol.ID] = vol
+		}
+	}
+	vols := make([]*storageops.Volume, 0, len(volumeIds))
+	for _, id := range volumeIds {
+		if vol, ok := found[*id]; ok {
+			vols = append(vols, vol)
+		}
+	}
+	return vols, nil
+}
+
+// DeviceMappings returns the device mappings of the instances of all members
+// with qualified volume IDs
+func (m *multiOps) DeviceMappings() (map[string]string, error) {
+	var lock sync.Mutex
+	mappings := make(map[string]string)
+	err := m.fanOut(nil, func(member *Member, _ map[string]string) error {
+		memberMappings, err := member.Ops.DeviceMappings()
+		if err != nil {
+			return err
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		for path, id := range memberMappings {
+			mappings[path] = VolumeID(member.Name, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mappings, nil
+}
+
+// Enumerate returns the volumes of all members matching the
+// given qualified IDs and labels, merging the sets of the members. A
+// MemberLabel only enumerates the volumes of its member, it must be given
+// with other labels.
+func (m *multiOps) Enumerate(
+	volumeIds []*string,
+	labels map[string]string,
+	setIdentifier string,
+) (map[string][]*storageops.Volume, error) {
+	byMember := make(map[string][]*string)
+	for _, volumeID := range volumeIds {
+		member, id, err := m.member(*volumeID)
+		if err != nil {
+			return nil, err
+		}
+		memberID := id
+		byMember[member.Name] = append(byMember[member.Name], &memberID)
+	}
+	var lock sync.Mutex
+	sets := make(map[string][]*storageops.Volume)
+	err := m.fanOut(labels, func(member *Member, labels map[string]string) error {
+		ids, ok := byMember[member.Name]
+		if len(volumeIds) > 0 && !ok {
+			return nil
+		}
+		memberSets, err := member.Ops.Enumerate(ids, labels, setIdentifier)
+		if err != nil {
+			return err
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		for set, vols := range memberSets {
+			sets[set] = append(sets[set], wrapVolumes(member, vols)...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sets, nil
+}
+
+func (m *multiOps) DevicePath(volumeID string) (string, error) {
+	member, id, err := m.member(volumeID)
+	if err != nil {
+		return "", err
+	}
+	path, err := member.Ops.DevicePath(id)
+	return path, memberError(member, err)
+}
+
+// Snapshot takes a snapshot of the given volume in its member and returns
+// it with a qualified ID
+func (m *multiOps) Snapshot(volumeID string, readonly bool) (*storageops.Snapshot, error) {
+	member, id, err := m.member(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := member.Ops.Snapshot(id, readonly)
+	if err != nil {
+		return nil, memberError(member, err)
+	}
+	return toSnapshot(wrap(member, snap), snap), nil
+}
+
+func (m *multiOps) SnapshotDelete(snapID string) error {
+	member, id, err := m.member(snapID)
+	if err != nil {
+		return err
+	}
+	return memberError(member, member.Ops.SnapshotDelete(id))
+}
+
+// SnapshotEnumerate returns the snapshots of all members
+// matching the given filter, with a qualified volume ID. A MemberLabel of
+// the filter only enumerates the snapshots of its member, it must be given
+// with other labels.
+func (m *multiOps) SnapshotEnumerate(
+	filter *storageops.SnapshotFilter,
+) ([]*storageops.Snapshot, error) {
+	var (
+		labels   map[string]string
+		volumeOf string
+		volumeID string
+	)
+	if filter != nil {
+		labels = filter.Labels
+		if len(filter.VolumeID) > 0 {
+			member, id, err := m.member(filter.VolumeID)
+			if err != nil {
+				return nil, err
+			}
+			volumeOf, volumeID = member.Name, id
+		}
+	}
+	var lock sync.Mutex
+	var snaps []*storageops.Snapshot
+	err := m.fanOut(labels, func(member *Member, labels map[string]string) error {
+		if len(volumeOf) > 0 && volumeOf != member.Name {
+			return nil
+		}
+		var memberFilter *storageops.SnapshotFilter
+		if filter != nil {
+			copied := *filter
+			copied.VolumeID, copied.Labels = volumeID, labels
+			memberFilter = &copied
+		}
+		memberSnaps, err := member.Ops.SnapshotEnumerate(memberFilter)
+		if err != nil {
+			return err
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		snaps = append(snaps, wrapSnapshots(member, memberSnaps)...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snaps, nil
+}
+
+// SnapshotRestore restores the given snapshot in its member, the zone must
+// be empty or a zone of the member. It returns the volume with a qualified
+// ID.
+func (m *multiOps) SnapshotRestore(
+	snapID, zone string,
+	labels map[string]string,
+) (*storageops.Volume, error) {
+	member, id, err := m.member(snapID)
+	if err != nil {
+		return nil, err
+	}
+	if name, ok := labels[MemberLabel]; ok && name != member.Name {
+		return nil, storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("snapshot %s can not be restored in member %s", snapID, name), "")
+	}
+	vol, err := member.Ops.SnapshotRestore(id, zone, withoutMember(labels))
+	if err != nil {
+		return nil, memberError(member, err)
+	}
+	return toVolume(wrap(member, vol), vol), nil
+}
+
+func (m *multiOps) SnapshotStatus(snapID string) (*storageops.SnapshotStatus, error) {
+	member, id, err := m.member(snapID)
+	if err != nil {
+		return nil, err
+	}
+	status, err := member.Ops.SnapshotStatus(id)
+	if err != nil {
+		return nil, memberError(member, err)
+	}
+	copied := *status
+	copied.ID = VolumeID(member.Name, status.ID)
+	return &copied, nil
+}
+
+// ApplyTags applies the given labels to the given volume or snapshot. The
+// MemberLabel can not be changed, volumes never move between members.
+func (m *multiOps) ApplyTags(volumeID string, labels map[string]string) error {
+	member, id, err := m.member(volumeID)
+	if err != nil {
+		return err
+	}
+	if name, ok := labels[MemberLabel]; ok && name != member.Name {
+		return storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("volume %s can not be moved to member %s", volumeID, name), "")
+	}
+	labels = withoutMember(labels)
+	if len(labels) == 0 {
+		return nil
+	}
+	return memberError(member, member.Ops.ApplyTags(id, labels))
+}
+
+// RemoveTags removes the given labels from the given volume or snapshot. The
+// MemberLabel can not be removed.
+func (m *multiOps) RemoveTags(volumeID string, labels map[string]string) error {
+	member, id, err := m.member(volumeID)
+	if err != nil {
+		return err
+	}
+	labels = withoutMember(labels)
+	if len(labels) == 0 {
+		return nil
+	}
+	return memberError(member, member.Ops.RemoveTags(id, labels))
+}
+
+func (m *multiOps) Tags(volumeID string) (map[string]string, error) {
+	member, id, err := m.member(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := member.Ops.Tags(id)
+	return tags, memberError(member, err)
+}
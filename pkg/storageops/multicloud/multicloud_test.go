@@ -0,0 +1,217 @@
+package multicloud
+
+import (
+	"testing"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/libopenstorage/openstorage/pkg/storageops/mock"
+	"github.com/libopenstorage/openstorage/pkg/storageops/test"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestClient(t *testing.T) (storageops.Ops, *mock.Ops, *mock.Ops) {
+	east := mock.New("instance-east", "us-east-a")
+	west := mock.New("instance-west", "eu-west-a")
+	d, err := NewClient(
+		&Member{Name: "east", Ops: east},
+		&Member{Name: "west", Ops: west, Labels: map[string]string{"tier": "archive"}},
+	)
+	require.NoError(t, err)
+	return d, east, west
+}
+
+func TestAll(t *testing.T) {
+	d, _, _ := newTestClient(t)
+	test.RunTest(map[string]storageops.Ops{d.Name(): d},
+		map[string]map[string]interface{}{d.Name(): {
+			"multicloud-disk": &mock.Volume{SizeGiB: 10},
+		}}, t)
+}
+
+func TestNewClient(t *testing.T) {
+	_, err := NewClient()
+	require.Error(t, err)
+	_, err = NewClient(&Member{Name: "a:b", Ops: mock.New("i", "z")})
+	require.Error(t, err)
+	_, err = NewClient(&Member{Name: "a"})
+	require.Error(t, err)
+	_, err = NewClient(&Member{Name: "a", Ops: mock.New("i", "z")},
+		&Member{Name: "a", Ops: mock.New("i", "z")})
+	require.Error(t, err)
+}
+
+func TestRouting(t *testing.T) {
+	d, east, west := newTestClient(t)
+
+	zones, err := d.ListZones()
+	require.NoError(t, err)
+	require.Equal(t, []string{"eu-west-a", "us-east-a"}, zones)
+	zone, err := d.GetZone()
+	require.NoError(t, err)
+	require.Equal(t, "us-east-a", zone, "the default member is the first one")
+
+	for _, tc := range []struct {
+		name   string
+		spec   *storageops.VolumeSpec
+		labels map[string]string
+		member string
+		driver *mock.Ops
+	}{
+		{"default", &storageops.VolumeSpec{SizeGiB: 1}, nil, "east", east},
+		{"zone", &storageops.VolumeSpec{SizeGiB: 1, Zone: "eu-west-a"}, nil, "west", west},
+		{"member label", &storageops.VolumeSpec{SizeGiB: 1},
+			map[string]string{MemberLabel: "west"}, "west", west},
+		{"spec member label", &storageops.VolumeSpec{SizeGiB: 1,
+			Labels: map[string]string{MemberLabel: "west"}}, nil, "west", west},
+		{"member labels", &storageops.VolumeSpec{SizeGiB: 1},
+			map[string]string{"tier": "archive"}, "west", west},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			vol, err := d.Create(tc.spec, tc.labels)
+			require.NoError(t, err)
+			member, id, err := ParseID(vol.ID)
+			require.NoError(t, err)
+			require.Equal(t, tc.member, member)
+			require.Equal(t, tc.member, vol.Labels[MemberLabel])
+			vols, err := tc.driver.Inspect([]*string{&id})
+			require.NoError(t, err)
+			require.Len(t, vols, 1)
+			tags, err := tc.driver.Tags(id)
+			require.NoError(t, err)
+			require.NotContains(t, tags, MemberLabel, "the member label must not be stored")
+			require.NoError(t, d.Delete(vol.ID))
+		})
+	}
+
+	vol, err := d.Create(&Template{Member: "west", Template: &mock.Volume{SizeGiB: 1}}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "west", vol.Raw.(*Object).Member)
+	require.IsType(t, &mock.Volume{}, vol.Raw.(*Object).Raw.(*storageops.Volume).Raw)
+
+	_, err = d.Create(&storageops.VolumeSpec{SizeGiB: 1, Zone: "ap-south-a"}, nil)
+	require.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval))
+	_, err = d.Create(&mock.Volume{SizeGiB: 1}, map[string]string{MemberLabel: "north"})
+	require.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval))
+	_, err = d.Create(&Template{Member: "east", Template: &mock.Volume{SizeGiB: 1}},
+		map[string]string{MemberLabel: "west"})
+	require.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval))
+}
+
+func TestVolumes(t *testing.T) {
+	d, _, west := newTestClient(t)
+
+	eastVol, err := d.Create(&storageops.VolumeSpec{SizeGiB: 1},
+		map[string]string{"app": "db"})
+	require.NoError(t, err)
+	westVol, err := d.Create(&storageops.VolumeSpec{SizeGiB: 2, Zone: "eu-west-a"},
+		map[string]string{"app": "db"})
+	require.NoError(t, err)
+
+	vols, err := d.Inspect([]*string{&westVol.ID, &eastVol.ID})
+	require.NoError(t, err)
+	require.Len(t, vols, 2)
+	require.Equal(t, westVol.ID, vols[0].ID, "inspect must keep the order of the IDs")
+	require.Equal(t, eastVol.ID, vols[1].ID)
+
+	sets, err := d.Enumerate(nil, map[string]string{"app": "db"}, "")
+	require.NoError(t, err)
+	require.Len(t, sets[storageops.SetIdentifierNone], 2, "enumerate must fan out to all members")
+	sets, err = d.Enumerate(nil, map[string]string{"app": "db", MemberLabel: "west"}, "")
+	require.NoError(t, err)
+	require.Len(t, sets[storageops.SetIdentifierNone], 1)
+	id, err := d.GetDeviceID(sets[storageops.SetIdentifierNone][0])
+	require.NoError(t, err)
+	require.Equal(t, westVol.ID, id)
+	sets, err = d.Enumerate([]*string{&eastVol.ID}, nil, "")
+	require.NoError(t, err)
+	require.Len(t, sets[storageops.SetIdentifierNone], 1)
+
+	require.NoError(t, d.ApplyTags(westVol.ID, map[string]string{"app": "web", MemberLabel: "west"}))
+	tags, err := d.Tags(westVol.ID)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"app": "web"}, tags)
+	err = d.ApplyTags(westVol.ID, map[string]string{MemberLabel: "east"})
+	require.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval))
+
+	devicePath, err := d.Attach(westVol.ID, nil)
+	require.NoError(t, err)
+	mappings, err := d.DeviceMappings()
+	require.NoError(t, err)
+	require.Equal(t, westVol.ID, mappings[devicePath])
+	_, westID, err := ParseID(westVol.ID)
+	require.NoError(t, err)
+	instance, _, _, err := west.Attached(westID)
+	require.NoError(t, err)
+	require.Equal(t, "instance-west", instance, "volumes attach to the instance of their member")
+	require.NoError(t, d.Detach(westVol.ID))
+
+	snap, err := d.Snapshot(westVol.ID, false)
+	require.NoError(t, err)
+	require.Equal(t, westVol.ID, snap.VolumeID)
+	require.Equal(t, "west", snap.Labels[MemberLabel])
+	status, err := d.SnapshotStatus(snap.ID)
+	require.NoError(t, err)
+	require.Equal(t, snap.ID, status.ID)
+	snaps, err := d.SnapshotEnumerate(&storageops.SnapshotFilter{VolumeID: westVol.ID})
+	require.NoError(t, err)
+	require.Len(t, snaps, 1)
+	snaps, err = d.SnapshotEnumerate(&storageops.SnapshotFilter{
+		Labels: map[string]string{MemberLabel: "east", "app": "db"}})
+	require.NoError(t, err)
+	require.Empty(t, snaps)
+	_, err = d.SnapshotEnumerate(&storageops.SnapshotFilter{
+		Labels: map[string]string{MemberLabel: "west"}})
+	require.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval),
+		"a member label alone must not enumerate all snapshots of the member")
+	restored, err := d.SnapshotRestore(snap.ID, "", nil)
+	require.NoError(t, err)
+	require.Equal(t, "west", restored.Labels[MemberLabel])
+	_, err = d.SnapshotRestore(snap.ID, "", map[string]string{MemberLabel: "east"})
+	require.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval))
+
+	for _, id := range []string{restored.ID, westVol.ID, eastVol.ID} {
+		require.NoError(t, d.Delete(id))
+	}
+	require.NoError(t, d.SnapshotDelete(snap.ID))
+	_, err = d.Inspect([]*string{&westVol.ID})
+	require.True(t, storageops.IsErrorCode(err, storageops.ErrVolNotFound),
+		"storage errors of members must keep their code")
+	_, err = d.Inspect([]*string{&westID})
+	require.True(t, storageops.IsErrorCode(err, storageops.ErrVolNotFound))
+}
+
+func TestDeleteMatchingMemberLabel(t *testing.T) {
+	d, _, _ := newTestClient(t)
+
+	var ids []string
+	for _, zone := range []string{"us-east-a", "eu-west-a"} {
+		vol, err := d.Create(&storageops.VolumeSpec{SizeGiB: 1, Zone: zone},
+			map[string]string{"app": "db"})
+		require.NoError(t, err)
+		ids = append(ids, vol.ID)
+		_, err = d.Snapshot(vol.ID, false)
+		require.NoError(t, err)
+	}
+
+	for _, name := range []string{"east", "west"} {
+		labels := map[string]string{MemberLabel: name}
+		_, err := storageops.DeleteMatching(d, labels, false, nil)
+		require.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval), "%v", err)
+		_, err = storageops.SnapshotDeleteMatching(d, labels, 0)
+		require.True(t, storageops.IsErrorCode(err, storageops.ErrVolInval), "%v", err)
+	}
+
+	sets, err := d.Enumerate(nil, map[string]string{"app": "db"}, "")
+	require.NoError(t, err)
+	require.Len(t, sets[storageops.SetIdentifierNone], 2, "no volume may be deleted")
+	snaps, err := d.SnapshotEnumerate(nil)
+	require.NoError(t, err)
+	require.Len(t, snaps, 2, "no snapshot may be deleted")
+
+	results, err := storageops.DeleteMatching(d,
+		map[string]string{MemberLabel: "west", "app": "db"}, false, nil)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Equal(t, ids[1], results[0].VolumeID)
+	require.NoError(t, results[0].Err)
+}
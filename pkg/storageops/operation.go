@@ -0,0 +1,224 @@
+package storageops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// OperationState is the provider independent state of a long-running
+// operation
+type OperationState string
+
+const (
+	// OperationRunning is the state of an operation that is in progress
+	OperationRunning OperationState = "running"
+	// OperationSucceeded is the state of an operation that completed
+	OperationSucceeded OperationState = "succeeded"
+	// OperationFailed is the state of an operation that will never complete
+	OperationFailed OperationState = "failed"
+	// OperationCanceled is the state of an operation canceled by Cancel
+	OperationCanceled OperationState = "canceled"
+)
+
+// ErrOperationCanceled is returned by Wait of canceled operations
+var ErrOperationCanceled = errors.New("operation canceled")
+
+// OperationStatus is the status of a long-running operation
+type OperationStatus struct {
+	// State of the operation
+	State OperationState
+	// Progress is the completion percentage, 100 when succeeded
+	Progress int
+	// Message is the provider's status or reason for a failure, if any
+	Message string
+}
+
+// Done returns true if the operation will make no further progress
+func (s *OperationStatus) Done() bool {
+	return s.State != OperationRunning
+}
+
+// Operation is the handle of a long-running operation, e.g. a snapshot, a
+// volume modification or a cross-region copy, so callers can poll it or show
+// its progress instead of blocking until it completes
+type Operation interface {
+	// ID of the operation, the ID of the resource it creates or modifies,
+	// e.g. the snapshot ID
+	ID() string
+	// Status returns the current status of the operation
+	Status() (*OperationStatus, error)
+	// Wait waits until the operation is done or ctx is done. It returns the
+	// result of the operation documented by the call that started it, or
+	// the error the operation failed with.
+	Wait(ctx context.Context) (interface{}, error)
+	// Cancel cancels the operation. It returns ErrNotSupported if the
+	// operation can not be canceled.
+	Cancel() error
+}
+
+// OperationPoll returns the status of an operation and, once it succeeded,
+// its result
+type OperationPoll func() (status *OperationStatus, result interface{}, err error)
+
+type polledOperation struct {
+	sync.Mutex
+	id       string
+	poll     OperationPoll
+	cancel   func() error
+	canceled bool
+}
+
+// NewOperation returns an operation with the given ID whose status is
+// polled with the given function. Cancel calls the given cancel function,
+// nil if the operation can not be canceled.
+func NewOperation(id string, poll OperationPoll, cancel func() error) Operation {
+	return &polledOperation{
+		id:     id,
+		poll:   poll,
+		cancel: cancel,
+	}
+}
+
+func (o *polledOperation) ID() string { return o.id }
+
+func (o *polledOperation) Status() (*OperationStatus, error) {
+	status, _, err := o.status()
+	return status, err
+}
+
+func (o *polledOperation) status() (*OperationStatus, interface{}, error) {
+	o.Lock()
+	canceled := o.canceled
+	o.Unlock()
+	if canceled {
+		return &OperationStatus{State: OperationCanceled}, nil, nil
+	}
+	return o.poll()
+}
+
+// Wait polls the operation, every MinPollInterval at first and backing off
+// to MaxPollInterval. Errors polling the status are retried until ctx is
+// done.
+func (o *polledOperation) Wait(ctx context.Context) (interface{}, error) {
+	interval := MinPollInterval
+	for {
+		status, result, err := o.status()
+		if err == nil {
+			switch status.State {
+			case OperationSucceeded:
+				return result, nil
+			case OperationFailed:
+				return nil, fmt.Errorf("operation %s failed: %s", o.id, status.Message)
+			case OperationCanceled:
+				return nil, ErrOperationCanceled
+			}
+		}
+		select {
+		case <-ctx.Done():
+			if err != nil {
+				return nil, fmt.Errorf("%v waiting for operation %s: %v", ctx.Err(), o.id, err)
+			}
+			return nil, fmt.Errorf("%v waiting for operation %s: %d%% done",
+				ctx.Err(), o.id, status.Progress)
+		case <-time.After(interval):
+		}
+		if interval *= 2; interval > MaxPollInterval {
+			interval = MaxPollInterval
+		}
+	}
+}
+
+func (o *polledOperation) Cancel() error {
+	if o.cancel == nil {
+		return ErrNotSupported
+	}
+	if err := o.cancel(); err != nil {
+		return err
+	}
+	o.Lock()
+	o.canceled = true
+	o.Unlock()
+	return nil
+}
+
+// NewBackgroundOperation runs the given blocking call in the background and
+// returns its operation, for drivers that can not report the progress of an
+// operation. The operation is running until the call returned and can not be
+// canceled, Wait returns the result of the call.
+func NewBackgroundOperation(id string, call func() (interface{}, error)) Operation {
+	var (
+		lock   sync.Mutex
+		done   bool
+		result interface{}
+		err    error
+	)
+	go func() {
+		r, e := call()
+		lock.Lock()
+		done, result, err = true, r, e
+		lock.Unlock()
+	}()
+	return NewOperation(id, func() (*OperationStatus, interface{}, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		switch {
+		case !done:
+			return &OperationStatus{State: OperationRunning}, nil, nil
+		case err != nil:
+			return &OperationStatus{State: OperationFailed, Message: err.Error()}, nil, nil
+		}
+		return &OperationStatus{State: OperationSucceeded, Progress: 100}, result, nil
+	}, nil)
+}
+
+// SnapshotOperation returns the operation of the given snapshot, polling its
+// SnapshotStatus. Wait returns the *SnapshotStatus of the completed snapshot,
+// Cancel deletes the snapshot.
+func SnapshotOperation(ops Ops, snapID string) Operation {
+	return NewOperation(snapID, func() (*OperationStatus, interface{}, error) {
+		status, err := ops.SnapshotStatus(snapID)
+		if err != nil {
+			return nil, nil, err
+		}
+		return status.OperationStatus(), status, nil
+	}, func() error {
+		return ops.SnapshotDelete(snapID)
+	})
+}
+
+// SnapshotAsync takes a snapshot of the given volume and returns its
+// operation without waiting for it to complete, see SnapshotOperation
+func SnapshotAsync(ops Ops, volumeID string, readonly bool) (Operation, error) {
+	snap, err := ops.Snapshot(volumeID, readonly)
+	if err != nil {
+		return nil, err
+	}
+	return SnapshotOperation(ops, snap.ID), nil
+}
+
+// AsyncModifier is implemented by drivers that report the progress of volume
+// modifications
+type AsyncModifier interface {
+	// ModifyAsync starts the given modification of the given volume and
+	// returns its operation without waiting for it to complete. The
+	// operation succeeds once the modified volume is usable, Wait returns
+	// nil.
+	ModifyAsync(volumeID string, spec VolumeSpecUpdate) (Operation, error)
+}
+
+// ModifyAsync starts the given modification of the given volume and returns
+// its operation, see AsyncModifier. For drivers that are not an
+// AsyncModifier Modify is run in the background, see
+// NewBackgroundOperation. Wrappers like middlewares hide the AsyncModifier
+// of the driver they wrap, see AttachBatch.
+func ModifyAsync(ops Ops, volumeID string, spec VolumeSpecUpdate) (Operation, error) {
+	if m, ok := ops.(AsyncModifier); ok {
+		return m.ModifyAsync(volumeID, spec)
+	}
+	return NewBackgroundOperation(volumeID, func() (interface{}, error) {
+		return nil, ops.Modify(volumeID, spec)
+	}), nil
+}
@@ -0,0 +1,257 @@
+package oracle
+
+import (
+	"context"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+
+	"github.com/oracle/oci-go-sdk/common"
+	"github.com/oracle/oci-go-sdk/core"
+	"github.com/oracle/oci-go-sdk/identity"
+)
+
+// listPageSize is the number of items requested per page of list calls
+const listPageSize = 100
+
+// APIKey is the API signing key of an OCI user
+type APIKey struct {
+	// TenancyID is the OCID of the tenancy
+	TenancyID string
+	// UserID is the OCID of the user
+	UserID string
+	// Fingerprint is the fingerprint of the public key uploaded for the user
+	Fingerprint string
+	// PrivateKey is the private key of the API key
+	PrivateKey *rsa.PrivateKey
+}
+
+// ParsePrivateKey parses a PEM encoded PKCS#1 or PKCS#8 RSA private key
+func ParsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	key, err := common.PrivateKeyFromBytesWithPassword(data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %v", err)
+	}
+	return key, nil
+}
+
+// keyProvider is the SDK configuration provider of an API key in a region,
+// the SDK signs the requests with it
+type keyProvider struct {
+	key    *APIKey
+	region string
+}
+
+var _ common.ConfigurationProvider = &keyProvider{}
+
+func (p *keyProvider) TenancyOCID() (string, error) { return p.key.TenancyID, nil }
+
+func (p *keyProvider) UserOCID() (string, error) { return p.key.UserID, nil }
+
+func (p *keyProvider) KeyFingerprint() (string, error) { return p.key.Fingerprint, nil }
+
+func (p *keyProvider) Region() (string, error) { return p.region, nil }
+
+func (p *keyProvider) KeyID() (string, error) {
+	return fmt.Sprintf("%s/%s/%s", p.key.TenancyID, p.key.UserID, p.key.Fingerprint), nil
+}
+
+func (p *keyProvider) PrivateRSAKey() (*rsa.PrivateKey, error) {
+	if p.key.PrivateKey == nil {
+		return nil, fmt.Errorf("the API key has no private key")
+	}
+	return p.key.PrivateKey, nil
+}
+
+// client are the SDK clients of the block volume, compute and identity
+// calls of the driver
+type client struct {
+	blockstorage core.BlockstorageClient
+	compute      core.ComputeClient
+	identity     identity.IdentityClient
+}
+
+// newClient returns the SDK clients of the given region authenticated with
+// the given API key. The core endpoint replaces that of the block volume
+// and compute services if set.
+func newClient(
+	key *APIKey,
+	region, coreEndpoint, identityEndpoint string,
+	httpClient *http.Client,
+) (*client, error) {
+	provider := &keyProvider{key: key, region: region}
+	blockstorage, err := core.NewBlockstorageClientWithConfigurationProvider(provider)
+	if err != nil {
+		return nil, err
+	}
+	compute, err := core.NewComputeClientWithConfigurationProvider(provider)
+	if err != nil {
+		return nil, err
+	}
+	ident, err := identity.NewIdentityClientWithConfigurationProvider(provider)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range []*common.BaseClient{
+		&blockstorage.BaseClient, &compute.BaseClient, &ident.BaseClient,
+	} {
+		c.HTTPClient = httpClient
+	}
+	if len(coreEndpoint) > 0 {
+		blockstorage.Host = coreEndpoint
+		compute.Host = coreEndpoint
+	}
+	if len(identityEndpoint) > 0 {
+		ident.Host = identityEndpoint
+	}
+	return &client{blockstorage: blockstorage, compute: compute, identity: ident}, nil
+}
+
+func (c *client) getVolume(id string) (*core.Volume, error) {
+	resp, err := c.blockstorage.GetVolume(context.Background(),
+		core.GetVolumeRequest{VolumeId: common.String(id)})
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Volume, nil
+}
+
+func (c *client) listVolumes(compartmentID string, fn func(vol *core.Volume) bool) error {
+	req := core.ListVolumesRequest{
+		CompartmentId: common.String(compartmentID),
+		Limit:         common.Int(listPageSize),
+	}
+	for {
+		resp, err := c.blockstorage.ListVolumes(context.Background(), req)
+		if err != nil {
+			return err
+		}
+		for i := range resp.Items {
+			if !fn(&resp.Items[i]) {
+				return nil
+			}
+		}
+		if resp.OpcNextPage == nil {
+			return nil
+		}
+		req.Page = resp.OpcNextPage
+	}
+}
+
+// listVolumeAttachments returns the attachments of the compartment, filtered
+// by instance and volume if not empty
+func (c *client) listVolumeAttachments(
+	compartmentID, instanceID, volumeID string,
+) ([]core.VolumeAttachment, error) {
+	req := core.ListVolumeAttachmentsRequest{
+		CompartmentId: common.String(compartmentID),
+		Limit:         common.Int(listPageSize),
+	}
+	if len(instanceID) > 0 {
+		req.InstanceId = common.String(instanceID)
+	}
+	if len(volumeID) > 0 {
+		req.VolumeId = common.String(volumeID)
+	}
+	var atts []core.VolumeAttachment
+	for {
+		resp, err := c.compute.ListVolumeAttachments(context.Background(), req)
+		if err != nil {
+			return nil, err
+		}
+		atts = append(atts, resp.Items...)
+		if resp.OpcNextPage == nil {
+			return atts, nil
+		}
+		req.Page = resp.OpcNextPage
+	}
+}
+
+func (c *client) getVolumeBackup(id string) (*core.VolumeBackup, error) {
+	resp, err := c.blockstorage.GetVolumeBackup(context.Background(),
+		core.GetVolumeBackupRequest{VolumeBackupId: common.String(id)})
+	if err != nil {
+		return nil, err
+	}
+	return &resp.VolumeBackup, nil
+}
+
+func (c *client) listVolumeBackups(
+	compartmentID string,
+	fn func(backup *core.VolumeBackup) bool,
+) error {
+	req := core.ListVolumeBackupsRequest{
+		CompartmentId: common.String(compartmentID),
+		Limit:         common.Int(listPageSize),
+	}
+	for {
+		resp, err := c.blockstorage.ListVolumeBackups(context.Background(), req)
+		if err != nil {
+			return err
+		}
+		for i := range resp.Items {
+			if !fn(&resp.Items[i]) {
+				return nil
+			}
+		}
+		if resp.OpcNextPage == nil {
+			return nil
+		}
+		req.Page = resp.OpcNextPage
+	}
+}
+
+// listInstanceDevices returns the consistent device paths of the given
+// instance that are not in use
+func (c *client) listInstanceDevices(id string) ([]core.Device, error) {
+	req := core.ListInstanceDevicesRequest{
+		InstanceId:  common.String(id),
+		IsAvailable: common.Bool(true),
+		Limit:       common.Int(listPageSize),
+	}
+	var devices []core.Device
+	for {
+		resp, err := c.compute.ListInstanceDevices(context.Background(), req)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, resp.Items...)
+		if resp.OpcNextPage == nil {
+			return devices, nil
+		}
+		req.Page = resp.OpcNextPage
+	}
+}
+
+// listAvailabilityDomains returns the names of the availability domains of
+// the region
+func (c *client) listAvailabilityDomains(tenancyID string) ([]string, error) {
+	resp, err := c.identity.ListAvailabilityDomains(context.Background(),
+		identity.ListAvailabilityDomainsRequest{CompartmentId: common.String(tenancyID)})
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(resp.Items))
+	for _, d := range resp.Items {
+		names = append(names, stringValue(d.Name))
+	}
+	return names, nil
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func int64Value(i *int64) int64 {
+	if i == nil {
+		return 0
+	}
+	return *i
+}
+
+func boolValue(b *bool) bool {
+	return b != nil && *b
+}
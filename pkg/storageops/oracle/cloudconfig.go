@@ -0,0 +1,98 @@
+package oracle
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/libopenstorage/openstorage/pkg/storageops/config"
+)
+
+func init() {
+	config.Register("oracle", newFromCloudConfig)
+}
+
+// newFromCloudConfig creates the driver of the given cloud config with the
+// API key of its tenancyID, userID, fingerprint and PEM encoded privateKey
+// credentials, read from the environment vars of NewEnvClient if not set.
+// The instance, the compartment of the compartmentID param, the availability
+// domain of the zone and the region are read from the instance metadata
+// service unless all of them are set. The attachmentType, endpoint and
+// identityEndpoint params and the comma separated definedTagNamespaces param
+// set the driver config.
+func newFromCloudConfig(c *config.Config) (storageops.Ops, error) {
+	key := &APIKey{}
+	for _, f := range []struct {
+		name, env string
+		field     *string
+	}{
+		{"tenancyID", "OCI_TENANCY_OCID", &key.TenancyID},
+		{"userID", "OCI_USER_OCID", &key.UserID},
+		{"fingerprint", "OCI_FINGERPRINT", &key.Fingerprint},
+	} {
+		v, err := c.RequiredCredential(f.name, f.env)
+		if err != nil {
+			return nil, err
+		}
+		*f.field = v
+	}
+	privateKey, ok, err := c.Credential("privateKey")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		path, err := c.RequiredCredential("privateKey", "OCI_PRIVATE_KEY_PATH")
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key: %v", err)
+		}
+		privateKey = string(data)
+	}
+	if key.PrivateKey, err = ParsePrivateKey([]byte(privateKey)); err != nil {
+		return nil, err
+	}
+
+	cfg := Config{
+		AttachmentType:   c.Params["attachmentType"],
+		Endpoint:         c.Params["endpoint"],
+		IdentityEndpoint: c.Params["identityEndpoint"],
+	}
+	if namespaces := c.Params["definedTagNamespaces"]; len(namespaces) > 0 {
+		cfg.DefinedTagNamespaces = strings.Split(namespaces, ",")
+	}
+	if cfg.RetryPolicy, err = c.RetryPolicy(); err != nil {
+		return nil, err
+	}
+	if cfg.HTTP, err = c.HTTPConfig(); err != nil {
+		return nil, err
+	}
+
+	i := &instance{
+		id:                 c.Instance,
+		compartmentID:      c.Params["compartmentID"],
+		availabilityDomain: c.Zone,
+		region:             c.Region,
+	}
+	if len(i.id) == 0 || len(i.compartmentID) == 0 ||
+		len(i.availabilityDomain) == 0 || len(i.region) == 0 {
+		md := &instance{}
+		if err := ociInfo(md); err != nil {
+			return nil, fmt.Errorf("error fetching instance info. Err: %v", err)
+		}
+		for _, f := range []struct{ field, value *string }{
+			{&i.id, &md.id},
+			{&i.compartmentID, &md.compartmentID},
+			{&i.availabilityDomain, &md.availabilityDomain},
+			{&i.region, &md.region},
+		} {
+			if len(*f.field) == 0 {
+				*f.field = *f.value
+			}
+		}
+	}
+	return NewClient(i.id, i.compartmentID, i.availabilityDomain, i.region, key, cfg)
+}
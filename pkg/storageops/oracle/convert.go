@@ -0,0 +1,117 @@
+package oracle
+
+import (
+	"fmt"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/oracle/oci-go-sdk/common"
+	"github.com/oracle/oci-go-sdk/core"
+)
+
+func init() {
+	storageops.RegisterConverter("oracle", &converter{})
+	// Block volumes are 50 GB to 32 TB in whole GB, which OCI means as GiB
+	storageops.RegisterSizeRule("oracle", &storageops.SizeRule{
+		Granularity: storageops.GiB,
+		Min:         50 * storageops.GiB,
+		Max:         32 * storageops.TiB,
+	})
+}
+
+type converter struct{}
+
+func (c *converter) ToVolume(raw interface{}) (*storageops.Volume, error) {
+	vol, ok := raw.(*core.Volume)
+	if !ok {
+		return nil, fmt.Errorf("invalid oracle volume %T", raw)
+	}
+	return toVolume(vol), nil
+}
+
+func toVolume(vol *core.Volume) *storageops.Volume {
+	v := &storageops.Volume{
+		ID:      stringValue(vol.Id),
+		Name:    stringValue(vol.DisplayName),
+		SizeGiB: uint64(int64Value(vol.SizeInGBs)),
+		Zone:    stringValue(vol.AvailabilityDomain),
+		State:   string(vol.LifecycleState),
+		// All volumes are encrypted at rest, with an Oracle managed key if
+		// no KMS key is set
+		Encrypted: true,
+		Labels:    tagsToLabels(vol.FreeformTags, vol.DefinedTags),
+		Raw:       vol,
+	}
+	for level, vpus := range vpusPerGBTypes {
+		if vpus == int64Value(vol.VpusPerGB) {
+			v.Type = level
+		}
+	}
+	return v
+}
+
+// FromSpec returns the *core.CreateVolumeDetails template of the given spec. The
+// type is the performance level of vpusPerGBTypes and the zone is the
+// availability domain. Volumes are always encrypted at rest.
+func (c *converter) FromSpec(spec *storageops.VolumeSpec) (interface{}, error) {
+	if err := spec.Unsupported("oracle", storageops.SpecFieldIops,
+		storageops.SpecFieldThroughput); err != nil {
+		return nil, err
+	}
+	details := &core.CreateVolumeDetails{}
+	if spec.Native != nil {
+		native, ok := spec.Native.(*core.CreateVolumeDetails)
+		if !ok {
+			return nil, storageops.NativeTemplateError("oracle", spec.Native)
+		}
+		copied := *native
+		details = &copied
+	}
+	if spec.SizeGiB > 0 {
+		details.SizeInGBs = common.Int64(int64(spec.SizeGiB))
+	}
+	if len(spec.Type) > 0 {
+		vpus, ok := vpusPerGBTypes[spec.Type]
+		// Volumes are created balanced if no performance units are given,
+		// lower volumes can only be made by Modify
+		if !ok || vpus == 0 {
+			return nil, storageops.NewStorageError(storageops.ErrVolInval,
+				fmt.Sprintf("oracle volumes can not be created with type %q", spec.Type), "")
+		}
+		details.VpusPerGB = common.Int64(vpus)
+	}
+	if len(spec.Zone) > 0 {
+		details.AvailabilityDomain = common.String(spec.Zone)
+	}
+	if len(spec.KMSKeyID) > 0 {
+		details.KmsKeyId = common.String(spec.KMSKeyID)
+	}
+	if len(spec.SnapshotID) > 0 {
+		details.SourceDetails = core.VolumeSourceFromVolumeBackupDetails{
+			Id: common.String(spec.SnapshotID),
+		}
+	}
+	return details, nil
+}
+
+func (c *converter) ToSnapshot(raw interface{}) (*storageops.Snapshot, error) {
+	backup, ok := raw.(*core.VolumeBackup)
+	if !ok {
+		return nil, fmt.Errorf("invalid oracle volume backup %T", raw)
+	}
+	return toSnapshot(backup), nil
+}
+
+func toSnapshot(backup *core.VolumeBackup) *storageops.Snapshot {
+	s := &storageops.Snapshot{
+		ID:       stringValue(backup.Id),
+		VolumeID: stringValue(backup.VolumeId),
+		SizeGiB:  uint64(int64Value(backup.SizeInGBs)),
+		State:    string(backup.LifecycleState),
+		Labels:   tagsToLabels(backup.FreeformTags, backup.DefinedTags),
+		Raw:      backup,
+	}
+	if backup.TimeCreated != nil {
+		s.Created = backup.TimeCreated.Time
+	}
+	return s
+}
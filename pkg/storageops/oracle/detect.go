@@ -0,0 +1,15 @@
+package oracle
+
+import (
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+func init() {
+	storageops.RegisterDetector("oracle", &storageops.Detector{
+		Probe: func() error {
+			return storageops.ProbeHTTP(metadataEndpoint,
+				map[string]string{"Authorization": "Bearer Oracle"})
+		},
+		New: NewEnvClient,
+	})
+}
@@ -0,0 +1,49 @@
+package oracle
+
+import (
+	"net/http"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/oracle/oci-go-sdk/common"
+)
+
+// errorCodes maps the service error codes of OCI API errors to storage error
+// codes, they take precedence over the HTTP status
+var errorCodes = map[string]int{
+	"NotAuthorizedOrNotFound": storageops.ErrVolNotFound,
+	"NotFound":                storageops.ErrVolNotFound,
+	"TooManyRequests":         storageops.ErrThrottled,
+	"LimitExceeded":           storageops.ErrQuotaExceeded,
+	"QuotaExceeded":           storageops.ErrQuotaExceeded,
+	"NotAuthenticated":        storageops.ErrUnauthorized,
+	"NotAuthorized":           storageops.ErrUnauthorized,
+	"InvalidParameter":        storageops.ErrVolInval,
+	"MissingParameter":        storageops.ErrVolInval,
+	"IncorrectState":          storageops.ErrVolInval,
+}
+
+// errorStatuses maps the HTTP status of OCI API errors to storage error codes
+var errorStatuses = map[int]int{
+	http.StatusNotFound:        storageops.ErrVolNotFound,
+	http.StatusTooManyRequests: storageops.ErrThrottled,
+	http.StatusUnauthorized:    storageops.ErrUnauthorized,
+	http.StatusForbidden:       storageops.ErrUnauthorized,
+	http.StatusBadRequest:      storageops.ErrVolInval,
+	http.StatusConflict:        storageops.ErrVolInval,
+}
+
+// storageError maps an error returned by the OCI API to a storage error with
+// the matching code. Errors without a matching code are returned unchanged.
+func (s *ociOps) storageError(err error) error {
+	apiErr, ok := common.IsServiceError(err)
+	if !ok {
+		return err
+	}
+	if code, ok := errorCodes[apiErr.GetCode()]; ok {
+		return storageops.WrapError(code, err, s.inst.id)
+	}
+	if code, ok := errorStatuses[apiErr.GetHTTPStatusCode()]; ok {
+		return storageops.WrapError(code, err, s.inst.id)
+	}
+	return err
+}
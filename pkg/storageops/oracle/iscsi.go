@@ -0,0 +1,61 @@
+package oracle
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/oracle/oci-go-sdk/core"
+)
+
+// iscsiadm runs iscsiadm with the given arguments
+var iscsiadm = func(args ...string) error {
+	out, err := exec.Command("iscsiadm", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("iscsiadm %s failed: %v: %s",
+			strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// iscsiPortal returns the portal of the given iSCSI attachment
+func iscsiPortal(att *core.IScsiVolumeAttachment) string {
+	// 3260 is the well-known iSCSI port
+	port := 3260
+	if att.Port != nil {
+		port = *att.Port
+	}
+	return fmt.Sprintf("%s:%d", stringValue(att.Ipv4), port)
+}
+
+// iscsiLogin logs in to the target of the given iSCSI attachment, with the
+// commands the OCI console gives for the attachment
+func iscsiLogin(att *core.IScsiVolumeAttachment) error {
+	portal, iqn := iscsiPortal(att), stringValue(att.Iqn)
+	for _, args := range [][]string{
+		{"-m", "node", "-o", "new", "-T", iqn, "-p", portal},
+		{"-m", "node", "-o", "update", "-T", iqn, "-n", "node.startup", "-v", "automatic"},
+		{"-m", "node", "-T", iqn, "-p", portal, "-l"},
+	} {
+		if err := iscsiadm(args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// iscsiLogout logs out of the target of the given iSCSI attachment and
+// removes its node record
+func iscsiLogout(att *core.IScsiVolumeAttachment) error {
+	portal, iqn := iscsiPortal(att), stringValue(att.Iqn)
+	if err := iscsiadm("-m", "node", "-T", iqn, "-p", portal, "-u"); err != nil {
+		return err
+	}
+	return iscsiadm("-m", "node", "-o", "delete", "-T", iqn, "-p", portal)
+}
+
+// iscsiDevicePath returns the by-path symlink of the LUN of the given iSCSI
+// attachment
+func iscsiDevicePath(att *core.IScsiVolumeAttachment) string {
+	return fmt.Sprintf("%s/ip-%s-iscsi-%s-lun-1", diskByPathDir, iscsiPortal(att), stringValue(att.Iqn))
+}
@@ -0,0 +1,905 @@
+package oracle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/oracle/oci-go-sdk/common"
+	"github.com/oracle/oci-go-sdk/core"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// metadataEndpoint is the instance metadata service
+	metadataEndpoint = "http://169.254.169.254/opc/v2/instance/"
+	// AttachmentParavirtualized attaches volumes through the hypervisor
+	AttachmentParavirtualized = "paravirtualized"
+	// AttachmentISCSI attaches volumes over iSCSI, which the instance logs
+	// in to after the attachment
+	AttachmentISCSI = "iscsi"
+	// AttachOptionAttachmentType is the Attach option selecting the
+	// attachment type of the volume, AttachmentParavirtualized or
+	// AttachmentISCSI
+	AttachOptionAttachmentType = "attachment-type"
+)
+
+// diskByPathDir is the directory of the udev by-path symlinks of iSCSI LUNs
+var diskByPathDir = "/dev/disk/by-path"
+
+// vpusPerGBTypes are the volume performance levels Modify accepts as the
+// volume type, in volume performance units per GB
+var vpusPerGBTypes = map[string]int64{
+	"lower":    0,
+	"balanced": 10,
+	"higher":   20,
+	"ultra":    30,
+}
+
+// Config is the optional configuration of the OCI storage ops driver
+type Config struct {
+	// RetryPolicy configures the wait loops of the driver. The default
+	// retries every storageops.ProviderOpsRetryInterval.
+	RetryPolicy *storageops.RetryPolicy
+	// HTTP configures the HTTP client of the API calls, e.g. to go through
+	// a proxy
+	HTTP *storageops.HTTPConfig
+	// AttachmentType is the default attachment type of Attach, defaults to
+	// AttachmentParavirtualized
+	AttachmentType string
+	// DefinedTagNamespaces are the tag namespaces whose labels, keyed
+	// namespace.key, are stored as defined tags. Other labels are stored as
+	// freeform tags.
+	DefinedTagNamespaces []string
+	// Endpoint replaces the core services endpoint of the region
+	Endpoint string
+	// IdentityEndpoint replaces the identity service endpoint of the region
+	IdentityEndpoint string
+}
+
+// instance stores the metadata of the running OCI instance
+type instance struct {
+	id                 string
+	compartmentID      string
+	availabilityDomain string
+	region             string
+}
+
+type ociOps struct {
+	inst   *instance
+	client *client
+	key    *APIKey
+	cfg    Config
+	mutex  sync.Mutex
+}
+
+var _ storageops.Ops = &ociOps{}
+
+// NewEnvClient creates a new OCI operations client with the API key of the
+// OCI_TENANCY_OCID, OCI_USER_OCID, OCI_FINGERPRINT and OCI_PRIVATE_KEY_PATH
+// environment vars. The instance is read from OCI_INSTANCE_ID,
+// OCI_COMPARTMENT_OCID, OCI_AVAILABILITY_DOMAIN and OCI_REGION if set, or
+// else from the instance metadata service.
+func NewEnvClient() (storageops.Ops, error) {
+	key := &APIKey{}
+	var keyPath string
+	for env, field := range map[string]*string{
+		"OCI_TENANCY_OCID":     &key.TenancyID,
+		"OCI_USER_OCID":        &key.UserID,
+		"OCI_FINGERPRINT":      &key.Fingerprint,
+		"OCI_PRIVATE_KEY_PATH": &keyPath,
+	} {
+		v, err := storageops.GetEnvValueStrict(env)
+		if err != nil {
+			return nil, err
+		}
+		*field = v
+	}
+	data, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %v", err)
+	}
+	if key.PrivateKey, err = ParsePrivateKey(data); err != nil {
+		return nil, err
+	}
+	httpCfg, err := storageops.HTTPConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	i := &instance{}
+	if err := ociInfoFromEnv(i); err != nil {
+		if err := ociInfo(i); err != nil {
+			return nil, fmt.Errorf("error fetching instance info. Err: %v", err)
+		}
+	}
+	return NewClient(i.id, i.compartmentID, i.availabilityDomain, i.region, key,
+		Config{HTTP: httpCfg})
+}
+
+// NewClient creates a new OCI operations client for the given instance of the
+// given compartment, availability domain and region, e.g. us-ashburn-1,
+// authenticated with the given API key
+func NewClient(
+	instanceID, compartmentID, availabilityDomain, region string,
+	key *APIKey,
+	cfg Config,
+) (storageops.Ops, error) {
+	if err := cfg.RetryPolicy.Validate(); err != nil {
+		return nil, err
+	}
+	switch cfg.AttachmentType {
+	case "":
+		cfg.AttachmentType = AttachmentParavirtualized
+	case AttachmentParavirtualized, AttachmentISCSI:
+	default:
+		return nil, fmt.Errorf("invalid attachment type %q", cfg.AttachmentType)
+	}
+	httpClient, err := cfg.HTTP.Client()
+	if err != nil {
+		return nil, err
+	}
+	client, err := newClient(key, region, cfg.Endpoint, cfg.IdentityEndpoint, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	return &ociOps{
+		inst: &instance{
+			id:                 instanceID,
+			compartmentID:      compartmentID,
+			availabilityDomain: availabilityDomain,
+			region:             region,
+		},
+		client: client,
+		key:    key,
+		cfg:    cfg,
+	}, nil
+}
+
+// ociInfo fetches the instance from the instance metadata service
+func ociInfo(inst *instance) error {
+	req, err := http.NewRequest(http.MethodGet, metadataEndpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer Oracle")
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("metadata service returned %s", resp.Status)
+	}
+	var md struct {
+		ID                  string `json:"id"`
+		CompartmentID       string `json:"compartmentId"`
+		AvailabilityDomain  string `json:"availabilityDomain"`
+		CanonicalRegionName string `json:"canonicalRegionName"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&md); err != nil {
+		return err
+	}
+	inst.id = md.ID
+	inst.compartmentID = md.CompartmentID
+	inst.availabilityDomain = md.AvailabilityDomain
+	inst.region = md.CanonicalRegionName
+	return nil
+}
+
+func ociInfoFromEnv(inst *instance) error {
+	for env, field := range map[string]*string{
+		"OCI_INSTANCE_ID":         &inst.id,
+		"OCI_COMPARTMENT_OCID":    &inst.compartmentID,
+		"OCI_AVAILABILITY_DOMAIN": &inst.availabilityDomain,
+		"OCI_REGION":              &inst.region,
+	} {
+		v, err := storageops.GetEnvValueStrict(env)
+		if err != nil {
+			return err
+		}
+		*field = v
+	}
+	return nil
+}
+
+func (s *ociOps) Name() string { return "oracle" }
+
+func (s *ociOps) InstanceID() string { return s.inst.id }
+
+// GetZone returns the availability domain of the instance
+func (s *ociOps) GetZone() (string, error) { return s.inst.availabilityDomain, nil }
+
+func (s *ociOps) GetRegion() (string, error) { return s.inst.region, nil }
+
+// ListZones returns the availability domains of the region
+func (s *ociOps) ListZones() ([]string, error) {
+	domains, err := s.client.listAvailabilityDomains(s.key.TenancyID)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	sort.Strings(domains)
+	return domains, nil
+}
+
+func (s *ociOps) Create(
+	template interface{},
+	labels map[string]string,
+) (*storageops.Volume, error) {
+	template, labels, err := storageops.VolumeTemplate(s.Name(), template, labels)
+	if err != nil {
+		return nil, err
+	}
+	v, ok := template.(*core.CreateVolumeDetails)
+	if !ok {
+		return nil, storageops.NewStorageError(storageops.ErrVolInval,
+			"Invalid volume template given", s.inst.id)
+	}
+	details := *v
+	if len(stringValue(details.CompartmentId)) == 0 {
+		details.CompartmentId = common.String(s.inst.compartmentID)
+	}
+	if len(stringValue(details.AvailabilityDomain)) == 0 {
+		details.AvailabilityDomain = common.String(s.inst.availabilityDomain)
+	}
+	details.FreeformTags, details.DefinedTags = mergeTags(v.FreeformTags, v.DefinedTags,
+		labels, nil, s.cfg.DefinedTagNamespaces)
+
+	resp, err := s.client.blockstorage.CreateVolume(context.Background(),
+		core.CreateVolumeRequest{CreateVolumeDetails: details})
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	id := stringValue(resp.Id)
+	available, err := s.waitVolumeState(id, core.VolumeLifecycleStateAvailable, storageops.RetryOpCreate)
+	if err != nil {
+		return nil, s.rollbackCreate(id, err)
+	}
+	return toVolume(available), nil
+}
+
+func (s *ociOps) rollbackCreate(id string, createErr error) error {
+	logrus.Warnf("Rollback create volume %v, Error %v", id, createErr)
+	if err := s.Delete(id); err != nil {
+		logrus.Warnf("Rollback failed volume %v, Error %v", id, err)
+	}
+	return createErr
+}
+
+// waitVolumeState waits for the given volume to be in the given lifecycle
+// state and returns it
+func (s *ociOps) waitVolumeState(
+	id string,
+	desired core.VolumeLifecycleStateEnum,
+	op string,
+) (*core.Volume, error) {
+	vol, err := s.cfg.RetryPolicy.For(op).Retry(
+		fmt.Sprintf("wait for volume %s to be %s", id, desired),
+		func() (interface{}, bool, error) {
+			vol, err := s.client.getVolume(id)
+			if err != nil {
+				return nil, true, s.storageError(err)
+			}
+			if vol.LifecycleState == core.VolumeLifecycleStateFaulty {
+				return nil, false, fmt.Errorf("volume %s is %s", id, vol.LifecycleState)
+			}
+			if vol.LifecycleState != desired {
+				return nil, true, fmt.Errorf("invalid state: %s for volume: %s. expected: %s",
+					vol.LifecycleState, id, desired)
+			}
+			return vol, false, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return vol.(*core.Volume), nil
+}
+
+func (s *ociOps) GetDeviceID(template interface{}) (string, error) {
+	if id, ok := storageops.TypedID(template); ok {
+		return id, nil
+	}
+	switch v := template.(type) {
+	case *core.Volume:
+		return stringValue(v.Id), nil
+	case *core.VolumeBackup:
+		return stringValue(v.Id), nil
+	}
+	return "", fmt.Errorf("invalid type: %v given to GetDeviceID", template)
+}
+
+func (s *ociOps) volume(volumeID string) (*core.Volume, error) {
+	vol, err := s.client.getVolume(volumeID)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	return vol, nil
+}
+
+func (s *ociOps) Expand(volumeID string, newSizeGiB uint64) (uint64, error) {
+	vol, err := s.volume(volumeID)
+	if err != nil {
+		return 0, err
+	}
+	currentSize := uint64(int64Value(vol.SizeInGBs))
+	if newSizeGiB == currentSize {
+		return currentSize, nil
+	}
+	if newSizeGiB < currentSize {
+		return 0, storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("volume %s of %d GiB cannot be shrunk to %d GiB",
+				volumeID, currentSize, newSizeGiB), s.inst.id)
+	}
+	if err := s.updateVolume(volumeID, core.UpdateVolumeDetails{
+		SizeInGBs: common.Int64(int64(newSizeGiB)),
+	}); err != nil {
+		return 0, err
+	}
+	if _, err := s.waitVolumeState(volumeID, core.VolumeLifecycleStateAvailable,
+		storageops.RetryOpExpand); err != nil {
+		return 0, err
+	}
+	return newSizeGiB, nil
+}
+
+// Modify changes the performance level of the given volume. The type is one
+// of lower, balanced, higher or ultra. IOPS and throughput scale with the
+// size and performance level and can not be set.
+func (s *ociOps) Modify(volumeID string, spec storageops.VolumeSpecUpdate) error {
+	if spec.Iops != 0 || spec.ThroughputMiBps != 0 {
+		return storageops.NewStorageError(storageops.ErrModifyUnsupported,
+			"OCI volume IOPS and throughput are set by the volume performance level", s.inst.id)
+	}
+	if len(spec.Type) == 0 {
+		return nil
+	}
+	vpus, ok := vpusPerGBTypes[spec.Type]
+	if !ok {
+		return storageops.NewStorageError(storageops.ErrVolInval,
+			fmt.Sprintf("invalid volume performance level %q", spec.Type), s.inst.id)
+	}
+	if err := s.updateVolume(volumeID, core.UpdateVolumeDetails{
+		VpusPerGB: common.Int64(vpus),
+	}); err != nil {
+		return err
+	}
+	_, err := s.cfg.RetryPolicy.For(storageops.RetryOpModify).Retry(
+		fmt.Sprintf("wait for volume %s to be %s", volumeID, spec.Type),
+		func() (interface{}, bool, error) {
+			vol, err := s.volume(volumeID)
+			if err != nil {
+				return nil, true, err
+			}
+			if int64Value(vol.VpusPerGB) != vpus ||
+				vol.LifecycleState != core.VolumeLifecycleStateAvailable {
+				return nil, true, fmt.Errorf("volume %s is at %d VPUs per GB and %s",
+					volumeID, int64Value(vol.VpusPerGB), vol.LifecycleState)
+			}
+			return nil, false, nil
+		})
+	return err
+}
+
+// attachments returns the attachments of the given volume and instance that
+// are not detached, filtered only by the set arguments
+func (s *ociOps) attachments(instanceID, volumeID string) ([]core.VolumeAttachment, error) {
+	atts, err := s.client.listVolumeAttachments(s.inst.compartmentID, instanceID, volumeID)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	var active []core.VolumeAttachment
+	for _, att := range atts {
+		if att.GetLifecycleState() != core.VolumeAttachmentLifecycleStateDetached {
+			active = append(active, att)
+		}
+	}
+	return active, nil
+}
+
+func (s *ociOps) Attach(volumeID string, options map[string]string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	atts, err := s.attachments("", volumeID)
+	if err != nil {
+		return "", err
+	}
+	for _, att := range atts {
+		if stringValue(att.GetInstanceId()) == s.inst.id {
+			return s.devicePath(att)
+		}
+	}
+	shareable := options[storageops.AttachOptionMultiAttach] == "true"
+	if len(atts) > 0 && !shareable {
+		return "", storageops.NewStorageError(storageops.ErrVolAlreadyAttached,
+			fmt.Sprintf("volume %s is already attached to %s", volumeID,
+				stringValue(atts[0].GetInstanceId())),
+			s.inst.id)
+	}
+
+	attachmentType := s.cfg.AttachmentType
+	if t, ok := options[AttachOptionAttachmentType]; ok {
+		if t != AttachmentParavirtualized && t != AttachmentISCSI {
+			return "", storageops.NewStorageError(storageops.ErrVolInval,
+				fmt.Sprintf("invalid attachment type %q", t), s.inst.id)
+		}
+		attachmentType = t
+	}
+	device := options[storageops.AttachOptionDevice]
+	if len(device) == 0 {
+		free, err := s.FreeDevices(nil, "")
+		if err != nil {
+			return "", err
+		}
+		device = free[0]
+	}
+
+	readOnly := options[storageops.AttachOptionReadOnly] == "true"
+	var details core.AttachVolumeDetails
+	if attachmentType == AttachmentISCSI {
+		details = core.AttachIScsiVolumeDetails{
+			InstanceId:  common.String(s.inst.id),
+			VolumeId:    common.String(volumeID),
+			Device:      common.String(device),
+			IsReadOnly:  common.Bool(readOnly),
+			IsShareable: common.Bool(shareable),
+		}
+	} else {
+		details = core.AttachParavirtualizedVolumeDetails{
+			InstanceId:  common.String(s.inst.id),
+			VolumeId:    common.String(volumeID),
+			Device:      common.String(device),
+			IsReadOnly:  common.Bool(readOnly),
+			IsShareable: common.Bool(shareable),
+		}
+	}
+	resp, err := s.client.compute.AttachVolume(context.Background(),
+		core.AttachVolumeRequest{AttachVolumeDetails: details})
+	if err != nil {
+		return "", s.storageError(err)
+	}
+	att, err := s.waitAttachmentState(stringValue(resp.GetId()),
+		core.VolumeAttachmentLifecycleStateAttached, storageops.RetryOpAttach)
+	if err != nil {
+		return "", err
+	}
+	if iscsi, ok := att.(core.IScsiVolumeAttachment); ok {
+		if err := iscsiLogin(&iscsi); err != nil {
+			return "", err
+		}
+	}
+
+	devicePath, err := s.cfg.RetryPolicy.For(storageops.RetryOpAttach).Retry(
+		fmt.Sprintf("wait for device of volume %s", volumeID),
+		func() (interface{}, bool, error) {
+			devicePath, err := s.devicePath(att)
+			return devicePath, err != nil, err
+		})
+	if err != nil {
+		return "", err
+	}
+	return devicePath.(string), nil
+}
+
+// waitAttachmentState waits for the given attachment to be in the given
+// lifecycle state and returns it
+func (s *ociOps) waitAttachmentState(
+	id string,
+	desired core.VolumeAttachmentLifecycleStateEnum,
+	op string,
+) (core.VolumeAttachment, error) {
+	att, err := s.cfg.RetryPolicy.For(op).Retry(
+		fmt.Sprintf("wait for volume attachment %s to be %s", id, desired),
+		func() (interface{}, bool, error) {
+			resp, err := s.client.compute.GetVolumeAttachment(context.Background(),
+				core.GetVolumeAttachmentRequest{VolumeAttachmentId: common.String(id)})
+			if err != nil {
+				return nil, true, s.storageError(err)
+			}
+			if state := resp.GetLifecycleState(); state != desired {
+				return nil, true, fmt.Errorf("invalid state: %s for volume attachment: %s. expected: %s",
+					state, id, desired)
+			}
+			return resp.VolumeAttachment, false, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return att.(core.VolumeAttachment), nil
+}
+
+func (s *ociOps) Detach(volumeID string) error {
+	return s.detachInternal(volumeID, s.inst.id)
+}
+
+func (s *ociOps) DetachFrom(volumeID, instanceID string) error {
+	return s.detachInternal(volumeID, instanceID)
+}
+
+func (s *ociOps) detachInternal(volumeID, instanceID string) error {
+	atts, err := s.attachments(instanceID, volumeID)
+	if err != nil {
+		return err
+	}
+	if len(atts) == 0 {
+		return storageops.NewStorageError(storageops.ErrVolDetached,
+			fmt.Sprintf("volume %s is not attached to %s", volumeID, instanceID), s.inst.id)
+	}
+	att := atts[0]
+	// Only this instance can log out of its iSCSI sessions
+	if iscsi, ok := att.(core.IScsiVolumeAttachment); ok && instanceID == s.inst.id {
+		if err := iscsiLogout(&iscsi); err != nil {
+			return err
+		}
+	}
+	if _, err := s.client.compute.DetachVolume(context.Background(),
+		core.DetachVolumeRequest{VolumeAttachmentId: att.GetId()}); err != nil {
+		return s.storageError(err)
+	}
+	_, err = s.waitAttachmentState(stringValue(att.GetId()),
+		core.VolumeAttachmentLifecycleStateDetached, storageops.RetryOpDetach)
+	return err
+}
+
+func (s *ociOps) Delete(volumeID string) error {
+	_, err := s.client.blockstorage.DeleteVolume(context.Background(),
+		core.DeleteVolumeRequest{VolumeId: common.String(volumeID)})
+	return s.storageError(err)
+}
+
+func (s *ociOps) DeleteFrom(volumeID, _ string) error {
+	return s.Delete(volumeID)
+}
+
+// Describe returns the *core.Instance of this instance
+func (s *ociOps) Describe() (interface{}, error) {
+	resp, err := s.client.compute.GetInstance(context.Background(),
+		core.GetInstanceRequest{InstanceId: common.String(s.inst.id)})
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	return &resp.Instance, nil
+}
+
+// FreeDevices returns the free consistent device paths of the instance, e.g.
+// /dev/oracleoci/oraclevdb. The arguments are ignored as OCI tracks the
+// devices in use.
+func (s *ociOps) FreeDevices(
+	blockDeviceMappings []interface{},
+	rootDeviceName string,
+) ([]string, error) {
+	devices, err := s.client.listInstanceDevices(s.inst.id)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	var free []string
+	for _, d := range devices {
+		if boolValue(d.IsAvailable) {
+			free = append(free, stringValue(d.Name))
+		}
+	}
+	if len(free) == 0 {
+		return nil, fmt.Errorf("No more free devices on %s", s.inst.id)
+	}
+	sort.Strings(free)
+	return free, nil
+}
+
+func (s *ociOps) Inspect(volumeIds []*string) ([]*storageops.Volume, error) {
+	var vols []*storageops.Volume
+	for _, id := range volumeIds {
+		vol, err := s.volume(*id)
+		if err != nil {
+			return nil, err
+		}
+		vols = append(vols, toVolume(vol))
+	}
+	return vols, nil
+}
+
+func (s *ociOps) DeviceMappings() (map[string]string, error) {
+	atts, err := s.attachments(s.inst.id, "")
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string)
+	for _, att := range atts {
+		if att.GetLifecycleState() != core.VolumeAttachmentLifecycleStateAttached {
+			continue
+		}
+		devPath, err := s.devicePath(att)
+		if err != nil {
+			return nil, err
+		}
+		m[devPath] = stringValue(att.GetVolumeId())
+	}
+	return m, nil
+}
+
+func (s *ociOps) Enumerate(
+	volumeIds []*string,
+	labels map[string]string,
+	setIdentifier string,
+) (map[string][]*storageops.Volume, error) {
+	sets := make(map[string][]*storageops.Volume)
+	err := s.EnumerateEach(volumeIds, labels, setIdentifier,
+		func(set string, vol *storageops.Volume) bool {
+			storageops.AddElementToMap(sets, vol, set)
+			return true
+		})
+	if err != nil {
+		return nil, err
+	}
+	return sets, nil
+}
+
+var _ storageops.StreamingEnumerator = &ociOps{}
+
+// EnumerateEach calls fn with every volume of the compartment matching the
+// given IDs and labels one page at a time. Terminated volumes are skipped.
+// The set of a volume is the setIdentifier label key if it has it.
+func (s *ociOps) EnumerateEach(
+	volumeIds []*string,
+	labels map[string]string,
+	setIdentifier string,
+	fn func(set string, vol *storageops.Volume) bool,
+) error {
+	ids := make(map[string]bool)
+	for _, id := range volumeIds {
+		ids[*id] = true
+	}
+	err := s.client.listVolumes(s.inst.compartmentID, func(vol *core.Volume) bool {
+		if vol.LifecycleState == core.VolumeLifecycleStateTerminated ||
+			(len(ids) > 0 && !ids[stringValue(vol.Id)]) ||
+			!hasLabels(vol.FreeformTags, vol.DefinedTags, labels) {
+			return true
+		}
+		set := storageops.SetIdentifierNone
+		if _, ok := tagsToLabels(vol.FreeformTags, vol.DefinedTags)[setIdentifier]; ok &&
+			len(setIdentifier) > 0 {
+			set = setIdentifier
+		}
+		return fn(set, toVolume(vol))
+	})
+	return s.storageError(err)
+}
+
+func (s *ociOps) DevicePath(volumeID string) (string, error) {
+	atts, err := s.attachments("", volumeID)
+	if err != nil {
+		return "", err
+	}
+	var attachedTo []string
+	for _, att := range atts {
+		if att.GetLifecycleState() != core.VolumeAttachmentLifecycleStateAttached {
+			continue
+		}
+		if stringValue(att.GetInstanceId()) == s.inst.id {
+			return s.devicePath(att)
+		}
+		attachedTo = append(attachedTo, stringValue(att.GetInstanceId()))
+	}
+	if len(attachedTo) == 0 {
+		return "", storageops.NewStorageError(storageops.ErrVolDetached,
+			fmt.Sprintf("Volume %s is detached", volumeID), s.inst.id)
+	}
+	return "", storageops.NewStorageError(storageops.ErrVolAttachedOnRemoteNode,
+		fmt.Sprintf("volume %s is not attached on: %s (Attached on: %v)",
+			volumeID, s.inst.id, attachedTo),
+		s.inst.id)
+}
+
+// devicePath resolves the consistent device path of the given attachment,
+// or the by-path symlink of its LUN for iSCSI attachments without one
+func (s *ociOps) devicePath(att core.VolumeAttachment) (string, error) {
+	link := stringValue(att.GetDevice())
+	if len(link) == 0 {
+		iscsi, ok := att.(core.IScsiVolumeAttachment)
+		if !ok {
+			return "", storageops.NewStorageError(storageops.ErrInvalidDevicePath,
+				fmt.Sprintf("volume attachment %s has no device", stringValue(att.GetId())),
+				s.inst.id)
+		}
+		link = iscsiDevicePath(&iscsi)
+	}
+	devPath, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		return "", storageops.NewStorageError(storageops.ErrInvalidDevicePath,
+			fmt.Sprintf("unable to find block dev path for %s. %v", link, err),
+			s.inst.id)
+	}
+	return devPath, nil
+}
+
+// Snapshot takes an incremental backup of the given volume with its tags and
+// waits for it to be available
+func (s *ociOps) Snapshot(volumeID string, readonly bool) (*storageops.Snapshot, error) {
+	vol, err := s.volume(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("%s-%s", stringValue(vol.DisplayName),
+		time.Now().UTC().Format("20060102150405"))
+	resp, err := s.client.blockstorage.CreateVolumeBackup(context.Background(),
+		core.CreateVolumeBackupRequest{CreateVolumeBackupDetails: core.CreateVolumeBackupDetails{
+			VolumeId:     common.String(volumeID),
+			DisplayName:  common.String(name),
+			Type:         core.CreateVolumeBackupDetailsTypeIncremental,
+			FreeformTags: vol.FreeformTags,
+			DefinedTags:  vol.DefinedTags,
+		}})
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	id := stringValue(resp.Id)
+	snap, err := s.cfg.RetryPolicy.For(storageops.RetryOpSnapshot).Retry(
+		fmt.Sprintf("wait for volume backup %s to be %s", id, core.VolumeBackupLifecycleStateAvailable),
+		func() (interface{}, bool, error) {
+			backup, err := s.client.getVolumeBackup(id)
+			if err != nil {
+				return nil, true, s.storageError(err)
+			}
+			if backup.LifecycleState == core.VolumeBackupLifecycleStateFaulty {
+				return nil, false, fmt.Errorf("volume backup %s is %s", id, backup.LifecycleState)
+			}
+			if backup.LifecycleState != core.VolumeBackupLifecycleStateAvailable {
+				return nil, true, fmt.Errorf("invalid state: %s for volume backup: %s. expected: %s",
+					backup.LifecycleState, id, core.VolumeBackupLifecycleStateAvailable)
+			}
+			return backup, false, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return toSnapshot(snap.(*core.VolumeBackup)), nil
+}
+
+func (s *ociOps) SnapshotDelete(snapID string) error {
+	_, err := s.client.blockstorage.DeleteVolumeBackup(context.Background(),
+		core.DeleteVolumeBackupRequest{VolumeBackupId: common.String(snapID)})
+	return s.storageError(err)
+}
+
+func (s *ociOps) SnapshotEnumerate(
+	filter *storageops.SnapshotFilter,
+) ([]*storageops.Snapshot, error) {
+	var snaps []*storageops.Snapshot
+	err := s.client.listVolumeBackups(s.inst.compartmentID, func(backup *core.VolumeBackup) bool {
+		if backup.LifecycleState == core.VolumeBackupLifecycleStateTerminated {
+			return true
+		}
+		if filter != nil && !hasLabels(backup.FreeformTags, backup.DefinedTags, filter.Labels) {
+			return true
+		}
+		snap := toSnapshot(backup)
+		if filter.Match(snap.VolumeID, snap.Created, snap.State, snap.Labels) {
+			snaps = append(snaps, snap)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	return snaps, nil
+}
+
+// SnapshotRestore creates a volume from the given volume backup in the given
+// availability domain
+func (s *ociOps) SnapshotRestore(
+	snapID, zone string,
+	labels map[string]string,
+) (*storageops.Volume, error) {
+	backup, err := s.client.getVolumeBackup(snapID)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	if len(zone) == 0 {
+		zone = s.inst.availabilityDomain
+	}
+	return s.Create(&core.CreateVolumeDetails{
+		AvailabilityDomain: common.String(zone),
+		DisplayName:        backup.DisplayName,
+		SourceDetails:      core.VolumeSourceFromVolumeBackupDetails{Id: common.String(snapID)},
+	}, labels)
+}
+
+func (s *ociOps) SnapshotStatus(snapID string) (*storageops.SnapshotStatus, error) {
+	backup, err := s.client.getVolumeBackup(snapID)
+	if err != nil {
+		return nil, s.storageError(err)
+	}
+	status := &storageops.SnapshotStatus{
+		ID:        stringValue(backup.Id),
+		State:     string(backup.LifecycleState),
+		Completed: backup.LifecycleState == core.VolumeBackupLifecycleStateAvailable,
+		Failed:    backup.LifecycleState == core.VolumeBackupLifecycleStateFaulty,
+	}
+	if status.Completed {
+		status.Progress = 100
+	}
+	return status, nil
+}
+
+// isBackup returns true if the given OCID is that of a volume backup
+func isBackup(id string) bool {
+	return strings.HasPrefix(id, "ocid1.volumebackup.")
+}
+
+// resourceTags returns the freeform and defined tags of the given volume or
+// volume backup
+func (s *ociOps) resourceTags(id string) (map[string]string, map[string]map[string]interface{}, error) {
+	if isBackup(id) {
+		backup, err := s.client.getVolumeBackup(id)
+		if err != nil {
+			return nil, nil, s.storageError(err)
+		}
+		return backup.FreeformTags, backup.DefinedTags, nil
+	}
+	vol, err := s.volume(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return vol.FreeformTags, vol.DefinedTags, nil
+}
+
+// updateTags applies and removes the given labels of the given volume or
+// volume backup. OCI replaces all tags on update, so the tags are merged
+// with the current ones.
+func (s *ociOps) updateTags(id string, apply, remove map[string]string) error {
+	freeform, defined, err := s.resourceTags(id)
+	if err != nil {
+		return err
+	}
+	freeform, defined = mergeTags(freeform, defined, apply, remove, s.cfg.DefinedTagNamespaces)
+	if !isBackup(id) {
+		return s.updateVolume(id, core.UpdateVolumeDetails{
+			FreeformTags: freeform,
+			DefinedTags:  defined,
+		})
+	}
+	_, err = s.client.blockstorage.UpdateVolumeBackup(context.Background(),
+		core.UpdateVolumeBackupRequest{
+			VolumeBackupId: common.String(id),
+			UpdateVolumeBackupDetails: core.UpdateVolumeBackupDetails{
+				FreeformTags: freeform,
+				DefinedTags:  defined,
+			},
+		})
+	return s.storageError(err)
+}
+
+// updateVolume applies the given update, which replaces the set fields, to
+// the given volume
+func (s *ociOps) updateVolume(id string, details core.UpdateVolumeDetails) error {
+	_, err := s.client.blockstorage.UpdateVolume(context.Background(),
+		core.UpdateVolumeRequest{VolumeId: common.String(id), UpdateVolumeDetails: details})
+	return s.storageError(err)
+}
+
+// ApplyTags applies the given labels to the given volume or volume backup,
+// see Config.DefinedTagNamespaces
+func (s *ociOps) ApplyTags(volumeID string, labels map[string]string) error {
+	return s.updateTags(volumeID, labels, nil)
+}
+
+func (s *ociOps) RemoveTags(volumeID string, labels map[string]string) error {
+	return s.updateTags(volumeID, nil, labels)
+}
+
+// Tags returns the freeform tags and the defined tags, keyed namespace.key,
+// of the given volume or volume backup
+func (s *ociOps) Tags(volumeID string) (map[string]string, error) {
+	freeform, defined, err := s.resourceTags(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	return tagsToLabels(freeform, defined), nil
+}
@@ -0,0 +1,321 @@
+package oracle
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/libopenstorage/openstorage/pkg/storageops/test"
+	"github.com/oracle/oci-go-sdk/common"
+	"github.com/oracle/oci-go-sdk/core"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAll(t *testing.T) {
+	d, err := NewEnvClient()
+	if err != nil {
+		t.Skipf("skipping Oracle tests as environment is not set...\n")
+	}
+	name := fmt.Sprintf("openstorage-test-%s", uuid.New()[:8])
+	test.RunTest(map[string]storageops.Ops{d.Name(): d},
+		map[string]map[string]interface{}{d.Name(): {
+			name: &core.CreateVolumeDetails{
+				DisplayName: common.String(name),
+				SizeInGBs:   common.Int64(50),
+			},
+		}}, t)
+}
+
+var signatureRegex = regexp.MustCompile(`headers="([^"]+)".*signature="([^"]+)"`)
+
+// fakeAttachment is the wire format of the volume attachments of fakeAPI
+type fakeAttachment struct {
+	ID             string `json:"id"`
+	AttachmentType string `json:"attachmentType"`
+	InstanceID     string `json:"instanceId"`
+	VolumeID       string `json:"volumeId"`
+	Device         string `json:"device,omitempty"`
+	LifecycleState string `json:"lifecycleState"`
+	Iqn            string `json:"iqn,omitempty"`
+	Ipv4           string `json:"ipv4,omitempty"`
+	Port           int    `json:"port,omitempty"`
+}
+
+// fakeAPI is an in memory OCI API of volumes and volume attachments that
+// verifies the request signatures
+type fakeAPI struct {
+	t           *testing.T
+	key         *rsa.PublicKey
+	device      string
+	volumes     map[string]*core.Volume
+	attachments map[string]*fakeAttachment
+}
+
+func newFakeAPI(t *testing.T, key *rsa.PublicKey, device string) *fakeAPI {
+	return &fakeAPI{t: t, key: key, device: device,
+		volumes: make(map[string]*core.Volume), attachments: make(map[string]*fakeAttachment)}
+}
+
+func (f *fakeAPI) verify(r *http.Request, body []byte) {
+	m := signatureRegex.FindStringSubmatch(r.Header.Get("Authorization"))
+	if !assert.NotNil(f.t, m, "request is not signed") {
+		return
+	}
+	var lines []string
+	for _, h := range strings.Split(m[1], " ") {
+		switch h {
+		case "(request-target)":
+			lines = append(lines, h+": "+strings.ToLower(r.Method)+" "+r.URL.RequestURI())
+		case "host":
+			lines = append(lines, h+": "+r.Host)
+		default:
+			lines = append(lines, h+": "+r.Header.Get(h))
+		}
+	}
+	digest := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	signature, err := base64.StdEncoding.DecodeString(m[2])
+	assert.NoError(f.t, err)
+	assert.NoError(f.t, rsa.VerifyPKCS1v15(f.key, crypto.SHA256, digest[:], signature))
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		assert.Equal(f.t, base64.StdEncoding.EncodeToString(sum[:]), r.Header.Get("X-Content-Sha256"))
+	}
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	f.verify(r, body)
+	var in map[string]interface{}
+	if len(body) > 0 {
+		assert.NoError(f.t, json.Unmarshal(body, &in))
+	}
+	reply := func(v interface{}) { assert.NoError(f.t, json.NewEncoder(w).Encode(v)) }
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/20160918/"), "/")
+
+	switch {
+	case parts[0] == "volumes" && len(parts) == 1 && r.Method == http.MethodPost:
+		vol := &core.Volume{}
+		assert.NoError(f.t, json.Unmarshal(body, vol))
+		vol.Id = common.String("ocid1.volume.oc1." + uuid.New())
+		vol.LifecycleState = core.VolumeLifecycleStateAvailable
+		f.volumes[*vol.Id] = vol
+		reply(vol)
+	case parts[0] == "volumes" && len(parts) == 1:
+		vols := []*core.Volume{}
+		for _, vol := range f.volumes {
+			vols = append(vols, vol)
+		}
+		reply(vols)
+	case parts[0] == "volumes":
+		vol, ok := f.volumes[parts[1]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			reply(map[string]string{"code": "NotAuthorizedOrNotFound", "message": "not found"})
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			update := core.UpdateVolumeDetails{}
+			assert.NoError(f.t, json.Unmarshal(body, &update))
+			if update.SizeInGBs != nil {
+				vol.SizeInGBs = update.SizeInGBs
+			}
+			if update.VpusPerGB != nil {
+				vol.VpusPerGB = update.VpusPerGB
+			}
+			// Updates replace all tags
+			if _, ok := in["freeformTags"]; ok {
+				vol.FreeformTags, vol.DefinedTags = update.FreeformTags, update.DefinedTags
+			}
+		case http.MethodDelete:
+			vol.LifecycleState = core.VolumeLifecycleStateTerminated
+		}
+		reply(vol)
+	case parts[0] == "volumeAttachments" && len(parts) == 1 && r.Method == http.MethodPost:
+		att := &fakeAttachment{
+			ID:             "ocid1.volumeattachment.oc1." + uuid.New(),
+			AttachmentType: in["type"].(string),
+			InstanceID:     in["instanceId"].(string),
+			VolumeID:       in["volumeId"].(string),
+			Device:         f.device,
+			LifecycleState: string(core.VolumeAttachmentLifecycleStateAttached),
+		}
+		if att.AttachmentType == AttachmentISCSI {
+			att.Device = ""
+			att.Iqn, att.Ipv4, att.Port = "iqn.2015-12.com.oracleiaas:"+att.VolumeID, "169.254.2.2", 3260
+		}
+		f.attachments[att.ID] = att
+		reply(att)
+	case parts[0] == "volumeAttachments" && len(parts) == 1:
+		atts := []*fakeAttachment{}
+		for _, att := range f.attachments {
+			if v := r.URL.Query().Get("volumeId"); len(v) > 0 && v != att.VolumeID {
+				continue
+			}
+			if i := r.URL.Query().Get("instanceId"); len(i) > 0 && i != att.InstanceID {
+				continue
+			}
+			atts = append(atts, att)
+		}
+		reply(atts)
+	case parts[0] == "volumeAttachments":
+		att := f.attachments[parts[1]]
+		if r.Method == http.MethodDelete {
+			att.LifecycleState = string(core.VolumeAttachmentLifecycleStateDetached)
+		}
+		reply(att)
+	case parts[0] == "instances" && len(parts) == 3:
+		reply([]core.Device{
+			{Name: common.String("/dev/oracleoci/oraclevdc"), IsAvailable: common.Bool(true)},
+			{Name: common.String("/dev/oracleoci/oraclevdb"), IsAvailable: common.Bool(true)},
+		})
+	default:
+		f.t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestOracleVolumes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oracle")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	device := filepath.Join(dir, "sdb")
+	assert.NoError(t, ioutil.WriteFile(device, nil, 0600))
+	link := filepath.Join(dir, "oraclevdb")
+	assert.NoError(t, os.Symlink(device, link))
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	api := newFakeAPI(t, &key.PublicKey, link)
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	d, err := NewClient("ocid1.instance.oc1.1", "ocid1.compartment.oc1.1", "AD-1", "us-ashburn-1",
+		&APIKey{TenancyID: "tenancy", UserID: "user", Fingerprint: "fp", PrivateKey: key},
+		Config{
+			Endpoint:             server.URL,
+			DefinedTagNamespaces: []string{"ops"},
+			RetryPolicy:          &storageops.RetryPolicy{Interval: time.Millisecond, Timeout: time.Second},
+		})
+	assert.NoError(t, err)
+
+	vol, err := d.Create(&core.CreateVolumeDetails{
+		DisplayName: common.String("data"),
+		SizeInGBs:   common.Int64(50),
+	}, map[string]string{"app": "db", "ops.team": "storage"})
+	assert.NoError(t, err)
+	id, err := d.GetDeviceID(vol)
+	assert.NoError(t, err)
+	created := api.volumes[id]
+	assert.Equal(t, "AD-1", *created.AvailabilityDomain)
+	assert.Equal(t, map[string]string{"app": "db"}, created.FreeformTags)
+	assert.Equal(t, "storage", created.DefinedTags["ops"]["team"])
+
+	_, err = d.DevicePath(id)
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolDetached))
+	path, err := d.Attach(id, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, device, path)
+	mappings, err := d.DeviceMappings()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{device: id}, mappings)
+
+	size, err := d.Expand(id, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(100), size)
+	assert.NoError(t, d.Modify(id, storageops.VolumeSpecUpdate{Type: "higher"}))
+	assert.Equal(t, int64(20), *api.volumes[id].VpusPerGB)
+	err = d.Modify(id, storageops.VolumeSpecUpdate{Iops: 1000})
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrModifyUnsupported))
+
+	sets, err := d.Enumerate(nil, map[string]string{"ops.team": "storage"}, "app")
+	assert.NoError(t, err)
+	assert.Len(t, sets["app"], 1)
+
+	assert.NoError(t, d.ApplyTags(id, map[string]string{"tier": "gold", "ops.team": "infra"}))
+	assert.NoError(t, d.RemoveTags(id, map[string]string{"app": ""}))
+	tags, err := d.Tags(id)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"tier": "gold", "ops.team": "infra"}, tags)
+
+	assert.NoError(t, d.Detach(id))
+	_, err = d.DevicePath(id)
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolDetached))
+	assert.NoError(t, d.Delete(id))
+	_, err = d.Inspect([]*string{stringPtr("ocid1.volume.oc1.missing")})
+	assert.True(t, storageops.IsErrorCode(err, storageops.ErrVolNotFound))
+}
+
+func TestOracleISCSIAttach(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oracle")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	byPath := diskByPathDir
+	diskByPathDir = dir
+	defer func() { diskByPathDir = byPath }()
+	var commands []string
+	run := iscsiadm
+	iscsiadm = func(args ...string) error {
+		commands = append(commands, strings.Join(args, " "))
+		return nil
+	}
+	defer func() { iscsiadm = run }()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	api := newFakeAPI(t, &key.PublicKey, "")
+	server := httptest.NewServer(api)
+	defer server.Close()
+	d, err := NewClient("ocid1.instance.oc1.1", "ocid1.compartment.oc1.1", "AD-1", "us-ashburn-1",
+		&APIKey{PrivateKey: key}, Config{
+			Endpoint:       server.URL,
+			AttachmentType: AttachmentISCSI,
+			RetryPolicy:    &storageops.RetryPolicy{Interval: time.Millisecond, Timeout: time.Second},
+		})
+	assert.NoError(t, err)
+
+	vol, err := d.Create(&core.CreateVolumeDetails{
+		DisplayName: common.String("data"),
+		SizeInGBs:   common.Int64(50),
+	}, nil)
+	assert.NoError(t, err)
+	id := vol.ID
+	iqn := "iqn.2015-12.com.oracleiaas:" + id
+	device := filepath.Join(dir, "sdc")
+	assert.NoError(t, ioutil.WriteFile(device, nil, 0600))
+	assert.NoError(t, os.Symlink(device,
+		filepath.Join(dir, "ip-169.254.2.2:3260-iscsi-"+iqn+"-lun-1")))
+
+	path, err := d.Attach(id, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, device, path)
+	assert.Equal(t, []string{
+		"-m node -o new -T " + iqn + " -p 169.254.2.2:3260",
+		"-m node -o update -T " + iqn + " -n node.startup -v automatic",
+		"-m node -T " + iqn + " -p 169.254.2.2:3260 -l",
+	}, commands)
+
+	commands = nil
+	assert.NoError(t, d.Detach(id))
+	assert.Equal(t, []string{
+		"-m node -T " + iqn + " -p 169.254.2.2:3260 -u",
+		"-m node -o delete -T " + iqn + " -p 169.254.2.2:3260",
+	}, commands)
+}
+
+func stringPtr(s string) *string { return &s }
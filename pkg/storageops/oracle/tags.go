@@ -0,0 +1,122 @@
+package oracle
+
+import (
+	"fmt"
+	"strings"
+)
+
+// labelsToTags splits the given labels into freeform tags and the defined
+// tags of the given tag namespaces. Labels keyed namespace.key with a
+// namespace of the list are defined tags, all others are freeform tags.
+func labelsToTags(
+	labels map[string]string,
+	namespaces []string,
+) (map[string]string, map[string]map[string]interface{}) {
+	freeform := make(map[string]string)
+	defined := make(map[string]map[string]interface{})
+	for k, v := range labels {
+		ns, key := splitDefinedKey(k, namespaces)
+		if len(ns) == 0 {
+			freeform[k] = v
+			continue
+		}
+		if defined[ns] == nil {
+			defined[ns] = make(map[string]interface{})
+		}
+		defined[ns][key] = v
+	}
+	return freeform, defined
+}
+
+// tagsToLabels returns the labels of the given freeform and defined tags,
+// see labelsToTags
+func tagsToLabels(
+	freeform map[string]string,
+	defined map[string]map[string]interface{},
+) map[string]string {
+	labels := make(map[string]string)
+	for k, v := range freeform {
+		labels[k] = v
+	}
+	for ns, tags := range defined {
+		for k, v := range tags {
+			labels[ns+"."+k] = fmt.Sprint(v)
+		}
+	}
+	return labels
+}
+
+// splitDefinedKey returns the namespace and key of the given label key if it
+// is the key of a defined tag of the given namespaces, or empty strings
+func splitDefinedKey(label string, namespaces []string) (string, string) {
+	for _, ns := range namespaces {
+		if strings.HasPrefix(label, ns+".") && len(label) > len(ns)+1 {
+			return ns, label[len(ns)+1:]
+		}
+	}
+	return "", ""
+}
+
+// mergeTags returns the given tags with the given labels applied and the
+// labels of the given keys removed. The inputs are not modified.
+func mergeTags(
+	freeform map[string]string,
+	defined map[string]map[string]interface{},
+	apply map[string]string,
+	remove map[string]string,
+	namespaces []string,
+) (map[string]string, map[string]map[string]interface{}) {
+	newFreeform := make(map[string]string)
+	for k, v := range freeform {
+		newFreeform[k] = v
+	}
+	newDefined := make(map[string]map[string]interface{})
+	for ns, tags := range defined {
+		newDefined[ns] = make(map[string]interface{})
+		for k, v := range tags {
+			newDefined[ns][k] = v
+		}
+	}
+
+	applyFreeform, applyDefined := labelsToTags(apply, namespaces)
+	for k, v := range applyFreeform {
+		newFreeform[k] = v
+	}
+	for ns, tags := range applyDefined {
+		if newDefined[ns] == nil {
+			newDefined[ns] = make(map[string]interface{})
+		}
+		for k, v := range tags {
+			newDefined[ns][k] = v
+		}
+	}
+
+	removeFreeform, removeDefined := labelsToTags(remove, namespaces)
+	for k := range removeFreeform {
+		delete(newFreeform, k)
+	}
+	for ns, tags := range removeDefined {
+		for k := range tags {
+			delete(newDefined[ns], k)
+		}
+	}
+	return newFreeform, newDefined
+}
+
+// hasLabels returns true if the given tags carry all given labels
+func hasLabels(
+	freeform map[string]string,
+	defined map[string]map[string]interface{},
+	labels map[string]string,
+) bool {
+	if len(labels) == 0 {
+		return true
+	}
+	have := tagsToLabels(freeform, defined)
+	for k, v := range labels {
+		if value, ok := have[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,165 @@
+package storageops
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+const (
+	// DefaultPageLimit is the page size of list calls that do not set one
+	DefaultPageLimit = 100
+	// cursorVersion is the version of the encoding of cursors
+	cursorVersion = 1
+)
+
+// Cursor is the opaque position of a page in a list. The empty cursor is the
+// start of the list. Lists are ordered by a stable key, e.g. the volume ID,
+// and a cursor holds the last key returned so pages are neither skipped nor
+// repeated when items are added or removed between calls.
+type Cursor string
+
+// PageRequest is the page of a list call to return
+type PageRequest struct {
+	// Cursor is the Next cursor of the previous page, empty for the first
+	Cursor Cursor
+	// Limit is the maximum number of items, defaults to DefaultPageLimit
+	Limit int
+}
+
+type cursorData struct {
+	Version int    `json:"v"`
+	After   string `json:"after"`
+}
+
+// NewCursor returns the cursor continuing after the given key
+func NewCursor(after string) Cursor {
+	b, _ := json.Marshal(&cursorData{Version: cursorVersion, After: after})
+	return Cursor(base64.RawURLEncoding.EncodeToString(b))
+}
+
+// After returns the key the cursor continues after, empty for the start of
+// the list
+func (c Cursor) After() (string, error) {
+	if len(c) == 0 {
+		return "", nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(string(c))
+	if err != nil {
+		return "", NewStorageError(ErrVolInval, fmt.Sprintf("invalid cursor %q: %v", c, err), "")
+	}
+	data := &cursorData{}
+	if err := json.Unmarshal(b, data); err != nil || data.Version != cursorVersion {
+		return "", NewStorageError(ErrVolInval, fmt.Sprintf("invalid cursor %q", c), "")
+	}
+	return data.After, nil
+}
+
+// PageKeys returns the range [lo, hi) of the sorted keys in the requested
+// page and the cursor of the next page, empty on the last page
+func PageKeys(keys []string, req *PageRequest) (int, int, Cursor, error) {
+	if req == nil {
+		req = &PageRequest{}
+	}
+	after, err := req.Cursor.After()
+	if err != nil {
+		return 0, 0, "", err
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		limit = DefaultPageLimit
+	}
+
+	lo := 0
+	if len(req.Cursor) > 0 {
+		lo = sort.Search(len(keys), func(i int) bool { return keys[i] > after })
+	}
+	hi := lo + limit
+	if hi >= len(keys) {
+		return lo, len(keys), "", nil
+	}
+	return lo, hi, NewCursor(keys[hi-1]), nil
+}
+
+// ListVolumes returns a page of the volumes of the given driver matching the
+// given labels, ordered by ID
+func ListVolumes(ops Ops, labels map[string]string, req *PageRequest) ([]*Volume, Cursor, error) {
+	sets, err := ops.Enumerate(nil, labels, "")
+	if err != nil {
+		return nil, "", err
+	}
+	var vols []*Volume
+	for _, set := range sets {
+		vols = append(vols, set...)
+	}
+	sort.Slice(vols, func(i, j int) bool { return vols[i].ID < vols[j].ID })
+	keys := make([]string, len(vols))
+	for i, v := range vols {
+		keys[i] = v.ID
+	}
+
+	lo, hi, next, err := PageKeys(keys, req)
+	if err != nil {
+		return nil, "", err
+	}
+	return vols[lo:hi], next, nil
+}
+
+// ListSnapshots returns a page of the snapshots of the given driver matching
+// the given filter, ordered by ID
+func ListSnapshots(ops Ops, filter *SnapshotFilter, req *PageRequest) ([]*Snapshot, Cursor, error) {
+	snaps, err := ops.SnapshotEnumerate(filter)
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].ID < snaps[j].ID })
+	keys := make([]string, len(snaps))
+	for i, s := range snaps {
+		keys[i] = s.ID
+	}
+
+	lo, hi, next, err := PageKeys(keys, req)
+	if err != nil {
+		return nil, "", err
+	}
+	return snaps[lo:hi], next, nil
+}
+
+// RecordsPage returns a page of the drive records, ordered by volume ID
+func (a *AntiEntropy) RecordsPage(req *PageRequest) ([]*DriveRecord, Cursor, error) {
+	records, err := a.Records()
+	if err != nil {
+		return nil, "", err
+	}
+	keys := make([]string, 0, len(records))
+	for id := range records {
+		keys = append(keys, id)
+	}
+	sort.Strings(keys)
+
+	lo, hi, next, err := PageKeys(keys, req)
+	if err != nil {
+		return nil, "", err
+	}
+	page := make([]*DriveRecord, 0, hi-lo)
+	for _, id := range keys[lo:hi] {
+		page = append(page, records[id])
+	}
+	return page, next, nil
+}
+
+// StatusPage returns a page of the per volume status, ordered by volume ID
+func (j *BackupJob) StatusPage(req *PageRequest) ([]*VolumeBackup, Cursor, error) {
+	status := j.Status()
+	keys := make([]string, len(status))
+	for i, v := range status {
+		keys[i] = v.VolumeID
+	}
+
+	lo, hi, next, err := PageKeys(keys, req)
+	if err != nil {
+		return nil, "", err
+	}
+	return status[lo:hi], next, nil
+}
@@ -0,0 +1,184 @@
+package storageops
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// MinPollInterval is the interval of the first polls of a wait loop
+	MinPollInterval = 250 * time.Millisecond
+	// MaxPollInterval is the longest interval between the polls of a wait
+	// loop
+	MaxPollInterval = 2 * time.Second
+)
+
+const (
+	// latencyBucketFactor is the ratio between the bounds of consecutive
+	// latency histogram buckets, the first bucket ends at MinPollInterval
+	latencyBucketFactor = 1.5
+	// latencyBuckets is the number of latency histogram buckets, the last
+	// one ends at about 2m40s and catches all longer latencies
+	latencyBuckets = 16
+	// latencyMinSamples is the number of samples needed before polling
+	// follows the histogram
+	latencyMinSamples = 5
+	// latencyMaxSamples is the weight of the histogram after which all
+	// counts are halved, so that it follows changes in latency
+	latencyMaxSamples = 256
+)
+
+// pollQuantiles are the points of the latency distribution at which a wait
+// loop with enough history polls
+var pollQuantiles = []float64{0.05, 0.25, 0.5, 0.75, 0.9, 0.99}
+
+// LatencyHistogram is a decaying histogram of operation latencies with
+// exponentially sized buckets
+type LatencyHistogram struct {
+	sync.Mutex
+	counts [latencyBuckets]float64
+	total  float64
+}
+
+// bucketBounds returns the lower and upper bound of the given bucket
+func bucketBounds(i int) (time.Duration, time.Duration) {
+	if i == 0 {
+		return 0, MinPollInterval
+	}
+	hi := float64(MinPollInterval)
+	for ; i > 0; i-- {
+		hi *= latencyBucketFactor
+	}
+	return time.Duration(hi / latencyBucketFactor), time.Duration(hi)
+}
+
+// Observe records the given latency
+func (h *LatencyHistogram) Observe(d time.Duration) {
+	h.Lock()
+	defer h.Unlock()
+	i := 0
+	for ; i < latencyBuckets-1; i++ {
+		if _, hi := bucketBounds(i); d <= hi {
+			break
+		}
+	}
+	h.counts[i]++
+	h.total++
+	if h.total > latencyMaxSamples {
+		for i := range h.counts {
+			h.counts[i] /= 2
+		}
+		h.total /= 2
+	}
+}
+
+// Count returns the weight of the recorded latencies
+func (h *LatencyHistogram) Count() float64 {
+	h.Lock()
+	defer h.Unlock()
+	return h.total
+}
+
+// Quantile returns the estimated latency at quantile q, zero if nothing was
+// recorded
+func (h *LatencyHistogram) Quantile(q float64) time.Duration {
+	h.Lock()
+	defer h.Unlock()
+	return h.quantile(q)
+}
+
+func (h *LatencyHistogram) quantile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	target := q * h.total
+	cumulative := 0.0
+	for i, count := range h.counts {
+		if count == 0 || cumulative+count < target {
+			cumulative += count
+			continue
+		}
+		lo, hi := bucketBounds(i)
+		return lo + time.Duration(float64(hi-lo)*(target-cumulative)/count)
+	}
+	_, hi := bucketBounds(latencyBuckets - 1)
+	return hi
+}
+
+// NextInterval returns how long a wait loop that has been waiting for elapsed
+// and last slept for prev should sleep before polling again. Without enough
+// history it backs off exponentially from MinPollInterval, otherwise it polls
+// at the next quantile of the recorded latencies and backs off once they are
+// all past. The interval is always between MinPollInterval and
+// MaxPollInterval, so it never polls more often than the first fast polls.
+func (h *LatencyHistogram) NextInterval(elapsed, prev time.Duration) time.Duration {
+	h.Lock()
+	defer h.Unlock()
+	if h.total >= latencyMinSamples {
+		for _, q := range pollQuantiles {
+			if at := h.quantile(q); at > elapsed {
+				return clampPollInterval(at - elapsed)
+			}
+		}
+	}
+	if prev == 0 {
+		return MinPollInterval
+	}
+	return clampPollInterval(2 * prev)
+}
+
+func clampPollInterval(d time.Duration) time.Duration {
+	if d < MinPollInterval {
+		return MinPollInterval
+	}
+	if d > MaxPollInterval {
+		return MaxPollInterval
+	}
+	return d
+}
+
+var (
+	pollLatenciesLock sync.Mutex
+	pollLatencies     = make(map[string]*LatencyHistogram)
+)
+
+// PollLatency returns the process wide latency histogram of the wait loops
+// with the given key, e.g. aws/attached/gp3
+func PollLatency(key string) *LatencyHistogram {
+	pollLatenciesLock.Lock()
+	defer pollLatenciesLock.Unlock()
+	h, ok := pollLatencies[key]
+	if !ok {
+		h = &LatencyHistogram{}
+		pollLatencies[key] = h
+	}
+	return h
+}
+
+// PollWithTimeout calls f until it succeeds, returns an error that should not
+// be retried or timeout expires, like RetryWithTimeout. Instead of a fixed
+// interval it polls adaptively using the histogram returned by PollLatency
+// for key, and records the time to success in it. key is called after every
+// attempt so that it can depend on what f observed, e.g. the type of the
+// volume.
+func PollWithTimeout(
+	op string,
+	key func() string,
+	f func() (interface{}, bool, error),
+	timeout time.Duration,
+) (interface{}, error) {
+	start := time.Now()
+	out, err := retryWithTimeout(op,
+		func() (interface{}, bool, error) {
+			out, retry, err := f()
+			if err == nil {
+				PollLatency(key()).Observe(time.Since(start))
+			}
+			return out, retry, err
+		},
+		timeout,
+		func(elapsed, prev time.Duration) time.Duration {
+			return PollLatency(key()).NextInterval(elapsed, prev)
+		})
+	return out, err
+}
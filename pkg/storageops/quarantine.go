@@ -0,0 +1,128 @@
+package storageops
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultDeviceQuarantine is how long drivers hold back a device name freed
+// by a detach before handing it out again
+const DefaultDeviceQuarantine = 5 * time.Second
+
+// DeviceQuarantine holds back the device names freed by detaches on an
+// instance. Reusing a freed device name right away occasionally races the
+// teardown of the old device node by the kernel, so the next attach may show
+// up at an unexpected device or not at all.
+type DeviceQuarantine struct {
+	sync.Mutex
+	// until is when each quarantined device is released
+	until map[string]time.Time
+}
+
+var (
+	instanceQuarantinesLock sync.Mutex
+	instanceQuarantines     = make(map[string]*DeviceQuarantine)
+)
+
+// InstanceDeviceQuarantine returns the device quarantine of the given
+// instance, shared by all drivers of the process
+func InstanceDeviceQuarantine(instanceID string) *DeviceQuarantine {
+	instanceQuarantinesLock.Lock()
+	defer instanceQuarantinesLock.Unlock()
+	q, ok := instanceQuarantines[instanceID]
+	if !ok {
+		q = &DeviceQuarantine{until: make(map[string]time.Time)}
+		instanceQuarantines[instanceID] = q
+	}
+	return q
+}
+
+// Quarantine holds back the given freed device for the given period
+func (q *DeviceQuarantine) Quarantine(device string, period time.Duration) {
+	if period <= 0 {
+		return
+	}
+	q.Lock()
+	defer q.Unlock()
+	q.until[device] = time.Now().Add(period)
+}
+
+// Filter returns the devices of free that are not quarantined and, if all
+// are, when the first of them is released
+func (q *DeviceQuarantine) Filter(free []string) ([]string, time.Time) {
+	q.Lock()
+	defer q.Unlock()
+	now := time.Now()
+	var available []string
+	var next time.Time
+	for _, device := range free {
+		until, ok := q.until[device]
+		if ok && now.After(until) {
+			delete(q.until, device)
+			ok = false
+		}
+		if !ok {
+			available = append(available, device)
+		} else if next.IsZero() || until.Before(next) {
+			next = until
+		}
+	}
+	if len(available) > 0 {
+		return available, time.Time{}
+	}
+	return nil, next
+}
+
+// Await returns the devices of free that are not quarantined, waiting for
+// the first quarantined one to be released if all are. It fails if none is
+// released before the deadline. Waits are reported to the quarantine
+// observers of the given provider.
+func (q *DeviceQuarantine) Await(provider string, free []string, deadline time.Time) ([]string, error) {
+	start := time.Now()
+	waited := false
+	for {
+		available, next := q.Filter(free)
+		if len(available) > 0 || next.IsZero() {
+			if waited {
+				reportQuarantineWait(provider, time.Since(start))
+			}
+			return available, nil
+		}
+		if next.After(deadline) {
+			devices := append([]string(nil), free...)
+			sort.Strings(devices)
+			return nil, NewStorageError(ErrDeviceBusy,
+				fmt.Sprintf("devices %v are quarantined after a detach until %s",
+					devices, next.Format(time.RFC3339)), "")
+		}
+		waited = true
+		time.Sleep(time.Until(next))
+	}
+}
+
+var (
+	quarantineObserversLock sync.Mutex
+	quarantineObservers     []QuarantineObserver
+)
+
+// QuarantineObserver is notified when an attach of the given provider waited
+// for a quarantined device
+type QuarantineObserver func(provider string, wait time.Duration)
+
+// AddQuarantineObserver registers an observer of all quarantine waits
+func AddQuarantineObserver(o QuarantineObserver) {
+	quarantineObserversLock.Lock()
+	defer quarantineObserversLock.Unlock()
+	quarantineObservers = append(quarantineObservers, o)
+}
+
+func reportQuarantineWait(provider string, wait time.Duration) {
+	quarantineObserversLock.Lock()
+	observers := quarantineObservers
+	quarantineObserversLock.Unlock()
+	for _, o := range observers {
+		o(provider, wait)
+	}
+}
@@ -0,0 +1,156 @@
+package storageops
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// queueCheckInterval is how often queued operations check their deadline
+const queueCheckInterval = time.Second
+
+// OpQueue runs operations targeting the same instance one at a time. Queued
+// operations are started in order of their deadlines, earliest first, and
+// their deadlines are extended while the running operation reports progress,
+// so a stampede of attaches to a single instance during failover does not
+// time out operations that are merely waiting their turn.
+type OpQueue struct {
+	sync.Mutex
+	grace        time.Duration
+	busy         bool
+	seq          uint64
+	waiters      []*queueWaiter
+	lastProgress time.Time
+}
+
+type queueWaiter struct {
+	deadline time.Time
+	seq      uint64
+	ready    chan struct{}
+}
+
+// NewOpQueue creates a queue. A queued operation whose deadline has passed
+// keeps waiting as long as the running operation reported progress within
+// the given grace period.
+func NewOpQueue(grace time.Duration) *OpQueue {
+	return &OpQueue{grace: grace}
+}
+
+var (
+	instanceQueuesLock sync.Mutex
+	instanceQueues     = make(map[string]*OpQueue)
+)
+
+// InstanceQueue returns the process wide queue of the given instance
+func InstanceQueue(instanceID string) *OpQueue {
+	instanceQueuesLock.Lock()
+	defer instanceQueuesLock.Unlock()
+	q, ok := instanceQueues[instanceID]
+	if !ok {
+		q = NewOpQueue(ProviderOpsTimeout)
+		instanceQueues[instanceID] = q
+	}
+	return q
+}
+
+// AttachDeadline returns the deadline of an attach with the given options,
+// its AttachOptionDeadline or, if it has none, the given timeout from now
+func AttachDeadline(options map[string]string, timeout time.Duration) (time.Time, error) {
+	value, ok := options[AttachOptionDeadline]
+	if !ok {
+		return time.Now().Add(timeout), nil
+	}
+	deadline, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, NewStorageError(ErrVolInval,
+			fmt.Sprintf("invalid attach deadline %q: %v", value, err), "")
+	}
+	return deadline, nil
+}
+
+// Acquire waits for the turn of an operation with the given deadline. It
+// returns a function that must be called once the operation is done.
+func (q *OpQueue) Acquire(deadline time.Time) (func(), error) {
+	q.Lock()
+	if !q.busy && len(q.waiters) == 0 {
+		q.busy = true
+		q.lastProgress = time.Now()
+		q.Unlock()
+		return q.release, nil
+	}
+
+	q.seq++
+	w := &queueWaiter{
+		deadline: deadline,
+		seq:      q.seq,
+		ready:    make(chan struct{}),
+	}
+	q.waiters = append(q.waiters, w)
+	sort.Slice(q.waiters, func(i, j int) bool {
+		if q.waiters[i].deadline.Equal(q.waiters[j].deadline) {
+			return q.waiters[i].seq < q.waiters[j].seq
+		}
+		return q.waiters[i].deadline.Before(q.waiters[j].deadline)
+	})
+	q.Unlock()
+
+	ticker := time.NewTicker(queueCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.ready:
+			return q.release, nil
+		case now := <-ticker.C:
+			q.Lock()
+			if now.Before(w.deadline) || now.Sub(q.lastProgress) < q.grace {
+				q.Unlock()
+				continue
+			}
+			select {
+			case <-w.ready:
+				// granted while checking the deadline
+				q.Unlock()
+				return q.release, nil
+			default:
+			}
+			for i := range q.waiters {
+				if q.waiters[i] == w {
+					q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+					break
+				}
+			}
+			q.Unlock()
+			return nil, fmt.Errorf("timed out after %v waiting for %d earlier operations",
+				now.Sub(w.deadline), len(q.waiters))
+		}
+	}
+}
+
+// Progress is called by the running operation to extend the deadlines of
+// the queued operations
+func (q *OpQueue) Progress() {
+	q.Lock()
+	q.lastProgress = time.Now()
+	q.Unlock()
+}
+
+// Len returns the number of queued operations
+func (q *OpQueue) Len() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.waiters)
+}
+
+func (q *OpQueue) release() {
+	q.Lock()
+	defer q.Unlock()
+	q.lastProgress = time.Now()
+	if len(q.waiters) == 0 {
+		q.busy = false
+		return
+	}
+	next := q.waiters[0]
+	q.waiters = q.waiters[1:]
+	close(next.ready)
+}
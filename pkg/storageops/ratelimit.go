@@ -0,0 +1,125 @@
+package storageops
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// tokenBucketMinRateDivisor bounds how far throttling lowers the rate of
+	// a token bucket, relative to its configured rate
+	tokenBucketMinRateDivisor = 16
+	// tokenBucketRecoverySteps is the number of successful calls after which
+	// a throttled bucket is back at its configured rate
+	tokenBucketRecoverySteps = 20
+)
+
+// TokenBucket is a client side rate limiter of provider API calls. Its rate
+// adapts to throttling: it is halved on every throttled call and recovers
+// additively on successful ones.
+type TokenBucket struct {
+	sync.Mutex
+	maxRate float64
+	rate    float64
+	burst   float64
+	tokens  float64
+	last    time.Time
+}
+
+// NewTokenBucket creates a bucket allowing rate calls per second with bursts
+// of up to burst calls
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{
+		maxRate: rate,
+		rate:    rate,
+		burst:   float64(burst),
+		tokens:  float64(burst),
+		last:    time.Now(),
+	}
+}
+
+// Reserve takes a token and returns how long the caller must wait before
+// making its call
+func (b *TokenBucket) Reserve() time.Duration {
+	b.Lock()
+	defer b.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Wait blocks until the caller may make its call
+func (b *TokenBucket) Wait() {
+	if d := b.Reserve(); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// Throttled halves the rate after the provider throttled a call
+func (b *TokenBucket) Throttled() {
+	b.Lock()
+	defer b.Unlock()
+	b.rate /= 2
+	if min := b.maxRate / tokenBucketMinRateDivisor; b.rate < min {
+		b.rate = min
+	}
+	if b.tokens > 0 {
+		b.tokens = 0
+	}
+}
+
+// Succeeded raises a throttled rate back towards the configured one
+func (b *TokenBucket) Succeeded() {
+	b.Lock()
+	defer b.Unlock()
+	b.rate += b.maxRate / tokenBucketRecoverySteps
+	if b.rate > b.maxRate {
+		b.rate = b.maxRate
+	}
+}
+
+// Rate returns the current rate in calls per second
+func (b *TokenBucket) Rate() float64 {
+	b.Lock()
+	defer b.Unlock()
+	return b.rate
+}
+
+var (
+	throttleObserversLock sync.Mutex
+	throttleObservers     []ThrottleObserver
+)
+
+// ThrottleObserver is notified when a provider throttled the given API call
+type ThrottleObserver func(provider, api string)
+
+// AddThrottleObserver registers an observer of all throttled calls
+func AddThrottleObserver(o ThrottleObserver) {
+	throttleObserversLock.Lock()
+	defer throttleObserversLock.Unlock()
+	throttleObservers = append(throttleObservers, o)
+}
+
+// ReportThrottle notifies the observers that the given provider throttled
+// the given API call
+func ReportThrottle(provider, api string) {
+	throttleObserversLock.Lock()
+	observers := throttleObservers
+	throttleObserversLock.Unlock()
+	for _, o := range observers {
+		o(provider, api)
+	}
+}
@@ -0,0 +1,128 @@
+package storageops
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/libopenstorage/openstorage/pkg/auth"
+)
+
+// Role is the cloud drive role of a caller
+type Role string
+
+const (
+	// RoleNone is the role of callers without a cloud drive role
+	RoleNone Role = ""
+	// RoleViewer can list and inspect volumes and snapshots
+	RoleViewer Role = "clouddrive.viewer"
+	// RoleOperator can in addition attach, detach, create and snapshot
+	RoleOperator Role = "clouddrive.operator"
+	// RoleAdmin can in addition delete volumes and snapshots and modify
+	// policies
+	RoleAdmin Role = "clouddrive.admin"
+)
+
+const (
+	// OpModifyPolicy is the operation name authorizing changes to cloud drive
+	// policies, e.g. budgets or fsck policies, for APIs managing them
+	OpModifyPolicy = "ModifyPolicy"
+)
+
+var (
+	// roleRank orders the roles, each role has the permissions of the lower
+	// ranked ones
+	roleRank = map[Role]int{
+		RoleNone:     0,
+		RoleViewer:   1,
+		RoleOperator: 2,
+		RoleAdmin:    3,
+	}
+	// systemRoles maps the openstorage system roles of the token claims to
+	// cloud drive roles
+	systemRoles = map[string]Role{
+		"system.view":  RoleViewer,
+		"system.user":  RoleOperator,
+		"system.admin": RoleAdmin,
+	}
+	// opRoles is the minimum role per operation. Operations not listed
+	// require RoleAdmin.
+	opRoles = map[string]Role{
+		"GetDeviceID":       RoleViewer,
+		"GetZone":           RoleViewer,
+		"GetRegion":         RoleViewer,
+		"ListZones":         RoleViewer,
+		"Describe":          RoleViewer,
+		"FreeDevices":       RoleViewer,
+		"Inspect":           RoleViewer,
+		"DeviceMappings":    RoleViewer,
+		"Enumerate":         RoleViewer,
+		"DevicePath":        RoleViewer,
+		"SnapshotEnumerate": RoleViewer,
+		"SnapshotStatus":    RoleViewer,
+		"Tags":              RoleViewer,
+		"Create":            RoleOperator,
+		"Expand":            RoleOperator,
+		"Modify":            RoleOperator,
+		"Attach":            RoleOperator,
+		"Detach":            RoleOperator,
+		"DetachFrom":        RoleOperator,
+		"Snapshot":          RoleOperator,
+		"SnapshotRestore":   RoleOperator,
+		"ApplyTags":         RoleOperator,
+		"RemoveTags":        RoleOperator,
+		"Delete":            RoleAdmin,
+		"DeleteFrom":        RoleAdmin,
+		"SnapshotDelete":    RoleAdmin,
+		OpModifyPolicy:      RoleAdmin,
+		OpRequestRestore:    RoleViewer,
+		OpApproveRestore:    RoleAdmin,
+	}
+)
+
+// RoleOf returns the highest cloud drive role in the given token claims
+func RoleOf(claims *auth.Claims) Role {
+	role := RoleNone
+	for _, name := range claims.Roles {
+		r, ok := systemRoles[name]
+		if !ok {
+			r = Role(name)
+		}
+		if rank, ok := roleRank[r]; ok && rank > roleRank[role] {
+			role = r
+		}
+	}
+	return role
+}
+
+// Authorize returns ErrPermissionDenied if the given user may not perform
+// the named operation. A nil user is allowed everything, as it means the
+// system runs without auth.
+func Authorize(user *auth.UserInfo, op string) error {
+	if user == nil {
+		return nil
+	}
+	required, ok := opRoles[op]
+	if !ok {
+		required = RoleAdmin
+	}
+	if role := RoleOf(&user.Claims); roleRank[role] < roleRank[required] {
+		return NewStorageError(ErrPermissionDenied,
+			fmt.Sprintf("%s requires role %s, user %s has role %q",
+				op, required, user.Username, role), "")
+	}
+	return nil
+}
+
+// AuthorizationMiddleware returns a middleware that authorizes every
+// operation against the user in the given request context, see
+// auth.ContextSaveUserInfo. API handlers create the driver of a request with
+// config.NewWithContext, which wraps it with this middleware.
+func AuthorizationMiddleware(ctx context.Context) Middleware {
+	user, _ := auth.NewUserInfoFromContext(ctx)
+	return InterceptorMiddleware(func(op string, args []interface{}, invoke Invoker) error {
+		if err := Authorize(user, op); err != nil {
+			return err
+		}
+		return invoke()
+	})
+}
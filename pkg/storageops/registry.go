@@ -0,0 +1,52 @@
+package storageops
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// InitFunc creates a driver from provider specific params, e.g. the vCenter
+// of vSphere. Whatever the params do not set is discovered or read from the
+// environment like the env clients of the providers do.
+type InitFunc func(params map[string]string) (Ops, error)
+
+var (
+	initFuncsLock sync.Mutex
+	initFuncs     = make(map[string]InitFunc)
+)
+
+// Register registers the init function of the driver with the given name,
+// replacing a function registered before. Drivers register theirs on init,
+// so Get only knows the drivers imported by the binary. Out-of-tree drivers
+// register the same way.
+func Register(name string, initFn InitFunc) {
+	initFuncsLock.Lock()
+	defer initFuncsLock.Unlock()
+	initFuncs[name] = initFn
+}
+
+// Get returns the init function of the driver with the given name. It
+// returns an ErrNotSupported error if no driver registered the name.
+func Get(name string) (InitFunc, error) {
+	initFuncsLock.Lock()
+	initFn, ok := initFuncs[name]
+	initFuncsLock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: no driver %s registered, registered are %v",
+			ErrNotSupported, name, Registered())
+	}
+	return initFn, nil
+}
+
+// Registered returns the sorted names of the registered drivers
+func Registered() []string {
+	initFuncsLock.Lock()
+	defer initFuncsLock.Unlock()
+	names := make([]string, 0, len(initFuncs))
+	for name := range initFuncs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
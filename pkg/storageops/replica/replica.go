@@ -0,0 +1,228 @@
+// Package replica periodically materializes a read-only copy of a volume from
+// its latest snapshot on an analytics node, so analytics workloads never
+// touch the live volume.
+package replica
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ReplicaOfLabel is the label applied to replica volumes with the ID of
+	// the volume they are a copy of
+	ReplicaOfLabel = "openstorage-replica-of"
+	// ReplicaNodeLabel is the label applied to replica volumes with the ID of
+	// the instance they are materialized on
+	ReplicaNodeLabel = "openstorage-replica-node"
+	// ReplicaSnapshotLabel is the label applied to replica volumes with the ID
+	// of the snapshot they were restored from
+	ReplicaSnapshotLabel = "openstorage-replica-snapshot"
+)
+
+// Spec describes the replica of a volume on an analytics node
+type Spec struct {
+	// VolumeID of the production volume to replicate
+	VolumeID string
+	// LinkPath is the stable symlink pointing at the device of the current
+	// replica, e.g. /dev/analytics/orders
+	LinkPath string
+	// Zone to create the replica in, defaults to the zone of the instance
+	Zone string
+	// Labels are extra labels applied to the replica volumes
+	Labels map[string]string
+}
+
+// Result is the outcome of a refresh
+type Result struct {
+	// SnapshotID is the snapshot the current replica was restored from
+	SnapshotID string
+	// VolumeID of the current replica
+	VolumeID string
+	// DevicePath the current replica is attached at
+	DevicePath string
+	// Refreshed is false if the replica was already at the latest snapshot
+	Refreshed bool
+	// Deleted are the IDs of the previous replicas that were deleted
+	Deleted []string
+}
+
+func (r *Result) String() string {
+	return fmt.Sprintf("replica %s from snapshot %s at %s (refreshed %v, deleted %v)",
+		r.VolumeID, r.SnapshotID, r.DevicePath, r.Refreshed, r.Deleted)
+}
+
+// Replica manages the replica of a volume through the storage ops driver of
+// the analytics node it is materialized on
+type Replica struct {
+	ops  storageops.Ops
+	spec *Spec
+}
+
+// New creates the replica described by spec, ops being the driver of the
+// analytics node
+func New(ops storageops.Ops, spec *Spec) (*Replica, error) {
+	if len(spec.VolumeID) == 0 || len(spec.LinkPath) == 0 {
+		return nil, fmt.Errorf("volume ID and link path are required")
+	}
+	return &Replica{
+		ops:  ops,
+		spec: spec,
+	}, nil
+}
+
+func (r *Replica) labels() map[string]string {
+	return map[string]string{
+		ReplicaOfLabel:   r.spec.VolumeID,
+		ReplicaNodeLabel: r.ops.InstanceID(),
+	}
+}
+
+// Refresh materializes a new replica if a newer completed snapshot exists:
+// it restores the snapshot, attaches the volume read-only, points LinkPath at
+// it and deletes the previous replicas of this node. The previous replica
+// stays in use until the link is swapped.
+func (r *Replica) Refresh() (*Result, error) {
+	snap, err := r.latestSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	previous, err := r.ops.Enumerate(nil, r.labels(), "")
+	if err != nil {
+		return nil, err
+	}
+	var current *storageops.Volume
+	for _, vol := range previous[storageops.SetIdentifierNone] {
+		if vol.Labels[ReplicaSnapshotLabel] == snap.ID {
+			current = vol
+			break
+		}
+	}
+
+	result := &Result{SnapshotID: snap.ID}
+	if current == nil {
+		labels := r.labels()
+		for k, v := range r.spec.Labels {
+			labels[k] = v
+		}
+		labels[ReplicaSnapshotLabel] = snap.ID
+		if current, err = r.ops.SnapshotRestore(snap.ID, r.spec.Zone, labels); err != nil {
+			return nil, fmt.Errorf("failed to restore snapshot %v: %v", snap.ID, err)
+		}
+		result.Refreshed = true
+	}
+	result.VolumeID = current.ID
+
+	// A replica of the latest snapshot is reused, e.g. after a restart
+	if result.DevicePath, err = r.ops.DevicePath(current.ID); err != nil {
+		result.DevicePath, err = r.ops.Attach(current.ID, map[string]string{
+			storageops.AttachOptionReadOnly: "true",
+		})
+		if err != nil {
+			if result.Refreshed {
+				r.delete(current.ID)
+			}
+			return nil, fmt.Errorf("failed to attach replica %v: %v", current.ID, err)
+		}
+	}
+
+	if err := swapLink(result.DevicePath, r.spec.LinkPath); err != nil {
+		if result.Refreshed {
+			r.delete(current.ID)
+		}
+		return nil, err
+	}
+
+	for _, old := range previous[storageops.SetIdentifierNone] {
+		if old.ID != current.ID && r.delete(old.ID) {
+			result.Deleted = append(result.Deleted, old.ID)
+		}
+	}
+	return result, nil
+}
+
+// Run refreshes the replica every interval until stop is closed. onRefresh,
+// if set, is called with the outcome of every refresh.
+func (r *Replica) Run(
+	interval time.Duration,
+	stop <-chan struct{},
+	onRefresh func(*Result, error),
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			result, err := r.Refresh()
+			if err != nil {
+				logrus.Warnf("refresh of replica of %v failed: %v", r.spec.VolumeID, err)
+			}
+			if onRefresh != nil {
+				onRefresh(result, err)
+			}
+		}
+	}
+}
+
+// latestSnapshot returns the most recent completed snapshot of the volume
+func (r *Replica) latestSnapshot() (*storageops.Snapshot, error) {
+	snaps, err := r.ops.SnapshotEnumerate(&storageops.SnapshotFilter{
+		VolumeID: r.spec.VolumeID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].Created.After(snaps[j].Created) })
+	for _, snap := range snaps {
+		status, err := r.ops.SnapshotStatus(snap.ID)
+		if err == storageops.ErrNotSupported {
+			return snap, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if status.Completed {
+			return snap, nil
+		}
+	}
+	return nil, storageops.NewStorageError(storageops.ErrVolNotFound,
+		fmt.Sprintf("no completed snapshot of %v", r.spec.VolumeID), r.ops.InstanceID())
+}
+
+// delete detaches and deletes the given replica, returning false on failure
+func (r *Replica) delete(volumeID string) bool {
+	if err := r.ops.Detach(volumeID); err != nil {
+		if !storageops.IsErrorCode(err, storageops.ErrVolDetached) {
+			logrus.Warnf("failed to detach replica %v: %v", volumeID, err)
+			return false
+		}
+	}
+	if err := r.ops.Delete(volumeID); err != nil {
+		logrus.Warnf("failed to delete replica %v: %v", volumeID, err)
+		return false
+	}
+	return true
+}
+
+// swapLink atomically points link at target by renaming a new symlink over it
+func swapLink(target, link string) error {
+	tmp := link + ".tmp"
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Symlink(target, tmp); err != nil {
+		return fmt.Errorf("failed to link %v to %v: %v", tmp, target, err)
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to swap link %v to %v: %v", link, target, err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package replica
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefresh(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replica")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	link := filepath.Join(dir, "orders")
+
+	prod := mock.New("prod-1", "zone-a")
+	analytics := prod.ForInstance("analytics-1", "zone-a")
+
+	vol, err := prod.Create(&mock.Volume{SizeGiB: 10}, nil)
+	require.NoError(t, err)
+	volumeID, err := prod.GetDeviceID(vol)
+	require.NoError(t, err)
+
+	r, err := New(analytics, &Spec{VolumeID: volumeID, LinkPath: link})
+	require.NoError(t, err)
+	_, err = r.Refresh()
+	require.Error(t, err, "no snapshot to replicate yet")
+
+	_, err = prod.Snapshot(volumeID, true)
+	require.NoError(t, err)
+	first, err := r.Refresh()
+	require.NoError(t, err)
+	require.True(t, first.Refreshed)
+	target, err := os.Readlink(link)
+	require.NoError(t, err)
+	require.Equal(t, first.DevicePath, target)
+
+	again, err := r.Refresh()
+	require.NoError(t, err)
+	require.False(t, again.Refreshed)
+	require.Equal(t, first.VolumeID, again.VolumeID)
+
+	time.Sleep(time.Millisecond)
+	_, err = prod.Snapshot(volumeID, true)
+	require.NoError(t, err)
+	second, err := r.Refresh()
+	require.NoError(t, err)
+	require.True(t, second.Refreshed)
+	require.NotEqual(t, first.VolumeID, second.VolumeID)
+	require.Equal(t, []string{first.VolumeID}, second.Deleted)
+	target, err = os.Readlink(link)
+	require.NoError(t, err)
+	require.Equal(t, second.DevicePath, target)
+
+	vols, err := analytics.Enumerate(nil, map[string]string{ReplicaOfLabel: volumeID}, "")
+	require.NoError(t, err)
+	require.Len(t, vols["None"], 1)
+}
@@ -0,0 +1,65 @@
+package storageops
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultDeviceReservationDir is where drivers keep the device reservations
+// of the host. It is on tmpfs, so reservations do not survive a reboot.
+const DefaultDeviceReservationDir = "/run/openstorage/devices"
+
+// DeviceReservation is the claim of a device name of an instance by an
+// attach, held from picking the device until the attach completes. Unlike
+// the attach lock of a driver it is seen by all processes on the host, so
+// agents attaching to the same instance never pick the same device name.
+type DeviceReservation struct {
+	file *os.File
+}
+
+// ReserveDevice reserves the given device name of the given instance with an
+// exclusive lock on a file under dir. Devices that are different names of
+// the same attachment slot, e.g. /dev/sdf and /dev/xvdf, must be reserved by
+// the same name. Returns an ErrDeviceBusy error if the device is reserved by
+// another attach, in this or another process.
+func ReserveDevice(dir, instance, device string) (*DeviceReservation, error) {
+	instanceDir := filepath.Join(dir, reservationName(instance))
+	if err := os.MkdirAll(instanceDir, 0700); err != nil {
+		return nil, err
+	}
+	// Lock files are never removed, as removing a file another process is
+	// about to lock would let two processes hold reservations of a device
+	path := filepath.Join(instanceDir, reservationName(device)+".lock")
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		file.Close()
+		if err == unix.EWOULDBLOCK {
+			return nil, NewStorageError(ErrDeviceBusy,
+				fmt.Sprintf("device %s is reserved by another attach", device), instance)
+		}
+		return nil, err
+	}
+	return &DeviceReservation{file: file}, nil
+}
+
+// Release releases the reservation. Releasing a nil reservation is a no-op.
+func (r *DeviceReservation) Release() {
+	if r == nil || r.file == nil {
+		return
+	}
+	// Closing the file releases the lock
+	r.file.Close()
+	r.file = nil
+}
+
+// reservationName returns the given instance or device name as a file name
+func reservationName(name string) string {
+	return strings.Replace(strings.TrimPrefix(name, "/dev/"), "/", "_", -1)
+}
@@ -0,0 +1,370 @@
+package storageops
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/libopenstorage/openstorage/pkg/auth"
+	"github.com/pborman/uuid"
+	"github.com/portworx/kvdb"
+	"github.com/sirupsen/logrus"
+)
+
+// restoreKeyPrefix is the kvdb prefix of the restore requests
+const restoreKeyPrefix = "storageops/restores/"
+
+const (
+	// OpRequestRestore is the operation name authorizing restore requests,
+	// which any viewer may file
+	OpRequestRestore = "RequestRestore"
+	// OpApproveRestore is the operation name authorizing the approval and
+	// rejection of restore requests
+	OpApproveRestore = "ApproveRestore"
+)
+
+// RestoreState is the state of a restore request
+type RestoreState string
+
+const (
+	// RestorePending requests wait for approval
+	RestorePending RestoreState = "pending"
+	// RestoreRejected requests were rejected and are never executed
+	RestoreRejected RestoreState = "rejected"
+	// RestoreRunning requests were approved and are being executed
+	RestoreRunning RestoreState = "running"
+	// RestoreCompleted requests were executed, see RestoreRequest.VolumeID
+	RestoreCompleted RestoreState = "completed"
+	// RestoreFailed requests were approved but the restore failed, see
+	// RestoreRequest.Err
+	RestoreFailed RestoreState = "failed"
+)
+
+// RestoreAuditEntry is a single step in the life of a restore request
+type RestoreAuditEntry struct {
+	// Time of the step
+	Time time.Time `json:"time"`
+	// User that performed the step, empty without auth
+	User string `json:"user,omitempty"`
+	// Action is the step, e.g. requested, approval-requested, approved,
+	// rejected, completed or failed
+	Action string `json:"action"`
+	// Message is the reason given by the user or the error of the step
+	Message string `json:"message,omitempty"`
+}
+
+// RestoreRequest is a persisted request to restore a snapshot, executed only
+// once approved
+type RestoreRequest struct {
+	// ID of the request
+	ID string `json:"id"`
+	// SnapshotID of the snapshot to restore
+	SnapshotID string `json:"snapshotId"`
+	// Zone to restore the snapshot into, empty for the zone of the driver
+	Zone string `json:"zone,omitempty"`
+	// Labels of the restored volume
+	Labels map[string]string `json:"labels,omitempty"`
+	// Requester is the user that filed the request
+	Requester string `json:"requester,omitempty"`
+	// Reason given by the requester
+	Reason string `json:"reason,omitempty"`
+	// State of the request
+	State RestoreState `json:"state"`
+	// VolumeID of the restored volume once completed
+	VolumeID string `json:"volumeId,omitempty"`
+	// Err is the error of the restore if it failed
+	Err string `json:"error,omitempty"`
+	// Created is when the request was filed
+	Created time.Time `json:"created"`
+	// Audit is the trail of the request, oldest first
+	Audit []*RestoreAuditEntry `json:"audit"`
+}
+
+// RestoreApprover asks for the approval of new restore requests, e.g. by
+// notifying a ticketing system that calls RestoreRequests.Approve or Reject
+// once decided
+type RestoreApprover interface {
+	// RequestApproval is called with every new restore request
+	RequestApproval(req *RestoreRequest) error
+}
+
+// RestoreApproverFunc is a function implementing RestoreApprover
+type RestoreApproverFunc func(req *RestoreRequest) error
+
+// RequestApproval calls f
+func (f RestoreApproverFunc) RequestApproval(req *RestoreRequest) error {
+	return f(req)
+}
+
+type webhookApprover struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookApprover returns an approver that posts every new request as JSON
+// to the given URL with the given client, nil for the default client. Any
+// response but a 2xx fails the approval request.
+func NewWebhookApprover(url string, client *http.Client) RestoreApprover {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &webhookApprover{url: url, client: client}
+}
+
+func (w *webhookApprover) RequestApproval(req *RestoreRequest) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("approval webhook returned %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// RestoreRequests lets callers without the role to restore snapshots request
+// restores, which the driver executes once an admin approved them. Requests
+// and their audit trail are persisted in kvdb.
+type RestoreRequests struct {
+	kv       kvdb.Kvdb
+	ops      Ops
+	approver RestoreApprover
+}
+
+// NewRestoreRequests returns the restore requests of the given driver. The
+// approver is called with every new request, nil if approvals are polled
+// with List.
+func NewRestoreRequests(kv kvdb.Kvdb, ops Ops, approver RestoreApprover) *RestoreRequests {
+	return &RestoreRequests{kv: kv, ops: ops, approver: approver}
+}
+
+func (r *RestoreRequests) prefix() string {
+	return restoreKeyPrefix + r.ops.Name() + "/"
+}
+
+func (r *RestoreRequests) key(id string) string {
+	return r.prefix() + "requests/" + id
+}
+
+// restoreUser returns the name of the user of the given context and authorizes
+// it for the given operation
+func restoreUser(ctx context.Context, op string) (string, error) {
+	user, _ := auth.NewUserInfoFromContext(ctx)
+	if err := Authorize(user, op); err != nil {
+		return "", err
+	}
+	if user == nil {
+		return "", nil
+	}
+	return user.Username, nil
+}
+
+func (req *RestoreRequest) audit(user, action, message string) {
+	req.Audit = append(req.Audit, &RestoreAuditEntry{
+		Time:    time.Now().UTC(),
+		User:    user,
+		Action:  action,
+		Message: message,
+	})
+}
+
+// Request files a request to restore the given snapshot into the given zone
+// with the given labels, on behalf of the user of the given context, see
+// auth.ContextSaveUserInfo. The request is persisted before the approver is
+// called. If the approver fails the request stays pending and the error is
+// returned with it.
+func (r *RestoreRequests) Request(
+	ctx context.Context,
+	snapID, zone string,
+	labels map[string]string,
+	reason string,
+) (*RestoreRequest, error) {
+	user, err := restoreUser(ctx, OpRequestRestore)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.ops.SnapshotStatus(snapID); err != nil && err != ErrNotSupported {
+		return nil, err
+	}
+
+	req := &RestoreRequest{
+		ID:         uuid.New(),
+		SnapshotID: snapID,
+		Zone:       zone,
+		Labels:     labels,
+		Requester:  user,
+		Reason:     reason,
+		State:      RestorePending,
+		Created:    time.Now().UTC(),
+	}
+	req.audit(user, "requested", reason)
+	if _, err := r.kv.Create(r.key(req.ID), req, 0); err != nil {
+		return nil, err
+	}
+	if r.approver == nil {
+		return req, nil
+	}
+
+	approvalErr := r.approver.RequestApproval(req)
+	action, message := "approval-requested", ""
+	if approvalErr != nil {
+		action, message = "approval-request-failed", approvalErr.Error()
+	}
+	updated, err := r.update(req.ID, func(req *RestoreRequest) error {
+		req.audit("", action, message)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if approvalErr != nil {
+		return updated, fmt.Errorf("failed to request approval of restore %s: %v",
+			req.ID, approvalErr)
+	}
+	return updated, nil
+}
+
+// update applies fn to the given request and persists it, holding the lock
+// of the request so concurrent approvals cannot both execute it
+func (r *RestoreRequests) update(id string, fn func(req *RestoreRequest) error) (*RestoreRequest, error) {
+	lock, err := r.kv.Lock(r.prefix() + "locks/" + id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock restore request %s: %v", id, err)
+	}
+	defer func() {
+		if err := r.kv.Unlock(lock); err != nil {
+			logrus.Warnf("failed to unlock restore request %s: %v", id, err)
+		}
+	}()
+
+	req, err := r.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(req); err != nil {
+		return nil, err
+	}
+	if _, err := r.kv.Put(r.key(id), req, 0); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// Get returns the given restore request
+func (r *RestoreRequests) Get(id string) (*RestoreRequest, error) {
+	kvp, err := r.kv.Get(r.key(id))
+	if err == kvdb.ErrNotFound {
+		return nil, NewStorageError(ErrVolNotFound,
+			fmt.Sprintf("restore request %s not found", id), "")
+	} else if err != nil {
+		return nil, err
+	}
+	req := &RestoreRequest{}
+	if err := json.Unmarshal(kvp.Value, req); err != nil {
+		return nil, fmt.Errorf("invalid restore request %s: %v", id, err)
+	}
+	return req, nil
+}
+
+// List returns the restore requests in the given state, all if empty, oldest
+// first
+func (r *RestoreRequests) List(state RestoreState) ([]*RestoreRequest, error) {
+	kvps, err := r.kv.Enumerate(r.prefix() + "requests/")
+	if err != nil {
+		return nil, err
+	}
+	var reqs []*RestoreRequest
+	for _, kvp := range kvps {
+		req := &RestoreRequest{}
+		if err := json.Unmarshal(kvp.Value, req); err != nil {
+			return nil, fmt.Errorf("invalid restore request %s: %v", kvp.Key, err)
+		}
+		if len(state) == 0 || req.State == state {
+			reqs = append(reqs, req)
+		}
+	}
+	sort.Slice(reqs, func(i, j int) bool { return reqs[i].Created.Before(reqs[j].Created) })
+	return reqs, nil
+}
+
+// pending returns an ErrVolInval error if the given request is not pending,
+// and an ErrPermissionDenied error if the given user filed it, as requesters
+// may not approve their own restores
+func (req *RestoreRequest) pending(user string) error {
+	if req.State != RestorePending {
+		return NewStorageError(ErrVolInval,
+			fmt.Sprintf("restore request %s is %s", req.ID, req.State), "")
+	}
+	if len(user) > 0 && user == req.Requester {
+		return NewStorageError(ErrPermissionDenied,
+			fmt.Sprintf("user %s may not decide on their own restore request %s", user, req.ID), "")
+	}
+	return nil
+}
+
+// Approve approves the given pending request on behalf of the admin of the
+// given context and executes the restore. The returned request is completed
+// with the restored volume or failed with the error of the restore.
+func (r *RestoreRequests) Approve(ctx context.Context, id, reason string) (*RestoreRequest, error) {
+	user, err := restoreUser(ctx, OpApproveRestore)
+	if err != nil {
+		return nil, err
+	}
+	req, err := r.update(id, func(req *RestoreRequest) error {
+		if err := req.pending(user); err != nil {
+			return err
+		}
+		req.State = RestoreRunning
+		req.audit(user, "approved", reason)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var volumeID string
+	vol, restoreErr := r.ops.SnapshotRestore(req.SnapshotID, req.Zone, req.Labels)
+	if restoreErr == nil {
+		volumeID = vol.ID
+	}
+	return r.update(id, func(req *RestoreRequest) error {
+		if restoreErr != nil {
+			req.State = RestoreFailed
+			req.Err = restoreErr.Error()
+			req.audit("", "failed", req.Err)
+			return nil
+		}
+		req.State = RestoreCompleted
+		req.VolumeID = volumeID
+		req.audit("", "completed", volumeID)
+		return nil
+	})
+}
+
+// Reject rejects the given pending request on behalf of the admin of the
+// given context
+func (r *RestoreRequests) Reject(ctx context.Context, id, reason string) (*RestoreRequest, error) {
+	user, err := restoreUser(ctx, OpApproveRestore)
+	if err != nil {
+		return nil, err
+	}
+	return r.update(id, func(req *RestoreRequest) error {
+		if err := req.pending(user); err != nil {
+			return err
+		}
+		req.State = RestoreRejected
+		req.audit(user, "rejected", reason)
+		return nil
+	})
+}
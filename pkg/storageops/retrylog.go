@@ -0,0 +1,186 @@
+package storageops
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/portworx/sched-ops/task"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultRetryProgressInterval is how often a wait loop logs its progress
+	// by default
+	DefaultRetryProgressInterval = 30 * time.Second
+	// VerboseRetryLogsEnv is the env variable that enables verbose wait loop
+	// logging when set to true
+	VerboseRetryLogsEnv = "STORAGEOPS_VERBOSE_RETRY_LOGS"
+	// RetryProgressIntervalEnv is the env variable with the progress interval
+	// of wait loops, e.g. 1m
+	RetryProgressIntervalEnv = "STORAGEOPS_RETRY_PROGRESS_INTERVAL"
+)
+
+var (
+	retryLogLock          sync.Mutex
+	verboseRetryLogs      bool
+	retryProgressInterval = DefaultRetryProgressInterval
+	retryObservers        []RetryObserver
+)
+
+// RetryObserver is notified of the outcome of every wait loop, with the name
+// of the loop, the number of retries and its error, if any
+type RetryObserver func(op string, retries int, err error)
+
+// AddRetryObserver registers an observer of all wait loops, e.g. to export
+// retry metrics
+func AddRetryObserver(o RetryObserver) {
+	retryLogLock.Lock()
+	defer retryLogLock.Unlock()
+	retryObservers = append(retryObservers, o)
+}
+
+func init() {
+	verbose := false
+	if v, err := GetEnvValueStrict(VerboseRetryLogsEnv); err == nil {
+		verbose, _ = strconv.ParseBool(v)
+	}
+	var interval time.Duration
+	if v, err := GetEnvValueStrict(RetryProgressIntervalEnv); err == nil {
+		if interval, err = time.ParseDuration(v); err != nil {
+			logrus.Warnf("invalid %s %q: %v", RetryProgressIntervalEnv, v, err)
+		}
+	}
+	SetRetryLogging(verbose, interval)
+}
+
+// SetRetryLogging configures how wait loops log. By default a wait loop logs
+// when it starts, its progress every progressInterval and its outcome with
+// the total duration and number of retries. With verbose every failed
+// attempt is logged instead. A zero progressInterval keeps the current one.
+func SetRetryLogging(verbose bool, progressInterval time.Duration) {
+	retryLogLock.Lock()
+	defer retryLogLock.Unlock()
+	verboseRetryLogs = verbose
+	if progressInterval > 0 {
+		retryProgressInterval = progressInterval
+	}
+}
+
+// retryLogger logs the attempts of a single wait loop
+type retryLogger struct {
+	op           string
+	verbose      bool
+	interval     time.Duration
+	start        time.Time
+	lastProgress time.Time
+	retries      int
+	observers    []RetryObserver
+}
+
+func newRetryLogger(op string) *retryLogger {
+	retryLogLock.Lock()
+	defer retryLogLock.Unlock()
+	now := time.Now()
+	l := &retryLogger{
+		op:           op,
+		verbose:      verboseRetryLogs,
+		interval:     retryProgressInterval,
+		start:        now,
+		lastProgress: now,
+		observers:    retryObservers,
+	}
+	logrus.Infof("%s: started", op)
+	return l
+}
+
+// retry logs a failed attempt that will be retried after next
+func (l *retryLogger) retry(err error, next time.Duration) {
+	l.retries++
+	if l.verbose {
+		logrus.Infof("%s: %v. Retry count: %v Next retry in: %v", l.op, err, l.retries, next)
+		return
+	}
+	if time.Since(l.lastProgress) >= l.interval {
+		l.lastProgress = time.Now()
+		logrus.Infof("%s: still waiting after %v and %d retries: %v",
+			l.op, time.Since(l.start).Round(time.Millisecond), l.retries, err)
+	}
+}
+
+// done logs the outcome of the wait loop
+func (l *retryLogger) done(err error) {
+	for _, o := range l.observers {
+		o(l.op, l.retries, err)
+	}
+	elapsed := time.Since(l.start).Round(time.Millisecond)
+	if err != nil {
+		logrus.Warnf("%s: failed after %v and %d retries: %v", l.op, elapsed, l.retries, err)
+		return
+	}
+	logrus.Infof("%s: completed after %v and %d retries", l.op, elapsed, l.retries)
+}
+
+// RetryWithTimeout calls f every interval until it succeeds, returns an error
+// that should not be retried or timeout expires, like
+// task.DoRetryWithTimeout, logging a summary of the attempts under the name
+// op as configured by SetRetryLogging. The error of a timeout matches
+// task.ErrTimedOut with errors.Is and wraps the error of the last attempt.
+func RetryWithTimeout(
+	op string,
+	f func() (interface{}, bool, error),
+	timeout, interval time.Duration,
+) (interface{}, error) {
+	return retryWithTimeout(op, f, timeout, func(time.Duration, time.Duration) time.Duration {
+		return interval
+	})
+}
+
+// timeoutError is the error of a wait loop that timed out. It matches
+// task.ErrTimedOut with errors.Is and unwraps to the error of the last
+// attempt, so that its storage error code is kept.
+type timeoutError struct {
+	op  string
+	err error
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("%s: %v: %v", e.op, task.ErrTimedOut, e.err)
+}
+
+func (e *timeoutError) Unwrap() error { return e.err }
+
+func (e *timeoutError) Is(target error) bool { return target == task.ErrTimedOut }
+
+// retryWithTimeout is RetryWithTimeout with the interval before each retry
+// returned by next, given the time elapsed and the previous interval
+func retryWithTimeout(
+	op string,
+	f func() (interface{}, bool, error),
+	timeout time.Duration,
+	next func(elapsed, prev time.Duration) time.Duration,
+) (interface{}, error) {
+	l := newRetryLogger(op)
+	var interval time.Duration
+	for {
+		out, retry, err := f()
+		if err == nil || !retry {
+			l.done(err)
+			return out, err
+		}
+
+		elapsed := time.Since(l.start)
+		remaining := timeout - elapsed
+		if remaining <= 0 {
+			l.done(err)
+			return out, &timeoutError{op: op, err: err}
+		}
+		interval = next(elapsed, interval)
+		if interval > remaining {
+			interval = remaining
+		}
+		l.retry(err, interval)
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,147 @@
+package storageops
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Operations of RetryPolicy.Overrides, i.e. the kinds of wait loops of the
+// providers
+const (
+	// RetryOpCreate is waiting for a new volume to be available
+	RetryOpCreate = "create"
+	// RetryOpAttach is waiting for a volume to be attached
+	RetryOpAttach = "attach"
+	// RetryOpDetach is waiting for a volume to be detached
+	RetryOpDetach = "detach"
+	// RetryOpExpand is waiting for a resize to take effect
+	RetryOpExpand = "expand"
+	// RetryOpModify is waiting for a type, IOPS or throughput change to take
+	// effect
+	RetryOpModify = "modify"
+	// RetryOpSnapshot is waiting for a snapshot or snapshot copy to complete
+	RetryOpSnapshot = "snapshot"
+)
+
+// RetryPolicy configures the wait loops of a provider. A nil policy keeps
+// the defaults of the provider.
+type RetryPolicy struct {
+	// Interval is the wait before the first retry, defaults to
+	// ProviderOpsRetryInterval
+	Interval time.Duration
+	// Multiplier grows the interval after every retry, 1 or less keeps it
+	// fixed
+	Multiplier float64
+	// MaxInterval caps the interval, zero for no cap
+	MaxInterval time.Duration
+	// Jitter is the fraction of every interval that is randomized, between
+	// 0 and 1, so that waiters in lock step spread out
+	Jitter float64
+	// MaxAttempts is the maximum number of attempts, zero for no limit
+	MaxAttempts int
+	// Timeout is how long to retry, defaults to ProviderOpsTimeout
+	Timeout time.Duration
+	// Overrides are the policies of specific RetryOp* operations. Their zero
+	// fields default to the fields of this policy.
+	Overrides map[string]*RetryPolicy
+}
+
+// For returns the policy of the given RetryOp* operation, nil if p is nil
+func (p *RetryPolicy) For(op string) *RetryPolicy {
+	if p == nil {
+		return nil
+	}
+	o, ok := p.Overrides[op]
+	if !ok {
+		return p
+	}
+	merged := *o
+	merged.Overrides = nil
+	if merged.Interval == 0 {
+		merged.Interval = p.Interval
+	}
+	if merged.Multiplier == 0 {
+		merged.Multiplier = p.Multiplier
+	}
+	if merged.MaxInterval == 0 {
+		merged.MaxInterval = p.MaxInterval
+	}
+	if merged.Jitter == 0 {
+		merged.Jitter = p.Jitter
+	}
+	if merged.MaxAttempts == 0 {
+		merged.MaxAttempts = p.MaxAttempts
+	}
+	if merged.Timeout == 0 {
+		merged.Timeout = p.Timeout
+	}
+	return &merged
+}
+
+// Validate returns an error if the policy or one of its overrides is invalid
+func (p *RetryPolicy) Validate() error {
+	if p == nil {
+		return nil
+	}
+	if p.Interval < 0 || p.MaxInterval < 0 || p.Timeout < 0 || p.MaxAttempts < 0 {
+		return fmt.Errorf("retry policy durations and attempts must not be negative")
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return fmt.Errorf("retry policy jitter %v is not between 0 and 1", p.Jitter)
+	}
+	for op, o := range p.Overrides {
+		if err := o.Validate(); err != nil {
+			return fmt.Errorf("%s: %v", op, err)
+		}
+	}
+	return nil
+}
+
+// Backoff returns the interval before the given retry, starting at 1
+func (p *RetryPolicy) Backoff(retry int) time.Duration {
+	interval := float64(p.Interval)
+	if interval == 0 {
+		interval = float64(ProviderOpsRetryInterval)
+	}
+	if p.Multiplier > 1 && retry > 1 {
+		interval *= math.Pow(p.Multiplier, float64(retry-1))
+	}
+	if p.MaxInterval > 0 && interval > float64(p.MaxInterval) {
+		interval = float64(p.MaxInterval)
+	}
+	if p.Jitter > 0 {
+		interval += interval * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(interval)
+}
+
+// Retry is RetryWithTimeout following the policy. A nil policy retries every
+// ProviderOpsRetryInterval for ProviderOpsTimeout.
+func (p *RetryPolicy) Retry(
+	op string,
+	f func() (interface{}, bool, error),
+) (interface{}, error) {
+	if p == nil {
+		return RetryWithTimeout(op, f, ProviderOpsTimeout, ProviderOpsRetryInterval)
+	}
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = ProviderOpsTimeout
+	}
+
+	attempts, retries := 0, 0
+	limited := func() (interface{}, bool, error) {
+		attempts++
+		out, retry, err := f()
+		if retry && err != nil && p.MaxAttempts > 0 && attempts >= p.MaxAttempts {
+			return out, false, fmt.Errorf("%w: giving up after %d attempts", err, attempts)
+		}
+		return out, retry, err
+	}
+	return retryWithTimeout(op, limited, timeout, func(time.Duration, time.Duration) time.Duration {
+		retries++
+		return p.Backoff(retries)
+	})
+}
@@ -0,0 +1,279 @@
+// Package sandbox restores a snapshot to a temporary volume and compares its
+// contents against the live volume, to validate backups and investigate
+// suspected data corruption.
+package sandbox
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/docker/docker/pkg/mount"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// SandboxLabel is the label applied to temporary sandbox volumes so leaked
+	// volumes can be found and garbage collected
+	SandboxLabel = "openstorage-restore-sandbox"
+)
+
+// Spec describes a restore sandbox run
+type Spec struct {
+	// Template is the provider specific template of the volume to restore the
+	// snapshot into, e.g. an *ec2.Volume with SnapshotId set
+	Template interface{}
+	// LiveDevicePath is the device path of the live volume on this instance
+	LiveDevicePath string
+	// FsType is the filesystem type of the volume, e.g. ext4 or xfs
+	FsType string
+	// Labels are extra labels applied to the sandbox volume
+	Labels map[string]string
+}
+
+// PathDiff holds the diff counts under a top-level path
+type PathDiff struct {
+	// Added is the number of files present in the live volume but not in the snapshot
+	Added int
+	// Deleted is the number of files present in the snapshot but not in the live volume
+	Deleted int
+	// Changed is the number of files that differ in size, mode or modification time
+	Changed int
+}
+
+// Report is the file-level diff between the restored snapshot and the live volume
+type Report struct {
+	// SnapshotVolumeID is the ID of the temporary volume the snapshot was restored to
+	SnapshotVolumeID string
+	// Total is the diff across the whole volume
+	Total PathDiff
+	// Paths is the diff per top-level path
+	Paths map[string]*PathDiff
+}
+
+// String renders the report with one line per top-level path
+func (r *Report) String() string {
+	var paths []string
+	for p := range r.Paths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "total: added %d deleted %d changed %d\n",
+		r.Total.Added, r.Total.Deleted, r.Total.Changed)
+	for _, p := range paths {
+		d := r.Paths[p]
+		fmt.Fprintf(&b, "%s: added %d deleted %d changed %d\n",
+			p, d.Added, d.Deleted, d.Changed)
+	}
+	return b.String()
+}
+
+// getMounts returns the mount table, replaced in tests
+var getMounts = mount.GetMounts
+
+// Run restores the snapshot described by spec into a temporary volume,
+// mounts it and the live volume read-only and returns the diff between them.
+// A live volume that is already mounted is bind mounted read-only from its
+// mount point instead, as it cannot be mounted a second time. The temporary
+// volume is always torn down before returning.
+func Run(ops storageops.Ops, spec *Spec) (*Report, error) {
+	labels := map[string]string{SandboxLabel: "true"}
+	for k, v := range spec.Labels {
+		labels[k] = v
+	}
+
+	vol, err := ops.Create(spec.Template, labels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore snapshot: %v", err)
+	}
+	volumeID, err := ops.GetDeviceID(vol)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := ops.Delete(volumeID); err != nil {
+			logrus.Warnf("failed to delete sandbox volume %v: %v", volumeID, err)
+		}
+	}()
+
+	devicePath, err := ops.Attach(volumeID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach sandbox volume %v: %v", volumeID, err)
+	}
+	defer func() {
+		if err := ops.Detach(volumeID); err != nil {
+			logrus.Warnf("failed to detach sandbox volume %v: %v", volumeID, err)
+		}
+	}()
+
+	snapDir, err := mountReadOnly(devicePath, spec.FsType)
+	if err != nil {
+		return nil, err
+	}
+	defer unmount(snapDir)
+
+	liveDir, err := mountLive(spec.LiveDevicePath, spec.FsType)
+	if err != nil {
+		return nil, err
+	}
+	defer unmount(liveDir)
+
+	report, err := Diff(snapDir, liveDir)
+	if err != nil {
+		return nil, err
+	}
+	report.SnapshotVolumeID = volumeID
+	return report, nil
+}
+
+// Diff compares the trees rooted at snapDir and liveDir. Files are considered
+// changed if their size, mode or modification time differ.
+func Diff(snapDir, liveDir string) (*Report, error) {
+	snapFiles, err := walk(snapDir)
+	if err != nil {
+		return nil, err
+	}
+	liveFiles, err := walk(liveDir)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Paths: make(map[string]*PathDiff)}
+	diff := func(path string) *PathDiff {
+		top := strings.SplitN(path, string(filepath.Separator), 2)[0]
+		d, ok := report.Paths[top]
+		if !ok {
+			d = &PathDiff{}
+			report.Paths[top] = d
+		}
+		return d
+	}
+
+	for path, snap := range snapFiles {
+		live, ok := liveFiles[path]
+		switch {
+		case !ok:
+			diff(path).Deleted++
+			report.Total.Deleted++
+		case snap.Size() != live.Size() ||
+			snap.Mode() != live.Mode() ||
+			(snap.Mode().IsRegular() && !snap.ModTime().Equal(live.ModTime())):
+			diff(path).Changed++
+			report.Total.Changed++
+		}
+	}
+	for path := range liveFiles {
+		if _, ok := snapFiles[path]; !ok {
+			diff(path).Added++
+			report.Total.Added++
+		}
+	}
+	return report, nil
+}
+
+func walk(root string) (map[string]os.FileInfo, error) {
+	files := make(map[string]os.FileInfo)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." || rel == "lost+found" {
+			return err
+		}
+		files[rel] = info
+		return nil
+	})
+	return files, err
+}
+
+// mountReadOnly mounts devicePath read-only on a new temporary directory.
+// Journal replay is disabled as the restored snapshot usually has a dirty
+// journal and shares its filesystem UUID with the live volume.
+func mountReadOnly(devicePath, fsType string) (string, error) {
+	dir, err := ioutil.TempDir("", "storageops-sandbox")
+	if err != nil {
+		return "", err
+	}
+
+	var data string
+	switch fsType {
+	case "ext4", "ext3":
+		data = "noload"
+	case "xfs":
+		data = "norecovery,nouuid"
+	}
+
+	if err := syscall.Mount(devicePath, dir, fsType, syscall.MS_RDONLY, data); err != nil {
+		os.Remove(dir)
+		return "", fmt.Errorf("failed to mount %v read-only on %v: %v", devicePath, dir, err)
+	}
+	return dir, nil
+}
+
+// mountPoint returns the mount point of the root of the filesystem on
+// devicePath, or an empty string if it is not mounted. Device symlinks, e.g.
+// under /dev/disk/by-id, are resolved before comparing.
+func mountPoint(devicePath string) (string, error) {
+	device, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return "", err
+	}
+	mounts, err := getMounts()
+	if err != nil {
+		return "", err
+	}
+	for _, m := range mounts {
+		if m.Root != "/" || !strings.HasPrefix(m.Source, "/") {
+			continue
+		}
+		source, err := filepath.EvalSymlinks(m.Source)
+		if err == nil && source == device {
+			return m.Mountpoint, nil
+		}
+	}
+	return "", nil
+}
+
+// mountLive mounts the live volume on devicePath read-only on a new
+// temporary directory. If the volume is already mounted, its mount point is
+// bind mounted read-only.
+func mountLive(devicePath, fsType string) (string, error) {
+	mp, err := mountPoint(devicePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to find mount point of %v: %v", devicePath, err)
+	}
+	if len(mp) == 0 {
+		return mountReadOnly(devicePath, fsType)
+	}
+
+	dir, err := ioutil.TempDir("", "storageops-sandbox")
+	if err != nil {
+		return "", err
+	}
+	if err := syscall.Mount(mp, dir, "", syscall.MS_BIND, ""); err != nil {
+		os.Remove(dir)
+		return "", fmt.Errorf("failed to bind mount %v on %v: %v", mp, dir, err)
+	}
+	// The read-only flag of a bind mount is only applied by a remount
+	if err := syscall.Mount("", dir, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, ""); err != nil {
+		unmount(dir)
+		return "", fmt.Errorf("failed to remount %v read-only: %v", dir, err)
+	}
+	return dir, nil
+}
+
+func unmount(dir string) {
+	if err := syscall.Unmount(dir, 0); err != nil {
+		logrus.Warnf("failed to unmount %v: %v", dir, err)
+		return
+	}
+	os.Remove(dir)
+}
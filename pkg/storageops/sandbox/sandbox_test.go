@@ -0,0 +1,77 @@
+package sandbox
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/pkg/mount"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, root, path, content string) {
+	p := filepath.Join(root, path)
+	require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+	require.NoError(t, ioutil.WriteFile(p, []byte(content), 0644))
+}
+
+func TestDiff(t *testing.T) {
+	snap, err := ioutil.TempDir("", "sandbox-snap")
+	require.NoError(t, err)
+	defer os.RemoveAll(snap)
+	live, err := ioutil.TempDir("", "sandbox-live")
+	require.NoError(t, err)
+	defer os.RemoveAll(live)
+
+	writeFile(t, snap, "data/same", "a")
+	writeFile(t, snap, "data/changed", "a")
+	writeFile(t, snap, "data/deleted", "a")
+	writeFile(t, snap, "logs/old", "a")
+
+	writeFile(t, live, "data/same", "a")
+	writeFile(t, live, "data/changed", "abc")
+	writeFile(t, live, "data/added", "a")
+	writeFile(t, live, "logs/old", "a")
+	writeFile(t, live, "logs/new", "a")
+
+	// Align modification times of files whose content did not change
+	for _, p := range []string{"data/same", "logs/old"} {
+		info, err := os.Stat(filepath.Join(snap, p))
+		require.NoError(t, err)
+		require.NoError(t, os.Chtimes(filepath.Join(live, p), info.ModTime(), info.ModTime()))
+	}
+
+	report, err := Diff(snap, live)
+	require.NoError(t, err)
+	require.Equal(t, PathDiff{Added: 2, Deleted: 1, Changed: 1}, report.Total)
+	require.Equal(t, &PathDiff{Added: 1, Deleted: 1, Changed: 1}, report.Paths["data"])
+	require.Equal(t, &PathDiff{Added: 1}, report.Paths["logs"])
+}
+
+func TestMountPoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sandbox-dev")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeFile(t, dir, "xvdf", "")
+	writeFile(t, dir, "xvdg", "")
+	require.NoError(t, os.Symlink(filepath.Join(dir, "xvdf"), filepath.Join(dir, "by-id")))
+
+	defer func(f func() ([]*mount.Info, error)) { getMounts = f }(getMounts)
+	getMounts = func() ([]*mount.Info, error) {
+		return []*mount.Info{
+			{Source: "tmpfs", Root: "/", Mountpoint: "/tmp"},
+			{Source: filepath.Join(dir, "xvdf"), Root: "/data", Mountpoint: "/var/lib/bind"},
+			{Source: filepath.Join(dir, "xvdf"), Root: "/", Mountpoint: "/var/lib/live"},
+		}, nil
+	}
+
+	mp, err := mountPoint(filepath.Join(dir, "by-id"))
+	require.NoError(t, err)
+	require.Equal(t, "/var/lib/live", mp, "device symlinks must be resolved")
+	mp, err = mountPoint(filepath.Join(dir, "xvdg"))
+	require.NoError(t, err)
+	require.Empty(t, mp, "unmounted devices have no mount point")
+	_, err = mountPoint(filepath.Join(dir, "missing"))
+	require.Error(t, err)
+}
@@ -0,0 +1,237 @@
+package storageops
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultShadowConcurrency is the number of shadow comparisons that run at
+// the same time if NewShadowOps is given none
+const DefaultShadowConcurrency = 4
+
+type shadowOps struct {
+	Ops
+	shadow Ops
+	// running has a slot for every running comparison, comparisons are
+	// dropped when it is full
+	running chan struct{}
+	dropped uint64
+	// report logs the differences of a comparison
+	report func(op string, arg interface{}, diffs []string)
+}
+
+// NewShadowOps returns Ops that route every call to primary and additionally
+// send read-only calls to shadow, logging the fields in which the two
+// results differ. Mutating calls are never sent to shadow, nor is Describe
+// whose provider object differs between implementations. Shadow calls run
+// in the background, at most concurrency at a time, and never affect the
+// result or latency of the primary call. Comparisons are dropped while
+// concurrency of them are running, so a slow shadow cannot pile up
+// goroutines. This is used to de-risk migrating between two implementations
+// of a provider.
+func NewShadowOps(primary, shadow Ops, concurrency int) Ops {
+	if concurrency <= 0 {
+		concurrency = DefaultShadowConcurrency
+	}
+	return &shadowOps{
+		Ops:     primary,
+		shadow:  shadow,
+		running: make(chan struct{}, concurrency),
+		report: func(op string, arg interface{}, diffs []string) {
+			logrus.Warnf("shadow: %s(%v) result mismatch: %s", op, arg, strings.Join(diffs, ", "))
+		},
+	}
+}
+
+// compare runs the shadow call in the background if a slot is free and
+// reports the fields in which its result differs from the primary result
+func (s *shadowOps) compare(
+	op string,
+	arg interface{},
+	primary interface{},
+	primaryErr error,
+	shadowFn func() (interface{}, error),
+) {
+	select {
+	case s.running <- struct{}{}:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+		logrus.Debugf("shadow: dropped %s(%v) comparison, %d are running", op, arg, cap(s.running))
+		return
+	}
+	go func() {
+		defer func() { <-s.running }()
+		shadow, shadowErr := shadowFn()
+		if (primaryErr == nil) != (shadowErr == nil) {
+			s.report(op, arg, []string{fmt.Sprintf("error: primary=%v shadow=%v", primaryErr, shadowErr)})
+			return
+		}
+		if primaryErr != nil {
+			return
+		}
+		if diffs := shadowDiff(primary, shadow); len(diffs) > 0 {
+			s.report(op, arg, diffs)
+		}
+	}()
+}
+
+// shadowDiff returns the differences between the normalized fields of the
+// given results, e.g. "vol-1.SizeGiB: primary=10 shadow=20"
+func shadowDiff(primary, shadow interface{}) []string {
+	p, s := shadowFields(primary), shadowFields(shadow)
+	var diffs []string
+	for k, pv := range p {
+		sv, ok := s[k]
+		if !ok {
+			sv = "<none>"
+		}
+		if pv != sv {
+			diffs = append(diffs, fmt.Sprintf("%s: primary=%s shadow=%s", k, pv, sv))
+		}
+	}
+	for k, sv := range s {
+		if _, ok := p[k]; !ok {
+			diffs = append(diffs, fmt.Sprintf("%s: primary=<none> shadow=%s", k, sv))
+		}
+	}
+	sort.Strings(diffs)
+	return diffs
+}
+
+// shadowFields flattens the given result into its fields that are expected
+// to match between two implementations of a provider. Volumes and
+// snapshots are keyed by their ID, so that the order of lists and the
+// provider objects in Raw do not matter.
+func shadowFields(result interface{}) map[string]string {
+	fields := make(map[string]string)
+	switch r := result.(type) {
+	case []*Volume:
+		for _, v := range r {
+			volumeFields(fields, v.ID, v)
+		}
+	case map[string][]*Volume:
+		for set, vols := range r {
+			for _, v := range vols {
+				volumeFields(fields, set+"/"+v.ID, v)
+			}
+		}
+	case []*Snapshot:
+		for _, snap := range r {
+			snapshotFields(fields, snap)
+		}
+	case *SnapshotStatus:
+		if r != nil {
+			fields["ID"] = r.ID
+			fields["State"] = r.State
+			fields["Completed"] = strconv.FormatBool(r.Completed)
+			fields["Failed"] = strconv.FormatBool(r.Failed)
+		}
+	case map[string]string:
+		for k, v := range r {
+			fields[k] = v
+		}
+	case []string:
+		for _, v := range r {
+			fields[v] = "present"
+		}
+	default:
+		fields["result"] = fmt.Sprintf("%v", r)
+	}
+	return fields
+}
+
+func volumeFields(fields map[string]string, key string, v *Volume) {
+	attachedTo := append([]string(nil), v.AttachedTo...)
+	sort.Strings(attachedTo)
+	fields[key+".SizeGiB"] = strconv.FormatUint(v.SizeGiB, 10)
+	fields[key+".Zone"] = v.Zone
+	fields[key+".Encrypted"] = strconv.FormatBool(v.Encrypted)
+	fields[key+".AttachedTo"] = strings.Join(attachedTo, ",")
+	for k, value := range v.Labels {
+		fields[key+".Labels."+k] = value
+	}
+}
+
+func snapshotFields(fields map[string]string, snap *Snapshot) {
+	fields[snap.ID+".VolumeID"] = snap.VolumeID
+	fields[snap.ID+".SizeGiB"] = strconv.FormatUint(snap.SizeGiB, 10)
+	for k, value := range snap.Labels {
+		fields[snap.ID+".Labels."+k] = value
+	}
+}
+
+func (s *shadowOps) FreeDevices(
+	blockDeviceMappings []interface{},
+	rootDeviceName string,
+) ([]string, error) {
+	out, err := s.Ops.FreeDevices(blockDeviceMappings, rootDeviceName)
+	s.compare("FreeDevices", rootDeviceName, out, err, func() (interface{}, error) {
+		return s.shadow.FreeDevices(blockDeviceMappings, rootDeviceName)
+	})
+	return out, err
+}
+
+func (s *shadowOps) Inspect(volumeIds []*string) ([]*Volume, error) {
+	out, err := s.Ops.Inspect(volumeIds)
+	s.compare("Inspect", len(volumeIds), out, err, func() (interface{}, error) {
+		return s.shadow.Inspect(volumeIds)
+	})
+	return out, err
+}
+
+func (s *shadowOps) DeviceMappings() (map[string]string, error) {
+	out, err := s.Ops.DeviceMappings()
+	s.compare("DeviceMappings", "", out, err, func() (interface{}, error) {
+		return s.shadow.DeviceMappings()
+	})
+	return out, err
+}
+
+func (s *shadowOps) Enumerate(
+	volumeIds []*string,
+	labels map[string]string,
+	setIdentifier string,
+) (map[string][]*Volume, error) {
+	out, err := s.Ops.Enumerate(volumeIds, labels, setIdentifier)
+	s.compare("Enumerate", labels, out, err, func() (interface{}, error) {
+		return s.shadow.Enumerate(volumeIds, labels, setIdentifier)
+	})
+	return out, err
+}
+
+func (s *shadowOps) DevicePath(volumeID string) (string, error) {
+	out, err := s.Ops.DevicePath(volumeID)
+	s.compare("DevicePath", volumeID, out, err, func() (interface{}, error) {
+		return s.shadow.DevicePath(volumeID)
+	})
+	return out, err
+}
+
+func (s *shadowOps) Tags(volumeID string) (map[string]string, error) {
+	out, err := s.Ops.Tags(volumeID)
+	s.compare("Tags", volumeID, out, err, func() (interface{}, error) {
+		return s.shadow.Tags(volumeID)
+	})
+	return out, err
+}
+
+func (s *shadowOps) SnapshotEnumerate(filter *SnapshotFilter) ([]*Snapshot, error) {
+	out, err := s.Ops.SnapshotEnumerate(filter)
+	s.compare("SnapshotEnumerate", fmt.Sprintf("%+v", filter), out, err, func() (interface{}, error) {
+		return s.shadow.SnapshotEnumerate(filter)
+	})
+	return out, err
+}
+
+func (s *shadowOps) SnapshotStatus(snapID string) (*SnapshotStatus, error) {
+	out, err := s.Ops.SnapshotStatus(snapID)
+	s.compare("SnapshotStatus", snapID, out, err, func() (interface{}, error) {
+		return s.shadow.SnapshotStatus(snapID)
+	})
+	return out, err
+}
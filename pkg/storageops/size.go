@@ -0,0 +1,239 @@
+package storageops
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Size is a volume size in bytes
+type Size uint64
+
+const (
+	// KiB is 1024 bytes
+	KiB Size = 1 << (10 * (iota + 1))
+	// MiB is 1024 KiB
+	MiB
+	// GiB is 1024 MiB, the granularity of most providers
+	GiB
+	// TiB is 1024 GiB
+	TiB
+	// PiB is 1024 TiB
+	PiB
+)
+
+const (
+	// KB is 1000 bytes
+	KB Size = 1000
+	// MB is 1000 KB
+	MB = 1000 * KB
+	// GB is 1000 MB
+	GB = 1000 * MB
+	// TB is 1000 GB
+	TB = 1000 * GB
+	// PB is 1000 TB
+	PB = 1000 * TB
+)
+
+// sizeUnits are the units of ParseSize, lower case. Units without the i are
+// decimal as in Kubernetes quantities, so 500GB is 500 * 10^9 bytes and not
+// 500 GiB.
+var sizeUnits = map[string]Size{
+	"b":   1,
+	"k":   KB,
+	"kb":  KB,
+	"m":   MB,
+	"mb":  MB,
+	"g":   GB,
+	"gb":  GB,
+	"t":   TB,
+	"tb":  TB,
+	"p":   PB,
+	"pb":  PB,
+	"ki":  KiB,
+	"kib": KiB,
+	"mi":  MiB,
+	"mib": MiB,
+	"gi":  GiB,
+	"gib": GiB,
+	"ti":  TiB,
+	"tib": TiB,
+	"pi":  PiB,
+	"pib": PiB,
+}
+
+var sizePattern = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)\s*([a-zA-Z]*)$`)
+
+// ParseSize parses a size such as 500GiB, 1.5Ti or 500GB. Units are case
+// insensitive, KB to PB are powers of 1000 and KiB to PiB powers of 1024. A
+// number without unit is in GiB, the unit of Expand. Fractional sizes are
+// rounded up to the next byte.
+//
+// Unlike units.Parse of the volume spec, which reads GB as GiB, 500GB is
+// about 465.7 GiB and a provider rounds it up to 466 GiB, see RoundSize.
+func ParseSize(s string) (Size, error) {
+	m := sizePattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, fmt.Errorf("invalid size %q, expected a number and a unit such as GiB or GB", s)
+	}
+	unit := GiB
+	if len(m[2]) > 0 {
+		var ok bool
+		if unit, ok = sizeUnits[strings.ToLower(m[2])]; !ok {
+			return 0, fmt.Errorf("invalid size %q, unknown unit %s", s, m[2])
+		}
+	}
+	value, ok := new(big.Rat).SetString(m[1])
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	value.Mul(value, new(big.Rat).SetInt(new(big.Int).SetUint64(uint64(unit))))
+	// Round up to the next byte
+	bytes := new(big.Int).Quo(value.Num(), value.Denom())
+	if !value.IsInt() {
+		bytes.Add(bytes, big.NewInt(1))
+	}
+	if !bytes.IsUint64() {
+		return 0, fmt.Errorf("invalid size %q, exceeds %d bytes", s, uint64(math.MaxUint64))
+	}
+	return Size(bytes.Uint64()), nil
+}
+
+// SizeFromGiB returns the size of the given number of GiB
+func SizeFromGiB(gib uint64) Size {
+	return Size(gib) * GiB
+}
+
+// RoundUp returns the size rounded up to a multiple of the given granularity
+func (s Size) RoundUp(granularity Size) Size {
+	if granularity <= 1 {
+		return s
+	}
+	return (s + granularity - 1) / granularity * granularity
+}
+
+// GiB returns the size in GiB, rounded up so a volume of that many GiB is
+// never smaller than the size
+func (s Size) GiB() uint64 {
+	return uint64(s.RoundUp(GiB) / GiB)
+}
+
+// String returns the size in the largest binary unit it is a multiple of,
+// e.g. 466GiB, which ParseSize parses back to the same size
+func (s Size) String() string {
+	for _, u := range []struct {
+		size Size
+		name string
+	}{{PiB, "PiB"}, {TiB, "TiB"}, {GiB, "GiB"}, {MiB, "MiB"}, {KiB, "KiB"}} {
+		if s >= u.size && s%u.size == 0 {
+			return fmt.Sprintf("%d%s", s/u.size, u.name)
+		}
+	}
+	return fmt.Sprintf("%dB", uint64(s))
+}
+
+// Set parses the given size, so a *Size can be used as a flag.Value
+func (s *Size) Set(value string) error {
+	size, err := ParseSize(value)
+	if err != nil {
+		return err
+	}
+	*s = size
+	return nil
+}
+
+// MarshalJSON encodes the size as a string, see String
+func (s Size) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// UnmarshalJSON decodes a size string, see ParseSize, or a number of bytes
+func (s *Size) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		return s.Set(str)
+	}
+	var bytes uint64
+	if err := json.Unmarshal(data, &bytes); err != nil {
+		return fmt.Errorf("invalid size %s, expected a string such as 500GiB or a number of bytes", data)
+	}
+	*s = Size(bytes)
+	return nil
+}
+
+// SizeRule is how a provider sizes volumes
+type SizeRule struct {
+	// Granularity is the unit sizes are rounded up to, GiB if zero
+	Granularity Size
+	// Min is the smallest volume size, if any
+	Min Size
+	// Max is the largest volume size, if any
+	Max Size
+}
+
+var (
+	sizeRulesLock sync.Mutex
+	sizeRules     = make(map[string]*SizeRule)
+)
+
+// RegisterSizeRule registers the size rule of the driver with the given name
+func RegisterSizeRule(name string, rule *SizeRule) {
+	sizeRulesLock.Lock()
+	defer sizeRulesLock.Unlock()
+	sizeRules[name] = rule
+}
+
+// GetSizeRule returns the size rule of the driver with the given name, GiB
+// granularity without limits if it registered none
+func GetSizeRule(name string) *SizeRule {
+	sizeRulesLock.Lock()
+	defer sizeRulesLock.Unlock()
+	if rule, ok := sizeRules[name]; ok {
+		return rule
+	}
+	return &SizeRule{Granularity: GiB}
+}
+
+// Round rounds the given size up to the granularity of the rule. It returns
+// an ErrVolInval error if the rounded size is outside the limits of the
+// rule, rather than silently creating a larger or smaller volume.
+func (r *SizeRule) Round(size Size) (Size, error) {
+	granularity := r.Granularity
+	if granularity == 0 {
+		granularity = GiB
+	}
+	rounded := size.RoundUp(granularity)
+	if r.Min > 0 && rounded < r.Min {
+		return 0, NewStorageError(ErrVolInval,
+			fmt.Sprintf("size %s is below the minimum volume size %s", size, r.Min), "")
+	}
+	if r.Max > 0 && rounded > r.Max {
+		return 0, NewStorageError(ErrVolInval,
+			fmt.Sprintf("size %s exceeds the maximum volume size %s", size, r.Max), "")
+	}
+	return rounded, nil
+}
+
+// RoundSize rounds the given size by the size rule of the driver with the
+// given name, see SizeRule.Round
+func RoundSize(provider string, size Size) (Size, error) {
+	return GetSizeRule(provider).Round(size)
+}
+
+// ExpandTo expands the given volume to the given size rounded by the size
+// rule of the driver, and returns its new size
+func ExpandTo(ops Ops, volumeID string, size Size) (Size, error) {
+	rounded, err := RoundSize(ops.Name(), size)
+	if err != nil {
+		return 0, err
+	}
+	newSizeGiB, err := ops.Expand(volumeID, rounded.GiB())
+	if err != nil {
+		return 0, err
+	}
+	return SizeFromGiB(newSizeGiB), nil
+}
@@ -0,0 +1,81 @@
+package storageops
+
+import (
+	"fmt"
+	"time"
+)
+
+// SmokeTestMode is how a device is exercised after it is attached
+type SmokeTestMode string
+
+const (
+	// SmokeTestSkip does not test the device
+	SmokeTestSkip SmokeTestMode = ""
+	// SmokeTestRead reads the first and the last block of the device
+	SmokeTestRead SmokeTestMode = "read"
+	// SmokeTestWrite reads the last block of the device, writes it back
+	// unchanged and reads it again. The device must not be in use.
+	SmokeTestWrite SmokeTestMode = "write"
+)
+
+// smokeTestBlockSize is the size of the I/Os of the smoke test, a multiple
+// of the logical block size of any device as required by O_DIRECT
+const smokeTestBlockSize = 4096
+
+// SmokeTestDevice performs a small direct I/O to the given device, bypassing
+// the page cache, and returns an ErrDeviceIOFailed error if it fails or does
+// not complete within timeout. A device whose I/O hangs leaves the I/O
+// and its goroutine blocked until the device recovers. Devices can only be
+// smoke tested on linux, elsewhere ErrNotSupported is returned.
+func SmokeTestDevice(devicePath string, mode SmokeTestMode, timeout time.Duration) error {
+	if mode == SmokeTestSkip {
+		return nil
+	}
+	done := make(chan error, 1)
+	go func() { done <- smokeTestDevice(devicePath, mode) }()
+
+	select {
+	case err := <-done:
+		if err == ErrNotSupported {
+			return err
+		}
+		if err != nil {
+			return NewStorageError(ErrDeviceIOFailed,
+				fmt.Sprintf("%s I/O to %s failed: %v", mode, devicePath, err), "")
+		}
+		return nil
+	case <-time.After(timeout):
+		return NewStorageError(ErrDeviceIOFailed,
+			fmt.Sprintf("%s I/O to %s did not complete within %v", mode, devicePath, timeout), "")
+	}
+}
+
+type smokeTestOps struct {
+	Ops
+	mode    SmokeTestMode
+	timeout time.Duration
+}
+
+// NewSmokeTestOps returns Ops that smoke test the device of a volume after
+// attaching it, so a volume that is attached but can not perform I/O fails
+// the attach. The volume is left attached for inspection and Attach returns
+// the device path along with the error.
+func NewSmokeTestOps(ops Ops, mode SmokeTestMode, timeout time.Duration) Ops {
+	return &smokeTestOps{
+		Ops:     ops,
+		mode:    mode,
+		timeout: timeout,
+	}
+}
+
+func (o *smokeTestOps) Attach(volumeID string, options map[string]string) (string, error) {
+	devicePath, err := o.Ops.Attach(volumeID, options)
+	if err != nil {
+		return devicePath, err
+	}
+	if err := SmokeTestDevice(devicePath, o.mode, o.timeout); err != nil {
+		return devicePath, fmt.Errorf("volume %s is attached but failed the smoke test: %w",
+			volumeID, err)
+	}
+	return devicePath, nil
+}
@@ -0,0 +1,64 @@
+//go:build linux
+// +build linux
+
+package storageops
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+func smokeTestDevice(devicePath string, mode SmokeTestMode) error {
+	flags := os.O_RDONLY
+	if mode == SmokeTestWrite {
+		flags = os.O_RDWR | syscall.O_SYNC
+	}
+	f, err := os.OpenFile(devicePath, flags|syscall.O_DIRECT, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if size < smokeTestBlockSize {
+		return fmt.Errorf("device of %d bytes is too small", size)
+	}
+	last := (size/smokeTestBlockSize - 1) * smokeTestBlockSize
+
+	// O_DIRECT needs an aligned buffer, anonymous mappings are page aligned
+	buf, err := syscall.Mmap(-1, 0, 2*smokeTestBlockSize,
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		return err
+	}
+	defer syscall.Munmap(buf)
+	block, verify := buf[:smokeTestBlockSize], buf[smokeTestBlockSize:]
+
+	if mode == SmokeTestRead {
+		if _, err := f.ReadAt(block, 0); err != nil {
+			return err
+		}
+		_, err := f.ReadAt(block, last)
+		return err
+	}
+
+	if _, err := f.ReadAt(block, last); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(block, last); err != nil {
+		return err
+	}
+	if _, err := f.ReadAt(verify, last); err != nil {
+		return err
+	}
+	if !bytes.Equal(block, verify) {
+		return fmt.Errorf("block at %d read back differently", last)
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package storageops
+
+// smokeTestDevice is not supported without O_DIRECT
+func smokeTestDevice(devicePath string, mode SmokeTestMode) error {
+	return ErrNotSupported
+}
@@ -0,0 +1,193 @@
+package storageops
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SnapshotStatus is the provider independent status of a snapshot
+type SnapshotStatus struct {
+	// ID of the snapshot
+	ID string
+	// State is the provider specific state, e.g. pending or READY
+	State string
+	// Progress is the completion percentage, 100 when completed
+	Progress int
+	// Completed is true once the snapshot is usable
+	Completed bool
+	// Failed is true if the snapshot will never complete
+	Failed bool
+	// Message is the provider's reason for a failure, if any
+	Message string
+}
+
+func (s *SnapshotStatus) String() string {
+	return fmt.Sprintf("snapshot %s %s (%d%%)", s.ID, s.State, s.Progress)
+}
+
+// OperationStatus returns the status of the operation of the snapshot
+func (s *SnapshotStatus) OperationStatus() *OperationStatus {
+	op := &OperationStatus{
+		State:    OperationRunning,
+		Progress: s.Progress,
+		Message:  s.Message,
+	}
+	switch {
+	case s.Failed:
+		op.State = OperationFailed
+	case s.Completed:
+		op.State = OperationSucceeded
+		op.Progress = 100
+	}
+	if len(op.Message) == 0 {
+		op.Message = s.State
+	}
+	return op
+}
+
+// WaitForSnapshot polls the status of the given snapshot until it completed,
+// failed or timeout expired
+func WaitForSnapshot(ops Ops, snapID string, timeout time.Duration) (*SnapshotStatus, error) {
+	out, err := RetryWithTimeout(
+		fmt.Sprintf("wait for snapshot %s to complete", snapID),
+		func() (interface{}, bool, error) {
+			status, err := ops.SnapshotStatus(snapID)
+			if err != nil {
+				return nil, true, err
+			}
+			if status.Failed {
+				return status, false, fmt.Errorf("%v failed: %s", status, status.Message)
+			}
+			if !status.Completed {
+				return status, true, fmt.Errorf("%v is not completed", status)
+			}
+			return status, false, nil
+		},
+		timeout,
+		ProviderOpsRetryInterval)
+	status, _ := out.(*SnapshotStatus)
+	return status, err
+}
+
+// SnapshotAndWait takes a snapshot of the given volume and, if
+// waitForCompletion is true, waits up to timeout for it to be usable
+func SnapshotAndWait(
+	ops Ops,
+	volumeID string,
+	readonly bool,
+	waitForCompletion bool,
+	timeout time.Duration,
+) (*Snapshot, error) {
+	snap, err := ops.Snapshot(volumeID, readonly)
+	if err != nil || !waitForCompletion {
+		return snap, err
+	}
+	_, err = WaitForSnapshot(ops, snap.ID, timeout)
+	return snap, err
+}
+
+// LabeledSnapshotter is implemented by drivers that label snapshots when
+// they are created
+type LabeledSnapshotter interface {
+	// SnapshotWithLabels takes a snapshot of the given volume labeled with
+	// the given labels
+	SnapshotWithLabels(volumeID string, readonly bool, labels map[string]string) (*Snapshot, error)
+}
+
+// SnapshotWithLabels takes a snapshot of the given volume labeled with the
+// given labels. Drivers that are a LabeledSnapshotter label the snapshot when
+// it is created, others label it after it is created and delete it again if
+// labeling fails. Wrappers like middlewares hide the LabeledSnapshotter of
+// the driver they wrap, see AttachBatch.
+func SnapshotWithLabels(
+	ops Ops,
+	volumeID string,
+	readonly bool,
+	labels map[string]string,
+) (*Snapshot, error) {
+	if l, ok := ops.(LabeledSnapshotter); ok {
+		return l.SnapshotWithLabels(volumeID, readonly, labels)
+	}
+	snap, err := ops.Snapshot(volumeID, readonly)
+	if err != nil || len(labels) == 0 {
+		return snap, err
+	}
+	if err := ops.ApplyTags(snap.ID, labels); err != nil {
+		if delErr := ops.SnapshotDelete(snap.ID); delErr != nil {
+			logrus.Warnf("Failed to delete snapshot %s of volume %s that could not be labeled: %v",
+				snap.ID, volumeID, delErr)
+		}
+		return nil, err
+	}
+	return snap, nil
+}
+
+// SnapshotEnumerateSets returns the snapshots matching the given labels
+// organized into sets identified by their value of the setIdentifier label,
+// like Enumerate does for volumes. Snapshots without the label are in
+// SetIdentifierNone. labels can be nil, setIdentifier can be empty string.
+func SnapshotEnumerateSets(
+	ops Ops,
+	labels map[string]string,
+	setIdentifier string,
+) (map[string][]*Snapshot, error) {
+	snaps, err := ops.SnapshotEnumerate(&SnapshotFilter{Labels: labels})
+	if err != nil {
+		return nil, err
+	}
+	sets := make(map[string][]*Snapshot)
+	for _, snap := range snaps {
+		set, ok := snap.Labels[setIdentifier]
+		if len(setIdentifier) == 0 || !ok {
+			set = SetIdentifierNone
+		}
+		sets[set] = append(sets[set], snap)
+	}
+	return sets, nil
+}
+
+// SnapshotDeleteResult is the result of deleting a single snapshot of
+// SnapshotDeleteMatching
+type SnapshotDeleteResult struct {
+	// SnapshotID of the deleted snapshot
+	SnapshotID string
+	// Err is the error deleting the snapshot, nil if it was deleted
+	Err error
+}
+
+// SnapshotDeleteMatching deletes every snapshot carrying all the given labels,
+// e.g. the snapshots of a deleted cluster, running up to parallelism deletes
+// at once. The labels must not be empty so a missing label never deletes all
+// snapshots, and enumerated snapshots without all the labels are never
+// deleted, whatever the filtering of the driver. It returns the result of
+// each delete ordered by snapshot ID.
+func SnapshotDeleteMatching(
+	ops Ops,
+	labels map[string]string,
+	parallelism int,
+) ([]*SnapshotDeleteResult, error) {
+	if len(labels) == 0 {
+		return nil, NewStorageError(ErrVolInval,
+			"refusing to delete snapshots without labels to match", "")
+	}
+	filter := &SnapshotFilter{Labels: labels}
+	snaps, err := ops.SnapshotEnumerate(filter)
+	if err != nil {
+		return nil, err
+	}
+	var results []*SnapshotDeleteResult
+	for _, snap := range snaps {
+		if filter.Match(snap.VolumeID, snap.Created, snap.State, snap.Labels) {
+			results = append(results, &SnapshotDeleteResult{SnapshotID: snap.ID})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].SnapshotID < results[j].SnapshotID })
+
+	ForEachParallel(len(results), parallelism, func(i int) {
+		results[i].Err = ops.SnapshotDelete(results[i].SnapshotID)
+	})
+	return results, nil
+}
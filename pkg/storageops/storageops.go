@@ -1,6 +1,10 @@
 package storageops
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
 
 const (
 	// SetIdentifierNone is a default identifier to group all disks from a
@@ -22,8 +26,71 @@ const (
 	ErrVolNotFound
 	// ErrInvalidDevicePath is code when a volume/disk has invalid device path
 	ErrInvalidDevicePath
+	// ErrDeviceBusy is code when a device is still in use on the instance
+	ErrDeviceBusy
+	// ErrBudgetExhausted is code when the operation budget of a cycle is used up
+	ErrBudgetExhausted
+	// ErrFsSignatureMismatch is code when the filesystem on a device is not
+	// the one recorded for the volume
+	ErrFsSignatureMismatch
+	// ErrFsckFailed is code when the filesystem check of a device found
+	// errors that were not repaired
+	ErrFsckFailed
+	// ErrPermissionDenied is code when the role of the caller does not allow
+	// the operation
+	ErrPermissionDenied
+	// ErrThrottled is code when the provider rejected a call because of its
+	// rate limits
+	ErrThrottled
+	// ErrQuotaExceeded is code when the call would exceed a provider quota,
+	// e.g. the number of volumes or their total size
+	ErrQuotaExceeded
+	// ErrUnauthorized is code when the credentials of the driver are invalid
+	// or do not allow the call
+	ErrUnauthorized
+	// ErrVolAlreadyAttached is code when a volume is already attached, to
+	// this or another instance
+	ErrVolAlreadyAttached
+	// ErrModifyUnsupported is code when a volume can not be modified to the
+	// requested type, IOPS or throughput in place
+	ErrModifyUnsupported
+	// ErrRetentionLocked is code when a snapshot can not be deleted as it is
+	// within the retention recorded in its backup catalog
+	ErrRetentionLocked
+	// ErrDeviceIOFailed is code when an attached device fails or hangs on I/O
+	ErrDeviceIOFailed
+	// ErrDryRun is code when a request of a dry run driver would have
+	// succeeded, see DryRun
+	ErrDryRun
+)
+
+// Attach options
+const (
+	// AttachOptionDevice requests a specific device for the attachment, e.g.
+	// /dev/xvdg on AWS
+	AttachOptionDevice = "device"
+	// AttachOptionReadOnly set to "true" attaches the volume read-only
+	AttachOptionReadOnly = "read-only"
+	// AttachOptionMultiAttach set to "true" allows the volume to be attached
+	// to more than one instance at a time
+	AttachOptionMultiAttach = "multi-attach"
+	// AttachOptionDeadline is the RFC 3339 time by which the caller needs the
+	// attach to start, queued attaches of an instance are started in order
+	// of their deadlines, see OpQueue
+	AttachOptionDeadline = "deadline"
 )
 
+// VolumeSpecUpdate is the change of a volume made by Modify. Zero fields are
+// left unchanged.
+type VolumeSpecUpdate struct {
+	// Type is the new provider volume type, e.g. gp3
+	Type string
+	// Iops are the new provisioned IOPS
+	Iops int64
+	// ThroughputMiBps is the new provisioned throughput
+	ThroughputMiBps int64
+}
+
 // ErrNotSupported is returned when a particular operation is not supported
 var ErrNotSupported = fmt.Errorf("operation not supported")
 
@@ -35,6 +102,23 @@ type StorageError struct {
 	Msg string
 	// Instance provides more information on the error.
 	Instance string
+	// Cause is the provider error this error was mapped from, if any
+	Cause error
+}
+
+// SnapshotFilter selects the snapshots returned by SnapshotEnumerate. Empty
+// fields match all snapshots.
+type SnapshotFilter struct {
+	// VolumeID of the volume the snapshots were taken from
+	VolumeID string
+	// CreatedAfter only matches snapshots created at or after this time
+	CreatedAfter time.Time
+	// CreatedBefore only matches snapshots created before this time
+	CreatedBefore time.Time
+	// State is the provider specific snapshot state, e.g. completed or READY
+	State string
+	// Labels that must be present on the snapshots
+	Labels map[string]string
 }
 
 // Ops interface to perform basic storage operations.
@@ -43,13 +127,31 @@ type Ops interface {
 	Name() string
 	// InstanceID returns the ID of the instance of the default instance the operations are performed on
 	InstanceID() string
+	// GetZone returns the availability zone of the instance
+	GetZone() (string, error)
+	// GetRegion returns the region of the instance
+	GetRegion() (string, error)
+	// ListZones returns the available zones of the region of the instance,
+	// sorted by name
+	ListZones() ([]string, error)
 	// Create volume based on input template volume and also apply given labels.
-	Create(template interface{}, labels map[string]string) (interface{}, error)
-	// GetDeviceID returns ID/Name of the given device/disk or snapshot
+	// The template is the provider template or a *VolumeSpec. The labels
+	// may carry a client token, see CreateIdempotent.
+	Create(template interface{}, labels map[string]string) (*Volume, error)
+	// GetDeviceID returns ID/Name of the given device/disk or snapshot, which
+	// may be a provider object, a *Volume or a *Snapshot
 	GetDeviceID(template interface{}) (string, error)
-	// Attach volumeID.
+	// Expand grows the given volume to newSizeGiB and returns its new size
+	// in GiB
+	Expand(volumeID string, newSizeGiB uint64) (uint64, error)
+	// Modify changes the type, provisioned IOPS or throughput of the given
+	// volume in place and waits for the change to take effect. Changes the
+	// provider can not make in place fail with ErrModifyUnsupported.
+	Modify(volumeID string, spec VolumeSpecUpdate) error
+	// Attach volumeID with the given AttachOption* options, options can be
+	// nil. Options a driver does not support are ignored.
 	// Return attach path.
-	Attach(volumeID string) (string, error)
+	Attach(volumeID string, options map[string]string) (string, error)
 	// Detach volumeID.
 	Detach(volumeID string) error
 	// DetachFrom detaches the disk/volume with given ID from the given instance ID
@@ -65,7 +167,7 @@ type Ops interface {
 	// the instance and where they are mapped to
 	FreeDevices(blockDeviceMappings []interface{}, rootDeviceName string) ([]string, error)
 	// Inspect volumes specified by volumeID
-	Inspect(volumeIds []*string) ([]interface{}, error)
+	Inspect(volumeIds []*string) ([]*Volume, error)
 	// DeviceMappings returns map[local_attached_volume_path]->volume ID/NAME
 	DeviceMappings() (map[string]string, error)
 	// Enumerate volumes that match given filters. Organize them into
@@ -74,18 +176,28 @@ type Ops interface {
 	Enumerate(volumeIds []*string,
 		labels map[string]string,
 		setIdentifier string,
-	) (map[string][]interface{}, error)
+	) (map[string][]*Volume, error)
 	// DevicePath for the given volume i.e path where it's attached
 	DevicePath(volumeID string) (string, error)
 	// Snapshot the volume with given volumeID
-	Snapshot(volumeID string, readonly bool) (interface{}, error)
+	Snapshot(volumeID string, readonly bool) (*Snapshot, error)
 	// SnapshotDelete deletes the snapshot with given ID
 	SnapshotDelete(snapID string) error
-	// ApplyTags will apply given labels/tags on the given volume
+	// SnapshotEnumerate returns all snapshots matching the given filter,
+	// following provider pagination. filter can be nil.
+	SnapshotEnumerate(filter *SnapshotFilter) ([]*Snapshot, error)
+	// SnapshotRestore creates a volume from the given snapshot in the given
+	// zone, or the zone of the instance if empty, applies the given labels
+	// and waits for it to be available. The volume is deleted on failure.
+	SnapshotRestore(snapID, zone string, labels map[string]string) (*Volume, error)
+	// SnapshotStatus returns the status of the given snapshot, see
+	// WaitForSnapshot to wait for its completion
+	SnapshotStatus(snapID string) (*SnapshotStatus, error)
+	// ApplyTags will apply given labels/tags on the given volume or snapshot
 	ApplyTags(volumeID string, labels map[string]string) error
-	// RemoveTags removes labels/tags from the given volume
+	// RemoveTags removes labels/tags from the given volume or snapshot
 	RemoveTags(volumeID string, labels map[string]string) error
-	// Tags will list the existing labels/tags on the given volume
+	// Tags will list the existing labels/tags on the given volume or snapshot
 	Tags(volumeID string) (map[string]string, error)
 }
 
@@ -94,6 +206,76 @@ func NewStorageError(code int, msg string, instance string) error {
 	return &StorageError{Code: code, Msg: msg, Instance: instance}
 }
 
+// WrapError creates a storage error with the given code for an error
+// returned by the provider, keeping its message. The provider error remains
+// available to errors.As.
+func WrapError(code int, cause error, instance string) error {
+	return &StorageError{Code: code, Msg: cause.Error(), Instance: instance, Cause: cause}
+}
+
 func (e *StorageError) Error() string {
 	return e.Msg
 }
+
+// Unwrap returns the provider error this error was mapped from
+func (e *StorageError) Unwrap() error {
+	return e.Cause
+}
+
+// Is makes errors.Is match storage errors by code, e.g.
+// errors.Is(err, &StorageError{Code: ErrThrottled})
+func (e *StorageError) Is(target error) bool {
+	t, ok := target.(*StorageError)
+	return ok && t.Code == e.Code && (len(t.Msg) == 0 || t.Msg == e.Msg)
+}
+
+// ErrorCode returns the code of the storage error in the chain of err
+func ErrorCode(err error) (int, bool) {
+	var se *StorageError
+	if errors.As(err, &se) {
+		return se.Code, true
+	}
+	return 0, false
+}
+
+// IsErrorCode returns true if the chain of err has a storage error with the
+// given code
+func IsErrorCode(err error, code int) bool {
+	c, ok := ErrorCode(err)
+	return ok && c == code
+}
+
+// Match returns true if a snapshot with the given properties matches the filter
+func (f *SnapshotFilter) Match(
+	volumeID string,
+	created time.Time,
+	state string,
+	labels map[string]string,
+) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.VolumeID) > 0 && f.VolumeID != volumeID {
+		return false
+	}
+	if !f.CreatedAfter.IsZero() && created.Before(f.CreatedAfter) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && !created.Before(f.CreatedBefore) {
+		return false
+	}
+	if len(f.State) > 0 && f.State != state {
+		return false
+	}
+	return HasLabels(labels, f.Labels)
+}
+
+// HasLabels returns true if the given labels carry all the wanted labels
+func HasLabels(labels, want map[string]string) bool {
+	for k, v := range want {
+		if value, ok := labels[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
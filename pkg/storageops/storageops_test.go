@@ -0,0 +1,2264 @@
+package storageops
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/libopenstorage/openstorage/pkg/auth"
+	"github.com/portworx/kvdb"
+	"github.com/portworx/kvdb/mem"
+	"github.com/portworx/sched-ops/task"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAESIDMapper(t *testing.T) {
+	_, err := NewAESIDMapper([]byte("short"))
+	require.Error(t, err)
+
+	m, err := NewAESIDMapper([]byte("0123456789abcdef0123456789abcdef"))
+	require.NoError(t, err)
+
+	h1, err := m.Encode("tenant-a", "vol-0123456789")
+	require.NoError(t, err)
+	require.NotContains(t, h1, "vol-")
+
+	h2, err := m.Encode("tenant-a", "vol-0123456789")
+	require.NoError(t, err)
+	require.Equal(t, h1, h2, "handles must be stable")
+
+	hb, err := m.Encode("tenant-b", "vol-0123456789")
+	require.NoError(t, err)
+	require.NotEqual(t, h1, hb, "handles must differ across tenants")
+
+	id, err := m.Decode("tenant-a", h1)
+	require.NoError(t, err)
+	require.Equal(t, "vol-0123456789", id)
+
+	_, err = m.Decode("tenant-b", h1)
+	require.Error(t, err, "handle of one tenant must not decode for another")
+
+	_, err = m.Decode("tenant-a", "vol-0123456789")
+	require.Error(t, err)
+}
+
+type fakeTenantOps struct {
+	Ops
+	vols  map[string]*Volume
+	snaps map[string]*Snapshot
+}
+
+func (f *fakeTenantOps) Create(template interface{}, labels map[string]string) (*Volume, error) {
+	vol := &Volume{ID: template.(string), Labels: labels}
+	f.vols[vol.ID] = vol
+	return vol, nil
+}
+
+func (f *fakeTenantOps) Inspect(volumeIds []*string) ([]*Volume, error) {
+	var vols []*Volume
+	for _, id := range volumeIds {
+		vol, ok := f.vols[*id]
+		if !ok {
+			return nil, NewStorageError(ErrVolNotFound, *id, "")
+		}
+		vols = append(vols, vol)
+	}
+	return vols, nil
+}
+
+func (f *fakeTenantOps) Enumerate(
+	volumeIds []*string,
+	labels map[string]string,
+	setIdentifier string,
+) (map[string][]*Volume, error) {
+	sets := make(map[string][]*Volume)
+	for _, vol := range f.vols {
+		if HasLabels(vol.Labels, labels) {
+			sets[vol.Labels[setIdentifier]] = append(sets[vol.Labels[setIdentifier]], vol)
+		}
+	}
+	return sets, nil
+}
+
+func (f *fakeTenantOps) Delete(volumeID string) error {
+	if _, ok := f.vols[volumeID]; !ok {
+		return NewStorageError(ErrVolNotFound, "volume "+volumeID+" not found", volumeID)
+	}
+	return fmt.Errorf("volume %s is attached", volumeID)
+}
+
+func (f *fakeTenantOps) GetDeviceID(template interface{}) (string, error) {
+	return template.(string), nil
+}
+
+func (f *fakeTenantOps) Snapshot(volumeID string, readonly bool) (*Snapshot, error) {
+	if _, ok := f.vols[volumeID]; !ok {
+		return nil, NewStorageError(ErrVolNotFound, volumeID, "")
+	}
+	snap := &Snapshot{ID: "snap-" + volumeID, VolumeID: volumeID}
+	f.snaps[snap.ID] = snap
+	return snap, nil
+}
+
+func (f *fakeTenantOps) ApplyTags(id string, labels map[string]string) error {
+	snap, ok := f.snaps[id]
+	if !ok {
+		return NewStorageError(ErrVolNotFound, id, "")
+	}
+	snap.Labels = make(map[string]string)
+	for k, v := range labels {
+		snap.Labels[k] = v
+	}
+	return nil
+}
+
+func (f *fakeTenantOps) SnapshotDelete(snapID string) error {
+	delete(f.snaps, snapID)
+	return nil
+}
+
+func (f *fakeTenantOps) SnapshotEnumerate(filter *SnapshotFilter) ([]*Snapshot, error) {
+	var snaps []*Snapshot
+	for _, snap := range f.snaps {
+		if filter.Match(snap.VolumeID, snap.Created, snap.State, snap.Labels) {
+			snaps = append(snaps, snap)
+		}
+	}
+	return snaps, nil
+}
+
+func (f *fakeTenantOps) SnapshotRestore(
+	snapID, zone string,
+	labels map[string]string,
+) (*Volume, error) {
+	if _, ok := f.snaps[snapID]; !ok {
+		return nil, NewStorageError(ErrVolNotFound, snapID, "")
+	}
+	return f.Create("restore-"+snapID, labels)
+}
+
+func TestTenantOps(t *testing.T) {
+	m, err := NewAESIDMapper([]byte("0123456789abcdef0123456789abcdef"))
+	require.NoError(t, err)
+	fake := &fakeTenantOps{vols: make(map[string]*Volume), snaps: make(map[string]*Snapshot)}
+	ops := NewTenantOps(fake, m, "tenant-a")
+	encode := func(id string) string {
+		handle, err := m.Encode("tenant-a", id)
+		require.NoError(t, err)
+		return handle
+	}
+
+	vol, err := ops.Create("vol-1", map[string]string{"set": "a"})
+	require.NoError(t, err)
+	require.Equal(t, encode("vol-1"), vol.ID)
+	require.Equal(t, "vol-1", fake.vols["vol-1"].ID, "the provider volume must not be modified")
+	require.Equal(t, "tenant-a", fake.vols["vol-1"].Labels[TenantLabel])
+
+	handle, err := ops.GetDeviceID("vol-1")
+	require.NoError(t, err)
+	require.Equal(t, vol.ID, handle)
+	handle, err = ops.GetDeviceID(vol.ID)
+	require.NoError(t, err)
+	require.Equal(t, vol.ID, handle, "handles must not be encoded again")
+
+	vols, err := ops.Inspect([]*string{&vol.ID})
+	require.NoError(t, err)
+	require.Len(t, vols, 1)
+	require.Equal(t, vol.ID, vols[0].ID)
+
+	sets, err := ops.Enumerate(nil, nil, "set")
+	require.NoError(t, err)
+	require.Len(t, sets["a"], 1)
+	require.Equal(t, vol.ID, sets["a"][0].ID)
+
+	snap, err := ops.Snapshot(vol.ID, false)
+	require.NoError(t, err)
+	require.Equal(t, encode("snap-vol-1"), snap.ID)
+	require.Equal(t, vol.ID, snap.VolumeID)
+
+	snaps, err := ops.SnapshotEnumerate(&SnapshotFilter{VolumeID: vol.ID})
+	require.NoError(t, err)
+	require.Len(t, snaps, 1)
+	require.Equal(t, snap.ID, snaps[0].ID)
+	require.Equal(t, vol.ID, snaps[0].VolumeID)
+	snaps, err = ops.SnapshotEnumerate(nil)
+	require.NoError(t, err)
+	require.Len(t, snaps, 1)
+	_, err = ops.SnapshotEnumerate(&SnapshotFilter{VolumeID: "vol-1"})
+	require.True(t, IsErrorCode(err, ErrVolNotFound), "raw IDs must not be accepted")
+
+	restored, err := ops.SnapshotRestore(snap.ID, "", nil)
+	require.NoError(t, err)
+	require.Equal(t, encode("restore-snap-vol-1"), restored.ID)
+
+	_, err = ops.Inspect([]*string{&fake.vols["vol-1"].ID})
+	require.True(t, IsErrorCode(err, ErrVolNotFound), "raw IDs must not be accepted")
+	other := NewTenantOps(fake, m, "tenant-b")
+	_, err = other.Snapshot(vol.ID, false)
+	require.True(t, IsErrorCode(err, ErrVolNotFound), "handles of another tenant must not be accepted")
+
+	_, err = other.Create("vol-2", map[string]string{"set": "a"})
+	require.NoError(t, err)
+	sets, err = other.Enumerate(nil, nil, "set")
+	require.NoError(t, err)
+	require.Len(t, sets["a"], 1, "volumes of another tenant must not be enumerated")
+	snaps, err = other.SnapshotEnumerate(nil)
+	require.NoError(t, err)
+	require.Empty(t, snaps, "snapshots of another tenant must not be enumerated")
+	_, err = other.Create("vol-3", map[string]string{TenantLabel: "tenant-a"})
+	require.True(t, IsErrorCode(err, ErrVolInval), "the tenant label must not be set")
+	require.True(t, IsErrorCode(ops.ApplyTags(snap.ID, map[string]string{TenantLabel: "tenant-b"}), ErrVolInval),
+		"the tenant label must not be changed")
+
+	err = ops.Delete(vol.ID)
+	require.EqualError(t, err, "volume "+vol.ID+" is attached")
+	require.NotContains(t, err.Error(), "vol-1", "raw IDs must not be returned in errors")
+	fake.vols = nil
+	err = ops.Delete(vol.ID)
+	require.True(t, IsErrorCode(err, ErrVolNotFound), "error codes must be kept")
+	require.NotContains(t, err.Error(), "vol-1", "raw IDs must not be returned in errors")
+	require.Equal(t, vol.ID, err.(*StorageError).Instance)
+}
+
+type fakeSpecOps struct {
+	Ops
+	specs map[string]*DesiredSpec
+}
+
+func (f *fakeSpecOps) Name() string { return "fake" }
+
+func (f *fakeSpecOps) ApplyTags(volumeID string, labels map[string]string) error {
+	for k, v := range labels {
+		f.specs[volumeID].Tags[k] = v
+	}
+	return nil
+}
+
+func (f *fakeSpecOps) Spec(volumeID string) (*DesiredSpec, error) {
+	spec, ok := f.specs[volumeID]
+	if !ok {
+		return nil, NewStorageError(ErrVolNotFound, volumeID, "")
+	}
+	copied := *spec
+	copied.Tags = make(map[string]string)
+	for k, v := range spec.Tags {
+		copied.Tags[k] = v
+	}
+	return &copied, nil
+}
+
+func (f *fakeSpecOps) Modify(volumeID string, spec VolumeSpecUpdate) error {
+	f.specs[volumeID].Type = spec.Type
+	f.specs[volumeID].Iops = spec.Iops
+	f.specs[volumeID].ThroughputMiBps = spec.ThroughputMiBps
+	return nil
+}
+
+func TestDriftDetector(t *testing.T) {
+	kv, err := kvdb.New(mem.Name, "drift_test", []string{}, nil, logrus.Panicf)
+	require.NoError(t, err)
+
+	ops := &fakeSpecOps{specs: map[string]*DesiredSpec{
+		"vol-1": {Type: "gp2", Iops: 3000, ThroughputMiBps: 125, Tags: map[string]string{"owner": "ops"}},
+		"vol-2": {Type: "io1", Iops: 1000, Encrypted: true, Tags: map[string]string{}},
+	}}
+	d := NewDriftDetector(ops, ops, kv)
+
+	require.NoError(t, d.SetDesired("vol-1", &DesiredSpec{
+		Type: "gp3", Iops: 4000, ThroughputMiBps: 250, Encrypted: true,
+		Tags: map[string]string{"owner": "ops"},
+	}))
+	require.NoError(t, d.SetDesired("vol-2", &DesiredSpec{Type: "io1", Iops: 1000}))
+	require.NoError(t, d.SetDesired("vol-gone", &DesiredSpec{Type: "gp3"}))
+
+	drifts, err := d.Check(false)
+	require.NoError(t, err)
+	require.Len(t, drifts, 4)
+	for _, drift := range drifts {
+		require.Equal(t, "vol-1", drift.VolumeID)
+		require.False(t, drift.Remediated)
+	}
+
+	require.NoError(t, d.SetDesired("vol-2", &DesiredSpec{Tags: map[string]string{"team": "db"}}))
+	drifts, err = d.Check(true)
+	require.NoError(t, err)
+	require.Len(t, drifts, 5)
+	for _, drift := range drifts {
+		require.Equal(t, drift.Field != "encrypted", drift.Remediated, "%v", drift)
+	}
+	require.Equal(t, "gp3", ops.specs["vol-1"].Type)
+	require.Equal(t, int64(4000), ops.specs["vol-1"].Iops)
+	require.Equal(t, int64(250), ops.specs["vol-1"].ThroughputMiBps)
+	require.Equal(t, "db", ops.specs["vol-2"].Tags["team"])
+
+	drifts, err = d.Check(false)
+	require.NoError(t, err)
+	require.Len(t, drifts, 1)
+	require.Equal(t, "encrypted", drifts[0].Field)
+
+	require.NoError(t, d.RemoveDesired("vol-1"))
+	drifts, err = d.Check(false)
+	require.NoError(t, err)
+	require.Empty(t, drifts)
+}
+
+func TestBudget(t *testing.T) {
+	b := NewBudget(3, 0)
+	b.StartCycle()
+	require.True(t, b.Allow())
+	require.True(t, b.Allow())
+	require.Equal(t, 1, b.Stats().Remaining)
+
+	// one unused call carries over
+	b.StartCycle()
+	require.Equal(t, 1, b.Stats().CarryOver)
+	for i := 0; i < 4; i++ {
+		require.True(t, b.Allow())
+	}
+	require.False(t, b.Allow())
+	require.True(t, b.Exhausted())
+
+	// forced calls are deducted from the next cycle
+	b.Charge(2)
+	b.StartCycle()
+	stats := b.Stats()
+	require.Equal(t, -2, stats.CarryOver)
+	require.Equal(t, 1, stats.Remaining)
+	require.Equal(t, uint64(1), stats.ExhaustedCycles)
+	require.Equal(t, uint64(1), stats.Rejected)
+
+	ops := NewBudgetedOps(&fakeSpecOps{}, b)
+	require.True(t, b.Allow())
+	err := ops.ApplyTags("vol-1", nil)
+	require.Error(t, err)
+	require.Equal(t, ErrBudgetExhausted, err.(*StorageError).Code)
+
+	b = NewBudget(0, time.Millisecond)
+	b.StartCycle()
+	require.True(t, b.Allow())
+	time.Sleep(2 * time.Millisecond)
+	require.False(t, b.Allow())
+}
+
+func TestSnapshotFilterMatch(t *testing.T) {
+	now := time.Now()
+	var none *SnapshotFilter
+	require.True(t, none.Match("vol-1", now, "completed", nil))
+
+	f := &SnapshotFilter{
+		VolumeID:      "vol-1",
+		CreatedAfter:  now.Add(-7 * 24 * time.Hour),
+		CreatedBefore: now,
+		State:         "completed",
+		Labels:        map[string]string{"cluster": "c1"},
+	}
+	labels := map[string]string{"cluster": "c1", "app": "db"}
+	require.True(t, f.Match("vol-1", now.Add(-time.Hour), "completed", labels))
+	require.False(t, f.Match("vol-2", now.Add(-time.Hour), "completed", labels))
+	require.False(t, f.Match("vol-1", now.Add(-8*24*time.Hour), "completed", labels))
+	require.False(t, f.Match("vol-1", now, "completed", labels))
+	require.False(t, f.Match("vol-1", now.Add(-time.Hour), "pending", labels))
+	require.False(t, f.Match("vol-1", now.Add(-time.Hour), "completed", nil))
+	require.False(t, f.Match("vol-1", now.Add(-time.Hour), "completed",
+		map[string]string{"cluster": "c2"}))
+}
+
+// fakeUnfilteredOps ignores the labels of Enumerate and SnapshotEnumerate
+// like a buggy driver
+type fakeUnfilteredOps struct {
+	Ops
+	vols    []*Volume
+	snaps   []*Snapshot
+	deleted []string
+}
+
+func (f *fakeUnfilteredOps) Enumerate(
+	volumeIds []*string,
+	labels map[string]string,
+	setIdentifier string,
+) (map[string][]*Volume, error) {
+	return map[string][]*Volume{SetIdentifierNone: f.vols}, nil
+}
+
+func (f *fakeUnfilteredOps) SnapshotEnumerate(filter *SnapshotFilter) ([]*Snapshot, error) {
+	return f.snaps, nil
+}
+
+func (f *fakeUnfilteredOps) Delete(volumeID string) error {
+	f.deleted = append(f.deleted, volumeID)
+	return nil
+}
+
+func (f *fakeUnfilteredOps) SnapshotDelete(snapID string) error {
+	f.deleted = append(f.deleted, snapID)
+	return nil
+}
+
+func TestDeleteMatchingLabels(t *testing.T) {
+	c1 := map[string]string{"cluster": "c1", "app": "db"}
+	ops := &fakeUnfilteredOps{
+		vols: []*Volume{
+			{ID: "vol-1", Labels: c1},
+			{ID: "vol-2", Labels: map[string]string{"cluster": "c2"}},
+			{ID: "vol-3"},
+		},
+		snaps: []*Snapshot{
+			{ID: "snap-1", Labels: c1},
+			{ID: "snap-2", Labels: map[string]string{"cluster": "c2"}},
+			{ID: "snap-3"},
+		},
+	}
+	labels := map[string]string{"cluster": "c1"}
+
+	results, err := DeleteMatching(ops, labels, false, nil)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Equal(t, "vol-1", results[0].VolumeID)
+
+	snapResults, err := SnapshotDeleteMatching(ops, labels, 0)
+	require.NoError(t, err)
+	require.Len(t, snapResults, 1)
+	require.Equal(t, "snap-1", snapResults[0].SnapshotID)
+
+	require.Equal(t, []string{"vol-1", "snap-1"}, ops.deleted,
+		"only volumes and snapshots with the labels may be deleted")
+}
+
+func TestOpQueue(t *testing.T) {
+	q := NewOpQueue(0)
+	release, err := q.Acquire(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	order := make(chan int, 3)
+	done := make(chan struct{})
+	for i, deadline := range []time.Duration{3 * time.Hour, time.Hour, 2 * time.Hour} {
+		go func(i int, deadline time.Duration) {
+			defer func() { done <- struct{}{} }()
+			r, err := q.Acquire(time.Now().Add(deadline))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			order <- i
+			r()
+		}(i, deadline)
+	}
+	for q.Len() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	release()
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+	require.Equal(t, 1, <-order)
+	require.Equal(t, 2, <-order)
+	require.Equal(t, 0, <-order)
+
+	// the deadline of an attach is taken from its options, a later queued
+	// attach with an earlier deadline runs first
+	release, err = q.Acquire(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	for i, deadline := range []time.Duration{2 * time.Hour, time.Hour} {
+		options := map[string]string{
+			AttachOptionDeadline: time.Now().Add(deadline).Format(time.RFC3339),
+		}
+		d, err := AttachDeadline(options, time.Minute)
+		require.NoError(t, err)
+		go func(i int, deadline time.Time) {
+			defer func() { done <- struct{}{} }()
+			r, err := q.Acquire(deadline)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			order <- i
+			r()
+		}(i, d)
+		for q.Len() < i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	release()
+	for i := 0; i < 2; i++ {
+		<-done
+	}
+	require.Equal(t, 1, <-order)
+	require.Equal(t, 0, <-order)
+
+	noDeadline, err := AttachDeadline(nil, time.Minute)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(time.Minute), noDeadline, time.Second)
+	_, err = AttachDeadline(map[string]string{AttachOptionDeadline: "soon"}, time.Minute)
+	require.True(t, IsErrorCode(err, ErrVolInval), "%v", err)
+
+	// a queued operation times out once its deadline passed and the running
+	// operation stopped making progress
+	q = NewOpQueue(0)
+	release, err = q.Acquire(time.Now())
+	require.NoError(t, err)
+	_, err = q.Acquire(time.Now())
+	require.Error(t, err)
+	require.Equal(t, 0, q.Len())
+	release()
+}
+
+func TestAuditLogTimeline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	log := NewAuditLog(filepath.Join(dir, "audit.log"))
+	entries, err := log.Timeline(nil, time.Time{}, time.Now())
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	now := time.Now()
+	require.NoError(t, log.PublishEvent(&Event{Type: EventAttach, VolumeID: "vol-1", Time: now.Add(-3 * time.Hour)}))
+	require.NoError(t, log.PublishEvent(&Event{Type: EventDetach, VolumeID: "vol-2", Time: now.Add(-2 * time.Hour)}))
+	require.NoError(t, log.PublishEvent(&Event{Type: EventDelete, VolumeID: "vol-1", Time: now.Add(-time.Hour), Err: "busy"}))
+
+	entries, err = log.Timeline([]string{"vol-1"}, now.Add(-4*time.Hour), now)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "busy", entries[1].Err)
+
+	provider := []*TimelineEntry{{Time: now.Add(-150 * time.Minute), Operation: "DetachVolume", VolumeID: "vol-1"}}
+	merged := MergeTimelines(entries, provider)
+	require.Len(t, merged, 3)
+	require.Equal(t, "DetachVolume", merged[1].Operation)
+}
+
+func TestMigrator(t *testing.T) {
+	kv, err := kvdb.New(mem.Name, "migrate_test", []string{}, nil, logrus.Panicf)
+	require.NoError(t, err)
+
+	_, err = NewMigrator(kv, "test", []Migration{{Version: 2}})
+	require.Error(t, err, "versions must start at 1")
+
+	var ran []int
+	step := func(v int) Migration {
+		return Migration{Version: v, Migrate: func(kvdb.Kvdb) error {
+			ran = append(ran, v)
+			return nil
+		}}
+	}
+	m, err := NewMigrator(kv, "test", []Migration{step(2), step(1)})
+	require.NoError(t, err)
+	version, err := m.Version()
+	require.NoError(t, err)
+	require.Equal(t, 0, version)
+
+	require.NoError(t, m.Migrate())
+	require.Equal(t, []int{1, 2}, ran)
+	version, err = m.Version()
+	require.NoError(t, err)
+	require.Equal(t, 2, version)
+
+	// only new migrations run after an upgrade
+	m, err = NewMigrator(kv, "test", []Migration{step(1), step(2), step(3)})
+	require.NoError(t, err)
+	require.NoError(t, m.Migrate())
+	require.Equal(t, []int{1, 2, 3}, ran)
+
+	// a downgraded binary refuses newer state
+	m, err = NewMigrator(kv, "test", []Migration{step(1)})
+	require.NoError(t, err)
+	require.Error(t, m.Migrate())
+
+	require.NoError(t, MigrateState(kv))
+}
+
+func TestAdaptivePolling(t *testing.T) {
+	h := &LatencyHistogram{}
+	// Without history polling starts fast and backs off
+	require.Equal(t, MinPollInterval, h.NextInterval(0, 0))
+	require.Equal(t, 2*MinPollInterval, h.NextInterval(MinPollInterval, MinPollInterval))
+	require.Equal(t, MaxPollInterval, h.NextInterval(10*time.Second, MaxPollInterval))
+
+	for i := 0; i < 10; i++ {
+		h.Observe(5 * time.Second)
+	}
+	q := h.Quantile(0.5)
+	require.True(t, q > 3*time.Second && q <= 6*time.Second, "p50 %v", q)
+
+	// Long before the observed latencies polling is capped, close to them it
+	// polls at the quantiles
+	require.Equal(t, MaxPollInterval, h.NextInterval(0, 0))
+	next := h.NextInterval(h.Quantile(0.05)-time.Second, MaxPollInterval)
+	require.Equal(t, time.Second, next)
+
+	key := "test/attached"
+	calls := 0
+	out, err := PollWithTimeout(
+		"test",
+		func() string { return key },
+		func() (interface{}, bool, error) {
+			calls++
+			if calls < 3 {
+				return nil, true, fmt.Errorf("not yet")
+			}
+			return calls, false, nil
+		},
+		time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, 3, out)
+	require.Equal(t, float64(1), PollLatency(key).Count())
+}
+
+func TestVolumeIDFromMappings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "devices")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"nvme1n1", "nvme1n1p1", "xvdf", "xvdf2"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), nil, 0600))
+	}
+	link := filepath.Join(dir, "by-id-part1")
+	require.NoError(t, os.Symlink(filepath.Join(dir, "nvme1n1p1"), link))
+
+	dev, err := BaseDevice(link)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "nvme1n1"), dev)
+	dev, err = BaseDevice(filepath.Join(dir, "xvdf2"))
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "xvdf"), dev)
+
+	mappings := map[string]string{
+		filepath.Join(dir, "nvme1n1"): "vol-1",
+		filepath.Join(dir, "xvdf"):    "vol-2",
+	}
+	volumeID, err := VolumeIDFromMappings(mappings, filepath.Join(dir, "xvdf"))
+	require.NoError(t, err)
+	require.Equal(t, "vol-2", volumeID)
+	_, err = VolumeIDFromMappings(mappings, filepath.Join(dir, "xvdg"))
+	require.Error(t, err)
+}
+
+func TestFsckExitStatus(t *testing.T) {
+	tests := []struct {
+		fsType   string
+		repair   bool
+		code     int
+		clean    bool
+		repaired bool
+		err      bool
+	}{
+		{fsType: "ext4", code: 0, clean: true},
+		{fsType: "ext4", code: 4},
+		{fsType: "ext4", repair: true, code: 1, clean: true, repaired: true},
+		{fsType: "ext4", repair: true, code: 5},
+		{fsType: "ext4", code: 8, err: true},
+		{fsType: "xfs", code: 0, clean: true},
+		{fsType: "xfs", code: 1},
+		{fsType: "xfs", repair: true, code: 2, err: true},
+	}
+	for _, test := range tests {
+		clean, repaired, err := fsckExitStatus(test.fsType, test.repair, test.code)
+		require.Equal(t, test.err, err != nil, "%+v: %v", test, err)
+		require.Equal(t, test.clean, clean, "%+v", test)
+		require.Equal(t, test.repaired, repaired, "%+v", test)
+	}
+	require.Nil(t, fsckCommand("vfat", false))
+	require.Equal(t, []string{"xfs_repair", "-n"}, fsckCommand("xfs", false))
+}
+
+type fakeEnumerateOps struct {
+	Ops
+	sets map[string][]*Volume
+}
+
+// volumes returns volumes with the given IDs
+func volumes(ids ...string) []*Volume {
+	vols := make([]*Volume, len(ids))
+	for i, id := range ids {
+		vols[i] = &Volume{ID: id}
+	}
+	return vols
+}
+
+func (f *fakeEnumerateOps) Name() string { return "fake" }
+
+func (f *fakeEnumerateOps) InstanceID() string { return "i-1" }
+
+func (f *fakeEnumerateOps) GetDeviceID(vol interface{}) (string, error) {
+	return vol.(*Volume).ID, nil
+}
+
+func (f *fakeEnumerateOps) Enumerate(
+	volumeIds []*string,
+	labels map[string]string,
+	setIdentifier string,
+) (map[string][]*Volume, error) {
+	return f.sets, nil
+}
+
+type fakePublisher struct {
+	events []*Event
+}
+
+func (f *fakePublisher) PublishEvent(event *Event) error {
+	f.events = append(f.events, event)
+	return nil
+}
+
+func (f *fakePublisher) PublishInventory(sets map[string][]*Volume) error { return nil }
+
+// blockingPublisher publishes events once release is closed
+type blockingPublisher struct {
+	fakePublisher
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingPublisher) PublishEvent(event *Event) error {
+	b.started <- struct{}{}
+	<-b.release
+	return b.fakePublisher.PublishEvent(event)
+}
+
+func TestPublishingOps(t *testing.T) {
+	publisher := &blockingPublisher{started: make(chan struct{}, 4), release: make(chan struct{})}
+	async := NewAsyncPublisher(publisher, 2)
+	ops := NewPublishingOps(&fakeAttachOps{}, async)
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		_, err := ops.Attach(fmt.Sprintf("vol-%d", i), nil)
+		require.NoError(t, err)
+		if i == 0 {
+			<-publisher.started
+		}
+	}
+	require.True(t, time.Since(start) < time.Second, "a blocked publisher must not block operations")
+	// one event is being published, two are buffered and the last is dropped
+	require.Equal(t, uint64(1), async.Dropped())
+
+	close(publisher.release)
+	async.Close()
+	require.Len(t, publisher.events, 3)
+	for i, event := range publisher.events {
+		require.Equal(t, EventAttach, event.Type)
+		require.Equal(t, "fake", event.Provider)
+		require.Equal(t, fmt.Sprintf("vol-%d", i), event.VolumeID)
+	}
+	require.Error(t, async.PublishEvent(&Event{Type: EventDetach}), "closed publishers must not publish")
+}
+
+func TestAntiEntropy(t *testing.T) {
+	kv, err := kvdb.New(mem.Name, "antientropy_test", []string{}, nil, logrus.Panicf)
+	require.NoError(t, err)
+
+	ops := &fakeEnumerateOps{sets: map[string][]*Volume{
+		"set-a": volumes("vol-1", "vol-2"),
+	}}
+	publisher := &fakePublisher{}
+	a := NewAntiEntropy(ops, kv, nil, "set", publisher)
+	require.NoError(t, a.PutRecord(&DriveRecord{VolumeID: "vol-1", Set: "set-a"}))
+	require.NoError(t, a.PutRecord(&DriveRecord{VolumeID: "vol-gone", Set: "set-a"}))
+
+	report, err := a.Check(false)
+	require.NoError(t, err)
+	require.False(t, report.Consistent())
+	require.Equal(t, []*DriveRecord{{VolumeID: "vol-gone", Set: "set-a"}}, report.MissingInCloud)
+	require.Equal(t, []*DriveRecord{{VolumeID: "vol-2", Set: "set-a"}}, report.MissingInKvdb)
+	require.Empty(t, report.Repairs)
+
+	report, err = a.Check(true)
+	require.NoError(t, err)
+	require.Len(t, report.Repairs, 2)
+	require.Len(t, publisher.events, 2)
+	require.Equal(t, EventRecordRemove, publisher.events[0].Type)
+	require.Equal(t, EventRecordAdd, publisher.events[1].Type)
+
+	report, err = a.Check(false)
+	require.NoError(t, err)
+	require.True(t, report.Consistent())
+	records, err := a.Records()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+}
+
+func TestRetryWithTimeout(t *testing.T) {
+	SetRetryLogging(false, time.Millisecond)
+	defer SetRetryLogging(false, DefaultRetryProgressInterval)
+
+	calls := 0
+	out, err := RetryWithTimeout("test",
+		func() (interface{}, bool, error) {
+			calls++
+			if calls < 3 {
+				return nil, true, fmt.Errorf("not yet")
+			}
+			return "done", false, nil
+		},
+		time.Minute, time.Millisecond)
+	require.NoError(t, err)
+	require.Equal(t, "done", out)
+	require.Equal(t, 3, calls)
+
+	_, err = RetryWithTimeout("test",
+		func() (interface{}, bool, error) {
+			return nil, true, NewStorageError(ErrVolDetached, "never", "")
+		},
+		10*time.Millisecond, time.Millisecond)
+	require.True(t, errors.Is(err, task.ErrTimedOut), "%v", err)
+	require.True(t, IsErrorCode(err, ErrVolDetached), "the code of the last error must be kept")
+
+	fatal := fmt.Errorf("fatal")
+	_, err = RetryWithTimeout("test",
+		func() (interface{}, bool, error) {
+			return nil, false, fatal
+		},
+		time.Minute, time.Millisecond)
+	require.Equal(t, fatal, err)
+}
+
+func TestChainedOps(t *testing.T) {
+	var calls []string
+	recorder := func(name string) Interceptor {
+		return func(op string, args []interface{}, invoke Invoker) error {
+			calls = append(calls, name+" "+op)
+			err := invoke()
+			calls = append(calls, name+" done")
+			return err
+		}
+	}
+	readOnly := func(op string, args []interface{}, invoke Invoker) error {
+		if op == "Delete" {
+			return fmt.Errorf("%s of %v rejected", op, args)
+		}
+		return invoke()
+	}
+
+	base := &fakeEnumerateOps{sets: map[string][]*Volume{"": volumes("vol-1")}}
+	ops := NewChainedOps(base,
+		InterceptorMiddleware(recorder("outer"), recorder("inner")),
+		InterceptorMiddleware(readOnly))
+	require.Equal(t, "fake", ops.Name())
+
+	sets, err := ops.Enumerate(nil, nil, "")
+	require.NoError(t, err)
+	require.Equal(t, base.sets, sets)
+	require.Equal(t, []string{"outer Enumerate", "inner Enumerate", "inner done", "outer done"}, calls)
+
+	calls = nil
+	err = ops.Delete("vol-1")
+	require.EqualError(t, err, "Delete of [vol-1] rejected")
+	require.Equal(t, []string{"outer Delete", "inner Delete", "inner done", "outer done"}, calls)
+}
+
+func TestAuthorization(t *testing.T) {
+	user := func(roles ...string) *auth.UserInfo {
+		return &auth.UserInfo{Username: "jdoe", Claims: auth.Claims{Roles: roles}}
+	}
+	require.Equal(t, RoleNone, RoleOf(&user().Claims))
+	require.Equal(t, RoleAdmin, RoleOf(&user("clouddrive.viewer", "system.admin").Claims))
+	require.Equal(t, RoleOperator, RoleOf(&user("system.user").Claims))
+
+	require.NoError(t, Authorize(nil, "Delete"))
+	require.NoError(t, Authorize(user("clouddrive.viewer"), "Enumerate"))
+	require.Error(t, Authorize(user("clouddrive.viewer"), "Attach"))
+	require.NoError(t, Authorize(user("clouddrive.operator"), "Snapshot"))
+	require.Error(t, Authorize(user("clouddrive.operator"), OpModifyPolicy))
+	require.NoError(t, Authorize(user("clouddrive.admin"), "SnapshotDelete"))
+	require.Error(t, Authorize(user(), "Enumerate"))
+	require.Error(t, Authorize(user("clouddrive.operator"), "UnknownOp"))
+
+	base := &fakeEnumerateOps{sets: map[string][]*Volume{"": volumes("vol-1")}}
+	ctx := auth.ContextSaveUserInfo(context.Background(), user("clouddrive.viewer"))
+	ops := NewChainedOps(base, AuthorizationMiddleware(ctx))
+	_, err := ops.Enumerate(nil, nil, "")
+	require.NoError(t, err)
+	err = ops.Delete("vol-1")
+	require.Error(t, err)
+	se, ok := err.(*StorageError)
+	require.True(t, ok)
+	require.Equal(t, ErrPermissionDenied, se.Code)
+}
+
+func TestMetricsCollector(t *testing.T) {
+	require.Equal(t, "wait for volume", retryKind("wait for volume vol-1 to be available"))
+	require.Equal(t, "wait for snapshot copy", retryKind("wait for snapshot copy snap-2 in us-west-2"))
+	require.Equal(t, "wait for attach", retryKind("wait for attach"))
+
+	c := NewMetricsCollector()
+	reg := prometheus.NewRegistry()
+	require.NoError(t, reg.Register(c))
+
+	base := &fakeEnumerateOps{sets: map[string][]*Volume{"": volumes("vol-1")}}
+	ops := NewChainedOps(base,
+		c.Middleware(),
+		InterceptorMiddleware(func(op string, args []interface{}, invoke Invoker) error {
+			if op == "Delete" {
+				return fmt.Errorf("rejected")
+			}
+			return invoke()
+		}))
+	_, err := ops.Enumerate(nil, nil, "")
+	require.NoError(t, err)
+	require.Error(t, ops.Delete("vol-1"))
+
+	attempts := 0
+	_, err = RetryWithTimeout("wait for volume vol-1 to be available", func() (interface{}, bool, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, true, fmt.Errorf("not yet")
+		}
+		return nil, false, nil
+	}, time.Second, time.Millisecond)
+	require.NoError(t, err)
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	values := make(map[string]float64)
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			name := f.GetName()
+			for _, l := range m.GetLabel() {
+				name += "," + l.GetValue()
+			}
+			switch {
+			case m.Counter != nil:
+				values[name] = m.GetCounter().GetValue()
+			case m.Histogram != nil:
+				values[name] = float64(m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	require.Equal(t, float64(1), values["storageops_operation_duration_seconds,Enumerate,fake"])
+	require.Equal(t, float64(1), values["storageops_operation_duration_seconds,Delete,fake"])
+	require.Equal(t, float64(1), values["storageops_operation_errors_total,Delete,fake"])
+	require.Equal(t, float64(2), values["storageops_wait_retries_total,wait for volume"])
+}
+
+type fakeSpanExporter struct {
+	spans []*SpanData
+}
+
+func (f *fakeSpanExporter) ExportSpan(span *SpanData) {
+	f.spans = append(f.spans, span)
+}
+
+type fakeDetachOps struct {
+	fakeEnumerateOps
+}
+
+func (f *fakeDetachOps) Detach(volumeID string) error {
+	_, err := RetryWithTimeout("wait for volume "+volumeID+" to be detached",
+		func() (interface{}, bool, error) { return nil, false, nil },
+		time.Second, time.Millisecond)
+	return err
+}
+
+func TestTracing(t *testing.T) {
+	exporter := &fakeSpanExporter{}
+	RegisterSpanExporter(exporter)
+	defer UnregisterSpanExporter(exporter)
+
+	ops := NewChainedOps(&fakeDetachOps{}, TracingMiddleware())
+	require.NoError(t, ops.Detach("vol-1"))
+	_, err := RetryWithTimeout("wait for volume vol-2 to be detached",
+		func() (interface{}, bool, error) { return nil, false, nil },
+		time.Second, time.Millisecond)
+	require.NoError(t, err)
+
+	require.Len(t, exporter.spans, 1)
+	span := exporter.spans[0]
+	require.Equal(t, "storageops.fake.Detach", span.Name)
+	require.Equal(t, "vol-1", span.Attributes["volumeID"])
+	require.Equal(t, "i-1", span.Attributes["instanceID"])
+	require.Len(t, span.TraceID, 32)
+	require.Len(t, span.Annotations, 2)
+	require.Equal(t, "wait for volume vol-1 to be detached", span.Annotations[0].Message)
+	require.NoError(t, span.Err)
+}
+
+func TestPageKeys(t *testing.T) {
+	keys := []string{"a", "b", "c", "d", "e"}
+	lo, hi, next, err := PageKeys(keys, &PageRequest{Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, keys[lo:hi])
+	require.NotEqual(t, Cursor(""), next)
+
+	// a key added before the cursor does not shift the next page
+	keys = []string{"a", "aa", "b", "c", "d", "e"}
+	lo, hi, next, err = PageKeys(keys, &PageRequest{Cursor: next, Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []string{"c", "d"}, keys[lo:hi])
+
+	lo, hi, next, err = PageKeys(keys, &PageRequest{Cursor: next, Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []string{"e"}, keys[lo:hi])
+	require.Equal(t, Cursor(""), next)
+
+	lo, hi, _, err = PageKeys(keys, nil)
+	require.NoError(t, err)
+	require.Equal(t, keys, keys[lo:hi])
+
+	_, _, _, err = PageKeys(keys, &PageRequest{Cursor: "not-a-cursor"})
+	require.Error(t, err)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	p := &RetryPolicy{
+		Interval:    time.Millisecond,
+		Multiplier:  2,
+		MaxInterval: 5 * time.Millisecond,
+		MaxAttempts: 3,
+		Timeout:     time.Second,
+		Overrides: map[string]*RetryPolicy{
+			RetryOpAttach: {Jitter: 0.5, MaxAttempts: 10},
+		},
+	}
+	require.NoError(t, p.Validate())
+	require.Equal(t, time.Millisecond, p.Backoff(1))
+	require.Equal(t, 4*time.Millisecond, p.Backoff(3))
+	require.Equal(t, 5*time.Millisecond, p.Backoff(10))
+
+	attach := p.For(RetryOpAttach)
+	require.Equal(t, 10, attach.MaxAttempts)
+	require.Equal(t, time.Millisecond, attach.Interval)
+	for i := 0; i < 10; i++ {
+		d := attach.Backoff(2)
+		require.True(t, d >= time.Millisecond && d <= 3*time.Millisecond, "%v", d)
+	}
+	require.Equal(t, p, p.For(RetryOpDetach))
+
+	var nilPolicy *RetryPolicy
+	require.Nil(t, nilPolicy.For(RetryOpAttach))
+
+	attempts := 0
+	_, err := p.Retry("wait for test", func() (interface{}, bool, error) {
+		attempts++
+		return nil, true, NewStorageError(ErrThrottled, "not yet", "")
+	})
+	require.Error(t, err)
+	require.Equal(t, 3, attempts)
+	require.True(t, IsErrorCode(err, ErrThrottled), "giving up must keep the code of the error")
+
+	require.Error(t, (&RetryPolicy{Jitter: 2}).Validate())
+}
+
+func TestTokenBucket(t *testing.T) {
+	b := NewTokenBucket(100, 2)
+	require.Equal(t, time.Duration(0), b.Reserve())
+	require.Equal(t, time.Duration(0), b.Reserve())
+	wait := b.Reserve()
+	require.True(t, wait > 0 && wait <= 10*time.Millisecond, "%v", wait)
+
+	b.Throttled()
+	require.Equal(t, float64(50), b.Rate())
+	for i := 0; i < 10; i++ {
+		b.Throttled()
+	}
+	require.Equal(t, 100.0/tokenBucketMinRateDivisor, b.Rate())
+	for i := 0; i < tokenBucketRecoverySteps+1; i++ {
+		b.Succeeded()
+	}
+	require.Equal(t, float64(100), b.Rate())
+}
+
+type fakeAttachOps struct {
+	fakeEnumerateOps
+	lock    sync.Mutex
+	running int
+	peak    int
+}
+
+func (f *fakeAttachOps) Attach(volumeID string, options map[string]string) (string, error) {
+	f.lock.Lock()
+	f.running++
+	if f.running > f.peak {
+		f.peak = f.running
+	}
+	f.lock.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	f.lock.Lock()
+	f.running--
+	f.lock.Unlock()
+	if volumeID == "vol-bad" {
+		return "", fmt.Errorf("attach of %s failed", volumeID)
+	}
+	return "/dev/" + volumeID, nil
+}
+
+func TestAttachBatch(t *testing.T) {
+	ops := &fakeAttachOps{}
+	var reqs []*AttachRequest
+	for _, id := range []string{"vol-1", "vol-2", "vol-bad", "vol-3", "vol-4"} {
+		reqs = append(reqs, &AttachRequest{VolumeID: id})
+	}
+
+	results := AttachBatch(ops, reqs, 2)
+	require.Len(t, results, len(reqs))
+	for i, r := range results {
+		require.Equal(t, reqs[i].VolumeID, r.VolumeID)
+		if r.VolumeID == "vol-bad" {
+			require.Error(t, r.Err)
+			continue
+		}
+		require.NoError(t, r.Err)
+		require.Equal(t, "/dev/"+r.VolumeID, r.DevicePath)
+	}
+	require.Equal(t, 2, ops.peak)
+}
+
+func TestMetricsLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metricslog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "metrics.log")
+
+	l, err := OpenMetricsLog(path, 3, 0)
+	require.NoError(t, err)
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, l.Record(&MetricsRecord{
+			Time:      start.Add(time.Duration(i) * time.Minute),
+			Kind:      MetricsRecordOperation,
+			Provider:  "fake",
+			Operation: fmt.Sprintf("op-%d", i),
+		}))
+	}
+	records, err := l.Records(time.Time{})
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	require.Equal(t, "op-2", records[0].Operation)
+	require.Equal(t, "op-4", records[2].Operation)
+	require.NoError(t, l.Close())
+
+	// reopening keeps the size and records of the file
+	l, err = OpenMetricsLog(path, 100, 30*time.Minute)
+	require.NoError(t, err)
+	defer l.Close()
+	records, err = l.Records(time.Time{})
+	require.NoError(t, err)
+	require.Empty(t, records, "records should be past the retention")
+
+	ops := NewChainedOps(&fakeEnumerateOps{}, l.Middleware())
+	_, err = ops.Enumerate(nil, nil, "")
+	require.NoError(t, err)
+	require.NoError(t, l.PublishEvent(&Event{
+		Type:     EventAttach,
+		Provider: "fake",
+		VolumeID: "vol-1",
+		Time:     time.Now(),
+		Err:      strings.Repeat("x", 2*metricsLogSlotSize),
+	}))
+	records, err = l.Records(time.Time{})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, MetricsRecordOperation, records[0].Kind)
+	require.Equal(t, "Enumerate", records[0].Operation)
+	require.Equal(t, MetricsRecordEvent, records[1].Kind)
+	require.Equal(t, "vol-1", records[1].VolumeID)
+	require.True(t, strings.HasSuffix(records[1].Err, "..."), "long error should be truncated")
+}
+
+func TestAutoDetect(t *testing.T) {
+	detectorsLock.Lock()
+	registered := detectors
+	detectors = make(map[string]*Detector)
+	detectorsLock.Unlock()
+	defer func() {
+		detectorsLock.Lock()
+		detectors = registered
+		detectorsLock.Unlock()
+	}()
+
+	_, err := AutoDetect()
+	require.IsType(t, &ErrNoProviderDetected{}, err)
+
+	RegisterDetector("cloud-a", &Detector{
+		Probe: func() error { return fmt.Errorf("metadata service not reachable") },
+		New:   func() (Ops, error) { return nil, fmt.Errorf("should not be created") },
+	})
+	RegisterDetector("cloud-b", &Detector{
+		Probe: func() error { return fmt.Errorf("system vendor is %q", "QEMU") },
+		New:   func() (Ops, error) { return nil, fmt.Errorf("should not be created") },
+	})
+	_, err = AutoDetect()
+	notDetected, ok := err.(*ErrNoProviderDetected)
+	require.True(t, ok, "unexpected error %v", err)
+	require.Len(t, notDetected.Probes, 2)
+	require.Contains(t, err.Error(), "cloud-a: metadata service not reachable")
+	require.Contains(t, err.Error(), "cloud-b: system vendor")
+
+	RegisterDetector("cloud-c", &Detector{
+		Probe: func() error { return nil },
+		New:   func() (Ops, error) { return &fakeEnumerateOps{}, nil },
+	})
+	ops, err := AutoDetect()
+	require.NoError(t, err)
+	require.Equal(t, "fake", ops.Name())
+}
+
+func TestRegister(t *testing.T) {
+	_, err := Get("cloud-d")
+	require.True(t, errors.Is(err, ErrNotSupported))
+
+	var got map[string]string
+	Register("cloud-d", func(params map[string]string) (Ops, error) {
+		got = params
+		return &fakeEnumerateOps{}, nil
+	})
+	defer func() {
+		initFuncsLock.Lock()
+		delete(initFuncs, "cloud-d")
+		initFuncsLock.Unlock()
+	}()
+	require.Contains(t, Registered(), "cloud-d")
+
+	initFn, err := Get("cloud-d")
+	require.NoError(t, err)
+	ops, err := initFn(map[string]string{"endpoint": "http://localhost"})
+	require.NoError(t, err)
+	require.Equal(t, "fake", ops.Name())
+	require.Equal(t, map[string]string{"endpoint": "http://localhost"}, got)
+}
+
+func TestStorageErrorCodes(t *testing.T) {
+	cause := fmt.Errorf("RequestLimitExceeded: Request limit exceeded.")
+	err := fmt.Errorf("failed to attach volume vol-1: %w", WrapError(ErrThrottled, cause, "i-1"))
+
+	require.True(t, errors.Is(err, &StorageError{Code: ErrThrottled}))
+	require.False(t, errors.Is(err, &StorageError{Code: ErrQuotaExceeded}))
+	require.True(t, errors.Is(err, cause), "provider error should remain in the chain")
+	require.True(t, IsErrorCode(err, ErrThrottled))
+
+	var se *StorageError
+	require.True(t, errors.As(err, &se))
+	require.Equal(t, "i-1", se.Instance)
+	require.Equal(t, cause.Error(), se.Error())
+
+	_, ok := ErrorCode(cause)
+	require.False(t, ok)
+}
+
+func TestCatalog(t *testing.T) {
+	kv, err := kvdb.New(mem.Name, "catalog_test", []string{}, nil, logrus.Panicf)
+	require.NoError(t, err)
+	c := NewCatalog(kv, "fake")
+
+	start := time.Now().UTC()
+	e1, err := c.Record("snap-1", "vol-1", map[string]string{"app": "db"}, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), e1.Seq)
+	require.Empty(t, e1.PrevHash)
+
+	_, err = c.Record("snap-1", "vol-1", nil, 0)
+	require.True(t, IsErrorCode(err, ErrVolInval), "entries are write once")
+
+	require.NoError(t, c.RecordRestore("snap-1", "vol-2"))
+	e2, err := c.Record("snap-2", "vol-2", map[string]string{"app": "web"}, 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{"vol-1"}, e2.Lineage)
+	require.Equal(t, e1.Hash, e2.PrevHash)
+
+	entries, err := c.Query(&CatalogQuery{VolumeID: "vol-1"})
+	require.NoError(t, err)
+	require.Len(t, entries, 2, "snapshots of restored volumes are in the lineage")
+	entries, err = c.Query(&CatalogQuery{Labels: map[string]string{"app": "web"}})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "snap-2", entries[0].SnapshotID)
+	entries, err = c.Query(&CatalogQuery{CreatedBefore: start})
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	ops := NewCatalogOps(&fakeEnumerateOps{}, c, time.Hour)
+	err = ops.SnapshotDelete("snap-1")
+	require.True(t, IsErrorCode(err, ErrRetentionLocked))
+	require.NoError(t, c.CheckDelete("snap-2"))
+
+	require.NoError(t, c.Verify())
+	e1.RetainUntil = start
+	_, err = kv.Put(c.entryKey("snap-1"), e1, 0)
+	require.NoError(t, err)
+	require.Error(t, c.Verify(), "tampered entry must be detected")
+}
+
+type fakeRestoreOps struct {
+	fakeEnumerateOps
+	restored []string
+}
+
+func (f *fakeRestoreOps) SnapshotStatus(snapID string) (*SnapshotStatus, error) {
+	if snapID != "snap-1" {
+		return nil, NewStorageError(ErrVolNotFound, "snapshot not found", "")
+	}
+	return &SnapshotStatus{ID: snapID, Completed: true}, nil
+}
+
+func (f *fakeRestoreOps) SnapshotRestore(
+	snapID, zone string,
+	labels map[string]string,
+) (*Volume, error) {
+	f.restored = append(f.restored, snapID)
+	return &Volume{ID: "vol-restored"}, nil
+}
+
+func TestRestoreRequests(t *testing.T) {
+	kv, err := kvdb.New(mem.Name, "restore_test", []string{}, nil, logrus.Panicf)
+	require.NoError(t, err)
+	var notified []*RestoreRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &RestoreRequest{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(req))
+		notified = append(notified, req)
+	}))
+	defer server.Close()
+	ops := &fakeRestoreOps{}
+	r := NewRestoreRequests(kv, ops, NewWebhookApprover(server.URL, nil))
+
+	user := func(name string, roles ...string) context.Context {
+		return auth.ContextSaveUserInfo(context.Background(),
+			&auth.UserInfo{Username: name, Claims: auth.Claims{Roles: roles}})
+	}
+	dev := user("dev", "clouddrive.viewer")
+	admin := user("admin", "clouddrive.admin")
+
+	_, err = r.Request(dev, "snap-missing", "", nil, "oops")
+	require.True(t, IsErrorCode(err, ErrVolNotFound))
+	req, err := r.Request(dev, "snap-1", "zone-a", map[string]string{"app": "db"}, "lost a table")
+	require.NoError(t, err)
+	require.Equal(t, RestorePending, req.State)
+	require.Equal(t, "dev", req.Requester)
+	require.Len(t, notified, 1)
+	require.Equal(t, req.ID, notified[0].ID)
+
+	_, err = r.Approve(dev, req.ID, "")
+	require.True(t, IsErrorCode(err, ErrPermissionDenied), "viewers may not approve")
+	_, err = r.Approve(user("dev", "clouddrive.admin"), req.ID, "")
+	require.True(t, IsErrorCode(err, ErrPermissionDenied), "requesters may not approve")
+	require.Empty(t, ops.restored, "nothing runs before approval")
+
+	req, err = r.Approve(admin, req.ID, "ok")
+	require.NoError(t, err)
+	require.Equal(t, RestoreCompleted, req.State)
+	require.Equal(t, "vol-restored", req.VolumeID)
+	require.Equal(t, []string{"snap-1"}, ops.restored)
+	var actions []string
+	for _, e := range req.Audit {
+		actions = append(actions, e.User+":"+e.Action)
+	}
+	require.Equal(t, []string{"dev:requested", ":approval-requested", "admin:approved", ":completed"}, actions)
+	_, err = r.Approve(admin, req.ID, "")
+	require.True(t, IsErrorCode(err, ErrVolInval), "requests run once")
+
+	rejected, err := r.Request(dev, "snap-1", "", nil, "")
+	require.NoError(t, err)
+	_, err = r.Reject(admin, rejected.ID, "use yesterday's")
+	require.NoError(t, err)
+	reqs, err := r.List(RestoreRejected)
+	require.NoError(t, err)
+	require.Len(t, reqs, 1)
+	require.Equal(t, rejected.ID, reqs[0].ID)
+	reqs, err = r.List("")
+	require.NoError(t, err)
+	require.Len(t, reqs, 2)
+	require.Len(t, ops.restored, 1)
+}
+
+type fakeCheckpointOps struct {
+	Ops
+	mappings map[string]string
+}
+
+func (f *fakeCheckpointOps) Name() string { return "fake" }
+
+func (f *fakeCheckpointOps) DeviceMappings() (map[string]string, error) {
+	return f.mappings, nil
+}
+
+func (f *fakeCheckpointOps) DevicePath(volumeID string) (string, error) {
+	for path, id := range f.mappings {
+		if id == volumeID {
+			return path, nil
+		}
+	}
+	return "", NewStorageError(ErrVolDetached, "volume "+volumeID+" is detached", "")
+}
+
+func TestAttachCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "attachments.json")
+
+	ops := &fakeCheckpointOps{mappings: map[string]string{
+		"/dev/fake-xvdf": "vol-1",
+		"/dev/fake-xvdg": "vol-2",
+		"/dev/fake-xvdh": "vol-3",
+		"/dev/fake-xvdi": "vol-4",
+	}}
+	written, err := WriteAttachCheckpoint(ops, path)
+	require.NoError(t, err)
+	require.Len(t, written.Volumes, 4)
+
+	cp, err := ReadAttachCheckpoint(path)
+	require.NoError(t, err)
+	require.Equal(t, "vol-1", cp.Volumes[0].VolumeID)
+	cp.Volumes[3].Mounts = []string{"/mnt/data"}
+
+	ops.mappings = map[string]string{
+		"/dev/fake-xvdf":    "vol-1",
+		"/dev/fake-nvme1n1": "vol-2",
+		"/dev/fake-xvdi":    "vol-4",
+	}
+	report := VerifyAttachCheckpoint(ops, cp)
+	require.False(t, report.Rebooted)
+	require.False(t, report.Recovered())
+	require.Len(t, report.Volumes, 4)
+	require.Equal(t, VolumeRecovered, report.Volumes[0].Status)
+	require.Equal(t, VolumeMoved, report.Volumes[1].Status)
+	require.Equal(t, "/dev/fake-nvme1n1", report.Volumes[1].DevicePath)
+	require.Equal(t, VolumeMissing, report.Volumes[2].Status)
+	require.Equal(t, VolumeUnmounted, report.Volumes[3].Status)
+	require.Equal(t, []string{"/mnt/data"}, report.Volumes[3].MissingMounts)
+}
+
+func TestSmokeTestDevice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smoketest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	device := filepath.Join(dir, "device")
+	data := make([]byte, 4*smokeTestBlockSize)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	require.NoError(t, ioutil.WriteFile(device, data, 0600))
+
+	if err := SmokeTestDevice(device, SmokeTestRead, time.Second); err != nil {
+		t.Skipf("direct I/O not supported in %s: %v", dir, err)
+	}
+	require.NoError(t, SmokeTestDevice(device, SmokeTestWrite, time.Second))
+	after, err := ioutil.ReadFile(device)
+	require.NoError(t, err)
+	require.Equal(t, data, after, "write test must not change the device")
+
+	require.NoError(t, ioutil.WriteFile(device, data[:100], 0600))
+	err = SmokeTestDevice(device, SmokeTestRead, time.Second)
+	require.True(t, IsErrorCode(err, ErrDeviceIOFailed))
+	require.NoError(t, SmokeTestDevice(filepath.Join(dir, "missing"), SmokeTestSkip, time.Second))
+}
+
+func TestHTTPConfig(t *testing.T) {
+	client, err := (*HTTPConfig)(nil).Client()
+	require.NoError(t, err)
+	require.Equal(t, http.DefaultClient, client)
+
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		require.NotEmpty(t, r.Header.Get("Proxy-Authorization"))
+	}))
+	defer proxy.Close()
+	u, err := url.Parse(proxy.URL)
+	require.NoError(t, err)
+	u.User = url.UserPassword("user", "password")
+
+	cfg := &HTTPConfig{ProxyURL: u.String(), MaxConnsPerHost: 4, Timeout: time.Second}
+	client, err = cfg.Client()
+	require.NoError(t, err)
+	require.Equal(t, time.Second, client.Timeout)
+	resp, err := client.Get("http://ec2.us-east-1.amazonaws.com/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, []string{"http://ec2.us-east-1.amazonaws.com/"}, proxied)
+
+	transport := client.Transport.(*http.Transport)
+	require.Equal(t, 4, transport.MaxConnsPerHost)
+	req, err := http.NewRequest(http.MethodGet, "http://169.254.169.254/latest/meta-data", nil)
+	require.NoError(t, err)
+	proxyURL, err := transport.Proxy(req)
+	require.NoError(t, err)
+	require.Nil(t, proxyURL, "metadata service must not be proxied")
+
+	_, err = (&HTTPConfig{CABundle: "/nonexistent/ca.pem"}).Client()
+	require.Error(t, err)
+
+	custom := &http.Transport{}
+	client, err = (&HTTPConfig{Transport: custom}).Client()
+	require.NoError(t, err)
+	require.Equal(t, custom, client.Transport)
+}
+
+func TestSize(t *testing.T) {
+	for s, expected := range map[string]Size{
+		"500GB":     500 * 1000 * 1000 * 1000,
+		"500gib":    500 * GiB,
+		"500 Gi":    500 * GiB,
+		"500":       500 * GiB,
+		"1.5TiB":    1536 * GiB,
+		"0.1KB":     100,
+		"1.0000001": GiB + 108,
+		"2T":        2 * TB,
+	} {
+		size, err := ParseSize(s)
+		require.NoError(t, err, s)
+		require.Equal(t, expected, size, s)
+	}
+	for _, s := range []string{"", "GiB", "-1GiB", "1.GiB", "10XB", "20000000PiB"} {
+		_, err := ParseSize(s)
+		require.Error(t, err, s)
+	}
+
+	size, err := ParseSize("500GB")
+	require.NoError(t, err)
+	require.Equal(t, uint64(466), size.GiB())
+	require.Equal(t, "488281250KiB", size.String())
+	require.Equal(t, "466GiB", size.RoundUp(GiB).String())
+	require.Equal(t, "1536GiB", (1536 * GiB).String())
+	require.Equal(t, "100B", Size(100).String())
+
+	RegisterSizeRule("size-test", &SizeRule{Granularity: GiB, Min: 4 * GiB, Max: TiB})
+	rounded, err := RoundSize("size-test", size)
+	require.NoError(t, err)
+	require.Equal(t, 466*GiB, rounded)
+	_, err = RoundSize("size-test", GB)
+	require.True(t, IsErrorCode(err, ErrVolInval), "%v", err)
+	_, err = RoundSize("size-test", 2*TB)
+	require.True(t, IsErrorCode(err, ErrVolInval), "%v", err)
+	rounded, err = RoundSize("unregistered", MiB)
+	require.NoError(t, err)
+	require.Equal(t, GiB, rounded)
+
+	var spec struct {
+		Size Size `json:"size"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(`{"size":"500GB"}`), &spec))
+	require.Equal(t, size, spec.Size)
+	require.NoError(t, json.Unmarshal([]byte(`{"size":1024}`), &spec))
+	require.Equal(t, KiB, spec.Size)
+	require.Error(t, json.Unmarshal([]byte(`{"size":"big"}`), &spec))
+	spec.Size = 466 * GiB
+	data, err := json.Marshal(spec)
+	require.NoError(t, err)
+	require.Equal(t, `{"size":"466GiB"}`, string(data))
+
+	var flagSize Size
+	flags := flag.NewFlagSet("size", flag.ContinueOnError)
+	flags.Var(&flagSize, "size", "volume size")
+	require.NoError(t, flags.Parse([]string{"-size", "1TiB"}))
+	require.Equal(t, TiB, flagSize)
+}
+
+func TestDeviceQuarantine(t *testing.T) {
+	var waits []time.Duration
+	AddQuarantineObserver(func(provider string, wait time.Duration) {
+		if provider == "quarantine-test" {
+			waits = append(waits, wait)
+		}
+	})
+
+	q := InstanceDeviceQuarantine("quarantine-test-instance")
+	require.Equal(t, q, InstanceDeviceQuarantine("quarantine-test-instance"))
+	q.Quarantine("f", 50*time.Millisecond)
+	q.Quarantine("g", 0)
+
+	available, next := q.Filter([]string{"f", "g"})
+	require.Equal(t, []string{"g"}, available)
+	require.True(t, next.IsZero())
+	available, next = q.Filter([]string{"f"})
+	require.Empty(t, available)
+	require.False(t, next.IsZero())
+
+	_, err := q.Await("quarantine-test", []string{"f"}, time.Now())
+	require.True(t, IsErrorCode(err, ErrDeviceBusy), "%v", err)
+	require.Empty(t, waits)
+
+	start := time.Now()
+	available, err = q.Await("quarantine-test", []string{"f"}, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	require.Equal(t, []string{"f"}, available)
+	require.True(t, time.Since(start) >= 40*time.Millisecond)
+	require.Len(t, waits, 1)
+
+	available, err = q.Await("quarantine-test", []string{"f", "g"}, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, []string{"f", "g"}, available)
+	require.Len(t, waits, 1)
+}
+
+type fakeMappingsOps struct {
+	Ops
+	sync.Mutex
+	mappings map[string]string
+}
+
+func (f *fakeMappingsOps) InstanceID() string { return "i-1" }
+
+func (f *fakeMappingsOps) DeviceMappings() (map[string]string, error) {
+	f.Lock()
+	defer f.Unlock()
+	mappings := make(map[string]string, len(f.mappings))
+	for k, v := range f.mappings {
+		mappings[k] = v
+	}
+	return mappings, nil
+}
+
+func (f *fakeMappingsOps) set(mappings map[string]string) {
+	f.Lock()
+	defer f.Unlock()
+	f.mappings = mappings
+}
+
+func TestWatchDeviceMappings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "devwatch")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ops := &fakeMappingsOps{mappings: map[string]string{"/dev/nvme1n1": "vol-1"}}
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := WatchDeviceMappings(ctx, ops, &DeviceWatchOptions{
+		Interval:   time.Hour,
+		Settle:     10 * time.Millisecond,
+		DeviceDirs: []string{dir, filepath.Join(dir, "missing")},
+	})
+	require.NoError(t, err)
+
+	next := func() *DeviceMappingEvent {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "no device mapping event")
+		}
+		return nil
+	}
+	e := next()
+	require.Equal(t, DeviceAdded, e.Type)
+	require.Equal(t, "vol-1", e.VolumeID)
+	require.Equal(t, "/dev/nvme1n1", e.DevicePath)
+
+	// Renumbered after a reboot and a new volume, seen through the device
+	// directory well before the next poll
+	ops.set(map[string]string{"/dev/nvme2n1": "vol-1", "/dev/nvme1n1": "vol-2"})
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "nvme2n1"), nil, 0600))
+	e = next()
+	require.Equal(t, DeviceMoved, e.Type)
+	require.Equal(t, "vol-1", e.VolumeID)
+	require.Equal(t, "/dev/nvme2n1", e.DevicePath)
+	require.Equal(t, "/dev/nvme1n1", e.OldDevicePath)
+	e = next()
+	require.Equal(t, DeviceAdded, e.Type)
+	require.Equal(t, "vol-2", e.VolumeID)
+
+	ops.set(map[string]string{"/dev/nvme2n1": "vol-1"})
+	require.NoError(t, os.Remove(filepath.Join(dir, "nvme2n1")))
+	e = next()
+	require.Equal(t, DeviceRemoved, e.Type)
+	require.Equal(t, "vol-2", e.VolumeID)
+	require.Equal(t, "/dev/nvme1n1", e.DevicePath)
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestConfigBundle(t *testing.T) {
+	kv, err := kvdb.New(mem.Name, "bundle_test", []string{}, nil, logrus.Panicf)
+	require.NoError(t, err)
+	require.NoError(t, MigrateState(kv))
+	ops := &fakeSpecOps{specs: map[string]*DesiredSpec{"vol-1": {Tags: map[string]string{}}}}
+	require.NoError(t, NewDriftDetector(ops, ops, kv).SetDesired("vol-1",
+		&DesiredSpec{Type: "gp3", Tags: map[string]string{"owner": "ops"}}))
+	require.NoError(t, NewAliasOps(ops, kv).SetAlias("vol-1", "db-data"))
+	// history is never exported
+	_, err = NewCatalog(kv, "fake").Record("snap-1", "vol-1", nil, 0)
+	require.NoError(t, err)
+
+	key := []byte("bundle-key")
+	_, err = ExportConfig(kv, nil)
+	require.True(t, IsErrorCode(err, ErrVolInval), "%v", err)
+	_, err = ExportConfig(kv, key, "catalog")
+	require.True(t, IsErrorCode(err, ErrVolInval), "%v", err)
+	bundle, err := ExportConfig(kv, key)
+	require.NoError(t, err)
+	require.Equal(t, 1, bundle.Schema)
+	require.Len(t, bundle.Sections, 2)
+	require.Equal(t, "vol-1", bundle.Sections["aliases"]["fake/db-data"])
+	require.Contains(t, bundle.Sections["specs"]["fake/vol-1"], `"gp3"`)
+
+	data, err := json.Marshal(bundle)
+	require.NoError(t, err)
+	decoded := &ConfigBundle{}
+	require.NoError(t, json.Unmarshal(data, decoded))
+	require.NoError(t, decoded.Verify(key))
+	require.True(t, IsErrorCode(decoded.Verify([]byte("other")), ErrPermissionDenied))
+	tampered := *decoded
+	tampered.Sections = map[string]map[string]string{"aliases": {"fake/db-data": "vol-evil"}}
+	require.True(t, IsErrorCode(tampered.Verify(key), ErrPermissionDenied))
+
+	dr, err := kvdb.New(mem.Name, "bundle_dr_test", []string{}, nil, logrus.Panicf)
+	require.NoError(t, err)
+	_, err = ImportConfig(dr, decoded, key, nil)
+	require.True(t, IsErrorCode(err, ErrVolInval), "unmigrated state: %v", err)
+	require.NoError(t, MigrateState(dr))
+	_, err = ImportConfig(dr, &tampered, key, nil)
+	require.True(t, IsErrorCode(err, ErrPermissionDenied), "%v", err)
+
+	report, err := ImportConfig(dr, decoded, key, &ImportOptions{
+		VolumeIDs: map[string]string{"vol-1": "vol-9"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{aliasKeyPrefix + "fake/db-data", driftKeyPrefix + "fake/vol-9"}, report.Imported)
+	volumeID, err := NewAliasOps(ops, dr).Resolve(AliasPrefix + "db-data")
+	require.NoError(t, err)
+	require.Equal(t, "vol-9", volumeID)
+	kvp, err := dr.Get(driftKeyPrefix + "fake/vol-9")
+	require.NoError(t, err)
+	spec := &DesiredSpec{}
+	require.NoError(t, json.Unmarshal(kvp.Value, spec))
+	require.Equal(t, "ops", spec.Tags["owner"])
+	_, err = dr.Get(catalogKeyPrefix + "fake/head")
+	require.Equal(t, kvdb.ErrNotFound, err)
+
+	report, err = ImportConfig(dr, decoded, key, &ImportOptions{
+		VolumeIDs: map[string]string{"vol-1": "vol-9"},
+	})
+	require.NoError(t, err)
+	require.Empty(t, report.Imported)
+	require.Len(t, report.Skipped, 2)
+	report, err = ImportConfig(dr, decoded, key, &ImportOptions{Overwrite: true})
+	require.NoError(t, err)
+	require.Len(t, report.Imported, 2)
+	volumeID, err = NewAliasOps(ops, dr).Resolve(AliasPrefix + "db-data")
+	require.NoError(t, err)
+	require.Equal(t, "vol-1", volumeID)
+}
+
+type fakeTagOps struct {
+	Ops
+	tags     map[string]map[string]string
+	detached []string
+}
+
+func (f *fakeTagOps) Name() string { return "fake" }
+
+func (f *fakeTagOps) ApplyTags(volumeID string, labels map[string]string) error {
+	tags, ok := f.tags[volumeID]
+	if !ok {
+		return NewStorageError(ErrVolNotFound, volumeID, "")
+	}
+	for k, v := range labels {
+		tags[k] = v
+	}
+	return nil
+}
+
+func (f *fakeTagOps) Tags(volumeID string) (map[string]string, error) {
+	tags, ok := f.tags[volumeID]
+	if !ok {
+		return nil, NewStorageError(ErrVolNotFound, volumeID, "")
+	}
+	return tags, nil
+}
+
+func (f *fakeTagOps) RemoveTags(volumeID string, labels map[string]string) error {
+	for k := range labels {
+		delete(f.tags[volumeID], k)
+	}
+	return nil
+}
+
+func (f *fakeTagOps) Detach(volumeID string) error {
+	f.detached = append(f.detached, volumeID)
+	return nil
+}
+
+func (f *fakeTagOps) Delete(volumeID string) error {
+	delete(f.tags, volumeID)
+	return nil
+}
+
+func TestAliasOps(t *testing.T) {
+	kv, err := kvdb.New(mem.Name, "alias_test", []string{}, nil, logrus.Panicf)
+	require.NoError(t, err)
+	ops := &fakeTagOps{tags: map[string]map[string]string{
+		"vol-1": {}, "vol-2": {}, "db-data": {},
+	}}
+	a := NewAliasOps(ops, kv)
+
+	require.True(t, IsErrorCode(a.SetAlias("vol-1", "Not Valid"), ErrVolInval))
+	require.NoError(t, a.SetAlias("vol-1", "db-data"))
+	require.NoError(t, a.SetAlias("vol-1", AliasPrefix+"db-data"), "setting the same alias is a no-op")
+	require.Equal(t, "db-data", ops.tags["vol-1"][AliasLabel])
+	err = a.SetAlias("vol-2", "db-data")
+	require.True(t, IsErrorCode(err, ErrVolInval), "aliases must be unique: %v", err)
+
+	id, err := a.Resolve(AliasPrefix + "db-data")
+	require.NoError(t, err)
+	require.Equal(t, "vol-1", id)
+	id, err = a.Resolve("db-data")
+	require.NoError(t, err)
+	require.Equal(t, "db-data", id, "IDs that are valid alias names must not resolve to the alias")
+	_, err = a.Resolve(AliasPrefix + "missing")
+	require.True(t, IsErrorCode(err, ErrVolNotFound))
+	_, err = a.Resolve(AliasPrefix + "Not Valid")
+	require.True(t, IsErrorCode(err, ErrVolNotFound))
+
+	require.NoError(t, a.Detach(AliasPrefix+"db-data"))
+	require.NoError(t, a.Detach("db-data"))
+	require.Equal(t, []string{"vol-1", "db-data"}, ops.detached)
+
+	// re-aliasing frees the previous alias
+	require.NoError(t, a.SetAlias(AliasPrefix+"db-data", "db-primary"))
+	require.Equal(t, "db-primary", ops.tags["vol-1"][AliasLabel])
+	_, err = a.Resolve(AliasPrefix + "db-data")
+	require.True(t, IsErrorCode(err, ErrVolNotFound))
+	require.NoError(t, a.SetAlias("vol-2", "db-data"))
+	id, err = a.Resolve(AliasPrefix + "db-data")
+	require.NoError(t, err)
+	require.Equal(t, "vol-2", id)
+
+	require.NoError(t, a.RemoveAlias(AliasPrefix+"db-data"))
+	require.NotContains(t, ops.tags["vol-2"], AliasLabel)
+	require.True(t, IsErrorCode(a.RemoveAlias("db-data"), ErrVolNotFound))
+
+	require.NoError(t, a.Delete(AliasPrefix+"db-primary"))
+	require.NotContains(t, ops.tags, "vol-1")
+	_, err = a.Resolve(AliasPrefix + "db-primary")
+	require.True(t, IsErrorCode(err, ErrVolNotFound), "aliases of deleted volumes must be dropped")
+}
+
+func TestReserveDevice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reservations")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	r, err := ReserveDevice(dir, "i-1", "f")
+	require.NoError(t, err)
+	_, err = ReserveDevice(dir, "i-1", "f")
+	require.True(t, IsErrorCode(err, ErrDeviceBusy), "expected a busy device, got %v", err)
+
+	// Other devices and instances are reserved independently
+	other, err := ReserveDevice(dir, "i-1", "g")
+	require.NoError(t, err)
+	other.Release()
+	other, err = ReserveDevice(dir, "i-2", "f")
+	require.NoError(t, err)
+	other.Release()
+
+	r.Release()
+	r.Release()
+	r, err = ReserveDevice(dir, "i-1", "f")
+	require.NoError(t, err)
+	r.Release()
+	(*DeviceReservation)(nil).Release()
+}
+
+func TestProviderLimitsValidate(t *testing.T) {
+	limits := &ProviderLimits{
+		MaxAttachments:             8,
+		MaxVolumeSizeGiB:           1024,
+		MaxInstanceIops:            10000,
+		MaxInstanceThroughputMiBps: 500,
+		VolumeTypes: map[string]VolumeTypeLimits{
+			"fast": {MinSizeGiB: 10, MaxSizeGiB: 512, MaxIops: 4000, MaxThroughputMiBps: 250},
+			"slow": {MinSizeGiB: 100},
+		},
+	}
+	require.NoError(t, limits.Validate(1,
+		&DriveSetSpec{Count: 2, Type: "fast", SizeGiB: 100, Iops: 3000, ThroughputMiBps: 200},
+		&DriveSetSpec{Count: 4, Type: "slow", SizeGiB: 1000}))
+
+	err := limits.Validate(2,
+		&DriveSetSpec{Count: 3, Type: "fast", SizeGiB: 5, Iops: 5000},
+		&DriveSetSpec{Count: 4, Type: "slow", SizeGiB: 2000},
+		&DriveSetSpec{Count: 1, Type: "other", SizeGiB: 10})
+	require.True(t, IsErrorCode(err, ErrVolInval), "%v", err)
+	for _, reason := range []string{
+		"below the minimum size of 10 GiB",
+		"5000 IOPS exceed the maximum of 4000 IOPS of fast volumes",
+		"2000 GiB volumes exceed the maximum size of 1024 GiB",
+		`unknown volume type "other"`,
+		"10 volumes exceed the 8 attachments",
+		"15000 provisioned IOPS exceed the 10000 IOPS",
+	} {
+		require.Contains(t, err.Error(), reason)
+	}
+
+	require.NoError(t, (&ProviderLimits{}).Validate(100, &DriveSetSpec{Count: 100, Type: "any", SizeGiB: 1 << 20}))
+}
+
+// fakeShadowOps inspects the given volumes, blocking until release is closed
+// if set
+type fakeShadowOps struct {
+	fakeEnumerateOps
+	inspected []*Volume
+	release   chan struct{}
+}
+
+func (f *fakeShadowOps) Inspect(volumeIds []*string) ([]*Volume, error) {
+	if f.release != nil {
+		<-f.release
+	}
+	return f.inspected, nil
+}
+
+// waitShadow waits for the running comparisons of the given ops by taking
+// every slot
+func waitShadow(ops *shadowOps) {
+	for i := 0; i < cap(ops.running); i++ {
+		ops.running <- struct{}{}
+	}
+	for i := 0; i < cap(ops.running); i++ {
+		<-ops.running
+	}
+}
+
+func TestShadowOps(t *testing.T) {
+	primary := &fakeShadowOps{inspected: []*Volume{
+		{ID: "vol-1", SizeGiB: 10, Labels: map[string]string{"app": "db"}, Raw: "primary"},
+		{ID: "vol-2", SizeGiB: 20, AttachedTo: []string{"i-1", "i-2"}},
+	}}
+	shadow := &fakeShadowOps{inspected: []*Volume{
+		{ID: "vol-2", SizeGiB: 20, AttachedTo: []string{"i-2", "i-1"}},
+		{ID: "vol-1", SizeGiB: 10, Labels: map[string]string{"app": "db"}, Raw: "shadow"},
+	}, release: make(chan struct{})}
+	ops := NewShadowOps(primary, shadow, 1).(*shadowOps)
+	var reports [][]string
+	ops.report = func(op string, arg interface{}, diffs []string) { reports = append(reports, diffs) }
+
+	// the first comparison blocks on the shadow, the second one is dropped
+	for i := 0; i < 2; i++ {
+		vols, err := ops.Inspect(nil)
+		require.NoError(t, err)
+		require.Len(t, vols, 2)
+	}
+	require.Equal(t, uint64(1), atomic.LoadUint64(&ops.dropped))
+	close(shadow.release)
+	waitShadow(ops)
+	require.Empty(t, reports, "order, attachment order and Raw must not differ")
+
+	shadow.inspected = []*Volume{
+		{ID: "vol-1", SizeGiB: 15, Labels: map[string]string{"app": "web"}},
+		{ID: "vol-3", SizeGiB: 20},
+	}
+	_, err := ops.Inspect(nil)
+	require.NoError(t, err)
+	waitShadow(ops)
+	require.Len(t, reports, 1)
+	require.Contains(t, reports[0], "vol-1.SizeGiB: primary=10 shadow=15")
+	require.Contains(t, reports[0], "vol-1.Labels.app: primary=db shadow=web")
+	require.Contains(t, reports[0], "vol-2.SizeGiB: primary=20 shadow=<none>")
+	require.Contains(t, reports[0], "vol-3.SizeGiB: primary=<none> shadow=20")
+
+	require.Empty(t, shadowDiff(map[string]string{"/dev/xvdf": "vol-1"}, map[string]string{"/dev/xvdf": "vol-1"}))
+	require.Equal(t, []string{"/dev/xvdg: primary=<none> shadow=present"},
+		shadowDiff([]string{"/dev/xvdf"}, []string{"/dev/xvdg", "/dev/xvdf"}))
+	require.Equal(t, []string{"snap-1.SizeGiB: primary=10 shadow=20"},
+		shadowDiff([]*Snapshot{{ID: "snap-1", VolumeID: "vol-1", SizeGiB: 10}},
+			[]*Snapshot{{ID: "snap-1", VolumeID: "vol-1", SizeGiB: 20}}))
+}
+
+func TestBaseDevice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "basedevice")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, tc := range []struct {
+		name, base string
+	}{
+		{"nvme1n1p1", "nvme1n1"},
+		{"nvme0n1", "nvme0n1"},
+		{"nvme10n2p12", "nvme10n2"},
+		{"sda1", "sda"},
+		{"sdaa12", "sdaa"},
+		{"xvdf2", "xvdf"},
+		{"vdb3", "vdb"},
+		{"sdb", "sdb"},
+		{"loop0", "loop0"},
+		{"md0", "md0"},
+		{"nbd1", "nbd1"},
+		{"dm-0", "dm-0"},
+	} {
+		path := filepath.Join(dir, tc.name)
+		require.NoError(t, ioutil.WriteFile(path, nil, 0600))
+		base, err := BaseDevice(path)
+		require.NoError(t, err)
+		require.Equal(t, filepath.Join(dir, tc.base), base, tc.name)
+	}
+}
+
+func TestDeviceMounts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mountinfo")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	self := filepath.Join(dir, "self")
+	init := filepath.Join(dir, "init")
+	require.NoError(t, ioutil.WriteFile(self, []byte(
+		"36 35 202:81 / /mnt/data rw,noatime master:1 - ext4 /dev/xvdf1 rw\n"+
+			"37 35 202:0 / / rw - ext4 /dev/xvda rw\n"), 0600))
+	require.NoError(t, ioutil.WriteFile(init, []byte(
+		"40 35 202:81 / /mnt/data rw,noatime master:1 - ext4 /dev/xvdf1 rw\n"+
+			"41 35 202:80 / /host/raw rw - xfs /dev/xvdf rw\n"), 0600))
+	paths := mountInfoPaths
+	defer func() { mountInfoPaths = paths }()
+	devs := map[uint64]string{1: "202:80", 2: "202:81"}
+
+	mountInfoPaths = []string{self, filepath.Join(dir, "missing"), init}
+	mounts, err := deviceMounts(devs)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/mnt/data", "/host/raw"}, mounts,
+		"mounts of PID 1 must be found and duplicates dropped")
+
+	mountInfoPaths = []string{filepath.Join(dir, "missing"), init}
+	_, err = deviceMounts(devs)
+	require.Error(t, err, "the mountinfo of this process must be readable")
+}
+
+func TestCheckDeviceBusy(t *testing.T) {
+	usage, interval := deviceUsage, deviceReleaseInterval
+	defer func() { deviceUsage, deviceReleaseInterval = usage, interval }()
+	deviceReleaseInterval = time.Millisecond
+	SetRetryLogging(false, time.Millisecond)
+	defer SetRetryLogging(false, DefaultRetryProgressInterval)
+
+	// busyFor returns a device usage that is busy for the given number of
+	// checks
+	calls := 0
+	busyFor := func(checks int) func(string) (*DeviceUsage, error) {
+		calls = 0
+		return func(string) (*DeviceUsage, error) {
+			calls++
+			if calls <= checks {
+				return &DeviceUsage{Mounts: []string{"/mnt/data"}, Pids: []int{42}}, nil
+			}
+			return &DeviceUsage{}, nil
+		}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		policy  BusyDevicePolicy
+		checks  int
+		force   bool
+		code    int
+		calls   int
+		timeout time.Duration
+	}{
+		{name: "ignore", policy: BusyDeviceIgnore, checks: 100, calls: 0},
+		{name: "fail idle", policy: BusyDeviceFail, checks: 0, calls: 1},
+		{name: "fail busy", policy: BusyDeviceFail, checks: 100, code: ErrDeviceBusy, calls: 1},
+		{name: "wait released", policy: BusyDeviceWait, checks: 3, calls: 4, timeout: time.Minute},
+		{name: "wait busy", policy: BusyDeviceWait, checks: 1 << 30, code: ErrDeviceBusy,
+			timeout: 20 * time.Millisecond},
+		{name: "force released", policy: BusyDeviceForce, checks: 2, calls: 3, timeout: time.Minute},
+		{name: "force busy", policy: BusyDeviceForce, checks: 1 << 30, force: true,
+			timeout: 20 * time.Millisecond},
+	} {
+		deviceUsage = busyFor(tc.checks)
+		force, err := CheckDeviceBusy("/dev/xvdf", tc.policy, tc.timeout)
+		require.Equal(t, tc.force, force, tc.name)
+		if tc.code != 0 {
+			require.True(t, IsErrorCode(err, tc.code), "%s: %v", tc.name, err)
+			require.Contains(t, err.Error(), "/mnt/data", tc.name)
+		} else {
+			require.NoError(t, err, tc.name)
+		}
+		if tc.calls > 0 || tc.policy == BusyDeviceIgnore {
+			require.Equal(t, tc.calls, calls, tc.name)
+		}
+	}
+}
+
+type fakeBackupOps struct {
+	fakeCheckpointOps
+	sync.Mutex
+	labeled map[string]map[string]string
+}
+
+func (f *fakeBackupOps) SnapshotWithLabels(
+	volumeID string,
+	readonly bool,
+	labels map[string]string,
+) (*Snapshot, error) {
+	f.Lock()
+	defer f.Unlock()
+	id := "snap-" + volumeID
+	f.labeled[id] = labels
+	return &Snapshot{ID: id, VolumeID: volumeID}, nil
+}
+
+func TestBackupNodeSkipsRootDevice(t *testing.T) {
+	usage := deviceUsage
+	defer func() { deviceUsage = usage }()
+	deviceUsage = func(devicePath string) (*DeviceUsage, error) {
+		switch devicePath {
+		case "/dev/fake-xvda":
+			return &DeviceUsage{Mounts: []string{"/boot", "/"}}, nil
+		case "/dev/fake-xvdf":
+			return &DeviceUsage{Mounts: []string{"/mnt/data"}}, nil
+		}
+		return nil, fmt.Errorf("no such device %s", devicePath)
+	}
+
+	ops := &fakeBackupOps{
+		fakeCheckpointOps: fakeCheckpointOps{mappings: map[string]string{
+			"/dev/fake-xvda": "vol-root",
+			"/dev/fake-xvdf": "vol-1",
+			"/dev/fake-xvdg": "vol-2",
+		}},
+		labeled: make(map[string]map[string]string),
+	}
+	job, err := BackupNode(ops, nil)
+	require.NoError(t, err)
+	require.NoError(t, job.Wait())
+
+	status := job.Status()
+	require.Len(t, status, 2)
+	for _, v := range status {
+		require.NotEqual(t, "vol-root", v.VolumeID)
+		require.Equal(t, BackupCompleted, v.State)
+		require.Equal(t, map[string]string{BackupSetLabel: job.ID}, ops.labeled[v.SnapshotID],
+			"the snapshot must be labeled when it is created")
+	}
+}
+
+// fakeBlkid returns a blkidCommand running TestBlkidHelperProcess, which
+// prints the given output and exits with the given code
+func fakeBlkid(output string, code int) func(string, ...string) *exec.Cmd {
+	return func(name string, args ...string) *exec.Cmd {
+		cmd := exec.Command(os.Args[0], append([]string{"-test.run=TestBlkidHelperProcess", "--", name}, args...)...)
+		cmd.Env = append(os.Environ(), "BLKID_HELPER_PROCESS=1",
+			"BLKID_OUTPUT="+output, "BLKID_EXIT_CODE="+strconv.Itoa(code))
+		return cmd
+	}
+}
+
+// TestBlkidHelperProcess is not a test, it is the blkid run by fakeBlkid
+func TestBlkidHelperProcess(t *testing.T) {
+	if os.Getenv("BLKID_HELPER_PROCESS") != "1" {
+		return
+	}
+	fmt.Print(os.Getenv("BLKID_OUTPUT"))
+	code, _ := strconv.Atoi(os.Getenv("BLKID_EXIT_CODE"))
+	os.Exit(code)
+}
+
+func TestFsSignature(t *testing.T) {
+	command := blkidCommand
+	defer func() { blkidCommand = command }()
+
+	blkidCommand = fakeBlkid("DEVNAME=/dev/xvdf\nUUID=1234-abcd\nLABEL=data\nTYPE=ext4\n", 0)
+	sig, err := GetFsSignature("/dev/xvdf")
+	require.NoError(t, err)
+	require.Equal(t, &FsSignature{UUID: "1234-abcd", Label: "data", Type: "ext4"}, sig)
+
+	// blkid exits with 2 if the device has no signature
+	blkidCommand = fakeBlkid("", 2)
+	sig, err = GetFsSignature("/dev/xvdf")
+	require.NoError(t, err)
+	require.Equal(t, &FsSignature{}, sig)
+
+	// other exit codes, e.g. 4 for usage errors, and failing to run blkid
+	// are errors
+	blkidCommand = fakeBlkid("", 4)
+	_, err = GetFsSignature("/dev/xvdf")
+	require.Error(t, err)
+	blkidCommand = func(string, ...string) *exec.Cmd { return exec.Command("/nonexistent/blkid") }
+	_, err = GetFsSignature("/dev/xvdf")
+	require.Error(t, err)
+
+	ops := &fakeTagOps{tags: map[string]map[string]string{"vol-1": {}, "vol-2": {}}}
+	blkidCommand = fakeBlkid("", 2)
+	require.True(t, IsErrorCode(RecordFsSignature(ops, "vol-1", "/dev/xvdf"), ErrVolInval),
+		"devices without a filesystem have no signature to record")
+	require.NoError(t, VerifyFsSignature(ops, "vol-1", "/dev/xvdf"),
+		"volumes without a recorded signature must pass")
+
+	blkidCommand = fakeBlkid("UUID=1234-abcd\nLABEL=data\nTYPE=ext4\n", 0)
+	require.NoError(t, RecordFsSignature(ops, "vol-1", "/dev/xvdf"))
+	require.Equal(t, map[string]string{FsUUIDLabel: "1234-abcd", FsLabelLabel: "data"}, ops.tags["vol-1"])
+	require.NoError(t, VerifyFsSignature(ops, "vol-1", "/dev/xvdf"))
+
+	blkidCommand = fakeBlkid("UUID=5678-ef01\nTYPE=ext4\n", 0)
+	err = VerifyFsSignature(ops, "vol-1", "/dev/xvdf")
+	require.True(t, IsErrorCode(err, ErrFsSignatureMismatch), "%v", err)
+	blkidCommand = fakeBlkid("", 4)
+	err = VerifyFsSignature(ops, "vol-1", "/dev/xvdf")
+	require.Error(t, err)
+	require.False(t, IsErrorCode(err, ErrFsSignatureMismatch), "blkid errors are not mismatches")
+
+	// GCE lowercases label values
+	gce := &lowercaseTagOps{fakeTagOps: ops}
+	blkidCommand = fakeBlkid("UUID=5678-EF01\nLABEL=Data\nTYPE=xfs\n", 0)
+	require.NoError(t, RecordFsSignature(gce, "vol-2", "/dev/xvdg"))
+	require.Equal(t, map[string]string{FsUUIDLabel: "5678-ef01", FsLabelLabel: "data"}, ops.tags["vol-2"])
+	require.NoError(t, VerifyFsSignature(gce, "vol-2", "/dev/xvdg"))
+	blkidCommand = fakeBlkid("UUID=5678-EF01\nLABEL=Logs\nTYPE=xfs\n", 0)
+	err = VerifyFsSignature(gce, "vol-2", "/dev/xvdg")
+	require.True(t, IsErrorCode(err, ErrFsSignatureMismatch), "%v", err)
+}
+
+// lowercaseTagOps lowercases tag values like GCE does for labels
+type lowercaseTagOps struct {
+	*fakeTagOps
+}
+
+func (f *lowercaseTagOps) ApplyTags(volumeID string, labels map[string]string) error {
+	lower := make(map[string]string, len(labels))
+	for k, v := range labels {
+		lower[k] = strings.ToLower(v)
+	}
+	return f.fakeTagOps.ApplyTags(volumeID, lower)
+}
+
+func TestProbeHost(t *testing.T) {
+	defer func(release func() string, look func(string) (string, error),
+		command func(string, ...string) *exec.Cmd, stat func(string) (os.FileInfo, error)) {
+		kernelRelease, lookPath, hostCommand, statPath = release, look, command, stat
+	}(kernelRelease, lookPath, hostCommand, statPath)
+
+	kernelRelease = func() string { return "5.10.0-23-cloud-amd64" }
+	lookPath = func(file string) (string, error) { return "/usr/sbin/" + file, nil }
+	hostCommand = fakeBlkid("nvme version 1.12\n", 0)
+	statPath = func(path string) (os.FileInfo, error) {
+		if path == "/run/udev/control" {
+			return nil, os.ErrNotExist
+		}
+		return nil, nil
+	}
+	h := ProbeHost()
+	require.Equal(t, &HostCapabilities{
+		KernelVersion:  "5.10.0-23-cloud-amd64",
+		NvmeCli:        "/usr/sbin/nvme",
+		NvmeCliVersion: "1.12",
+		DiskByID:       true,
+		SysBlock:       true,
+	}, h)
+	require.True(t, h.KernelAtLeast(4, 4))
+	require.True(t, h.KernelAtLeast(5, 10))
+	require.False(t, h.KernelAtLeast(5, 11))
+
+	kernelRelease = func() string { return "" }
+	lookPath = func(file string) (string, error) { return "", exec.ErrNotFound }
+	statPath = func(path string) (os.FileInfo, error) { return nil, os.ErrNotExist }
+	h = ProbeHost()
+	require.Equal(t, &HostCapabilities{}, h)
+	require.False(t, h.KernelAtLeast(4, 4), "unknown kernels are not recent enough")
+}
@@ -0,0 +1,52 @@
+package storageops
+
+import "sort"
+
+// StreamingEnumerator is implemented by drivers that can enumerate volumes a
+// page at a time, so the volumes of very large accounts are never all held
+// in memory at once
+type StreamingEnumerator interface {
+	// EnumerateEach calls fn with every volume matching the filters of
+	// Enumerate and the set it belongs to, fetching the volumes a page at a
+	// time. Enumeration stops when fn returns false.
+	EnumerateEach(
+		volumeIds []*string,
+		labels map[string]string,
+		setIdentifier string,
+		fn func(set string, vol *Volume) bool,
+	) error
+}
+
+// EnumerateEach calls fn with every volume matching the given filters and the
+// set it belongs to until fn returns false. It uses the driver's
+// StreamingEnumerator if it has one, or else the result of Enumerate, which
+// holds all volumes in memory. Wrappers like middlewares hide the
+// StreamingEnumerator of the driver they wrap, see AttachBatch.
+func EnumerateEach(
+	ops Ops,
+	volumeIds []*string,
+	labels map[string]string,
+	setIdentifier string,
+	fn func(set string, vol *Volume) bool,
+) error {
+	if s, ok := ops.(StreamingEnumerator); ok {
+		return s.EnumerateEach(volumeIds, labels, setIdentifier, fn)
+	}
+	sets, err := ops.Enumerate(volumeIds, labels, setIdentifier)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(sets))
+	for name := range sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, vol := range sets[name] {
+			if !fn(name, vol) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
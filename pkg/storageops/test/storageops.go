@@ -1,7 +1,10 @@
 package test
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"strings"
 	"testing"
 	"time"
@@ -17,28 +20,96 @@ var diskLabels = map[string]string{
 	"Test":   "UPPER_CASE",
 }
 
+// ConformanceOutputEnv is the env variable with the path of the file to which
+// RunTest writes the feature matrix as JSON
+const ConformanceOutputEnv = "STORAGEOPS_CONFORMANCE_OUTPUT"
+
 func RunTest(
 	drivers map[string]storageops.Ops,
 	diskTemplates map[string]map[string]interface{},
 	t *testing.T) {
+	matrix := RunConformance(drivers, diskTemplates, t)
+
+	if path := os.Getenv(ConformanceOutputEnv); len(path) > 0 {
+		out, err := json.MarshalIndent(matrix, "", "  ")
+		require.NoError(t, err, "failed to marshal feature matrix")
+		err = ioutil.WriteFile(path, out, 0644)
+		require.NoError(t, err, "failed to write feature matrix")
+	}
+}
+
+// RunConformance runs the conformance suite against the given drivers and
+// returns the status of each feature of each driver
+func RunConformance(
+	drivers map[string]storageops.Ops,
+	diskTemplates map[string]map[string]interface{},
+	t *testing.T) storageops.FeatureMatrix {
+	matrix := make(storageops.FeatureMatrix)
 	for _, d := range drivers {
 		name(t, d)
 
+		// run runs a feature as a subtest and records its status. fn returns
+		// false if the feature is not supported by the driver.
+		run := func(f storageops.Feature, fn func(t *testing.T) bool) bool {
+			supported := true
+			passed := t.Run(fmt.Sprintf("%s/%s", d.Name(), f), func(t *testing.T) {
+				supported = fn(t)
+			})
+			switch {
+			case !passed:
+				matrix.Set(d.Name(), f, storageops.FeatureFailed)
+			case !supported:
+				matrix.Set(d.Name(), f, storageops.FeatureNotSupported)
+			default:
+				matrix.Set(d.Name(), f, storageops.FeatureSupported)
+			}
+			return passed
+		}
+
 		for _, template := range diskTemplates[d.Name()] {
-			disk := create(t, d, template)
-			fmt.Printf("Created disk: %v\n", disk)
-			diskID := id(t, d, disk)
-			require.NotEmpty(t, diskID, "disk ID should not be empty")
-			snapshot(t, d, diskID)
-			tags(t, d, diskID)
-			enumerate(t, d, diskID)
-			inspect(t, d, diskID)
-			attach(t, d, diskID)
-			devicePath(t, d, diskID)
-			teardown(t, d, diskID)
-			fmt.Printf("Tore down disk: %v\n", disk)
+			var diskID string
+			if !run(storageops.FeatureCreate, func(t *testing.T) bool {
+				disk := create(t, d, template)
+				fmt.Printf("Created disk: %v\n", disk)
+				diskID = id(t, d, disk)
+				require.NotEmpty(t, diskID, "disk ID should not be empty")
+				return true
+			}) {
+				continue
+			}
+			run(storageops.FeatureSnapshot, func(t *testing.T) bool {
+				return snapshot(t, d, diskID)
+			})
+			run(storageops.FeatureTags, func(t *testing.T) bool {
+				return tags(t, d, diskID)
+			})
+			run(storageops.FeatureEnumerate, func(t *testing.T) bool {
+				return enumerate(t, d, diskID)
+			})
+			run(storageops.FeatureInspect, func(t *testing.T) bool {
+				return inspect(t, d, diskID)
+			})
+			run(storageops.FeatureExpand, func(t *testing.T) bool {
+				return expand(t, d, diskID)
+			})
+			attached := run(storageops.FeatureAttach, func(t *testing.T) bool {
+				attach(t, d, diskID)
+				return true
+			})
+			if attached {
+				run(storageops.FeatureDevicePath, func(t *testing.T) bool {
+					devicePath(t, d, diskID)
+					return true
+				})
+			}
+			run(storageops.FeatureDelete, func(t *testing.T) bool {
+				teardown(t, d, diskID)
+				return true
+			})
+			fmt.Printf("Tore down disk: %v\n", diskID)
 		}
 	}
+	return matrix
 }
 
 func name(t *testing.T, driver storageops.Ops) {
@@ -46,7 +117,7 @@ func name(t *testing.T, driver storageops.Ops) {
 	require.NotEmpty(t, name, "driver returned empty name")
 }
 
-func create(t *testing.T, driver storageops.Ops, template interface{}) interface{} {
+func create(t *testing.T, driver storageops.Ops, template interface{}) *storageops.Volume {
 	d, err := driver.Create(template, nil)
 	require.NoError(t, err, "failed to create disk")
 	require.NotNil(t, d, "got nil disk from create api")
@@ -54,17 +125,18 @@ func create(t *testing.T, driver storageops.Ops, template interface{}) interface
 	return d
 }
 
-func id(t *testing.T, driver storageops.Ops, disk interface{}) string {
+func id(t *testing.T, driver storageops.Ops, disk *storageops.Volume) string {
 	id, err := driver.GetDeviceID(disk)
 	require.NoError(t, err, "failed to get disk ID")
 	require.NotEmpty(t, id, "got empty disk name/ID")
+	require.Equal(t, disk.ID, id, "GetDeviceID returned another ID than the volume")
 	return id
 }
 
-func snapshot(t *testing.T, driver storageops.Ops, diskName string) {
+func snapshot(t *testing.T, driver storageops.Ops, diskName string) bool {
 	snap, err := driver.Snapshot(diskName, true)
 	if err == storageops.ErrNotSupported {
-		return
+		return false
 	}
 
 	require.NoError(t, err, "failed to create snapshot")
@@ -76,12 +148,13 @@ func snapshot(t *testing.T, driver storageops.Ops, diskName string) {
 
 	err = driver.SnapshotDelete(snapID)
 	require.NoError(t, err, "failed to delete snapshot")
+	return true
 }
 
-func tags(t *testing.T, driver storageops.Ops, diskName string) {
+func tags(t *testing.T, driver storageops.Ops, diskName string) bool {
 	err := driver.ApplyTags(diskName, diskLabels)
 	if err == storageops.ErrNotSupported {
-		return
+		return false
 	}
 
 	require.NoError(t, err, "failed to apply tags to disk")
@@ -99,12 +172,13 @@ func tags(t *testing.T, driver storageops.Ops, diskName string) {
 
 	err = driver.ApplyTags(diskName, diskLabels)
 	require.NoError(t, err, "failed to apply tags to disk")
+	return true
 }
 
-func enumerate(t *testing.T, driver storageops.Ops, diskName string) {
+func enumerate(t *testing.T, driver storageops.Ops, diskName string) bool {
 	disks, err := driver.Enumerate([]*string{&diskName}, diskLabels, storageops.SetIdentifierNone)
 	if err == storageops.ErrNotSupported {
-		return
+		return false
 	}
 
 	require.NoError(t, err, "failed to enumerate disk")
@@ -119,20 +193,40 @@ func enumerate(t *testing.T, driver storageops.Ops, diskName string) {
 	disks, err = driver.Enumerate([]*string{&diskName}, invalidLabels, storageops.SetIdentifierNone)
 	require.NoError(t, err, "failed to enumerate disk")
 	require.Len(t, disks, 0, "enumerate returned invalid length")
+	return true
 }
 
-func inspect(t *testing.T, driver storageops.Ops, diskName string) {
+func inspect(t *testing.T, driver storageops.Ops, diskName string) bool {
 	disks, err := driver.Inspect([]*string{&diskName})
 	if err == storageops.ErrNotSupported {
-		return
+		return false
 	}
 
 	require.NoError(t, err, "failed to inspect disk")
 	require.Len(t, disks, 1, fmt.Sprintf("inspect returned invalid length: %d", len(disks)))
+	return true
+}
+
+func expand(t *testing.T, driver storageops.Ops, diskName string) bool {
+	disks, err := driver.Inspect([]*string{&diskName})
+	if err == storageops.ErrNotSupported {
+		return false
+	}
+	require.NoError(t, err, "failed to inspect disk")
+	require.Len(t, disks, 1, "inspect returned invalid length")
+
+	newSize := disks[0].SizeGiB + 1
+	size, err := driver.Expand(diskName, newSize)
+	if err == storageops.ErrNotSupported {
+		return false
+	}
+	require.NoError(t, err, "failed to expand disk")
+	require.Equal(t, newSize, size, "expand returned invalid size")
+	return true
 }
 
 func attach(t *testing.T, driver storageops.Ops, diskName string) {
-	devPath, err := driver.Attach(diskName)
+	devPath, err := driver.Attach(diskName, nil)
 	require.NoError(t, err, "disk attach returned error")
 	require.NotEmpty(t, devPath, "disk attach returned empty devicePath")
 
@@ -143,7 +237,7 @@ func attach(t *testing.T, driver storageops.Ops, diskName string) {
 	err = driver.DetachFrom(diskName, driver.InstanceID())
 	require.NoError(t, err, "disk DetachFrom returned error")
 
-	devPath, err = driver.Attach(diskName)
+	devPath, err = driver.Attach(diskName, nil)
 	require.NoError(t, err, "disk attach returned error")
 	require.NotEmpty(t, devPath, "disk attach returned empty devicePath")
 
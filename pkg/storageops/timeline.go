@@ -0,0 +1,134 @@
+package storageops
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// TimelineSourceAudit marks timeline entries from the local audit log
+	TimelineSourceAudit = "audit"
+	// TimelineSourceProvider marks timeline entries from the provider's
+	// own audit trail, e.g. CloudTrail
+	TimelineSourceProvider = "provider"
+)
+
+// TimelineEntry is a single operation in a reconstructed volume timeline
+type TimelineEntry struct {
+	// Time the operation happened
+	Time time.Time `json:"time"`
+	// Source is where the entry came from, TimelineSourceAudit or
+	// TimelineSourceProvider
+	Source string `json:"source"`
+	// Operation is the name of the operation, e.g. AttachVolume or attach
+	Operation string `json:"operation"`
+	// VolumeID of the volume the operation was performed on
+	VolumeID string `json:"volumeId,omitempty"`
+	// InstanceID of the instance involved in the operation
+	InstanceID string `json:"instanceId,omitempty"`
+	// User is the identity that performed the operation
+	User string `json:"user,omitempty"`
+	// Err is the error of the operation if it failed
+	Err string `json:"error,omitempty"`
+}
+
+// MergeTimelines merges the given timelines into a single timeline ordered
+// by time
+func MergeTimelines(timelines ...[]*TimelineEntry) []*TimelineEntry {
+	var merged []*TimelineEntry
+	for _, t := range timelines {
+		merged = append(merged, t...)
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Time.Before(merged[j].Time)
+	})
+	return merged
+}
+
+// AuditLog is an EventPublisher that appends lifecycle events as JSON lines
+// to a local file, to be merged with provider audit trails after an incident
+type AuditLog struct {
+	sync.Mutex
+	path string
+}
+
+// NewAuditLog returns an audit log that appends to the file at path
+func NewAuditLog(path string) *AuditLog {
+	return &AuditLog{path: path}
+}
+
+// PublishEvent appends the given event to the audit log
+func (a *AuditLog) PublishEvent(event *Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// PublishInventory is a no-op, the audit log only records operations
+func (a *AuditLog) PublishInventory(sets map[string][]*Volume) error {
+	return nil
+}
+
+// Timeline returns the entries of the audit log for the given volumes between
+// start and end. A nil volumeIDs returns the entries of all volumes.
+func (a *AuditLog) Timeline(volumeIDs []string, start, end time.Time) ([]*TimelineEntry, error) {
+	a.Lock()
+	defer a.Unlock()
+	f, err := os.Open(a.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var match map[string]bool
+	if volumeIDs != nil {
+		match = make(map[string]bool)
+		for _, id := range volumeIDs {
+			match[id] = true
+		}
+	}
+
+	var entries []*TimelineEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		event := &Event{}
+		if err := json.Unmarshal(scanner.Bytes(), event); err != nil {
+			return nil, err
+		}
+		if event.Time.Before(start) || !event.Time.Before(end) {
+			continue
+		}
+		if match != nil && !match[event.VolumeID] {
+			continue
+		}
+		entries = append(entries, &TimelineEntry{
+			Time:       event.Time,
+			Source:     TimelineSourceAudit,
+			Operation:  string(event.Type),
+			VolumeID:   event.VolumeID,
+			InstanceID: event.InstanceID,
+			Err:        event.Err,
+		})
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,210 @@
+package storageops
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Annotation is a timestamped message on a span
+type Annotation struct {
+	// Time of the annotation
+	Time time.Time
+	// Message of the annotation
+	Message string
+	// Attributes of the annotation
+	Attributes map[string]interface{}
+}
+
+// SpanData is a finished span of a storage operation, modeled after the
+// opencensus trace.SpanData so exporters can convert it easily
+type SpanData struct {
+	// TraceID identifies the trace, spans of one call share it
+	TraceID string
+	// SpanID identifies the span
+	SpanID string
+	// Name of the span, e.g. storageops.aws.Attach
+	Name string
+	// StartTime of the operation
+	StartTime time.Time
+	// EndTime of the operation
+	EndTime time.Time
+	// Attributes of the span, e.g. provider, instanceID and volumeID
+	Attributes map[string]interface{}
+	// Annotations made during the operation
+	Annotations []Annotation
+	// Err is the error of the operation, nil on success
+	Err error
+}
+
+// SpanExporter exports finished spans, e.g. to an opencensus or
+// OpenTelemetry exporter of the serving binary
+type SpanExporter interface {
+	ExportSpan(span *SpanData)
+}
+
+var (
+	spanExportersLock sync.RWMutex
+	spanExporters     = make(map[SpanExporter]struct{})
+)
+
+// RegisterSpanExporter adds an exporter of the spans of traced Ops
+func RegisterSpanExporter(e SpanExporter) {
+	spanExportersLock.Lock()
+	defer spanExportersLock.Unlock()
+	spanExporters[e] = struct{}{}
+}
+
+// UnregisterSpanExporter removes an exporter added by RegisterSpanExporter
+func UnregisterSpanExporter(e SpanExporter) {
+	spanExportersLock.Lock()
+	defer spanExportersLock.Unlock()
+	delete(spanExporters, e)
+}
+
+func exportSpan(span *SpanData) {
+	spanExportersLock.RLock()
+	defer spanExportersLock.RUnlock()
+	for e := range spanExporters {
+		e.ExportSpan(span)
+	}
+}
+
+// volumeArgOps are the operations whose first argument is a volume ID
+var volumeArgOps = map[string]bool{
+	"Expand":     true,
+	"Modify":     true,
+	"Attach":     true,
+	"Detach":     true,
+	"DetachFrom": true,
+	"Delete":     true,
+	"DeleteFrom": true,
+	"DevicePath": true,
+	"Snapshot":   true,
+	"ApplyTags":  true,
+	"RemoveTags": true,
+	"Tags":       true,
+}
+
+// snapshotArgOps are the operations whose first argument is a snapshot ID
+var snapshotArgOps = map[string]bool{
+	"SnapshotDelete":  true,
+	"SnapshotRestore": true,
+	"SnapshotStatus":  true,
+}
+
+// TracingMiddleware returns a middleware emitting a span per operation of the
+// wrapped driver to the registered exporters. Wait loops run during the
+// operation, e.g. waiting for an attach, are annotated on the span.
+func TracingMiddleware() Middleware {
+	return func(ops Ops) Ops {
+		provider := ops.Name()
+		return InterceptorMiddleware(func(op string, args []interface{}, invoke Invoker) error {
+			span := &SpanData{
+				TraceID:   newTraceID(16),
+				SpanID:    newTraceID(8),
+				Name:      "storageops." + provider + "." + op,
+				StartTime: time.Now(),
+				Attributes: map[string]interface{}{
+					"provider":   provider,
+					"operation":  op,
+					"instanceID": ops.InstanceID(),
+				},
+			}
+			var id string
+			if len(args) > 0 {
+				var ok bool
+				if id, ok = args[0].(string); ok && volumeArgOps[op] {
+					span.Attributes["volumeID"] = id
+				} else if ok && snapshotArgOps[op] {
+					span.Attributes["snapshotID"] = id
+				} else {
+					id = ""
+				}
+			}
+			if op == "DetachFrom" || op == "DeleteFrom" {
+				span.Attributes["instanceID"] = args[1]
+			}
+
+			var lock sync.Mutex
+			stop := addSpanRetryObserver(func(wait string, retries int, err error) {
+				// wait loops are named after the volume or snapshot they
+				// wait for, skip those of concurrent operations
+				if len(id) > 0 && !strings.Contains(wait, id) {
+					return
+				}
+				lock.Lock()
+				defer lock.Unlock()
+				a := Annotation{
+					Time:    time.Now(),
+					Message: wait,
+					Attributes: map[string]interface{}{
+						"retries": retries,
+					},
+				}
+				if err != nil {
+					a.Attributes["error"] = err.Error()
+				}
+				span.Annotations = append(span.Annotations, a)
+			})
+			err := invoke()
+			stop()
+
+			span.EndTime = time.Now()
+			span.Err = err
+			span.Annotations = append(span.Annotations, Annotation{
+				Time:    span.EndTime,
+				Message: "cloud API call finished",
+				Attributes: map[string]interface{}{
+					"latency": span.EndTime.Sub(span.StartTime).String(),
+				},
+			})
+			exportSpan(span)
+			return err
+		})(ops)
+	}
+}
+
+var (
+	spanObserversLock sync.Mutex
+	spanObserversSeq  uint64
+	spanObservers     = make(map[uint64]RetryObserver)
+	spanObserversOnce sync.Once
+)
+
+// addSpanRetryObserver observes the wait loops of the process until the
+// returned function is called
+func addSpanRetryObserver(o RetryObserver) func() {
+	spanObserversOnce.Do(func() {
+		AddRetryObserver(func(op string, retries int, err error) {
+			spanObserversLock.Lock()
+			defer spanObserversLock.Unlock()
+			for _, o := range spanObservers {
+				o(op, retries, err)
+			}
+		})
+	})
+	spanObserversLock.Lock()
+	defer spanObserversLock.Unlock()
+	spanObserversSeq++
+	id := spanObserversSeq
+	spanObservers[id] = o
+	return func() {
+		spanObserversLock.Lock()
+		defer spanObserversLock.Unlock()
+		delete(spanObservers, id)
+	}
+}
+
+func newTraceID(n int) string {
+	b := make([]byte, n)
+	for i := 0; i < n; i += 8 {
+		var word [8]byte
+		binary.LittleEndian.PutUint64(word[:], rand.Uint64())
+		copy(b[i:], word[:])
+	}
+	return fmt.Sprintf("%x", b)
+}
@@ -0,0 +1,113 @@
+package storageops
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Volume is the provider independent view of a volume/disk
+type Volume struct {
+	// ID of the volume
+	ID string
+	// Name of the volume, if the provider has names separate from IDs
+	Name string
+	// SizeGiB is the size of the volume
+	SizeGiB uint64
+	// Type is the provider specific volume type, e.g. gp3 or pd-ssd
+	Type string
+	// Iops are the provisioned IOPS, zero if not provisioned
+	Iops int64
+	// ThroughputMiBps is the provisioned throughput, zero if not provisioned
+	ThroughputMiBps int64
+	// Zone the volume is in
+	Zone string
+	// State is the provider specific state of the volume
+	State string
+	// Encrypted is true if the volume is encrypted at rest
+	Encrypted bool
+	// Labels are the labels/tags on the volume
+	Labels map[string]string
+	// AttachedTo are the IDs of the instances the volume is attached to
+	AttachedTo []string
+	// Attachments are the attachments to the instances of AttachedTo, if
+	// the provider object has them
+	Attachments []Attachment
+	// Raw is the provider object the volume was converted from
+	Raw interface{}
+}
+
+// Attachment is the provider independent view of the attachment of a volume
+// to an instance
+type Attachment struct {
+	// InstanceID of the instance the volume is attached to
+	InstanceID string
+	// Device the volume is attached at as reported by the provider, empty if
+	// not known
+	Device string
+	// State is the provider specific state of the attachment, empty if not
+	// known
+	State string
+}
+
+// Snapshot is the provider independent view of a snapshot
+type Snapshot struct {
+	// ID of the snapshot
+	ID string
+	// VolumeID of the volume the snapshot was taken from
+	VolumeID string
+	// SizeGiB is the size of the source volume
+	SizeGiB uint64
+	// State is the provider specific state of the snapshot
+	State string
+	// Created is when the snapshot was taken
+	Created time.Time
+	// Labels are the labels/tags on the snapshot
+	Labels map[string]string
+	// Raw is the provider object the snapshot was converted from
+	Raw interface{}
+}
+
+// Converter converts the provider objects of a storage operations driver to
+// their typed counterparts
+type Converter interface {
+	// ToVolume converts a provider volume
+	ToVolume(raw interface{}) (*Volume, error)
+	// ToSnapshot converts a provider snapshot
+	ToSnapshot(raw interface{}) (*Snapshot, error)
+}
+
+var (
+	convertersLock sync.Mutex
+	converters     = make(map[string]Converter)
+)
+
+// RegisterConverter registers the converter of the driver with the given name
+func RegisterConverter(name string, c Converter) {
+	convertersLock.Lock()
+	defer convertersLock.Unlock()
+	converters[name] = c
+}
+
+// GetConverter returns the converter of the driver with the given name
+func GetConverter(name string) (Converter, error) {
+	convertersLock.Lock()
+	defer convertersLock.Unlock()
+	c, ok := converters[name]
+	if !ok {
+		return nil, fmt.Errorf("no result converter registered for %s", name)
+	}
+	return c, nil
+}
+
+// TypedID returns the ID of the given *Volume or *Snapshot, for the
+// GetDeviceID of drivers. It returns false for other objects.
+func TypedID(obj interface{}) (string, bool) {
+	switch o := obj.(type) {
+	case *Volume:
+		return o.ID, true
+	case *Snapshot:
+		return o.ID, true
+	}
+	return "", false
+}
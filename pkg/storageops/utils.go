@@ -18,16 +18,11 @@ const ProviderOpsTimeout = time.Minute
 
 // AddElementToMap adds to the given 'elem' to the 'sets' map with given 'key'
 func AddElementToMap(
-	sets map[string][]interface{},
-	elem interface{},
+	sets map[string][]*Volume,
+	elem *Volume,
 	key string,
 ) {
-	if s, ok := sets[key]; ok {
-		sets[key] = append(s, elem)
-	} else {
-		sets[key] = make([]interface{}, 0)
-		sets[key] = append(sets[key], elem)
-	}
+	sets[key] = append(sets[key], elem)
 }
 
 // GetEnvValueStrict fetches value for env variable "key". Returns error if not found or empty
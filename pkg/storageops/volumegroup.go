@@ -0,0 +1,248 @@
+package storageops
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pborman/uuid"
+	"github.com/portworx/kvdb"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// VolumeGroupLabel is the label carrying the name of the volume group on
+	// its volumes and snapshots
+	VolumeGroupLabel = "openstorage-volume-group"
+	// VolumeGroupSnapshotLabel is the label carrying the ID of the group
+	// snapshot on the snapshots of its volumes
+	VolumeGroupSnapshotLabel = "openstorage-volume-group-snapshot"
+	// volumeGroupKeyPrefix is the kvdb prefix of the names of the volume
+	// groups
+	volumeGroupKeyPrefix = "storageops/volumegroups/"
+)
+
+// VolumeGroupSpec is the spec of a volume group
+type VolumeGroupSpec struct {
+	// Name of the group, unique among the groups of the driver
+	Name string
+	// Templates are the provider templates of the volumes of the group, see
+	// Create
+	Templates []interface{}
+	// Labels are applied to every volume of the group
+	Labels map[string]string
+}
+
+// VolumeGroup is a set of volumes that are created, attached, snapshotted
+// and deleted as one unit. If an operation fails for some of the volumes it
+// is rolled back on the others, so the group is left as it was before.
+type VolumeGroup struct {
+	kv  kvdb.Kvdb
+	ops Ops
+	// Name of the group
+	Name string
+	// VolumeIDs are the IDs of the volumes of the group
+	VolumeIDs []string
+}
+
+// GroupSnapshot is a snapshot of every volume of a volume group
+type GroupSnapshot struct {
+	// ID of the group snapshot
+	ID string
+	// SnapshotIDs are the IDs of the snapshots keyed by their volume ID
+	SnapshotIDs map[string]string
+}
+
+// CreateVolumeGroup creates the volumes of the given group. The name of the
+// group is reserved in kvdb first, so it fails if a group with the name
+// exists. If any of the volumes fails to be created the others are deleted
+// again.
+func CreateVolumeGroup(kv kvdb.Kvdb, ops Ops, spec *VolumeGroupSpec) (*VolumeGroup, error) {
+	if len(spec.Name) == 0 || len(spec.Templates) == 0 {
+		return nil, NewStorageError(ErrVolInval,
+			"volume group needs a name and at least one volume", "")
+	}
+	g := &VolumeGroup{kv: kv, ops: ops, Name: spec.Name}
+	if _, err := kv.Create(g.key(), spec.Name, 0); err == kvdb.ErrExist {
+		return nil, NewStorageError(ErrVolInval,
+			fmt.Sprintf("volume group %s already exists", spec.Name), "")
+	} else if err != nil {
+		return nil, err
+	}
+	labels := make(map[string]string, len(spec.Labels)+1)
+	for k, v := range spec.Labels {
+		labels[k] = v
+	}
+	labels[VolumeGroupLabel] = spec.Name
+
+	for _, template := range spec.Templates {
+		vol, err := ops.Create(template, labels)
+		if err == nil {
+			g.VolumeIDs = append(g.VolumeIDs, vol.ID)
+			continue
+		}
+		g.rollback("create", g.VolumeIDs, ops.Delete)
+		g.release()
+		return nil, fmt.Errorf("failed to create volume group %s: %v", spec.Name, err)
+	}
+	return g, nil
+}
+
+// OpenVolumeGroup returns the existing volume group with the given name
+func OpenVolumeGroup(kv kvdb.Kvdb, ops Ops, name string) (*VolumeGroup, error) {
+	sets, err := ops.Enumerate(nil, map[string]string{VolumeGroupLabel: name}, "")
+	if err != nil {
+		return nil, err
+	}
+	g := &VolumeGroup{kv: kv, ops: ops, Name: name}
+	for _, vols := range sets {
+		for _, vol := range vols {
+			g.VolumeIDs = append(g.VolumeIDs, vol.ID)
+		}
+	}
+	if len(g.VolumeIDs) == 0 {
+		return nil, NewStorageError(ErrVolNotFound,
+			fmt.Sprintf("volume group %s not found", name), "")
+	}
+	sort.Strings(g.VolumeIDs)
+	return g, nil
+}
+
+// Attach attaches every volume of the group to this instance and returns
+// their device paths keyed by volume ID. If any attach fails the attached
+// volumes are detached again.
+func (g *VolumeGroup) Attach(options map[string]string) (map[string]string, error) {
+	reqs := make([]*AttachRequest, len(g.VolumeIDs))
+	for i, id := range g.VolumeIDs {
+		reqs[i] = &AttachRequest{VolumeID: id, Options: options}
+	}
+
+	devicePaths := make(map[string]string, len(reqs))
+	var attached, failed []string
+	for _, r := range AttachBatch(g.ops, reqs, 0) {
+		if r.Err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", r.VolumeID, r.Err))
+			continue
+		}
+		attached = append(attached, r.VolumeID)
+		devicePaths[r.VolumeID] = r.DevicePath
+	}
+	if len(failed) > 0 {
+		g.rollback("attach", attached, g.ops.Detach)
+		return nil, fmt.Errorf("failed to attach volume group %s: %s",
+			g.Name, strings.Join(failed, "; "))
+	}
+	return devicePaths, nil
+}
+
+// Detach detaches every volume of the group from this instance. A detach
+// can not be rolled back, so it continues with the remaining volumes on
+// failure and returns an error listing the failed ones.
+func (g *VolumeGroup) Detach() error {
+	var failed []string
+	for _, r := range DetachBatch(g.ops, g.VolumeIDs, 0) {
+		if r.Err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", r.VolumeID, r.Err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to detach %d volumes of volume group %s: %s",
+			len(failed), g.Name, strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// Snapshot snapshots every volume of the group and labels the snapshots with
+// the group and the ID of the group snapshot. If any snapshot fails the
+// others are deleted again.
+func (g *VolumeGroup) Snapshot(readonly bool) (*GroupSnapshot, error) {
+	gs := &GroupSnapshot{
+		ID:          uuid.New(),
+		SnapshotIDs: make(map[string]string, len(g.VolumeIDs)),
+	}
+	labels := map[string]string{
+		VolumeGroupLabel:         g.Name,
+		VolumeGroupSnapshotLabel: gs.ID,
+	}
+
+	var snapIDs []string
+	for _, volumeID := range g.VolumeIDs {
+		snap, err := SnapshotWithLabels(g.ops, volumeID, readonly, labels)
+		if err == nil {
+			snapIDs = append(snapIDs, snap.ID)
+			gs.SnapshotIDs[volumeID] = snap.ID
+			continue
+		}
+		g.rollback("snapshot", snapIDs, g.ops.SnapshotDelete)
+		return nil, fmt.Errorf("failed to snapshot volume %s of volume group %s: %v",
+			volumeID, g.Name, err)
+	}
+	return gs, nil
+}
+
+// Delete deletes every volume of the group. The volumes must be detached, it
+// fails without deleting any volume if one of them is still attached.
+func (g *VolumeGroup) Delete() error {
+	attached, err := g.attachedVolumes()
+	if err != nil {
+		return err
+	}
+	if len(attached) > 0 {
+		return NewStorageError(ErrVolInval,
+			fmt.Sprintf("volume group %s has attached volumes %v", g.Name, attached), "")
+	}
+
+	var failed []string
+	for _, volumeID := range g.VolumeIDs {
+		if err := g.ops.Delete(volumeID); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", volumeID, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to delete %d volumes of volume group %s: %s",
+			len(failed), g.Name, strings.Join(failed, "; "))
+	}
+	g.release()
+	return nil
+}
+
+func (g *VolumeGroup) key() string {
+	return volumeGroupKeyPrefix + g.ops.Name() + "/" + g.Name
+}
+
+// release frees the name of the group for new groups
+func (g *VolumeGroup) release() {
+	if _, err := g.kv.Delete(g.key()); err != nil && err != kvdb.ErrNotFound {
+		logrus.Warnf("failed to release the name of volume group %s: %v", g.Name, err)
+	}
+}
+
+// attachedVolumes returns the volumes of the group attached to any instance
+func (g *VolumeGroup) attachedVolumes() ([]string, error) {
+	ids := make([]*string, len(g.VolumeIDs))
+	for i := range g.VolumeIDs {
+		ids[i] = &g.VolumeIDs[i]
+	}
+	vols, err := g.ops.Inspect(ids)
+	if err != nil {
+		return nil, err
+	}
+	var attached []string
+	for _, v := range vols {
+		if len(v.AttachedTo) > 0 {
+			attached = append(attached, v.ID)
+		}
+	}
+	return attached, nil
+}
+
+// rollback undoes an operation on the given volumes or snapshots. Failures
+// are only logged as the error of the operation is returned to the caller.
+func (g *VolumeGroup) rollback(op string, ids []string, undo func(id string) error) {
+	for _, id := range ids {
+		if err := undo(id); err != nil {
+			logrus.Warnf("failed to roll back %s of %s of volume group %s: %v",
+				op, id, g.Name, err)
+		}
+	}
+}
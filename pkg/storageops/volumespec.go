@@ -0,0 +1,136 @@
+package storageops
+
+import (
+	"fmt"
+)
+
+// Fields of a VolumeSpec that drivers may not support, see
+// VolumeSpec.Unsupported
+const (
+	SpecFieldType       = "type"
+	SpecFieldIops       = "iops"
+	SpecFieldThroughput = "throughput"
+	SpecFieldEncrypted  = "encrypted"
+	SpecFieldKMSKey     = "kms key"
+	SpecFieldSnapshot   = "snapshot"
+)
+
+// VolumeSpec is the provider independent spec of a volume to create. Create
+// of the drivers accepts a *VolumeSpec in place of their provider template.
+// Zero fields are left to the provider defaults.
+type VolumeSpec struct {
+	// SizeGiB is the size of the volume
+	SizeGiB uint64
+	// Type is the provider specific volume type, e.g. gp3 or pd-ssd
+	Type string
+	// Iops are the provisioned IOPS, zero if not provisioned
+	Iops int64
+	// ThroughputMiBps is the provisioned throughput, zero if not provisioned
+	ThroughputMiBps int64
+	// Zone to create the volume in, defaults to the zone of the instance
+	Zone string
+	// Encrypted requires the volume to be encrypted at rest
+	Encrypted bool
+	// KMSKeyID is the provider specific ID of the key to encrypt the volume
+	// with
+	KMSKeyID string
+	// SnapshotID is the snapshot to create the volume from, if any
+	SnapshotID string
+	// Labels of the volume. The labels given to Create take precedence.
+	Labels map[string]string
+	// ClientToken is the idempotency key of the create, see
+	// ClientTokenLabel
+	ClientToken string
+	// Native is a provider template, e.g. an *ec2.Volume, the fields of the
+	// spec are applied to. It sets what the spec has no field for.
+	Native interface{}
+}
+
+// Unsupported returns an ErrVolInval error if the spec sets any of the given
+// fields that the given driver can not create volumes with
+func (s *VolumeSpec) Unsupported(driver string, fields ...string) error {
+	var set []string
+	for _, field := range fields {
+		var isSet bool
+		switch field {
+		case SpecFieldType:
+			isSet = len(s.Type) > 0
+		case SpecFieldIops:
+			isSet = s.Iops > 0
+		case SpecFieldThroughput:
+			isSet = s.ThroughputMiBps > 0
+		case SpecFieldEncrypted:
+			isSet = s.Encrypted
+		case SpecFieldKMSKey:
+			isSet = len(s.KMSKeyID) > 0
+		case SpecFieldSnapshot:
+			isSet = len(s.SnapshotID) > 0
+		}
+		if isSet {
+			set = append(set, field)
+		}
+	}
+	if len(set) > 0 {
+		return NewStorageError(ErrVolInval,
+			fmt.Sprintf("%s volumes can not be created with %v", driver, set), "")
+	}
+	return nil
+}
+
+// SpecConverter is implemented by the Converters of drivers that create
+// volumes from a VolumeSpec
+type SpecConverter interface {
+	// FromSpec returns the provider template of the given spec. It returns
+	// an ErrVolInval error if the provider does not support a field of the
+	// spec or its native template is of the wrong type.
+	FromSpec(spec *VolumeSpec) (interface{}, error)
+}
+
+// VolumeTemplate returns the provider template and labels that the driver
+// with the given name creates a volume from. A *VolumeSpec is translated by
+// the SpecConverter of the driver and its labels and client token are merged
+// with the given labels, other templates are returned as they are. Drivers call it first
+// in Create.
+func VolumeTemplate(
+	name string,
+	template interface{},
+	labels map[string]string,
+) (interface{}, map[string]string, error) {
+	spec, ok := template.(*VolumeSpec)
+	if !ok {
+		return template, labels, nil
+	}
+	c, err := GetConverter(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	sc, ok := c.(SpecConverter)
+	if !ok {
+		return nil, nil, ErrNotSupported
+	}
+	native, err := sc.FromSpec(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(spec.Labels) == 0 && len(spec.ClientToken) == 0 {
+		return native, labels, nil
+	}
+	merged := make(map[string]string, len(spec.Labels)+len(labels)+1)
+	for k, v := range spec.Labels {
+		merged[k] = v
+	}
+	if len(spec.ClientToken) > 0 {
+		merged[ClientTokenLabel] = spec.ClientToken
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	return native, merged, nil
+}
+
+// NativeTemplateError returns the ErrVolInval error of a VolumeSpec with a
+// native template of the wrong type for the given driver
+func NativeTemplateError(driver string, native interface{}) error {
+	return NewStorageError(ErrVolInval,
+		fmt.Sprintf("invalid %s native template %T", driver, native), "")
+}
@@ -0,0 +1,56 @@
+package vsphere
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/libopenstorage/openstorage/pkg/storageops/config"
+)
+
+func init() {
+	config.Register("vsphere", newFromCloudConfig)
+}
+
+// newFromCloudConfig creates the driver of the vCenter of the vcenter,
+// vcenterPort, user and insecure params of the given cloud config,
+// authenticated with its password credential. The instance is the VM UUID.
+// Whatever the config does not set is read from the environment vars of
+// ReadVSphereConfigFromEnv.
+func newFromCloudConfig(c *config.Config) (storageops.Ops, error) {
+	cfg := &VSphereConfig{VMUUID: c.Instance}
+	for _, f := range []struct {
+		param, env string
+		field      *string
+	}{
+		{"vcenter", "VSPHERE_VCENTER", &cfg.VCenterIP},
+		{"vcenterPort", "VSPHERE_VCENTER_PORT", &cfg.VCenterPort},
+		{"user", "VSPHERE_USER", &cfg.User},
+	} {
+		v, ok := c.Params[f.param]
+		if !ok {
+			var err error
+			if v, err = storageops.GetEnvValueStrict(f.env); err != nil {
+				return nil, fmt.Errorf("vsphere cloud config requires param %s or env %s",
+					f.param, f.env)
+			}
+		}
+		*f.field = v
+	}
+	var err error
+	if cfg.Password, err = c.RequiredCredential("password", "VSPHERE_PASSWORD"); err != nil {
+		return nil, err
+	}
+	if insecure, ok := c.Params["insecure"]; ok {
+		if cfg.InsecureFlag, err = strconv.ParseBool(insecure); err != nil {
+			return nil, fmt.Errorf("invalid insecure %q: %v", insecure, err)
+		}
+	} else if insecure, err := storageops.GetEnvValueStrict("VSPHERE_INSECURE"); err == nil {
+		cfg.InsecureFlag = strings.ToLower(insecure) == "true"
+	}
+	if len(cfg.VMUUID) == 0 {
+		cfg.VMUUID, _ = storageops.GetEnvValueStrict("VSPHERE_VM_UUID")
+	}
+	return NewClient(cfg)
+}
@@ -0,0 +1,92 @@
+package vsphere
+
+import (
+	"fmt"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	"github.com/pborman/uuid"
+	"k8s.io/kubernetes/pkg/cloudprovider/providers/vsphere/vclib"
+)
+
+func init() {
+	storageops.RegisterConverter("vsphere", &converter{})
+	storageops.RegisterSizeRule("vsphere", &storageops.SizeRule{
+		Granularity: storageops.GiB,
+		Max:         62 * storageops.TiB,
+	})
+}
+
+type converter struct{}
+
+func (c *converter) ToVolume(raw interface{}) (*storageops.Volume, error) {
+	disk, ok := raw.(*VirtualDisk)
+	if !ok {
+		return nil, fmt.Errorf("invalid vsphere disk %T", raw)
+	}
+	return toVolume(disk), nil
+}
+
+func toVolume(disk *VirtualDisk) *storageops.Volume {
+	v := &storageops.Volume{
+		ID:  disk.DiskPath,
+		Raw: disk,
+	}
+	if opts := disk.VolumeOptions; opts != nil {
+		v.Name = opts.Name
+		v.SizeGiB = uint64(opts.CapacityKB) / (1024 * 1024)
+		v.Type = opts.DiskFormat
+		v.Zone = opts.Datastore
+		v.Labels = opts.Tags
+	}
+	return v
+}
+
+// FromSpec returns the *vclib.VolumeOptions template of the given spec. The
+// type is the disk format and the zone is the datastore. Disks without a
+// name in the native template are named after a random UUID.
+func (c *converter) FromSpec(spec *storageops.VolumeSpec) (interface{}, error) {
+	if err := spec.Unsupported("vsphere", storageops.SpecFieldIops, storageops.SpecFieldThroughput,
+		storageops.SpecFieldEncrypted, storageops.SpecFieldKMSKey, storageops.SpecFieldSnapshot); err != nil {
+		return nil, err
+	}
+	opts := &vclib.VolumeOptions{}
+	if spec.Native != nil {
+		native, ok := spec.Native.(*vclib.VolumeOptions)
+		if !ok {
+			return nil, storageops.NativeTemplateError("vsphere", spec.Native)
+		}
+		copied := *native
+		opts = &copied
+	}
+	if len(opts.Name) == 0 {
+		opts.Name = "disk-" + uuid.New()
+	}
+	if spec.SizeGiB > 0 {
+		opts.CapacityKB = int(spec.SizeGiB) * 1024 * 1024
+	}
+	if len(spec.Type) > 0 {
+		opts.DiskFormat = spec.Type
+	}
+	if len(spec.Zone) > 0 {
+		opts.Datastore = spec.Zone
+	}
+	return opts, nil
+}
+
+func (c *converter) ToSnapshot(raw interface{}) (*storageops.Snapshot, error) {
+	snap, ok := raw.(*Snapshot)
+	if !ok {
+		return nil, fmt.Errorf("invalid vsphere snapshot %T", raw)
+	}
+	return toSnapshot(snap), nil
+}
+
+func toSnapshot(snap *Snapshot) *storageops.Snapshot {
+	return &storageops.Snapshot{
+		ID:       snap.ID,
+		VolumeID: snap.DiskPath,
+		State:    "ready",
+		Created:  snap.Created,
+		Raw:      snap,
+	}
+}
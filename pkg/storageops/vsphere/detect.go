@@ -0,0 +1,45 @@
+package vsphere
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+// sysVendorPath is the DMI system vendor of the instance, "VMware, Inc." on
+// vSphere VMs
+var sysVendorPath = "/sys/class/dmi/id/sys_vendor"
+
+func init() {
+	storageops.RegisterDetector("vsphere", &storageops.Detector{
+		Probe: probe,
+		New: func() (storageops.Ops, error) {
+			cfg, err := ReadVSphereConfigFromEnv()
+			if err != nil {
+				return nil, err
+			}
+			return NewClient(cfg)
+		},
+	})
+}
+
+// probe detects a VMware VM with the vCenter to manage its disks configured
+// in the environment. Unlike the clouds vSphere has no metadata service that
+// names the vCenter.
+func probe() error {
+	if !IsDevMode() {
+		vendor, err := ioutil.ReadFile(sysVendorPath)
+		if err != nil {
+			return fmt.Errorf("unable to read system vendor: %v", err)
+		}
+		if !strings.Contains(string(vendor), "VMware") {
+			return fmt.Errorf("system vendor is %q", strings.TrimSpace(string(vendor)))
+		}
+	}
+	if _, err := ReadVSphereConfigFromEnv(); err != nil {
+		return fmt.Errorf("running on VMware but vCenter is not configured: %v", err)
+	}
+	return nil
+}
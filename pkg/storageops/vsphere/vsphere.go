@@ -6,6 +6,7 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/libopenstorage/openstorage/pkg/storageops"
 	"github.com/sirupsen/logrus"
@@ -30,6 +31,27 @@ type vsphereOps struct {
 	vm   *vclib.VirtualMachine
 	conn *vclib.VSphereConnection
 	cfg  *VSphereConfig
+	// disks are the vmdk operations of the snapshot calls
+	disks virtualDisks
+}
+
+// virtualDisks are the vmdk operations of the snapshot calls, replaced by
+// tests as the vendored govmomi has no simulator
+type virtualDisks interface {
+	// copyDisk copies the vmdk at src to dest and waits for the copy to
+	// finish
+	copyDisk(ctx context.Context, src, dest string) error
+	// deleteDisk deletes the vmdk at the given path and waits for the delete
+	// to finish
+	deleteDisk(ctx context.Context, diskPath string) error
+	// diskUUID returns the UUID of the vmdk at the given path
+	diskUUID(ctx context.Context, diskPath string) (string, error)
+	// canonicalPath returns the path of the given vmdk with the datastore
+	// folder ID in place of its name
+	canonicalPath(ctx context.Context, diskPath string) (string, error)
+	// diskDirectory creates the directory of provisioned disks on the given
+	// datastore if needed and returns its path
+	diskDirectory(ctx context.Context, datastore string) (string, error)
 }
 
 // VirtualDisk encapsulates the existing virtual disk object to add a managed object
@@ -40,6 +62,17 @@ type VirtualDisk struct {
 	DatastoreRef types.ManagedObjectReference
 }
 
+// Snapshot is a point in time copy of a virtual disk, stored as a vmdk next
+// to the disk it was taken from
+type Snapshot struct {
+	// ID is the path of the snapshot vmdk
+	ID string
+	// DiskPath is the path of the disk the snapshot was taken from
+	DiskPath string
+	// Created is when the snapshot was taken
+	Created time.Time
+}
+
 // NewClient creates a new vsphere storageops instance
 func NewClient(cfg *VSphereConfig) (storageops.Ops, error) {
 	vSphereConn := &vclib.VSphereConnection{
@@ -63,18 +96,37 @@ func NewClient(cfg *VSphereConfig) (storageops.Ops, error) {
 	logrus.Debugf("  Datacenter: %s", vmObj.Datacenter.Name())
 	logrus.Debugf("  VMUUID: %s", cfg.VMUUID)
 
-	return &vsphereOps{
+	ops := &vsphereOps{
 		cfg:  cfg,
 		vm:   vmObj,
 		conn: vSphereConn,
-	}, nil
+	}
+	ops.disks = &datacenterDisks{ops: ops}
+	return ops, nil
 }
 
 func (ops *vsphereOps) Name() string { return "vsphere" }
 
 func (ops *vsphereOps) InstanceID() string { return ops.cfg.VMUUID }
 
-func (ops *vsphereOps) Create(opts interface{}, labels map[string]string) (interface{}, error) {
+// vSphere has no notion of availability zones or regions
+func (ops *vsphereOps) GetZone() (string, error) {
+	return "", storageops.ErrNotSupported
+}
+
+func (ops *vsphereOps) GetRegion() (string, error) {
+	return "", storageops.ErrNotSupported
+}
+
+func (ops *vsphereOps) ListZones() ([]string, error) {
+	return nil, storageops.ErrNotSupported
+}
+
+func (ops *vsphereOps) Create(opts interface{}, labels map[string]string) (*storageops.Volume, error) {
+	opts, labels, err := storageops.VolumeTemplate(ops.Name(), opts, labels)
+	if err != nil {
+		return nil, err
+	}
 	volumeOptions, ok := opts.(*vclib.VolumeOptions)
 	if !ok {
 		return nil, fmt.Errorf("invalid volume options specified to create: %v", opts)
@@ -155,23 +207,28 @@ func (ops *vsphereOps) Create(opts interface{}, labels map[string]string) (inter
 
 	disk.DiskPath = canonicalVolumePath
 
-	return &VirtualDisk{
+	return toVolume(&VirtualDisk{
 		VirtualDisk:  disk,
 		DatastoreRef: ds.Reference(),
-	}, nil
+	}), nil
 }
 
 func (ops *vsphereOps) GetDeviceID(vDisk interface{}) (string, error) {
-	disk, ok := vDisk.(*VirtualDisk)
-	if !ok {
+	if id, ok := storageops.TypedID(vDisk); ok {
+		return id, nil
+	}
+	switch disk := vDisk.(type) {
+	case *VirtualDisk:
+		return disk.DiskPath, nil
+	case *Snapshot:
+		return disk.ID, nil
+	default:
 		return "", fmt.Errorf("invalid input: %v to GetDeviceID", vDisk)
 	}
-
-	return disk.DiskPath, nil
 }
 
 // Attach takes in the path of the vmdk file and returns where it is attached inside the vm instance
-func (ops *vsphereOps) Attach(diskPath string) (string, error) {
+func (ops *vsphereOps) Attach(diskPath string, options map[string]string) (string, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -277,7 +334,7 @@ func (ops *vsphereOps) FreeDevices(blockDeviceMappings []interface{}, rootDevice
 	return nil, storageops.ErrNotSupported
 }
 
-func (ops *vsphereOps) Inspect(diskPaths []*string) ([]interface{}, error) {
+func (ops *vsphereOps) Inspect(diskPaths []*string) ([]*storageops.Volume, error) {
 	// TODO find a way to map diskPaths to unattached/attached virtual disks and query info
 	// currently returning the disks directly
 
@@ -350,20 +407,185 @@ func (ops *vsphereOps) DevicePath(diskPath string) (string, error) {
 func (ops *vsphereOps) Enumerate(volumeIds []*string,
 	labels map[string]string,
 	setIdentifier string,
-) (map[string][]interface{}, error) {
+) (map[string][]*storageops.Volume, error) {
 	return nil, storageops.ErrNotSupported
 }
 
-// Snapshot the volume with given volumeID
-func (ops *vsphereOps) Snapshot(volumeID string, readonly bool) (interface{}, error) {
-	return nil, storageops.ErrNotSupported
+// Expand grows the given volume to newSizeGiB
+func (ops *vsphereOps) Expand(volumeID string, newSizeGiB uint64) (uint64, error) {
+	return 0, storageops.ErrNotSupported
 }
 
-// SnapshotDelete deletes the snapshot with given ID
-func (ops *vsphereOps) SnapshotDelete(snapID string) error {
+// Modify is not supported, vmdks have no types or provisioned performance
+func (ops *vsphereOps) Modify(volumeID string, spec storageops.VolumeSpecUpdate) error {
 	return storageops.ErrNotSupported
 }
 
+// Snapshot copies the vmdk of the given volume to a snapshot vmdk in the same
+// directory. vCenter refuses to copy a disk open for writing, so the volume
+// must be detached or quiesced by the caller.
+func (ops *vsphereOps) Snapshot(volumeID string, readonly bool) (*storageops.Snapshot, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	now := time.Now()
+	snapPath := fmt.Sprintf("%s-snap-%d.vmdk", strings.TrimSuffix(volumeID, ".vmdk"), now.Unix())
+	if err := ops.disks.copyDisk(ctx, volumeID, snapPath); err != nil {
+		logrus.Errorf("Failed to snapshot vsphere disk: %s to %s. err: %+v", volumeID, snapPath, err)
+		return nil, err
+	}
+
+	return toSnapshot(&Snapshot{
+		ID:       snapPath,
+		DiskPath: volumeID,
+		Created:  now,
+	}), nil
+}
+
+// SnapshotDelete deletes the snapshot vmdk with given path
+func (ops *vsphereOps) SnapshotDelete(snapID string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	return ops.disks.deleteDisk(ctx, snapID)
+}
+
+// SnapshotEnumerate returns the snapshots matching the given filter
+func (ops *vsphereOps) SnapshotEnumerate(
+	filter *storageops.SnapshotFilter,
+) ([]*storageops.Snapshot, error) {
+	return nil, storageops.ErrNotSupported
+}
+
+// SnapshotRestore copies the given snapshot vmdk to a new disk. zone is the
+// datastore to restore to, defaulting to the datastore of the snapshot.
+func (ops *vsphereOps) SnapshotRestore(
+	snapID, zone string,
+	labels map[string]string,
+) (*storageops.Volume, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	name := fmt.Sprintf("%s-restore-%d",
+		strings.TrimSuffix(path.Base(snapID), ".vmdk"), time.Now().Unix())
+	diskPath := path.Join(path.Dir(snapID), name+".vmdk")
+	datastore := zone
+	if len(datastore) > 0 {
+		diskBasePath, err := ops.disks.diskDirectory(ctx, datastore)
+		if err != nil {
+			return nil, err
+		}
+		diskPath = diskBasePath + name + ".vmdk"
+	} else if strings.HasPrefix(snapID, "[") {
+		datastore = strings.TrimPrefix(strings.SplitN(snapID, "]", 2)[0], "[")
+	}
+
+	if err := ops.disks.copyDisk(ctx, snapID, diskPath); err != nil {
+		logrus.Errorf("Failed to restore vsphere snapshot: %s to %s. err: %+v", snapID, diskPath, err)
+		return nil, err
+	}
+
+	canonicalVolumePath, err := ops.disks.canonicalPath(ctx, diskPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return toVolume(&VirtualDisk{
+		VirtualDisk: diskmanagers.VirtualDisk{
+			DiskPath: canonicalVolumePath,
+			VolumeOptions: &vclib.VolumeOptions{
+				Name:      name,
+				Datastore: datastore,
+				Tags:      labels,
+			},
+		},
+	}), nil
+}
+
+// SnapshotStatus returns the status of the given snapshot vmdk. Snapshots
+// are complete once Snapshot returns, as the copy is waited for.
+func (ops *vsphereOps) SnapshotStatus(snapID string) (*storageops.SnapshotStatus, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if _, err := ops.disks.diskUUID(ctx, snapID); err != nil {
+		return nil, err
+	}
+	return &storageops.SnapshotStatus{
+		ID:        snapID,
+		State:     "ready",
+		Progress:  100,
+		Completed: true,
+	}, nil
+}
+
+// datacenterDisks are the virtualDisks of the datacenter of the VM, using
+// the virtual disk manager of vCenter
+type datacenterDisks struct {
+	ops *vsphereOps
+}
+
+func (d *datacenterDisks) copyDisk(ctx context.Context, src, dest string) error {
+	vmObj, err := d.ops.renewVM(ctx, d.ops.vm)
+	if err != nil {
+		return err
+	}
+	vdm := object.NewVirtualDiskManager(vmObj.Client())
+	dc := vmObj.Datacenter.Datacenter
+	task, err := vdm.CopyVirtualDisk(ctx, src, dc, dest, dc, nil, false)
+	if err != nil {
+		return err
+	}
+	return task.Wait(ctx)
+}
+
+func (d *datacenterDisks) deleteDisk(ctx context.Context, diskPath string) error {
+	vmObj, err := d.ops.renewVM(ctx, d.ops.vm)
+	if err != nil {
+		return err
+	}
+	vdm := object.NewVirtualDiskManager(vmObj.Client())
+	task, err := vdm.DeleteVirtualDisk(ctx, diskPath, vmObj.Datacenter.Datacenter)
+	if err != nil {
+		return err
+	}
+	return task.Wait(ctx)
+}
+
+func (d *datacenterDisks) diskUUID(ctx context.Context, diskPath string) (string, error) {
+	vmObj, err := d.ops.renewVM(ctx, d.ops.vm)
+	if err != nil {
+		return "", err
+	}
+	vdm := object.NewVirtualDiskManager(vmObj.Client())
+	return vdm.QueryVirtualDiskUuid(ctx, diskPath, vmObj.Datacenter.Datacenter)
+}
+
+func (d *datacenterDisks) canonicalPath(ctx context.Context, diskPath string) (string, error) {
+	vmObj, err := d.ops.renewVM(ctx, d.ops.vm)
+	if err != nil {
+		return "", err
+	}
+	return getCanonicalVolumePath(ctx, vmObj.Datacenter, diskPath)
+}
+
+func (d *datacenterDisks) diskDirectory(ctx context.Context, datastore string) (string, error) {
+	vmObj, err := d.ops.renewVM(ctx, d.ops.vm)
+	if err != nil {
+		return "", err
+	}
+	ds, err := vmObj.Datacenter.GetDatastoreByName(ctx, datastore)
+	if err != nil {
+		return "", err
+	}
+	diskBasePath := filepath.Clean(ds.Path(diskDirectory)) + "/"
+	err = ds.CreateDirectory(ctx, diskBasePath, false)
+	if err != nil && err != vclib.ErrFileAlreadyExist {
+		return "", err
+	}
+	return diskBasePath, nil
+}
+
 // ApplyTags will apply given labels/tags on the given volume
 func (ops *vsphereOps) ApplyTags(volumeID string, labels map[string]string) error {
 	return storageops.ErrNotSupported
@@ -1,12 +1,15 @@
 package vsphere
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/libopenstorage/openstorage/pkg/storageops"
 	"github.com/libopenstorage/openstorage/pkg/storageops/test"
 	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"k8s.io/kubernetes/pkg/cloudprovider/providers/vsphere/vclib"
 )
@@ -62,3 +65,120 @@ func TestAll(t *testing.T) {
 		t.Skip("skipping vSphere tests as environment is not set...")
 	}
 }
+
+// fakeDisks are virtualDisks of the vmdks in a map of path to UUID. Disk
+// paths are canonical with the datastore name as folder ID.
+type fakeDisks struct {
+	disks map[string]string
+	// fail fails the calls on the given path
+	fail string
+}
+
+func (f *fakeDisks) check(diskPath string) error {
+	if diskPath == f.fail {
+		return fmt.Errorf("injected failure on %s", diskPath)
+	}
+	if _, ok := f.disks[diskPath]; !ok {
+		return fmt.Errorf("file %s was not found", diskPath)
+	}
+	return nil
+}
+
+func (f *fakeDisks) copyDisk(ctx context.Context, src, dest string) error {
+	if err := f.check(src); err != nil {
+		return err
+	}
+	if _, ok := f.disks[dest]; ok {
+		return fmt.Errorf("file %s already exists", dest)
+	}
+	f.disks[dest] = uuid.New()
+	return nil
+}
+
+func (f *fakeDisks) deleteDisk(ctx context.Context, diskPath string) error {
+	if err := f.check(diskPath); err != nil {
+		return err
+	}
+	delete(f.disks, diskPath)
+	return nil
+}
+
+func (f *fakeDisks) diskUUID(ctx context.Context, diskPath string) (string, error) {
+	if err := f.check(diskPath); err != nil {
+		return "", err
+	}
+	return f.disks[diskPath], nil
+}
+
+func (f *fakeDisks) canonicalPath(ctx context.Context, diskPath string) (string, error) {
+	if err := f.check(diskPath); err != nil {
+		return "", err
+	}
+	return diskPath, nil
+}
+
+func (f *fakeDisks) diskDirectory(ctx context.Context, datastore string) (string, error) {
+	if datastore == f.fail {
+		return "", fmt.Errorf("datastore %s was not found", datastore)
+	}
+	return fmt.Sprintf("[%s] %s/", datastore, diskDirectory), nil
+}
+
+func TestSnapshot(t *testing.T) {
+	volumeID := "[ds1] " + diskDirectory + "/disk-1.vmdk"
+	disks := &fakeDisks{disks: map[string]string{volumeID: uuid.New()}}
+	ops := &vsphereOps{cfg: &VSphereConfig{}, disks: disks}
+
+	snap, err := ops.Snapshot(volumeID, false)
+	require.NoError(t, err)
+	assert.Equal(t, volumeID, snap.VolumeID)
+	assert.True(t, strings.HasPrefix(snap.ID, "[ds1] "+diskDirectory+"/disk-1-snap-"), snap.ID)
+	assert.Contains(t, disks.disks, snap.ID, "the snapshot must copy the vmdk")
+	assert.Equal(t, "ready", snap.State)
+
+	status, err := ops.SnapshotStatus(snap.ID)
+	require.NoError(t, err)
+	assert.True(t, status.Completed)
+
+	restored, err := ops.SnapshotRestore(snap.ID, "", map[string]string{"foo": "bar"})
+	require.NoError(t, err)
+	assert.Equal(t, "ds1", restored.Zone, "restores default to the datastore of the snapshot")
+	assert.True(t, strings.HasPrefix(restored.ID, "[ds1] "+diskDirectory+"/"), restored.ID)
+	assert.Contains(t, disks.disks, restored.ID)
+	assert.Equal(t, map[string]string{"foo": "bar"}, restored.Labels)
+
+	restored, err = ops.SnapshotRestore(snap.ID, "ds2", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "ds2", restored.Zone)
+	assert.True(t, strings.HasPrefix(restored.ID, "[ds2] "+diskDirectory+"/"), restored.ID)
+	assert.Contains(t, disks.disks, restored.ID)
+
+	require.NoError(t, ops.SnapshotDelete(snap.ID))
+	assert.NotContains(t, disks.disks, snap.ID)
+	assert.Contains(t, disks.disks, volumeID, "deleting a snapshot must keep its volume")
+
+	_, err = ops.SnapshotStatus(snap.ID)
+	assert.Error(t, err, "deleted snapshots have no status")
+	assert.Error(t, ops.SnapshotDelete(snap.ID))
+	_, err = ops.SnapshotRestore(snap.ID, "", nil)
+	assert.Error(t, err, "deleted snapshots cannot be restored")
+}
+
+func TestSnapshotErrors(t *testing.T) {
+	volumeID := "[ds1] " + diskDirectory + "/disk-1.vmdk"
+	disks := &fakeDisks{disks: map[string]string{volumeID: uuid.New()}, fail: volumeID}
+	ops := &vsphereOps{cfg: &VSphereConfig{}, disks: disks}
+
+	_, err := ops.Snapshot(volumeID, false)
+	assert.Error(t, err)
+	_, err = ops.Snapshot("[ds1] missing.vmdk", false)
+	assert.Error(t, err)
+	assert.Len(t, disks.disks, 1, "failed snapshots must not create vmdks")
+
+	disks.fail = "ds2"
+	snap, err := ops.Snapshot(volumeID, false)
+	require.NoError(t, err)
+	_, err = ops.SnapshotRestore(snap.ID, "ds2", nil)
+	assert.Error(t, err, "restores to a missing datastore must fail")
+	assert.Len(t, disks.disks, 2)
+}
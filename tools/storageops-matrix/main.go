@@ -0,0 +1,72 @@
+/*
+Copyright 2018 Portworx
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+// This tool merges the feature matrices written by the storageops conformance
+// suite for each provider and emits a single JSON or markdown matrix
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+)
+
+var format = flag.String("format", "json", "output format: json or markdown")
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "usage: storageops-matrix [-format json|markdown] <matrix.json>...\n")
+		os.Exit(1)
+	}
+
+	merged := make(storageops.FeatureMatrix)
+	for _, file := range flag.Args() {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", file, err)
+			os.Exit(1)
+		}
+		var m storageops.FeatureMatrix
+		if err := json.Unmarshal(data, &m); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to parse %s: %v\n", file, err)
+			os.Exit(1)
+		}
+		for driver, features := range m {
+			for feature, status := range features {
+				merged.Set(driver, feature, status)
+			}
+		}
+	}
+
+	switch *format {
+	case "markdown":
+		fmt.Print(merged.Markdown())
+	case "json":
+		out, err := json.MarshalIndent(merged, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to marshal matrix: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(out))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2018 Portworx
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+// This tool reconstructs the operation timeline of volumes from CloudTrail,
+// optionally merged with a local storageops audit log, for post-incident
+// analysis
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
+	aws_ops "github.com/libopenstorage/openstorage/pkg/storageops/aws"
+)
+
+var (
+	region   = flag.String("region", os.Getenv("AWS_REGION"), "AWS region of the volumes")
+	volumes  = flag.String("volumes", "", "comma separated volume IDs")
+	since    = flag.Duration("since", 24*time.Hour, "how far back to replay")
+	until    = flag.String("until", "", "end of the replay in RFC3339, defaults to now")
+	auditLog = flag.String("audit-log", "", "local storageops audit log to merge")
+	format   = flag.String("format", "table", "output format: table or json")
+)
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+func main() {
+	flag.Parse()
+	if len(*volumes) == 0 {
+		fail("usage: storageops-replay -volumes vol-1,vol-2 [-region r] [-since 24h] " +
+			"[-until time] [-audit-log path] [-format table|json]")
+	}
+	volumeIDs := strings.Split(*volumes, ",")
+
+	end := time.Now()
+	if len(*until) > 0 {
+		var err error
+		if end, err = time.Parse(time.RFC3339, *until); err != nil {
+			fail("invalid -until %q: %v", *until, err)
+		}
+	}
+	start := end.Add(-*since)
+
+	sess, err := session.NewSession(&aws.Config{Region: region})
+	if err != nil {
+		fail("failed to create AWS session: %v", err)
+	}
+	timeline, err := aws_ops.ReplayCloudTrail(sess, volumeIDs, start, end)
+	if err != nil {
+		fail("failed to replay CloudTrail: %v", err)
+	}
+	if len(*auditLog) > 0 {
+		local, err := storageops.NewAuditLog(*auditLog).Timeline(volumeIDs, start, end)
+		if err != nil {
+			fail("failed to read audit log %s: %v", *auditLog, err)
+		}
+		timeline = storageops.MergeTimelines(timeline, local)
+	}
+
+	switch *format {
+	case "json":
+		out, err := json.MarshalIndent(timeline, "", "  ")
+		if err != nil {
+			fail("failed to marshal timeline: %v", err)
+		}
+		fmt.Println(string(out))
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tSOURCE\tOPERATION\tVOLUME\tINSTANCE\tUSER\tERROR")
+		for _, e := range timeline {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				e.Time.Format(time.RFC3339), e.Source, e.Operation,
+				e.VolumeID, e.InstanceID, e.User, e.Err)
+		}
+		w.Flush()
+	default:
+		fail("unknown format %q", *format)
+	}
+}
@@ -59,7 +59,10 @@ type Driver struct {
 	volume.CloudBackupDriver
 	volume.CloudMigrateDriver
 	ops storageops.Ops
-	md  *Metadata
+	// aliases resolves storageops.AliasPrefix prefixed aliases given in
+	// place of volume IDs, nil without a kvdb
+	aliases storageops.AliasOps
+	md      *Metadata
 }
 
 // Init aws volume driver metadata.
@@ -106,9 +109,26 @@ func Init(params map[string]string) (volume.VolumeDriver, error) {
 		CloudMigrateDriver: volume.CloudMigrateNotSupported,
 		StoreEnumerator:    common.NewDefaultStoreEnumerator(Name, kvdb.Instance()),
 	}
+	if kv := kvdb.Instance(); kv != nil {
+		// The alias index is persisted state, bring it to the current
+		// schema before it is used
+		if err := storageops.MigrateState(kv); err != nil {
+			return nil, err
+		}
+		d.aliases = storageops.NewAliasOps(d.ops, kv)
+	}
 	return d, nil
 }
 
+// resolve returns the volume ID of the given alias, so that aliases are
+// accepted wherever the CLI and REST API accept a volume ID
+func (d *Driver) resolve(volumeID string) (string, error) {
+	if d.aliases == nil {
+		return volumeID, nil
+	}
+	return d.aliases.Resolve(volumeID)
+}
+
 // authKeys return authentication keys for this instance.
 func authKeys(params map[string]string) (string, string, error) {
 	accessKey, err := getAuthKey(awsAccessKeyID, params)
@@ -201,6 +221,18 @@ func (d *Driver) Create(
 	source *api.Source,
 	spec *api.VolumeSpec,
 ) (string, error) {
+	// An alias label assigns the alias to the new volume
+	alias := locator.VolumeLabels[storageops.AliasLabel]
+	if len(alias) > 0 {
+		if d.aliases == nil {
+			return "", storageops.NewStorageError(storageops.ErrVolInval,
+				"volume aliases require a kvdb", "")
+		}
+		if id, err := d.aliases.Resolve(storageops.AliasPrefix + alias); err == nil {
+			return "", storageops.NewStorageError(storageops.ErrVolInval,
+				fmt.Sprintf("alias %q is already assigned to volume %s", alias, id), "")
+		}
+	}
 	var snapID *string
 	// Spec size is in bytes, translate to GiB.
 	sz := int64(spec.Size / (1024 * 1024 * 1024))
@@ -220,21 +252,14 @@ func (d *Driver) Create(
 	if *volType != opsworks.VolumeTypeGp2 {
 		ec2Vol.Iops = iops
 	}
-	resp, err := d.ops.Create(ec2Vol, locator.VolumeLabels)
+	vol, err := d.ops.Create(ec2Vol, locator.VolumeLabels)
 	if err != nil {
 		logrus.Warnf("Failed in CreateVolumeRequest :%v", err)
 		return "", err
 	}
 
-	vol, ok := resp.(*ec2.Volume)
-	if !ok {
-		return "", storageops.NewStorageError(storageops.ErrVolInval,
-			"Invalid volume returned by create API",
-			fmt.Sprintf("template snapshot: %s", *snapID))
-	}
-
 	volume := common.NewVolume(
-		*vol.VolumeId,
+		vol.ID,
 		api.FSType_FS_TYPE_EXT4,
 		locator,
 		source,
@@ -244,11 +269,24 @@ func (d *Driver) Create(
 	if err != nil {
 		return "", err
 	}
+	if len(alias) > 0 {
+		if err := d.aliases.SetAlias(volume.Id, alias); err != nil {
+			// The alias was checked above, but it may have been assigned
+			// since. Do not leak the volume created for it.
+			if derr := d.ops.Delete(vol.ID); derr != nil {
+				logrus.Warnf("failed to delete volume %s after failing to assign alias %q: %v",
+					vol.ID, alias, derr)
+			} else if derr := d.DeleteVol(volume.Id); derr != nil {
+				logrus.Warnf("failed to delete metadata of volume %s: %v", volume.Id, derr)
+			}
+			return "", err
+		}
+	}
 	if _, err := d.Attach(volume.Id, nil); err != nil {
 		return "", err
 	}
 
-	logrus.Infof("aws preparing volume %s...", *vol.VolumeId)
+	logrus.Infof("aws preparing volume %s...", vol.ID)
 	if err := d.Format(volume.Id); err != nil {
 		return "", err
 	}
@@ -260,12 +298,12 @@ func (d *Driver) Create(
 }
 
 // merge volume properties from aws into volume.
-func (d *Driver) merge(v *api.Volume, aws *ec2.Volume) {
+func (d *Driver) merge(v *api.Volume, aws *storageops.Volume) {
 	v.AttachedOn = ""
 	v.State = api.VolumeState_VOLUME_STATE_DETACHED
 	v.DevicePath = ""
 
-	switch *aws.State {
+	switch aws.State {
 	case ec2.VolumeStateAvailable:
 		v.Status = api.VolumeStatus_VOLUME_STATUS_UP
 	case ec2.VolumeStateCreating, ec2.VolumeStateDeleting:
@@ -279,23 +317,27 @@ func (d *Driver) merge(v *api.Volume, aws *ec2.Volume) {
 		v.Status = api.VolumeStatus_VOLUME_STATUS_DOWN
 	case ec2.VolumeStateInUse:
 		v.Status = api.VolumeStatus_VOLUME_STATUS_UP
-		if aws.Attachments != nil && len(aws.Attachments) != 0 {
-			if aws.Attachments[0].InstanceId != nil {
-				v.AttachedOn = *aws.Attachments[0].InstanceId
-			}
-			if aws.Attachments[0].State != nil {
+		if len(aws.Attachments) != 0 {
+			v.AttachedOn = aws.Attachments[0].InstanceID
+			if len(aws.Attachments[0].State) > 0 {
 				v.State = d.volumeState(aws.Attachments[0].State)
 			}
-			if aws.Attachments[0].Device != nil {
-				v.DevicePath = *aws.Attachments[0].Device
-			}
+			v.DevicePath = aws.Attachments[0].Device
 		}
 	}
 }
 
 // Inspect insepcts a volume
 func (d *Driver) Inspect(volumeIDs []string) ([]*api.Volume, error) {
-	vols, err := d.StoreEnumerator.Inspect(volumeIDs)
+	resolved := make([]string, len(volumeIDs))
+	for i, id := range volumeIDs {
+		volumeID, err := d.resolve(id)
+		if err != nil {
+			return nil, err
+		}
+		resolved[i] = volumeID
+	}
+	vols, err := d.StoreEnumerator.Inspect(resolved)
 	if err != nil {
 		return nil, err
 	}
@@ -315,14 +357,8 @@ func (d *Driver) Inspect(volumeIDs []string) ([]*api.Volume, error) {
 		if len(awsVols) != len(vols) {
 			return nil, fmt.Errorf("Inspect volume count mismatch")
 		}
-		for i, v := range awsVols {
-			vol, ok := v.(*ec2.Volume)
-			if !ok {
-				return nil, storageops.NewStorageError(storageops.ErrVolInval,
-					"Invalid volume returned by inspect API", "")
-			}
-
-			if string(vols[i].Id) != *vol.VolumeId {
+		for i, vol := range awsVols {
+			if string(vols[i].Id) != vol.ID {
 				d.merge(vols[i], vol)
 			}
 		}
@@ -331,7 +367,17 @@ func (d *Driver) Inspect(volumeIDs []string) ([]*api.Volume, error) {
 }
 
 func (d *Driver) Delete(volumeID string) error {
-	if err := d.ops.Delete(volumeID); err != nil {
+	volumeID, err := d.resolve(volumeID)
+	if err != nil {
+		return err
+	}
+	if d.aliases != nil {
+		// drops the aliases of the volume with it
+		err = d.aliases.Delete(volumeID)
+	} else {
+		err = d.ops.Delete(volumeID)
+	}
+	if err != nil {
 		return err
 	}
 	return d.DeleteVol(volumeID)
@@ -343,6 +389,10 @@ func (d *Driver) Snapshot(
 	locator *api.VolumeLocator,
 	noRetry bool,
 ) (string, error) {
+	volumeID, err := d.resolve(volumeID)
+	if err != nil {
+		return "", err
+	}
 	vols, err := d.StoreEnumerator.Inspect([]string{volumeID})
 	if err != nil {
 		return "", err
@@ -350,14 +400,13 @@ func (d *Driver) Snapshot(
 	if len(vols) != 1 {
 		return "", fmt.Errorf("Failed to inspect %v len %v", volumeID, len(vols))
 	}
-	resp, err := d.ops.Snapshot(volumeID, readonly)
+	snap, err := d.ops.Snapshot(volumeID, readonly)
 	if err != nil {
 		return "", err
 	}
 
-	snap := resp.(*ec2.Snapshot)
 	chaos.Now(koStrayCreate)
-	vols[0].Id = *snap.SnapshotId
+	vols[0].Id = snap.ID
 	vols[0].Source = &api.Source{Parent: volumeID}
 	vols[0].Locator = locator
 	vols[0].Ctime = prototime.Now()
@@ -383,11 +432,15 @@ func (d *Driver) Attach(
 	volumeID string,
 	attachOptions map[string]string,
 ) (string, error) {
+	volumeID, err := d.resolve(volumeID)
+	if err != nil {
+		return "", err
+	}
 	volume, err := d.GetVol(volumeID)
 	if err != nil {
 		return "", fmt.Errorf("Volume %s could not be located", volumeID)
 	}
-	path, err := d.ops.Attach(volumeID)
+	path, err := d.ops.Attach(volumeID, storageAttachOptions(attachOptions))
 	if err != nil {
 		return "", err
 	}
@@ -399,11 +452,28 @@ func (d *Driver) Attach(
 	return path, nil
 }
 
-func (d *Driver) volumeState(ec2VolState *string) api.VolumeState {
-	if ec2VolState == nil {
-		return api.VolumeState_VOLUME_STATE_DETACHED
+// storageAttachOptions returns the storageops attach options of the given
+// attach options, other options are meant for the volume driver layers and
+// are not passed to the cloud provider
+func storageAttachOptions(attachOptions map[string]string) map[string]string {
+	var options map[string]string
+	for _, k := range []string{
+		storageops.AttachOptionDevice,
+		storageops.AttachOptionReadOnly,
+		storageops.AttachOptionMultiAttach,
+	} {
+		if v, ok := attachOptions[k]; ok {
+			if options == nil {
+				options = make(map[string]string)
+			}
+			options[k] = v
+		}
 	}
-	switch *ec2VolState {
+	return options
+}
+
+func (d *Driver) volumeState(ec2VolState string) api.VolumeState {
+	switch ec2VolState {
 	case ec2.VolumeAttachmentStateAttached:
 		return api.VolumeState_VOLUME_STATE_ATTACHED
 	case ec2.VolumeAttachmentStateDetached:
@@ -417,6 +487,10 @@ func (d *Driver) volumeState(ec2VolState *string) api.VolumeState {
 }
 
 func (d *Driver) Format(volumeID string) error {
+	volumeID, err := d.resolve(volumeID)
+	if err != nil {
+		return err
+	}
 	volume, err := d.GetVol(volumeID)
 	if err != nil {
 		return fmt.Errorf("Failed to locate volume %q", volumeID)
@@ -431,14 +505,7 @@ func (d *Driver) Format(volumeID string) error {
 		return fmt.Errorf("Failed to inspect volume %v", volumeID)
 	}
 
-	awsVol, ok := awsVols[0].(*ec2.Volume)
-	if !ok {
-		return storageops.NewStorageError(storageops.ErrVolInval,
-			"Invalid volume returned by inspect API",
-			fmt.Sprintf("volume to inspect: %s", volumeID))
-	}
-
-	devicePath, err := d.ops.DevicePath(*awsVol.VolumeId)
+	devicePath, err := d.ops.DevicePath(awsVols[0].ID)
 	if err != nil {
 		return err
 	}
@@ -453,6 +520,10 @@ func (d *Driver) Format(volumeID string) error {
 }
 
 func (d *Driver) Detach(volumeID string, options map[string]string) error {
+	volumeID, err := d.resolve(volumeID)
+	if err != nil {
+		return err
+	}
 	if err := d.ops.Detach(volumeID); err != nil {
 		return err
 	}
@@ -462,7 +533,7 @@ func (d *Driver) Detach(volumeID string, options map[string]string) error {
 	} else {
 		volume.DevicePath = ""
 		if err := d.UpdateVol(volume); err != nil {
-			logrus.Warnf("Failed to update volume", volumeID)
+			logrus.Warnf("Failed to update volume %s", volumeID)
 		}
 	}
 	return nil
@@ -473,6 +544,10 @@ func (d *Driver) MountedAt(mountpath string) string {
 }
 
 func (d *Driver) Mount(volumeID string, mountpath string, options map[string]string) error {
+	volumeID, err := d.resolve(volumeID)
+	if err != nil {
+		return err
+	}
 	volume, err := d.GetVol(volumeID)
 	if err != nil {
 		return fmt.Errorf("Failed to locate volume %q", volumeID)
@@ -485,14 +560,7 @@ func (d *Driver) Mount(volumeID string, mountpath string, options map[string]str
 		return fmt.Errorf("Failed to inspect volume %v", volumeID)
 	}
 
-	awsVol, ok := awsVols[0].(*ec2.Volume)
-	if !ok {
-		return storageops.NewStorageError(storageops.ErrVolInval,
-			"Invalid volume returned by inspect API",
-			fmt.Sprintf("volume to inspect: %s", volumeID))
-	}
-
-	devicePath, err := d.ops.DevicePath(*awsVol.VolumeId)
+	devicePath, err := d.ops.DevicePath(awsVols[0].ID)
 	if err != nil {
 		return err
 	}
@@ -1,14 +1,20 @@
 package aws
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/opsworks"
 	"github.com/libopenstorage/openstorage/api"
+	"github.com/libopenstorage/openstorage/pkg/storageops"
 	"github.com/libopenstorage/openstorage/volume"
+	"github.com/libopenstorage/openstorage/volume/drivers/common"
 	"github.com/libopenstorage/openstorage/volume/drivers/test"
+	"github.com/portworx/kvdb"
+	"github.com/portworx/kvdb/mem"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 )
 
@@ -27,17 +33,15 @@ func testRemoveTags(t *testing.T, driver volume.VolumeDriver) {
 	for _, name := range labelNames {
 		labels[name] = name
 	}
-	resp, err := d.ops.Create(ec2Vol, labels)
+	vol, err := d.ops.Create(ec2Vol, labels)
 	require.Nil(t, err, "Failed in CreateVolumeRequest :%v", err)
-	vol, ok := resp.(*ec2.Volume)
-	require.True(t, ok, "invalid volume returned by create API")
-	defer d.ops.Delete(*vol.VolumeId)
+	defer d.ops.Delete(vol.ID)
 
-	tags, err := d.ops.Tags(*vol.VolumeId)
+	tags, err := d.ops.Tags(vol.ID)
 	require.Nil(t, err, "Failed to apply tags :%v", err)
 	require.True(t, len(tags) == len(labelNames), "ApplyTags failed")
-	require.Nil(t, d.ops.RemoveTags(*vol.VolumeId, labels), "RemoveTags error")
-	tags, err = d.ops.Tags(*vol.VolumeId)
+	require.Nil(t, d.ops.RemoveTags(vol.ID, labels), "RemoveTags error")
+	tags, err = d.ops.Tags(vol.ID)
 	require.Nil(t, err, "Failed to fetch tags :%v", err)
 	require.True(t, len(tags) == 0, "RemoveTags failed")
 }
@@ -82,3 +86,68 @@ func TestAll(t *testing.T) {
 	test.RunShort(t, ctx)
 	testRemoveTags(t, driver)
 }
+
+func TestStorageAttachOptions(t *testing.T) {
+	require.Nil(t, storageAttachOptions(nil))
+	require.Nil(t, storageAttachOptions(map[string]string{"secret": "s3cr3t"}))
+	require.Equal(t, map[string]string{
+		storageops.AttachOptionDevice:      "/dev/xvdg",
+		storageops.AttachOptionReadOnly:    "true",
+		storageops.AttachOptionMultiAttach: "false",
+	}, storageAttachOptions(map[string]string{
+		storageops.AttachOptionDevice:      "/dev/xvdg",
+		storageops.AttachOptionReadOnly:    "true",
+		storageops.AttachOptionMultiAttach: "false",
+		"secret":                           "s3cr3t",
+	}))
+}
+
+// fakeCreateOps creates volumes and records their deletion
+type fakeCreateOps struct {
+	storageops.Ops
+	deleted []string
+}
+
+func (f *fakeCreateOps) Create(template interface{}, labels map[string]string) (*storageops.Volume, error) {
+	return &storageops.Volume{ID: "vol-1", Labels: labels}, nil
+}
+
+func (f *fakeCreateOps) Delete(volumeID string) error {
+	f.deleted = append(f.deleted, volumeID)
+	return nil
+}
+
+// failingAliasOps fails to assign aliases, as if they were assigned
+// concurrently
+type failingAliasOps struct {
+	storageops.AliasOps
+}
+
+func (f *failingAliasOps) Resolve(idOrAlias string) (string, error) {
+	return "", storageops.NewStorageError(storageops.ErrVolNotFound, idOrAlias, "")
+}
+
+func (f *failingAliasOps) SetAlias(volumeID, alias string) error {
+	return fmt.Errorf("alias %q is already assigned", alias)
+}
+
+func TestCreateAliasFailure(t *testing.T) {
+	kv, err := kvdb.New(mem.Name, "aws-test", []string{}, nil, logrus.Panicf)
+	require.NoError(t, err)
+	ops := &fakeCreateOps{}
+	d := &Driver{
+		ops:             ops,
+		aliases:         &failingAliasOps{},
+		md:              &Metadata{zone: "us-east-1a"},
+		StoreEnumerator: common.NewDefaultStoreEnumerator(Name, kv),
+	}
+
+	_, err = d.Create(&api.VolumeLocator{
+		Name:         "db",
+		VolumeLabels: map[string]string{storageops.AliasLabel: "db"},
+	}, nil, &api.VolumeSpec{Size: 1 << 30})
+	require.Error(t, err)
+	require.Equal(t, []string{"vol-1"}, ops.deleted, "the volume must be deleted if the alias cannot be assigned")
+	_, err = d.GetVol("vol-1")
+	require.Error(t, err, "the volume metadata must be deleted")
+}